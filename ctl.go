@@ -0,0 +1,661 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	vswitch "vswitch/switch"
+)
+
+// ctlUsage describes the ctl subcommands
+const ctlUsage = `Usage: %s [-control-socket path] ctl <command> [arguments]
+
+Commands:
+  vlans                       List VLANs with their statistics
+  connections <port>          List the connections of a VLAN
+  mac-table [port]            List the learned MAC addresses of one or all VLANs
+  ips [port]                  List the IP addresses seen on one or all VLANs
+  whohas <address>            Show the VLANs, MACs, connections and VMs an IP
+                              address was seen on
+  kick <port> <connection>    Disconnect a connection from a VLAN
+  disable <port> [connection] Shut a VLAN or one of its connections down
+  enable <port> [connection]  Bring a VLAN or one of its connections back up
+  reset-stats [port [connection]]
+                              Zero the statistics of all VLANs, one VLAN or one
+                              connection
+  impair <port> [connection] <impairment>
+                              Delay, drop, duplicate or reorder the frames sent
+                              by a VLAN or to one connection, e.g.
+                              delay:50ms,jitter:10ms,loss:1%%; none removes it
+                              and vlan makes a connection follow its VLAN's
+  vids <port>                 List the broadcast domains of a VLAN
+                              demultiplexing 802.1Q tags
+  partitions <port>           List the active partitions of a VLAN
+  partition <port> <group> <group>... [for <duration>]
+                              Blackhole the traffic between groups of
+                              connections, each a comma-separated list of
+                              connection IDs, labels, client IDs, VM names or
+                              addresses, until healed or for the duration
+  heal <port> [partition]     Remove one or all partitions of a VLAN
+  bridges                     List the bridges between VLANs
+  bridge <port> <port> <rule>...
+                              Pass the frames a rule selects between two
+                              VLANs, e.g. dst=52:54:00:00:00:01 or
+                              ethertype=arp,direction=forward
+  unbridge <bridge>           Remove a bridge
+  capture <port>              Write the frames of a VLAN to stdout as pcap
+  replay <port> <file> [speed]
+                              Inject the frames of a pcap file into a VLAN, at
+                              their original timing, speed times faster, or as
+                              fast as possible with speed 0; - reads stdin
+  add-vlan <port>             Create and start a VLAN
+  remove-vlan <port>          Stop and remove a VLAN
+  export                      Write a snapshot of the VLANs, their settings,
+                              static MACs and peer labels to stdout as JSON
+  import <file>               Start the VLANs of a snapshot that do not exist
+                              and apply its state; - reads stdin
+  diff <file>                 List the actions apply would take
+  apply <file>                Reconcile the switch to the desired state of a
+                              snapshot, removing the VLANs not in it
+`
+
+// runCtl runs a ctl subcommand against the daemon's control socket and
+// returns the process exit code
+func runCtl(socket string, args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintf(os.Stderr, ctlUsage, os.Args[0])
+		return 2
+	}
+
+	command, args := args[0], args[1:]
+	// Minimum and maximum number of arguments of each command
+	arity := map[string][2]int{
+		"vlans":       {0, 0},
+		"connections": {1, 1},
+		"mac-table":   {0, 1},
+		"ips":         {0, 1},
+		"whohas":      {1, 1},
+		"kick":        {2, 2},
+		"disable":     {1, 2},
+		"enable":      {1, 2},
+		"reset-stats": {0, 2},
+		"impair":      {2, 3},
+		"vids":        {1, 1},
+		"partitions":  {1, 1},
+		"partition":   {3, 64},
+		"heal":        {1, 2},
+		"bridges":     {0, 0},
+		"bridge":      {3, 64},
+		"unbridge":    {1, 1},
+		"capture":     {1, 1},
+		"replay":      {2, 3},
+		"add-vlan":    {1, 1},
+		"remove-vlan": {1, 1},
+		"export":      {0, 0},
+		"import":      {1, 1},
+		"diff":        {1, 1},
+		"apply":       {1, 1},
+	}
+	expected, ok := arity[command]
+	if !ok || len(args) < expected[0] || len(args) > expected[1] {
+		fmt.Fprintf(os.Stderr, ctlUsage, os.Args[0])
+		return 2
+	}
+
+	var port int
+	if len(args) > 0 && command != "import" && command != "diff" && command != "apply" && command != "unbridge" && command != "whohas" {
+		var err error
+		if port, err = strconv.Atoi(args[0]); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid port: %s\n", args[0])
+			return 2
+		}
+	}
+
+	client := vswitch.NewControlClient(socket)
+	out := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+
+	var err error
+	switch command {
+	case "vlans":
+		err = ctlVLANs(client, out)
+	case "connections":
+		err = ctlConnections(client, out, port)
+	case "mac-table":
+		err = ctlMACTable(client, out, port, len(args) > 0)
+	case "ips":
+		err = ctlIPInventory(client, out, port, len(args) > 0)
+	case "whohas":
+		err = ctlWhoHas(client, out, args[0])
+	case "kick":
+		var flushed int
+		if flushed, err = client.Kick(port, args[1]); err == nil {
+			fmt.Printf("Disconnected %s from VLAN %d and flushed %d MAC entries\n", args[1], port, flushed)
+		}
+	case "disable", "enable":
+		err = ctlAdminState(client, port, args[1:], command == "disable")
+	case "reset-stats":
+		err = ctlResetStats(client, port, args)
+	case "impair":
+		err = ctlImpair(client, port, args[1:])
+	case "vids":
+		err = ctlTagDomains(client, out, port)
+	case "partitions":
+		err = ctlPartitions(client, out, port)
+	case "partition":
+		err = ctlPartition(client, port, args[1:])
+	case "heal":
+		err = ctlHeal(client, port, args[1:])
+	case "bridges":
+		err = ctlBridges(client, out)
+	case "bridge":
+		err = ctlBridge(client, port, args[1:])
+	case "unbridge":
+		var bridge vswitch.Bridge
+		if bridge, err = client.RemoveBridge(args[0]); err == nil {
+			fmt.Printf("Removed bridge %s between VLANs %d and %d\n", bridge.ID, bridge.VLANs[0], bridge.VLANs[1])
+		}
+	case "capture":
+		ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+		err = client.Capture(ctx, port, os.Stdout)
+		cancel()
+	case "replay":
+		err = ctlReplay(client, port, args[1:])
+	case "add-vlan":
+		if err = client.AddVLAN(port); err == nil {
+			fmt.Printf("Started VLAN on port %d\n", port)
+		}
+	case "remove-vlan":
+		if err = client.RemoveVLAN(port); err == nil {
+			fmt.Printf("Removed VLAN on port %d\n", port)
+		}
+	case "export":
+		err = ctlExport(client)
+	case "import":
+		err = ctlImport(client, args[0])
+	case "diff", "apply":
+		err = ctlApply(client, out, args[0], command == "diff")
+	}
+
+	if err == nil {
+		err = out.Flush()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// ctlVLANs prints each VLAN with its main counters
+func ctlVLANs(client *vswitch.ControlClient, out *tabwriter.Writer) error {
+	ports, err := client.VLANs()
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintln(out, "PORT\tADMIN\tCONNECTIONS\tMACS\tFRAMES\tDROPPED\tRX RATE")
+	for _, port := range ports {
+		stats, err := client.VLANStats(port)
+		if err != nil {
+			return err
+		}
+		bps, _ := stats["rx_bits_per_second"].(float64)
+		pps, _ := stats["rx_frames_per_second"].(float64)
+		fmt.Fprintf(out, "%d\t%s\t%v\t%v\t%v\t%v\t%s\n", port, adminState(stats["disabled"] == true), stats["connections"], stats["mac_entries"],
+			stats["total_frames"], stats["dropped_frames"], formatRate(vswitch.Rates{BitsPerSecond: bps, FramesPerSecond: pps}))
+	}
+	return nil
+}
+
+// ctlConnections prints the connections of a VLAN
+func ctlConnections(client *vswitch.ControlClient, out *tabwriter.Writer, port int) error {
+	connections, err := client.Connections(port)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintln(out, "ID\tREMOTE\tLABEL\tCLIENT\tVM\tADMIN\tMODE\tRX FRAMES\tTX FRAMES\tRX RATE\tTX RATE\tQUEUE\tQUEUE DROPS\tLAST SEEN")
+	for _, conn := range connections {
+		label := conn.Label
+		if label == "" {
+			label = "-"
+		}
+		clientID := conn.ClientID
+		if clientID == "" {
+			clientID = "-"
+		}
+		vm := conn.VMName
+		if conn.Interface != "" {
+			vm = strings.TrimPrefix(vm+"/"+conn.Interface, "/")
+		}
+		if vm == "" {
+			vm = "-"
+		}
+		fmt.Fprintf(out, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%d\t%d\t%s\t%s\t%d\t%d\t%s\n", conn.ID, conn.RemoteAddr, label, clientID, vm, adminState(conn.Disabled), conn.Mode,
+			conn.FramesReceived, conn.FramesSent, formatRate(conn.RxRates), formatRate(conn.TxRates), conn.QueueDepth, conn.QueueDrops, formatAge(conn.LastSeen))
+	}
+	return nil
+}
+
+// ctlMACTable prints the MAC table of a VLAN, or of all VLANs
+func ctlMACTable(client *vswitch.ControlClient, out *tabwriter.Writer, port int, single bool) error {
+	var entries []vswitch.MACTableEntry
+	var err error
+	if single {
+		entries, err = client.MACTable(port)
+	} else {
+		entries, err = client.AllMACTables()
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintln(out, "VLAN\tMAC\tVENDOR\tCONNECTION\tTYPE\tAGE")
+	for _, entry := range entries {
+		kind := "dynamic"
+		if entry.Static {
+			kind = "static"
+		}
+		vendor := entry.Vendor
+		if vendor == "" {
+			vendor = "-"
+		}
+		vlan := strconv.Itoa(entry.VLAN)
+		if entry.VID != 0 {
+			vlan += "." + strconv.Itoa(entry.VID)
+		}
+		age := time.Duration(entry.AgeSeconds) * time.Second
+		fmt.Fprintf(out, "%s\t%s\t%s\t%s\t%s\t%s\n", vlan, entry.MAC, vendor, entry.ConnectionID, kind, age)
+	}
+	return nil
+}
+
+// ctlIPInventory prints the IP addresses seen on a VLAN, or on all VLANs
+func ctlIPInventory(client *vswitch.ControlClient, out *tabwriter.Writer, port int, single bool) error {
+	var entries []vswitch.IPInventoryEntry
+	var err error
+	if single {
+		entries, err = client.IPInventory(port)
+	} else {
+		entries, err = client.AllIPInventories()
+	}
+	if err != nil {
+		return err
+	}
+	printIPInventory(out, entries)
+	return nil
+}
+
+// ctlWhoHas prints where an IP address was seen
+func ctlWhoHas(client *vswitch.ControlClient, out *tabwriter.Writer, address string) error {
+	entries, err := client.FindIP(address)
+	if err != nil {
+		return err
+	}
+	printIPInventory(out, entries)
+	return nil
+}
+
+// printIPInventory prints IP inventory entries with the connection and VM
+// they were seen on
+func printIPInventory(out *tabwriter.Writer, entries []vswitch.IPInventoryEntry) {
+	fmt.Fprintln(out, "VLAN\tIP\tMAC\tVENDOR\tCONNECTION\tLABEL\tVM\tSOURCE\tLAST SEEN")
+	for _, entry := range entries {
+		fmt.Fprintf(out, "%d\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", entry.VLAN, entry.IP, entry.MAC, orDash(entry.Vendor), entry.ConnectionID,
+			orDash(entry.Label), orDash(entry.VMName), entry.Source, formatAge(entry.LastSeen))
+	}
+}
+
+// ctlAdminState disables or enables a VLAN, or the connection given in args
+func ctlAdminState(client *vswitch.ControlClient, port int, args []string, disabled bool) error {
+	if len(args) == 0 {
+		if err := client.SetVLANDisabled(port, disabled); err != nil {
+			return err
+		}
+		fmt.Printf("VLAN %d is %s\n", port, adminState(disabled))
+		return nil
+	}
+
+	if err := client.SetConnectionDisabled(port, args[0], disabled); err != nil {
+		return err
+	}
+	fmt.Printf("Connection %s on VLAN %d is %s\n", args[0], port, adminState(disabled))
+	return nil
+}
+
+// ctlResetStats zeroes the statistics of all VLANs, or of the VLAN or
+// connection given in args
+func ctlResetStats(client *vswitch.ControlClient, port int, args []string) error {
+	var resetAt time.Time
+	var err error
+	var what string
+	switch len(args) {
+	case 0:
+		resetAt, err = client.ResetStats()
+		what = "all VLANs"
+	case 1:
+		resetAt, err = client.ResetVLANStats(port)
+		what = fmt.Sprintf("VLAN %d", port)
+	default:
+		resetAt, err = client.ResetConnectionStats(port, args[1])
+		what = fmt.Sprintf("connection %s on VLAN %d", args[1], port)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Reset the statistics of %s at %s\n", what, resetAt.Format(time.RFC3339))
+	return nil
+}
+
+// ctlImpair sets the impairment of a VLAN, or of the connection given in
+// args before it
+func ctlImpair(client *vswitch.ControlClient, port int, args []string) error {
+	spec := args[len(args)-1]
+	if len(args) == 2 && spec == "vlan" {
+		if err := client.SetConnectionImpairment(port, args[0], nil); err != nil {
+			return err
+		}
+		fmt.Printf("Connection %s on VLAN %d follows its VLAN's impairment\n", args[0], port)
+		return nil
+	}
+
+	var impairment vswitch.Impairment
+	if spec != "none" {
+		var err error
+		if impairment, err = vswitch.ParseImpairment(spec); err != nil {
+			return err
+		}
+	}
+	if len(args) == 1 {
+		if err := client.SetVLANImpairment(port, impairment); err != nil {
+			return err
+		}
+		fmt.Printf("Impairment of VLAN %d: %s\n", port, impairment)
+		return nil
+	}
+	if err := client.SetConnectionImpairment(port, args[0], &impairment); err != nil {
+		return err
+	}
+	fmt.Printf("Impairment of connection %s on VLAN %d: %s\n", args[0], port, impairment)
+	return nil
+}
+
+// ctlTagDomains prints the broadcast domains of a VLAN demultiplexing tags
+func ctlTagDomains(client *vswitch.ControlClient, out *tabwriter.Writer, port int) error {
+	domains, err := client.TagDomains(port)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintln(out, "VID\tCONNECTIONS\tMACS\tFRAMES")
+	for _, d := range domains {
+		vid := strconv.Itoa(d.VID)
+		if d.Native {
+			vid += " (native)"
+		}
+		fmt.Fprintf(out, "%s\t%d\t%d\t%d\n", vid, d.Connections, d.MACs, d.Frames)
+	}
+	return nil
+}
+
+// ctlPartitions prints the active partitions of a VLAN
+func ctlPartitions(client *vswitch.ControlClient, out *tabwriter.Writer, port int) error {
+	partitions, err := client.Partitions(port)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintln(out, "ID\tGROUPS\tDROPPED\tEXPIRES")
+	for _, p := range partitions {
+		expires := "never"
+		if p.Expires != nil {
+			expires = time.Until(*p.Expires).Round(time.Second).String()
+		}
+		fmt.Fprintf(out, "%s\t%s\t%d\t%s\n", p.ID, formatGroups(p.Groups), p.Dropped, expires)
+	}
+	return nil
+}
+
+// ctlPartition partitions a VLAN between the groups in args, for the
+// duration following "for" at their end if there is one
+func ctlPartition(client *vswitch.ControlClient, port int, args []string) error {
+	var duration time.Duration
+	if len(args) >= 2 && args[len(args)-2] == "for" {
+		var err error
+		if duration, err = time.ParseDuration(args[len(args)-1]); err != nil || duration <= 0 {
+			return fmt.Errorf("invalid partition duration '%s'", args[len(args)-1])
+		}
+		args = args[:len(args)-2]
+	}
+
+	groups := make([][]string, len(args))
+	for i, arg := range args {
+		groups[i] = strings.Split(arg, ",")
+	}
+	partition, err := client.Partition(port, groups, duration)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Partitioned VLAN %d as %s: %s\n", port, partition.ID, formatGroups(partition.Groups))
+	return nil
+}
+
+// ctlHeal removes the partition given in args from a VLAN, or all of them
+func ctlHeal(client *vswitch.ControlClient, port int, args []string) error {
+	id := ""
+	if len(args) > 0 {
+		id = args[0]
+	}
+	healed, err := client.Heal(port, id)
+	if err != nil {
+		return err
+	}
+	for _, p := range healed {
+		fmt.Printf("Healed partition %s of VLAN %d after dropping %d frames\n", p.ID, port, p.Dropped)
+	}
+	return nil
+}
+
+// formatGroups formats the groups of a partition as comma-separated
+// members with the groups separated by " | "
+func formatGroups(groups [][]string) string {
+	formatted := make([]string, len(groups))
+	for i, group := range groups {
+		formatted[i] = strings.Join(group, ",")
+	}
+	return strings.Join(formatted, " | ")
+}
+
+// ctlBridges prints the bridges between VLANs
+func ctlBridges(client *vswitch.ControlClient, out *tabwriter.Writer) error {
+	bridges, err := client.Bridges()
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintln(out, "ID\tVLANS\tRULES\tFORWARDED\tFILTERED\tDROPPED")
+	for _, bridge := range bridges {
+		rules := make([]string, len(bridge.Rules))
+		for i, rule := range bridge.Rules {
+			rules[i] = rule.String()
+		}
+		fmt.Fprintf(out, "%s\t%d-%d\t%s\t%d\t%d\t%d\n", bridge.ID, bridge.VLANs[0], bridge.VLANs[1], strings.Join(rules, " "),
+			bridge.Forwarded, bridge.Filtered, bridge.Dropped)
+	}
+	return nil
+}
+
+// ctlBridge bridges a VLAN to the one given in args before the rules
+func ctlBridge(client *vswitch.ControlClient, port int, args []string) error {
+	peer, err := strconv.Atoi(args[0])
+	if err != nil {
+		return fmt.Errorf("invalid port: %s", args[0])
+	}
+	rules := make([]vswitch.BridgeRule, len(args)-1)
+	for i, spec := range args[1:] {
+		if rules[i], err = vswitch.ParseBridgeRule(spec); err != nil {
+			return err
+		}
+	}
+
+	bridge, err := client.AddBridge([2]int{port, peer}, rules)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Bridged VLANs %d and %d as %s\n", port, peer, bridge.ID)
+	return nil
+}
+
+// ctlExport writes the snapshot of the switch to stdout
+func ctlExport(client *vswitch.ControlClient) error {
+	snapshot, err := client.Export()
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(snapshot)
+}
+
+// readSnapshot reads a snapshot from a file, or stdin for -
+func readSnapshot(path string) (vswitch.Snapshot, error) {
+	var snapshot vswitch.Snapshot
+	input := os.Stdin
+	if path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			return snapshot, err
+		}
+		defer func() { _ = file.Close() }()
+		input = file
+	}
+
+	decoder := json.NewDecoder(input)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&snapshot); err != nil {
+		return snapshot, fmt.Errorf("invalid snapshot: %v", err)
+	}
+	return snapshot, nil
+}
+
+// ctlImport imports a snapshot written by export
+func ctlImport(client *vswitch.ControlClient, path string) error {
+	snapshot, err := readSnapshot(path)
+	if err != nil {
+		return err
+	}
+
+	result, err := client.Import(snapshot)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Imported %d VLANs: created %v, updated %v\n", len(snapshot.VLANs), result.Created, result.Updated)
+	return nil
+}
+
+// ctlApply reconciles the switch to a desired state and prints the actions
+// taken, or with dryRun those it would take
+func ctlApply(client *vswitch.ControlClient, out *tabwriter.Writer, path string, dryRun bool) error {
+	desired, err := readSnapshot(path)
+	if err != nil {
+		return err
+	}
+
+	actions, err := client.Reconcile(desired, dryRun)
+	if err != nil {
+		return err
+	}
+	if len(actions) == 0 {
+		fmt.Println("The switch is in the desired state")
+		return nil
+	}
+	fmt.Fprintln(out, "VLAN\tACTION\tDETAIL")
+	for _, action := range actions {
+		fmt.Fprintf(out, "%d\t%s\t%s\n", action.VLAN, action.Action, action.Detail)
+	}
+	return nil
+}
+
+// ctlReplay injects a pcap file into a VLAN, interrupted with Ctrl+C
+func ctlReplay(client *vswitch.ControlClient, port int, args []string) error {
+	speed := 1.0
+	if len(args) > 1 {
+		var err error
+		if speed, err = strconv.ParseFloat(args[1], 64); err != nil || speed < 0 {
+			return fmt.Errorf("invalid speed: %s", args[1])
+		}
+	}
+
+	input := os.Stdin
+	if args[0] != "-" {
+		file, err := os.Open(args[0])
+		if err != nil {
+			return err
+		}
+		defer func() { _ = file.Close() }()
+		input = file
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+	result, err := client.Replay(ctx, port, input, speed)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Replayed %d frames (%d bytes) into VLAN %d as %s in %.1fs",
+		result.Frames, result.Bytes, port, result.Connection, result.Seconds)
+	if result.Skipped > 0 {
+		fmt.Printf(", skipped %d", result.Skipped)
+	}
+	fmt.Println()
+	return nil
+}
+
+// adminState names an admin state the way switches show it
+func adminState(disabled bool) string {
+	if disabled {
+		return "down"
+	}
+	return "up"
+}
+
+// formatRate formats a throughput with an SI prefix, e.g. 12.5 Mbit/s 1.1 kpps
+func formatRate(rates vswitch.Rates) string {
+	return siPrefix(rates.BitsPerSecond) + "bit/s " + siPrefix(rates.FramesPerSecond) + "pps"
+}
+
+// siPrefix formats a value with one decimal and the SI prefix keeping it
+// below 1000, followed by a space
+func siPrefix(value float64) string {
+	for _, prefix := range []string{"", "k", "M", "G"} {
+		if value < 1000 || prefix == "G" {
+			return strconv.FormatFloat(value, 'f', 1, 64) + " " + prefix
+		}
+		value /= 1000
+	}
+	return ""
+}
+
+// formatAge formats the time since t, rounded to seconds
+func formatAge(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return time.Since(t).Round(time.Second).String()
+}
+
+// orDash returns a value, or a dash for an empty one
+func orDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
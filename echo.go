@@ -0,0 +1,298 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"math"
+	"net"
+	"os"
+	"os/signal"
+	"time"
+
+	vswitch "vswitch/switch"
+)
+
+// echoMagic starts the payload of echo frames, telling them apart from
+// other traffic of the experimental EtherType
+const echoMagic = "vswitch-echo"
+
+// Operations of echo frames
+const (
+	echoRequest  byte = 1
+	echoReply    byte = 2
+	echoAnnounce byte = 3
+)
+
+// Offsets of the fields of an echo frame; the size of a minimal frame
+// leaves room for them
+const (
+	echoOpOffset   = 14 + len(echoMagic)
+	echoSeqOffset  = echoOpOffset + 1
+	echoSentOffset = echoSeqOffset + 8
+	echoMinSize    = 60
+)
+
+// echoUsage describes the echo subcommand
+const echoUsage = `Usage: %s echo [-mac address] [-psk key] <host:port>
+       %s echo -ping [-psk key] [-to address] [-count n] [-interval duration] [-size bytes] [-wait duration] <host:port>
+
+Without -ping, connects to a VLAN and answers the echo probes sent to it.
+With -ping, sends probes through the VLAN and reports the round-trip time
+and loss of the replies of every responder.
+
+Options:
+`
+
+// echoFrame builds an echo frame with the given operation, sequence number
+// and send time, padded to size bytes
+func echoFrame(dst, src net.HardwareAddr, op byte, seq uint64, sent time.Duration, size int) []byte {
+	frame := make([]byte, max(size, echoMinSize))
+	copy(frame[0:6], dst)
+	copy(frame[6:12], src)
+	binary.BigEndian.PutUint16(frame[12:14], experimentalEtherType)
+	copy(frame[14:], echoMagic)
+	frame[echoOpOffset] = op
+	binary.BigEndian.PutUint64(frame[echoSeqOffset:], seq)
+	binary.BigEndian.PutUint64(frame[echoSentOffset:], uint64(sent))
+	return frame
+}
+
+// parseEchoFrame returns the operation of an echo frame, or false if the
+// frame is not one
+func parseEchoFrame(frame []byte) (byte, bool) {
+	if len(frame) < echoSentOffset+8 ||
+		binary.BigEndian.Uint16(frame[12:14]) != experimentalEtherType ||
+		!bytes.Equal(frame[14:echoOpOffset], []byte(echoMagic)) {
+		return 0, false
+	}
+	return frame[echoOpOffset], true
+}
+
+// runEcho runs the echo subcommand, answering or sending probes through
+// a remote VLAN so two hosts can measure the latency and loss of the path
+// between them through the switch. It returns the process exit code.
+func runEcho(args []string) int {
+	flags := flag.NewFlagSet("echo", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, echoUsage, os.Args[0], os.Args[0])
+		flags.PrintDefaults()
+	}
+	macAddress := flags.String("mac", "", "MAC address to use (default random)")
+	ping := flags.Bool("ping", false, "Send probes instead of answering them")
+	to := flags.String("to", "", "MAC address of the responder to probe (default broadcast)")
+	count := flags.Int("count", 10, "Number of probes to send, 0 until interrupted")
+	interval := flags.Duration("interval", time.Second, "Time between probes")
+	size := flags.Int("size", echoMinSize, "Size of the probes in bytes, without the checksum")
+	wait := flags.Duration("wait", 2*time.Second, "Time to wait for replies after the last probe")
+	psk := flags.String("psk", "", "Pre-shared key of the VLAN, if it has one")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 || *count < 0 || *interval <= 0 || *size < echoMinSize || *size > vswitch.DefaultMTU+14 {
+		flags.Usage()
+		return 2
+	}
+
+	mac, err := vswitch.RandomMAC()
+	if *macAddress != "" {
+		mac, err = net.ParseMAC(*macAddress)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid MAC address: %v\n", err)
+		return 2
+	}
+	dst := vswitch.BroadcastMAC
+	if *to != "" {
+		if dst, err = net.ParseMAC(*to); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid MAC address: %v\n", err)
+			return 2
+		}
+	}
+
+	client, err := dialFrameClient("echo client", flags.Arg(0), mac)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	defer func() { _ = client.conn.Close() }()
+	if *psk != "" {
+		if err := vswitch.Authenticate(client.conn, *psk); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to authenticate: %v\n", err)
+			return 1
+		}
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+	go func() {
+		<-ctx.Done()
+		_ = client.conn.Close()
+	}()
+
+	if *ping {
+		return runEchoPing(ctx, client, dst, *count, *interval, *size, *wait)
+	}
+	return runEchoResponder(ctx, client)
+}
+
+// runEchoResponder answers the probes addressed to the client or
+// broadcast until ctx is done
+func runEchoResponder(ctx context.Context, client *frameClient) int {
+	// Let the switch learn the responder, so probes to it are not flooded
+	if err := client.send(echoFrame(vswitch.BroadcastMAC, client.mac, echoAnnounce, 0, 0, echoMinSize)); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to announce responder: %v\n", err)
+		return 1
+	}
+	fmt.Printf("Answering echo probes on %s as %s\n", client.conn.RemoteAddr(), client.mac)
+
+	answered := 0
+	buf := make([]byte, maxFrameSize)
+	for {
+		frame, err := client.receive(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				fmt.Printf("Answered %d probes\n", answered)
+				return 0
+			}
+			fmt.Fprintf(os.Stderr, "Connection to the VLAN lost: %v\n", err)
+			return 1
+		}
+
+		op, ok := parseEchoFrame(frame)
+		if !ok || op != echoRequest || !(bytes.Equal(frame[0:6], client.mac) || bytes.Equal(frame[0:6], vswitch.BroadcastMAC)) {
+			continue
+		}
+
+		// The reply carries the probe's payload back to its sender
+		reply := bytes.Clone(frame)
+		copy(reply[0:6], frame[6:12])
+		copy(reply[6:12], client.mac)
+		reply[echoOpOffset] = echoReply
+		if err := client.send(reply); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to send reply: %v\n", err)
+			return 1
+		}
+		answered++
+	}
+}
+
+// echoReplies accumulates the replies of one responder
+type echoReplies struct {
+	answered   map[uint64]bool
+	duplicates int
+	rtts       []time.Duration
+}
+
+// report prints the loss and round-trip times of the replies to sent probes
+func (r *echoReplies) report(sent int) {
+	loss := 0.0
+	if sent > 0 {
+		loss = 100 * float64(sent-len(r.answered)) / float64(sent)
+	}
+	fmt.Printf("%d probes sent, %d answered, %.1f%% loss", sent, len(r.answered), loss)
+	if r.duplicates > 0 {
+		fmt.Printf(", %d duplicate replies", r.duplicates)
+	}
+	fmt.Println()
+
+	minRTT, maxRTT := r.rtts[0], r.rtts[0]
+	var sum, sumSquares float64
+	for _, rtt := range r.rtts {
+		minRTT, maxRTT = min(minRTT, rtt), max(maxRTT, rtt)
+		sum += float64(rtt)
+		sumSquares += float64(rtt) * float64(rtt)
+	}
+	mean := sum / float64(len(r.rtts))
+	deviation := math.Sqrt(max(sumSquares/float64(len(r.rtts))-mean*mean, 0))
+	fmt.Printf("rtt min/avg/max/mdev = %v/%v/%v/%v\n",
+		minRTT, time.Duration(mean).Round(time.Microsecond), maxRTT, time.Duration(deviation).Round(time.Microsecond))
+}
+
+// runEchoPing sends count probes to dst, printing each reply, and reports
+// the loss and round-trip times of every responder. It returns 0 if any
+// probe was answered.
+func runEchoPing(ctx context.Context, client *frameClient, dst net.HardwareAddr, count int, interval time.Duration, size int, wait time.Duration) int {
+	start := time.Now()
+	// Responders in the order they first replied, and their replies
+	var responders []string
+	replies := make(map[string]*echoReplies)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		buf := make([]byte, maxFrameSize)
+		for {
+			frame, err := client.receive(buf)
+			if err != nil {
+				return
+			}
+			op, ok := parseEchoFrame(frame)
+			if !ok || op != echoReply || !bytes.Equal(frame[0:6], client.mac) {
+				continue
+			}
+
+			seq := binary.BigEndian.Uint64(frame[echoSeqOffset:])
+			rtt := time.Since(start) - time.Duration(binary.BigEndian.Uint64(frame[echoSentOffset:]))
+			responder := net.HardwareAddr(frame[6:12]).String()
+			r, found := replies[responder]
+			if !found {
+				r = &echoReplies{answered: make(map[uint64]bool)}
+				replies[responder] = r
+				responders = append(responders, responder)
+			}
+			if r.answered[seq] {
+				r.duplicates++
+			}
+			r.answered[seq] = true
+			r.rtts = append(r.rtts, rtt)
+			fmt.Printf("%d bytes from %s: seq=%d time=%v\n", len(frame), responder, seq, rtt)
+		}
+	}()
+
+	fmt.Printf("Probing %s through %s from %s\n", dst, client.conn.RemoteAddr(), client.mac)
+
+	sent := 0
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+probes:
+	for {
+		if err := client.send(echoFrame(dst, client.mac, echoRequest, uint64(sent), time.Since(start), size)); err != nil {
+			if ctx.Err() == nil {
+				fmt.Fprintf(os.Stderr, "Failed to send probe: %v\n", err)
+			}
+			break
+		}
+		sent++
+		if sent == count {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			break probes
+		case <-ticker.C:
+		}
+	}
+
+	// Replies to the last probes are still on their way
+	select {
+	case <-ctx.Done():
+	case <-done:
+	case <-time.After(wait):
+	}
+	_ = client.conn.Close()
+	<-done
+
+	if len(responders) == 0 {
+		fmt.Printf("\n%d probes sent, no replies\n", sent)
+		return 1
+	}
+	for _, responder := range responders {
+		fmt.Printf("\n--- %s ---\n", responder)
+		replies[responder].report(sent)
+	}
+	return 0
+}
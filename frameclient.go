@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+const (
+	// experimentalEtherType is the IEEE local experimental EtherType of
+	// the frames the selftest and echo subcommands send
+	experimentalEtherType = 0x88b5
+
+	// maxFrameSize is the size of the largest jumbo frame a VLAN forwards
+	maxFrameSize = 9216
+)
+
+// frameClient is a client of a VLAN, speaking the QEMU socket protocol
+// over TCP
+type frameClient struct {
+	name   string
+	mac    net.HardwareAddr
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// dialFrameClient connects a client with the given MAC address to the VLAN
+// at address
+func dialFrameClient(name, address string, mac net.HardwareAddr) (*frameClient, error) {
+	conn, err := net.DialTimeout("tcp", address, 5*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect %s: %v", name, err)
+	}
+	return &frameClient{
+		name:   name,
+		mac:    mac,
+		conn:   conn,
+		reader: bufio.NewReaderSize(conn, 64*1024),
+	}, nil
+}
+
+// send writes a length-prefixed frame
+func (c *frameClient) send(frame []byte) error {
+	data := make([]byte, 4+len(frame))
+	binary.BigEndian.PutUint32(data, uint32(len(frame)))
+	copy(data[4:], frame)
+	_, err := c.conn.Write(data)
+	return err
+}
+
+// receive reads a length-prefixed frame into buf, which must be large
+// enough for the largest frame the switch sends
+func (c *frameClient) receive(buf []byte) ([]byte, error) {
+	var length [4]byte
+	if _, err := io.ReadFull(c.reader, length[:]); err != nil {
+		return nil, err
+	}
+	size := int(binary.BigEndian.Uint32(length[:]))
+	if size > len(buf) {
+		return nil, fmt.Errorf("frame of %d bytes exceeds %d", size, len(buf))
+	}
+	if _, err := io.ReadFull(c.reader, buf[:size]); err != nil {
+		return nil, err
+	}
+	return buf[:size], nil
+}
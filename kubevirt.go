@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	// kubevirtHook is the name KubeVirt's sidecar shim runs a domain hook
+	// under; link it to the vswitch binary in the sidecar image
+	kubevirtHook = "onDefineDomain"
+
+	// kubevirtAnnotation prefixes the VMI annotations that name the VLAN
+	// address of an interface, e.g. vswitch/lab: vswitch.default.svc:9999
+	kubevirtAnnotation = "vswitch/"
+
+	// kubevirtAliasPrefix marks the user-defined device aliases KubeVirt
+	// matches interfaces by
+	kubevirtAliasPrefix = "ua-"
+)
+
+// kubevirtVMI holds the fields of a VirtualMachineInstance the hook uses
+type kubevirtVMI struct {
+	Metadata struct {
+		Name        string            `json:"name"`
+		Namespace   string            `json:"namespace"`
+		Annotations map[string]string `json:"annotations"`
+	} `json:"metadata"`
+	Spec struct {
+		Domain struct {
+			Devices struct {
+				Interfaces []struct {
+					Name       string `json:"name"`
+					MacAddress string `json:"macAddress"`
+					Binding    *struct {
+						Name string `json:"name"`
+					} `json:"binding"`
+				} `json:"interfaces"`
+			} `json:"devices"`
+		} `json:"domain"`
+	} `json:"spec"`
+}
+
+// domainInterface is a libvirt interface connecting to a vswitch VLAN the
+// way "-netdev socket,connect=" does
+type domainInterface struct {
+	XMLName xml.Name `xml:"interface"`
+	Type    string   `xml:"type,attr"`
+	MAC     *struct {
+		Address string `xml:"address,attr"`
+	} `xml:"mac,omitempty"`
+	Source struct {
+		Address string `xml:"address,attr"`
+		Port    string `xml:"port,attr"`
+	} `xml:"source"`
+	Model struct {
+		Type string `xml:"type,attr"`
+	} `xml:"model"`
+	Alias struct {
+		Name string `xml:"name,attr"`
+	} `xml:"alias"`
+}
+
+// runKubeVirtHook implements KubeVirt's onDefineDomain hook for the vswitch
+// network binding plugin. It adds an interface connecting to a VLAN for
+// each VMI interface bound to the plugin, and with VSWITCH_API set waits
+// for the switch to be ready and labels the VM's connections with its
+// name. The modified domain XML is written to stdout.
+func runKubeVirtHook(args []string) int {
+	flags := flag.NewFlagSet(kubevirtHook, flag.ContinueOnError)
+	vmiJSON := flags.String("vmi", "", "VirtualMachineInstance as JSON")
+	domainXML := flags.String("domain", "", "libvirt domain XML")
+	flags.String("version", "", "hook API version")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	domain, err := kubevirtDefineDomain(*vmiJSON, *domainXML)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "vswitch: %v\n", err)
+		return 1
+	}
+	fmt.Print(domain)
+	return 0
+}
+
+// kubevirtDefineDomain returns the domain XML with the VMI's vswitch
+// interfaces added
+func kubevirtDefineDomain(vmiJSON, domain string) (string, error) {
+	var vmi kubevirtVMI
+	if err := json.Unmarshal([]byte(vmiJSON), &vmi); err != nil {
+		return "", fmt.Errorf("invalid VMI: %v", err)
+	}
+
+	binding := getEnvOrDefault("VSWITCH_BINDING", "vswitch")
+	label := vmi.Metadata.Namespace + "/" + vmi.Metadata.Name
+
+	var devices strings.Builder
+	for _, iface := range vmi.Spec.Domain.Devices.Interfaces {
+		if iface.Binding == nil || iface.Binding.Name != binding {
+			continue
+		}
+
+		address := vmi.Metadata.Annotations[kubevirtAnnotation+iface.Name]
+		if address == "" {
+			address = os.Getenv("VSWITCH_ADDRESS")
+		}
+		host, port, err := net.SplitHostPort(address)
+		if err != nil {
+			return "", fmt.Errorf("interface %s: expected the VLAN as host:port in annotation %s%s or VSWITCH_ADDRESS, got %q",
+				iface.Name, kubevirtAnnotation, iface.Name, address)
+		}
+
+		if api := os.Getenv("VSWITCH_API"); api != "" {
+			if err := kubevirtRegister(api, port, label); err != nil {
+				return "", fmt.Errorf("interface %s: %v", iface.Name, err)
+			}
+		}
+
+		device := domainInterface{Type: "client"}
+		if iface.MacAddress != "" {
+			device.MAC = &struct {
+				Address string `xml:"address,attr"`
+			}{iface.MacAddress}
+		}
+		device.Source.Address = host
+		device.Source.Port = port
+		device.Model.Type = "virtio-non-transitional"
+		device.Alias.Name = kubevirtAliasPrefix + iface.Name
+
+		encoded, err := xml.MarshalIndent(device, "    ", "  ")
+		if err != nil {
+			return "", err
+		}
+		devices.Write(encoded)
+		devices.WriteString("\n")
+	}
+
+	if devices.Len() == 0 {
+		return domain, nil
+	}
+	end := strings.LastIndex(domain, "</devices>")
+	if end < 0 {
+		return "", fmt.Errorf("domain has no devices element")
+	}
+	// Keep the indentation of the closing tag
+	if start := strings.LastIndex(domain[:end], "\n") + 1; strings.TrimSpace(domain[start:end]) == "" {
+		end = start
+	}
+	return domain[:end] + devices.String() + domain[end:], nil
+}
+
+// kubevirtRegister waits until the switch is ready, then labels the
+// connections from this pod on the VLAN at port with the VM's name, so
+// that GET /vlans/{port}/peers reports when the VM is connected
+func kubevirtRegister(api, port, label string) error {
+	api = strings.TrimSuffix(api, "/")
+	client := &http.Client{Timeout: 5 * time.Second}
+
+	timeout, err := time.ParseDuration(getEnvOrDefault("VSWITCH_READY_TIMEOUT", "30s"))
+	if err != nil {
+		return fmt.Errorf("invalid VSWITCH_READY_TIMEOUT: %v", err)
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		response, err := kubevirtRequest(client, http.MethodGet, api+"/readyz", nil)
+		if err == nil {
+			_ = response.Body.Close()
+			if response.StatusCode == http.StatusOK {
+				break
+			}
+			err = fmt.Errorf("%s", response.Status)
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("switch not ready: %v", err)
+		}
+		time.Sleep(time.Second)
+	}
+
+	podIP, err := kubevirtPodIP()
+	if err != nil {
+		return err
+	}
+	body, _ := json.Marshal(map[string]string{"label": label})
+	response, err := kubevirtRequest(client, http.MethodPut, api+"/vlans/"+port+"/peers/"+podIP.String(), body)
+	if err != nil {
+		return fmt.Errorf("failed to label connection: %v", err)
+	}
+	defer func() { _ = response.Body.Close() }()
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to label connection: %s", response.Status)
+	}
+	return nil
+}
+
+// kubevirtRequest sends an API request, authenticated with VSWITCH_API_TOKEN
+func kubevirtRequest(client *http.Client, method, url string, body []byte) (*http.Response, error) {
+	request, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if token := os.Getenv("VSWITCH_API_TOKEN"); token != "" {
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+	return client.Do(request)
+}
+
+// kubevirtPodIP returns the address the VM's connections come from: the
+// pod's, whose network namespace the hook sidecar shares, unless
+// VSWITCH_POD_IP names it
+func kubevirtPodIP() (net.IP, error) {
+	if value := os.Getenv("VSWITCH_POD_IP"); value != "" {
+		if ip := net.ParseIP(value); ip != nil {
+			return ip, nil
+		}
+		return nil, fmt.Errorf("invalid VSWITCH_POD_IP %q", value)
+	}
+
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil, err
+	}
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.IsGlobalUnicast() {
+			return ipNet.IP, nil
+		}
+	}
+	return nil, fmt.Errorf("no pod address found")
+}
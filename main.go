@@ -2,19 +2,37 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"flag"
 	"fmt"
-	"log"
-	"log/syslog"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"runtime"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
 	"syscall"
 	"time"
 
 	vswitch "vswitch/switch"
+
+	"google.golang.org/grpc"
+)
+
+// Default paths of an unnamed instance; named instances get their own
+var (
+	defaultPIDFile       = filepath.Join(runtimeDir, "vswitch.pid")
+	defaultControlSocket = filepath.Join(runtimeDir, "vswitch.sock")
 )
 
 // getEnvOrDefault returns environment variable value or default if not set
@@ -46,45 +64,236 @@ func getEnvBoolOrDefault(key string, defaultValue bool) bool {
 }
 
 var (
-	ports     = flag.String("ports", getEnvOrDefault("VSWITCH_PORTS", "9999,9998"), "Comma-separated list of ports (each port = isolated VLAN) [env: VSWITCH_PORTS]")
-	statsPort = flag.Int("stats-port", getEnvIntOrDefault("VSWITCH_STATS_PORT", 0), "Port for statistics HTTP server (0 to disable) [env: VSWITCH_STATS_PORT]")
-	daemon    = flag.Bool("daemon", getEnvBoolOrDefault("VSWITCH_DAEMON", false), "Run as daemon in background [env: VSWITCH_DAEMON]")
-	pidFile   = flag.String("pid-file", getEnvOrDefault("VSWITCH_PID_FILE", "/tmp/vswitch.pid"), "PID file for daemon mode [env: VSWITCH_PID_FILE]")
-	logFile   = flag.String("log-file", getEnvOrDefault("VSWITCH_LOG_FILE", ""), "Log file (empty for syslog) [env: VSWITCH_LOG_FILE]")
-	stop      = flag.Bool("stop", false, "Stop running daemon")
-	status    = flag.Bool("status", false, "Show daemon status")
-	version   = flag.Bool("version", false, "Show version information")
+	ports            = flag.String("ports", getEnvOrDefault("VSWITCH_PORTS", "9999,9998"), "Comma-separated list of ports, ranges (9000-9015) and counts (9000+8); each port is an isolated VLAN, except ports given the same name (9999=lab0,9998=lab0), which feed one VLAN [env: VSWITCH_PORTS]")
+	statsPort        = flag.Int("stats-port", getEnvIntOrDefault("VSWITCH_STATS_PORT", 0), "Port for statistics HTTP server (0 to disable) [env: VSWITCH_STATS_PORT]")
+	controlSocket    = flag.String("control-socket", getEnvOrDefault("VSWITCH_CONTROL_SOCKET", defaultControlSocket), "Unix socket for the ctl subcommands, default "+filepath.Join(runtimeDir, "vswitch-NAME.sock")+" with -instance, @name for an abstract socket on Linux (empty to disable) [env: VSWITCH_CONTROL_SOCKET]")
+	dockerPlugin     = flag.String("docker-plugin", getEnvOrDefault("VSWITCH_DOCKER_PLUGIN", ""), "Unix socket serving the Docker network driver plugin API, e.g. /run/docker/plugins/vswitch.sock [env: VSWITCH_DOCKER_PLUGIN]")
+	xdp              = flag.Bool("xdp", getEnvBoolOrDefault("VSWITCH_XDP", false), "Give Docker containers veths and forward unicast frames between them in the kernel with XDP, Linux only [env: VSWITCH_XDP]")
+	tlsListen        = flag.String("tls-listen", getEnvOrDefault("VSWITCH_TLS_LISTEN", ""), "Address of a TLS endpoint placing clients on VLANs by their certificate, e.g. :9443 [env: VSWITCH_TLS_LISTEN]")
+	tlsCert          = flag.String("tls-cert", getEnvOrDefault("VSWITCH_TLS_CERT", ""), "Certificate of the TLS endpoint (PEM) [env: VSWITCH_TLS_CERT]")
+	tlsKey           = flag.String("tls-key", getEnvOrDefault("VSWITCH_TLS_KEY", ""), "Private key of the TLS endpoint (PEM) [env: VSWITCH_TLS_KEY]")
+	tlsClientCA      = flag.String("tls-client-ca", getEnvOrDefault("VSWITCH_TLS_CLIENT_CA", ""), "CA certificates client certificates must be signed by (PEM) [env: VSWITCH_TLS_CLIENT_CA]")
+	tlsVLANs         = flag.String("tls-vlans", getEnvOrDefault("VSWITCH_TLS_VLANS", ""), "Client certificate identities and the VLANs they join, with optional port settings, e.g. tenant-a=9999,tenant-b=9998/isolated,*=9997 [env: VSWITCH_TLS_VLANS]")
+	clusterNode      = flag.String("cluster-node", getEnvOrDefault("VSWITCH_CLUSTER_NODE", ""), "Name of this switch in a cluster spanning hosts, one of the names of -cluster-cert (empty to disable) [env: VSWITCH_CLUSTER_NODE]")
+	clusterListen    = flag.String("cluster-listen", getEnvOrDefault("VSWITCH_CLUSTER_LISTEN", ""), "Address other cluster nodes link to, e.g. :7946 [env: VSWITCH_CLUSTER_LISTEN]")
+	clusterPeers     = flag.String("cluster-peers", getEnvOrDefault("VSWITCH_CLUSTER_PEERS", ""), "Comma-separated addresses of the cluster nodes to link to, e.g. host-b:7946,host-c:7946 [env: VSWITCH_CLUSTER_PEERS]")
+	clusterCert      = flag.String("cluster-cert", getEnvOrDefault("VSWITCH_CLUSTER_CERT", ""), "Certificate of this cluster node (PEM) [env: VSWITCH_CLUSTER_CERT]")
+	clusterKey       = flag.String("cluster-key", getEnvOrDefault("VSWITCH_CLUSTER_KEY", ""), "Private key of this cluster node (PEM) [env: VSWITCH_CLUSTER_KEY]")
+	clusterHorizon   = flag.String("cluster-horizon", getEnvOrDefault("VSWITCH_CLUSTER_HORIZON", vswitch.DefaultClusterHorizon), "Split horizon group of the trunks to other cluster nodes [env: VSWITCH_CLUSTER_HORIZON]")
+	clusterCA        = flag.String("cluster-ca", getEnvOrDefault("VSWITCH_CLUSTER_CA", ""), "CA certificates the certificates of cluster nodes must be signed by (PEM) [env: VSWITCH_CLUSTER_CA]")
+	auditLogPath     = flag.String("audit-log", getEnvOrDefault("VSWITCH_AUDIT_LOG", ""), "File management operations through the API and control socket are appended to as JSON lines (empty to disable) [env: VSWITCH_AUDIT_LOG]")
+	grpcListen       = flag.String("grpc-listen", getEnvOrDefault("VSWITCH_GRPC_LISTEN", ""), "Address serving the SwitchControl gRPC API: a unix socket path only its owner can open, @name for an abstract socket on Linux, or host:port guarded like the HTTP API by -api-token (empty to disable) [env: VSWITCH_GRPC_LISTEN]")
+	apiToken         = flag.String("api-token", getEnvOrDefault("VSWITCH_API_TOKEN", ""), "Bearer token required by the HTTP API; enables VLAN management [env: VSWITCH_API_TOKEN]")
+	stickyMAC        = flag.Bool("sticky-mac", getEnvBoolOrDefault("VSWITCH_STICKY_MAC", false), "Lock each MAC to the first connection it is learned on [env: VSWITCH_STICKY_MAC]")
+	privateVLAN      = flag.Bool("private-vlan", getEnvBoolOrDefault("VSWITCH_PRIVATE_VLAN", false), "Isolate connections from each other except for promiscuous peers [env: VSWITCH_PRIVATE_VLAN]")
+	promiscuousPeers = flag.String("promiscuous-peers", getEnvOrDefault("VSWITCH_PROMISCUOUS_PEERS", ""), "Comma-separated IPs/CIDRs of peers allowed to reach isolated connections [env: VSWITCH_PROMISCUOUS_PEERS]")
+	allowClients     = flag.String("allow-clients", getEnvOrDefault("VSWITCH_ALLOW_CLIENTS", ""), "Per-VLAN IPs/CIDRs allowed to connect, all others rejected, e.g. 9999=10.0.0.0/8 [env: VSWITCH_ALLOW_CLIENTS]")
+	horizonGroups    = flag.String("horizon-groups", getEnvOrDefault("VSWITCH_HORIZON_GROUPS", ""), "Per-VLAN split horizon groups of peer IPs/CIDRs, whose connections never forward frames to each other, e.g. 9999=mesh:10.0.0.2,9999=mesh:10.0.0.3 [env: VSWITCH_HORIZON_GROUPS]")
+	trunkBroadcast   = flag.Int("trunk-broadcast-limit", getEnvIntOrDefault("VSWITCH_TRUNK_BROADCAST_LIMIT", 0), "Broadcast frames per second flooded to each trunk link (0 for unlimited) [env: VSWITCH_TRUNK_BROADCAST_LIMIT]")
+	trunkUnknown     = flag.Int("trunk-unknown-unicast-limit", getEnvIntOrDefault("VSWITCH_TRUNK_UNKNOWN_UNICAST_LIMIT", 0), "Unknown unicast frames per second flooded to each trunk link (0 for unlimited) [env: VSWITCH_TRUNK_UNKNOWN_UNICAST_LIMIT]")
+	trunkMulticast   = flag.Int("trunk-multicast-limit", getEnvIntOrDefault("VSWITCH_TRUNK_MULTICAST_LIMIT", 0), "Multicast frames per second flooded to each trunk link (0 for unlimited) [env: VSWITCH_TRUNK_MULTICAST_LIMIT]")
+	denyClients      = flag.String("deny-clients", getEnvOrDefault("VSWITCH_DENY_CLIENTS", ""), "Per-VLAN IPs/CIDRs rejected at connect time, e.g. 9999=10.0.0.5 [env: VSWITCH_DENY_CLIENTS]")
+	workers          = flag.Int("workers", getEnvIntOrDefault("VSWITCH_WORKERS", runtime.GOMAXPROCS(0)), "Goroutines forwarding the frames of each VLAN (0 forwards in each connection's reader) [env: VSWITCH_WORKERS]")
+	egressQueue      = flag.Int("egress-queue", getEnvIntOrDefault("VSWITCH_EGRESS_QUEUE", vswitch.DefaultEgressQueueSize), "Frames queued per connection before further frames to it are dropped (0 writes synchronously) [env: VSWITCH_EGRESS_QUEUE]")
+	mtu              = flag.Int("mtu", getEnvIntOrDefault("VSWITCH_MTU", vswitch.DefaultMTU), "Largest frame payload accepted from VMs, up to 9198 for jumbo frames [env: VSWITCH_MTU]")
+	listenFamily     = flag.String("listen-family", getEnvOrDefault("VSWITCH_LISTEN_FAMILY", "dual"), "IP versions the VLANs listen on: ipv4, ipv6 or dual, for all VLANs and/or per VLAN, e.g. ipv4,9999=ipv6 [env: VSWITCH_LISTEN_FAMILY]")
+	tcpNoDelay       = flag.Bool("tcp-nodelay", getEnvBoolOrDefault("VSWITCH_TCP_NODELAY", true), "Send frames to VMs at once rather than batching them with Nagle's algorithm [env: VSWITCH_TCP_NODELAY]")
+	socketSndBuf     = flag.Int("socket-sndbuf", getEnvIntOrDefault("VSWITCH_SOCKET_SNDBUF", 0), "Kernel send buffer size of VM connections in bytes (0 for the system default) [env: VSWITCH_SOCKET_SNDBUF]")
+	socketRcvBuf     = flag.Int("socket-rcvbuf", getEnvIntOrDefault("VSWITCH_SOCKET_RCVBUF", 0), "Kernel receive buffer size of VM connections in bytes (0 for the system default) [env: VSWITCH_SOCKET_RCVBUF]")
+	tcpKeepAlive     = flag.String("tcp-keepalive", getEnvOrDefault("VSWITCH_TCP_KEEPALIVE", "15s"), "Idle time before and interval between TCP keepalive probes of VM connections (0 to disable) [env: VSWITCH_TCP_KEEPALIVE]")
+	allowDegraded    = flag.Bool("allow-degraded", getEnvBoolOrDefault("VSWITCH_ALLOW_DEGRADED", false), "Keep running with the VLANs that started when others cannot bind their port, reporting them as not ready, instead of exiting [env: VSWITCH_ALLOW_DEGRADED]")
+	bindRetry        = flag.String("bind-retry", getEnvOrDefault("VSWITCH_BIND_RETRY", "0"), "How long to keep retrying, with exponential backoff, to bind VLAN ports in use at startup, e.g. after a quick restart (0 to fail at once) [env: VSWITCH_BIND_RETRY]")
+	namedPipes       = flag.String("pipes", getEnvOrDefault("VSWITCH_PIPES", ""), "Per-VLAN Windows named pipes VMs can connect to besides the TCP port, e.g. 9999=vswitch-9999 for \\\\.\\pipe\\vswitch-9999 [env: VSWITCH_PIPES]")
+	listenersPerPort = flag.String("listeners-per-port", getEnvOrDefault("VSWITCH_LISTENERS_PER_PORT", ""), "Per-VLAN number of SO_REUSEPORT listeners, each with its own accept loop, spreading many clients across cores (Linux only), e.g. 9999=4 [env: VSWITCH_LISTENERS_PER_PORT]")
+	listenBacklog    = flag.Int("listen-backlog", getEnvIntOrDefault("VSWITCH_LISTEN_BACKLOG", 0), "Length of the queue of VM connections waiting to be accepted (0 for the system maximum) [env: VSWITCH_LISTEN_BACKLOG]")
+	dropUnknown      = flag.String("drop-unknown-unicast", getEnvOrDefault("VSWITCH_DROP_UNKNOWN_UNICAST", ""), "VLANs dropping unicast frames to unlearned destinations instead of flooding them to every connection, e.g. 9999,9998 [env: VSWITCH_DROP_UNKNOWN_UNICAST]")
+	arpSuppression   = flag.String("arp-suppression", getEnvOrDefault("VSWITCH_ARP_SUPPRESSION", ""), "VLANs answering ARP requests for learned addresses instead of flooding them across hosts, e.g. 9999,9998 [env: VSWITCH_ARP_SUPPRESSION]")
+	padFrames        = flag.String("pad-frames", getEnvOrDefault("VSWITCH_PAD_FRAMES", ""), "VLANs whose frames are padded to the 60-byte Ethernet minimum on egress, e.g. 9999,9998 [env: VSWITCH_PAD_FRAMES]")
+	bandwidthLimits  = flag.String("bandwidth", getEnvOrDefault("VSWITCH_BANDWIDTH", ""), "Per-VLAN bandwidth the forwarded traffic is shaped to, in bits per second with an optional burst in bytes, e.g. 9999=100M or 9999=1G/512k [env: VSWITCH_BANDWIDTH]")
+	tagDemux         = flag.String("tag-demux", getEnvOrDefault("VSWITCH_TAG_DEMUX", ""), "Per-VLAN 802.1Q trunk mode switching each VLAN ID in a broadcast domain of its own: all or the VLAN IDs carried, and native:<vid> for untagged frames, e.g. 9999=all or 9999=10-20,9999=native:1 [env: VSWITCH_TAG_DEMUX]")
+	psks             = flag.String("psk", getEnvOrDefault("VSWITCH_PSK", ""), "Per-VLAN pre-shared keys of at least 16 bytes clients must prove they hold before joining, e.g. 9999=<key>; prefer the environment to keep keys out of the process list [env: VSWITCH_PSK]")
+	protocolVLANs    = flag.String("protocol-vlans", getEnvOrDefault("VSWITCH_PROTOCOL_VLANS", ""), "Per-VLAN assignments of the untagged frames of a protocol to another VLAN, as protocol:port with dhcp or an EtherType, e.g. 9999=dhcp:9990,9998=dhcp:9990 [env: VSWITCH_PROTOCOL_VLANS]")
+	impairments      = flag.String("impairment", getEnvOrDefault("VSWITCH_IMPAIRMENT", ""), "Per-VLAN impairment of the frames sent to connections: delay, jitter, loss, duplicate, reorder and limit terms, e.g. 9999=delay:50ms,9999=loss:1% [env: VSWITCH_IMPAIRMENT]")
+	frameValidation  = flag.String("frame-validation", getEnvOrDefault("VSWITCH_FRAME_VALIDATION", ""), "Per-VLAN frame validation mode: strict, standard (default) or permissive, e.g. 9999=permissive [env: VSWITCH_FRAME_VALIDATION]")
+	macTimeout       = flag.String("mac-timeout", getEnvOrDefault("VSWITCH_MAC_TIMEOUT", vswitch.DefaultMACTimeout.String()), "How long learned MAC addresses are kept without traffic [env: VSWITCH_MAC_TIMEOUT]")
+	macTableSize     = flag.Int("mac-table-size", getEnvIntOrDefault("VSWITCH_MAC_TABLE_SIZE", 0), "MAC addresses learned per VLAN before the least recently used are evicted (0 for unlimited) [env: VSWITCH_MAC_TABLE_SIZE]")
+	slowConsumer     = flag.String("slow-consumer", getEnvOrDefault("VSWITCH_SLOW_CONSUMER", "drop-new"), "What to do when a connection's egress queue is full: drop-new, drop-tail or disconnect [env: VSWITCH_SLOW_CONSUMER]")
+	slowTimeout      = flag.String("slow-consumer-timeout", getEnvOrDefault("VSWITCH_SLOW_CONSUMER_TIMEOUT", "5s"), "How long an egress queue may stay full before -slow-consumer disconnect drops the connection [env: VSWITCH_SLOW_CONSUMER_TIMEOUT]")
+	macStateDir      = flag.String("mac-state-dir", getEnvOrDefault("VSWITCH_MAC_STATE_DIR", ""), "Directory where MAC tables are saved and restored across restarts (empty to disable) [env: VSWITCH_MAC_STATE_DIR]")
+	countersDir      = flag.String("counters-dir", getEnvOrDefault("VSWITCH_COUNTERS_DIR", ""), "Directory where VLAN counters are saved every minute and restored across restarts (empty to disable) [env: VSWITCH_COUNTERS_DIR]")
+	ouiFile          = flag.String("oui-file", getEnvOrDefault("VSWITCH_OUI_FILE", ""), "OUI database (Wireshark manuf or IEEE oui.txt) naming the vendors of MAC addresses beyond the built-in ones [env: VSWITCH_OUI_FILE]")
+	macWebhook       = flag.String("mac-webhook", getEnvOrDefault("VSWITCH_MAC_WEBHOOK", ""), "URL receiving a JSON POST when a MAC is learned, moves to another connection or is removed (empty to disable) [env: VSWITCH_MAC_WEBHOOK]")
+	macMoveAnnounce  = flag.Bool("mac-move-announce", getEnvBoolOrDefault("VSWITCH_MAC_MOVE_ANNOUNCE", false), "Flood a RARP and gratuitous ARPs when a MAC moves to another connection, e.g. after a live migration [env: VSWITCH_MAC_MOVE_ANNOUNCE]")
+	lldp             = flag.Bool("lldp", getEnvBoolOrDefault("VSWITCH_LLDP", false), "Advertise each connection's switch port and VM to its guest with LLDP [env: VSWITCH_LLDP]")
+	hairpin          = flag.Bool("hairpin", getEnvBoolOrDefault("VSWITCH_HAIRPIN", false), "Allow frames to be forwarded back out the connection they arrived on [env: VSWITCH_HAIRPIN]")
+	slaac            = flag.String("slaac", getEnvOrDefault("VSWITCH_SLAAC", ""), "Per-VLAN IPv6 /64 prefixes to announce for SLAAC, e.g. 9999=2001:db8:1::/64 [env: VSWITCH_SLAAC]")
+	slaacDNS         = flag.String("slaac-dns", getEnvOrDefault("VSWITCH_SLAAC_DNS", ""), "Per-VLAN IPv6 DNS servers to announce with SLAAC, e.g. 9999=2001:db8:1::53 [env: VSWITCH_SLAAC_DNS]")
+	dhcpSnooping     = flag.Bool("dhcp-snooping", getEnvBoolOrDefault("VSWITCH_DHCP_SNOOPING", false), "Only allow DHCP server replies from trusted peers and record leases [env: VSWITCH_DHCP_SNOOPING]")
+	ipInventory      = flag.Bool("ip-inventory", getEnvBoolOrDefault("VSWITCH_IP_INVENTORY", false), "Record the IP addresses each connection's guests send from, learned from ARP, IPv4 headers and IPv6 neighbor discovery [env: VSWITCH_IP_INVENTORY]")
+	trustedPeers     = flag.String("trusted-peers", getEnvOrDefault("VSWITCH_TRUSTED_PEERS", ""), "Comma-separated IPs/CIDRs of peers trusted to run DHCP servers and IPv6 routers [env: VSWITCH_TRUSTED_PEERS]")
+	raGuard          = flag.Bool("ra-guard", getEnvBoolOrDefault("VSWITCH_RA_GUARD", false), "Drop IPv6 router advertisements from untrusted peers [env: VSWITCH_RA_GUARD]")
+	ndInspection     = flag.Bool("nd-inspection", getEnvBoolOrDefault("VSWITCH_ND_INSPECTION", false), "Drop spoofed IPv6 neighbor discovery from untrusted peers [env: VSWITCH_ND_INSPECTION]")
+	dnsAddrs         = flag.String("dns", getEnvOrDefault("VSWITCH_DNS", ""), "Per-VLAN IPv4 addresses for the built-in DNS forwarder, e.g. 9999=10.0.0.53 [env: VSWITCH_DNS]")
+	dnsUpstreams     = flag.String("dns-upstreams", getEnvOrDefault("VSWITCH_DNS_UPSTREAMS", ""), "Comma-separated upstream resolvers (host:port) for the DNS forwarder, default from /etc/resolv.conf [env: VSWITCH_DNS_UPSTREAMS]")
+	dnsRecords       = flag.String("dns-records", getEnvOrDefault("VSWITCH_DNS_RECORDS", ""), "Comma-separated static DNS records served by the forwarder, e.g. gw.lab=10.0.0.1 [env: VSWITCH_DNS_RECORDS]")
+	proxyARP         = flag.String("proxy-arp", getEnvOrDefault("VSWITCH_PROXY_ARP", ""), "Per-VLAN IPv4 addresses to answer ARP for, optionally with a MAC, e.g. 9999=10.0.0.1@52:54:00:12:34:56 [env: VSWITCH_PROXY_ARP]")
+	natGateways      = flag.String("nat", getEnvOrDefault("VSWITCH_NAT", ""), "Per-VLAN gateway addresses for the user-mode NAT uplink, e.g. 9999=10.0.2.2 [env: VSWITCH_NAT]")
+	natForwards      = flag.String("nat-forwards", getEnvOrDefault("VSWITCH_NAT_FORWARDS", ""), "Per-VLAN NAT port forwards as proto/host-addr/guest-ip:port, e.g. 9999=tcp/:2222/10.0.2.15:22 [env: VSWITCH_NAT_FORWARDS]")
+	natLoopback      = flag.Bool("nat-host-loopback", getEnvBoolOrDefault("VSWITCH_NAT_HOST_LOOPBACK", false), "Relay NAT connections to the gateway address to the host's loopback interface [env: VSWITCH_NAT_HOST_LOOPBACK]")
+	dhcpServers      = flag.String("dhcp-server", getEnvOrDefault("VSWITCH_DHCP_SERVER", ""), "Per-VLAN DHCP server address and subnet, e.g. 9999=10.0.2.3/24 [env: VSWITCH_DHCP_SERVER]")
+	dhcpRanges       = flag.String("dhcp-range", getEnvOrDefault("VSWITCH_DHCP_RANGE", ""), "Per-VLAN DHCP address pools, default the whole subnet, e.g. 9999=10.0.2.100-10.0.2.199 [env: VSWITCH_DHCP_RANGE]")
+	dhcpLeaseTime    = flag.String("dhcp-lease-time", getEnvOrDefault("VSWITCH_DHCP_LEASE_TIME", "1h"), "Lease time handed out by the DHCP server [env: VSWITCH_DHCP_LEASE_TIME]")
+	pxeBoot          = flag.String("pxe-boot", getEnvOrDefault("VSWITCH_PXE_BOOT", ""), "Per-VLAN PXE boot file offered by the DHCP server, e.g. 9999=pxelinux.0 [env: VSWITCH_PXE_BOOT]")
+	pxeBootUEFI      = flag.String("pxe-boot-uefi", getEnvOrDefault("VSWITCH_PXE_BOOT_UEFI", ""), "Per-VLAN PXE boot file for UEFI clients, e.g. 9999=bootx64.efi [env: VSWITCH_PXE_BOOT_UEFI]")
+	tftpRoots        = flag.String("tftp-root", getEnvOrDefault("VSWITCH_TFTP_ROOT", ""), "Per-VLAN directory served read-only over TFTP, e.g. 9999=/srv/tftp [env: VSWITCH_TFTP_ROOT]")
+	openflowCtrls    = flag.String("openflow", getEnvOrDefault("VSWITCH_OPENFLOW", ""), "Per-VLAN OpenFlow 1.3 controllers replacing MAC learning, e.g. 9999=127.0.0.1:6653 [env: VSWITCH_OPENFLOW]")
+	sflowCollector   = flag.String("sflow-collector", getEnvOrDefault("VSWITCH_SFLOW_COLLECTOR", ""), "sFlow collector (host:port) to export sampled frames and counters to [env: VSWITCH_SFLOW_COLLECTOR]")
+	sflowRate        = flag.Int("sflow-sampling-rate", getEnvIntOrDefault("VSWITCH_SFLOW_SAMPLING_RATE", 512), "Sample one in this many frames for sFlow [env: VSWITCH_SFLOW_SAMPLING_RATE]")
+	sflowInterval    = flag.String("sflow-polling-interval", getEnvOrDefault("VSWITCH_SFLOW_POLLING_INTERVAL", "20s"), "Interval between sFlow counter samples (0 to disable) [env: VSWITCH_SFLOW_POLLING_INTERVAL]")
+	protocolStats    = flag.Int("protocol-stats", getEnvIntOrDefault("VSWITCH_PROTOCOL_STATS", 0), "Decode one in this many frames to break traffic down by protocol, port and DNS query type in the statistics (0 to disable) [env: VSWITCH_PROTOCOL_STATS]")
+	vmnetSockets     = flag.String("vmnet", getEnvOrDefault("VSWITCH_VMNET", ""), "Per-VLAN socket_vmnet sockets connecting the VLAN to the host network on macOS, e.g. 9999=/opt/homebrew/var/run/socket_vmnet [env: VSWITCH_VMNET]")
+	captureListen    = flag.String("capture-listen", getEnvOrDefault("VSWITCH_CAPTURE_LISTEN", ""), "Per-VLAN TCP addresses streaming frames as pcap for remote capture, e.g. 9999=127.0.0.1:19999 [env: VSWITCH_CAPTURE_LISTEN]")
+	daemon           = flag.Bool("daemon", getEnvBoolOrDefault("VSWITCH_DAEMON", false), "Run as daemon in background [env: VSWITCH_DAEMON]")
+	supervise        = flag.Bool("supervise", getEnvBoolOrDefault("VSWITCH_SUPERVISE", false), "Run the switch in a child process and restart it when it crashes [env: VSWITCH_SUPERVISE]")
+	watchdogTimeout  = flag.String("watchdog-timeout", getEnvOrDefault("VSWITCH_WATCHDOG_TIMEOUT", "30s"), "How long a supervised switch may go without reporting its forwarding loops alive before it is restarted (0 to disable) [env: VSWITCH_WATCHDOG_TIMEOUT]")
+	runAsUser        = flag.String("user", getEnvOrDefault("VSWITCH_USER", ""), "User to switch to once all sockets are open, when started as root [env: VSWITCH_USER]")
+	runAsGroup       = flag.String("group", getEnvOrDefault("VSWITCH_GROUP", ""), "Group to switch to with -user, default the user's primary group [env: VSWITCH_GROUP]")
+	drainTimeout     = flag.String("upgrade-drain-timeout", getEnvOrDefault("VSWITCH_UPGRADE_DRAIN_TIMEOUT", "1m"), "How long the old process keeps serving its connections after a SIGUSR2 upgrade [env: VSWITCH_UPGRADE_DRAIN_TIMEOUT]")
+	shutdownDrain    = flag.String("drain-timeout", getEnvOrDefault("VSWITCH_DRAIN_TIMEOUT", "5s"), "How long to spend on shutdown flushing the frames queued for each connection before closing it (0 to close connections at once) [env: VSWITCH_DRAIN_TIMEOUT]")
+	drainFIN         = flag.Bool("drain-fin", getEnvBoolOrDefault("VSWITCH_DRAIN_FIN", false), "Half-close connections on shutdown once flushed and wait, within -drain-timeout, for the VMs to close them [env: VSWITCH_DRAIN_FIN]")
+	pidFile          = flag.String("pid-file", getEnvOrDefault("VSWITCH_PID_FILE", defaultPIDFile), "PID file for daemon mode, default "+filepath.Join(runtimeDir, "vswitch-NAME.pid")+" with -instance [env: VSWITCH_PID_FILE]")
+	instance         = flag.String("instance", getEnvOrDefault("VSWITCH_INSTANCE", ""), "Name of this switch, separating its default PID file, control socket and log identifier from other instances on the host [env: VSWITCH_INSTANCE]")
+	logLevel         = flag.String("log-level", getEnvOrDefault("VSWITCH_LOG_LEVEL", "info"), "Log level: error, warn, info or debug [env: VSWITCH_LOG_LEVEL]")
+	logFormat        = flag.String("log-format", getEnvOrDefault("VSWITCH_LOG_FORMAT", "text"), "Log format: text or json [env: VSWITCH_LOG_FORMAT]")
+	journald         = flag.Bool("journald", getEnvBoolOrDefault("VSWITCH_JOURNALD", false), "Log to systemd-journald with structured fields instead of syslog or stdout [env: VSWITCH_JOURNALD]")
+	logFile          = flag.String("log-file", getEnvOrDefault("VSWITCH_LOG_FILE", ""), "Log file (empty for syslog) [env: VSWITCH_LOG_FILE]")
+	logMaxSize       = flag.Int("log-max-size", getEnvIntOrDefault("VSWITCH_LOG_MAX_SIZE", 0), "Rotate the log file when it exceeds this many MB (0 to disable) [env: VSWITCH_LOG_MAX_SIZE]")
+	logRotateAfter   = flag.String("log-rotate-interval", getEnvOrDefault("VSWITCH_LOG_ROTATE_INTERVAL", "0"), "Rotate the log file after this long, e.g. 24h (0 to disable) [env: VSWITCH_LOG_ROTATE_INTERVAL]")
+	logMaxBackups    = flag.Int("log-max-backups", getEnvIntOrDefault("VSWITCH_LOG_MAX_BACKUPS", 5), "Number of rotated log files to keep [env: VSWITCH_LOG_MAX_BACKUPS]")
+	statsLogInterval = flag.String("stats-log-interval", getEnvOrDefault("VSWITCH_STATS_LOG_INTERVAL", "60s"), "Interval between statistics log lines (0 to disable) [env: VSWITCH_STATS_LOG_INTERVAL]")
+	statsLogVLANs    = flag.Bool("stats-log-vlans", getEnvBoolOrDefault("VSWITCH_STATS_LOG_VLANS", false), "Add a statistics log line per VLAN to each periodic line [env: VSWITCH_STATS_LOG_VLANS]")
+	stop             = flag.Bool("stop", false, "Stop running daemon")
+	status           = flag.Bool("status", false, "Show daemon status")
+	version          = flag.Bool("version", false, "Show version information")
 )
 
-// setupLogging configures logging based on daemon mode and log file settings
-func setupLogging(logFile string, isDaemon bool) error {
-	if logFile == "" {
-		if isDaemon {
-			// Use syslog for daemon mode when no log file specified
-			syslogWriter, err := syslog.New(syslog.LOG_INFO|syslog.LOG_DAEMON, "vswitch")
-			if err != nil {
-				return fmt.Errorf("failed to connect to syslog: %v", err)
+// setupLogging configures logging based on daemon mode, log file, format and
+// level settings. It returns the log file, if one is used, so it can be
+// reopened and closed.
+func setupLogging(logFile string, isDaemon bool, format string, level slog.Level) (*vswitch.LogFile, error) {
+	opts := &slog.HandlerOptions{Level: level}
+	var writer io.Writer
+	var file *vswitch.LogFile
+
+	if logFile != "" {
+		rotateInterval, err := time.ParseDuration(*logRotateAfter)
+		if err != nil || rotateInterval < 0 {
+			return nil, fmt.Errorf("invalid -log-rotate-interval '%s'", *logRotateAfter)
+		}
+		if file, err = vswitch.OpenLogFile(logFile, int64(*logMaxSize)<<20, rotateInterval, *logMaxBackups); err != nil {
+			return nil, err
+		}
+		writer = file
+	} else if *journald {
+		// journald keeps the fields of every record queryable
+		handler, err := vswitch.NewJournalHandler(logIdentifier(), level)
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to journald: %v", err)
+		}
+		slog.SetDefault(slog.New(handler))
+		return nil, nil
+	} else if isDaemon {
+		// Use syslog for daemon mode when no log file specified
+		syslogWriter, err := newSyslogWriter(logIdentifier())
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to syslog: %v", err)
+		}
+		opts.ReplaceAttr = func(groups []string, attr slog.Attr) slog.Attr {
+			// syslog handles timestamps
+			if len(groups) == 0 && attr.Key == slog.TimeKey {
+				return slog.Attr{}
 			}
-			log.SetOutput(syslogWriter)
-			log.SetFlags(0) // syslog handles timestamps and prefixes
-		} else {
-			// Use stdout for foreground mode when no log file specified
-			log.SetOutput(os.Stdout)
-			log.SetFlags(log.LstdFlags | log.Lshortfile)
+			return attr
 		}
+		writer = syslogWriter
 	} else {
-		// Use file logging (handled by daemon.go for daemon mode)
-		log.SetFlags(log.LstdFlags | log.Lshortfile)
+		// Use stdout for foreground mode when no log file specified
+		writer = os.Stdout
+	}
+
+	switch format {
+	case "text":
+		slog.SetDefault(slog.New(slog.NewTextHandler(writer, opts)))
+	case "json":
+		slog.SetDefault(slog.New(slog.NewJSONHandler(writer, opts)))
+	default:
+		if file != nil {
+			_ = file.Close()
+		}
+		return nil, fmt.Errorf("unknown log format '%s'", format)
+	}
+	return file, nil
+}
+
+// logIdentifier returns the name the switch logs under to syslog and
+// journald
+func logIdentifier() string {
+	if *instance == "" {
+		return "vswitch"
+	}
+	return "vswitch-" + *instance
+}
+
+// applyInstance moves the PID file and control socket of a named instance
+// away from the defaults unless they were set explicitly
+func applyInstance(name string) error {
+	if name == "" {
+		return nil
+	}
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+			return fmt.Errorf("'%s' may only contain letters, digits, '-' and '_'", name)
+		}
+	}
+
+	if *pidFile == defaultPIDFile {
+		*pidFile = filepath.Join(runtimeDir, "vswitch-"+name+".pid")
+	}
+	if *controlSocket == defaultControlSocket {
+		*controlSocket = filepath.Join(runtimeDir, "vswitch-"+name+".sock")
 	}
 	return nil
 }
 
+// parseLogLevel parses a log level name (error, warn, info or debug)
+func parseLogLevel(name string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(name)); err != nil {
+		return level, fmt.Errorf("unknown log level '%s'", name)
+	}
+	return level, nil
+}
+
+// fatalf logs an error and exits
+func fatalf(format string, args ...interface{}) {
+	slog.Error(fmt.Sprintf(format, args...))
+	os.Exit(1)
+}
+
 func main() {
+	// Run as KubeVirt's domain hook when linked under its name
+	if filepath.Base(os.Args[0]) == kubevirtHook {
+		os.Exit(runKubeVirtHook(os.Args[1:]))
+	}
+
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, "Virtual Switch for QEMU VMs %s\n\n", GetVersion())
 		fmt.Fprintf(os.Stderr, "A high-performance virtual Ethernet switch with isolated VLANs.\n")
 		fmt.Fprintf(os.Stderr, "Each port creates a separate isolated virtual LAN.\n\n")
-		fmt.Fprintf(os.Stderr, "Usage: %s [options]\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Usage: %s [options]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s [options] ctl <command> [arguments]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s selftest [-frames n] [-size bytes] [-timeout duration]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s echo [-ping] [options] <host:port>\n\n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "Options:\n")
 		flag.PrintDefaults()
 		fmt.Fprintf(os.Stderr, "\nExamples:\n")
@@ -92,17 +301,43 @@ func main() {
 		fmt.Fprintf(os.Stderr, "  %s -daemon -ports 8080,8081\n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "  %s -stop\n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "  %s -status\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s ctl connections 9999\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s selftest -frames 100000\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s echo -ping -count 100 -interval 10ms switch.example:9999\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s qemu-args -vlan 9999 -format libvirt\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -instance lab -daemon -ports 7000\n", os.Args[0])
 	}
 
 	flag.Parse()
 
+	if err := applyInstance(*instance); err != nil {
+		fatalf("Invalid -instance: %v", err)
+	}
+
 	if *version {
 		fmt.Printf("Virtual Switch for QEMU VMs %s\n", GetVersion())
 		os.Exit(0)
 	}
 
+	if flag.Arg(0) == "ctl" {
+		os.Exit(runCtl(*controlSocket, flag.Args()[1:]))
+	}
+
+	if flag.Arg(0) == "selftest" {
+		os.Exit(runSelftest(flag.Args()[1:]))
+	}
+
+	if flag.Arg(0) == "echo" {
+		os.Exit(runEcho(flag.Args()[1:]))
+	}
+
+	if flag.Arg(0) == "qemu-args" {
+		os.Exit(runQEMUArgs(flag.Args()[1:]))
+	}
+
 	// Initialize daemon manager
 	dm := vswitch.NewDaemonManager(*pidFile, *logFile)
+	dm.Logger = slog.Default()
 
 	if *stop {
 		if !dm.IsRunning() {
@@ -121,6 +356,11 @@ func main() {
 		if dm.IsRunning() {
 			pid := dm.GetPID()
 			fmt.Printf("Daemon is running (PID: %d)\n", pid)
+			if *controlSocket != "" {
+				if vlans, err := vswitch.NewControlClient(*controlSocket).VLANs(); err == nil {
+					fmt.Printf("VLANs (%d): %s\n", len(vlans), formatPorts(vlans))
+				}
+			}
 			os.Exit(0)
 		}
 		fmt.Printf("Daemon is not running\n")
@@ -128,13 +368,13 @@ func main() {
 	}
 
 	// Parse ports
-	portList, err := parsePorts(*ports)
+	portList, extraPorts, err := parseVLANPorts(*ports)
 	if err != nil {
-		log.Fatalf("Invalid ports specification: %v", err)
+		fatalf("Invalid ports specification: %v", err)
 	}
 
 	if len(portList) == 0 {
-		log.Fatalf("No ports specified")
+		fatalf("No ports specified")
 	}
 
 	if *daemon {
@@ -157,55 +397,358 @@ func main() {
 	}
 
 	// Set up logging
-	if err := setupLogging(*logFile, *daemon); err != nil {
-		log.Fatalf("Failed to setup logging: %v", err)
+	level, err := parseLogLevel(*logLevel)
+	if err != nil {
+		fatalf("Invalid -log-level: %v", err)
 	}
-	log.Printf("Starting Virtual Switch %s", GetVersion())
-	log.Printf("Configured VLANs on ports: %v", portList)
 
-	// Create switch manager and add VLANs for each port
-	sm := vswitch.NewSwitchManager()
-	for _, port := range portList {
-		if err := sm.AddVLAN(port); err != nil {
-			log.Fatalf("Failed to create VLAN on port %d: %v", port, err)
+	// Take over the listening sockets of the process being upgraded, if any
+	if err := vswitch.InheritSockets(); err != nil {
+		fatalf("Failed to take over sockets: %v", err)
+	}
+
+	// A daemon holds the lock on its PID file for as long as it runs
+	if vswitch.Daemonized() {
+		if err := dm.Lock(); err != nil {
+			fatalf("Failed to lock PID file: %v", err)
+		}
+	}
+
+	if *supervise && !vswitch.Supervised() {
+		os.Exit(runSupervisor(dm, level))
+	}
+
+	logWriter, err := setupLogging(*logFile, *daemon, *logFormat, level)
+	if err != nil {
+		fatalf("Failed to setup logging: %v", err)
+	}
+	dm.Logger = slog.Default()
+	slog.Info("Starting Virtual Switch", "version", GetVersion())
+	if *instance != "" {
+		slog.Info("Running as named instance", "instance", *instance)
+	}
+	slog.Info("Configured VLANs", "count", len(portList), "ports", formatPorts(portList))
+
+	drainAfter, err := time.ParseDuration(*drainTimeout)
+	if err != nil || drainAfter < 0 {
+		fatalf("Invalid -upgrade-drain-timeout '%s'", *drainTimeout)
+	}
+	shutdownDrainAfter, err := time.ParseDuration(*shutdownDrain)
+	if err != nil || shutdownDrainAfter < 0 {
+		fatalf("Invalid -drain-timeout '%s'", *shutdownDrain)
+	}
+	statsEvery, err := time.ParseDuration(*statsLogInterval)
+	if err != nil || statsEvery < 0 {
+		fatalf("Invalid -stats-log-interval '%s'", *statsLogInterval)
+	}
+
+	// Configure the VLANs
+	config := vswitch.DefaultConfig()
+	config.Logger = slog.Default()
+	config.MTU = *mtu
+	if config.MACTimeout, err = time.ParseDuration(*macTimeout); err != nil || config.MACTimeout <= 0 {
+		fatalf("Invalid -mac-timeout '%s'", *macTimeout)
+	}
+	if config.BindRetry, err = time.ParseDuration(*bindRetry); err != nil || config.BindRetry < 0 {
+		fatalf("Invalid -bind-retry '%s'", *bindRetry)
+	}
+	if *macTableSize < 0 {
+		fatalf("Invalid -mac-table-size: must not be negative")
+	}
+	config.MACTableSize = *macTableSize
+	if config.ListenFamily, _, err = parseListenFamilies(*listenFamily); err != nil {
+		fatalf("Invalid -listen-family: %v", err)
+	}
+	config.StickyMAC = *stickyMAC
+	config.Socket = vswitch.SocketOptions{
+		Nagle:         !*tcpNoDelay,
+		SendBuffer:    *socketSndBuf,
+		ReceiveBuffer: *socketRcvBuf,
+		Backlog:       *listenBacklog,
+	}
+	if config.Socket.KeepAlive, err = time.ParseDuration(*tcpKeepAlive); err != nil || config.Socket.KeepAlive < 0 {
+		fatalf("Invalid -tcp-keepalive '%s'", *tcpKeepAlive)
+	} else if config.Socket.KeepAlive == 0 {
+		config.Socket.KeepAlive = -1
+	}
+	config.PrivateVLAN = *privateVLAN
+	config.Hairpin = *hairpin
+	config.MACStateDir = *macStateDir
+	config.CountersDir = *countersDir
+	config.MACMoveAnnounce = *macMoveAnnounce
+	config.LLDP = *lldp
+	if *workers < 0 {
+		fatalf("Invalid -workers: must not be negative")
+	}
+	config.Workers = *workers
+	if *egressQueue < 0 {
+		fatalf("Invalid -egress-queue: must not be negative")
+	}
+	config.EgressQueueSize = *egressQueue
+	if config.SlowConsumerPolicy, err = vswitch.ParseSlowConsumerPolicy(*slowConsumer); err != nil {
+		fatalf("Invalid -slow-consumer: %v", err)
+	}
+	if config.SlowConsumerTimeout, err = time.ParseDuration(*slowTimeout); err != nil || config.SlowConsumerTimeout < 0 {
+		fatalf("Invalid -slow-consumer-timeout '%s'", *slowTimeout)
+	}
+	config.DrainFIN = *drainFIN
+	if *trunkBroadcast < 0 || *trunkUnknown < 0 || *trunkMulticast < 0 {
+		fatalf("Invalid trunk limits: must not be negative")
+	}
+	config.TrunkBUMLimits = vswitch.BUMLimits{Broadcast: *trunkBroadcast, UnknownUnicast: *trunkUnknown, Multicast: *trunkMulticast}
+	config.DHCPSnooping = *dhcpSnooping
+	config.IPInventory = *ipInventory
+	config.RAGuard = *raGuard
+	config.NDInspection = *ndInspection
+	config.FastPath = *xdp
+	if config.TrustedPeers, err = vswitch.ParseCIDRList(*trustedPeers); err != nil {
+		fatalf("Invalid trusted peers: %v", err)
+	}
+	if config.PromiscuousPeers, err = vswitch.ParseCIDRList(*promiscuousPeers); err != nil {
+		fatalf("Invalid promiscuous peers: %v", err)
+	}
+	if *sflowCollector != "" {
+		if config.SFlow, err = parseSFlowConfig(*sflowCollector, *sflowRate, *sflowInterval); err != nil {
+			fatalf("Invalid sFlow configuration: %v", err)
+		}
+	}
+	if *protocolStats < 0 {
+		fatalf("Invalid -protocol-stats: must not be negative")
+	}
+	config.ProtocolStats = *protocolStats
+	var webhook *vswitch.Webhook
+	if *macWebhook != "" {
+		if _, err := url.ParseRequestURI(*macWebhook); err != nil {
+			fatalf("Invalid -mac-webhook: %v", err)
+		}
+		webhook = vswitch.NewWebhook(*macWebhook, slog.Default())
+		config.MACEventHandler = func(event vswitch.MACEvent) { webhook.Send(event) }
+	}
+	if *ouiFile != "" {
+		loaded, err := vswitch.LoadOUIFile(*ouiFile)
+		if err != nil {
+			fatalf("Invalid -oui-file: %v", err)
 		}
+		slog.Info("Loaded OUI database", "path", *ouiFile, "vendors", loaded)
+	}
+	vlanConfigs, err := buildVLANConfigs(config, portList)
+	if err != nil {
+		fatalf("Invalid VLAN configuration: %v", err)
 	}
 
-	// Start all VLANs
-	if err := sm.StartAll(); err != nil {
-		log.Fatalf("Failed to start VLANs: %v", err)
+	// Create and start the VLANs
+	sm, err := vswitch.New(context.Background(), vswitch.Options{
+		Ports:         portList,
+		ExtraPorts:    extraPorts,
+		Config:        &config,
+		VLANs:         vlanConfigs,
+		Instance:      *instance,
+		AllowDegraded: *allowDegraded,
+	})
+	if err != nil {
+		fatalf("Failed to start VLANs: %v", err)
 	}
 
 	// Set up signal handling for graceful shutdown
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	if upgradeSignal != nil {
+		signal.Notify(sigChan, upgradeSignal)
+	}
+
+	// Reopen the log file when logrotate signals that it moved it away
+	if logWriter != nil && reopenSignal != nil {
+		reopenChan := make(chan os.Signal, 1)
+		signal.Notify(reopenChan, reopenSignal)
+		go func() {
+			for range reopenChan {
+				if err := logWriter.Reopen(); err != nil {
+					fmt.Fprintf(os.Stderr, "Failed to reopen log file: %v\n", err)
+					continue
+				}
+				slog.Info("Reopened log file", "path", *logFile)
+			}
+		}()
+	}
+
+	// Record management operations before accepting any
+	var auditLog *vswitch.AuditLog
+	if *auditLogPath != "" {
+		if auditLog, err = vswitch.OpenAuditLog(*auditLogPath); err != nil {
+			fatalf("Failed to open audit log: %v", err)
+		}
+		sm.SetAuditLog(auditLog)
+	}
 
 	// Start statistics reporting if enabled
+	var statsServer *http.Server
+	if *apiToken != "" && *statsPort == 0 {
+		slog.Warn("-api-token has no effect without -stats-port")
+	}
 	if *statsPort > 0 {
-		go startStatsServer(sm, *statsPort)
+		if statsServer, err = startStatsServer(sm, *statsPort, *apiToken); err != nil {
+			slog.Error("Statistics server failed", "error", err)
+		}
+	}
+
+	// Start the control socket for the ctl subcommands
+	var controlServer *http.Server
+	if *controlSocket != "" {
+		if controlServer, err = startControlServer(sm, *controlSocket); err != nil {
+			slog.Error("Control socket disabled", "error", err)
+		}
+	}
+
+	// Serve the gRPC control-plane API
+	var grpcServer *grpc.Server
+	if *grpcListen != "" {
+		if grpcServer, err = startGRPCServer(sm, *grpcListen, *apiToken); err != nil {
+			slog.Error("gRPC server disabled", "error", err)
+		}
+	}
+
+	// Let Docker attach containers to the VLANs
+	var pluginServer *http.Server
+	if *dockerPlugin != "" {
+		if pluginServer, err = startPluginServer(sm, *dockerPlugin); err != nil {
+			slog.Error("Docker network driver disabled", "error", err)
+		}
+		if *runAsUser != "" {
+			slog.Warn("-docker-plugin needs root to create TAP devices; containers cannot join after -user drops privileges")
+		}
+	}
+
+	// Place TLS clients on VLANs by their certificate
+	var tlsEndpoint *vswitch.TLSEndpoint
+	if *tlsListen != "" {
+		if tlsEndpoint, err = startTLSEndpoint(sm, *tlsListen, portList); err != nil {
+			slog.Error("TLS endpoint disabled", "error", err)
+		}
+	}
+
+	// Join the VLANs with those of other hosts
+	var cluster *vswitch.Cluster
+	if *clusterNode != "" {
+		if cluster, err = startCluster(sm); err != nil {
+			slog.Error("Clustering disabled", "error", err)
+		}
+	}
+
+	// All sockets are open, so root is no longer needed
+	if *runAsUser != "" {
+		if err := vswitch.DropPrivileges(*runAsUser, *runAsGroup); err != nil {
+			fatalf("Failed to drop privileges: %v", err)
+		}
+		slog.Info("Dropped privileges", "uid", os.Getuid(), "gid", os.Getgid())
+	} else if *runAsGroup != "" {
+		fatalf("-group requires -user")
 	}
 
 	// Start periodic statistics logging
-	go logStatsPeriodically(sm, 60*time.Second)
+	if statsEvery > 0 {
+		go logStatsPeriodically(sm, statsEvery, *statsLogVLANs)
+	}
+
+	// Let the process being upgraded stop accepting
+	if err := vswitch.CompleteUpgrade(); err != nil {
+		fatalf("Failed to complete upgrade: %v", err)
+	}
+
+	// Tell systemd or the supervisor that the switch is up, and keep its
+	// watchdog informed while the forwarding loops are alive
+	if err := vswitch.Notify("READY=1"); err != nil {
+		slog.Warn("Failed to notify service manager", "error", err)
+	}
+	if timeout := vswitch.WatchdogTimeout(); timeout > 0 {
+		slog.Info("Watchdog enabled", "timeout", timeout)
+		go sm.RunWatchdog(timeout)
+	}
 
-	log.Printf("Virtual switch started with %d isolated VLANs. Press Ctrl+C to stop.", len(portList))
+	slog.Info("Virtual switch started. Press Ctrl+C to stop.", "vlans", len(portList))
+
+	// Wait for a shutdown signal; SIGUSR2 hands over to a new process first
+	upgraded := false
+	for !upgraded {
+		sig := <-sigChan
+		if sig != upgradeSignal {
+			slog.Info("Received signal, shutting down", "signal", sig.String())
+			break
+		}
+		upgraded = upgrade(dm, sm, drainAfter)
+	}
 
-	// Wait for shutdown signal
-	sig := <-sigChan
-	log.Printf("Received signal %s, shutting down...", sig)
+	// Stop accepting and flush the connections while the servers still
+	// report the switch as draining; after an upgrade they were drained
+	if !upgraded && shutdownDrainAfter > 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownDrainAfter)
+		_ = sm.Shutdown(ctx)
+		cancel()
+	}
 
 	// Graceful shutdown
+	if statsServer != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		if err := statsServer.Shutdown(ctx); err != nil {
+			slog.Warn("Statistics server shutdown", "error", err)
+		}
+		cancel()
+	}
+	if controlServer != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		if err := controlServer.Shutdown(ctx); err != nil {
+			slog.Warn("Control server shutdown", "error", err)
+		}
+		cancel()
+	}
+	if grpcServer != nil {
+		// Event and statistics streams only end when their client or the
+		// server stops them
+		stopped := make(chan struct{})
+		go func() {
+			grpcServer.GracefulStop()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+		case <-time.After(5 * time.Second):
+			grpcServer.Stop()
+		}
+	}
+	if pluginServer != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		if err := pluginServer.Shutdown(ctx); err != nil {
+			slog.Warn("Docker network driver shutdown", "error", err)
+		}
+		cancel()
+	}
+	if tlsEndpoint != nil {
+		_ = tlsEndpoint.Close()
+	}
+	if cluster != nil {
+		_ = cluster.Close()
+	}
 	sm.StopAll()
+	if webhook != nil {
+		webhook.Close()
+	}
+	if auditLog != nil {
+		_ = auditLog.Close()
+	}
 
-	// Clean up daemon artifacts if running as daemon
-	if filepath.Base(os.Args[0]) != "main" { // Simple check if running as daemon
+	// Clean up daemon artifacts if running as daemon; after an upgrade they
+	// belong to the new process
+	if !upgraded {
 		dm.Cleanup()
 	}
 
-	log.Printf("Virtual switch stopped")
+	slog.Info("Virtual switch stopped")
+	if logWriter != nil {
+		_ = logWriter.Close()
+	}
 }
 
-// parsePorts parses a comma-separated list of port numbers
+// parsePorts parses a comma-separated list of port numbers, ranges
+// (9000-9015) and counts of consecutive ports (9000+8)
 func parsePorts(portStr string) ([]int, error) {
 	if portStr == "" {
 		return nil, fmt.Errorf("empty port string")
@@ -213,6 +756,7 @@ func parsePorts(portStr string) ([]int, error) {
 
 	portStrs := strings.Split(portStr, ",")
 	ports := make([]int, 0, len(portStrs))
+	seen := make(map[int]bool)
 
 	for _, str := range portStrs {
 		str = strings.TrimSpace(str)
@@ -220,35 +764,1098 @@ func parsePorts(portStr string) ([]int, error) {
 			continue
 		}
 
-		port, err := strconv.Atoi(str)
+		first, last, err := parsePortRange(str)
+		if err != nil {
+			return nil, err
+		}
+
+		for port := first; port <= last; port++ {
+			if seen[port] {
+				return nil, fmt.Errorf("port %d listed twice", port)
+			}
+			seen[port] = true
+			ports = append(ports, port)
+		}
+	}
+
+	return ports, nil
+}
+
+// parseVLANPorts parses -ports: ports as parsePorts parses them, except
+// that ports named with port=name, or range=name, feed the single VLAN of
+// that name. It returns the port identifying each VLAN, the first of its
+// ports, and the other ports of the named VLANs by that port.
+func parseVLANPorts(portStr string) ([]int, map[int][]int, error) {
+	var vlans []int
+	extra := make(map[int][]int)
+	named := make(map[string]int)
+	seen := make(map[int]bool)
+
+	for _, term := range strings.Split(portStr, ",") {
+		spec, name, hasName := strings.Cut(strings.TrimSpace(term), "=")
+		if spec == "" && !hasName {
+			continue
+		}
+		if hasName && name == "" {
+			return nil, nil, fmt.Errorf("empty VLAN name in '%s'", term)
+		}
+		ports, err := parsePorts(spec)
 		if err != nil {
-			return nil, fmt.Errorf("invalid port '%s': %v", str, err)
+			return nil, nil, err
+		}
+		for _, port := range ports {
+			if seen[port] {
+				return nil, nil, fmt.Errorf("port %d listed twice", port)
+			}
+			seen[port] = true
+		}
+
+		if !hasName {
+			vlans = append(vlans, ports...)
+			continue
+		}
+		if primary, found := named[name]; found {
+			extra[primary] = append(extra[primary], ports...)
+			continue
 		}
+		named[name] = ports[0]
+		vlans = append(vlans, ports[0])
+		if len(ports) > 1 {
+			extra[ports[0]] = ports[1:]
+		}
+	}
+
+	return vlans, extra, nil
+}
 
+// parsePortRange parses a port, a range of ports or a count of consecutive
+// ports, returning the first and last port
+func parsePortRange(str string) (int, int, error) {
+	parsePort := func(s string) (int, error) {
+		port, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil {
+			return 0, fmt.Errorf("invalid port '%s': %v", s, err)
+		}
 		if port < 1 || port > 65535 {
-			return nil, fmt.Errorf("port %d out of range (1-65535)", port)
+			return 0, fmt.Errorf("port %d out of range (1-65535)", port)
+		}
+		return port, nil
+	}
+
+	if start, end, ok := strings.Cut(str, "-"); ok {
+		first, err := parsePort(start)
+		if err != nil {
+			return 0, 0, err
+		}
+		last, err := parsePort(end)
+		if err != nil {
+			return 0, 0, err
 		}
+		if last < first {
+			return 0, 0, fmt.Errorf("invalid port range '%s': end before start", str)
+		}
+		return first, last, nil
+	}
 
-		ports = append(ports, port)
+	if start, count, ok := strings.Cut(str, "+"); ok {
+		first, err := parsePort(start)
+		if err != nil {
+			return 0, 0, err
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(count))
+		if err != nil || n < 1 {
+			return 0, 0, fmt.Errorf("invalid port count '%s'", count)
+		}
+		if first+n-1 > 65535 {
+			return 0, 0, fmt.Errorf("port range '%s' out of range (1-65535)", str)
+		}
+		return first, first + n - 1, nil
 	}
 
-	return ports, nil
+	port, err := parsePort(str)
+	return port, port, err
 }
 
-// logStatsPeriodically logs switch statistics periodically
-func logStatsPeriodically(sm *vswitch.SwitchManager, interval time.Duration) {
-	ticker := time.NewTicker(interval)
-	defer ticker.Stop()
+// formatPorts lists ports in the syntax of -ports, collapsing runs of
+// consecutive ports into ranges
+func formatPorts(ports []int) string {
+	sorted := slices.Clone(ports)
+	slices.Sort(sorted)
 
-	for range ticker.C {
-		stats := sm.GetStats()
-		log.Printf("Stats: %d VLANs, %d total connections, %d MAC entries, %d total frames (%d unicast, %d broadcast, %d dropped)",
-			stats["vlan_count"], stats["total_connections"], stats["total_mac_entries"], stats["total_frames"],
-			stats["unicast_frames"], stats["broadcast_frames"], stats["dropped_frames"])
+	var parts []string
+	for i := 0; i < len(sorted); {
+		j := i
+		for j+1 < len(sorted) && sorted[j+1] == sorted[j]+1 {
+			j++
+		}
+		if j > i {
+			parts = append(parts, fmt.Sprintf("%d-%d", sorted[i], sorted[j]))
+		} else {
+			parts = append(parts, strconv.Itoa(sorted[i]))
+		}
+		i = j + 1
+	}
+	return strings.Join(parts, ",")
+}
+
+// parsePortAssignments parses a comma-separated list of port=value pairs,
+// collecting the values assigned to each port
+func parsePortAssignments(spec string) (map[int][]string, error) {
+	assignments := make(map[int][]string)
+
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		portStr, value, found := strings.Cut(item, "=")
+		if !found {
+			return nil, fmt.Errorf("invalid assignment '%s': expected port=value", item)
+		}
+
+		port, err := strconv.Atoi(strings.TrimSpace(portStr))
+		if err != nil {
+			return nil, fmt.Errorf("invalid port in '%s': %v", item, err)
+		}
+
+		assignments[port] = append(assignments[port], strings.TrimSpace(value))
+	}
+
+	return assignments, nil
+}
+
+// parseTagDemux parses the -tag-demux terms of a VLAN: all, VLAN IDs and
+// ranges of them, and native:<vid>. It returns the VLAN IDs carried, none
+// for all, and the native VLAN ID.
+func parseTagDemux(terms []string) ([]int, int, error) {
+	var vids []int
+	native := 0
+	all := false
+	for _, term := range terms {
+		value, isNative := strings.CutPrefix(term, "native:")
+		switch {
+		case term == "all":
+			all = true
+		case isNative:
+			vid, err := strconv.Atoi(value)
+			if err != nil || native != 0 {
+				return nil, 0, fmt.Errorf("invalid native VLAN ID '%s'", term)
+			}
+			native = vid
+		default:
+			first, last := term, term
+			if start, end, ok := strings.Cut(term, "-"); ok {
+				first, last = start, end
+			}
+			from, err := strconv.Atoi(first)
+			if err != nil {
+				return nil, 0, fmt.Errorf("invalid VLAN ID '%s'", term)
+			}
+			to, err := strconv.Atoi(last)
+			if err != nil || to < from || to-from > 4094 {
+				return nil, 0, fmt.Errorf("invalid VLAN ID range '%s'", term)
+			}
+			for vid := from; vid <= to; vid++ {
+				vids = append(vids, vid)
+			}
+		}
+	}
+	if all {
+		if len(vids) > 0 {
+			return nil, 0, fmt.Errorf("all carries every VLAN ID, listing some is ambiguous")
+		}
+	} else if len(vids) == 0 && native == 0 {
+		return nil, 0, fmt.Errorf("expected all, VLAN IDs or native:<vid>")
+	}
+	return vids, native, nil
+}
+
+// parseListenFamilies parses the address family of all VLANs and the
+// port=family assignments overriding it, e.g. ipv4,9999=ipv6
+func parseListenFamilies(spec string) (vswitch.AddressFamily, map[int]vswitch.AddressFamily, error) {
+	defaultFamily := vswitch.FamilyDual
+	families := make(map[int]vswitch.AddressFamily)
+
+	for _, item := range strings.Split(spec, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+
+		portStr, name, found := strings.Cut(item, "=")
+		if !found {
+			name = item
+		}
+		family, err := vswitch.ParseAddressFamily(strings.TrimSpace(name))
+		if err != nil {
+			return 0, nil, err
+		}
+		if !found {
+			defaultFamily = family
+			continue
+		}
+
+		port, err := strconv.Atoi(strings.TrimSpace(portStr))
+		if err != nil {
+			return 0, nil, fmt.Errorf("invalid port in '%s': %v", item, err)
+		}
+		families[port] = family
 	}
+
+	return defaultFamily, families, nil
 }
 
-// startStatsServer starts a simple HTTP server for statistics (placeholder)
-func startStatsServer(_ *vswitch.SwitchManager, port int) {
-	log.Printf("Statistics server would be started on port %d (not implemented yet)", port)
+// buildVLANConfigs derives the configuration of each VLAN from the base
+// configuration and the per-VLAN flags
+func buildVLANConfigs(base vswitch.Config, portList []int) (map[int]vswitch.Config, error) {
+	prefixes, err := parsePortAssignments(*slaac)
+	if err != nil {
+		return nil, fmt.Errorf("-slaac: %v", err)
+	}
+
+	dnsServers, err := parsePortAssignments(*slaacDNS)
+	if err != nil {
+		return nil, fmt.Errorf("-slaac-dns: %v", err)
+	}
+
+	dnsAssignments, err := parsePortAssignments(*dnsAddrs)
+	if err != nil {
+		return nil, fmt.Errorf("-dns: %v", err)
+	}
+
+	allowAssignments, err := parsePortAssignments(*allowClients)
+	if err != nil {
+		return nil, fmt.Errorf("-allow-clients: %v", err)
+	}
+
+	denyAssignments, err := parsePortAssignments(*denyClients)
+	if err != nil {
+		return nil, fmt.Errorf("-deny-clients: %v", err)
+	}
+
+	horizonAssignments, err := parsePortAssignments(*horizonGroups)
+	if err != nil {
+		return nil, fmt.Errorf("-horizon-groups: %v", err)
+	}
+
+	records := make(map[string]net.IP)
+	for _, item := range strings.Split(*dnsRecords, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		name, addr, _ := strings.Cut(item, "=")
+		ip := net.ParseIP(strings.TrimSpace(addr))
+		if ip == nil || strings.TrimSpace(name) == "" {
+			return nil, fmt.Errorf("-dns-records: invalid record '%s': expected name=ip", item)
+		}
+		records[strings.TrimSpace(name)] = ip
+	}
+
+	proxyARPAssignments, err := parsePortAssignments(*proxyARP)
+	if err != nil {
+		return nil, fmt.Errorf("-proxy-arp: %v", err)
+	}
+
+	natAssignments, err := parsePortAssignments(*natGateways)
+	if err != nil {
+		return nil, fmt.Errorf("-nat: %v", err)
+	}
+
+	forwardAssignments, err := parsePortAssignments(*natForwards)
+	if err != nil {
+		return nil, fmt.Errorf("-nat-forwards: %v", err)
+	}
+
+	dhcpAssignments, err := parsePortAssignments(*dhcpServers)
+	if err != nil {
+		return nil, fmt.Errorf("-dhcp-server: %v", err)
+	}
+
+	rangeAssignments, err := parsePortAssignments(*dhcpRanges)
+	if err != nil {
+		return nil, fmt.Errorf("-dhcp-range: %v", err)
+	}
+
+	leaseTime, err := time.ParseDuration(*dhcpLeaseTime)
+	if err != nil || leaseTime < time.Minute {
+		return nil, fmt.Errorf("-dhcp-lease-time: invalid duration '%s'", *dhcpLeaseTime)
+	}
+
+	bootAssignments, err := parsePortAssignments(*pxeBoot)
+	if err != nil {
+		return nil, fmt.Errorf("-pxe-boot: %v", err)
+	}
+
+	uefiAssignments, err := parsePortAssignments(*pxeBootUEFI)
+	if err != nil {
+		return nil, fmt.Errorf("-pxe-boot-uefi: %v", err)
+	}
+
+	tftpAssignments, err := parsePortAssignments(*tftpRoots)
+	if err != nil {
+		return nil, fmt.Errorf("-tftp-root: %v", err)
+	}
+
+	openflowAssignments, err := parsePortAssignments(*openflowCtrls)
+	if err != nil {
+		return nil, fmt.Errorf("-openflow: %v", err)
+	}
+
+	captureAssignments, err := parsePortAssignments(*captureListen)
+	if err != nil {
+		return nil, fmt.Errorf("-capture-listen: %v", err)
+	}
+
+	vmnetAssignments, err := parsePortAssignments(*vmnetSockets)
+	if err != nil {
+		return nil, fmt.Errorf("-vmnet: %v", err)
+	}
+
+	_, families, err := parseListenFamilies(*listenFamily)
+	if err != nil {
+		return nil, fmt.Errorf("-listen-family: %v", err)
+	}
+
+	validationAssignments, err := parsePortAssignments(*frameValidation)
+	if err != nil {
+		return nil, fmt.Errorf("-frame-validation: %v", err)
+	}
+
+	bandwidthAssignments, err := parsePortAssignments(*bandwidthLimits)
+	if err != nil {
+		return nil, fmt.Errorf("-bandwidth: %v", err)
+	}
+
+	impairmentAssignments, err := parsePortAssignments(*impairments)
+	if err != nil {
+		return nil, fmt.Errorf("-impairment: %v", err)
+	}
+
+	demuxAssignments, err := parsePortAssignments(*tagDemux)
+	if err != nil {
+		return nil, fmt.Errorf("-tag-demux: %v", err)
+	}
+
+	protocolAssignments, err := parsePortAssignments(*protocolVLANs)
+	if err != nil {
+		return nil, fmt.Errorf("-protocol-vlans: %v", err)
+	}
+
+	pskAssignments, err := parsePortAssignments(*psks)
+	if err != nil {
+		return nil, fmt.Errorf("-psk: %v", err)
+	}
+
+	listenerAssignments, err := parsePortAssignments(*listenersPerPort)
+	if err != nil {
+		return nil, fmt.Errorf("-listeners-per-port: %v", err)
+	}
+
+	pipeAssignments, err := parsePortAssignments(*namedPipes)
+	if err != nil {
+		return nil, fmt.Errorf("-pipes: %v", err)
+	}
+
+	padded := make(map[int]bool)
+	if *padFrames != "" {
+		padPorts, err := parsePorts(*padFrames)
+		if err != nil {
+			return nil, fmt.Errorf("-pad-frames: %v", err)
+		}
+		for _, port := range padPorts {
+			padded[port] = true
+		}
+	}
+
+	unflooded := make(map[int]bool)
+	if *dropUnknown != "" {
+		dropPorts, err := parsePorts(*dropUnknown)
+		if err != nil {
+			return nil, fmt.Errorf("-drop-unknown-unicast: %v", err)
+		}
+		for _, port := range dropPorts {
+			unflooded[port] = true
+		}
+	}
+
+	suppressed := make(map[int]bool)
+	if *arpSuppression != "" {
+		suppressPorts, err := parsePorts(*arpSuppression)
+		if err != nil {
+			return nil, fmt.Errorf("-arp-suppression: %v", err)
+		}
+		for _, port := range suppressPorts {
+			suppressed[port] = true
+		}
+	}
+
+	var upstreams []string
+	for _, upstream := range strings.Split(*dnsUpstreams, ",") {
+		if upstream = strings.TrimSpace(upstream); upstream != "" {
+			if _, _, err := net.SplitHostPort(upstream); err != nil {
+				upstream = net.JoinHostPort(upstream, "53")
+			}
+			upstreams = append(upstreams, upstream)
+		}
+	}
+
+	configs := make(map[int]vswitch.Config, len(portList))
+	for _, port := range portList {
+		config := base
+		config.PadFrames = padded[port]
+		config.ARPSuppression = suppressed[port]
+		config.DropUnknownUnicast = unflooded[port]
+		if family, found := families[port]; found {
+			config.ListenFamily = family
+		}
+
+		if config.AllowedClients, err = vswitch.ParseCIDRList(strings.Join(allowAssignments[port], ",")); err != nil {
+			return nil, fmt.Errorf("-allow-clients: %v", err)
+		}
+		if config.DeniedClients, err = vswitch.ParseCIDRList(strings.Join(denyAssignments[port], ",")); err != nil {
+			return nil, fmt.Errorf("-deny-clients: %v", err)
+		}
+		if config.HorizonGroups, err = parseHorizonGroups(horizonAssignments[port]); err != nil {
+			return nil, fmt.Errorf("-horizon-groups: %v", err)
+		}
+
+		if addrs := dnsAssignments[port]; len(addrs) > 0 {
+			ip := net.ParseIP(addrs[0])
+			if ip == nil || ip.To4() == nil || len(addrs) > 1 {
+				return nil, fmt.Errorf("-dns: port %d needs exactly one IPv4 address", port)
+			}
+			config.DNSAddr = ip.To4()
+			config.DNSUpstreams = upstreams
+			config.DNSRecords = records
+		}
+
+		for _, prefix := range prefixes[port] {
+			ip, ipNet, err := net.ParseCIDR(prefix)
+			if err != nil {
+				return nil, fmt.Errorf("-slaac: invalid prefix '%s': %v", prefix, err)
+			}
+			if ones, bits := ipNet.Mask.Size(); ip.To4() != nil || ones != 64 || bits != 128 {
+				return nil, fmt.Errorf("-slaac: prefix '%s' must be an IPv6 /64", prefix)
+			}
+			config.SLAACPrefixes = append(config.SLAACPrefixes, ipNet)
+		}
+
+		for _, server := range dnsServers[port] {
+			ip := net.ParseIP(server)
+			if ip == nil || ip.To4() != nil {
+				return nil, fmt.Errorf("-slaac-dns: invalid IPv6 address '%s'", server)
+			}
+			config.SLAACDNS = append(config.SLAACDNS, ip)
+		}
+
+		for _, entry := range proxyARPAssignments[port] {
+			addr, macStr, hasMAC := strings.Cut(entry, "@")
+			ip := net.ParseIP(addr)
+			if ip == nil || ip.To4() == nil {
+				return nil, fmt.Errorf("-proxy-arp: invalid IPv4 address '%s'", addr)
+			}
+			arpEntry := vswitch.ProxyARPEntry{IP: ip.To4()}
+			if hasMAC {
+				if arpEntry.MAC, err = net.ParseMAC(macStr); err != nil {
+					return nil, fmt.Errorf("-proxy-arp: invalid MAC '%s': %v", macStr, err)
+				}
+			}
+			config.ProxyARP = append(config.ProxyARP, arpEntry)
+		}
+
+		if gateways := natAssignments[port]; len(gateways) > 0 {
+			ip := net.ParseIP(gateways[0])
+			if ip == nil || ip.To4() == nil || len(gateways) > 1 {
+				return nil, fmt.Errorf("-nat: port %d needs exactly one IPv4 gateway address", port)
+			}
+			config.NAT = &vswitch.NATConfig{Gateway: ip.To4(), AllowLoopback: *natLoopback}
+		}
+
+		for _, entry := range forwardAssignments[port] {
+			if config.NAT == nil {
+				return nil, fmt.Errorf("-nat-forwards: port %d has no NAT gateway", port)
+			}
+			forward, err := parsePortForward(entry)
+			if err != nil {
+				return nil, fmt.Errorf("-nat-forwards: %v", err)
+			}
+			config.NAT.PortForwards = append(config.NAT.PortForwards, forward)
+		}
+
+		if servers := dhcpAssignments[port]; len(servers) > 0 {
+			ip, subnet, err := net.ParseCIDR(servers[0])
+			if err != nil || ip.To4() == nil || len(servers) > 1 {
+				return nil, fmt.Errorf("-dhcp-server: port %d needs exactly one IPv4 address/prefix", port)
+			}
+			server := &vswitch.DHCPServerConfig{ServerIP: ip.To4(), Subnet: subnet, LeaseTime: leaseTime}
+
+			if ranges := rangeAssignments[port]; len(ranges) > 0 {
+				start, end, _ := strings.Cut(ranges[0], "-")
+				server.RangeStart = net.ParseIP(strings.TrimSpace(start)).To4()
+				server.RangeEnd = net.ParseIP(strings.TrimSpace(end)).To4()
+				if server.RangeStart == nil || server.RangeEnd == nil || len(ranges) > 1 ||
+					!subnet.Contains(server.RangeStart) || !subnet.Contains(server.RangeEnd) {
+					return nil, fmt.Errorf("-dhcp-range: invalid range '%s' for subnet %s", ranges[0], subnet)
+				}
+			}
+
+			if files := bootAssignments[port]; len(files) > 0 {
+				server.BootFile = files[0]
+			}
+			if files := uefiAssignments[port]; len(files) > 0 {
+				server.BootFileUEFI = files[0]
+			}
+			config.DHCPServer = server
+		}
+
+		if roots := tftpAssignments[port]; len(roots) > 0 {
+			if config.DHCPServer == nil && config.NAT == nil && config.DNSAddr == nil {
+				return nil, fmt.Errorf("-tftp-root: port %d needs a -dhcp-server, -nat or -dns address to serve on", port)
+			}
+			if info, err := os.Stat(roots[0]); err != nil || !info.IsDir() {
+				return nil, fmt.Errorf("-tftp-root: '%s' is not a directory", roots[0])
+			}
+			config.TFTPRoot = roots[0]
+		}
+
+		if controllers := openflowAssignments[port]; len(controllers) > 0 {
+			if _, _, err := net.SplitHostPort(controllers[0]); err != nil || len(controllers) > 1 {
+				return nil, fmt.Errorf("-openflow: port %d needs exactly one controller host:port", port)
+			}
+			config.OpenFlow = &vswitch.OpenFlowConfig{Controller: controllers[0]}
+		}
+
+		if addrs := captureAssignments[port]; len(addrs) > 0 {
+			if _, _, err := net.SplitHostPort(addrs[0]); err != nil || len(addrs) > 1 {
+				return nil, fmt.Errorf("-capture-listen: port %d needs exactly one host:port", port)
+			}
+			config.CaptureAddr = addrs[0]
+		}
+
+		if paths := vmnetAssignments[port]; len(paths) > 0 {
+			if len(paths) > 1 {
+				return nil, fmt.Errorf("-vmnet: port %d needs exactly one socket", port)
+			}
+			config.VMNetSocket = paths[0]
+		}
+
+		if modes := validationAssignments[port]; len(modes) > 0 {
+			if len(modes) > 1 {
+				return nil, fmt.Errorf("-frame-validation: port %d needs exactly one mode", port)
+			}
+			if config.Validation, err = vswitch.ParseValidationMode(modes[0]); err != nil {
+				return nil, fmt.Errorf("-frame-validation: %v", err)
+			}
+		}
+
+		if limits := bandwidthAssignments[port]; len(limits) > 0 {
+			if len(limits) > 1 {
+				return nil, fmt.Errorf("-bandwidth: port %d needs exactly one limit", port)
+			}
+			if config.Bandwidth, err = vswitch.ParseBandwidth(limits[0]); err != nil {
+				return nil, fmt.Errorf("-bandwidth: port %d: %v", port, err)
+			}
+		}
+
+		if terms := impairmentAssignments[port]; len(terms) > 0 {
+			if config.Impairment, err = vswitch.ParseImpairment(strings.Join(terms, ",")); err != nil {
+				return nil, fmt.Errorf("-impairment: port %d: %v", port, err)
+			}
+		}
+
+		if terms := demuxAssignments[port]; len(terms) > 0 {
+			config.TagDemux = true
+			if config.TagDemuxVIDs, config.NativeVID, err = parseTagDemux(terms); err != nil {
+				return nil, fmt.Errorf("-tag-demux: port %d: %v", port, err)
+			}
+		}
+
+		for _, spec := range protocolAssignments[port] {
+			assignment, err := vswitch.ParseProtocolVLAN(spec)
+			if err != nil {
+				return nil, fmt.Errorf("-protocol-vlans: port %d: %v", port, err)
+			}
+			if !slices.Contains(portList, assignment.VLAN) || assignment.VLAN == port {
+				return nil, fmt.Errorf("-protocol-vlans: port %d: %d is not another configured VLAN", port, assignment.VLAN)
+			}
+			config.ProtocolVLANs = append(config.ProtocolVLANs, assignment)
+		}
+
+		if keys := pskAssignments[port]; len(keys) > 0 {
+			if len(keys) > 1 || keys[0] == "" {
+				return nil, fmt.Errorf("-psk: port %d needs exactly one key", port)
+			}
+			config.PSK = keys[0]
+		}
+
+		if counts := listenerAssignments[port]; len(counts) > 0 {
+			if config.Socket.Listeners, err = strconv.Atoi(counts[0]); err != nil || config.Socket.Listeners < 1 || len(counts) > 1 {
+				return nil, fmt.Errorf("-listeners-per-port: port %d needs exactly one positive count", port)
+			}
+		}
+
+		if pipes := pipeAssignments[port]; len(pipes) > 0 {
+			if len(pipes) > 1 || pipes[0] == "" {
+				return nil, fmt.Errorf("-pipes: port %d needs exactly one pipe", port)
+			}
+			config.Pipe = pipes[0]
+		}
+
+		configs[port] = config
+	}
+
+	for flagName, assignments := range map[string]map[int][]string{"-allow-clients": allowAssignments, "-deny-clients": denyAssignments, "-horizon-groups": horizonAssignments, "-slaac": prefixes, "-slaac-dns": dnsServers, "-dns": dnsAssignments, "-proxy-arp": proxyARPAssignments, "-nat": natAssignments, "-nat-forwards": forwardAssignments,
+		"-dhcp-server": dhcpAssignments, "-tftp-root": tftpAssignments, "-openflow": openflowAssignments, "-capture-listen": captureAssignments, "-vmnet": vmnetAssignments,
+		"-frame-validation": validationAssignments, "-bandwidth": bandwidthAssignments, "-impairment": impairmentAssignments, "-tag-demux": demuxAssignments, "-protocol-vlans": protocolAssignments, "-psk": pskAssignments, "-listeners-per-port": listenerAssignments, "-pipes": pipeAssignments} {
+		for port := range assignments {
+			if _, ok := configs[port]; !ok {
+				return nil, fmt.Errorf("%s: port %d is not a configured VLAN", flagName, port)
+			}
+		}
+	}
+
+	for port := range padded {
+		if _, ok := configs[port]; !ok {
+			return nil, fmt.Errorf("-pad-frames: port %d is not a configured VLAN", port)
+		}
+	}
+	for port := range unflooded {
+		if _, ok := configs[port]; !ok {
+			return nil, fmt.Errorf("-drop-unknown-unicast: port %d is not a configured VLAN", port)
+		}
+	}
+	for port := range suppressed {
+		if _, ok := configs[port]; !ok {
+			return nil, fmt.Errorf("-arp-suppression: port %d is not a configured VLAN", port)
+		}
+	}
+	for port := range families {
+		if _, ok := configs[port]; !ok {
+			return nil, fmt.Errorf("-listen-family: port %d is not a configured VLAN", port)
+		}
+	}
+
+	for flagName, assignments := range map[string]map[int][]string{"-dhcp-range": rangeAssignments, "-pxe-boot": bootAssignments, "-pxe-boot-uefi": uefiAssignments} {
+		for port := range assignments {
+			if _, ok := dhcpAssignments[port]; !ok {
+				return nil, fmt.Errorf("%s: port %d has no DHCP server", flagName, port)
+			}
+		}
+	}
+
+	return configs, nil
+}
+
+// parseSFlowConfig validates the sFlow export settings
+func parseSFlowConfig(collector string, rate int, interval string) (*vswitch.SFlowConfig, error) {
+	if _, _, err := net.SplitHostPort(collector); err != nil {
+		return nil, fmt.Errorf("-sflow-collector: invalid address '%s': %v", collector, err)
+	}
+	if rate < 1 {
+		return nil, fmt.Errorf("-sflow-sampling-rate: must be at least 1")
+	}
+	pollingInterval, err := time.ParseDuration(interval)
+	if err != nil || pollingInterval < 0 {
+		return nil, fmt.Errorf("-sflow-polling-interval: invalid duration '%s'", interval)
+	}
+
+	return &vswitch.SFlowConfig{
+		Collector:       collector,
+		SamplingRate:    uint32(rate),
+		PollingInterval: pollingInterval,
+	}, nil
+}
+
+// parsePortForward parses a NAT port forward of the form
+// proto/host-addr/guest-ip:guest-port
+func parsePortForward(spec string) (vswitch.PortForward, error) {
+	parts := strings.Split(spec, "/")
+	if len(parts) != 3 || (parts[0] != "tcp" && parts[0] != "udp") {
+		return vswitch.PortForward{}, fmt.Errorf("invalid forward '%s': expected tcp|udp/host-addr/guest-ip:port", spec)
+	}
+
+	if _, _, err := net.SplitHostPort(parts[1]); err != nil {
+		return vswitch.PortForward{}, fmt.Errorf("invalid host address '%s': %v", parts[1], err)
+	}
+
+	host, portStr, err := net.SplitHostPort(parts[2])
+	if err != nil {
+		return vswitch.PortForward{}, fmt.Errorf("invalid guest address '%s': %v", parts[2], err)
+	}
+	guestIP := net.ParseIP(host)
+	guestPort, err := strconv.ParseUint(portStr, 10, 16)
+	if guestIP == nil || guestIP.To4() == nil || err != nil || guestPort == 0 {
+		return vswitch.PortForward{}, fmt.Errorf("invalid guest address '%s'", parts[2])
+	}
+
+	return vswitch.PortForward{
+		Protocol:  parts[0],
+		HostAddr:  parts[1],
+		GuestIP:   guestIP.To4(),
+		GuestPort: uint16(guestPort),
+	}, nil
+}
+
+// logStatsPeriodically logs switch statistics periodically, followed by a
+// line for each VLAN when perVLAN is set
+func logStatsPeriodically(sm *vswitch.SwitchManager, interval time.Duration, perVLAN bool) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		stats := sm.GetStats()
+		slog.Info("Stats", "vlans", stats["vlan_count"], "connections", stats["total_connections"],
+			"mac_entries", stats["total_mac_entries"], "total_frames", stats["total_frames"],
+			"unicast_frames", stats["unicast_frames"], "broadcast_frames", stats["broadcast_frames"],
+			"dropped_frames", stats["dropped_frames"])
+		if !perVLAN {
+			continue
+		}
+
+		ports := sm.GetVLANs()
+		sort.Ints(ports)
+		for _, port := range ports {
+			// The VLAN may have been removed since it was listed
+			vlanStats, err := sm.GetVLANStats(port)
+			if err != nil {
+				continue
+			}
+			slog.Info("VLAN stats", "vlan", port, "connections", vlanStats["connections"],
+				"mac_entries", vlanStats["mac_entries"], "total_frames", vlanStats["total_frames"],
+				"unicast_frames", vlanStats["unicast_frames"], "broadcast_frames", vlanStats["broadcast_frames"],
+				"dropped_frames", vlanStats["dropped_frames"])
+		}
+	}
+}
+
+// upgradeTimeout bounds how long a new process may take to start during an
+// upgrade
+const upgradeTimeout = 30 * time.Second
+
+// upgrade starts a new instance of the executable, hands it the listening
+// sockets and, once it runs, keeps serving the open connections until they
+// close or the drain timeout expires. It reports whether this instance
+// should exit; if the new instance fails, this one carries on.
+func upgrade(dm *vswitch.DaemonManager, sm *vswitch.SwitchManager, drainTimeout time.Duration) bool {
+	slog.Info("Upgrading: starting new process")
+	process, err := vswitch.Upgrade(upgradeTimeout)
+	if err != nil {
+		slog.Error("Upgrade failed", "error", err)
+		return false
+	}
+	slog.Info("Handed sockets to new process", "pid", process.Pid)
+
+	if err := dm.UpdatePIDFile(process.Pid); err != nil {
+		slog.Warn("Failed to update PID file", "error", err)
+	}
+	if err := vswitch.Notify("MAINPID=" + strconv.Itoa(process.Pid)); err != nil {
+		slog.Warn("Failed to notify service manager", "error", err)
+	}
+
+	vswitch.CloseSockets()
+	slog.Info("Draining connections", "timeout", drainTimeout)
+	sm.Drain(drainTimeout)
+	return true
+}
+
+// runSupervisor runs the switch with the same arguments in a child process
+// and restarts it when it crashes or misses its watchdog. It returns the exit
+// code of the supervisor.
+func runSupervisor(dm *vswitch.DaemonManager, level slog.Level) int {
+	// The child owns the log file and its rotation
+	if _, err := setupLogging("", false, *logFormat, level); err != nil {
+		fatalf("Failed to setup logging: %v", err)
+	}
+	dm.Logger = slog.Default()
+
+	watchdog, err := time.ParseDuration(*watchdogTimeout)
+	if err != nil || watchdog < 0 {
+		fatalf("Invalid -watchdog-timeout '%s'", *watchdogTimeout)
+	}
+	if timeout := vswitch.WatchdogTimeout(); timeout > 0 {
+		watchdog = timeout // systemd's WatchdogSec
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		fatalf("Failed to find the executable: %v", err)
+	}
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	if upgradeSignal != nil {
+		signal.Notify(sigChan, reopenSignal, upgradeSignal)
+	}
+
+	slog.Info("Supervising virtual switch", "version", GetVersion(), "watchdog", watchdog)
+	supervisor := vswitch.NewSupervisor(append([]string{executable}, os.Args[1:]...), watchdog)
+	supervisor.Logger = slog.Default()
+	code := supervisor.Run(sigChan)
+
+	dm.Cleanup()
+	slog.Info("Supervisor stopped")
+	return code
+}
+
+// startStatsServer serves the statistics and management API over HTTP on
+// the given port
+func startStatsServer(sm *vswitch.SwitchManager, port int, token string) (*http.Server, error) {
+	listener, err := vswitch.Listen("tcp", ":"+strconv.Itoa(port))
+	if err != nil {
+		return nil, err
+	}
+
+	server := &http.Server{
+		Handler:           vswitch.NewAPIHandler(sm, token),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		slog.Info("Statistics server listening", "port", port)
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) && !errors.Is(err, net.ErrClosed) {
+			slog.Error("Statistics server failed", "error", err)
+		}
+	}()
+
+	return server, nil
+}
+
+// startControlServer serves the management API on a unix control socket
+func startControlServer(sm *vswitch.SwitchManager, path string) (*http.Server, error) {
+	listener, err := vswitch.ListenControlSocket(path)
+	if err != nil {
+		return nil, err
+	}
+
+	server := &http.Server{
+		Handler:           vswitch.NewControlHandler(sm),
+		ReadHeaderTimeout: 10 * time.Second,
+		ConnContext:       vswitch.ControlConnContext,
+	}
+
+	go func() {
+		slog.Info("Control socket listening", "path", path)
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) && !errors.Is(err, net.ErrClosed) {
+			slog.Error("Control server failed", "error", err)
+		}
+	}()
+
+	return server, nil
+}
+
+// startGRPCServer serves the gRPC control-plane API on a unix socket only
+// its owner can open, or on a TCP address guarded by the API token
+func startGRPCServer(sm *vswitch.SwitchManager, addr, token string) (*grpc.Server, error) {
+	var listener net.Listener
+	var server *grpc.Server
+	var err error
+	if strings.HasPrefix(addr, "/") || strings.HasPrefix(addr, "@") {
+		listener, err = vswitch.ListenControlSocket(addr)
+		server = vswitch.NewControlGRPCServer(sm)
+	} else {
+		listener, err = vswitch.Listen("tcp", addr)
+		server = vswitch.NewGRPCServer(sm, token)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	go func() {
+		slog.Info("gRPC server listening", "address", addr)
+		if err := server.Serve(listener); err != nil && !errors.Is(err, grpc.ErrServerStopped) {
+			slog.Error("gRPC server failed", "error", err)
+		}
+	}()
+
+	return server, nil
+}
+
+// startPluginServer serves the Docker network driver plugin API on a unix
+// socket
+func startPluginServer(sm *vswitch.SwitchManager, path string) (*http.Server, error) {
+	listener, err := vswitch.ListenPluginSocket(path)
+	if err != nil {
+		return nil, err
+	}
+
+	server := &http.Server{
+		Handler:           vswitch.NewDockerDriver(sm),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		slog.Info("Docker network driver listening", "path", path)
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) && !errors.Is(err, net.ErrClosed) {
+			slog.Error("Docker network driver failed", "error", err)
+		}
+	}()
+
+	return server, nil
+}
+
+// startTLSEndpoint opens the TLS endpoint, routing the client certificate
+// identities of -tls-vlans to their VLANs
+func startTLSEndpoint(sm *vswitch.SwitchManager, addr string, portList []int) (*vswitch.TLSEndpoint, error) {
+	routes, err := parseTLSRoutes(*tlsVLANs, portList)
+	if err != nil {
+		return nil, fmt.Errorf("-tls-vlans: %v", err)
+	}
+	if len(routes) == 0 {
+		return nil, fmt.Errorf("-tls-listen needs -tls-vlans")
+	}
+
+	cert, err := tls.LoadX509KeyPair(*tlsCert, *tlsKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load -tls-cert and -tls-key: %v", err)
+	}
+	caPEM, err := os.ReadFile(*tlsClientCA)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read -tls-client-ca: %v", err)
+	}
+	clientCAs := x509.NewCertPool()
+	if !clientCAs.AppendCertsFromPEM(caPEM) {
+		return nil, fmt.Errorf("no certificates in -tls-client-ca '%s'", *tlsClientCA)
+	}
+
+	endpoint, err := vswitch.ListenTLS(sm, addr, &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientCAs:    clientCAs,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		MinVersion:   tls.VersionTLS12,
+	}, routes)
+	if err != nil {
+		return nil, err
+	}
+
+	slog.Info("TLS endpoint listening", "address", endpoint.Addr().String(), "routes", len(routes))
+	go endpoint.Serve()
+	return endpoint, nil
+}
+
+// parseHorizonGroups parses the group:peer entries of a VLAN, keeping the
+// groups in the order they first appear
+func parseHorizonGroups(entries []string) ([]vswitch.HorizonGroup, error) {
+	var groups []vswitch.HorizonGroup
+	for _, entry := range entries {
+		name, peer, found := strings.Cut(entry, ":")
+		if !found || name == "" {
+			return nil, fmt.Errorf("invalid entry '%s': expected group:peer", entry)
+		}
+		peers, err := vswitch.ParseCIDRList(peer)
+		if err != nil {
+			return nil, err
+		}
+
+		index := slices.IndexFunc(groups, func(group vswitch.HorizonGroup) bool { return group.Name == name })
+		if index < 0 {
+			groups = append(groups, vswitch.HorizonGroup{Name: name})
+			index = len(groups) - 1
+		}
+		groups[index].Peers = append(groups[index].Peers, peers...)
+	}
+	return groups, nil
+}
+
+// startCluster makes the switch a node of a cluster spanning hosts
+func startCluster(sm *vswitch.SwitchManager) (*vswitch.Cluster, error) {
+	cert, err := tls.LoadX509KeyPair(*clusterCert, *clusterKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load -cluster-cert and -cluster-key: %v", err)
+	}
+	caPEM, err := os.ReadFile(*clusterCA)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read -cluster-ca: %v", err)
+	}
+	cas := x509.NewCertPool()
+	if !cas.AppendCertsFromPEM(caPEM) {
+		return nil, fmt.Errorf("no certificates in -cluster-ca '%s'", *clusterCA)
+	}
+
+	var peers []string
+	for _, peer := range strings.Split(*clusterPeers, ",") {
+		if peer = strings.TrimSpace(peer); peer != "" {
+			peers = append(peers, peer)
+		}
+	}
+	if *clusterListen == "" && len(peers) == 0 {
+		return nil, fmt.Errorf("-cluster-node needs -cluster-listen or -cluster-peers")
+	}
+
+	cluster, err := vswitch.StartCluster(sm, vswitch.ClusterConfig{
+		Node:    *clusterNode,
+		Listen:  *clusterListen,
+		Peers:   peers,
+		Horizon: *clusterHorizon,
+		TLS: &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			RootCAs:      cas,
+			MinVersion:   tls.VersionTLS12,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if addr := cluster.Addr(); addr != nil {
+		slog.Info("Cluster node listening", "node", *clusterNode, "address", addr.String(), "peers", peers)
+	} else {
+		slog.Info("Cluster node started", "node", *clusterNode, "peers", peers)
+	}
+	return cluster, nil
+}
+
+// parseTLSRoutes parses identity=port[/setting...] routes. The settings are
+// isolated, promiscuous, community:NAME, trusted, hairpin and horizon:GROUP.
+func parseTLSRoutes(spec string, portList []int) ([]vswitch.TLSRoute, error) {
+	var routes []vswitch.TLSRoute
+	for _, item := range strings.Split(spec, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+
+		// Identities such as URIs may contain '=', ports never do
+		split := strings.LastIndex(item, "=")
+		if split <= 0 {
+			return nil, fmt.Errorf("invalid route '%s': expected identity=port", item)
+		}
+		settings := strings.Split(item[split+1:], "/")
+		port, err := strconv.Atoi(settings[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid port in '%s': %v", item, err)
+		}
+		if !slices.Contains(portList, port) {
+			return nil, fmt.Errorf("port %d is not a configured VLAN", port)
+		}
+
+		route := vswitch.TLSRoute{Identity: item[:split], Port: port}
+		for _, setting := range settings[1:] {
+			name, value, _ := strings.Cut(setting, ":")
+			switch name {
+			case "isolated", "promiscuous", "community":
+				mode := vswitch.PortIsolated
+				if name == "promiscuous" {
+					mode = vswitch.PortPromiscuous
+				} else if name == "community" {
+					if value == "" {
+						return nil, fmt.Errorf("invalid route '%s': community needs a name", item)
+					}
+					mode = vswitch.PortCommunity
+					route.Community = value
+				}
+				route.Mode = &mode
+			case "trusted":
+				route.Trusted = true
+			case "hairpin":
+				route.Hairpin = true
+			case "horizon":
+				if value == "" {
+					return nil, fmt.Errorf("invalid route '%s': horizon needs a group", item)
+				}
+				route.Horizon = value
+			default:
+				return nil, fmt.Errorf("invalid route '%s': unknown setting '%s'", item, setting)
+			}
+		}
+		routes = append(routes, route)
+	}
+	return routes, nil
 }
@@ -0,0 +1,26 @@
+//go:build !windows
+
+package main
+
+import (
+	"io"
+	"log/syslog"
+	"os"
+	"syscall"
+)
+
+var (
+	// runtimeDir holds the default PID file and control socket
+	runtimeDir = "/tmp"
+
+	// upgradeSignal makes the switch hand over to a new process
+	upgradeSignal os.Signal = syscall.SIGUSR2
+
+	// reopenSignal makes the switch reopen its log file
+	reopenSignal os.Signal = syscall.SIGUSR1
+)
+
+// newSyslogWriter connects to the local syslog daemon
+func newSyslogWriter(tag string) (io.Writer, error) {
+	return syslog.New(syslog.LOG_INFO|syslog.LOG_DAEMON, tag)
+}
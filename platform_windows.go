@@ -0,0 +1,23 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+)
+
+var (
+	// runtimeDir holds the default PID file and control socket
+	runtimeDir = os.TempDir()
+
+	// upgradeSignal is nil as Windows has no signal to request an upgrade
+	upgradeSignal os.Signal
+
+	// reopenSignal is nil as Windows has no signal to reopen the log file
+	reopenSignal os.Signal
+)
+
+// newSyslogWriter fails as Windows has no syslog; log to a file instead
+func newSyslogWriter(_ string) (io.Writer, error) {
+	return nil, errors.New("syslog is not available on Windows, use -log-file")
+}
@@ -0,0 +1,1393 @@
+// Control-plane API for managing a running virtual switch.
+//
+// Go stubs are generated with protoc-gen-go and protoc-gen-go-grpc:
+//
+//   protoc --go_out=. --go_opt=paths=source_relative \
+//          --go-grpc_out=. --go-grpc_opt=paths=source_relative \
+//          proto/vswitch/v1/vswitch.proto
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.11
+// 	protoc        (unknown)
+// source: proto/vswitch/v1/vswitch.proto
+
+package vswitchv1
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type Event_Type int32
+
+const (
+	Event_TYPE_UNSPECIFIED     Event_Type = 0
+	Event_TYPE_CONNECTED       Event_Type = 1
+	Event_TYPE_DISCONNECTED    Event_Type = 2
+	Event_TYPE_RESUMED         Event_Type = 3
+	Event_TYPE_IDENTIFIED      Event_Type = 4
+	Event_TYPE_REJECTED        Event_Type = 5
+	Event_TYPE_VLAN_ADDED      Event_Type = 6
+	Event_TYPE_VLAN_REMOVED    Event_Type = 7
+	Event_TYPE_LIMIT_VIOLATION Event_Type = 8
+	Event_TYPE_STORM_CONTROL   Event_Type = 9
+)
+
+// Enum value maps for Event_Type.
+var (
+	Event_Type_name = map[int32]string{
+		0: "TYPE_UNSPECIFIED",
+		1: "TYPE_CONNECTED",
+		2: "TYPE_DISCONNECTED",
+		3: "TYPE_RESUMED",
+		4: "TYPE_IDENTIFIED",
+		5: "TYPE_REJECTED",
+		6: "TYPE_VLAN_ADDED",
+		7: "TYPE_VLAN_REMOVED",
+		8: "TYPE_LIMIT_VIOLATION",
+		9: "TYPE_STORM_CONTROL",
+	}
+	Event_Type_value = map[string]int32{
+		"TYPE_UNSPECIFIED":     0,
+		"TYPE_CONNECTED":       1,
+		"TYPE_DISCONNECTED":    2,
+		"TYPE_RESUMED":         3,
+		"TYPE_IDENTIFIED":      4,
+		"TYPE_REJECTED":        5,
+		"TYPE_VLAN_ADDED":      6,
+		"TYPE_VLAN_REMOVED":    7,
+		"TYPE_LIMIT_VIOLATION": 8,
+		"TYPE_STORM_CONTROL":   9,
+	}
+)
+
+func (x Event_Type) Enum() *Event_Type {
+	p := new(Event_Type)
+	*p = x
+	return p
+}
+
+func (x Event_Type) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (Event_Type) Descriptor() protoreflect.EnumDescriptor {
+	return file_proto_vswitch_v1_vswitch_proto_enumTypes[0].Descriptor()
+}
+
+func (Event_Type) Type() protoreflect.EnumType {
+	return &file_proto_vswitch_v1_vswitch_proto_enumTypes[0]
+}
+
+func (x Event_Type) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use Event_Type.Descriptor instead.
+func (Event_Type) EnumDescriptor() ([]byte, []int) {
+	return file_proto_vswitch_v1_vswitch_proto_rawDescGZIP(), []int{16, 0}
+}
+
+type VLAN struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Port          int32                  `protobuf:"varint,1,opt,name=port,proto3" json:"port,omitempty"`
+	Stats         *VLANStats             `protobuf:"bytes,2,opt,name=stats,proto3" json:"stats,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *VLAN) Reset() {
+	*x = VLAN{}
+	mi := &file_proto_vswitch_v1_vswitch_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *VLAN) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*VLAN) ProtoMessage() {}
+
+func (x *VLAN) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_vswitch_v1_vswitch_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use VLAN.ProtoReflect.Descriptor instead.
+func (*VLAN) Descriptor() ([]byte, []int) {
+	return file_proto_vswitch_v1_vswitch_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *VLAN) GetPort() int32 {
+	if x != nil {
+		return x.Port
+	}
+	return 0
+}
+
+func (x *VLAN) GetStats() *VLANStats {
+	if x != nil {
+		return x.Stats
+	}
+	return nil
+}
+
+type VLANStats struct {
+	state           protoimpl.MessageState `protogen:"open.v1"`
+	TotalFrames     uint64                 `protobuf:"varint,1,opt,name=total_frames,json=totalFrames,proto3" json:"total_frames,omitempty"`
+	BroadcastFrames uint64                 `protobuf:"varint,2,opt,name=broadcast_frames,json=broadcastFrames,proto3" json:"broadcast_frames,omitempty"`
+	UnicastFrames   uint64                 `protobuf:"varint,3,opt,name=unicast_frames,json=unicastFrames,proto3" json:"unicast_frames,omitempty"`
+	DroppedFrames   uint64                 `protobuf:"varint,4,opt,name=dropped_frames,json=droppedFrames,proto3" json:"dropped_frames,omitempty"`
+	SpoofedFrames   uint64                 `protobuf:"varint,5,opt,name=spoofed_frames,json=spoofedFrames,proto3" json:"spoofed_frames,omitempty"`
+	IsolatedFrames  uint64                 `protobuf:"varint,6,opt,name=isolated_frames,json=isolatedFrames,proto3" json:"isolated_frames,omitempty"`
+	DhcpDrops       uint64                 `protobuf:"varint,7,opt,name=dhcp_drops,json=dhcpDrops,proto3" json:"dhcp_drops,omitempty"`
+	NdDrops         uint64                 `protobuf:"varint,8,opt,name=nd_drops,json=ndDrops,proto3" json:"nd_drops,omitempty"`
+	ProxyArpReplies uint64                 `protobuf:"varint,9,opt,name=proxy_arp_replies,json=proxyArpReplies,proto3" json:"proxy_arp_replies,omitempty"`
+	NatFlows        uint32                 `protobuf:"varint,10,opt,name=nat_flows,json=natFlows,proto3" json:"nat_flows,omitempty"`
+	Connections     uint32                 `protobuf:"varint,11,opt,name=connections,proto3" json:"connections,omitempty"`
+	MacEntries      uint32                 `protobuf:"varint,12,opt,name=mac_entries,json=macEntries,proto3" json:"mac_entries,omitempty"`
+	unknownFields   protoimpl.UnknownFields
+	sizeCache       protoimpl.SizeCache
+}
+
+func (x *VLANStats) Reset() {
+	*x = VLANStats{}
+	mi := &file_proto_vswitch_v1_vswitch_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *VLANStats) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*VLANStats) ProtoMessage() {}
+
+func (x *VLANStats) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_vswitch_v1_vswitch_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use VLANStats.ProtoReflect.Descriptor instead.
+func (*VLANStats) Descriptor() ([]byte, []int) {
+	return file_proto_vswitch_v1_vswitch_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *VLANStats) GetTotalFrames() uint64 {
+	if x != nil {
+		return x.TotalFrames
+	}
+	return 0
+}
+
+func (x *VLANStats) GetBroadcastFrames() uint64 {
+	if x != nil {
+		return x.BroadcastFrames
+	}
+	return 0
+}
+
+func (x *VLANStats) GetUnicastFrames() uint64 {
+	if x != nil {
+		return x.UnicastFrames
+	}
+	return 0
+}
+
+func (x *VLANStats) GetDroppedFrames() uint64 {
+	if x != nil {
+		return x.DroppedFrames
+	}
+	return 0
+}
+
+func (x *VLANStats) GetSpoofedFrames() uint64 {
+	if x != nil {
+		return x.SpoofedFrames
+	}
+	return 0
+}
+
+func (x *VLANStats) GetIsolatedFrames() uint64 {
+	if x != nil {
+		return x.IsolatedFrames
+	}
+	return 0
+}
+
+func (x *VLANStats) GetDhcpDrops() uint64 {
+	if x != nil {
+		return x.DhcpDrops
+	}
+	return 0
+}
+
+func (x *VLANStats) GetNdDrops() uint64 {
+	if x != nil {
+		return x.NdDrops
+	}
+	return 0
+}
+
+func (x *VLANStats) GetProxyArpReplies() uint64 {
+	if x != nil {
+		return x.ProxyArpReplies
+	}
+	return 0
+}
+
+func (x *VLANStats) GetNatFlows() uint32 {
+	if x != nil {
+		return x.NatFlows
+	}
+	return 0
+}
+
+func (x *VLANStats) GetConnections() uint32 {
+	if x != nil {
+		return x.Connections
+	}
+	return 0
+}
+
+func (x *VLANStats) GetMacEntries() uint32 {
+	if x != nil {
+		return x.MacEntries
+	}
+	return 0
+}
+
+type Connection struct {
+	state          protoimpl.MessageState `protogen:"open.v1"`
+	Id             string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	RemoteAddr     string                 `protobuf:"bytes,2,opt,name=remote_addr,json=remoteAddr,proto3" json:"remote_addr,omitempty"`
+	Mode           string                 `protobuf:"bytes,3,opt,name=mode,proto3" json:"mode,omitempty"`
+	Community      string                 `protobuf:"bytes,4,opt,name=community,proto3" json:"community,omitempty"`
+	Hairpin        bool                   `protobuf:"varint,5,opt,name=hairpin,proto3" json:"hairpin,omitempty"`
+	Trusted        bool                   `protobuf:"varint,6,opt,name=trusted,proto3" json:"trusted,omitempty"`
+	FramesSent     uint64                 `protobuf:"varint,7,opt,name=frames_sent,json=framesSent,proto3" json:"frames_sent,omitempty"`
+	FramesReceived uint64                 `protobuf:"varint,8,opt,name=frames_received,json=framesReceived,proto3" json:"frames_received,omitempty"`
+	BytesSent      uint64                 `protobuf:"varint,9,opt,name=bytes_sent,json=bytesSent,proto3" json:"bytes_sent,omitempty"`
+	BytesReceived  uint64                 `protobuf:"varint,10,opt,name=bytes_received,json=bytesReceived,proto3" json:"bytes_received,omitempty"`
+	LastSeen       *timestamppb.Timestamp `protobuf:"bytes,11,opt,name=last_seen,json=lastSeen,proto3" json:"last_seen,omitempty"`
+	unknownFields  protoimpl.UnknownFields
+	sizeCache      protoimpl.SizeCache
+}
+
+func (x *Connection) Reset() {
+	*x = Connection{}
+	mi := &file_proto_vswitch_v1_vswitch_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Connection) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Connection) ProtoMessage() {}
+
+func (x *Connection) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_vswitch_v1_vswitch_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Connection.ProtoReflect.Descriptor instead.
+func (*Connection) Descriptor() ([]byte, []int) {
+	return file_proto_vswitch_v1_vswitch_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *Connection) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *Connection) GetRemoteAddr() string {
+	if x != nil {
+		return x.RemoteAddr
+	}
+	return ""
+}
+
+func (x *Connection) GetMode() string {
+	if x != nil {
+		return x.Mode
+	}
+	return ""
+}
+
+func (x *Connection) GetCommunity() string {
+	if x != nil {
+		return x.Community
+	}
+	return ""
+}
+
+func (x *Connection) GetHairpin() bool {
+	if x != nil {
+		return x.Hairpin
+	}
+	return false
+}
+
+func (x *Connection) GetTrusted() bool {
+	if x != nil {
+		return x.Trusted
+	}
+	return false
+}
+
+func (x *Connection) GetFramesSent() uint64 {
+	if x != nil {
+		return x.FramesSent
+	}
+	return 0
+}
+
+func (x *Connection) GetFramesReceived() uint64 {
+	if x != nil {
+		return x.FramesReceived
+	}
+	return 0
+}
+
+func (x *Connection) GetBytesSent() uint64 {
+	if x != nil {
+		return x.BytesSent
+	}
+	return 0
+}
+
+func (x *Connection) GetBytesReceived() uint64 {
+	if x != nil {
+		return x.BytesReceived
+	}
+	return 0
+}
+
+func (x *Connection) GetLastSeen() *timestamppb.Timestamp {
+	if x != nil {
+		return x.LastSeen
+	}
+	return nil
+}
+
+type MACTableEntry struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Mac           string                 `protobuf:"bytes,1,opt,name=mac,proto3" json:"mac,omitempty"`
+	ConnectionId  string                 `protobuf:"bytes,2,opt,name=connection_id,json=connectionId,proto3" json:"connection_id,omitempty"`
+	LearnedAt     *timestamppb.Timestamp `protobuf:"bytes,3,opt,name=learned_at,json=learnedAt,proto3" json:"learned_at,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *MACTableEntry) Reset() {
+	*x = MACTableEntry{}
+	mi := &file_proto_vswitch_v1_vswitch_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *MACTableEntry) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*MACTableEntry) ProtoMessage() {}
+
+func (x *MACTableEntry) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_vswitch_v1_vswitch_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use MACTableEntry.ProtoReflect.Descriptor instead.
+func (*MACTableEntry) Descriptor() ([]byte, []int) {
+	return file_proto_vswitch_v1_vswitch_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *MACTableEntry) GetMac() string {
+	if x != nil {
+		return x.Mac
+	}
+	return ""
+}
+
+func (x *MACTableEntry) GetConnectionId() string {
+	if x != nil {
+		return x.ConnectionId
+	}
+	return ""
+}
+
+func (x *MACTableEntry) GetLearnedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.LearnedAt
+	}
+	return nil
+}
+
+type ListVLANsRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListVLANsRequest) Reset() {
+	*x = ListVLANsRequest{}
+	mi := &file_proto_vswitch_v1_vswitch_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListVLANsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListVLANsRequest) ProtoMessage() {}
+
+func (x *ListVLANsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_vswitch_v1_vswitch_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListVLANsRequest.ProtoReflect.Descriptor instead.
+func (*ListVLANsRequest) Descriptor() ([]byte, []int) {
+	return file_proto_vswitch_v1_vswitch_proto_rawDescGZIP(), []int{4}
+}
+
+type ListVLANsResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Vlans         []*VLAN                `protobuf:"bytes,1,rep,name=vlans,proto3" json:"vlans,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListVLANsResponse) Reset() {
+	*x = ListVLANsResponse{}
+	mi := &file_proto_vswitch_v1_vswitch_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListVLANsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListVLANsResponse) ProtoMessage() {}
+
+func (x *ListVLANsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_vswitch_v1_vswitch_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListVLANsResponse.ProtoReflect.Descriptor instead.
+func (*ListVLANsResponse) Descriptor() ([]byte, []int) {
+	return file_proto_vswitch_v1_vswitch_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *ListVLANsResponse) GetVlans() []*VLAN {
+	if x != nil {
+		return x.Vlans
+	}
+	return nil
+}
+
+type AddVLANRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Port          int32                  `protobuf:"varint,1,opt,name=port,proto3" json:"port,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AddVLANRequest) Reset() {
+	*x = AddVLANRequest{}
+	mi := &file_proto_vswitch_v1_vswitch_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AddVLANRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AddVLANRequest) ProtoMessage() {}
+
+func (x *AddVLANRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_vswitch_v1_vswitch_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AddVLANRequest.ProtoReflect.Descriptor instead.
+func (*AddVLANRequest) Descriptor() ([]byte, []int) {
+	return file_proto_vswitch_v1_vswitch_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *AddVLANRequest) GetPort() int32 {
+	if x != nil {
+		return x.Port
+	}
+	return 0
+}
+
+type RemoveVLANRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Port          int32                  `protobuf:"varint,1,opt,name=port,proto3" json:"port,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RemoveVLANRequest) Reset() {
+	*x = RemoveVLANRequest{}
+	mi := &file_proto_vswitch_v1_vswitch_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RemoveVLANRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RemoveVLANRequest) ProtoMessage() {}
+
+func (x *RemoveVLANRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_vswitch_v1_vswitch_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RemoveVLANRequest.ProtoReflect.Descriptor instead.
+func (*RemoveVLANRequest) Descriptor() ([]byte, []int) {
+	return file_proto_vswitch_v1_vswitch_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *RemoveVLANRequest) GetPort() int32 {
+	if x != nil {
+		return x.Port
+	}
+	return 0
+}
+
+type RemoveVLANResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RemoveVLANResponse) Reset() {
+	*x = RemoveVLANResponse{}
+	mi := &file_proto_vswitch_v1_vswitch_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RemoveVLANResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RemoveVLANResponse) ProtoMessage() {}
+
+func (x *RemoveVLANResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_vswitch_v1_vswitch_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RemoveVLANResponse.ProtoReflect.Descriptor instead.
+func (*RemoveVLANResponse) Descriptor() ([]byte, []int) {
+	return file_proto_vswitch_v1_vswitch_proto_rawDescGZIP(), []int{8}
+}
+
+type ListConnectionsRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Port          int32                  `protobuf:"varint,1,opt,name=port,proto3" json:"port,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListConnectionsRequest) Reset() {
+	*x = ListConnectionsRequest{}
+	mi := &file_proto_vswitch_v1_vswitch_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListConnectionsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListConnectionsRequest) ProtoMessage() {}
+
+func (x *ListConnectionsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_vswitch_v1_vswitch_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListConnectionsRequest.ProtoReflect.Descriptor instead.
+func (*ListConnectionsRequest) Descriptor() ([]byte, []int) {
+	return file_proto_vswitch_v1_vswitch_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *ListConnectionsRequest) GetPort() int32 {
+	if x != nil {
+		return x.Port
+	}
+	return 0
+}
+
+type ListConnectionsResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Connections   []*Connection          `protobuf:"bytes,1,rep,name=connections,proto3" json:"connections,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListConnectionsResponse) Reset() {
+	*x = ListConnectionsResponse{}
+	mi := &file_proto_vswitch_v1_vswitch_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListConnectionsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListConnectionsResponse) ProtoMessage() {}
+
+func (x *ListConnectionsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_vswitch_v1_vswitch_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListConnectionsResponse.ProtoReflect.Descriptor instead.
+func (*ListConnectionsResponse) Descriptor() ([]byte, []int) {
+	return file_proto_vswitch_v1_vswitch_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *ListConnectionsResponse) GetConnections() []*Connection {
+	if x != nil {
+		return x.Connections
+	}
+	return nil
+}
+
+type KickRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Port          int32                  `protobuf:"varint,1,opt,name=port,proto3" json:"port,omitempty"`
+	ConnectionId  string                 `protobuf:"bytes,2,opt,name=connection_id,json=connectionId,proto3" json:"connection_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *KickRequest) Reset() {
+	*x = KickRequest{}
+	mi := &file_proto_vswitch_v1_vswitch_proto_msgTypes[11]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *KickRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*KickRequest) ProtoMessage() {}
+
+func (x *KickRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_vswitch_v1_vswitch_proto_msgTypes[11]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use KickRequest.ProtoReflect.Descriptor instead.
+func (*KickRequest) Descriptor() ([]byte, []int) {
+	return file_proto_vswitch_v1_vswitch_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *KickRequest) GetPort() int32 {
+	if x != nil {
+		return x.Port
+	}
+	return 0
+}
+
+func (x *KickRequest) GetConnectionId() string {
+	if x != nil {
+		return x.ConnectionId
+	}
+	return ""
+}
+
+type KickResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Number of MAC entries flushed with the connection
+	FlushedMacs   uint32 `protobuf:"varint,1,opt,name=flushed_macs,json=flushedMacs,proto3" json:"flushed_macs,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *KickResponse) Reset() {
+	*x = KickResponse{}
+	mi := &file_proto_vswitch_v1_vswitch_proto_msgTypes[12]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *KickResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*KickResponse) ProtoMessage() {}
+
+func (x *KickResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_vswitch_v1_vswitch_proto_msgTypes[12]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use KickResponse.ProtoReflect.Descriptor instead.
+func (*KickResponse) Descriptor() ([]byte, []int) {
+	return file_proto_vswitch_v1_vswitch_proto_rawDescGZIP(), []int{12}
+}
+
+func (x *KickResponse) GetFlushedMacs() uint32 {
+	if x != nil {
+		return x.FlushedMacs
+	}
+	return 0
+}
+
+type GetMACTableRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Port          int32                  `protobuf:"varint,1,opt,name=port,proto3" json:"port,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetMACTableRequest) Reset() {
+	*x = GetMACTableRequest{}
+	mi := &file_proto_vswitch_v1_vswitch_proto_msgTypes[13]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetMACTableRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetMACTableRequest) ProtoMessage() {}
+
+func (x *GetMACTableRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_vswitch_v1_vswitch_proto_msgTypes[13]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetMACTableRequest.ProtoReflect.Descriptor instead.
+func (*GetMACTableRequest) Descriptor() ([]byte, []int) {
+	return file_proto_vswitch_v1_vswitch_proto_rawDescGZIP(), []int{13}
+}
+
+func (x *GetMACTableRequest) GetPort() int32 {
+	if x != nil {
+		return x.Port
+	}
+	return 0
+}
+
+type GetMACTableResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Entries       []*MACTableEntry       `protobuf:"bytes,1,rep,name=entries,proto3" json:"entries,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetMACTableResponse) Reset() {
+	*x = GetMACTableResponse{}
+	mi := &file_proto_vswitch_v1_vswitch_proto_msgTypes[14]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetMACTableResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetMACTableResponse) ProtoMessage() {}
+
+func (x *GetMACTableResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_vswitch_v1_vswitch_proto_msgTypes[14]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetMACTableResponse.ProtoReflect.Descriptor instead.
+func (*GetMACTableResponse) Descriptor() ([]byte, []int) {
+	return file_proto_vswitch_v1_vswitch_proto_rawDescGZIP(), []int{14}
+}
+
+func (x *GetMACTableResponse) GetEntries() []*MACTableEntry {
+	if x != nil {
+		return x.Entries
+	}
+	return nil
+}
+
+type StreamEventsRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// VLAN ports to watch; empty watches all VLANs
+	Ports         []int32 `protobuf:"varint,1,rep,packed,name=ports,proto3" json:"ports,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *StreamEventsRequest) Reset() {
+	*x = StreamEventsRequest{}
+	mi := &file_proto_vswitch_v1_vswitch_proto_msgTypes[15]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *StreamEventsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StreamEventsRequest) ProtoMessage() {}
+
+func (x *StreamEventsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_vswitch_v1_vswitch_proto_msgTypes[15]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StreamEventsRequest.ProtoReflect.Descriptor instead.
+func (*StreamEventsRequest) Descriptor() ([]byte, []int) {
+	return file_proto_vswitch_v1_vswitch_proto_rawDescGZIP(), []int{15}
+}
+
+func (x *StreamEventsRequest) GetPorts() []int32 {
+	if x != nil {
+		return x.Ports
+	}
+	return nil
+}
+
+type Event struct {
+	state        protoimpl.MessageState `protogen:"open.v1"`
+	Type         Event_Type             `protobuf:"varint,1,opt,name=type,proto3,enum=vswitch.v1.Event_Type" json:"type,omitempty"`
+	Time         *timestamppb.Timestamp `protobuf:"bytes,2,opt,name=time,proto3" json:"time,omitempty"`
+	Port         int32                  `protobuf:"varint,3,opt,name=port,proto3" json:"port,omitempty"`
+	ConnectionId string                 `protobuf:"bytes,4,opt,name=connection_id,json=connectionId,proto3" json:"connection_id,omitempty"`
+	RemoteAddr   string                 `protobuf:"bytes,5,opt,name=remote_addr,json=remoteAddr,proto3" json:"remote_addr,omitempty"`
+	Label        string                 `protobuf:"bytes,6,opt,name=label,proto3" json:"label,omitempty"`
+	VmName       string                 `protobuf:"bytes,7,opt,name=vm_name,json=vmName,proto3" json:"vm_name,omitempty"`
+	// Why a connection was closed or rejected, or the limit it violated
+	Reason        string `protobuf:"bytes,8,opt,name=reason,proto3" json:"reason,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Event) Reset() {
+	*x = Event{}
+	mi := &file_proto_vswitch_v1_vswitch_proto_msgTypes[16]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Event) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Event) ProtoMessage() {}
+
+func (x *Event) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_vswitch_v1_vswitch_proto_msgTypes[16]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Event.ProtoReflect.Descriptor instead.
+func (*Event) Descriptor() ([]byte, []int) {
+	return file_proto_vswitch_v1_vswitch_proto_rawDescGZIP(), []int{16}
+}
+
+func (x *Event) GetType() Event_Type {
+	if x != nil {
+		return x.Type
+	}
+	return Event_TYPE_UNSPECIFIED
+}
+
+func (x *Event) GetTime() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Time
+	}
+	return nil
+}
+
+func (x *Event) GetPort() int32 {
+	if x != nil {
+		return x.Port
+	}
+	return 0
+}
+
+func (x *Event) GetConnectionId() string {
+	if x != nil {
+		return x.ConnectionId
+	}
+	return ""
+}
+
+func (x *Event) GetRemoteAddr() string {
+	if x != nil {
+		return x.RemoteAddr
+	}
+	return ""
+}
+
+func (x *Event) GetLabel() string {
+	if x != nil {
+		return x.Label
+	}
+	return ""
+}
+
+func (x *Event) GetVmName() string {
+	if x != nil {
+		return x.VmName
+	}
+	return ""
+}
+
+func (x *Event) GetReason() string {
+	if x != nil {
+		return x.Reason
+	}
+	return ""
+}
+
+type StreamStatsRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Interval between snapshots; defaults to one second
+	IntervalMs    uint32 `protobuf:"varint,1,opt,name=interval_ms,json=intervalMs,proto3" json:"interval_ms,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *StreamStatsRequest) Reset() {
+	*x = StreamStatsRequest{}
+	mi := &file_proto_vswitch_v1_vswitch_proto_msgTypes[17]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *StreamStatsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StreamStatsRequest) ProtoMessage() {}
+
+func (x *StreamStatsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_vswitch_v1_vswitch_proto_msgTypes[17]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StreamStatsRequest.ProtoReflect.Descriptor instead.
+func (*StreamStatsRequest) Descriptor() ([]byte, []int) {
+	return file_proto_vswitch_v1_vswitch_proto_rawDescGZIP(), []int{17}
+}
+
+func (x *StreamStatsRequest) GetIntervalMs() uint32 {
+	if x != nil {
+		return x.IntervalMs
+	}
+	return 0
+}
+
+type StatsSnapshot struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Time          *timestamppb.Timestamp `protobuf:"bytes,1,opt,name=time,proto3" json:"time,omitempty"`
+	Vlans         []*VLAN                `protobuf:"bytes,2,rep,name=vlans,proto3" json:"vlans,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *StatsSnapshot) Reset() {
+	*x = StatsSnapshot{}
+	mi := &file_proto_vswitch_v1_vswitch_proto_msgTypes[18]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *StatsSnapshot) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StatsSnapshot) ProtoMessage() {}
+
+func (x *StatsSnapshot) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_vswitch_v1_vswitch_proto_msgTypes[18]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StatsSnapshot.ProtoReflect.Descriptor instead.
+func (*StatsSnapshot) Descriptor() ([]byte, []int) {
+	return file_proto_vswitch_v1_vswitch_proto_rawDescGZIP(), []int{18}
+}
+
+func (x *StatsSnapshot) GetTime() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Time
+	}
+	return nil
+}
+
+func (x *StatsSnapshot) GetVlans() []*VLAN {
+	if x != nil {
+		return x.Vlans
+	}
+	return nil
+}
+
+var File_proto_vswitch_v1_vswitch_proto protoreflect.FileDescriptor
+
+const file_proto_vswitch_v1_vswitch_proto_rawDesc = "" +
+	"\n" +
+	"\x1eproto/vswitch/v1/vswitch.proto\x12\n" +
+	"vswitch.v1\x1a\x1fgoogle/protobuf/timestamp.proto\"G\n" +
+	"\x04VLAN\x12\x12\n" +
+	"\x04port\x18\x01 \x01(\x05R\x04port\x12+\n" +
+	"\x05stats\x18\x02 \x01(\v2\x15.vswitch.v1.VLANStatsR\x05stats\"\xbd\x03\n" +
+	"\tVLANStats\x12!\n" +
+	"\ftotal_frames\x18\x01 \x01(\x04R\vtotalFrames\x12)\n" +
+	"\x10broadcast_frames\x18\x02 \x01(\x04R\x0fbroadcastFrames\x12%\n" +
+	"\x0eunicast_frames\x18\x03 \x01(\x04R\runicastFrames\x12%\n" +
+	"\x0edropped_frames\x18\x04 \x01(\x04R\rdroppedFrames\x12%\n" +
+	"\x0espoofed_frames\x18\x05 \x01(\x04R\rspoofedFrames\x12'\n" +
+	"\x0fisolated_frames\x18\x06 \x01(\x04R\x0eisolatedFrames\x12\x1d\n" +
+	"\n" +
+	"dhcp_drops\x18\a \x01(\x04R\tdhcpDrops\x12\x19\n" +
+	"\bnd_drops\x18\b \x01(\x04R\andDrops\x12*\n" +
+	"\x11proxy_arp_replies\x18\t \x01(\x04R\x0fproxyArpReplies\x12\x1b\n" +
+	"\tnat_flows\x18\n" +
+	" \x01(\rR\bnatFlows\x12 \n" +
+	"\vconnections\x18\v \x01(\rR\vconnections\x12\x1f\n" +
+	"\vmac_entries\x18\f \x01(\rR\n" +
+	"macEntries\"\xec\x02\n" +
+	"\n" +
+	"Connection\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x1f\n" +
+	"\vremote_addr\x18\x02 \x01(\tR\n" +
+	"remoteAddr\x12\x12\n" +
+	"\x04mode\x18\x03 \x01(\tR\x04mode\x12\x1c\n" +
+	"\tcommunity\x18\x04 \x01(\tR\tcommunity\x12\x18\n" +
+	"\ahairpin\x18\x05 \x01(\bR\ahairpin\x12\x18\n" +
+	"\atrusted\x18\x06 \x01(\bR\atrusted\x12\x1f\n" +
+	"\vframes_sent\x18\a \x01(\x04R\n" +
+	"framesSent\x12'\n" +
+	"\x0fframes_received\x18\b \x01(\x04R\x0eframesReceived\x12\x1d\n" +
+	"\n" +
+	"bytes_sent\x18\t \x01(\x04R\tbytesSent\x12%\n" +
+	"\x0ebytes_received\x18\n" +
+	" \x01(\x04R\rbytesReceived\x127\n" +
+	"\tlast_seen\x18\v \x01(\v2\x1a.google.protobuf.TimestampR\blastSeen\"\x81\x01\n" +
+	"\rMACTableEntry\x12\x10\n" +
+	"\x03mac\x18\x01 \x01(\tR\x03mac\x12#\n" +
+	"\rconnection_id\x18\x02 \x01(\tR\fconnectionId\x129\n" +
+	"\n" +
+	"learned_at\x18\x03 \x01(\v2\x1a.google.protobuf.TimestampR\tlearnedAt\"\x12\n" +
+	"\x10ListVLANsRequest\";\n" +
+	"\x11ListVLANsResponse\x12&\n" +
+	"\x05vlans\x18\x01 \x03(\v2\x10.vswitch.v1.VLANR\x05vlans\"$\n" +
+	"\x0eAddVLANRequest\x12\x12\n" +
+	"\x04port\x18\x01 \x01(\x05R\x04port\"'\n" +
+	"\x11RemoveVLANRequest\x12\x12\n" +
+	"\x04port\x18\x01 \x01(\x05R\x04port\"\x14\n" +
+	"\x12RemoveVLANResponse\",\n" +
+	"\x16ListConnectionsRequest\x12\x12\n" +
+	"\x04port\x18\x01 \x01(\x05R\x04port\"S\n" +
+	"\x17ListConnectionsResponse\x128\n" +
+	"\vconnections\x18\x01 \x03(\v2\x16.vswitch.v1.ConnectionR\vconnections\"F\n" +
+	"\vKickRequest\x12\x12\n" +
+	"\x04port\x18\x01 \x01(\x05R\x04port\x12#\n" +
+	"\rconnection_id\x18\x02 \x01(\tR\fconnectionId\"1\n" +
+	"\fKickResponse\x12!\n" +
+	"\fflushed_macs\x18\x01 \x01(\rR\vflushedMacs\"(\n" +
+	"\x12GetMACTableRequest\x12\x12\n" +
+	"\x04port\x18\x01 \x01(\x05R\x04port\"J\n" +
+	"\x13GetMACTableResponse\x123\n" +
+	"\aentries\x18\x01 \x03(\v2\x19.vswitch.v1.MACTableEntryR\aentries\"+\n" +
+	"\x13StreamEventsRequest\x12\x14\n" +
+	"\x05ports\x18\x01 \x03(\x05R\x05ports\"\xe6\x03\n" +
+	"\x05Event\x12*\n" +
+	"\x04type\x18\x01 \x01(\x0e2\x16.vswitch.v1.Event.TypeR\x04type\x12.\n" +
+	"\x04time\x18\x02 \x01(\v2\x1a.google.protobuf.TimestampR\x04time\x12\x12\n" +
+	"\x04port\x18\x03 \x01(\x05R\x04port\x12#\n" +
+	"\rconnection_id\x18\x04 \x01(\tR\fconnectionId\x12\x1f\n" +
+	"\vremote_addr\x18\x05 \x01(\tR\n" +
+	"remoteAddr\x12\x14\n" +
+	"\x05label\x18\x06 \x01(\tR\x05label\x12\x17\n" +
+	"\avm_name\x18\a \x01(\tR\x06vmName\x12\x16\n" +
+	"\x06reason\x18\b \x01(\tR\x06reason\"\xdf\x01\n" +
+	"\x04Type\x12\x14\n" +
+	"\x10TYPE_UNSPECIFIED\x10\x00\x12\x12\n" +
+	"\x0eTYPE_CONNECTED\x10\x01\x12\x15\n" +
+	"\x11TYPE_DISCONNECTED\x10\x02\x12\x10\n" +
+	"\fTYPE_RESUMED\x10\x03\x12\x13\n" +
+	"\x0fTYPE_IDENTIFIED\x10\x04\x12\x11\n" +
+	"\rTYPE_REJECTED\x10\x05\x12\x13\n" +
+	"\x0fTYPE_VLAN_ADDED\x10\x06\x12\x15\n" +
+	"\x11TYPE_VLAN_REMOVED\x10\a\x12\x18\n" +
+	"\x14TYPE_LIMIT_VIOLATION\x10\b\x12\x16\n" +
+	"\x12TYPE_STORM_CONTROL\x10\t\"5\n" +
+	"\x12StreamStatsRequest\x12\x1f\n" +
+	"\vinterval_ms\x18\x01 \x01(\rR\n" +
+	"intervalMs\"g\n" +
+	"\rStatsSnapshot\x12.\n" +
+	"\x04time\x18\x01 \x01(\v2\x1a.google.protobuf.TimestampR\x04time\x12&\n" +
+	"\x05vlans\x18\x02 \x03(\v2\x10.vswitch.v1.VLANR\x05vlans2\xd8\x04\n" +
+	"\rSwitchControl\x12H\n" +
+	"\tListVLANs\x12\x1c.vswitch.v1.ListVLANsRequest\x1a\x1d.vswitch.v1.ListVLANsResponse\x127\n" +
+	"\aAddVLAN\x12\x1a.vswitch.v1.AddVLANRequest\x1a\x10.vswitch.v1.VLAN\x12K\n" +
+	"\n" +
+	"RemoveVLAN\x12\x1d.vswitch.v1.RemoveVLANRequest\x1a\x1e.vswitch.v1.RemoveVLANResponse\x12Z\n" +
+	"\x0fListConnections\x12\".vswitch.v1.ListConnectionsRequest\x1a#.vswitch.v1.ListConnectionsResponse\x129\n" +
+	"\x04Kick\x12\x17.vswitch.v1.KickRequest\x1a\x18.vswitch.v1.KickResponse\x12N\n" +
+	"\vGetMACTable\x12\x1e.vswitch.v1.GetMACTableRequest\x1a\x1f.vswitch.v1.GetMACTableResponse\x12D\n" +
+	"\fStreamEvents\x12\x1f.vswitch.v1.StreamEventsRequest\x1a\x11.vswitch.v1.Event0\x01\x12J\n" +
+	"\vStreamStats\x12\x1e.vswitch.v1.StreamStatsRequest\x1a\x19.vswitch.v1.StatsSnapshot0\x01B$Z\"vswitch/proto/vswitch/v1;vswitchv1b\x06proto3"
+
+var (
+	file_proto_vswitch_v1_vswitch_proto_rawDescOnce sync.Once
+	file_proto_vswitch_v1_vswitch_proto_rawDescData []byte
+)
+
+func file_proto_vswitch_v1_vswitch_proto_rawDescGZIP() []byte {
+	file_proto_vswitch_v1_vswitch_proto_rawDescOnce.Do(func() {
+		file_proto_vswitch_v1_vswitch_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_proto_vswitch_v1_vswitch_proto_rawDesc), len(file_proto_vswitch_v1_vswitch_proto_rawDesc)))
+	})
+	return file_proto_vswitch_v1_vswitch_proto_rawDescData
+}
+
+var file_proto_vswitch_v1_vswitch_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
+var file_proto_vswitch_v1_vswitch_proto_msgTypes = make([]protoimpl.MessageInfo, 19)
+var file_proto_vswitch_v1_vswitch_proto_goTypes = []any{
+	(Event_Type)(0),                 // 0: vswitch.v1.Event.Type
+	(*VLAN)(nil),                    // 1: vswitch.v1.VLAN
+	(*VLANStats)(nil),               // 2: vswitch.v1.VLANStats
+	(*Connection)(nil),              // 3: vswitch.v1.Connection
+	(*MACTableEntry)(nil),           // 4: vswitch.v1.MACTableEntry
+	(*ListVLANsRequest)(nil),        // 5: vswitch.v1.ListVLANsRequest
+	(*ListVLANsResponse)(nil),       // 6: vswitch.v1.ListVLANsResponse
+	(*AddVLANRequest)(nil),          // 7: vswitch.v1.AddVLANRequest
+	(*RemoveVLANRequest)(nil),       // 8: vswitch.v1.RemoveVLANRequest
+	(*RemoveVLANResponse)(nil),      // 9: vswitch.v1.RemoveVLANResponse
+	(*ListConnectionsRequest)(nil),  // 10: vswitch.v1.ListConnectionsRequest
+	(*ListConnectionsResponse)(nil), // 11: vswitch.v1.ListConnectionsResponse
+	(*KickRequest)(nil),             // 12: vswitch.v1.KickRequest
+	(*KickResponse)(nil),            // 13: vswitch.v1.KickResponse
+	(*GetMACTableRequest)(nil),      // 14: vswitch.v1.GetMACTableRequest
+	(*GetMACTableResponse)(nil),     // 15: vswitch.v1.GetMACTableResponse
+	(*StreamEventsRequest)(nil),     // 16: vswitch.v1.StreamEventsRequest
+	(*Event)(nil),                   // 17: vswitch.v1.Event
+	(*StreamStatsRequest)(nil),      // 18: vswitch.v1.StreamStatsRequest
+	(*StatsSnapshot)(nil),           // 19: vswitch.v1.StatsSnapshot
+	(*timestamppb.Timestamp)(nil),   // 20: google.protobuf.Timestamp
+}
+var file_proto_vswitch_v1_vswitch_proto_depIdxs = []int32{
+	2,  // 0: vswitch.v1.VLAN.stats:type_name -> vswitch.v1.VLANStats
+	20, // 1: vswitch.v1.Connection.last_seen:type_name -> google.protobuf.Timestamp
+	20, // 2: vswitch.v1.MACTableEntry.learned_at:type_name -> google.protobuf.Timestamp
+	1,  // 3: vswitch.v1.ListVLANsResponse.vlans:type_name -> vswitch.v1.VLAN
+	3,  // 4: vswitch.v1.ListConnectionsResponse.connections:type_name -> vswitch.v1.Connection
+	4,  // 5: vswitch.v1.GetMACTableResponse.entries:type_name -> vswitch.v1.MACTableEntry
+	0,  // 6: vswitch.v1.Event.type:type_name -> vswitch.v1.Event.Type
+	20, // 7: vswitch.v1.Event.time:type_name -> google.protobuf.Timestamp
+	20, // 8: vswitch.v1.StatsSnapshot.time:type_name -> google.protobuf.Timestamp
+	1,  // 9: vswitch.v1.StatsSnapshot.vlans:type_name -> vswitch.v1.VLAN
+	5,  // 10: vswitch.v1.SwitchControl.ListVLANs:input_type -> vswitch.v1.ListVLANsRequest
+	7,  // 11: vswitch.v1.SwitchControl.AddVLAN:input_type -> vswitch.v1.AddVLANRequest
+	8,  // 12: vswitch.v1.SwitchControl.RemoveVLAN:input_type -> vswitch.v1.RemoveVLANRequest
+	10, // 13: vswitch.v1.SwitchControl.ListConnections:input_type -> vswitch.v1.ListConnectionsRequest
+	12, // 14: vswitch.v1.SwitchControl.Kick:input_type -> vswitch.v1.KickRequest
+	14, // 15: vswitch.v1.SwitchControl.GetMACTable:input_type -> vswitch.v1.GetMACTableRequest
+	16, // 16: vswitch.v1.SwitchControl.StreamEvents:input_type -> vswitch.v1.StreamEventsRequest
+	18, // 17: vswitch.v1.SwitchControl.StreamStats:input_type -> vswitch.v1.StreamStatsRequest
+	6,  // 18: vswitch.v1.SwitchControl.ListVLANs:output_type -> vswitch.v1.ListVLANsResponse
+	1,  // 19: vswitch.v1.SwitchControl.AddVLAN:output_type -> vswitch.v1.VLAN
+	9,  // 20: vswitch.v1.SwitchControl.RemoveVLAN:output_type -> vswitch.v1.RemoveVLANResponse
+	11, // 21: vswitch.v1.SwitchControl.ListConnections:output_type -> vswitch.v1.ListConnectionsResponse
+	13, // 22: vswitch.v1.SwitchControl.Kick:output_type -> vswitch.v1.KickResponse
+	15, // 23: vswitch.v1.SwitchControl.GetMACTable:output_type -> vswitch.v1.GetMACTableResponse
+	17, // 24: vswitch.v1.SwitchControl.StreamEvents:output_type -> vswitch.v1.Event
+	19, // 25: vswitch.v1.SwitchControl.StreamStats:output_type -> vswitch.v1.StatsSnapshot
+	18, // [18:26] is the sub-list for method output_type
+	10, // [10:18] is the sub-list for method input_type
+	10, // [10:10] is the sub-list for extension type_name
+	10, // [10:10] is the sub-list for extension extendee
+	0,  // [0:10] is the sub-list for field type_name
+}
+
+func init() { file_proto_vswitch_v1_vswitch_proto_init() }
+func file_proto_vswitch_v1_vswitch_proto_init() {
+	if File_proto_vswitch_v1_vswitch_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_proto_vswitch_v1_vswitch_proto_rawDesc), len(file_proto_vswitch_v1_vswitch_proto_rawDesc)),
+			NumEnums:      1,
+			NumMessages:   19,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_proto_vswitch_v1_vswitch_proto_goTypes,
+		DependencyIndexes: file_proto_vswitch_v1_vswitch_proto_depIdxs,
+		EnumInfos:         file_proto_vswitch_v1_vswitch_proto_enumTypes,
+		MessageInfos:      file_proto_vswitch_v1_vswitch_proto_msgTypes,
+	}.Build()
+	File_proto_vswitch_v1_vswitch_proto = out.File
+	file_proto_vswitch_v1_vswitch_proto_goTypes = nil
+	file_proto_vswitch_v1_vswitch_proto_depIdxs = nil
+}
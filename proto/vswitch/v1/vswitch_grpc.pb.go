@@ -0,0 +1,422 @@
+// Control-plane API for managing a running virtual switch.
+//
+// Go stubs are generated with protoc-gen-go and protoc-gen-go-grpc:
+//
+//   protoc --go_out=. --go_opt=paths=source_relative \
+//          --go-grpc_out=. --go-grpc_opt=paths=source_relative \
+//          proto/vswitch/v1/vswitch.proto
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.6.2
+// - protoc             (unknown)
+// source: proto/vswitch/v1/vswitch.proto
+
+package vswitchv1
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	SwitchControl_ListVLANs_FullMethodName       = "/vswitch.v1.SwitchControl/ListVLANs"
+	SwitchControl_AddVLAN_FullMethodName         = "/vswitch.v1.SwitchControl/AddVLAN"
+	SwitchControl_RemoveVLAN_FullMethodName      = "/vswitch.v1.SwitchControl/RemoveVLAN"
+	SwitchControl_ListConnections_FullMethodName = "/vswitch.v1.SwitchControl/ListConnections"
+	SwitchControl_Kick_FullMethodName            = "/vswitch.v1.SwitchControl/Kick"
+	SwitchControl_GetMACTable_FullMethodName     = "/vswitch.v1.SwitchControl/GetMACTable"
+	SwitchControl_StreamEvents_FullMethodName    = "/vswitch.v1.SwitchControl/StreamEvents"
+	SwitchControl_StreamStats_FullMethodName     = "/vswitch.v1.SwitchControl/StreamStats"
+)
+
+// SwitchControlClient is the client API for SwitchControl service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// SwitchControl manages the VLANs and connections of a switch
+type SwitchControlClient interface {
+	// ListVLANs returns the VLANs and their statistics
+	ListVLANs(ctx context.Context, in *ListVLANsRequest, opts ...grpc.CallOption) (*ListVLANsResponse, error)
+	// AddVLAN creates and starts a VLAN listening on a port
+	AddVLAN(ctx context.Context, in *AddVLANRequest, opts ...grpc.CallOption) (*VLAN, error)
+	// RemoveVLAN stops a VLAN, disconnecting its connections
+	RemoveVLAN(ctx context.Context, in *RemoveVLANRequest, opts ...grpc.CallOption) (*RemoveVLANResponse, error)
+	// ListConnections returns the connections of a VLAN
+	ListConnections(ctx context.Context, in *ListConnectionsRequest, opts ...grpc.CallOption) (*ListConnectionsResponse, error)
+	// Kick disconnects a connection from a VLAN, flushing its MAC entries
+	Kick(ctx context.Context, in *KickRequest, opts ...grpc.CallOption) (*KickResponse, error)
+	// GetMACTable returns the learned MAC addresses of a VLAN
+	GetMACTable(ctx context.Context, in *GetMACTableRequest, opts ...grpc.CallOption) (*GetMACTableResponse, error)
+	// StreamEvents streams switch events such as connections and VLAN changes
+	StreamEvents(ctx context.Context, in *StreamEventsRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Event], error)
+	// StreamStats streams VLAN statistics at a fixed interval
+	StreamStats(ctx context.Context, in *StreamStatsRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[StatsSnapshot], error)
+}
+
+type switchControlClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewSwitchControlClient(cc grpc.ClientConnInterface) SwitchControlClient {
+	return &switchControlClient{cc}
+}
+
+func (c *switchControlClient) ListVLANs(ctx context.Context, in *ListVLANsRequest, opts ...grpc.CallOption) (*ListVLANsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListVLANsResponse)
+	err := c.cc.Invoke(ctx, SwitchControl_ListVLANs_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *switchControlClient) AddVLAN(ctx context.Context, in *AddVLANRequest, opts ...grpc.CallOption) (*VLAN, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(VLAN)
+	err := c.cc.Invoke(ctx, SwitchControl_AddVLAN_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *switchControlClient) RemoveVLAN(ctx context.Context, in *RemoveVLANRequest, opts ...grpc.CallOption) (*RemoveVLANResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(RemoveVLANResponse)
+	err := c.cc.Invoke(ctx, SwitchControl_RemoveVLAN_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *switchControlClient) ListConnections(ctx context.Context, in *ListConnectionsRequest, opts ...grpc.CallOption) (*ListConnectionsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListConnectionsResponse)
+	err := c.cc.Invoke(ctx, SwitchControl_ListConnections_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *switchControlClient) Kick(ctx context.Context, in *KickRequest, opts ...grpc.CallOption) (*KickResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(KickResponse)
+	err := c.cc.Invoke(ctx, SwitchControl_Kick_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *switchControlClient) GetMACTable(ctx context.Context, in *GetMACTableRequest, opts ...grpc.CallOption) (*GetMACTableResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetMACTableResponse)
+	err := c.cc.Invoke(ctx, SwitchControl_GetMACTable_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *switchControlClient) StreamEvents(ctx context.Context, in *StreamEventsRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Event], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &SwitchControl_ServiceDesc.Streams[0], SwitchControl_StreamEvents_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[StreamEventsRequest, Event]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type SwitchControl_StreamEventsClient = grpc.ServerStreamingClient[Event]
+
+func (c *switchControlClient) StreamStats(ctx context.Context, in *StreamStatsRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[StatsSnapshot], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &SwitchControl_ServiceDesc.Streams[1], SwitchControl_StreamStats_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[StreamStatsRequest, StatsSnapshot]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type SwitchControl_StreamStatsClient = grpc.ServerStreamingClient[StatsSnapshot]
+
+// SwitchControlServer is the server API for SwitchControl service.
+// All implementations must embed UnimplementedSwitchControlServer
+// for forward compatibility.
+//
+// SwitchControl manages the VLANs and connections of a switch
+type SwitchControlServer interface {
+	// ListVLANs returns the VLANs and their statistics
+	ListVLANs(context.Context, *ListVLANsRequest) (*ListVLANsResponse, error)
+	// AddVLAN creates and starts a VLAN listening on a port
+	AddVLAN(context.Context, *AddVLANRequest) (*VLAN, error)
+	// RemoveVLAN stops a VLAN, disconnecting its connections
+	RemoveVLAN(context.Context, *RemoveVLANRequest) (*RemoveVLANResponse, error)
+	// ListConnections returns the connections of a VLAN
+	ListConnections(context.Context, *ListConnectionsRequest) (*ListConnectionsResponse, error)
+	// Kick disconnects a connection from a VLAN, flushing its MAC entries
+	Kick(context.Context, *KickRequest) (*KickResponse, error)
+	// GetMACTable returns the learned MAC addresses of a VLAN
+	GetMACTable(context.Context, *GetMACTableRequest) (*GetMACTableResponse, error)
+	// StreamEvents streams switch events such as connections and VLAN changes
+	StreamEvents(*StreamEventsRequest, grpc.ServerStreamingServer[Event]) error
+	// StreamStats streams VLAN statistics at a fixed interval
+	StreamStats(*StreamStatsRequest, grpc.ServerStreamingServer[StatsSnapshot]) error
+	mustEmbedUnimplementedSwitchControlServer()
+}
+
+// UnimplementedSwitchControlServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedSwitchControlServer struct{}
+
+func (UnimplementedSwitchControlServer) ListVLANs(context.Context, *ListVLANsRequest) (*ListVLANsResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method ListVLANs not implemented")
+}
+func (UnimplementedSwitchControlServer) AddVLAN(context.Context, *AddVLANRequest) (*VLAN, error) {
+	return nil, status.Error(codes.Unimplemented, "method AddVLAN not implemented")
+}
+func (UnimplementedSwitchControlServer) RemoveVLAN(context.Context, *RemoveVLANRequest) (*RemoveVLANResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method RemoveVLAN not implemented")
+}
+func (UnimplementedSwitchControlServer) ListConnections(context.Context, *ListConnectionsRequest) (*ListConnectionsResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method ListConnections not implemented")
+}
+func (UnimplementedSwitchControlServer) Kick(context.Context, *KickRequest) (*KickResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method Kick not implemented")
+}
+func (UnimplementedSwitchControlServer) GetMACTable(context.Context, *GetMACTableRequest) (*GetMACTableResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method GetMACTable not implemented")
+}
+func (UnimplementedSwitchControlServer) StreamEvents(*StreamEventsRequest, grpc.ServerStreamingServer[Event]) error {
+	return status.Error(codes.Unimplemented, "method StreamEvents not implemented")
+}
+func (UnimplementedSwitchControlServer) StreamStats(*StreamStatsRequest, grpc.ServerStreamingServer[StatsSnapshot]) error {
+	return status.Error(codes.Unimplemented, "method StreamStats not implemented")
+}
+func (UnimplementedSwitchControlServer) mustEmbedUnimplementedSwitchControlServer() {}
+func (UnimplementedSwitchControlServer) testEmbeddedByValue()                       {}
+
+// UnsafeSwitchControlServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to SwitchControlServer will
+// result in compilation errors.
+type UnsafeSwitchControlServer interface {
+	mustEmbedUnimplementedSwitchControlServer()
+}
+
+func RegisterSwitchControlServer(s grpc.ServiceRegistrar, srv SwitchControlServer) {
+	// If the following call panics, it indicates UnimplementedSwitchControlServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&SwitchControl_ServiceDesc, srv)
+}
+
+func _SwitchControl_ListVLANs_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListVLANsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SwitchControlServer).ListVLANs(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: SwitchControl_ListVLANs_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SwitchControlServer).ListVLANs(ctx, req.(*ListVLANsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _SwitchControl_AddVLAN_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AddVLANRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SwitchControlServer).AddVLAN(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: SwitchControl_AddVLAN_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SwitchControlServer).AddVLAN(ctx, req.(*AddVLANRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _SwitchControl_RemoveVLAN_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RemoveVLANRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SwitchControlServer).RemoveVLAN(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: SwitchControl_RemoveVLAN_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SwitchControlServer).RemoveVLAN(ctx, req.(*RemoveVLANRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _SwitchControl_ListConnections_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListConnectionsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SwitchControlServer).ListConnections(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: SwitchControl_ListConnections_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SwitchControlServer).ListConnections(ctx, req.(*ListConnectionsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _SwitchControl_Kick_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(KickRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SwitchControlServer).Kick(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: SwitchControl_Kick_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SwitchControlServer).Kick(ctx, req.(*KickRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _SwitchControl_GetMACTable_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetMACTableRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SwitchControlServer).GetMACTable(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: SwitchControl_GetMACTable_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SwitchControlServer).GetMACTable(ctx, req.(*GetMACTableRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _SwitchControl_StreamEvents_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(StreamEventsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(SwitchControlServer).StreamEvents(m, &grpc.GenericServerStream[StreamEventsRequest, Event]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type SwitchControl_StreamEventsServer = grpc.ServerStreamingServer[Event]
+
+func _SwitchControl_StreamStats_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(StreamStatsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(SwitchControlServer).StreamStats(m, &grpc.GenericServerStream[StreamStatsRequest, StatsSnapshot]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type SwitchControl_StreamStatsServer = grpc.ServerStreamingServer[StatsSnapshot]
+
+// SwitchControl_ServiceDesc is the grpc.ServiceDesc for SwitchControl service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var SwitchControl_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "vswitch.v1.SwitchControl",
+	HandlerType: (*SwitchControlServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ListVLANs",
+			Handler:    _SwitchControl_ListVLANs_Handler,
+		},
+		{
+			MethodName: "AddVLAN",
+			Handler:    _SwitchControl_AddVLAN_Handler,
+		},
+		{
+			MethodName: "RemoveVLAN",
+			Handler:    _SwitchControl_RemoveVLAN_Handler,
+		},
+		{
+			MethodName: "ListConnections",
+			Handler:    _SwitchControl_ListConnections_Handler,
+		},
+		{
+			MethodName: "Kick",
+			Handler:    _SwitchControl_Kick_Handler,
+		},
+		{
+			MethodName: "GetMACTable",
+			Handler:    _SwitchControl_GetMACTable_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "StreamEvents",
+			Handler:       _SwitchControl_StreamEvents_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "StreamStats",
+			Handler:       _SwitchControl_StreamStats_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "proto/vswitch/v1/vswitch.proto",
+}
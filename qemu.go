@@ -0,0 +1,149 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// qemuArgsUsage describes the qemu-args subcommand
+const qemuArgsUsage = `Usage: %s qemu-args -vlan port [-host address] [-mac address] [-model device] [-id name] [-mtu bytes] [-format qemu|libvirt]
+
+Prints the QEMU arguments, or the libvirt interface XML, attaching a VM to
+a VLAN of the switch, e.g.:
+
+  qemu-system-x86_64 ... $(%s qemu-args -vlan 9999)
+
+Options:
+`
+
+// libvirtInterface is the <interface> element of a libvirt domain
+// connecting to a VLAN as a TCP client
+type libvirtInterface struct {
+	XMLName xml.Name `xml:"interface"`
+	Type    string   `xml:"type,attr"`
+	MAC     struct {
+		Address string `xml:"address,attr"`
+	} `xml:"mac"`
+	Source struct {
+		Address string `xml:"address,attr"`
+		Port    int    `xml:"port,attr"`
+	} `xml:"source"`
+	Model struct {
+		Type string `xml:"type,attr"`
+	} `xml:"model"`
+	MTU *libvirtMTU `xml:"mtu"`
+}
+
+// libvirtMTU is the MTU announced to the guest of a libvirt interface
+type libvirtMTU struct {
+	Size int `xml:"size,attr"`
+}
+
+// qemuMAC returns a random MAC address in QEMU's 52:54:00 range, so VMs
+// wired with the generated arguments do not all share QEMU's default
+// 52:54:00:12:34:56
+func qemuMAC() (net.HardwareAddr, error) {
+	mac := net.HardwareAddr{0x52, 0x54, 0x00, 0, 0, 0}
+	if _, err := rand.Read(mac[3:]); err != nil {
+		return nil, err
+	}
+	return mac, nil
+}
+
+// libvirtModel returns the libvirt model type of a QEMU network device
+func libvirtModel(device string) string {
+	if strings.HasPrefix(device, "virtio-net") {
+		return "virtio"
+	}
+	return device
+}
+
+// writeQEMUArgs writes the -netdev and -device arguments connecting a VM
+// to the VLAN at addr, one option and its value per line so that the
+// output can be pasted or substituted into a QEMU command line
+func writeQEMUArgs(out io.Writer, addr, id, model string, mac net.HardwareAddr, mtu int) {
+	device := fmt.Sprintf("%s,netdev=%s,mac=%s", model, id, mac)
+	if mtu > 0 && strings.HasPrefix(model, "virtio-net") {
+		device += ",host_mtu=" + strconv.Itoa(mtu)
+	}
+	fmt.Fprintf(out, "-netdev socket,id=%s,connect=%s\n", id, addr)
+	fmt.Fprintf(out, "-device %s\n", device)
+}
+
+// writeLibvirtXML writes the libvirt interface element connecting a VM to
+// the VLAN at host and port
+func writeLibvirtXML(out io.Writer, host string, port int, model string, mac net.HardwareAddr, mtu int) error {
+	iface := libvirtInterface{Type: "client"}
+	iface.MAC.Address = mac.String()
+	iface.Source.Address = host
+	iface.Source.Port = port
+	iface.Model.Type = libvirtModel(model)
+	if mtu > 0 {
+		iface.MTU = &libvirtMTU{Size: mtu}
+	}
+
+	body, err := xml.MarshalIndent(iface, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(out, "%s\n", body)
+	return err
+}
+
+// runQEMUArgs runs the qemu-args subcommand, printing the arguments that
+// attach a VM to a VLAN so that guests need not be wired by hand. It
+// returns the process exit code.
+func runQEMUArgs(args []string) int {
+	flags := flag.NewFlagSet("qemu-args", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, qemuArgsUsage, os.Args[0], os.Args[0])
+		flags.PrintDefaults()
+	}
+	vlan := flags.Int("vlan", 0, "Port of the VLAN to attach the VM to")
+	host := flags.String("host", "127.0.0.1", "Address of the switch as seen from QEMU")
+	macAddress := flags.String("mac", "", "MAC address of the VM's NIC (default random in 52:54:00)")
+	model := flags.String("model", "virtio-net-pci", "QEMU network device, e.g. virtio-net-pci or e1000")
+	id := flags.String("id", "net0", "ID of the QEMU netdev, unique within the VM")
+	mtu := flags.Int("mtu", 0, "MTU to announce to the guest, matching the switch's -mtu (0 to leave the default)")
+	format := flags.String("format", "qemu", "Output format: qemu or libvirt")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *vlan <= 0 || *vlan > 65535 || *host == "" || *id == "" || *mtu < 0 || flags.NArg() > 0 {
+		flags.Usage()
+		return 2
+	}
+
+	mac, err := qemuMAC()
+	if *macAddress != "" {
+		mac, err = net.ParseMAC(*macAddress)
+		if err == nil && (len(mac) != 6 || mac[0]&0x01 != 0) {
+			err = fmt.Errorf("%s is not a unicast Ethernet address", *macAddress)
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid MAC address: %v\n", err)
+		return 2
+	}
+
+	switch *format {
+	case "qemu":
+		writeQEMUArgs(os.Stdout, net.JoinHostPort(*host, strconv.Itoa(*vlan)), *id, *model, mac, *mtu)
+	case "libvirt":
+		if err := writeLibvirtXML(os.Stdout, *host, *vlan, *model, mac, *mtu); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to write libvirt XML: %v\n", err)
+			return 1
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "Invalid -format %q: must be qemu or libvirt\n", *format)
+		return 2
+	}
+	return 0
+}
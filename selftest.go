@@ -0,0 +1,309 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	vswitch "vswitch/switch"
+)
+
+const (
+	// selftestHeader is the size of the Ethernet header and the sequence
+	// number and send time leading the payload of each test frame
+	selftestHeader = 14 + 8 + 8
+
+	// selftestWindow bounds the frames in flight, keeping the receiver's
+	// egress queue from overflowing so that every loss is a failure
+	selftestWindow = 64
+
+	// selftestGrace is how long stray frames are waited for before the
+	// isolation of the clients is judged
+	selftestGrace = 200 * time.Millisecond
+)
+
+// frame builds a test frame from the client with the sequence number and
+// send time in the payload, padded with a pattern derived from seq
+func (c *frameClient) frame(dst net.HardwareAddr, size int, seq uint64, sent time.Duration) []byte {
+	frame := make([]byte, size)
+	copy(frame[0:6], dst)
+	copy(frame[6:12], c.mac)
+	binary.BigEndian.PutUint16(frame[12:14], experimentalEtherType)
+	binary.BigEndian.PutUint64(frame[14:22], seq)
+	binary.BigEndian.PutUint64(frame[22:30], uint64(sent))
+	for i := selftestHeader; i < size; i++ {
+		frame[i] = byte(seq) + byte(i)
+	}
+	return frame
+}
+
+// intact reports whether a received frame carries the pattern of its
+// sequence number
+func intact(frame []byte, size int, seq uint64) bool {
+	if len(frame) != size {
+		return false
+	}
+	for i := selftestHeader; i < size; i++ {
+		if frame[i] != byte(seq)+byte(i) {
+			return false
+		}
+	}
+	return true
+}
+
+// selftestResult records the outcome of one check
+type selftestResult struct {
+	name   string
+	failed string
+}
+
+// runSelftest brings up a VLAN on a free local port, passes traffic
+// between internal clients through the full TCP, parsing and forwarding
+// path, and reports the throughput, the latency and any frames lost,
+// reordered, corrupted or delivered to the wrong client. It returns the
+// process exit code.
+func runSelftest(args []string) int {
+	flags := flag.NewFlagSet("selftest", flag.ContinueOnError)
+	frames := flags.Int("frames", 50000, "Number of unicast frames to send")
+	size := flags.Int("size", 1024, "Size of the frames in bytes, without the checksum")
+	timeout := flags.Duration("timeout", 30*time.Second, "Time limit of the test")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	maxSize := vswitch.DefaultMTU + 14
+	if *frames <= 0 || *size < 60 || *size > maxSize || flags.NArg() > 0 {
+		fmt.Fprintf(os.Stderr, "Usage: %s selftest [-frames n] [-size 60-%d] [-timeout duration]\n", os.Args[0], maxSize)
+		return 2
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	// Find a free port; the switch listens on it right after
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to find a free port: %v\n", err)
+		return 1
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+	_ = listener.Close()
+
+	sm, err := vswitch.New(ctx, vswitch.Options{Ports: []int{port}})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to start VLAN %d: %v\n", port, err)
+		return 1
+	}
+	defer sm.StopAll()
+
+	fmt.Printf("Virtual Switch for QEMU VMs %s self-test on VLAN %d\n", GetVersion(), port)
+
+	var clients []*frameClient
+	for i, name := range []string{"sender", "receiver", "bystander"} {
+		mac := net.HardwareAddr{0x02, 0x00, 0x5e, 0x00, 0x00, byte(i + 1)}
+		client, err := dialFrameClient(name, net.JoinHostPort("127.0.0.1", strconv.Itoa(port)), mac)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+		defer func() { _ = client.conn.Close() }()
+		clients = append(clients, client)
+	}
+	sender, receiver, bystander := clients[0], clients[1], clients[2]
+
+	// Flooding only reaches connections the switch has accepted
+	for {
+		if connections, err := sm.GetConnections(port); err == nil && len(connections) == len(clients) {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			fmt.Fprintf(os.Stderr, "Timed out waiting for the clients to be accepted\n")
+			return 1
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	deadline, _ := ctx.Deadline()
+	for _, client := range clients {
+		_ = client.conn.SetDeadline(deadline)
+	}
+
+	results := []selftestResult{
+		{name: "flooding", failed: checkFlooding(clients)},
+	}
+
+	// Frames the bystander and the sender receive from here on are leaks
+	var strays atomic.Uint64
+	for _, client := range []*frameClient{sender, bystander} {
+		go func() {
+			buf := make([]byte, maxSize)
+			for {
+				if _, err := client.receive(buf); err != nil {
+					return
+				}
+				strays.Add(1)
+			}
+		}()
+	}
+
+	unicast := runUnicast(ctx, sender, receiver, *frames, *size)
+	results = append(results, selftestResult{name: "unicast", failed: unicast.failure(*frames)})
+
+	time.Sleep(selftestGrace)
+	isolation := ""
+	if count := strays.Load(); count > 0 {
+		isolation = fmt.Sprintf("%d frames delivered to clients they were not addressed to", count)
+	}
+	results = append(results, selftestResult{name: "isolation", failed: isolation})
+
+	drops := ""
+	if stats, err := sm.GetVLANStats(port); err == nil {
+		if dropped, _ := stats["dropped_frames"].(uint64); dropped > 0 {
+			drops = fmt.Sprintf("switch dropped %d frames: %v", dropped, stats["drop_reasons"])
+		}
+	}
+	results = append(results, selftestResult{name: "drops", failed: drops})
+
+	if unicast.received > 0 {
+		seconds := unicast.elapsed.Seconds()
+		fmt.Printf("Throughput: %.0f frames/s, %.1f Mbit/s with %d-byte frames\n",
+			float64(unicast.received)/seconds, float64(unicast.received)*float64(*size)*8/seconds/1e6, *size)
+		slices.Sort(unicast.latencies)
+		fmt.Printf("Latency: min %v, p50 %v, p99 %v, max %v\n",
+			unicast.latencies[0], percentile(unicast.latencies, 50), percentile(unicast.latencies, 99),
+			unicast.latencies[len(unicast.latencies)-1])
+	}
+
+	code := 0
+	for _, result := range results {
+		if result.failed == "" {
+			fmt.Printf("  %-10s ok\n", result.name)
+		} else {
+			fmt.Printf("  %-10s FAILED: %s\n", result.name, result.failed)
+			code = 1
+		}
+	}
+	return code
+}
+
+// checkFlooding has each client broadcast a frame and checks that it
+// reaches every other client
+func checkFlooding(clients []*frameClient) string {
+	buf := make([]byte, vswitch.DefaultMTU+14)
+	for _, source := range clients {
+		if err := source.send(source.frame(vswitch.BroadcastMAC, 60, 0, 0)); err != nil {
+			return fmt.Sprintf("%s failed to send a broadcast: %v", source.name, err)
+		}
+		for _, client := range clients {
+			if client == source {
+				continue
+			}
+			frame, err := client.receive(buf)
+			if err != nil {
+				return fmt.Sprintf("%s did not receive the broadcast of %s: %v", client.name, source.name, err)
+			}
+			if !slices.Equal(frame[6:12], source.mac) {
+				return fmt.Sprintf("%s received a frame from %s instead of %s", client.name, net.HardwareAddr(frame[6:12]), source.name)
+			}
+		}
+	}
+	return ""
+}
+
+// unicastResult is the outcome of sending unicast frames between clients
+type unicastResult struct {
+	received  int
+	reordered int
+	corrupted int
+	sendErr   error
+	readErr   error
+	elapsed   time.Duration
+	latencies []time.Duration
+}
+
+// failure describes what went wrong sending count frames, or returns ""
+func (r unicastResult) failure(count int) string {
+	switch {
+	case r.sendErr != nil:
+		return fmt.Sprintf("sending failed: %v", r.sendErr)
+	case r.received < count:
+		return fmt.Sprintf("%d of %d frames lost: %v", count-r.received, count, r.readErr)
+	case r.reordered > 0 || r.corrupted > 0:
+		return fmt.Sprintf("%d frames out of order, %d corrupted", r.reordered, r.corrupted)
+	}
+	return ""
+}
+
+// runUnicast sends count frames of size bytes from sender to receiver,
+// which the switch learned from the flooding check, and times them
+func runUnicast(ctx context.Context, sender, receiver *frameClient, count, size int) unicastResult {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	window := make(chan struct{}, selftestWindow)
+	start := time.Now()
+	sendErr := make(chan error, 1)
+	go func() {
+		for seq := uint64(0); seq < uint64(count); seq++ {
+			select {
+			case window <- struct{}{}:
+			case <-ctx.Done():
+				sendErr <- nil
+				return
+			}
+			if err := sender.send(sender.frame(receiver.mac, size, seq, time.Since(start))); err != nil {
+				// Give the frames in flight time to arrive
+				_ = receiver.conn.SetReadDeadline(time.Now().Add(selftestGrace))
+				sendErr <- err
+				return
+			}
+		}
+		sendErr <- nil
+	}()
+
+	result := unicastResult{latencies: make([]time.Duration, 0, count)}
+	buf := make([]byte, vswitch.DefaultMTU+14)
+	for next := uint64(0); result.received < count; next++ {
+		frame, err := receiver.receive(buf)
+		if err != nil {
+			result.readErr = err
+			break
+		}
+		select {
+		case <-window:
+		default:
+		}
+		if len(frame) < selftestHeader || !slices.Equal(frame[6:12], sender.mac) {
+			result.corrupted++
+			continue
+		}
+		seq := binary.BigEndian.Uint64(frame[14:22])
+		sent := time.Duration(binary.BigEndian.Uint64(frame[22:30]))
+		result.received++
+		result.latencies = append(result.latencies, time.Since(start)-sent)
+		if seq != next {
+			result.reordered++
+			next = seq
+		}
+		if !intact(frame, size, seq) {
+			result.corrupted++
+		}
+	}
+	result.elapsed = time.Since(start)
+
+	// Stop a sender still waiting for the window
+	cancel()
+	result.sendErr = <-sendErr
+	return result
+}
+
+// percentile returns the p-th percentile of sorted durations
+func percentile(sorted []time.Duration, p int) time.Duration {
+	return sorted[(len(sorted)-1)*p/100]
+}
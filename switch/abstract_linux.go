@@ -0,0 +1,40 @@
+package vswitch
+
+import (
+	"fmt"
+	"net"
+	"os"
+)
+
+// ownerListener accepts connections from processes of the current user
+// only, which abstract unix sockets, lacking file permissions, cannot
+// restrict themselves
+type ownerListener struct {
+	net.Listener
+}
+
+// Accept returns the next connection of the current user, closing the
+// connections of other users
+func (l ownerListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		if cred, err := peerUcred(conn); err == nil && int(cred.Uid) == os.Getuid() {
+			return conn, nil
+		}
+		_ = conn.Close()
+	}
+}
+
+// listenAbstractSocket listens on a unix socket in the abstract namespace,
+// which disappears with the last process holding it, so there is no stale
+// socket to replace. Only the current user's processes are served.
+func listenAbstractSocket(path, kind string) (net.Listener, error) {
+	listener, err := Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("%s %s is unavailable: %v", kind, path, err)
+	}
+	return ownerListener{listener}, nil
+}
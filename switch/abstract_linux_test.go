@@ -0,0 +1,43 @@
+package vswitch
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"testing"
+)
+
+func TestAbstractControlSocket(t *testing.T) {
+	path := fmt.Sprintf("@vswitch-test/%d/ctl", os.Getpid())
+
+	listener, err := ListenControlSocket(path)
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	if _, err := ListenControlSocket(path); err == nil {
+		t.Errorf("Expected socket in use to be refused")
+	}
+
+	sm := NewSwitchManager()
+	if err := sm.AddVLAN(8080); err != nil {
+		t.Fatalf("Failed to add VLAN: %v", err)
+	}
+	server := &http.Server{Handler: NewControlHandler(sm)}
+	go func() { _ = server.Serve(listener) }()
+
+	// The current user is served over the socket, which leaves no file
+	if ports, err := NewControlClient(path).VLANs(); err != nil || len(ports) != 1 || ports[0] != 8080 {
+		t.Errorf("Unexpected VLANs %v (%v)", ports, err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("Expected no socket file, got %v", err)
+	}
+
+	// Closing the socket frees the name at once
+	_ = server.Close()
+	listener, err = ListenControlSocket(path)
+	if err != nil {
+		t.Fatalf("Expected the name to be free again: %v", err)
+	}
+	_ = listener.Close()
+}
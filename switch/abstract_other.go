@@ -0,0 +1,14 @@
+//go:build !linux
+
+package vswitch
+
+import (
+	"fmt"
+	"net"
+)
+
+// listenAbstractSocket fails outside Linux, which alone has an abstract
+// namespace for unix sockets
+func listenAbstractSocket(path, kind string) (net.Listener, error) {
+	return nil, fmt.Errorf("%s %s: abstract unix sockets are only supported on Linux", kind, path)
+}
@@ -0,0 +1,614 @@
+package vswitch
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// NewAPIHandler returns an HTTP handler serving the manager's statistics
+// and state as JSON:
+//
+//	GET /stats                          aggregated statistics
+//	GET /vlans                          VLAN ports
+//	GET /vlans/{port}                   statistics of one VLAN
+//	GET /vlans/{port}/connections       connections of one VLAN
+//	GET /vlans/{port}/macs              MAC table of one VLAN
+//	GET /macs                           MAC tables of all VLANs
+//	GET /vlans/{port}/dhcp-bindings     DHCP snooping bindings of one VLAN
+//	GET /vlans/{port}/ips               IP addresses seen on one VLAN
+//	GET /ips                            IP addresses seen on all VLANs
+//	GET /ips/{address}                  VLANs, MACs and connections an IP address was seen on
+//	GET /vlans/{port}/peers             labeled peers and whether they are connected
+//	GET /vlans/{port}/partitions        active partitions of one VLAN
+//	GET /vlans/{port}/vids              broadcast domains of a VLAN demultiplexing tags
+//	GET /config                         declarative snapshot of the VLANs and their state
+//	GET /cluster                        cluster nodes linked to this switch
+//	GET /bridges                        bridges passing selected frames between VLANs
+//	GET /events                         stream switch events as server-sent events
+//	GET /metrics                        per-VLAN statistics for Prometheus
+//	GET /healthz                        200 while no forwarding loop is stuck
+//	GET /readyz                         200 while all VLANs accept connections
+//
+// and managing VLANs while the switch runs:
+//
+//	POST /vlans                         create and start a VLAN ({"port": N})
+//	DELETE /vlans/{port}                stop and remove a VLAN
+//	DELETE /vlans/{port}/connections/{id}  disconnect a connection and flush its MACs
+//	POST /vlans/{port}/disable          shut a VLAN down, keeping its state
+//	POST /vlans/{port}/enable           bring a VLAN back up
+//	POST /vlans/{port}/connections/{id}/disable  shut a connection down
+//	POST /vlans/{port}/connections/{id}/enable   bring a connection back up
+//	PUT /vlans/{port}/impairment        delay, drop, duplicate or reorder the frames a VLAN sends
+//	DELETE /vlans/{port}/impairment     stop impairing a VLAN's frames
+//	PUT /vlans/{port}/connections/{id}/impairment     give a connection its own impairment
+//	DELETE /vlans/{port}/connections/{id}/impairment  make a connection follow its VLAN's impairment
+//	POST /vlans/{port}/partitions       blackhole traffic between groups of connections
+//	                                    ({"groups": [["a"], ["b", "c"]], "duration": "30s"})
+//	DELETE /vlans/{port}/partitions/{id}  heal a partition
+//	DELETE /vlans/{port}/partitions     heal every partition of a VLAN
+//	POST /bridges                       bridge two VLANs for the frames rules select
+//	                                    ({"vlans": [N, M], "rules": [{"dst_mac": "..."}]})
+//	DELETE /bridges/{id}                remove a bridge
+//	POST /stats/reset                   zero the statistics of all VLANs
+//	POST /vlans/{port}/stats/reset      zero the statistics of one VLAN and its connections
+//	POST /vlans/{port}/connections/{id}/stats/reset  zero the statistics of a connection
+//	GET /vlans/{port}/capture           stream the VLAN's frames as pcap
+//	PUT /vlans/{port}/peers/{address}   label a peer's connections ({"label": "ns/vm"})
+//	DELETE /vlans/{port}/peers/{address}  forget a peer's label
+//	POST /config                        import a snapshot, starting the VLANs missing
+//	PUT /config[?dry_run=true]          reconcile the switch to a desired state, listing the actions
+//
+// When token is set every request except the health checks must carry it
+// as a bearer token. Without a token the management endpoints are disabled.
+// Management requests are recorded in the manager's audit log, if any.
+func NewAPIHandler(sm *SwitchManager, token string) http.Handler {
+	if token == "" {
+		return newAPIMux(sm, false)
+	}
+
+	// Orchestrators probing health do not hold the token
+	mux := http.NewServeMux()
+	addHealthHandlers(mux, sm)
+	mux.Handle("/", requireToken(token, newAPIMux(sm, true)))
+	return mux
+}
+
+// NewControlHandler returns the API handler with management enabled and no
+// authentication, for serving on a control socket only its owner can open
+func NewControlHandler(sm *SwitchManager) http.Handler {
+	return newAPIMux(sm, true)
+}
+
+// newAPIMux routes the API endpoints, optionally enabling management
+func newAPIMux(sm *SwitchManager, management bool) *http.ServeMux {
+	mux := http.NewServeMux()
+	addHealthHandlers(mux, sm)
+
+	// Management operations are recorded in the audit log
+	manage := func(handler http.HandlerFunc) http.HandlerFunc {
+		return managementHandler(management, sm.audited(handler))
+	}
+
+	mux.HandleFunc("GET /stats", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(w, http.StatusOK, sm.GetStats())
+	})
+
+	mux.HandleFunc("GET /metrics", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		writeMetrics(w, sm)
+	})
+
+	mux.HandleFunc("GET /vlans", func(w http.ResponseWriter, _ *http.Request) {
+		ports := sm.GetVLANs()
+		sort.Ints(ports)
+		writeJSON(w, http.StatusOK, ports)
+	})
+
+	mux.HandleFunc("GET /vlans/{port}", vlanHandler(func(port int) (interface{}, error) {
+		return sm.GetVLANStats(port)
+	}))
+
+	mux.HandleFunc("GET /vlans/{port}/connections", vlanHandler(func(port int) (interface{}, error) {
+		return sm.GetConnections(port)
+	}))
+
+	mux.HandleFunc("GET /macs", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(w, http.StatusOK, sm.GetAllMACTables())
+	})
+
+	mux.HandleFunc("GET /vlans/{port}/macs", vlanHandler(func(port int) (interface{}, error) {
+		return sm.GetMACTable(port)
+	}))
+
+	mux.HandleFunc("GET /vlans/{port}/dhcp-bindings", vlanHandler(func(port int) (interface{}, error) {
+		return sm.GetDHCPBindings(port)
+	}))
+
+	mux.HandleFunc("GET /vlans/{port}/ips", vlanHandler(func(port int) (interface{}, error) {
+		return sm.GetIPInventory(port)
+	}))
+
+	mux.HandleFunc("GET /ips", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(w, http.StatusOK, sm.GetAllIPInventories())
+	})
+
+	mux.HandleFunc("GET /ips/{address}", func(w http.ResponseWriter, r *http.Request) {
+		ip := net.ParseIP(r.PathValue("address"))
+		if ip == nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid IP address"})
+			return
+		}
+		entries := sm.FindIP(ip)
+		if len(entries) == 0 {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "IP address not seen"})
+			return
+		}
+		writeJSON(w, http.StatusOK, entries)
+	})
+
+	mux.HandleFunc("GET /vlans/{port}/peers", vlanHandler(func(port int) (interface{}, error) {
+		return sm.GetPeerLabels(port)
+	}))
+
+	mux.HandleFunc("GET /vlans/{port}/partitions", vlanHandler(func(port int) (interface{}, error) {
+		return sm.GetPartitions(port)
+	}))
+
+	mux.HandleFunc("GET /vlans/{port}/vids", vlanHandler(func(port int) (interface{}, error) {
+		return sm.GetTagDomains(port)
+	}))
+
+	mux.HandleFunc("GET /bridges", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(w, http.StatusOK, sm.Bridges())
+	})
+
+	mux.HandleFunc("GET /cluster", func(w http.ResponseWriter, _ *http.Request) {
+		members := sm.ClusterMembers()
+		if members == nil {
+			members = []ClusterMember{}
+		}
+		writeJSON(w, http.StatusOK, members)
+	})
+
+	mux.HandleFunc("GET /events", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		if err := sm.StreamEvents(r.Context(), w); err != nil {
+			sm.logger().Debug("Event stream ended", "error", err)
+		}
+	})
+
+	mux.HandleFunc("POST /vlans", manage(func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			Port int `json:"port"`
+		}
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&request); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request body"})
+			return
+		}
+		if request.Port < 1 || request.Port > 65535 {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid port"})
+			return
+		}
+
+		if err := sm.StartVLAN(request.Port); err != nil {
+			writeJSON(w, http.StatusConflict, map[string]string{"error": err.Error()})
+			return
+		}
+
+		writeJSON(w, http.StatusCreated, map[string]int{"port": request.Port})
+	}))
+
+	mux.HandleFunc("GET /config", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(w, http.StatusOK, sm.Snapshot())
+	})
+
+	mux.HandleFunc("POST /config", manage(func(w http.ResponseWriter, r *http.Request) {
+		var snapshot Snapshot
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxSnapshotSize)).Decode(&snapshot); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid snapshot: " + err.Error()})
+			return
+		}
+
+		result, err := sm.Import(snapshot)
+		if errors.Is(err, errInvalidSnapshot) {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		} else if err != nil {
+			writeJSON(w, http.StatusConflict, map[string]interface{}{"error": err.Error(), "created": result.Created, "updated": result.Updated})
+			return
+		}
+		writeJSON(w, http.StatusOK, result)
+	}))
+
+	mux.HandleFunc("PUT /config", manage(func(w http.ResponseWriter, r *http.Request) {
+		var desired Snapshot
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxSnapshotSize)).Decode(&desired); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid desired state: " + err.Error()})
+			return
+		}
+		dryRun, _ := strconv.ParseBool(r.URL.Query().Get("dry_run"))
+
+		actions, err := sm.Reconcile(desired, dryRun)
+		if actions == nil {
+			actions = []ReconcileAction{}
+		}
+		if errors.Is(err, errInvalidSnapshot) {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		} else if err != nil {
+			writeJSON(w, http.StatusConflict, map[string]interface{}{"error": err.Error(), "actions": actions})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"dry_run": dryRun, "actions": actions})
+	}))
+
+	mux.HandleFunc("DELETE /vlans/{port}", manage(vlanHandler(func(port int) (interface{}, error) {
+		if err := sm.RemoveVLAN(port); err != nil {
+			return nil, err
+		}
+		return map[string]int{"port": port}, nil
+	})))
+
+	mux.HandleFunc("DELETE /vlans/{port}/connections/{id}", manage(func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+		vlanHandler(func(port int) (interface{}, error) {
+			flushed, err := sm.Kick(port, id)
+			if err != nil {
+				return nil, err
+			}
+			return map[string]interface{}{"id": id, "flushed_macs": flushed}, nil
+		})(w, r)
+	}))
+
+	mux.HandleFunc("POST /stats/reset", manage(func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]interface{}{"stats_reset_at": sm.ResetStats()})
+	}))
+
+	mux.HandleFunc("POST /vlans/{port}/stats/reset", manage(vlanHandler(func(port int) (interface{}, error) {
+		resetAt, err := sm.ResetVLANStats(port)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"port": port, "stats_reset_at": resetAt}, nil
+	})))
+
+	mux.HandleFunc("POST /vlans/{port}/connections/{id}/stats/reset", manage(func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+		vlanHandler(func(port int) (interface{}, error) {
+			resetAt, err := sm.ResetConnectionStats(port, id)
+			if err != nil {
+				return nil, err
+			}
+			return map[string]interface{}{"id": id, "stats_reset_at": resetAt}, nil
+		})(w, r)
+	}))
+
+	mux.HandleFunc("PUT /vlans/{port}/peers/{address}", manage(func(w http.ResponseWriter, r *http.Request) {
+		ip := net.ParseIP(r.PathValue("address"))
+		var request struct {
+			Label string `json:"label"`
+		}
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&request); err != nil || ip == nil || request.Label == "" {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "expected a peer IP address and a label"})
+			return
+		}
+		vlanHandler(func(port int) (interface{}, error) {
+			if err := sm.SetPeerLabel(port, ip, request.Label); err != nil {
+				return nil, err
+			}
+			return map[string]string{"address": ip.String(), "label": request.Label}, nil
+		})(w, r)
+	}))
+
+	mux.HandleFunc("DELETE /vlans/{port}/peers/{address}", manage(func(w http.ResponseWriter, r *http.Request) {
+		ip := net.ParseIP(r.PathValue("address"))
+		if ip == nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid peer address"})
+			return
+		}
+		vlanHandler(func(port int) (interface{}, error) {
+			if err := sm.RemovePeerLabel(port, ip); err != nil {
+				return nil, err
+			}
+			return map[string]string{"address": ip.String()}, nil
+		})(w, r)
+	}))
+
+	for action, disabled := range map[string]bool{"disable": true, "enable": false} {
+		mux.HandleFunc("POST /vlans/{port}/"+action, manage(vlanHandler(func(port int) (interface{}, error) {
+			if err := sm.SetVLANDisabled(port, disabled); err != nil {
+				return nil, err
+			}
+			return map[string]interface{}{"port": port, "disabled": disabled}, nil
+		})))
+
+		mux.HandleFunc("POST /vlans/{port}/connections/{id}/"+action, manage(func(w http.ResponseWriter, r *http.Request) {
+			id := r.PathValue("id")
+			vlanHandler(func(port int) (interface{}, error) {
+				if err := sm.SetConnectionDisabled(port, id, disabled); err != nil {
+					return nil, err
+				}
+				return map[string]interface{}{"id": id, "disabled": disabled}, nil
+			})(w, r)
+		}))
+	}
+
+	mux.HandleFunc("PUT /vlans/{port}/impairment", manage(func(w http.ResponseWriter, r *http.Request) {
+		impairment, ok := decodeImpairment(w, r)
+		if !ok {
+			return
+		}
+		vlanHandler(func(port int) (interface{}, error) {
+			if err := sm.SetVLANImpairment(port, impairment); err != nil {
+				return nil, err
+			}
+			return map[string]interface{}{"port": port, "impairment": impairment}, nil
+		})(w, r)
+	}))
+
+	mux.HandleFunc("DELETE /vlans/{port}/impairment", manage(vlanHandler(func(port int) (interface{}, error) {
+		if err := sm.SetVLANImpairment(port, Impairment{}); err != nil {
+			return nil, err
+		}
+		return map[string]int{"port": port}, nil
+	})))
+
+	mux.HandleFunc("PUT /vlans/{port}/connections/{id}/impairment", manage(func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+		impairment, ok := decodeImpairment(w, r)
+		if !ok {
+			return
+		}
+		vlanHandler(func(port int) (interface{}, error) {
+			if err := sm.SetConnectionImpairment(port, id, &impairment); err != nil {
+				return nil, err
+			}
+			return map[string]interface{}{"id": id, "impairment": impairment}, nil
+		})(w, r)
+	}))
+
+	mux.HandleFunc("DELETE /vlans/{port}/connections/{id}/impairment", manage(func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+		vlanHandler(func(port int) (interface{}, error) {
+			if err := sm.SetConnectionImpairment(port, id, nil); err != nil {
+				return nil, err
+			}
+			return map[string]string{"id": id}, nil
+		})(w, r)
+	}))
+
+	mux.HandleFunc("POST /vlans/{port}/partitions", manage(func(w http.ResponseWriter, r *http.Request) {
+		port, err := strconv.Atoi(r.PathValue("port"))
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid port"})
+			return
+		}
+
+		var request struct {
+			Groups   [][]string `json:"groups"`
+			Duration string     `json:"duration"`
+		}
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&request); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request body"})
+			return
+		}
+		var duration time.Duration
+		if err := parseSettingDuration("duration", request.Duration, &duration); err != nil || duration < 0 {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid duration"})
+			return
+		}
+		if err := validatePartitionGroups(request.Groups); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+
+		partition, err := sm.Partition(port, request.Groups, duration)
+		if err != nil {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusCreated, partition)
+	}))
+
+	mux.HandleFunc("DELETE /vlans/{port}/partitions/{id}", manage(func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+		vlanHandler(func(port int) (interface{}, error) {
+			return sm.Heal(port, id)
+		})(w, r)
+	}))
+
+	mux.HandleFunc("DELETE /vlans/{port}/partitions", manage(vlanHandler(func(port int) (interface{}, error) {
+		return sm.Heal(port, "")
+	})))
+
+	mux.HandleFunc("POST /bridges", manage(func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			VLANs [2]int       `json:"vlans"`
+			Rules []BridgeRule `json:"rules"`
+		}
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&request); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request body"})
+			return
+		}
+		if request.VLANs[0] == request.VLANs[1] {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "a bridge needs two different VLANs"})
+			return
+		}
+		if _, err := compileBridgeRules(request.Rules); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+
+		bridge, err := sm.AddBridge(request.VLANs, request.Rules)
+		if err != nil {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusCreated, bridge)
+	}))
+
+	mux.HandleFunc("DELETE /bridges/{id}", manage(func(w http.ResponseWriter, r *http.Request) {
+		bridge, err := sm.RemoveBridge(r.PathValue("id"))
+		if err != nil {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, bridge)
+	}))
+
+	mux.HandleFunc("GET /vlans/{port}/capture", manage(func(w http.ResponseWriter, r *http.Request) {
+		port, err := strconv.Atoi(r.PathValue("port"))
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid port"})
+			return
+		}
+
+		if _, err := sm.GetVLANStats(port); err != nil {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/vnd.tcpdump.pcap")
+		if err := sm.StreamCapture(r.Context(), port, w); err != nil {
+			sm.logger().Debug("Capture stream ended", "vlan", port, "error", err)
+		}
+	}))
+
+	mux.HandleFunc("POST /vlans/{port}/replay", manage(func(w http.ResponseWriter, r *http.Request) {
+		port, err := strconv.Atoi(r.PathValue("port"))
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid port"})
+			return
+		}
+		speed := 1.0
+		if value := r.URL.Query().Get("speed"); value != "" {
+			if speed, err = strconv.ParseFloat(value, 64); err != nil {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid speed"})
+				return
+			}
+		}
+
+		if _, err := sm.GetVLANStats(port); err != nil {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+			return
+		}
+
+		result, err := sm.Replay(r.Context(), port, r.Body, speed)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, result)
+	}))
+
+	return mux
+}
+
+// addHealthHandlers routes the liveness and readiness checks. They answer
+// 503 Service Unavailable with the VLANs' health when the check fails.
+func addHealthHandlers(mux *http.ServeMux, sm *SwitchManager) {
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, _ *http.Request) {
+		vlans := sm.Health()
+		healthy := true
+		for _, vlan := range vlans {
+			healthy = healthy && vlan.Live
+		}
+		writeHealth(w, healthy, vlans)
+	})
+
+	mux.HandleFunc("GET /readyz", func(w http.ResponseWriter, _ *http.Request) {
+		vlans := sm.Health()
+		ready := len(vlans) > 0
+		for _, vlan := range vlans {
+			ready = ready && vlan.Ready
+		}
+		writeHealth(w, ready, vlans)
+	})
+}
+
+// writeHealth writes the result of a health check
+func writeHealth(w http.ResponseWriter, ok bool, vlans []VLANHealth) {
+	status, code := "ok", http.StatusOK
+	if !ok {
+		status, code = "unavailable", http.StatusServiceUnavailable
+	}
+	writeJSON(w, code, map[string]interface{}{"status": status, "vlans": vlans})
+}
+
+// managementHandler disables a state-changing handler unless management is
+// enabled, so an unauthenticated server stays read-only
+func managementHandler(enabled bool, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !enabled {
+			writeJSON(w, http.StatusForbidden, map[string]string{"error": "VLAN management requires an API token"})
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// requireToken rejects requests that do not carry the bearer token
+func requireToken(token string, next http.Handler) http.Handler {
+	expected := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expected) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="vswitch"`)
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// vlanHandler adapts a per-VLAN lookup to an HTTP handler, answering 404
+// for unknown VLANs
+func vlanHandler(lookup func(port int) (interface{}, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		port, err := strconv.Atoi(r.PathValue("port"))
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid port"})
+			return
+		}
+
+		result, err := lookup(port)
+		if err != nil {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+			return
+		}
+
+		writeJSON(w, http.StatusOK, result)
+	}
+}
+
+// decodeImpairment decodes the impairment of a request body, answering 400
+// if it is not valid
+func decodeImpairment(w http.ResponseWriter, r *http.Request) (Impairment, bool) {
+	var impairment Impairment
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&impairment)
+	if err == nil {
+		err = impairment.validate()
+	}
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid impairment: " + err.Error()})
+		return Impairment{}, false
+	}
+	return impairment, true
+}
+
+// writeJSON writes a value as an indented JSON response
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	// A failed write means the client went away, leaving no one to tell
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	_ = encoder.Encode(value)
+}
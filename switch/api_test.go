@@ -0,0 +1,246 @@
+package vswitch
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// getJSON requests a path from the handler and decodes the JSON response
+func getJSON(t *testing.T, handler http.Handler, path string, value interface{}) int {
+	t.Helper()
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+
+	if contentType := recorder.Header().Get("Content-Type"); contentType != "application/json" {
+		t.Errorf("%s: expected JSON content type, got %q", path, contentType)
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), value); err != nil {
+		t.Fatalf("%s: invalid JSON response: %v", path, err)
+	}
+
+	return recorder.Code
+}
+
+func TestAPIHandler(t *testing.T) {
+	sm := NewSwitchManager()
+	if err := sm.AddVLAN(8081); err != nil {
+		t.Fatalf("Failed to add VLAN: %v", err)
+	}
+	if err := sm.AddVLAN(8080); err != nil {
+		t.Fatalf("Failed to add VLAN: %v", err)
+	}
+
+	vs := sm.switches[8080]
+	conn := NewConnection("guest", &mockConnSwitch{addr: &mockAddrSwitch{network: "tcp", address: "127.0.0.1:9001"}})
+	conn.SetTrusted(true)
+	vs.connections.Store("guest", conn)
+	vs.learnMAC(net.HardwareAddr{0x52, 0x54, 0x00, 0x00, 0x00, 0x01}, conn)
+
+	handler := NewAPIHandler(sm, "")
+
+	var stats map[string]interface{}
+	if code := getJSON(t, handler, "/stats", &stats); code != http.StatusOK || stats["vlan_count"] != float64(2) {
+		t.Errorf("Unexpected /stats response %d: %v", code, stats)
+	}
+
+	var ports []int
+	if code := getJSON(t, handler, "/vlans", &ports); code != http.StatusOK || len(ports) != 2 || ports[0] != 8080 {
+		t.Errorf("Expected sorted VLAN ports, got %d: %v", code, ports)
+	}
+
+	var vlanStats map[string]interface{}
+	if code := getJSON(t, handler, "/vlans/8080", &vlanStats); code != http.StatusOK || vlanStats["mac_entries"] != float64(1) {
+		t.Errorf("Unexpected VLAN stats %d: %v", code, vlanStats)
+	}
+
+	var connections []ConnectionInfo
+	getJSON(t, handler, "/vlans/8080/connections", &connections)
+	if len(connections) != 1 || connections[0].ID != "guest" || !connections[0].Trusted ||
+		connections[0].Mode != "promiscuous" || connections[0].RemoteAddr != "127.0.0.1:9001" {
+		t.Errorf("Unexpected connections %+v", connections)
+	}
+
+	var macs []MACTableEntry
+	getJSON(t, handler, "/vlans/8080/macs", &macs)
+	if len(macs) != 1 || macs[0].MAC != "52:54:00:00:00:01" || macs[0].ConnectionID != "guest" {
+		t.Errorf("Unexpected MAC table %+v", macs)
+	}
+	if macs[0].VLAN != 8080 || macs[0].Static || macs[0].AgeSeconds != 0 {
+		t.Errorf("Expected a fresh dynamic entry on VLAN 8080, got %+v", macs[0])
+	}
+
+	vs.macBindings.Store("52:54:00:00:00:01", conn)
+	var allMACs []MACTableEntry
+	if code := getJSON(t, handler, "/macs", &allMACs); code != http.StatusOK || len(allMACs) != 1 || !allMACs[0].Static {
+		t.Errorf("Expected the sticky MAC to be static, got %d: %+v", code, allMACs)
+	}
+
+	var apiErr map[string]string
+	if code := getJSON(t, handler, "/vlans/9999/macs", &apiErr); code != http.StatusNotFound || apiErr["error"] == "" {
+		t.Errorf("Expected 404 for unknown VLAN, got %d: %v", code, apiErr)
+	}
+	if code := getJSON(t, handler, "/vlans/abc", &apiErr); code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for invalid port, got %d", code)
+	}
+}
+
+// apiRequest sends a request with an optional bearer token and returns the status code
+func apiRequest(handler http.Handler, method, path, body, token string) int {
+	request := httptest.NewRequest(method, path, strings.NewReader(body))
+	if token != "" {
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	return recorder.Code
+}
+
+func TestAPIManagement(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to find free port: %v", err)
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+	_ = listener.Close()
+	body := `{"port": ` + strconv.Itoa(port) + `}`
+	path := "/vlans/" + strconv.Itoa(port)
+
+	sm := NewSwitchManager()
+	defer sm.StopAll()
+
+	if code := apiRequest(NewAPIHandler(sm, ""), http.MethodPost, "/vlans", body, ""); code != http.StatusForbidden {
+		t.Errorf("Expected management to be disabled without a token, got %d", code)
+	}
+
+	handler := NewAPIHandler(sm, "secret")
+	if code := apiRequest(handler, http.MethodGet, "/stats", "", ""); code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 without token, got %d", code)
+	}
+	if code := apiRequest(handler, http.MethodPost, "/vlans", body, "wrong"); code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 with wrong token, got %d", code)
+	}
+	if code := apiRequest(handler, http.MethodPost, "/vlans", `{"port": 0}`, "secret"); code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for invalid port, got %d", code)
+	}
+
+	if code := apiRequest(handler, http.MethodPost, "/vlans", body, "secret"); code != http.StatusCreated {
+		t.Fatalf("Expected VLAN to be created, got %d", code)
+	}
+	if code := apiRequest(handler, http.MethodPost, "/vlans", body, "secret"); code != http.StatusConflict {
+		t.Errorf("Expected 409 for duplicate VLAN, got %d", code)
+	}
+
+	vs := sm.switches[port]
+	conn := NewConnection("guest", &mockConnSwitch{addr: &mockAddrSwitch{network: "tcp", address: "127.0.0.1:9001"}})
+	vs.connections.Store("guest", conn)
+
+	if code := apiRequest(handler, http.MethodPost, path+"/connections/guest/disable", "", "secret"); code != http.StatusOK || !conn.Disabled() {
+		t.Errorf("Expected connection to be disabled, got %d", code)
+	}
+	if code := apiRequest(handler, http.MethodPost, path+"/connections/guest/enable", "", "secret"); code != http.StatusOK || conn.Disabled() {
+		t.Errorf("Expected connection to be enabled, got %d", code)
+	}
+	if code := apiRequest(handler, http.MethodPost, path+"/disable", "", "secret"); code != http.StatusOK || !vs.disabled.Load() {
+		t.Errorf("Expected VLAN to be disabled, got %d", code)
+	}
+	if code := apiRequest(handler, http.MethodPost, path+"/enable", "", "secret"); code != http.StatusOK || vs.disabled.Load() {
+		t.Errorf("Expected VLAN to be enabled, got %d", code)
+	}
+	if code := apiRequest(NewAPIHandler(sm, ""), http.MethodPost, path+"/disable", "", ""); code != http.StatusForbidden {
+		t.Errorf("Expected disabling to require management, got %d", code)
+	}
+
+	if code := apiRequest(handler, http.MethodDelete, path+"/connections/guest", "", "secret"); code != http.StatusOK {
+		t.Errorf("Expected connection to be kicked, got %d", code)
+	}
+	if code := apiRequest(handler, http.MethodDelete, path+"/connections/missing", "", "secret"); code != http.StatusNotFound {
+		t.Errorf("Expected 404 for unknown connection, got %d", code)
+	}
+
+	if code := apiRequest(handler, http.MethodDelete, path, "", "secret"); code != http.StatusOK {
+		t.Errorf("Expected VLAN to be removed, got %d", code)
+	}
+	if code := apiRequest(handler, http.MethodDelete, path, "", "secret"); code != http.StatusNotFound {
+		t.Errorf("Expected 404 for removed VLAN, got %d", code)
+	}
+	if len(sm.GetVLANs()) != 0 {
+		t.Errorf("Expected no VLANs, got %v", sm.GetVLANs())
+	}
+}
+
+func TestAPIHealth(t *testing.T) {
+	sm := NewSwitchManager()
+	handler := NewAPIHandler(sm, "secret")
+
+	// Health checks need no token
+	if code := apiRequest(handler, http.MethodGet, "/healthz", "", ""); code != http.StatusOK {
+		t.Errorf("Expected /healthz to succeed, got %d", code)
+	}
+	if code := apiRequest(handler, http.MethodGet, "/readyz", "", ""); code != http.StatusServiceUnavailable {
+		t.Errorf("Expected /readyz to fail without VLANs, got %d", code)
+	}
+
+	if err := sm.AddVLAN(9999); err != nil {
+		t.Fatalf("Failed to add VLAN: %v", err)
+	}
+	vs := sm.switches[9999]
+	vs.listening.Store(1)
+
+	var health struct {
+		Status string       `json:"status"`
+		VLANs  []VLANHealth `json:"vlans"`
+	}
+	if code := getJSON(t, handler, "/readyz", &health); code != http.StatusOK || health.Status != "ok" {
+		t.Errorf("Expected /readyz to succeed, got %d %+v", code, health)
+	}
+	if len(health.VLANs) != 1 || health.VLANs[0].Port != 9999 {
+		t.Errorf("Expected the VLAN's health, got %+v", health.VLANs)
+	}
+
+	vs.listenerBeats[0].last.Store(time.Now().Add(-2 * heartbeatTimeout).UnixNano())
+	if code := getJSON(t, handler, "/healthz", &health); code != http.StatusServiceUnavailable || health.Status != "unavailable" {
+		t.Errorf("Expected /healthz to fail with a stuck loop, got %d %+v", code, health)
+	}
+	if code := apiRequest(NewAPIHandler(sm, ""), http.MethodGet, "/readyz", "", ""); code != http.StatusServiceUnavailable {
+		t.Errorf("Expected /readyz to fail with a stuck loop, got %d", code)
+	}
+}
+
+func TestAPIMetrics(t *testing.T) {
+	sm := NewSwitchManager()
+	if err := sm.AddVLAN(8080); err != nil {
+		t.Fatalf("Failed to add VLAN: %v", err)
+	}
+	sm.switches[8080].forwardLatency.observe(3 * time.Microsecond)
+
+	recorder := httptest.NewRecorder()
+	NewAPIHandler(sm, "").ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if recorder.Code != http.StatusOK || !strings.HasPrefix(recorder.Header().Get("Content-Type"), "text/plain") {
+		t.Fatalf("Unexpected /metrics response %d %q", recorder.Code, recorder.Header().Get("Content-Type"))
+	}
+
+	body := recorder.Body.String()
+	for _, line := range []string{
+		`vswitch_total_frames{vlan="8080"} 0`,
+		`vswitch_dropped_frames_by_reason{vlan="8080",reason="queue_overflow"} 0`,
+		`vswitch_frames_by_ethertype{vlan="8080",ethertype="ipv6"} 0`,
+		`vswitch_forwarding_latency_seconds_bucket{vlan="8080",le="2e-06"} 0`,
+		`vswitch_forwarding_latency_seconds_bucket{vlan="8080",le="5e-06"} 1`,
+		`vswitch_forwarding_latency_seconds_bucket{vlan="8080",le="+Inf"} 1`,
+		`vswitch_forwarding_latency_seconds_count{vlan="8080"} 1`,
+		`vswitch_frame_size_bytes_bucket{vlan="8080",le="1518"} 0`,
+		`vswitch_frame_size_bytes_count{vlan="8080"} 0`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("Expected metrics to contain %q, got:\n%s", line, body)
+		}
+	}
+}
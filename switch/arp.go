@@ -0,0 +1,69 @@
+package vswitch
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+)
+
+// ARP operation codes
+const (
+	arpRequest = 1
+	arpReply   = 2
+)
+
+// arpPacket is a parsed Ethernet/IPv4 ARP packet
+type arpPacket struct {
+	Op        uint16
+	SenderMAC net.HardwareAddr
+	SenderIP  net.IP
+	TargetMAC net.HardwareAddr
+	TargetIP  net.IP
+}
+
+// parseARP parses an Ethernet/IPv4 ARP packet
+func parseARP(data []byte) (*arpPacket, error) {
+	if len(data) < 28 {
+		return nil, fmt.Errorf("ARP packet too short: %d bytes", len(data))
+	}
+
+	if binary.BigEndian.Uint16(data[0:2]) != 1 || binary.BigEndian.Uint16(data[2:4]) != EtherTypeIPv4 ||
+		data[4] != 6 || data[5] != 4 {
+		return nil, fmt.Errorf("unsupported ARP hardware or protocol type")
+	}
+
+	return &arpPacket{
+		Op:        binary.BigEndian.Uint16(data[6:8]),
+		SenderMAC: net.HardwareAddr(data[8:14]),
+		SenderIP:  net.IP(data[14:18]),
+		TargetMAC: net.HardwareAddr(data[18:24]),
+		TargetIP:  net.IP(data[24:28]),
+	}, nil
+}
+
+// marshal serializes the ARP packet
+func (a *arpPacket) marshal() []byte {
+	data := make([]byte, 28)
+	binary.BigEndian.PutUint16(data[0:2], 1)
+	binary.BigEndian.PutUint16(data[2:4], EtherTypeIPv4)
+	data[4] = 6
+	data[5] = 4
+	binary.BigEndian.PutUint16(data[6:8], a.Op)
+	copy(data[8:14], a.SenderMAC)
+	copy(data[14:18], a.SenderIP.To4())
+	copy(data[18:24], a.TargetMAC)
+	copy(data[24:28], a.TargetIP.To4())
+	return data
+}
+
+// buildARPReply builds the Ethernet frame answering an ARP request with the given MAC
+func buildARPReply(request *arpPacket, mac net.HardwareAddr) []byte {
+	reply := &arpPacket{
+		Op:        arpReply,
+		SenderMAC: mac,
+		SenderIP:  request.TargetIP,
+		TargetMAC: request.SenderMAC,
+		TargetIP:  request.SenderIP,
+	}
+	return buildEthernet(request.SenderMAC, mac, EtherTypeARP, reply.marshal())
+}
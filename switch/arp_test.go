@@ -0,0 +1,48 @@
+package vswitch
+
+import (
+	"net"
+	"testing"
+)
+
+func TestARPMarshalParse(t *testing.T) {
+	request := &arpPacket{
+		Op:        arpRequest,
+		SenderMAC: net.HardwareAddr{0x52, 0x54, 0x00, 0x00, 0x00, 0x01},
+		SenderIP:  net.ParseIP("10.0.0.10"),
+		TargetMAC: make(net.HardwareAddr, 6),
+		TargetIP:  net.ParseIP("10.0.0.1"),
+	}
+
+	parsed, err := parseARP(request.marshal())
+	if err != nil {
+		t.Fatalf("Unexpected error parsing ARP: %v", err)
+	}
+
+	if parsed.Op != arpRequest || !parsed.SenderIP.Equal(request.SenderIP) || !parsed.TargetIP.Equal(request.TargetIP) {
+		t.Errorf("Unexpected ARP packet %+v", parsed)
+	}
+
+	mac := serviceMAC(8080)
+	frame, _ := ParseEthernetFrame(buildARPReply(parsed, mac))
+	if frame.DestMAC.String() != request.SenderMAC.String() || frame.EtherType != EtherTypeARP {
+		t.Errorf("Unexpected reply frame %s", frame)
+	}
+
+	reply, err := parseARP(frame.Payload)
+	if err != nil {
+		t.Fatalf("Unexpected error parsing reply: %v", err)
+	}
+
+	if reply.Op != arpReply || reply.SenderMAC.String() != mac.String() || !reply.SenderIP.Equal(request.TargetIP) {
+		t.Errorf("Unexpected ARP reply %+v", reply)
+	}
+
+	if _, err := parseARP(make([]byte, 10)); err == nil {
+		t.Errorf("Expected error for short ARP packet")
+	}
+
+	if _, err := parseARP(make([]byte, 28)); err == nil {
+		t.Errorf("Expected error for unsupported ARP types")
+	}
+}
@@ -0,0 +1,127 @@
+package vswitch
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+// arpSuppressor answers ARP requests from the addresses learned on the
+// VLAN instead of flooding them, keeping broadcasts off the trunks of a
+// VLAN stretched across hosts. Addresses are learned from the sender of
+// every ARP packet, including those arriving over trunks, and answered
+// for while their MAC is in the MAC table.
+type arpSuppressor struct {
+	vs *VirtualSwitch
+
+	mutex     sync.RWMutex
+	neighbors map[[4]byte]macKey // IPv4 -> MAC
+
+	suppressed atomic.Uint64
+}
+
+// newARPSuppressor creates an ARP suppressor with no learned addresses
+func newARPSuppressor(vs *VirtualSwitch) *arpSuppressor {
+	return &arpSuppressor{
+		vs:        vs,
+		neighbors: make(map[[4]byte]macKey),
+	}
+}
+
+// handleFrame learns the sender of ARP packets and answers and consumes
+// requests for known addresses
+func (s *arpSuppressor) handleFrame(frame *EthernetFrame, _ *Connection) bool {
+	if frame.EtherType != EtherTypeARP {
+		return false
+	}
+
+	packet, err := parseARP(frame.Payload)
+	if err != nil {
+		return false
+	}
+
+	// Probes come from the unspecified address and teach nothing
+	sender := packet.SenderIP.To4()
+	if sender == nil || sender.IsUnspecified() {
+		return false
+	}
+	s.learn(sender, packet.SenderMAC)
+
+	// Announcements and probes are for everyone to see
+	if packet.Op != arpRequest || packet.TargetIP.Equal(packet.SenderIP) {
+		return false
+	}
+
+	mac, found := s.lookup(packet.TargetIP)
+	if !found || mac.String() == packet.SenderMAC.String() {
+		return false
+	}
+
+	s.vs.injectFrame(buildARPReply(packet, mac))
+	s.suppressed.Add(1)
+	return true
+}
+
+// learn records the MAC of an address
+func (s *arpSuppressor) learn(ip net.IP, mac net.HardwareAddr) {
+	key := [4]byte(ip.To4())
+	value := newMACKey(mac)
+
+	s.mutex.RLock()
+	current, found := s.neighbors[key]
+	s.mutex.RUnlock()
+	if found && current == value {
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.neighbors[key] = value
+}
+
+// lookup returns the MAC of an address while the MAC is still reachable
+// on the VLAN
+func (s *arpSuppressor) lookup(ip net.IP) (net.HardwareAddr, bool) {
+	ip4 := ip.To4()
+	if ip4 == nil {
+		return nil, false
+	}
+
+	s.mutex.RLock()
+	key, found := s.neighbors[[4]byte(ip4)]
+	s.mutex.RUnlock()
+	if !found {
+		return nil, false
+	}
+
+	mac := net.HardwareAddr(key[:])
+	if s.vs.lookupMAC(mac) == nil {
+		// Forget addresses whose MAC aged out; the owner is asked again
+		s.mutex.Lock()
+		if s.neighbors[[4]byte(ip4)] == key {
+			delete(s.neighbors, [4]byte(ip4))
+		}
+		s.mutex.Unlock()
+		return nil, false
+	}
+	return mac, true
+}
+
+// addresses returns the addresses learned for a MAC
+func (s *arpSuppressor) addresses(mac net.HardwareAddr) []net.IP {
+	value := newMACKey(mac)
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	var addresses []net.IP
+	for ip, key := range s.neighbors {
+		if key == value {
+			addresses = append(addresses, net.IP(append([]byte{}, ip[:]...)))
+		}
+	}
+	return addresses
+}
+
+// run returns immediately; the suppressor is driven entirely by received
+// frames
+func (s *arpSuppressor) run(_ <-chan bool) {}
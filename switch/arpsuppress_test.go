@@ -0,0 +1,72 @@
+package vswitch
+
+import (
+	"net"
+	"testing"
+)
+
+func TestARPSuppression(t *testing.T) {
+	sw := NewVirtualSwitchWithConfig([]int{8080}, Config{ARPSuppression: true})
+
+	mockGuest := &mockConnSwitch{addr: &mockAddrSwitch{network: "tcp", address: "127.0.0.1:9001"}}
+	mockTrunk := &mockConnSwitch{addr: &mockAddrSwitch{network: "tcp", address: "127.0.0.1:9002"}}
+	guest := NewConnection("guest", mockGuest)
+	trunk := NewConnection("trunk", mockTrunk)
+	trunk.SetTrunk(true)
+	sw.connections.Store("guest", guest)
+	sw.connections.Store("trunk", trunk)
+
+	guestMAC := net.HardwareAddr{0x52, 0x54, 0x00, 0x00, 0x00, 0x01}
+	remoteMAC := net.HardwareAddr{0x52, 0x54, 0x00, 0x00, 0x00, 0x02}
+	arpFrame := func(op uint16, sender net.HardwareAddr, senderIP, targetIP string) *EthernetFrame {
+		arp := &arpPacket{
+			Op:        op,
+			SenderMAC: sender,
+			SenderIP:  net.ParseIP(senderIP),
+			TargetMAC: make(net.HardwareAddr, 6),
+			TargetIP:  net.ParseIP(targetIP),
+		}
+		return rawFrame(buildEthernet(BroadcastMAC, sender, EtherTypeARP, arp.marshal()))
+	}
+
+	// Unknown addresses are asked for across the trunk
+	_ = sw.processFrame(arpFrame(arpRequest, guestMAC, "10.0.0.1", "10.0.0.2"), guest)
+	if len(mockTrunk.writeData) == 0 {
+		t.Fatal("Expected a request for an unknown address to be flooded")
+	}
+
+	// The remote VM's announcement teaches its address
+	_ = sw.processFrame(arpFrame(arpReply, remoteMAC, "10.0.0.2", "10.0.0.2"), trunk)
+	mockTrunk.writeData = nil
+	mockGuest.writeData = nil
+
+	// Later requests are answered locally with the remote VM's MAC
+	_ = sw.processFrame(arpFrame(arpRequest, guestMAC, "10.0.0.1", "10.0.0.2"), guest)
+	if len(mockTrunk.writeData) != 0 {
+		t.Error("Expected a request for a known address not to cross the trunk")
+	}
+	frames := writtenFrames(t, mockGuest.writeData)
+	if len(frames) != 1 {
+		t.Fatalf("Expected an ARP reply, got %d frames", len(frames))
+	}
+	if reply, _ := parseARP(rawFrame(frames[0]).Payload); reply.Op != arpReply || reply.SenderMAC.String() != remoteMAC.String() {
+		t.Errorf("Expected a reply with the remote MAC, got %+v", reply)
+	}
+	if suppressed := sw.GetStats()["arp_suppressed"].(uint64); suppressed != 1 {
+		t.Errorf("Expected 1 suppressed request, got %d", suppressed)
+	}
+
+	// Probes are flooded so that the owner defends its address
+	_ = sw.processFrame(arpFrame(arpRequest, guestMAC, "0.0.0.0", "10.0.0.2"), guest)
+	if len(mockTrunk.writeData) == 0 {
+		t.Error("Expected a probe to be flooded")
+	}
+
+	// Once the remote MAC is gone, the owner is asked again
+	sw.flushMACs(trunk)
+	mockTrunk.writeData = nil
+	_ = sw.processFrame(arpFrame(arpRequest, guestMAC, "10.0.0.1", "10.0.0.2"), guest)
+	if len(mockTrunk.writeData) == 0 {
+		t.Error("Expected a request for an address whose MAC aged out to be flooded")
+	}
+}
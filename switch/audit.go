@@ -0,0 +1,193 @@
+package vswitch
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// auditBodyLimit is the largest request body recorded in the audit log
+const auditBodyLimit = 4096
+
+// AuditEntry records one management operation
+type AuditEntry struct {
+	Time       time.Time         `json:"time"`
+	Principal  string            `json:"principal"`
+	Action     string            `json:"action"`
+	Parameters map[string]string `json:"parameters,omitempty"`
+	Body       json.RawMessage   `json:"body,omitempty"`
+	Status     int               `json:"status"`
+}
+
+// AuditLog appends management operations to a file as JSON lines. The
+// file is only ever appended to, so that it can be made append-only with
+// chattr +a and shipped elsewhere as it grows.
+type AuditLog struct {
+	mutex sync.Mutex
+	file  *os.File
+}
+
+// OpenAuditLog opens the audit log at path for appending, creating it
+// readable only by its owner if needed
+func OpenAuditLog(path string) (*AuditLog, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return &AuditLog{file: file}, nil
+}
+
+// Record appends an entry and syncs it to disk, so that operations are on
+// record even if the switch crashes right after them
+func (a *AuditLog) Record(entry AuditEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if _, err := a.file.Write(line); err != nil {
+		return err
+	}
+	return a.file.Sync()
+}
+
+// Close closes the audit log
+func (a *AuditLog) Close() error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	return a.file.Close()
+}
+
+// SetAuditLog records the management operations received through the API
+// and the control socket in log; nil stops recording them
+func (sm *SwitchManager) SetAuditLog(log *AuditLog) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	sm.auditLog = log
+}
+
+// auditLogger returns the audit log of the manager, or nil
+func (sm *SwitchManager) auditLogger() *AuditLog {
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+
+	return sm.auditLog
+}
+
+// principalKey is the context key of the principal making a request
+type principalKey struct{}
+
+// ControlConnContext tags the requests received on a control socket
+// connection with the user of the process on the other end, for use as
+// the ConnContext of the control socket's server
+func ControlConnContext(ctx context.Context, conn net.Conn) context.Context {
+	return context.WithValue(ctx, principalKey{}, "control "+peerCredentials(conn))
+}
+
+// requestPrincipal names who made a request: the control socket user, or
+// the address of an API client
+func requestPrincipal(r *http.Request) string {
+	if principal, ok := r.Context().Value(principalKey{}).(string); ok {
+		return principal
+	}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return "api " + host
+	}
+	return "api " + r.RemoteAddr
+}
+
+// statusRecorder remembers the status of a response
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+// WriteHeader records the status before sending it
+func (s *statusRecorder) WriteHeader(status int) {
+	if s.status == 0 {
+		s.status = status
+	}
+	s.ResponseWriter.WriteHeader(status)
+}
+
+// Write records an implicit 200 OK
+func (s *statusRecorder) Write(data []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	return s.ResponseWriter.Write(data)
+}
+
+// Flush passes flushes on to streaming handlers' writers
+func (s *statusRecorder) Flush() {
+	if flusher, ok := s.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// audited records the requests served by a management handler in the
+// manager's audit log, with their path parameters, body and status
+func (sm *SwitchManager) audited(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log := sm.auditLogger()
+		if log == nil {
+			handler(w, r)
+			return
+		}
+
+		entry := AuditEntry{
+			Time:       time.Now(),
+			Principal:  requestPrincipal(r),
+			Action:     r.Pattern,
+			Parameters: pathParameters(r),
+		}
+
+		// Keep a copy of the body for the record while the handler reads it
+		if r.Body != nil {
+			body, err := io.ReadAll(io.LimitReader(r.Body, auditBodyLimit+1))
+			if err == nil && len(body) <= auditBodyLimit && json.Valid(body) {
+				entry.Body = body
+			}
+			r.Body = struct {
+				io.Reader
+				io.Closer
+			}{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+		}
+
+		recorder := &statusRecorder{ResponseWriter: w}
+		handler(recorder, r)
+
+		entry.Status = recorder.status
+		if err := log.Record(entry); err != nil {
+			sm.logger().Error("Failed to write audit log", "action", entry.Action, "principal", entry.Principal, "error", err)
+		}
+	}
+}
+
+// pathParameters returns the wildcards of the pattern a request matched
+func pathParameters(r *http.Request) map[string]string {
+	var parameters map[string]string
+	for _, segment := range strings.Split(r.Pattern, "/") {
+		if !strings.HasPrefix(segment, "{") || !strings.HasSuffix(segment, "}") {
+			continue
+		}
+		name := strings.TrimSuffix(strings.TrimPrefix(segment, "{"), "}")
+		if parameters == nil {
+			parameters = make(map[string]string)
+		}
+		parameters[name] = r.PathValue(name)
+	}
+	return parameters
+}
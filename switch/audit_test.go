@@ -0,0 +1,84 @@
+package vswitch
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAuditLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	auditLog, err := OpenAuditLog(path)
+	if err != nil {
+		t.Fatalf("Failed to open audit log: %v", err)
+	}
+
+	sm := NewSwitchManager()
+	defer sm.StopAll()
+	if err := sm.AddVLANWithConfig(8080, DefaultConfig()); err != nil {
+		t.Fatalf("Failed to add VLAN: %v", err)
+	}
+	sm.SetAuditLog(auditLog)
+
+	handler := NewAPIHandler(sm, "secret")
+	if code := apiRequest(handler, http.MethodPost, "/vlans/8080/disable", "", "secret"); code != http.StatusOK {
+		t.Errorf("Expected VLAN to be disabled, got %d", code)
+	}
+	if code := apiRequest(handler, http.MethodDelete, "/vlans/8080/connections/vm-1", "", "secret"); code != http.StatusNotFound {
+		t.Errorf("Expected 404 kicking an unknown connection, got %d", code)
+	}
+	if code := apiRequest(handler, http.MethodPut, "/vlans/8080/peers/10.0.0.5", `{"label": "lab/vm-a"}`, "secret"); code != http.StatusOK {
+		t.Errorf("Expected peer to be labeled, got %d", code)
+	}
+
+	// Reading state is not a management operation
+	if code := apiRequest(handler, http.MethodGet, "/vlans/8080", "", "secret"); code != http.StatusOK {
+		t.Errorf("Expected VLAN statistics, got %d", code)
+	}
+
+	// The control socket names its user
+	request := httptest.NewRequest(http.MethodPost, "/vlans/8080/enable", nil)
+	request = request.WithContext(ControlConnContext(request.Context(), nil))
+	NewControlHandler(sm).ServeHTTP(httptest.NewRecorder(), request)
+
+	if err := auditLog.Close(); err != nil {
+		t.Fatalf("Failed to close audit log: %v", err)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Failed to read audit log: %v", err)
+	}
+	defer func() { _ = file.Close() }()
+
+	var entries []AuditEntry
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatalf("Invalid audit log line %q: %v", scanner.Text(), err)
+		}
+		entries = append(entries, entry)
+	}
+
+	if len(entries) != 4 {
+		t.Fatalf("Expected 4 audit entries, got %d: %+v", len(entries), entries)
+	}
+	if entry := entries[0]; entry.Action != "POST /vlans/{port}/disable" || entry.Parameters["port"] != "8080" || entry.Status != http.StatusOK || entry.Principal != "api 192.0.2.1" || entry.Time.IsZero() {
+		t.Errorf("Unexpected entry for disabling the VLAN: %+v", entry)
+	}
+	if entry := entries[1]; entry.Parameters["id"] != "vm-1" || entry.Status != http.StatusNotFound {
+		t.Errorf("Expected the failed kick with its status, got %+v", entry)
+	}
+	if entry := entries[2]; entry.Parameters["address"] != "10.0.0.5" || !strings.Contains(string(entry.Body), "lab/vm-a") {
+		t.Errorf("Expected the peer label with its body, got %+v", entry)
+	}
+	if entry := entries[3]; !strings.HasPrefix(entry.Principal, "control ") || entry.Action != "POST /vlans/{port}/enable" {
+		t.Errorf("Expected the control socket user as principal, got %+v", entry)
+	}
+}
@@ -0,0 +1,90 @@
+package vswitch
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// bindRetryMin and bindRetryMax bound the exponential backoff between
+	// attempts to bind a port
+	bindRetryMin = 100 * time.Millisecond
+	bindRetryMax = 5 * time.Second
+)
+
+// bindRetries tracks the ports of a VLAN that failed to bind when it
+// started and are being retried
+type bindRetries struct {
+	pending  atomic.Int32
+	failures atomic.Uint64
+
+	mutex     sync.Mutex
+	lastError string
+	next      time.Time
+}
+
+// failed records a failed attempt and when the next one is due
+func (b *bindRetries) failed(err error, next time.Time) {
+	b.failures.Add(1)
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.lastError = err.Error()
+	b.next = next
+}
+
+// addStats adds the retry state to the statistics of the VLAN
+func (b *bindRetries) addStats(stats map[string]interface{}) {
+	pending := b.pending.Load()
+	stats["bind_pending"] = pending
+	stats["bind_failures"] = b.failures.Load()
+	if pending == 0 {
+		return
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	stats["bind_error"] = b.lastError
+	stats["bind_next_attempt"] = b.next
+}
+
+// retryListen keeps trying to bind the i-th port of the VLAN with
+// exponential backoff, until it succeeds, the switch stops or BindRetry has
+// passed since the first attempt
+func (vs *VirtualSwitch) retryListen(i int, start time.Time) {
+	defer vs.wg.Done()
+	defer vs.bindRetries.pending.Add(-1)
+
+	port := vs.ports[i]
+	backoff := bindRetryMin
+	deadline := start.Add(vs.config.BindRetry)
+	for {
+		timer := time.NewTimer(backoff)
+		select {
+		case <-vs.shutdown:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		listeners, err := vs.listen(port)
+		if err == nil {
+			vs.logger.Info("Listening", "port", port, "addr", listeners[0].Addr().String(), "listeners", len(listeners))
+			vs.serve(i, listeners)
+			return
+		}
+		err = fmt.Errorf("failed to listen on port %d: %w", port, err)
+
+		backoff = min(2*backoff, bindRetryMax)
+		if time.Now().Add(backoff).After(deadline) {
+			vs.bindRetries.failed(err, time.Time{})
+			vs.startFailure.Store(err.Error())
+			vs.fail(err)
+			return
+		}
+		vs.bindRetries.failed(err, time.Now().Add(backoff))
+		vs.logger.Debug("Failed to listen, retrying", "port", port, "error", err, "backoff", backoff)
+	}
+}
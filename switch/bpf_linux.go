@@ -0,0 +1,204 @@
+package vswitch
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"runtime"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// eBPF opcodes of the instructions the fast path program uses
+const (
+	bpfLoadMapFD      = 0x18 // r = map fd, spanning two instructions
+	bpfLoadByte       = 0x71 // r = *(u8 *)(r + off)
+	bpfLoadWord       = 0x61 // r = *(u32 *)(r + off)
+	bpfLoadDouble     = 0x79 // r = *(u64 *)(r + off)
+	bpfStoreByte      = 0x73 // *(u8 *)(r + off) = r
+	bpfStoreWordImm   = 0x62 // *(u32 *)(r + off) = imm
+	bpfStoreDoubleImm = 0x7a // *(u64 *)(r + off) = imm
+	bpfAtomicAdd      = 0xdb // lock *(u64 *)(r + off) += r
+	bpfMovImm         = 0xb7 // r = imm
+	bpfMovReg         = 0xbf // r = r
+	bpfAddImm         = 0x07 // r += imm
+	bpfAndImm         = 0x57 // r &= imm
+	bpfJumpEqImm      = 0x15 // if r == imm goto off
+	bpfJumpNeImm      = 0x55 // if r != imm goto off
+	bpfJumpEqReg      = 0x1d // if r == r goto off
+	bpfJumpNeReg      = 0x5d // if r != r goto off
+	bpfJumpGtReg      = 0x2d // if r > r goto off
+	bpfCall           = 0x85 // call helper imm
+	bpfExit           = 0x95 // return r0
+)
+
+// eBPF helpers the fast path program calls
+const (
+	bpfMapLookupElem = 1
+	bpfRedirect      = 23
+)
+
+// bpfPseudoMapFD marks a 64-bit load of a map's file descriptor, which the
+// kernel replaces with the map
+const bpfPseudoMapFD = 1
+
+// bpfInsn is an eBPF instruction. Jumps name their target by label, which
+// assembling turns into an offset.
+type bpfInsn struct {
+	code     uint8
+	dst, src uint8
+	off      int16
+	imm      int32
+
+	label  string // label of this instruction
+	target string // label a jump goes to
+}
+
+// nativeBigEndian tells whether the register nibbles of instructions are
+// in big-endian order
+var nativeBigEndian = binary.NativeEndian.Uint16([]byte{0, 1}) == 1
+
+// assembleBPF resolves the jump labels of a program and encodes it
+func assembleBPF(insns []bpfInsn) ([]byte, error) {
+	labels := make(map[string]int)
+	for i, insn := range insns {
+		if insn.label != "" {
+			labels[insn.label] = i
+		}
+	}
+
+	program := make([]byte, 8*len(insns))
+	for i, insn := range insns {
+		if insn.target != "" {
+			target, found := labels[insn.target]
+			if !found {
+				return nil, fmt.Errorf("unknown label %q", insn.target)
+			}
+			insn.off = int16(target - i - 1)
+		}
+
+		b := program[8*i:]
+		b[0] = insn.code
+		if nativeBigEndian {
+			b[1] = insn.dst<<4 | insn.src
+		} else {
+			b[1] = insn.src<<4 | insn.dst
+		}
+		binary.NativeEndian.PutUint16(b[2:], uint16(insn.off))
+		binary.NativeEndian.PutUint32(b[4:], uint32(insn.imm))
+	}
+	return program, nil
+}
+
+// bpfPointer pins the object ptr points to for as long as the kernel may
+// use it and returns its address as a bpf_attr holds it
+func bpfPointer(pinner *runtime.Pinner, ptr unsafe.Pointer) uint64 {
+	pinner.Pin(ptr)
+	return uint64(uintptr(ptr))
+}
+
+// bpfMapCreateAttr is the bpf_attr of BPF_MAP_CREATE
+type bpfMapCreateAttr struct {
+	mapType    uint32
+	keySize    uint32
+	valueSize  uint32
+	maxEntries uint32
+	flags      uint32
+}
+
+// bpfMapElemAttr is the bpf_attr of the BPF_MAP_*_ELEM commands
+type bpfMapElemAttr struct {
+	mapFD uint32
+	_     uint32
+	key   uint64
+	value uint64
+	flags uint64
+}
+
+// bpfProgLoadAttr is the bpf_attr of BPF_PROG_LOAD
+type bpfProgLoadAttr struct {
+	progType    uint32
+	insnCount   uint32
+	insns       uint64
+	license     uint64
+	logLevel    uint32
+	logSize     uint32
+	logBuf      uint64
+	kernVersion uint32
+	flags       uint32
+}
+
+// bpf runs a bpf command
+func bpf(cmd int, attr unsafe.Pointer, size uintptr) (int, error) {
+	r, _, errno := unix.Syscall(unix.SYS_BPF, uintptr(cmd), uintptr(attr), size)
+	if errno != 0 {
+		return -1, errno
+	}
+	return int(r), nil
+}
+
+// bpfCreateMap creates a map and returns its file descriptor
+func bpfCreateMap(mapType, keySize, valueSize, maxEntries uint32) (int, error) {
+	attr := bpfMapCreateAttr{mapType: mapType, keySize: keySize, valueSize: valueSize, maxEntries: maxEntries}
+	return bpf(unix.BPF_MAP_CREATE, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+}
+
+// bpfUpdateElem sets the value of a key of a map
+func bpfUpdateElem(fd int, key, value unsafe.Pointer) error {
+	var pinner runtime.Pinner
+	defer pinner.Unpin()
+
+	attr := bpfMapElemAttr{mapFD: uint32(fd), key: bpfPointer(&pinner, key), value: bpfPointer(&pinner, value), flags: unix.BPF_ANY}
+	_, err := bpf(unix.BPF_MAP_UPDATE_ELEM, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+	return err
+}
+
+// bpfLookupElem reads the value of a key of a map
+func bpfLookupElem(fd int, key, value unsafe.Pointer) error {
+	var pinner runtime.Pinner
+	defer pinner.Unpin()
+
+	attr := bpfMapElemAttr{mapFD: uint32(fd), key: bpfPointer(&pinner, key), value: bpfPointer(&pinner, value)}
+	_, err := bpf(unix.BPF_MAP_LOOKUP_ELEM, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+	return err
+}
+
+// bpfDeleteElem removes a key from a map
+func bpfDeleteElem(fd int, key unsafe.Pointer) error {
+	var pinner runtime.Pinner
+	defer pinner.Unpin()
+
+	attr := bpfMapElemAttr{mapFD: uint32(fd), key: bpfPointer(&pinner, key)}
+	_, err := bpf(unix.BPF_MAP_DELETE_ELEM, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+	return err
+}
+
+// bpfLoadProgram loads a program and returns its file descriptor. A
+// program the verifier rejects fails with the last lines of the verifier's
+// log.
+func bpfLoadProgram(progType uint32, program []byte, license string) (int, error) {
+	var pinner runtime.Pinner
+	defer pinner.Unpin()
+
+	licenseBuf := append([]byte(license), 0)
+	log := make([]byte, 64*1024)
+	attr := bpfProgLoadAttr{
+		progType:  progType,
+		insnCount: uint32(len(program) / 8),
+		insns:     bpfPointer(&pinner, unsafe.Pointer(&program[0])),
+		license:   bpfPointer(&pinner, unsafe.Pointer(&licenseBuf[0])),
+		logLevel:  1,
+		logSize:   uint32(len(log)),
+		logBuf:    bpfPointer(&pinner, unsafe.Pointer(&log[0])),
+	}
+	fd, err := bpf(unix.BPF_PROG_LOAD, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+	if err != nil {
+		if end := bytes.IndexByte(log, 0); end > 0 {
+			lines := bytes.Split(bytes.TrimSpace(log[:end]), []byte("\n"))
+			return -1, fmt.Errorf("%v: %s", err, bytes.Join(lines[max(0, len(lines)-3):], []byte("; ")))
+		}
+		return -1, err
+	}
+	return fd, nil
+}
@@ -0,0 +1,393 @@
+package vswitch
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// bridgeHorizon is the split horizon group of the connections bridges add
+// to VLANs, so that frames never cross from one bridge to another and
+// bridges between three VLANs cannot loop
+const bridgeHorizon = "bridges"
+
+// bridgeQueueSize is the depth of the queue of frames waiting to cross a
+// bridge in each direction; frames beyond it are dropped
+const bridgeQueueSize = 256
+
+// Directions of a bridge rule
+const (
+	// BridgeBoth passes matching frames both ways
+	BridgeBoth = "both"
+	// BridgeForward passes matching frames from the first VLAN to the second
+	BridgeForward = "forward"
+	// BridgeReverse passes matching frames from the second VLAN to the first
+	BridgeReverse = "reverse"
+)
+
+// BridgeRule selects frames a bridge lets cross between its VLANs. The MACs
+// and EtherType a rule sets must all match; a rule passing both ways swaps
+// its source and destination MACs for frames from the second VLAN, so a
+// MAC pair lets its two hosts talk.
+type BridgeRule struct {
+	SrcMAC    string `json:"src_mac,omitempty"`
+	DstMAC    string `json:"dst_mac,omitempty"`
+	EtherType uint16 `json:"ethertype,omitempty"`
+
+	// Direction is BridgeBoth, the default, BridgeForward or BridgeReverse
+	Direction string `json:"direction,omitempty"`
+}
+
+// Bridge passes the frames selected by its rules between two VLANs, which
+// otherwise stay isolated, e.g. to reach a jump host from several segments.
+// It is attached to each VLAN as a connection.
+type Bridge struct {
+	ID    string       `json:"id"`
+	VLANs [2]int       `json:"vlans"`
+	Rules []BridgeRule `json:"rules"`
+
+	// Forwarded counts the frames that crossed the bridge, Filtered those
+	// no rule selected and Dropped those its queues had no room for
+	Forwarded uint64 `json:"forwarded"`
+	Filtered  uint64 `json:"filtered"`
+	Dropped   uint64 `json:"dropped"`
+}
+
+// bridgeRule is a BridgeRule with parsed MACs
+type bridgeRule struct {
+	src, dst  net.HardwareAddr
+	etherType uint16
+	direction string
+}
+
+// compile validates the rule and parses its MACs
+func (r BridgeRule) compile() (bridgeRule, error) {
+	rule := bridgeRule{etherType: r.EtherType, direction: r.Direction}
+	switch r.Direction {
+	case "":
+		rule.direction = BridgeBoth
+	case BridgeBoth, BridgeForward, BridgeReverse:
+	default:
+		return rule, fmt.Errorf("invalid bridge direction '%s'", r.Direction)
+	}
+
+	for _, mac := range []struct {
+		value  string
+		parsed *net.HardwareAddr
+	}{{r.SrcMAC, &rule.src}, {r.DstMAC, &rule.dst}} {
+		if mac.value == "" {
+			continue
+		}
+		parsed, err := net.ParseMAC(mac.value)
+		if err != nil || len(parsed) != 6 {
+			return rule, fmt.Errorf("invalid bridge rule MAC '%s'", mac.value)
+		}
+		*mac.parsed = parsed
+	}
+	if rule.src == nil && rule.dst == nil && rule.etherType == 0 {
+		return rule, fmt.Errorf("a bridge rule needs a MAC or an EtherType")
+	}
+	return rule, nil
+}
+
+// matches reports whether the rule passes a frame crossing the bridge
+// forward, from its first VLAN, or in reverse
+func (r *bridgeRule) matches(frame *EthernetFrame, forward bool) bool {
+	if (forward && r.direction == BridgeReverse) || (!forward && r.direction == BridgeForward) {
+		return false
+	}
+	src, dst := r.src, r.dst
+	if !forward && r.direction == BridgeBoth {
+		src, dst = dst, src
+	}
+	return (src == nil || bytes.Equal(frame.SrcMAC, src)) &&
+		(dst == nil || bytes.Equal(frame.DestMAC, dst)) &&
+		(r.etherType == 0 || frame.EtherType == r.etherType)
+}
+
+// compileBridgeRules validates the rules of a bridge
+func compileBridgeRules(rules []BridgeRule) ([]bridgeRule, error) {
+	if len(rules) == 0 {
+		return nil, fmt.Errorf("a bridge needs at least one rule")
+	}
+	compiled := make([]bridgeRule, len(rules))
+	for i, rule := range rules {
+		var err error
+		if compiled[i], err = rule.compile(); err != nil {
+			return nil, err
+		}
+	}
+	return compiled, nil
+}
+
+// ParseBridgeRule parses comma-separated name=value terms, e.g.
+// src=52:54:00:00:00:01,ethertype=arp,direction=forward, into a rule.
+// EtherTypes are numbers such as 0x0806 or one of arp, ipv4, ipv6 and lldp.
+func ParseBridgeRule(spec string) (BridgeRule, error) {
+	var rule BridgeRule
+	for _, term := range strings.Split(spec, ",") {
+		term = strings.TrimSpace(term)
+		if term == "" {
+			continue
+		}
+		name, value, found := strings.Cut(term, "=")
+		if !found {
+			return BridgeRule{}, fmt.Errorf("invalid bridge rule '%s': expected name=value", term)
+		}
+
+		switch name {
+		case "src":
+			rule.SrcMAC = value
+		case "dst":
+			rule.DstMAC = value
+		case "ethertype":
+			etherType, err := parseEtherType(value)
+			if err != nil {
+				return BridgeRule{}, err
+			}
+			rule.EtherType = etherType
+		case "direction":
+			rule.Direction = value
+		default:
+			return BridgeRule{}, fmt.Errorf("unknown bridge rule term '%s'", name)
+		}
+	}
+	_, err := rule.compile()
+	return rule, err
+}
+
+// String formats the rule as ParseBridgeRule parses it
+func (r BridgeRule) String() string {
+	var terms []string
+	if r.SrcMAC != "" {
+		terms = append(terms, "src="+r.SrcMAC)
+	}
+	if r.DstMAC != "" {
+		terms = append(terms, "dst="+r.DstMAC)
+	}
+	if r.EtherType != 0 {
+		terms = append(terms, fmt.Sprintf("ethertype=0x%04x", r.EtherType))
+	}
+	if r.Direction != "" && r.Direction != BridgeBoth {
+		terms = append(terms, "direction="+r.Direction)
+	}
+	return strings.Join(terms, ",")
+}
+
+// etherTypeNames are the EtherTypes parseEtherType knows by name
+var etherTypeNames = map[string]uint16{
+	"arp":  EtherTypeARP,
+	"ipv4": EtherTypeIPv4,
+	"ipv6": EtherTypeIPv6,
+	"lldp": EtherTypeLLDP,
+}
+
+// parseEtherType parses an EtherType given as a number or by name
+func parseEtherType(value string) (uint16, error) {
+	if etherType, found := etherTypeNames[strings.ToLower(value)]; found {
+		return etherType, nil
+	}
+	etherType, err := strconv.ParseUint(value, 0, 16)
+	if err != nil || etherType < 0x0600 {
+		return 0, fmt.Errorf("invalid EtherType '%s'", value)
+	}
+	return uint16(etherType), nil
+}
+
+// bridge is an active bridge between two VLANs
+type bridge struct {
+	Bridge
+	rules     []bridgeRule
+	forwarded atomic.Uint64
+	filtered  atomic.Uint64
+
+	// ends are the bridge's sides of its connections to each VLAN
+	ends    [2]*Connection
+	closing sync.Once
+	closed  func()
+}
+
+// newBridge connects a bridge to its two VLANs and starts passing frames.
+// closed is called once either VLAN goes away and the bridge with it.
+func newBridge(id string, vlans [2]*VirtualSwitch, rules []BridgeRule, closed func()) (*bridge, error) {
+	compiled, err := compileBridgeRules(rules)
+	if err != nil {
+		return nil, err
+	}
+
+	b := &bridge{
+		Bridge: Bridge{ID: id, VLANs: [2]int{vlans[0].port(), vlans[1].port()}, Rules: append([]BridgeRule(nil), rules...)},
+		rules:  compiled,
+		closed: closed,
+	}
+	for i, vs := range vlans {
+		client, server := net.Pipe()
+		connID := fmt.Sprintf("bridge-%s-%d", id, b.VLANs[1-i])
+		vs.attach(connID, server, func(c *Connection) { c.SetHorizon(bridgeHorizon) })
+
+		// Frames wait in a queue to cross, so that a busy VLAN never
+		// blocks the other
+		end := NewConnection(connID, client)
+		end.maxFrame = vs.config.maxFrameSize()
+		end.StartWriter(bridgeQueueSize, SlowConsumerDropNew, 0)
+		b.ends[i] = end
+	}
+	go b.pass(b.ends[0], b.ends[1], true)
+	go b.pass(b.ends[1], b.ends[0], false)
+	return b, nil
+}
+
+// pass reads the frames a VLAN sends to the bridge and queues those its
+// rules select for the other VLAN, until either side closes
+func (b *bridge) pass(from, to *Connection, forward bool) {
+	defer b.close()
+	for {
+		frame, err := from.ReadFrame()
+		if err != nil {
+			return
+		}
+		if b.allows(frame, forward) {
+			if err := to.SendFrame(frame); err == nil {
+				b.forwarded.Add(1)
+			} else if !errors.Is(err, errQueueFull) {
+				frame.Release()
+				return
+			}
+		} else {
+			b.filtered.Add(1)
+		}
+		frame.Release()
+	}
+}
+
+// allows reports whether a rule of the bridge passes a frame
+func (b *bridge) allows(frame *EthernetFrame, forward bool) bool {
+	for i := range b.rules {
+		if b.rules[i].matches(frame, forward) {
+			return true
+		}
+	}
+	return false
+}
+
+// close disconnects the bridge from both VLANs
+func (b *bridge) close() {
+	b.closing.Do(func() {
+		for _, end := range b.ends {
+			_ = end.Close()
+		}
+		if b.closed != nil {
+			b.closed()
+		}
+	})
+}
+
+// snapshot returns the bridge as reported by the API
+func (b *bridge) snapshot() Bridge {
+	snapshot := b.Bridge
+	snapshot.Forwarded = b.forwarded.Load()
+	snapshot.Filtered = b.filtered.Load()
+	for _, end := range b.ends {
+		snapshot.Dropped += end.Info().QueueDrops
+	}
+	return snapshot
+}
+
+// sortBridges sorts bridges by creation
+func sortBridges(list []Bridge) {
+	sort.Slice(list, func(i, j int) bool {
+		if len(list[i].ID) != len(list[j].ID) {
+			return len(list[i].ID) < len(list[j].ID)
+		}
+		return list[i].ID < list[j].ID
+	})
+}
+
+// AddBridge bridges the VLANs at two ports, passing the frames the rules
+// select between them, and returns the new bridge
+func (sm *SwitchManager) AddBridge(ports [2]int, rules []BridgeRule) (Bridge, error) {
+	if ports[0] == ports[1] {
+		return Bridge{}, fmt.Errorf("a bridge needs two different VLANs")
+	}
+	var vlans [2]*VirtualSwitch
+	for i, port := range ports {
+		if vlans[i] = sm.vlan(port); vlans[i] == nil {
+			return Bridge{}, fmt.Errorf("VLAN does not exist on port %d", port)
+		}
+	}
+
+	sm.bridgeMutex.Lock()
+	defer sm.bridgeMutex.Unlock()
+
+	if sm.bridges == nil {
+		sm.bridges = make(map[string]*bridge)
+	}
+	sm.nextBridgeID++
+	id := "b" + strconv.Itoa(sm.nextBridgeID)
+	b, err := newBridge(id, vlans, rules, func() { sm.bridgeClosed(id) })
+	if err != nil {
+		return Bridge{}, err
+	}
+	sm.bridges[id] = b
+
+	sm.logger().Info("Bridged VLANs", "bridge", id, "vlans", ports, "rules", len(rules))
+	return b.snapshot(), nil
+}
+
+// RemoveBridge disconnects a bridge from its VLANs and returns it
+func (sm *SwitchManager) RemoveBridge(id string) (Bridge, error) {
+	sm.bridgeMutex.Lock()
+	b, found := sm.bridges[id]
+	sm.bridgeMutex.Unlock()
+	if !found {
+		return Bridge{}, fmt.Errorf("bridge %s not found", id)
+	}
+
+	b.close()
+	return b.snapshot(), nil
+}
+
+// Bridges returns the bridges between VLANs
+func (sm *SwitchManager) Bridges() []Bridge {
+	sm.bridgeMutex.Lock()
+	defer sm.bridgeMutex.Unlock()
+
+	list := make([]Bridge, 0, len(sm.bridges))
+	for _, b := range sm.bridges {
+		list = append(list, b.snapshot())
+	}
+	sortBridges(list)
+	return list
+}
+
+// bridgeClosed forgets a bridge once it is disconnected from its VLANs
+func (sm *SwitchManager) bridgeClosed(id string) {
+	sm.bridgeMutex.Lock()
+	b, found := sm.bridges[id]
+	delete(sm.bridges, id)
+	sm.bridgeMutex.Unlock()
+
+	if found {
+		sm.logger().Info("Removed bridge", "bridge", id, "vlans", b.VLANs, "forwarded", b.forwarded.Load())
+	}
+}
+
+// closeBridges disconnects every bridge from its VLANs
+func (sm *SwitchManager) closeBridges() {
+	sm.bridgeMutex.Lock()
+	bridges := make([]*bridge, 0, len(sm.bridges))
+	for _, b := range sm.bridges {
+		bridges = append(bridges, b)
+	}
+	sm.bridgeMutex.Unlock()
+
+	for _, b := range bridges {
+		b.close()
+	}
+}
@@ -0,0 +1,162 @@
+package vswitch
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"testing"
+)
+
+func TestParseBridgeRule(t *testing.T) {
+	rule, err := ParseBridgeRule("src=52:54:00:00:00:01, ethertype=arp,direction=forward")
+	if err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+	expected := BridgeRule{SrcMAC: "52:54:00:00:00:01", EtherType: EtherTypeARP, Direction: BridgeForward}
+	if rule != expected {
+		t.Errorf("Expected %+v, got %+v", expected, rule)
+	}
+	if reparsed, err := ParseBridgeRule(rule.String()); err != nil || reparsed != rule {
+		t.Errorf("Expected %s to parse back, got %+v, %v", rule, reparsed, err)
+	}
+	if rule, err := ParseBridgeRule("ethertype=0x88b5"); err != nil || rule.EtherType != 0x88b5 {
+		t.Errorf("Expected a numeric EtherType, got %+v, %v", rule, err)
+	}
+
+	for _, spec := range []string{"", "src", "src=nope", "ethertype=0x05", "direction=forward", "ethertype=arp,direction=up", "vlan=5"} {
+		if _, err := ParseBridgeRule(spec); err == nil {
+			t.Errorf("Expected %q to be rejected", spec)
+		}
+	}
+}
+
+func TestBridge(t *testing.T) {
+	sm := NewSwitchManager()
+	sm.SetDefaultConfig(Config{InMemory: true, EgressQueueSize: 64})
+	_ = sm.AddVLAN(8080)
+	_ = sm.AddVLAN(8081)
+	if err := sm.StartAll(context.Background()); err != nil {
+		t.Fatalf("Failed to start VLANs: %v", err)
+	}
+	defer sm.StopAll()
+
+	jumpMAC := net.HardwareAddr{0x52, 0x54, 0x00, 0x00, 0x00, 0x01}
+	clientMAC := net.HardwareAddr{0x52, 0x54, 0x00, 0x00, 0x00, 0x02}
+	strangerMAC := net.HardwareAddr{0x52, 0x54, 0x00, 0x00, 0x00, 0x03}
+	jump := sm.vlan(8080).Dial("jump")
+	client := sm.vlan(8081).Dial("client")
+	stranger := sm.vlan(8081).Dial("stranger")
+	defer func() { _ = stranger.Close() }()
+	send := func(guest net.Conn, dst, src net.HardwareAddr, etherType uint16) {
+		t.Helper()
+		if _, err := guest.Write(qemuFrame(buildEthernet(dst, src, etherType, make([]byte, 46)))); err != nil {
+			t.Fatalf("Failed to send frame: %v", err)
+		}
+	}
+
+	// The jump host and the client may talk, and the jump host's ARP
+	// broadcasts reach the client's VLAN
+	bridge, err := sm.AddBridge([2]int{8080, 8081}, []BridgeRule{
+		{SrcMAC: jumpMAC.String(), DstMAC: clientMAC.String()},
+		{EtherType: EtherTypeARP, Direction: BridgeForward},
+	})
+	if err != nil {
+		t.Fatalf("Failed to bridge: %v", err)
+	}
+	waitUntil(t, "the bridge is connected", func() bool {
+		return sm.vlan(8080).connectionCount() == 2 && sm.vlan(8081).connectionCount() == 3
+	})
+
+	send(client, jumpMAC, clientMAC, EtherTypeIPv4)
+	if frame := readGuestFrame(t, jump); frame.SrcMAC.String() != clientMAC.String() {
+		t.Errorf("Expected the client's frame to cross the bridge, got one from %s", frame.SrcMAC)
+	}
+
+	// The stranger is not in any rule, nor is the jump host's IPv4 broadcast
+	send(stranger, jumpMAC, strangerMAC, EtherTypeIPv4)
+	send(jump, BroadcastMAC, jumpMAC, EtherTypeIPv4)
+	send(jump, BroadcastMAC, jumpMAC, EtherTypeARP)
+	for {
+		frame := readGuestFrame(t, client)
+		if frame.SrcMAC.String() == strangerMAC.String() {
+			// Flooded within the client's own VLAN
+			continue
+		}
+		if frame.EtherType != EtherTypeARP {
+			t.Errorf("Expected only the ARP broadcast to cross the bridge, got %04x", frame.EtherType)
+		}
+		break
+	}
+
+	// A reply from the client reaches the jump host, an ARP broadcast of the
+	// client is kept out by the forward-only rule
+	send(client, BroadcastMAC, clientMAC, EtherTypeARP)
+	send(client, jumpMAC, clientMAC, EtherTypeIPv4)
+	if frame := readGuestFrame(t, jump); frame.EtherType != EtherTypeIPv4 {
+		t.Errorf("Expected only the unicast reply to cross the bridge, got %04x", frame.EtherType)
+	}
+
+	bridges := sm.Bridges()
+	if len(bridges) != 1 || bridges[0].ID != bridge.ID || bridges[0].Forwarded != 3 || bridges[0].Filtered != 3 {
+		t.Errorf("Expected 3 frames forwarded and 3 filtered, got %+v", bridges)
+	}
+
+	if _, err := sm.RemoveBridge(bridge.ID); err != nil {
+		t.Fatalf("Failed to remove the bridge: %v", err)
+	}
+	waitUntil(t, "the bridge is disconnected", func() bool {
+		return sm.vlan(8080).connectionCount() == 1 && len(sm.Bridges()) == 0
+	})
+	if _, err := sm.RemoveBridge(bridge.ID); err == nil {
+		t.Errorf("Expected removing a missing bridge to fail")
+	}
+
+	// A bridge goes away with its VLANs
+	if _, err := sm.AddBridge([2]int{8080, 8081}, []BridgeRule{{EtherType: EtherTypeARP}}); err != nil {
+		t.Fatalf("Failed to bridge: %v", err)
+	}
+	if err := sm.RemoveVLAN(8081); err != nil {
+		t.Fatalf("Failed to remove VLAN: %v", err)
+	}
+	waitUntil(t, "the bridge is removed", func() bool { return len(sm.Bridges()) == 0 })
+}
+
+func TestAPIBridges(t *testing.T) {
+	sm := NewSwitchManager()
+	sm.SetDefaultConfig(Config{InMemory: true})
+	_ = sm.AddVLAN(8080)
+	_ = sm.AddVLAN(8081)
+	if err := sm.StartAll(context.Background()); err != nil {
+		t.Fatalf("Failed to start VLANs: %v", err)
+	}
+	defer sm.StopAll()
+	handler := NewAPIHandler(sm, "secret")
+
+	if status := apiRequest(handler, http.MethodPost, "/bridges", `{"vlans":[8080,8081],"rules":[{"ethertype":2054}]}`, "secret"); status != http.StatusCreated {
+		t.Fatalf("Expected the bridge to be created, got %d", status)
+	}
+	if bridges := sm.Bridges(); len(bridges) != 1 || bridges[0].VLANs != [2]int{8080, 8081} {
+		t.Errorf("Unexpected bridges %+v", bridges)
+	}
+
+	for _, body := range []string{
+		`{"vlans":[8080,8080],"rules":[{"ethertype":2054}]}`,
+		`{"vlans":[8080,8081],"rules":[]}`,
+		`{"vlans":[8080,8081],"rules":[{"direction":"both"}]}`,
+		`{"vlans":[8080,8081],"rules":[{"src_mac":"nope"}]}`,
+	} {
+		if status := apiRequest(handler, http.MethodPost, "/bridges", body, "secret"); status != http.StatusBadRequest {
+			t.Errorf("Expected %s to be rejected, got %d", body, status)
+		}
+	}
+	if status := apiRequest(handler, http.MethodPost, "/bridges", `{"vlans":[8080,8082],"rules":[{"ethertype":2054}]}`, "secret"); status != http.StatusNotFound {
+		t.Errorf("Expected an unknown VLAN to be rejected, got %d", status)
+	}
+
+	if status := apiRequest(handler, http.MethodDelete, "/bridges/b1", "", "secret"); status != http.StatusOK {
+		t.Errorf("Expected the bridge to be removed, got %d", status)
+	}
+	if status := apiRequest(handler, http.MethodDelete, "/bridges/b1", "", "secret"); status != http.StatusNotFound {
+		t.Errorf("Expected a missing bridge to be rejected, got %d", status)
+	}
+}
@@ -0,0 +1,226 @@
+package vswitch
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// captureQueueSize bounds the frames buffered for a slow capture client
+	captureQueueSize = 1024
+
+	// pcapSnapLen is the snapshot length announced in the pcap header
+	pcapSnapLen = 65535
+
+	// pcapLinkTypeEthernet is the pcap link type of Ethernet frames
+	pcapLinkTypeEthernet = 1
+)
+
+// capturedFrame is a copy of a frame queued for a capture stream
+type capturedFrame struct {
+	timestamp time.Time
+	data      []byte
+}
+
+// captureStream is one live capture client of a VLAN
+type captureStream struct {
+	frames  chan capturedFrame
+	dropped atomic.Uint64
+}
+
+// captureHub fans the frames seen by a VLAN out to its live capture
+// streams. Frames are only copied while at least one stream is attached.
+type captureHub struct {
+	active  atomic.Int32
+	mutex   sync.RWMutex
+	streams map[*captureStream]struct{}
+}
+
+// subscribe attaches a new capture stream
+func (h *captureHub) subscribe() *captureStream {
+	stream := &captureStream{frames: make(chan capturedFrame, captureQueueSize)}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if h.streams == nil {
+		h.streams = make(map[*captureStream]struct{})
+	}
+	h.streams[stream] = struct{}{}
+	h.active.Add(1)
+	return stream
+}
+
+// unsubscribe detaches a capture stream
+func (h *captureHub) unsubscribe(stream *captureStream) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if _, exists := h.streams[stream]; exists {
+		delete(h.streams, stream)
+		h.active.Add(-1)
+	}
+}
+
+// tap copies a frame seen at timestamp to every attached stream, dropping
+// it for streams whose queue is full rather than slowing down forwarding
+func (h *captureHub) tap(raw []byte, timestamp time.Time) {
+	if h.active.Load() == 0 {
+		return
+	}
+
+	frame := capturedFrame{timestamp: timestamp, data: append([]byte(nil), raw...)}
+
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	for stream := range h.streams {
+		select {
+		case stream.frames <- frame:
+		default:
+			stream.dropped.Add(1)
+		}
+	}
+}
+
+// StreamCapture writes the frames received and originated by the switch to
+// w in pcap format until ctx is done, the switch stops or a write fails.
+// Received frames are stamped with the nanosecond they were read from their
+// connection rather than when they are written out. Frames are dropped from
+// the stream when w cannot keep up.
+func (vs *VirtualSwitch) StreamCapture(ctx context.Context, w io.Writer) error {
+	stream := vs.capture.subscribe()
+	vs.syncFastPath()
+	defer func() {
+		vs.capture.unsubscribe(stream)
+		vs.syncFastPath()
+		if dropped := stream.dropped.Load(); dropped > 0 {
+			vs.logger.Warn("Capture stream dropped frames", "dropped", dropped)
+		}
+	}()
+
+	flusher, _ := w.(interface{ Flush() })
+
+	header := binary.LittleEndian.AppendUint32(nil, pcapMagicNanos)
+	header = binary.LittleEndian.AppendUint16(header, 2)
+	header = binary.LittleEndian.AppendUint16(header, 4)
+	header = binary.LittleEndian.AppendUint32(header, 0) // GMT offset
+	header = binary.LittleEndian.AppendUint32(header, 0) // timestamp accuracy
+	header = binary.LittleEndian.AppendUint32(header, pcapSnapLen)
+	header = binary.LittleEndian.AppendUint32(header, pcapLinkTypeEthernet)
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	if flusher != nil {
+		flusher.Flush()
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-vs.shutdown:
+			return nil
+		case frame := <-stream.frames:
+			record := binary.LittleEndian.AppendUint32(nil, uint32(frame.timestamp.Unix()))
+			record = binary.LittleEndian.AppendUint32(record, uint32(frame.timestamp.Nanosecond()))
+			record = binary.LittleEndian.AppendUint32(record, uint32(len(frame.data)))
+			record = binary.LittleEndian.AppendUint32(record, uint32(len(frame.data)))
+			record = append(record, frame.data...)
+			if _, err := w.Write(record); err != nil {
+				return err
+			}
+			if flusher != nil && len(stream.frames) == 0 {
+				flusher.Flush()
+			}
+		}
+	}
+}
+
+// captureServer streams the VLAN's frames in pcap format to every client
+// connecting to a TCP address, as read by `wireshark -k -i TCP@host:port`
+type captureServer struct {
+	vs       *VirtualSwitch
+	addr     string
+	listener net.Listener
+}
+
+// newCaptureServer creates a capture server for the given listen address
+func newCaptureServer(vs *VirtualSwitch, addr string) *captureServer {
+	return &captureServer{vs: vs, addr: addr}
+}
+
+// handleFrame does nothing; captured frames are tapped by the switch itself
+func (c *captureServer) handleFrame(_ *EthernetFrame, _ *Connection) bool {
+	return false
+}
+
+// listen opens the capture server's socket
+func (c *captureServer) listen() {
+	listener, err := Listen("tcp", c.addr)
+	if err != nil {
+		c.vs.logger.Error("Capture server failed", "addr", c.addr, "error", err)
+		return
+	}
+	c.listener = listener
+}
+
+// run accepts capture clients until shutdown
+func (c *captureServer) run(shutdown <-chan bool) {
+	listener := c.listener
+	if listener == nil {
+		return
+	}
+
+	go func() {
+		<-shutdown
+		_ = listener.Close()
+	}()
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			select {
+			case <-shutdown:
+				return
+			default:
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			c.vs.logger.Warn("Failed to accept capture client", "addr", c.addr, "error", err)
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.serve(conn)
+		}()
+	}
+}
+
+// serve streams frames to a capture client until it disconnects
+func (c *captureServer) serve(conn net.Conn) {
+	defer func() { _ = conn.Close() }()
+	c.vs.logger.Info("Capture client attached", "remote", conn.RemoteAddr().String())
+
+	// Clients never send anything, so a read returns once they hang up
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		_, _ = io.Copy(io.Discard, conn)
+		cancel()
+	}()
+
+	if err := c.vs.StreamCapture(ctx, conn); err != nil {
+		c.vs.logger.Debug("Capture client write failed", "remote", conn.RemoteAddr().String(), "error", err)
+	}
+	c.vs.logger.Info("Capture client detached", "remote", conn.RemoteAddr().String())
+}
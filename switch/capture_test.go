@@ -0,0 +1,179 @@
+package vswitch
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// readPcapRecord reads the next record of a pcap stream
+func readPcapRecord(t *testing.T, r io.Reader) []byte {
+	t.Helper()
+
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(r, header); err != nil {
+		t.Fatalf("Failed to read record header: %v", err)
+	}
+	data := make([]byte, binary.LittleEndian.Uint32(header[8:12]))
+	if _, err := io.ReadFull(r, data); err != nil {
+		t.Fatalf("Failed to read record data: %v", err)
+	}
+	return data
+}
+
+// waitForCapture waits until a capture stream is attached to the switch
+func waitForCapture(t *testing.T, sw *VirtualSwitch) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for sw.capture.active.Load() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Capture stream was not attached")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestStreamCapture(t *testing.T) {
+	sw := NewVirtualSwitch([]int{8080})
+	conn := NewConnection("guest", &frameSink{})
+	sw.connections.Store("guest", conn)
+
+	reader, writer := io.Pipe()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- sw.StreamCapture(ctx, writer) }()
+
+	header := make([]byte, 24)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		t.Fatalf("Failed to read pcap header: %v", err)
+	}
+	if binary.LittleEndian.Uint32(header[0:4]) != pcapMagicNanos || binary.LittleEndian.Uint32(header[20:24]) != pcapLinkTypeEthernet {
+		t.Fatalf("Unexpected pcap header %x", header)
+	}
+
+	// Received and injected frames are both captured
+	received := buildEthernet(BroadcastMAC, net.HardwareAddr{0x52, 0x54, 0, 0, 0, 1}, EtherTypeARP, make([]byte, 46))
+	_ = sw.processFrame(rawFrame(received), conn)
+	if data := readPcapRecord(t, reader); !bytes.Equal(data, received) {
+		t.Errorf("Expected received frame, got %x", data)
+	}
+
+	injected := buildEthernet(BroadcastMAC, serviceMAC(8080), EtherTypeIPv4, make([]byte, 46))
+	sw.injectFrame(injected)
+	if data := readPcapRecord(t, reader); !bytes.Equal(data, injected) {
+		t.Errorf("Expected injected frame, got %x", data)
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("Expected clean end of capture, got %v", err)
+	}
+	if sw.capture.active.Load() != 0 {
+		t.Errorf("Expected capture stream to be detached")
+	}
+}
+
+func TestCaptureTimestamps(t *testing.T) {
+	sw := NewVirtualSwitch([]int{8080})
+	conn := NewConnection("guest", &frameSink{})
+	sw.connections.Store("guest", conn)
+
+	reader, writer := io.Pipe()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = sw.StreamCapture(ctx, writer) }()
+	if _, err := io.ReadFull(reader, make([]byte, 24)); err != nil {
+		t.Fatalf("Failed to read pcap header: %v", err)
+	}
+
+	// Frames keep the time they were read at, to the nanosecond
+	frame := rawFrame(buildEthernet(BroadcastMAC, net.HardwareAddr{0x52, 0x54, 0, 0, 0, 1}, EtherTypeARP, make([]byte, 46)))
+	frame.received = time.Unix(1700000000, 123456789)
+	_ = sw.processFrame(frame, conn)
+
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		t.Fatalf("Failed to read record header: %v", err)
+	}
+	seconds, nanos := binary.LittleEndian.Uint32(header[0:4]), binary.LittleEndian.Uint32(header[4:8])
+	if seconds != 1700000000 || nanos != 123456789 {
+		t.Errorf("Expected the reception time, got %d.%09d", seconds, nanos)
+	}
+}
+
+func TestCaptureDropsWhenFull(t *testing.T) {
+	var hub captureHub
+	stream := hub.subscribe()
+
+	for i := 0; i < captureQueueSize+10; i++ {
+		hub.tap([]byte{byte(i)}, time.Now())
+	}
+
+	if len(stream.frames) != captureQueueSize || stream.dropped.Load() != 10 {
+		t.Errorf("Expected %d queued and 10 dropped frames, got %d and %d",
+			captureQueueSize, len(stream.frames), stream.dropped.Load())
+	}
+}
+
+func TestCaptureServer(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	addr := listener.Addr().String()
+	_ = listener.Close()
+
+	sw := NewVirtualSwitchWithConfig([]int{8080}, Config{CaptureAddr: addr})
+	sw.startServices()
+	defer sw.Stop()
+
+	var client net.Conn
+	for i := 0; i < 50; i++ {
+		if client, err = net.Dial("tcp", addr); err == nil {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("Failed to connect to capture server: %v", err)
+	}
+	defer func() { _ = client.Close() }()
+	_ = client.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	if _, err := io.ReadFull(client, make([]byte, 24)); err != nil {
+		t.Fatalf("Failed to read pcap header: %v", err)
+	}
+	waitForCapture(t, sw)
+
+	frame := buildEthernet(BroadcastMAC, net.HardwareAddr{0x52, 0x54, 0, 0, 0, 1}, EtherTypeARP, make([]byte, 46))
+	_ = sw.processFrame(rawFrame(frame), NewConnection("guest", &frameSink{}))
+	if data := readPcapRecord(t, client); !bytes.Equal(data, frame) {
+		t.Errorf("Expected captured frame, got %x", data)
+	}
+}
+
+func TestAPICaptureRequiresToken(t *testing.T) {
+	sm := NewSwitchManager()
+	if err := sm.AddVLAN(8080); err != nil {
+		t.Fatalf("Failed to add VLAN: %v", err)
+	}
+
+	recorder := httptest.NewRecorder()
+	NewAPIHandler(sm, "").ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/vlans/8080/capture", nil))
+	if recorder.Code != http.StatusForbidden {
+		t.Errorf("Expected capture to require an API token, got %d", recorder.Code)
+	}
+
+	recorder = httptest.NewRecorder()
+	NewControlHandler(sm).ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/vlans/9999/capture", nil))
+	if recorder.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for unknown VLAN, got %d", recorder.Code)
+	}
+}
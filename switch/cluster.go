@@ -0,0 +1,718 @@
+package vswitch
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// clusterInterval is how often nodes exchange membership and MAC
+	// reachability
+	clusterInterval = 5 * time.Second
+
+	// clusterTimeout is how long a link may stay silent before the node
+	// behind it is considered gone
+	clusterTimeout = 3 * clusterInterval
+
+	// clusterDialTimeout bounds connecting to another node
+	clusterDialTimeout = 5 * time.Second
+
+	// clusterRetryInterval is how long a node waits before redialing a peer
+	clusterRetryInterval = 5 * time.Second
+
+	// clusterWriteTimeout bounds sending a message to another node
+	clusterWriteTimeout = 10 * time.Second
+
+	// clusterMaxMessage is the size of the largest message accepted from
+	// another node
+	clusterMaxMessage = 1 << 20
+)
+
+// Cluster message types. Each message is a 4-byte big-endian length, the
+// type and its body.
+const (
+	// clusterHello carries a clusterHelloMessage; it opens the link and
+	// is repeated as a heartbeat
+	clusterHello byte = iota + 1
+
+	// clusterFrame carries a 2-byte VLAN port followed by a frame
+	clusterFrame
+
+	// clusterMACs carries a clusterMACsMessage
+	clusterMACs
+)
+
+// ClusterConfig configures a switch joining a cluster of switches on
+// several hosts
+type ClusterConfig struct {
+	// Node names this switch in the cluster. It must be unique and one of
+	// the names of the switch's certificate.
+	Node string
+
+	// Listen is the address other nodes link to; empty to only dial
+	Listen string
+
+	// Peers are the addresses of the nodes this switch links to
+	Peers []string
+
+	// TLS holds the certificate of the node and the CAs that signed the
+	// certificates of the other nodes, in RootCAs or ClientCAs
+	TLS *tls.Config
+
+	// Horizon is the split horizon group of the trunks to other nodes,
+	// DefaultClusterHorizon if empty
+	Horizon string
+}
+
+// ClusterMember describes a node linked to this switch
+type ClusterMember struct {
+	Node     string    `json:"node"`
+	Address  string    `json:"address"`
+	VLANs    []int     `json:"vlans"`
+	Outbound bool      `json:"outbound"`
+	Since    time.Time `json:"since"`
+}
+
+// clusterHelloMessage introduces a node and the VLANs it serves
+type clusterHelloMessage struct {
+	Node  string `json:"node"`
+	VLANs []int  `json:"vlans"`
+}
+
+// clusterMACsMessage announces the MAC addresses of a VLAN connected to
+// the sending node
+type clusterMACsMessage struct {
+	VLAN int      `json:"vlan"`
+	MACs []string `json:"macs"`
+}
+
+// Cluster joins the VLANs of this switch with those of the same ports on
+// other hosts into single segments. Nodes link to each other over mutually
+// authenticated TLS, and each VLAN both nodes serve is tunneled over the
+// link as a trusted, promiscuous trunk connection. Nodes announce their
+// MAC addresses to each other, so a VM that migrates to another host is
+// reached there as soon as the new host announces it. The trunks share a
+// split horizon group, so the nodes must form a full mesh: a frame
+// received from one trunk is not forwarded out another.
+type Cluster struct {
+	sm       *SwitchManager
+	config   ClusterConfig
+	server   *tls.Config
+	listener net.Listener
+
+	// ctx is canceled when the cluster is closed, closing its links
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mutex sync.Mutex
+	links map[string]*clusterLink // node -> link
+}
+
+// StartCluster makes the switch a node of a cluster, listening for and
+// dialing the other nodes
+func StartCluster(sm *SwitchManager, config ClusterConfig) (*Cluster, error) {
+	if config.Node == "" {
+		return nil, fmt.Errorf("cluster node needs a name")
+	}
+	if config.TLS == nil || len(config.TLS.Certificates) == 0 {
+		return nil, fmt.Errorf("cluster node needs a certificate")
+	}
+	if config.Horizon == "" {
+		config.Horizon = DefaultClusterHorizon
+	}
+
+	server := config.TLS.Clone()
+	server.ClientAuth = tls.RequireAndVerifyClientCert
+	if server.ClientCAs == nil {
+		server.ClientCAs = server.RootCAs
+	}
+
+	c := &Cluster{
+		sm:     sm,
+		config: config,
+		server: server,
+		links:  make(map[string]*clusterLink),
+	}
+
+	if config.Listen != "" {
+		listener, err := tls.Listen("tcp", config.Listen, server)
+		if err != nil {
+			return nil, err
+		}
+		c.listener = listener
+	}
+
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+	if c.listener != nil {
+		c.wg.Add(1)
+		go c.accept()
+	}
+	for _, peer := range config.Peers {
+		c.wg.Add(1)
+		go c.dial(peer)
+	}
+
+	sm.setCluster(c)
+	return c, nil
+}
+
+// Addr returns the address the node listens on, or nil
+func (c *Cluster) Addr() net.Addr {
+	if c.listener == nil {
+		return nil
+	}
+	return c.listener.Addr()
+}
+
+// Close leaves the cluster, removing the trunks of the other nodes
+func (c *Cluster) Close() error {
+	c.sm.setCluster(nil)
+	c.cancel()
+
+	var err error
+	if c.listener != nil {
+		err = c.listener.Close()
+	}
+	c.wg.Wait()
+	return err
+}
+
+// Members returns the nodes linked to this switch
+func (c *Cluster) Members() []ClusterMember {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	members := make([]ClusterMember, 0, len(c.links))
+	for _, link := range c.links {
+		members = append(members, link.member())
+	}
+	sort.Slice(members, func(i, j int) bool { return members[i].Node < members[j].Node })
+	return members
+}
+
+// accept serves the links other nodes open until the cluster is closed
+func (c *Cluster) accept() {
+	defer c.wg.Done()
+
+	for {
+		conn, err := c.listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			c.sm.logger().Warn("Failed to accept cluster link", "error", err)
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+
+		c.wg.Add(1)
+		go func() {
+			defer c.wg.Done()
+			c.serve(conn.(*tls.Conn), false)
+		}()
+	}
+}
+
+// dial keeps a link to a peer, reconnecting when it fails
+func (c *Cluster) dial(address string) {
+	defer c.wg.Done()
+
+	config := c.config.TLS.Clone()
+	if config.RootCAs == nil {
+		config.RootCAs = config.ClientCAs
+	}
+	if config.ServerName == "" {
+		config.ServerName, _, _ = net.SplitHostPort(address)
+	}
+	dialer := &tls.Dialer{NetDialer: &net.Dialer{Timeout: clusterDialTimeout}, Config: config}
+
+	failing := false
+	for {
+		conn, err := dialer.DialContext(c.ctx, "tcp", address)
+		if err != nil {
+			// Log once until the peer is back
+			if !failing && c.ctx.Err() == nil {
+				c.sm.logger().Warn("Failed to link to cluster node", "address", address, "error", err)
+			}
+			failing = true
+		} else {
+			failing = false
+			node := c.serve(conn.(*tls.Conn), true)
+
+			// Wait out a link the peer opened instead
+			if other := c.link(node); other != nil {
+				select {
+				case <-other.done:
+				case <-c.ctx.Done():
+				}
+			}
+		}
+
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-time.After(clusterRetryInterval):
+		}
+	}
+}
+
+// link returns the link to a node, or nil
+func (c *Cluster) link(node string) *clusterLink {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.links[node]
+}
+
+// serve introduces the nodes at both ends of a TLS connection to each
+// other and runs their link until it fails. It returns the name of the
+// remote node, if it introduced itself.
+func (c *Cluster) serve(conn *tls.Conn, outbound bool) string {
+	defer func() { _ = conn.Close() }()
+	stop := context.AfterFunc(c.ctx, func() { _ = conn.Close() })
+	defer stop()
+
+	logger := c.sm.logger()
+	remote := conn.RemoteAddr().String()
+
+	ctx, cancel := context.WithTimeout(c.ctx, tlsHandshakeTimeout)
+	err := conn.HandshakeContext(ctx)
+	cancel()
+	if err != nil {
+		logger.Warn("Cluster handshake failed", "remote", remote, "error", err)
+		return ""
+	}
+
+	// Both nodes introduce themselves before anything else
+	link := &clusterLink{
+		cluster:  c,
+		conn:     conn,
+		remote:   remote,
+		outbound: outbound,
+		ports:    make(map[int]*clusterPort),
+		done:     make(chan struct{}),
+	}
+	_ = conn.SetDeadline(time.Now().Add(tlsHandshakeTimeout))
+	if err := link.sendHello(); err != nil {
+		logger.Warn("Failed to introduce to cluster node", "remote", remote, "error", err)
+		return ""
+	}
+	kind, body, err := link.read()
+	if err == nil && kind != clusterHello {
+		err = fmt.Errorf("unexpected message type %d", kind)
+	}
+	var hello clusterHelloMessage
+	if err == nil {
+		err = json.Unmarshal(body, &hello)
+	}
+	if err != nil {
+		logger.Warn("Cluster node did not introduce itself", "remote", remote, "error", err)
+		return ""
+	}
+	_ = conn.SetDeadline(time.Time{})
+
+	// Nodes may only claim names their certificate carries
+	cert := conn.ConnectionState().PeerCertificates[0]
+	if hello.Node == "" || hello.Node == c.config.Node || !slices.Contains(certificateIdentities(cert), hello.Node) {
+		logger.Warn("Rejected cluster node with a name its certificate does not carry", "remote", remote, "node", hello.Node, "subject", cert.Subject.String())
+		return ""
+	}
+	link.node = hello.Node
+	link.since = time.Now()
+
+	if !c.register(link) {
+		logger.Debug("Dropped duplicate cluster link", "node", link.node, "remote", remote)
+		return link.node
+	}
+	defer c.unregister(link)
+
+	logger.Info("Cluster node joined", "node", link.node, "remote", remote, "vlans", hello.VLANs)
+	err = link.run(hello.VLANs)
+	logger.Info("Cluster node left", "node", link.node, "remote", remote, "error", err)
+	return link.node
+}
+
+// register makes a link the one to its node. When both nodes dialed each
+// other, both keep the link dialed by the node with the lower name; a
+// link dialed the same way as the current one is a reconnection and
+// replaces it.
+func (c *Cluster) register(link *clusterLink) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if existing := c.links[link.node]; existing != nil {
+		if existing.outbound != link.outbound && c.dialer(link) != min(c.config.Node, link.node) {
+			return false
+		}
+		existing.close()
+	}
+	c.links[link.node] = link
+	return true
+}
+
+// unregister forgets a link that failed, unless it was replaced
+func (c *Cluster) unregister(link *clusterLink) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.links[link.node] == link {
+		delete(c.links, link.node)
+	}
+}
+
+// dialer returns the name of the node that opened a link
+func (c *Cluster) dialer(link *clusterLink) string {
+	if link.outbound {
+		return c.config.Node
+	}
+	return link.node
+}
+
+// clusterLink is the TLS connection to another node, carrying the trunks
+// of the VLANs both nodes serve
+type clusterLink struct {
+	cluster  *Cluster
+	conn     *tls.Conn
+	node     string
+	remote   string
+	outbound bool
+	since    time.Time
+
+	// writeMutex keeps messages from interleaving
+	writeMutex sync.Mutex
+
+	// vlans are the VLANs the remote node serves, and ports the trunks
+	// of those this node serves too
+	mutex sync.Mutex
+	vlans []int
+	ports map[int]*clusterPort
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// clusterPort is the trunk of a VLAN over a link. The VLAN reads and writes
+// the trunk connection through one end of a pipe; the link relays frames
+// through the other.
+type clusterPort struct {
+	vs   *VirtualSwitch
+	conn *Connection
+	pipe net.Conn
+}
+
+// clusterConn is the VLAN's end of a trunk pipe, reporting the address of
+// the remote node
+type clusterConn struct {
+	net.Conn
+	remote net.Addr
+}
+
+// RemoteAddr returns the address of the remote node
+func (c clusterConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+// run relays messages from the remote node until the link fails
+func (l *clusterLink) run(vlans []int) error {
+	defer l.detachAll()
+	defer l.close()
+
+	l.setVLANs(vlans)
+	l.sync()
+	go l.heartbeat()
+
+	for {
+		_ = l.conn.SetReadDeadline(time.Now().Add(clusterTimeout))
+		kind, body, err := l.read()
+		if err != nil {
+			return err
+		}
+
+		switch kind {
+		case clusterHello:
+			var hello clusterHelloMessage
+			if err := json.Unmarshal(body, &hello); err != nil {
+				return fmt.Errorf("invalid hello: %v", err)
+			}
+			l.setVLANs(hello.VLANs)
+			l.sync()
+		case clusterFrame:
+			l.deliver(body)
+		case clusterMACs:
+			var macs clusterMACsMessage
+			if err := json.Unmarshal(body, &macs); err != nil {
+				return fmt.Errorf("invalid MAC announcement: %v", err)
+			}
+			l.learn(macs)
+		}
+	}
+}
+
+// heartbeat periodically repeats the node's VLANs and MAC addresses to the
+// remote node until the link fails
+func (l *clusterLink) heartbeat() {
+	ticker := time.NewTicker(clusterInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-l.done:
+			return
+		case <-ticker.C:
+		}
+
+		// Pick up VLANs added or removed on this node
+		l.sync()
+		if err := l.sendHello(); err != nil {
+			l.close()
+			return
+		}
+		if err := l.announce(); err != nil {
+			l.close()
+			return
+		}
+	}
+}
+
+// close ends the link
+func (l *clusterLink) close() {
+	l.closeOnce.Do(func() {
+		close(l.done)
+		_ = l.conn.Close()
+	})
+}
+
+// member describes the remote node
+func (l *clusterLink) member() ClusterMember {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	return ClusterMember{
+		Node:     l.node,
+		Address:  l.remote,
+		VLANs:    slices.Clone(l.vlans),
+		Outbound: l.outbound,
+		Since:    l.since,
+	}
+}
+
+// setVLANs records the VLANs the remote node serves
+func (l *clusterLink) setVLANs(vlans []int) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.vlans = vlans
+}
+
+// sync adds the trunks of VLANs both nodes serve and removes the others
+func (l *clusterLink) sync() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	select {
+	case <-l.done:
+		return
+	default:
+	}
+
+	for port, trunk := range l.ports {
+		if !slices.Contains(l.vlans, port) || l.cluster.sm.vlan(port) != trunk.vs || trunk.conn.IsClosed() {
+			_ = trunk.pipe.Close()
+			delete(l.ports, port)
+		}
+	}
+	for _, port := range l.vlans {
+		if _, exists := l.ports[port]; exists {
+			continue
+		}
+		if vs := l.cluster.sm.vlan(port); vs != nil {
+			l.ports[port] = l.attach(vs, port)
+		}
+	}
+}
+
+// attach adds the trunk of a VLAN and starts relaying the frames the VLAN
+// sends it
+func (l *clusterLink) attach(vs *VirtualSwitch, port int) *clusterPort {
+	local, relay := net.Pipe()
+	trunk := &clusterPort{vs: vs, pipe: relay}
+
+	vs.attach(fmt.Sprintf("%s-%d", l.remote, port), clusterConn{Conn: local, remote: l.conn.RemoteAddr()}, func(conn *Connection) {
+		conn.SetTrusted(true)
+		conn.SetPortMode(PortPromiscuous, "")
+		conn.SetTrunk(true)
+		conn.SetHorizon(l.cluster.config.Horizon)
+		conn.SetLabel(l.node)
+		trunk.conn = conn
+	})
+
+	go l.relay(port, relay)
+	return trunk
+}
+
+// detachAll removes the trunks of the link
+func (l *clusterLink) detachAll() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	for port, trunk := range l.ports {
+		_ = trunk.pipe.Close()
+		delete(l.ports, port)
+	}
+}
+
+// relay sends the frames a VLAN writes to its trunk to the remote node
+// until the trunk is removed
+func (l *clusterLink) relay(port int, pipe net.Conn) {
+	var length [4]byte
+	for {
+		if _, err := io.ReadFull(pipe, length[:]); err != nil {
+			return
+		}
+		size := binary.BigEndian.Uint32(length[:])
+		if size > jumboFrameSize {
+			_ = pipe.Close()
+			return
+		}
+
+		body := make([]byte, 2+size)
+		binary.BigEndian.PutUint16(body, uint16(port))
+		if _, err := io.ReadFull(pipe, body[2:]); err != nil {
+			return
+		}
+		if err := l.send(clusterFrame, body); err != nil {
+			l.close()
+			return
+		}
+	}
+}
+
+// deliver hands a frame from the remote node to the trunk of its VLAN
+func (l *clusterLink) deliver(body []byte) {
+	if len(body) < 2 {
+		return
+	}
+	port := int(binary.BigEndian.Uint16(body))
+
+	l.mutex.Lock()
+	trunk := l.ports[port]
+	l.mutex.Unlock()
+	if trunk == nil {
+		return
+	}
+
+	// Frames for a trunk being removed are lost with it
+	message := binary.BigEndian.AppendUint32(make([]byte, 0, 4+len(body)-2), uint32(len(body)-2))
+	_, _ = trunk.pipe.Write(append(message, body[2:]...))
+}
+
+// announce sends the remote node the MAC addresses this node reaches
+// directly on each trunked VLAN
+func (l *clusterLink) announce() error {
+	l.mutex.Lock()
+	trunks := make(map[int]*clusterPort, len(l.ports))
+	for port, trunk := range l.ports {
+		trunks[port] = trunk
+	}
+	l.mutex.Unlock()
+
+	for port, trunk := range trunks {
+		message := clusterMACsMessage{VLAN: port}
+		trunk.vs.macTable.rangeEntries(func(key macKey, entry MACEntry) {
+			if !entry.Connection.Trunk() {
+				message.MACs = append(message.MACs, key.String())
+			}
+		})
+		if len(message.MACs) == 0 {
+			continue
+		}
+
+		body, err := json.Marshal(message)
+		if err != nil {
+			return err
+		}
+		if err := l.send(clusterMACs, body); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// learn points the announced MAC addresses at the trunk of the remote
+// node, unless they are connected to this node
+func (l *clusterLink) learn(message clusterMACsMessage) {
+	l.mutex.Lock()
+	trunk := l.ports[message.VLAN]
+	l.mutex.Unlock()
+	if trunk == nil {
+		return
+	}
+
+	now := time.Now()
+	for _, address := range message.MACs {
+		mac, err := net.ParseMAC(address)
+		if err != nil {
+			continue
+		}
+		if entry, found := trunk.vs.macTable.get(mac); found && !entry.Connection.Trunk() {
+			continue
+		}
+		trunk.vs.macTable.store(mac, MACEntry{Connection: trunk.conn, LearnedAt: now})
+	}
+}
+
+// sendHello sends the remote node the name and VLANs of this node
+func (l *clusterLink) sendHello() error {
+	vlans := l.cluster.sm.GetVLANs()
+	sort.Ints(vlans)
+
+	body, err := json.Marshal(clusterHelloMessage{Node: l.cluster.config.Node, VLANs: vlans})
+	if err != nil {
+		return err
+	}
+	return l.send(clusterHello, body)
+}
+
+// send writes a message to the remote node
+func (l *clusterLink) send(kind byte, body []byte) error {
+	message := make([]byte, 5, 5+len(body))
+	binary.BigEndian.PutUint32(message, uint32(1+len(body)))
+	message[4] = kind
+	message = append(message, body...)
+
+	l.writeMutex.Lock()
+	defer l.writeMutex.Unlock()
+
+	_ = l.conn.SetWriteDeadline(time.Now().Add(clusterWriteTimeout))
+	_, err := l.conn.Write(message)
+	return err
+}
+
+// read reads a message from the remote node
+func (l *clusterLink) read() (byte, []byte, error) {
+	var length [4]byte
+	if _, err := io.ReadFull(l.conn, length[:]); err != nil {
+		return 0, nil, err
+	}
+	size := binary.BigEndian.Uint32(length[:])
+	if size == 0 || size > clusterMaxMessage {
+		return 0, nil, fmt.Errorf("invalid message length %d", size)
+	}
+
+	message := make([]byte, size)
+	if _, err := io.ReadFull(l.conn, message); err != nil {
+		return 0, nil, err
+	}
+	return message[0], message[1:], nil
+}
@@ -0,0 +1,138 @@
+package vswitch
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// startClusterNode starts a cluster node with a certificate for its name
+func startClusterNode(t *testing.T, ca *testCA, sm *SwitchManager, node, listen string, peers ...string) *Cluster {
+	t.Helper()
+
+	cluster, err := StartCluster(sm, ClusterConfig{
+		Node:   node,
+		Listen: listen,
+		Peers:  peers,
+		TLS: &tls.Config{
+			Certificates: []tls.Certificate{ca.issue(t, x509.ExtKeyUsageAny, node)},
+			RootCAs:      ca.pool,
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to start cluster node %s: %v", node, err)
+	}
+	t.Cleanup(func() { _ = cluster.Close() })
+	return cluster
+}
+
+// attachGuest connects a guest to a VLAN, returning its end of the stream
+func attachGuest(t *testing.T, sm *SwitchManager, port int, id string) net.Conn {
+	t.Helper()
+
+	guest, vlanEnd := net.Pipe()
+	sm.vlan(port).Attach(id, vlanEnd)
+	t.Cleanup(func() { _ = guest.Close() })
+	return guest
+}
+
+// readGuestFrame reads the next frame a guest receives
+func readGuestFrame(t *testing.T, guest net.Conn) *EthernetFrame {
+	t.Helper()
+
+	_ = guest.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var length [4]byte
+	if _, err := io.ReadFull(guest, length[:]); err != nil {
+		t.Fatalf("Failed to read frame: %v", err)
+	}
+	raw := make([]byte, int(length[0])<<24|int(length[1])<<16|int(length[2])<<8|int(length[3]))
+	if _, err := io.ReadFull(guest, raw); err != nil {
+		t.Fatalf("Failed to read frame: %v", err)
+	}
+	return rawFrame(raw)
+}
+
+func TestCluster(t *testing.T) {
+	ca := newTestCA(t)
+
+	smA := NewSwitchManager()
+	smB := NewSwitchManager()
+	for _, vlan := range []struct {
+		sm   *SwitchManager
+		port int
+	}{{smA, 9301}, {smB, 9301}, {smB, 9302}} {
+		if err := vlan.sm.AddVLAN(vlan.port); err != nil {
+			t.Fatalf("Failed to add VLAN: %v", err)
+		}
+	}
+
+	nodeA := startClusterNode(t, ca, smA, "node-a", "127.0.0.1:0")
+	startClusterNode(t, ca, smB, "node-b", "", nodeA.Addr().String())
+
+	// Both nodes see each other and trunk the VLAN they share
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if conns, _ := smB.GetConnections(9301); len(conns) == 1 && len(smA.ClusterMembers()) == 1 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	members := smA.ClusterMembers()
+	if len(members) != 1 || members[0].Node != "node-b" || len(members[0].VLANs) != 2 || members[0].Outbound {
+		t.Fatalf("Expected node-b to have linked to node-a with two VLANs, got %+v", members)
+	}
+	conns, _ := smA.GetConnections(9301)
+	if len(conns) != 1 || !conns[0].Trunk || conns[0].Label != "node-b" || conns[0].Horizon != DefaultClusterHorizon {
+		t.Fatalf("Expected a trunk to node-b in the cluster horizon group on VLAN 9301, got %+v", conns)
+	}
+	if conns, _ := smB.GetConnections(9302); len(conns) != 0 {
+		t.Errorf("Expected no trunk on a VLAN node-a does not serve, got %+v", conns)
+	}
+
+	guestA := attachGuest(t, smA, 9301, "guest-a")
+	guestB := attachGuest(t, smB, 9301, "guest-b")
+	macA := net.HardwareAddr{0x52, 0x54, 0x00, 0x00, 0x00, 0x0a}
+	macB := net.HardwareAddr{0x52, 0x54, 0x00, 0x00, 0x00, 0x0b}
+
+	// Broadcasts cross the hosts, and the reply finds its way back
+	if _, err := guestA.Write(qemuFrame(buildEthernet(BroadcastMAC, macA, EtherTypeARP, make([]byte, 46)))); err != nil {
+		t.Fatalf("Failed to send frame: %v", err)
+	}
+	if frame := readGuestFrame(t, guestB); frame.SrcMAC.String() != macA.String() {
+		t.Errorf("Expected the broadcast of guest-a, got a frame from %s", frame.SrcMAC)
+	}
+	if _, err := guestB.Write(qemuFrame(buildEthernet(macA, macB, EtherTypeARP, make([]byte, 46)))); err != nil {
+		t.Fatalf("Failed to send frame: %v", err)
+	}
+	if frame := readGuestFrame(t, guestA); frame.SrcMAC.String() != macB.String() {
+		t.Errorf("Expected the reply of guest-b, got a frame from %s", frame.SrcMAC)
+	}
+}
+
+func TestClusterLearnsAnnouncedMACs(t *testing.T) {
+	sm := NewSwitchManager()
+	if err := sm.AddVLAN(9303); err != nil {
+		t.Fatalf("Failed to add VLAN: %v", err)
+	}
+	vs := sm.vlan(9303)
+
+	local := NewConnection("local", &frameSink{})
+	trunk := NewConnection("trunk", &frameSink{})
+	trunk.SetTrunk(true)
+	link := &clusterLink{ports: map[int]*clusterPort{9303: {vs: vs, conn: trunk}}}
+
+	here := net.HardwareAddr{0x52, 0x54, 0x00, 0x00, 0x00, 0x01}
+	vs.learnMAC(here, local)
+	link.learn(clusterMACsMessage{VLAN: 9303, MACs: []string{here.String(), "52:54:00:00:00:02"}})
+
+	// MACs connected here stay here; the others point at the trunk
+	if conn := vs.lookupMAC(here); conn != local {
+		t.Errorf("Expected a local MAC to stay on its connection, got %v", conn)
+	}
+	if conn := vs.lookupMAC(net.HardwareAddr{0x52, 0x54, 0x00, 0x00, 0x00, 0x02}); conn != trunk {
+		t.Errorf("Expected an announced MAC to point at the trunk, got %v", conn)
+	}
+}
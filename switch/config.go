@@ -0,0 +1,457 @@
+package vswitch
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"runtime"
+	"time"
+)
+
+// PortMode controls which other connections a port may exchange frames with
+type PortMode int
+
+const (
+	// PortPromiscuous ports can reach every other port in the VLAN
+	PortPromiscuous PortMode = iota
+	// PortIsolated ports can only reach promiscuous ports
+	PortIsolated
+	// PortCommunity ports can reach promiscuous ports and ports in the same community
+	PortCommunity
+)
+
+// String returns the name of the port mode
+func (m PortMode) String() string {
+	switch m {
+	case PortPromiscuous:
+		return "promiscuous"
+	case PortIsolated:
+		return "isolated"
+	case PortCommunity:
+		return "community"
+	default:
+		return "unknown"
+	}
+}
+
+// SlowConsumerPolicy selects what happens to frames for a connection whose
+// egress queue is full
+type SlowConsumerPolicy int
+
+const (
+	// SlowConsumerDropNew drops the frame being sent
+	SlowConsumerDropNew SlowConsumerPolicy = iota
+	// SlowConsumerDropTail drops the oldest queued frame to make room
+	SlowConsumerDropTail
+	// SlowConsumerDisconnect drops the frame being sent and disconnects the
+	// connection once its queue has stayed full for a timeout
+	SlowConsumerDisconnect
+)
+
+// String returns the name of the slow consumer policy
+func (p SlowConsumerPolicy) String() string {
+	switch p {
+	case SlowConsumerDropNew:
+		return "drop-new"
+	case SlowConsumerDropTail:
+		return "drop-tail"
+	case SlowConsumerDisconnect:
+		return "disconnect"
+	default:
+		return "unknown"
+	}
+}
+
+// ParseSlowConsumerPolicy parses the name of a slow consumer policy
+func ParseSlowConsumerPolicy(name string) (SlowConsumerPolicy, error) {
+	for _, policy := range []SlowConsumerPolicy{SlowConsumerDropNew, SlowConsumerDropTail, SlowConsumerDisconnect} {
+		if policy.String() == name {
+			return policy, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown slow consumer policy '%s'", name)
+}
+
+// Config holds per-VLAN switch behaviour settings
+type Config struct {
+	// Logger receives the VLAN's log records, tagged with its port. Nil
+	// discards them.
+	Logger *slog.Logger
+
+	// MTU is the largest payload of the frames a connection may send; with
+	// the Ethernet header and a VLAN tag it bounds the frame size. Zero
+	// uses DefaultMTU.
+	MTU int
+
+	// MACTimeout is how long a learned MAC address stays in the table
+	// without being seen again. Zero uses DefaultMACTimeout.
+	MACTimeout time.Duration
+
+	// MACTableSize caps the number of MAC addresses the VLAN learns, so a
+	// guest sending from random source MACs cannot exhaust memory; when it
+	// is full, learning a new MAC evicts the least recently used one. Zero
+	// leaves the table unbounded.
+	MACTableSize int
+
+	// IngressHooks run in order on every frame the VLAN receives, and
+	// EgressHooks on every frame before it is sent to a connection. More
+	// can be added while the VLAN runs with AddIngressHook and
+	// AddEgressHook.
+	IngressHooks []FrameHook
+	EgressHooks  []FrameHook
+
+	// InMemory keeps the VLAN off the network: Start binds no port, and
+	// connections only join through Attach and Dial, so embedders and
+	// tests exercise the full forwarding path without sockets
+	InMemory bool
+
+	// ErrorHandler is called when the VLAN fails after it started, as when
+	// one of its listeners is closed under it, so that supervising code
+	// can react. It runs on the failing goroutine and must not block.
+	ErrorHandler func(vlan int, err error)
+
+	// Pipe names a Windows named pipe, e.g. vswitch-9999 for
+	// \\.\pipe\vswitch-9999, on which the VLAN accepts connections
+	// besides its TCP ports, for virtualization frontends preferring pipe
+	// transports. It must not exist yet and only serves local clients.
+	Pipe string
+
+	// BindRetry keeps retrying to bind the ports of the VLAN that fail to
+	// bind when it starts, e.g. while a previous process releases them,
+	// for up to this long with exponential backoff. The VLAN runs, but is
+	// not ready, in the meantime. Zero fails the start at once.
+	BindRetry time.Duration
+
+	// Listen opens the listener of each port of the VLAN instead of a TCP
+	// socket, e.g. to serve a unix socket, wrap the socket in TLS or hand
+	// a test a listener of its own. Its listeners are not passed on in
+	// upgrades.
+	Listen ListenFunc
+
+	// ListenFamily selects whether the VLAN listens on IPv4, IPv6 or both
+	ListenFamily AddressFamily
+
+	// Socket tunes the VLAN's listening socket and the TCP connections it
+	// accepts
+	Socket SocketOptions
+
+	// PadFrames pads frames sent to connections with zeros up to the
+	// 60-byte Ethernet minimum, for guests whose drivers discard runts
+	PadFrames bool
+
+	// Validation selects which frame checks drop the frames failing them;
+	// the others only count them
+	Validation ValidationMode
+
+	// DropUnknownUnicast drops unicast frames whose destination has not
+	// been learned instead of flooding them to every connection
+	DropUnknownUnicast bool
+
+	// StickyMAC locks each source MAC to the first connection it was
+	// learned on and drops frames claiming that MAC from other connections
+	StickyMAC bool
+
+	// MACMoveAnnounce floods a RARP announcement of a MAC that moved to
+	// another connection, and a gratuitous ARP for each IPv4 address known
+	// for it, so switches behind trunks and the ARP caches of guests follow
+	// a migrated or failed over VM at once
+	MACMoveAnnounce bool
+
+	// PrivateVLAN makes new connections isolated unless their remote
+	// address matches PromiscuousPeers
+	PrivateVLAN      bool
+	PromiscuousPeers []*net.IPNet
+
+	// AllowedClients, if set, restricts the remote addresses that may
+	// connect to the VLAN. Addresses matching DeniedClients are rejected
+	// even when allowed.
+	AllowedClients []*net.IPNet
+	DeniedClients  []*net.IPNet
+
+	// PSK, if set, is the pre-shared key the clients connecting to the
+	// VLAN's listeners must prove they hold, answering a challenge before
+	// any frame is forwarded to or from them (see Authenticate). Named
+	// pipes cannot time out the challenge, so it excludes Pipe.
+	PSK string
+
+	// HorizonGroups place connections in split horizon groups by their
+	// remote address; the first matching group applies
+	HorizonGroups []HorizonGroup
+
+	// TrunkBUMLimits caps the flooded frames sent to each trunk link
+	TrunkBUMLimits BUMLimits
+
+	// TagDemux makes every connection of the VLAN an 802.1Q trunk: frames
+	// are switched in a broadcast domain of their VLAN ID, so that a single
+	// port serves a whole trunked topology. TagDemuxVIDs, if set, lists the
+	// VLAN IDs carried; untagged frames belong to NativeVID, or are dropped
+	// when it is zero. Only forwarding settings apply within the domains.
+	TagDemux     bool
+	TagDemuxVIDs []int
+	NativeVID    int
+
+	// ProtocolVLANs switch the untagged frames of some protocols that the
+	// VLAN receives in other VLANs of the manager, whose replies reach the
+	// sender as if its port were a member of both; the first assignment
+	// matching a frame applies
+	ProtocolVLANs []ProtocolVLAN
+
+	// Bandwidth shapes the traffic the VLAN forwards
+	Bandwidth BandwidthLimit
+
+	// Impairment delays, drops, duplicates or reorders the frames sent to
+	// the VLAN's connections, which may be given their own at runtime
+	Impairment Impairment
+
+	// EgressQueueSize is the number of frames queued for each connection's
+	// writer goroutine; frames beyond it are dropped so that a slow receiver
+	// cannot stall forwarding. Zero writes frames synchronously.
+	EgressQueueSize int
+
+	// Workers is the number of goroutines forwarding the VLAN's frames.
+	// Frames of a connection always go to the same worker, so they stay
+	// in order. Zero forwards frames in each connection's reader.
+	Workers int
+
+	// SlowConsumerPolicy decides which frames are dropped when an egress
+	// queue is full. With SlowConsumerDisconnect, connections whose queue
+	// stays full for SlowConsumerTimeout are disconnected.
+	SlowConsumerPolicy  SlowConsumerPolicy
+	SlowConsumerTimeout time.Duration
+
+	// DrainFIN makes Shutdown half-close each connection once its egress
+	// queue is flushed, sending a TCP FIN, and wait for the client to
+	// close its end, instead of closing it at once
+	DrainFIN bool
+
+	// MACStateDir, if set, is a directory where the VLAN's MAC table is
+	// saved periodically and on shutdown. Saved entries are restored at
+	// startup and rebound when a connection from the same remote address
+	// returns, avoiding a flood of unknown unicast while MACs are relearned.
+	MACStateDir string
+
+	// CountersDir, if set, is a directory where the VLAN's aggregate
+	// counters are saved every minute and on shutdown. Saved counters are
+	// added back at startup, so long-term accounting survives restarts and
+	// upgrades.
+	CountersDir string
+
+	// Hairpin is the default reflective relay setting for new connections,
+	// allowing frames to be sent back out the connection they arrived on
+	Hairpin bool
+
+	// LLDP advertises the switch port of each connection to its guest,
+	// describing the VM its client reported in a hello
+	LLDP bool
+
+	// SLAACPrefixes are /64 prefixes announced in IPv6 router advertisements
+	// so guests can autoconfigure addresses. SLAACDNS servers are announced
+	// alongside them.
+	SLAACPrefixes []*net.IPNet
+	SLAACDNS      []net.IP
+
+	// DHCPSnooping drops DHCP server messages from connections that are not
+	// trusted and records the leases handed out by trusted servers.
+	// Connections whose remote address matches TrustedPeers start trusted.
+	DHCPSnooping bool
+	TrustedPeers []*net.IPNet
+
+	// IPInventory records the IP addresses each connection's guests send
+	// from, learned from ARP packets, IPv4 headers and IPv6 neighbor
+	// discovery, until they are not seen for the MAC timeout
+	IPInventory bool
+
+	// RAGuard drops IPv6 router advertisements and redirects from
+	// untrusted connections
+	RAGuard bool
+
+	// NDInspection drops IPv6 neighbor discovery messages from untrusted
+	// connections that carry a mismatched link-layer address or claim an
+	// address already in use by another connection
+	NDInspection bool
+
+	// DNSAddr enables a DNS forwarder that answers on this IPv4 address.
+	// Names in DNSRecords are answered locally; other queries are relayed
+	// to DNSUpstreams (host:port), which default to the host's resolvers.
+	DNSAddr      net.IP
+	DNSUpstreams []string
+	DNSRecords   map[string]net.IP
+
+	// ProxyARP lists IPv4 addresses whose ARP requests the switch answers
+	// directly instead of flooding them
+	ProxyARP []ProxyARPEntry
+
+	// ARPSuppression answers ARP requests for addresses learned on the
+	// VLAN instead of flooding them, for VLANs stretched across hosts
+	ARPSuppression bool
+
+	// NAT enables a user-mode NAT gateway giving guests outbound IPv4
+	// access through the host's sockets
+	NAT *NATConfig
+
+	// DHCPServer enables a DHCPv4 server handing out addresses and, when a
+	// boot file is set, PXE boot options
+	DHCPServer *DHCPServerConfig
+
+	// TFTPRoot is a directory served read-only over TFTP on the host
+	// stack's addresses, e.g. for PXE boot images
+	TFTPRoot string
+
+	// OpenFlow hands forwarding decisions to an OpenFlow 1.3 controller
+	// instead of MAC learning
+	OpenFlow *OpenFlowConfig
+
+	// SFlow exports sampled frames and connection counters to an sFlow
+	// collector
+	SFlow *SFlowConfig
+
+	// ProtocolStats decodes one in this many received frames to break the
+	// VLAN's traffic down by transport protocol, service port and DNS
+	// query type; 0 disables the breakdown
+	ProtocolStats int
+
+	// CaptureAddr is a TCP address streaming the VLAN's frames in pcap
+	// format to every client that connects, for remote packet capture
+	CaptureAddr string
+
+	// VMNetSocket is the unix socket of a socket_vmnet helper connecting
+	// the VLAN to the host network through the macOS vmnet framework
+	VMNetSocket string
+
+	// FastPath gives the Docker driver's containers veths instead of TAP
+	// devices and has an XDP program forward unicast frames between them
+	// in the kernel once the switch learned both MACs. Frames forwarded
+	// in the kernel are not counted per connection and skip MAC aging, so
+	// each MAC still goes through the switch once per MAC timeout. Linux
+	// only.
+	FastPath bool
+
+	// MACEventHandler, if set, is called when a MAC is learned, moves to
+	// another connection or is removed from the table. It is called from
+	// the forwarding path and must not block.
+	MACEventHandler func(MACEvent)
+}
+
+// SFlowConfig configures sFlow v5 export
+type SFlowConfig struct {
+	// Collector is the collector's UDP address (host:port)
+	Collector string
+
+	// SamplingRate samples one in this many frames on average
+	SamplingRate uint32
+
+	// PollingInterval is how often connection counters are exported
+	PollingInterval time.Duration
+}
+
+// OpenFlowConfig configures the OpenFlow agent of a VLAN
+type OpenFlowConfig struct {
+	// Controller is the controller's TCP address (host:port)
+	Controller string
+
+	// DatapathID identifies the VLAN to the controller; it defaults to
+	// the VLAN's port number
+	DatapathID uint64
+}
+
+// DHCPServerConfig configures the DHCPv4 server of a VLAN
+type DHCPServerConfig struct {
+	// ServerIP is the address the server answers from; it also serves
+	// boot files when TFTP is enabled
+	ServerIP net.IP
+	Subnet   *net.IPNet
+
+	// RangeStart and RangeEnd bound the addresses handed out; they default
+	// to the whole subnet
+	RangeStart net.IP
+	RangeEnd   net.IP
+
+	LeaseTime time.Duration
+
+	// Router and DNS are announced to clients. They default to the NAT
+	// gateway and the DNS forwarder when those are enabled.
+	Router net.IP
+	DNS    []net.IP
+
+	// BootFile is offered to PXE clients; BootFileUEFI, if set, replaces
+	// it for clients reporting a UEFI architecture
+	BootFile     string
+	BootFileUEFI string
+}
+
+// NATConfig configures the user-mode NAT gateway of a VLAN
+type NATConfig struct {
+	// Gateway is the address guests use as their default router
+	Gateway net.IP
+
+	// AllowLoopback relays connections to the gateway address to the
+	// host's loopback interface, exposing services bound there to guests
+	AllowLoopback bool
+
+	// PortForwards expose guest services on host addresses
+	PortForwards []PortForward
+}
+
+// PortForward relays traffic received on a host address to a guest
+type PortForward struct {
+	Protocol  string // "tcp" or "udp"
+	HostAddr  string // Host listen address, e.g. ":2222"
+	GuestIP   net.IP
+	GuestPort uint16
+}
+
+// DefaultEgressQueueSize is the default depth of connection egress queues
+const DefaultEgressQueueSize = 256
+
+// DefaultSlowConsumerTimeout is how long an egress queue may stay full
+// before SlowConsumerDisconnect disconnects the connection
+const DefaultSlowConsumerTimeout = 5 * time.Second
+
+// DefaultMTU is the default MTU of connections, that of standard Ethernet
+const DefaultMTU = 1500
+
+// jumboFrameSize is the size of the largest jumbo frames the frame buffer
+// pools hold
+const jumboFrameSize = 9216
+
+// maxMTU is the MTU of the largest jumbo frames
+const maxMTU = jumboFrameSize - ethernetOverhead
+
+// DefaultMACTimeout is how long learned MAC addresses are kept by default
+const DefaultMACTimeout = 5 * time.Minute
+
+// DefaultConfig returns the default switch configuration
+func DefaultConfig() Config {
+	return Config{
+		MTU:                 DefaultMTU,
+		MACTimeout:          DefaultMACTimeout,
+		Workers:             runtime.GOMAXPROCS(0),
+		EgressQueueSize:     DefaultEgressQueueSize,
+		SlowConsumerTimeout: DefaultSlowConsumerTimeout,
+	}
+}
+
+// discardLogger drops every record; it is used when no logger is configured
+var discardLogger = slog.New(slog.DiscardHandler)
+
+// loggerOrDiscard returns logger, or one discarding all records when it is nil
+func loggerOrDiscard(logger *slog.Logger) *slog.Logger {
+	if logger != nil {
+		return logger
+	}
+	return discardLogger
+}
+
+// logger returns the configured logger
+func (c Config) logger() *slog.Logger {
+	return loggerOrDiscard(c.Logger)
+}
+
+// maxFrameSize returns the size of the largest frame a connection may send
+func (c Config) maxFrameSize() int {
+	mtu := c.MTU
+	if mtu <= 0 {
+		mtu = DefaultMTU
+	}
+	return mtu + ethernetOverhead
+}
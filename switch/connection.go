@@ -2,14 +2,36 @@
 package vswitch
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// errQueueFull is returned when a frame is dropped because the egress
+// queue of a connection is full
+var errQueueFull = errors.New("egress queue full")
+
+// zeroPadding pads runts to the Ethernet minimum
+var zeroPadding [minFrameSize]byte
+
+// errMalformedFrame is returned when a frame read from a connection cannot
+// be parsed; the stream itself is intact, so reading may go on
+var errMalformedFrame = errors.New("malformed frame")
+
+// errPortDisabled is returned when a frame is dropped because the
+// connection is administratively disabled
+var errPortDisabled = errors.New("port disabled")
+
+// errDraining is the error of frames refused while the switch drains its
+// connections on shutdown
+var errDraining = errors.New("draining")
+
 // Connection represents a single QEMU VM connection
 type Connection struct {
 	ID       string
@@ -22,18 +44,133 @@ type Connection struct {
 	BytesSent      uint64
 	BytesReceived  uint64
 
+	// statsResetAt is when the statistics were last reset, or else the
+	// connection was created
+	statsResetAt time.Time
+
+	// Port settings
+	mode      PortMode
+	community string
+	hairpin   bool
+	trusted   bool
+	trunk     bool
+	horizon   string
+	disabled  atomic.Bool
+
+	// protocolProxy is set on the connections standing in for a connection
+	// of another VLAN whose protocol VLANs switch frames here
+	protocolProxy bool
+
+	// label names the VM behind the connection, as registered by its
+	// orchestrator
+	label string
+
+	// hello is what the client presented in its hello, if any: its
+	// persistent identity and the VM behind it; replaced is set when a
+	// reconnect of the same client took the connection's place
+	hello    Hello
+	replaced atomic.Bool
+
+	// Egress queue drained by the writer goroutine; nil when frames are
+	// written synchronously
+	queue      chan *EthernetFrame
+	done       chan struct{}
+	queueDrops atomic.Uint64
+
+	// flushed is signalled when the writer reaches the nil frame flush
+	// queued, after which SendFrame refuses frames
+	flushed  chan struct{}
+	flushing atomic.Bool
+
+	// Limits of the flooded frames sent to a trunk link, by class; nil
+	// for unlimited classes
+	bumLimiters [bumClassCount]*rateLimiter
+	bumDrops    atomic.Uint64
+
+	// Slow consumer handling of a full egress queue
+	policy         SlowConsumerPolicy
+	policyTimeout  time.Duration
+	queueFullSince atomic.Int64 // UnixNano, zero while frames fit
+	slowConsumer   atomic.Bool  // Set when the policy disconnected it
+
+	// Rolling average throughput in each direction
+	rxRates rateMeter
+	txRates rateMeter
+
+	// deliveryLatency records how long frames took from their reception
+	// until they were written to the peer, if set
+	deliveryLatency *latencyHistogram
+
+	// impairment degrades the frames sent to the peer: the connection's
+	// own if set, or else its VLAN's
+	impairment      atomic.Pointer[Impairment]
+	vlanImpairment  *atomic.Pointer[Impairment]
+	impairmentDrops atomic.Uint64
+	impairmentHeld  atomic.Int64 // Delayed frames not sent yet
+
+	// lengthBuf holds the length prefix of the frame being read
+	lengthBuf [4]byte
+
+	// maxFrame is the size of the largest frame accepted from the peer
+	maxFrame int
+
+	// padFrames pads frames sent to the peer to the Ethernet minimum
+	padFrames bool
+
+	// logger receives the connection's log records
+	logger *slog.Logger
+
+	// reported holds when each ongoing violation was last reported as an
+	// event
+	reported map[string]time.Time
+
 	// Connection state
 	mutex  sync.RWMutex
 	closed bool
 }
 
+// ConnectionInfo is a snapshot of a connection's settings and statistics
+type ConnectionInfo struct {
+	ID              string      `json:"id"`
+	RemoteAddr      string      `json:"remote_addr"`
+	Label           string      `json:"label,omitempty"`
+	ClientID        string      `json:"client_id,omitempty"`
+	VMName          string      `json:"vm_name,omitempty"`
+	VMUUID          string      `json:"vm_uuid,omitempty"`
+	Interface       string      `json:"interface,omitempty"`
+	Mode            string      `json:"mode"`
+	Community       string      `json:"community,omitempty"`
+	Hairpin         bool        `json:"hairpin"`
+	Trusted         bool        `json:"trusted"`
+	Trunk           bool        `json:"trunk"`
+	Horizon         string      `json:"horizon,omitempty"`
+	Disabled        bool        `json:"disabled"`
+	FramesSent      uint64      `json:"frames_sent"`
+	FramesReceived  uint64      `json:"frames_received"`
+	BytesSent       uint64      `json:"bytes_sent"`
+	BytesReceived   uint64      `json:"bytes_received"`
+	QueueDepth      int         `json:"queue_depth"`
+	QueueDrops      uint64      `json:"queue_drops"`
+	BUMDrops        uint64      `json:"bum_drops"`
+	Impairment      *Impairment `json:"impairment,omitempty"`
+	ImpairmentDrops uint64      `json:"impairment_drops"`
+	RxRates         Rates       `json:"rx_rates"`
+	TxRates         Rates       `json:"tx_rates"`
+	StatsResetAt    time.Time   `json:"stats_reset_at"`
+	LastSeen        time.Time   `json:"last_seen"`
+}
+
 // NewConnection creates a new Connection instance
 func NewConnection(id string, conn net.Conn) *Connection {
+	now := time.Now()
 	return &Connection{
-		ID:       id,
-		Conn:     conn,
-		LastSeen: time.Now(),
-		closed:   false,
+		ID:           id,
+		Conn:         conn,
+		LastSeen:     now,
+		statsResetAt: now,
+		closed:       false,
+		maxFrame:     DefaultMTU + ethernetOverhead,
+		logger:       discardLogger,
 	}
 }
 
@@ -47,7 +184,7 @@ func (c *Connection) ReadFrame() (*EthernetFrame, error) {
 	c.mutex.RUnlock()
 
 	// Read frame length (first 4 bytes in network byte order)
-	lengthBytes := make([]byte, 4)
+	lengthBytes := c.lengthBuf[:]
 	if _, err := io.ReadFull(c.Conn, lengthBytes); err != nil {
 		return nil, fmt.Errorf("failed to read frame length: %w", err)
 	}
@@ -57,36 +194,218 @@ func (c *Connection) ReadFrame() (*EthernetFrame, error) {
 		uint32(lengthBytes[2])<<8 | uint32(lengthBytes[3])
 
 	// Validate frame length
-	if frameLen == 0 || frameLen > 1518 {
+	if frameLen == 0 || frameLen > uint32(c.maxFrame) {
 		return nil, fmt.Errorf("invalid frame length: %d", frameLen)
 	}
 
-	frameData := getFrameBuffer()[:frameLen]
+	frameData := getFrameBuffer(int(frameLen))
 	if _, err := io.ReadFull(c.Conn, frameData); err != nil {
+		putFrameBuffer(frameData)
 		return nil, fmt.Errorf("failed to read frame data: %w", err)
 	}
+	received := time.Now()
 
 	// Parse the Ethernet frame
-	frame, err := ParseEthernetFrame(frameData)
+	frame, err := newPooledFrame(frameData)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse frame: %w", err)
+		putFrameBuffer(frameData)
+		return nil, fmt.Errorf("%w: %w", errMalformedFrame, err)
 	}
 
-	// Validate the frame
-	if err := frame.Validate(); err != nil {
-		return nil, fmt.Errorf("invalid frame: %w", err)
-	}
+	frame.received = received
 
 	// Update statistics
 	c.mutex.Lock()
 	c.FramesReceived++
 	c.BytesReceived += uint64(len(frameData))
-	c.LastSeen = time.Now()
+	c.LastSeen = frame.received
 	c.mutex.Unlock()
 
 	return frame, nil
 }
 
+// StartWriter gives the connection an egress queue of the given depth,
+// drained by a writer goroutine until the connection is closed. Frames sent
+// with SendFrame then no longer block the caller on a slow receiver; the
+// policy decides what to drop when the queue is full.
+func (c *Connection) StartWriter(depth int, policy SlowConsumerPolicy, timeout time.Duration) {
+	c.policy = policy
+	c.policyTimeout = timeout
+	c.queue = make(chan *EthernetFrame, depth)
+	c.done = make(chan struct{})
+	c.flushed = make(chan struct{}, 1)
+	go c.writeLoop()
+}
+
+// writeLoop writes queued frames until the connection is closed
+func (c *Connection) writeLoop() {
+	for {
+		select {
+		case <-c.done:
+			return
+		case frame := <-c.queue:
+			if frame == nil {
+				c.flushed <- struct{}{}
+				continue
+			}
+			err := c.writeData(frame.Raw)
+			received := frame.received
+			frame.Release()
+			if err != nil {
+				c.logger.Debug("Failed to write queued frame", "connection", c.ID, "error", err)
+				_ = c.Close()
+				return
+			}
+			c.delivered(received)
+		}
+	}
+}
+
+// SendFrame queues a frame for the writer goroutine, or writes it directly
+// if the connection has no egress queue. When the queue is full a frame is
+// dropped and counted according to the slow consumer policy. The frame may
+// be released once SendFrame returns: pooled frames are queued without
+// copying and hold on to their buffer until written, while other frames
+// are copied into a pooled one. Frames go through the connection's
+// impairment first, if any.
+func (c *Connection) SendFrame(frame *EthernetFrame) error {
+	if impairment := c.currentImpairment(); impairment != nil && !impairment.IsZero() && frame != nil && len(frame.Raw) > 0 {
+		return c.sendImpaired(frame, impairment)
+	}
+	return c.send(frame)
+}
+
+// send queues or writes a frame as SendFrame does, past the impairment
+func (c *Connection) send(frame *EthernetFrame) error {
+	if c.disabled.Load() {
+		return errPortDisabled
+	}
+	if c.queue == nil {
+		if err := c.WriteFrame(frame); err != nil {
+			return err
+		}
+		c.delivered(frame.received)
+		return nil
+	}
+	if frame == nil || len(frame.Raw) == 0 {
+		return fmt.Errorf("frame cannot be empty")
+	}
+	if c.IsClosed() {
+		return fmt.Errorf("connection closed")
+	}
+	if c.flushing.Load() {
+		return errDraining
+	}
+
+	queued, err := hold(frame)
+	if err != nil {
+		return err
+	}
+
+	select {
+	case c.queue <- queued:
+		c.queueFullSince.Store(0)
+		return nil
+	default:
+	}
+
+	c.queueDrops.Add(1)
+	switch c.policy {
+	case SlowConsumerDropTail:
+		select {
+		case old := <-c.queue:
+			if old == nil {
+				// The frames ahead of a flush were all written
+				c.flushed <- struct{}{}
+				break
+			}
+			old.Release()
+		default:
+		}
+		select {
+		case c.queue <- queued:
+			return nil
+		default:
+		}
+	case SlowConsumerDisconnect:
+		now := time.Now().UnixNano()
+		if !c.queueFullSince.CompareAndSwap(0, now) &&
+			time.Duration(now-c.queueFullSince.Load()) >= c.policyTimeout {
+			c.slowConsumer.Store(true)
+			_ = c.Close()
+		}
+	}
+	queued.Release()
+	return errQueueFull
+}
+
+// hold returns a frame that stays valid once the caller released frame, and
+// must be released in turn: pooled frames are retained, while other frames
+// are copied into a pooled one
+func hold(frame *EthernetFrame) (*EthernetFrame, error) {
+	if frame.pooled {
+		frame.retain()
+		return frame, nil
+	}
+	held, err := newPooledFrame(append(getFrameBuffer(len(frame.Raw))[:0], frame.Raw...))
+	if err != nil {
+		return nil, err
+	}
+	held.received = frame.received
+	return held, nil
+}
+
+// delivered records the delivery latency of a frame written to the peer.
+// Frames originated by the switch have no reception time and are skipped.
+func (c *Connection) delivered(received time.Time) {
+	if c.deliveryLatency != nil && !received.IsZero() {
+		c.deliveryLatency.observe(time.Since(received))
+	}
+}
+
+// flush refuses further frames and waits until the writer has written the
+// frames already queued, so that closing the connection afterwards does not
+// cut them off, or until ctx is done
+func (c *Connection) flush(ctx context.Context) error {
+	if c.queue == nil || c.flushing.Swap(true) {
+		return nil
+	}
+
+	// The writer signals when it reaches the nil frame queued behind the
+	// others
+	select {
+	case c.queue <- nil:
+	case <-c.done:
+		return fmt.Errorf("connection closed")
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case <-c.flushed:
+		return nil
+	case <-c.done:
+		return fmt.Errorf("connection closed")
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// closeWrite half-closes the connection, sending a TCP FIN, so that the
+// client sees the end of the stream while it can still send; streams that
+// cannot be half-closed are closed
+func (c *Connection) closeWrite() error {
+	if conn, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return conn.CloseWrite()
+	}
+	return c.Close()
+}
+
+// SlowConsumer returns true if the connection was disconnected for not
+// draining its egress queue
+func (c *Connection) SlowConsumer() bool {
+	return c.slowConsumer.Load()
+}
+
 // WriteFrame writes an Ethernet frame to the connection
 func (c *Connection) WriteFrame(frame *EthernetFrame) error {
 	if frame == nil {
@@ -104,11 +423,22 @@ func (c *Connection) WriteFrame(frame *EthernetFrame) error {
 		return fmt.Errorf("frame data cannot be empty")
 	}
 
-	frameData := frame.Raw
+	return c.writeData(frame.Raw)
+}
+
+// writeData writes the length prefix and data of a frame
+func (c *Connection) writeData(frameData []byte) error {
 	dataLen := len(frameData)
 	if dataLen > 0xFFFFFFFF {
 		return fmt.Errorf("frame data too large: %d bytes", dataLen)
 	}
+
+	// Pad runts with zeros, leaving the shared frame untouched
+	var padding []byte
+	if c.padFrames && dataLen < minFrameSize {
+		padding = zeroPadding[:minFrameSize-dataLen]
+		dataLen = minFrameSize
+	}
 	frameLen := uint32(dataLen)
 
 	// Write frame length first (big endian)
@@ -120,13 +450,16 @@ func (c *Connection) WriteFrame(frame *EthernetFrame) error {
 
 	// Write frame length and frame data in a single write using net.Buffers (scatter/gather I/O)
 	buffers := net.Buffers{lengthBytes[:], frameData}
+	if padding != nil {
+		buffers = append(buffers, padding)
+	}
 	if _, err := buffers.WriteTo(c.Conn); err != nil {
 		return fmt.Errorf("failed to write frame length and data: %w", err)
 	}
 	// Update statistics
 	c.mutex.Lock()
 	c.FramesSent++
-	c.BytesSent += uint64(len(frameData))
+	c.BytesSent += uint64(dataLen)
 	c.mutex.Unlock()
 
 	return nil
@@ -142,13 +475,18 @@ func (c *Connection) Close() error {
 	}
 
 	c.closed = true
+	if c.done != nil {
+		close(c.done)
+	}
 	if err := c.Conn.Close(); err != nil {
-		log.Printf("Error closing connection %s: %v", c.ID, err)
+		c.logger.Warn("Error closing connection", "connection", c.ID, "error", err)
 		return err
 	}
 
-	log.Printf("Connection %s closed (sent: %d frames/%d bytes, received: %d frames/%d bytes)",
-		c.ID, c.FramesSent, c.BytesSent, c.FramesReceived, c.BytesReceived)
+	attrs := []any{"connection", c.ID,
+		"frames_sent", c.FramesSent, "bytes_sent", c.BytesSent,
+		"frames_received", c.FramesReceived, "bytes_received", c.BytesReceived}
+	c.logger.Info("Connection closed", append(attrs, c.hello.attrs()...)...)
 
 	return nil
 }
@@ -160,6 +498,166 @@ func (c *Connection) IsClosed() bool {
 	return c.closed
 }
 
+// SetPortMode sets the private VLAN mode of the connection. The community
+// name is only meaningful for PortCommunity.
+func (c *Connection) SetPortMode(mode PortMode, community string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.mode = mode
+	c.community = community
+}
+
+// PortMode returns the private VLAN mode and community of the connection
+func (c *Connection) PortMode() (PortMode, string) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	return c.mode, c.community
+}
+
+// SetHairpin enables or disables reflective relay on the connection
+func (c *Connection) SetHairpin(enabled bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.hairpin = enabled
+}
+
+// Hairpin returns true if frames may be forwarded back out this connection
+func (c *Connection) Hairpin() bool {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	return c.hairpin
+}
+
+// SetLabel names the VM behind the connection
+func (c *Connection) SetLabel(label string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.label = label
+}
+
+// Label returns the name of the VM behind the connection, if registered
+func (c *Connection) Label() string {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	return c.label
+}
+
+// setHello records what the client presented in its hello
+func (c *Connection) setHello(hello Hello) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.hello = hello
+}
+
+// Hello returns what the client presented in its hello, if anything
+func (c *Connection) Hello() Hello {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	return c.hello
+}
+
+// ClientID returns the persistent identity the client presented, if any
+func (c *Connection) ClientID() string {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	return c.hello.ID
+}
+
+// inherit adds the statistics of the previous connection of the same
+// client to c, counting from when they were last reset, and takes over its
+// label unless c has one
+func (c *Connection) inherit(previous *Connection) {
+	previous.mutex.RLock()
+	framesSent, framesReceived := previous.FramesSent, previous.FramesReceived
+	bytesSent, bytesReceived := previous.BytesSent, previous.BytesReceived
+	label, statsResetAt := previous.label, previous.statsResetAt
+	previous.mutex.RUnlock()
+
+	c.mutex.Lock()
+	c.FramesSent += framesSent
+	c.FramesReceived += framesReceived
+	c.BytesSent += bytesSent
+	c.BytesReceived += bytesReceived
+	c.statsResetAt = statsResetAt
+	if c.label == "" {
+		c.label = label
+	}
+	c.mutex.Unlock()
+
+	c.queueDrops.Add(previous.queueDrops.Load())
+	c.bumDrops.Add(previous.bumDrops.Load())
+	c.impairmentDrops.Add(previous.impairmentDrops.Load())
+	if impairment := previous.impairment.Load(); impairment != nil && c.impairment.Load() == nil {
+		c.impairment.Store(impairment)
+	}
+}
+
+// SetDisabled administratively disables or re-enables the connection. A
+// disabled connection stays open but neither sends nor forwards frames.
+func (c *Connection) SetDisabled(disabled bool) {
+	c.disabled.Store(disabled)
+}
+
+// Disabled returns true if the connection is administratively disabled
+func (c *Connection) Disabled() bool {
+	return c.disabled.Load()
+}
+
+// SetTrusted marks the connection as trusted to send infrastructure
+// traffic such as DHCP server replies
+func (c *Connection) SetTrusted(trusted bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.trusted = trusted
+}
+
+// Trusted returns true if the connection may send infrastructure traffic
+func (c *Connection) Trusted() bool {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	return c.trusted
+}
+
+// SetTrunk marks the connection as a link to another switch, through
+// which the MAC addresses of that switch are reached
+func (c *Connection) SetTrunk(trunk bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.trunk = trunk
+}
+
+// Trunk returns true if the connection links to another switch
+func (c *Connection) Trunk() bool {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	return c.trunk
+}
+
+// canReach reports whether private VLAN rules allow frames from c to dst
+func (c *Connection) canReach(dst *Connection) bool {
+	srcMode, srcCommunity := c.PortMode()
+	dstMode, dstCommunity := dst.PortMode()
+
+	if srcMode == PortPromiscuous || dstMode == PortPromiscuous {
+		return true
+	}
+
+	return srcMode == PortCommunity && dstMode == PortCommunity && srcCommunity == dstCommunity
+}
+
 // RemoteAddr returns the remote address of the connection
 func (c *Connection) RemoteAddr() string {
 	if c.Conn != nil {
@@ -168,6 +666,75 @@ func (c *Connection) RemoteAddr() string {
 	return "unknown"
 }
 
+// host returns the address of the host the connection comes from, without
+// the ephemeral port
+func (c *Connection) host() string {
+	if c.Conn == nil {
+		return ""
+	}
+	if ip := addrIP(c.Conn.RemoteAddr()); ip != nil {
+		return ip.String()
+	}
+	return c.Conn.RemoteAddr().String()
+}
+
+// stateKey identifies the peer of the connection in the saved MAC state:
+// the client's identity if it presented one, or else its remote address
+func (c *Connection) stateKey() string {
+	if id := c.ClientID(); id != "" {
+		return "client:" + id
+	}
+	return c.RemoteAddr()
+}
+
+// Info returns a snapshot of the connection's settings and statistics
+func (c *Connection) Info() ConnectionInfo {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	return ConnectionInfo{
+		ID:              c.ID,
+		RemoteAddr:      c.RemoteAddr(),
+		Label:           c.label,
+		ClientID:        c.hello.ID,
+		VMName:          c.hello.VMName,
+		VMUUID:          c.hello.VMUUID,
+		Interface:       c.hello.Interface,
+		Mode:            c.mode.String(),
+		Community:       c.community,
+		Hairpin:         c.hairpin,
+		Trusted:         c.trusted,
+		Trunk:           c.trunk,
+		Horizon:         c.horizon,
+		Disabled:        c.disabled.Load(),
+		FramesSent:      c.FramesSent,
+		FramesReceived:  c.FramesReceived,
+		BytesSent:       c.BytesSent,
+		BytesReceived:   c.BytesReceived,
+		QueueDepth:      len(c.queue),
+		QueueDrops:      c.queueDrops.Load(),
+		BUMDrops:        c.bumDrops.Load(),
+		Impairment:      c.impairment.Load(),
+		ImpairmentDrops: c.impairmentDrops.Load(),
+		RxRates:         c.rxRates.get(),
+		TxRates:         c.txRates.get(),
+		StatsResetAt:    c.statsResetAt,
+		LastSeen:        c.LastSeen,
+	}
+}
+
+// sampleRates samples the counters of the connection for its throughput
+// rates
+func (c *Connection) sampleRates(now time.Time) {
+	c.mutex.RLock()
+	framesReceived, bytesReceived := c.FramesReceived, c.BytesReceived
+	framesSent, bytesSent := c.FramesSent, c.BytesSent
+	c.mutex.RUnlock()
+
+	c.rxRates.sample(now, framesReceived, bytesReceived)
+	c.txRates.sample(now, framesSent, bytesSent)
+}
+
 // String returns a string representation of the connection
 func (c *Connection) String() string {
 	c.mutex.RLock()
@@ -331,3 +331,250 @@ func TestConnectionRemoteAddr(t *testing.T) {
 		t.Errorf("Expected 'unknown' for nil connection, got '%s'", addr2)
 	}
 }
+
+func TestConnectionSendFrameQueued(t *testing.T) {
+	local, remote := net.Pipe()
+	defer func() { _ = remote.Close() }()
+
+	conn := NewConnection("test-conn", local)
+	conn.StartWriter(1, SlowConsumerDropNew, 0)
+	defer func() { _ = conn.Close() }()
+
+	frameData := []byte{0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09, 0x0a, 0x0b, 0x0c, 0x08, 0x00}
+
+	// Nobody reads the pipe, so the writer blocks on the first frame and
+	// the queue fills up behind it without blocking the sender
+	sent := 0
+	for i := 0; i < 3; i++ {
+		if err := conn.SendFrame(&EthernetFrame{Raw: frameData}); err == nil {
+			sent++
+		} else if err != errQueueFull {
+			t.Fatalf("Unexpected error sending frame: %v", err)
+		}
+	}
+	if sent == 0 || sent == 3 {
+		t.Fatalf("Expected some frames queued and some dropped, got %d sent", sent)
+	}
+	if info := conn.Info(); info.QueueDrops != uint64(3-sent) {
+		t.Errorf("Expected %d queue drops, got %d", 3-sent, info.QueueDrops)
+	}
+
+	// Queued frames are delivered once the receiver reads
+	_ = remote.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 4+len(frameData))
+	for i := 0; i < sent; i++ {
+		if _, err := io.ReadFull(remote, buf); err != nil {
+			t.Fatalf("Failed to read frame %d: %v", i, err)
+		}
+		if buf[3] != byte(len(frameData)) || buf[4] != 0x01 {
+			t.Errorf("Unexpected frame %d: %x", i, buf)
+		}
+	}
+}
+
+func TestConnectionDeliveryLatency(t *testing.T) {
+	local, remote := net.Pipe()
+	defer func() { _ = remote.Close() }()
+
+	var latency latencyHistogram
+	conn := NewConnection("test-conn", local)
+	conn.deliveryLatency = &latency
+	conn.StartWriter(4, SlowConsumerDropNew, 0)
+	defer func() { _ = conn.Close() }()
+
+	// Frames originated by the switch have no reception time to measure from
+	frameData := []byte{0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09, 0x0a, 0x0b, 0x0c, 0x08, 0x00}
+	if err := conn.SendFrame(&EthernetFrame{Raw: frameData, received: time.Now().Add(-10 * time.Millisecond)}); err != nil {
+		t.Fatalf("Failed to send frame: %v", err)
+	}
+	if err := conn.SendFrame(&EthernetFrame{Raw: frameData}); err != nil {
+		t.Fatalf("Failed to send frame: %v", err)
+	}
+
+	_ = remote.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.ReadFull(remote, make([]byte, 2*(4+len(frameData)))); err != nil {
+		t.Fatalf("Failed to read frames: %v", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for latency.snapshot().Count == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if snapshot := latency.snapshot(); snapshot.Count != 1 || snapshot.SumSeconds < 0.01 {
+		t.Errorf("Expected one delivery of at least 10ms, got %+v", snapshot)
+	}
+}
+
+func TestConnectionSendFrameWithoutQueue(t *testing.T) {
+	mockConn := &mockConn{addr: &mockAddr{network: "tcp", address: "127.0.0.1:8080"}}
+	conn := NewConnection("test-conn", mockConn)
+
+	frameData := []byte{0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09, 0x0a, 0x0b, 0x0c, 0x08, 0x00}
+	if err := conn.SendFrame(&EthernetFrame{Raw: frameData}); err != nil {
+		t.Fatalf("Unexpected error sending frame: %v", err)
+	}
+	if len(mockConn.writeData) != 4+len(frameData) {
+		t.Errorf("Expected the frame to be written synchronously, got %d bytes", len(mockConn.writeData))
+	}
+}
+
+func TestConnectionPadFrames(t *testing.T) {
+	mockConn := &mockConn{addr: &mockAddr{network: "tcp", address: "127.0.0.1:8080"}}
+	conn := NewConnection("test-conn", mockConn)
+	conn.padFrames = true
+
+	frameData := []byte{0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09, 0x0a, 0x0b, 0x0c, 0x08, 0x06, 0x00, 0x01}
+	if err := conn.WriteFrame(&EthernetFrame{Raw: frameData}); err != nil {
+		t.Fatalf("Unexpected error writing frame: %v", err)
+	}
+	if len(mockConn.writeData) != 4+minFrameSize {
+		t.Fatalf("Expected the frame to be padded to %d bytes, got %d", minFrameSize, len(mockConn.writeData)-4)
+	}
+	if mockConn.writeData[3] != minFrameSize {
+		t.Errorf("Expected the length prefix to cover the padding, got %d", mockConn.writeData[3])
+	}
+	for i, b := range mockConn.writeData[4+len(frameData):] {
+		if b != 0 {
+			t.Errorf("Expected padding byte %d to be zero, got 0x%02x", i, b)
+		}
+	}
+	if conn.BytesSent != minFrameSize {
+		t.Errorf("Expected the padding to be counted, got %d bytes sent", conn.BytesSent)
+	}
+
+	// Frames at the minimum are sent as they are
+	mockConn.writeData = nil
+	if err := conn.WriteFrame(&EthernetFrame{Raw: make([]byte, 64)}); err != nil {
+		t.Fatalf("Unexpected error writing frame: %v", err)
+	}
+	if len(mockConn.writeData) != 4+64 {
+		t.Errorf("Expected a full-size frame to be left alone, got %d bytes", len(mockConn.writeData)-4)
+	}
+}
+
+// waitQueueEmpty waits until the writer goroutine has taken every queued frame
+func waitQueueEmpty(t *testing.T, conn *Connection) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(conn.queue) > 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Writer did not drain the queue")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestConnectionSlowConsumerDropTail(t *testing.T) {
+	local, remote := net.Pipe()
+	defer func() { _ = remote.Close() }()
+
+	conn := NewConnection("test-conn", local)
+	conn.StartWriter(1, SlowConsumerDropTail, 0)
+	defer func() { _ = conn.Close() }()
+
+	// The writer blocks on the first frame; later ones replace each other
+	for i, marker := range []byte{0xa0, 0xa1, 0xa2, 0xa3} {
+		frameData := []byte{0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09, 0x0a, 0x0b, 0x0c, 0x08, marker}
+		_ = conn.SendFrame(&EthernetFrame{Raw: frameData})
+		if i == 0 {
+			waitQueueEmpty(t, conn)
+		}
+	}
+	if info := conn.Info(); info.QueueDrops != 2 {
+		t.Errorf("Expected 2 queue drops, got %d", info.QueueDrops)
+	}
+
+	_ = remote.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 18)
+	for _, expected := range []byte{0xa0, 0xa3} {
+		if _, err := io.ReadFull(remote, buf); err != nil {
+			t.Fatalf("Failed to read frame: %v", err)
+		}
+		if buf[17] != expected {
+			t.Errorf("Expected frame %x, got %x", expected, buf[17])
+		}
+	}
+}
+
+func TestConnectionSlowConsumerDisconnect(t *testing.T) {
+	local, remote := net.Pipe()
+	defer func() { _ = remote.Close() }()
+
+	conn := NewConnection("test-conn", local)
+	conn.StartWriter(1, SlowConsumerDisconnect, 0)
+	defer func() { _ = conn.Close() }()
+
+	frameData := []byte{0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09, 0x0a, 0x0b, 0x0c, 0x08, 0x00}
+	_ = conn.SendFrame(&EthernetFrame{Raw: frameData})
+	waitQueueEmpty(t, conn)
+	_ = conn.SendFrame(&EthernetFrame{Raw: frameData})
+
+	// The first overflow starts the timeout, the next one disconnects
+	_ = conn.SendFrame(&EthernetFrame{Raw: frameData})
+	if conn.IsClosed() {
+		t.Fatalf("Expected the connection to survive the first overflow")
+	}
+	_ = conn.SendFrame(&EthernetFrame{Raw: frameData})
+	if !conn.IsClosed() || !conn.SlowConsumer() {
+		t.Errorf("Expected the slow consumer to be disconnected")
+	}
+}
+
+func TestParseSlowConsumerPolicy(t *testing.T) {
+	for _, policy := range []SlowConsumerPolicy{SlowConsumerDropNew, SlowConsumerDropTail, SlowConsumerDisconnect} {
+		parsed, err := ParseSlowConsumerPolicy(policy.String())
+		if err != nil || parsed != policy {
+			t.Errorf("ParseSlowConsumerPolicy(%q) = %v, %v", policy.String(), parsed, err)
+		}
+	}
+	if _, err := ParseSlowConsumerPolicy("block"); err == nil {
+		t.Errorf("Expected an error for an unknown policy")
+	}
+}
+
+func TestConnectionSendFrameSharesPooledBuffer(t *testing.T) {
+	conn1 := NewConnection("conn1", &mockConn{})
+	conn2 := NewConnection("conn2", &mockConn{})
+	conn1.queue = make(chan *EthernetFrame, 1)
+	conn2.queue = make(chan *EthernetFrame, 1)
+
+	frame, err := newPooledFrame(append(getFrameBuffer(len(testFrameData))[:0], testFrameData...))
+	if err != nil {
+		t.Fatalf("Failed to parse frame: %v", err)
+	}
+	_ = conn1.SendFrame(frame)
+	_ = conn2.SendFrame(frame)
+
+	queued1, queued2 := <-conn1.queue, <-conn2.queue
+	if queued1 != frame || queued2 != frame {
+		t.Fatalf("Expected the pooled frame to be queued without copying")
+	}
+
+	// The buffer stays valid until every holder released it
+	frame.Release()
+	queued1.Release()
+	if frame.Raw == nil {
+		t.Fatalf("Expected the buffer to outlive all but the last holder")
+	}
+	queued2.Release()
+	if frame.Raw != nil {
+		t.Errorf("Expected the buffer to return to the pool after the last holder")
+	}
+}
+
+func TestConnectionSendFrameCopiesUnpooled(t *testing.T) {
+	conn := NewConnection("conn1", &mockConn{})
+	conn.queue = make(chan *EthernetFrame, 1)
+
+	raw := append([]byte(nil), testFrameData...)
+	frame := &EthernetFrame{Raw: raw}
+	_ = conn.SendFrame(frame)
+	raw[0] = 0xff
+
+	queued := <-conn.queue
+	defer queued.Release()
+	if queued == frame || queued.Raw[0] != testFrameData[0] {
+		t.Errorf("Expected an unpooled frame to be copied")
+	}
+}
@@ -0,0 +1,449 @@
+package vswitch
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// controlTimeout bounds each request made over the control socket
+const controlTimeout = 10 * time.Second
+
+// ListenControlSocket listens on a unix socket only the current user can
+// connect to, replacing a stale socket left behind by a previous run. On
+// Linux, a path starting with @ names a socket in the abstract namespace,
+// which has no file to clean up.
+func ListenControlSocket(path string) (net.Listener, error) {
+	return listenPrivateSocket(path, "control socket")
+}
+
+// ListenPluginSocket listens on the unix socket Docker discovers the network
+// driver plugin on, creating its directory if needed
+func ListenPluginSocket(path string) (net.Listener, error) {
+	if !isAbstractSocket(path) {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return nil, err
+		}
+	}
+	return listenPrivateSocket(path, "plugin socket")
+}
+
+// isAbstractSocket tells whether a unix socket path names a socket in
+// Linux's abstract namespace, which exists only while it is open
+func isAbstractSocket(path string) bool {
+	return strings.HasPrefix(path, "@")
+}
+
+// listenPrivateSocket listens on a unix socket only the current user can
+// connect to, replacing a stale socket left behind by a previous run
+func listenPrivateSocket(path, kind string) (net.Listener, error) {
+	if isAbstractSocket(path) {
+		return listenAbstractSocket(path, kind)
+	}
+
+	// During an upgrade the socket is still served by the previous process
+	if sockets.inherits("unix/" + path) {
+		return Listen("unix", path)
+	}
+
+	if conn, err := net.Dial("unix", path); err == nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("%s %s is in use", kind, path)
+	}
+
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("failed to remove stale %s: %v", kind, err)
+		}
+	}
+
+	listener, err := Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0o600); err != nil {
+		_ = listener.Close()
+		return nil, fmt.Errorf("failed to restrict %s: %v", kind, err)
+	}
+
+	return listener, nil
+}
+
+// ControlClient talks to a running switch over its control socket
+type ControlClient struct {
+	client *http.Client
+}
+
+// NewControlClient creates a client for the control socket at path
+func NewControlClient(path string) *ControlClient {
+	dialer := &net.Dialer{}
+	return &ControlClient{
+		client: &http.Client{
+			Timeout: controlTimeout,
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					return dialer.DialContext(ctx, "unix", path)
+				},
+			},
+		},
+	}
+}
+
+// Stats returns the aggregated statistics of the switch
+func (c *ControlClient) Stats() (map[string]interface{}, error) {
+	var stats map[string]interface{}
+	err := c.do(http.MethodGet, "/stats", nil, &stats)
+	return stats, err
+}
+
+// VLANs returns the ports of the switch's VLANs
+func (c *ControlClient) VLANs() ([]int, error) {
+	var ports []int
+	err := c.do(http.MethodGet, "/vlans", nil, &ports)
+	return ports, err
+}
+
+// VLANStats returns the statistics of one VLAN
+func (c *ControlClient) VLANStats(port int) (map[string]interface{}, error) {
+	var stats map[string]interface{}
+	err := c.do(http.MethodGet, vlanPath(port), nil, &stats)
+	return stats, err
+}
+
+// Connections returns the connections of one VLAN
+func (c *ControlClient) Connections(port int) ([]ConnectionInfo, error) {
+	var connections []ConnectionInfo
+	err := c.do(http.MethodGet, vlanPath(port)+"/connections", nil, &connections)
+	return connections, err
+}
+
+// MACTable returns the MAC table of one VLAN
+func (c *ControlClient) MACTable(port int) ([]MACTableEntry, error) {
+	var entries []MACTableEntry
+	err := c.do(http.MethodGet, vlanPath(port)+"/macs", nil, &entries)
+	return entries, err
+}
+
+// AllMACTables returns the MAC tables of all VLANs
+func (c *ControlClient) AllMACTables() ([]MACTableEntry, error) {
+	var entries []MACTableEntry
+	err := c.do(http.MethodGet, "/macs", nil, &entries)
+	return entries, err
+}
+
+// IPInventory returns the IP addresses seen on one VLAN
+func (c *ControlClient) IPInventory(port int) ([]IPInventoryEntry, error) {
+	var entries []IPInventoryEntry
+	err := c.do(http.MethodGet, vlanPath(port)+"/ips", nil, &entries)
+	return entries, err
+}
+
+// AllIPInventories returns the IP addresses seen on all VLANs
+func (c *ControlClient) AllIPInventories() ([]IPInventoryEntry, error) {
+	var entries []IPInventoryEntry
+	err := c.do(http.MethodGet, "/ips", nil, &entries)
+	return entries, err
+}
+
+// FindIP returns the VLANs, MACs and connections an IP address was seen on
+func (c *ControlClient) FindIP(ip string) ([]IPInventoryEntry, error) {
+	var entries []IPInventoryEntry
+	err := c.do(http.MethodGet, "/ips/"+url.PathEscape(ip), nil, &entries)
+	return entries, err
+}
+
+// Kick disconnects a connection from a VLAN and returns the number of MAC
+// entries flushed with it
+func (c *ControlClient) Kick(port int, connID string) (int, error) {
+	var result struct {
+		FlushedMACs int `json:"flushed_macs"`
+	}
+	err := c.do(http.MethodDelete, vlanPath(port)+"/connections/"+url.PathEscape(connID), nil, &result)
+	return result.FlushedMACs, err
+}
+
+// SetVLANDisabled shuts a VLAN down or brings it back up
+func (c *ControlClient) SetVLANDisabled(port int, disabled bool) error {
+	return c.do(http.MethodPost, vlanPath(port)+"/"+adminAction(disabled), nil, nil)
+}
+
+// SetConnectionDisabled shuts a connection down or brings it back up
+func (c *ControlClient) SetConnectionDisabled(port int, connID string, disabled bool) error {
+	return c.do(http.MethodPost, vlanPath(port)+"/connections/"+url.PathEscape(connID)+"/"+adminAction(disabled), nil, nil)
+}
+
+// SetVLANImpairment sets the impairment of the frames a VLAN sends; a zero
+// impairment removes it
+func (c *ControlClient) SetVLANImpairment(port int, impairment Impairment) error {
+	if impairment.IsZero() {
+		return c.do(http.MethodDelete, vlanPath(port)+"/impairment", nil, nil)
+	}
+	return c.do(http.MethodPut, vlanPath(port)+"/impairment", impairment, nil)
+}
+
+// SetConnectionImpairment gives a connection its own impairment, or with
+// nil makes it follow its VLAN's again
+func (c *ControlClient) SetConnectionImpairment(port int, connID string, impairment *Impairment) error {
+	path := vlanPath(port) + "/connections/" + url.PathEscape(connID) + "/impairment"
+	if impairment == nil {
+		return c.do(http.MethodDelete, path, nil, nil)
+	}
+	return c.do(http.MethodPut, path, impairment, nil)
+}
+
+// TagDomains returns the broadcast domains of a VLAN demultiplexing tags
+func (c *ControlClient) TagDomains(port int) ([]TagDomain, error) {
+	var domains []TagDomain
+	err := c.do(http.MethodGet, vlanPath(port)+"/vids", nil, &domains)
+	return domains, err
+}
+
+// Partitions returns the active partitions of a VLAN
+func (c *ControlClient) Partitions(port int) ([]Partition, error) {
+	var partitions []Partition
+	err := c.do(http.MethodGet, vlanPath(port)+"/partitions", nil, &partitions)
+	return partitions, err
+}
+
+// Partition blackholes the traffic between groups of a VLAN's connections,
+// for the given duration if it is positive, and returns the new partition
+func (c *ControlClient) Partition(port int, groups [][]string, duration time.Duration) (Partition, error) {
+	request := struct {
+		Groups   [][]string `json:"groups"`
+		Duration string     `json:"duration,omitempty"`
+	}{Groups: groups}
+	if duration > 0 {
+		request.Duration = duration.String()
+	}
+	var partition Partition
+	err := c.do(http.MethodPost, vlanPath(port)+"/partitions", request, &partition)
+	return partition, err
+}
+
+// Heal removes a partition of a VLAN, or all of them if id is "", and
+// returns the partitions removed
+func (c *ControlClient) Heal(port int, id string) ([]Partition, error) {
+	path := vlanPath(port) + "/partitions"
+	if id != "" {
+		path += "/" + url.PathEscape(id)
+	}
+	var healed []Partition
+	err := c.do(http.MethodDelete, path, nil, &healed)
+	return healed, err
+}
+
+// Bridges returns the bridges between VLANs
+func (c *ControlClient) Bridges() ([]Bridge, error) {
+	var bridges []Bridge
+	err := c.do(http.MethodGet, "/bridges", nil, &bridges)
+	return bridges, err
+}
+
+// AddBridge bridges two VLANs for the frames the rules select and returns
+// the new bridge
+func (c *ControlClient) AddBridge(ports [2]int, rules []BridgeRule) (Bridge, error) {
+	request := struct {
+		VLANs [2]int       `json:"vlans"`
+		Rules []BridgeRule `json:"rules"`
+	}{ports, rules}
+	var bridge Bridge
+	err := c.do(http.MethodPost, "/bridges", request, &bridge)
+	return bridge, err
+}
+
+// RemoveBridge removes a bridge and returns it
+func (c *ControlClient) RemoveBridge(id string) (Bridge, error) {
+	var bridge Bridge
+	err := c.do(http.MethodDelete, "/bridges/"+url.PathEscape(id), nil, &bridge)
+	return bridge, err
+}
+
+// ResetStats zeroes the statistics of all VLANs and returns when
+func (c *ControlClient) ResetStats() (time.Time, error) {
+	return c.resetStats("/stats/reset")
+}
+
+// ResetVLANStats zeroes the statistics of one VLAN and its connections and
+// returns when
+func (c *ControlClient) ResetVLANStats(port int) (time.Time, error) {
+	return c.resetStats(vlanPath(port) + "/stats/reset")
+}
+
+// ResetConnectionStats zeroes the statistics of a connection and returns
+// when
+func (c *ControlClient) ResetConnectionStats(port int, connID string) (time.Time, error) {
+	return c.resetStats(vlanPath(port) + "/connections/" + url.PathEscape(connID) + "/stats/reset")
+}
+
+// resetStats posts a statistics reset and returns its time
+func (c *ControlClient) resetStats(path string) (time.Time, error) {
+	var result struct {
+		ResetAt time.Time `json:"stats_reset_at"`
+	}
+	err := c.do(http.MethodPost, path, nil, &result)
+	return result.ResetAt, err
+}
+
+// adminAction returns the API action setting an admin state
+func adminAction(disabled bool) string {
+	if disabled {
+		return "disable"
+	}
+	return "enable"
+}
+
+// Capture streams the frames of one VLAN to w in pcap format until ctx is
+// done or the switch closes the stream
+func (c *ControlClient) Capture(ctx context.Context, port int, w io.Writer) error {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://vswitch"+vlanPath(port)+"/capture", nil)
+	if err != nil {
+		return err
+	}
+
+	// The stream runs until cancelled, so it must not time out
+	streaming := *c.client
+	streaming.Timeout = 0
+
+	response, err := streaming.Do(request)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = response.Body.Close() }()
+
+	if response.StatusCode >= http.StatusBadRequest {
+		return decodeAPIError(response)
+	}
+
+	if _, err := io.Copy(w, response.Body); err != nil && ctx.Err() == nil {
+		return err
+	}
+	return nil
+}
+
+// Replay injects the frames of the pcap file read from r into a VLAN at
+// the given speed, 1 keeping their original timing and 0 sending them as
+// fast as possible
+func (c *ControlClient) Replay(ctx context.Context, port int, r io.Reader, speed float64) (ReplayResult, error) {
+	var result ReplayResult
+	path := vlanPath(port) + "/replay?speed=" + strconv.FormatFloat(speed, 'g', -1, 64)
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, "http://vswitch"+path, r)
+	if err != nil {
+		return result, err
+	}
+	request.Header.Set("Content-Type", "application/vnd.tcpdump.pcap")
+
+	// A replay at the original timing lasts as long as the capture
+	streaming := *c.client
+	streaming.Timeout = 0
+
+	response, err := streaming.Do(request)
+	if err != nil {
+		return result, err
+	}
+	defer func() { _ = response.Body.Close() }()
+
+	if response.StatusCode >= http.StatusBadRequest {
+		return result, decodeAPIError(response)
+	}
+	return result, json.NewDecoder(response.Body).Decode(&result)
+}
+
+// Export returns the declarative snapshot of the switch's VLANs
+func (c *ControlClient) Export() (Snapshot, error) {
+	var snapshot Snapshot
+	err := c.do(http.MethodGet, "/config", nil, &snapshot)
+	return snapshot, err
+}
+
+// Import starts the VLANs of a snapshot that do not exist and applies the
+// rest of its state
+func (c *ControlClient) Import(snapshot Snapshot) (ImportResult, error) {
+	var result ImportResult
+	err := c.do(http.MethodPost, "/config", snapshot, &result)
+	return result, err
+}
+
+// Reconcile brings the switch to a desired state and returns the actions
+// taken, or with dryRun those it would take
+func (c *ControlClient) Reconcile(desired Snapshot, dryRun bool) ([]ReconcileAction, error) {
+	var result struct {
+		Actions []ReconcileAction `json:"actions"`
+	}
+	err := c.do(http.MethodPut, "/config?dry_run="+strconv.FormatBool(dryRun), desired, &result)
+	return result.Actions, err
+}
+
+// AddVLAN creates and starts a VLAN on a port
+func (c *ControlClient) AddVLAN(port int) error {
+	return c.do(http.MethodPost, "/vlans", map[string]int{"port": port}, nil)
+}
+
+// RemoveVLAN stops and removes a VLAN
+func (c *ControlClient) RemoveVLAN(port int) error {
+	return c.do(http.MethodDelete, vlanPath(port), nil, nil)
+}
+
+// do sends a request with an optional JSON body and decodes the response
+// into result, turning API errors into Go errors
+func (c *ControlClient) do(method, path string, body, result interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	request, err := http.NewRequest(method, "http://vswitch"+path, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+
+	response, err := c.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = response.Body.Close() }()
+
+	if response.StatusCode >= http.StatusBadRequest {
+		return decodeAPIError(response)
+	}
+
+	if result == nil {
+		return nil
+	}
+	return json.NewDecoder(response.Body).Decode(result)
+}
+
+// decodeAPIError turns an API error response into a Go error
+func decodeAPIError(response *http.Response) error {
+	var apiErr struct {
+		Error string `json:"error"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&apiErr); err != nil || apiErr.Error == "" {
+		return fmt.Errorf("request failed: %s", response.Status)
+	}
+	return fmt.Errorf("%s", apiErr.Error)
+}
+
+// vlanPath returns the API path of a VLAN
+func vlanPath(port int) string {
+	return "/vlans/" + strconv.Itoa(port)
+}
@@ -0,0 +1,138 @@
+package vswitch
+
+import (
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// controlSocketPath returns a socket path short enough for sun_path
+func controlSocketPath(t *testing.T) string {
+	t.Helper()
+
+	dir, err := os.MkdirTemp("", "vswitch")
+	if err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+
+	return filepath.Join(dir, "ctl.sock")
+}
+
+func TestListenControlSocket(t *testing.T) {
+	path := controlSocketPath(t)
+
+	listener, err := ListenControlSocket(path)
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil || info.Mode().Perm() != 0o600 {
+		t.Errorf("Expected socket with mode 0600, got %v (%v)", info, err)
+	}
+
+	if _, err := ListenControlSocket(path); err == nil {
+		t.Errorf("Expected socket in use to be refused")
+	}
+	_ = listener.Close()
+
+	// A socket file left behind without a listener is replaced
+	stale, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		t.Fatalf("Failed to create stale socket: %v", err)
+	}
+	stale.SetUnlinkOnClose(false)
+	_ = stale.Close()
+
+	listener, err = ListenControlSocket(path)
+	if err != nil {
+		t.Fatalf("Expected stale socket to be replaced: %v", err)
+	}
+	_ = listener.Close()
+
+	file := filepath.Join(filepath.Dir(path), "file")
+	if err := os.WriteFile(file, nil, 0o644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if _, err := ListenControlSocket(file); err == nil {
+		t.Errorf("Expected regular file to be left alone")
+	}
+}
+
+func TestControlClient(t *testing.T) {
+	path := controlSocketPath(t)
+	listener, err := ListenControlSocket(path)
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+
+	sm := NewSwitchManager()
+	if err := sm.AddVLAN(8080); err != nil {
+		t.Fatalf("Failed to add VLAN: %v", err)
+	}
+	vs := sm.switches[8080]
+	conn := NewConnection("guest", &mockConnSwitch{addr: &mockAddrSwitch{network: "tcp", address: "127.0.0.1:9001"}})
+	vs.connections.Store("guest", conn)
+	vs.learnMAC(net.HardwareAddr{0x52, 0x54, 0x00, 0x00, 0x00, 0x01}, conn)
+
+	server := &http.Server{Handler: NewControlHandler(sm)}
+	go func() { _ = server.Serve(listener) }()
+	defer func() { _ = server.Close() }()
+
+	client := NewControlClient(path)
+
+	ports, err := client.VLANs()
+	if err != nil || len(ports) != 1 || ports[0] != 8080 {
+		t.Errorf("Unexpected VLANs %v (%v)", ports, err)
+	}
+
+	stats, err := client.VLANStats(8080)
+	if err != nil || stats["connections"] != float64(1) {
+		t.Errorf("Unexpected VLAN stats %v (%v)", stats, err)
+	}
+
+	connections, err := client.Connections(8080)
+	if err != nil || len(connections) != 1 || connections[0].ID != "guest" {
+		t.Errorf("Unexpected connections %+v (%v)", connections, err)
+	}
+
+	entries, err := client.MACTable(8080)
+	if err != nil || len(entries) != 1 || entries[0].MAC != "52:54:00:00:00:01" {
+		t.Errorf("Unexpected MAC table %+v (%v)", entries, err)
+	}
+	if entries, err := client.AllMACTables(); err != nil || len(entries) != 1 || entries[0].VLAN != 8080 {
+		t.Errorf("Unexpected MAC tables %+v (%v)", entries, err)
+	}
+
+	if err := client.SetConnectionDisabled(8080, "guest", true); err != nil || !conn.Disabled() {
+		t.Errorf("Failed to disable connection: %v", err)
+	}
+	if err := client.SetVLANDisabled(8080, true); err != nil || !vs.disabled.Load() {
+		t.Errorf("Failed to disable VLAN: %v", err)
+	}
+	if err := client.SetVLANDisabled(8080, false); err != nil || vs.disabled.Load() {
+		t.Errorf("Failed to enable VLAN: %v", err)
+	}
+	if err := client.SetConnectionDisabled(8080, "missing", true); err == nil {
+		t.Errorf("Expected error disabling a missing connection")
+	}
+
+	if flushed, err := client.Kick(8080, "guest"); err != nil || flushed != 1 {
+		t.Errorf("Failed to kick connection and flush its MAC: %d (%v)", flushed, err)
+	}
+	if _, err := client.Kick(8080, "missing"); err == nil || err.Error() != "connection missing not found" {
+		t.Errorf("Expected API error to be returned, got %v", err)
+	}
+	if _, err := client.MACTable(9999); err == nil {
+		t.Errorf("Expected error for unknown VLAN")
+	}
+
+	if err := client.RemoveVLAN(8080); err != nil {
+		t.Errorf("Failed to remove VLAN: %v", err)
+	}
+	if err := client.AddVLAN(0); err == nil {
+		t.Errorf("Expected invalid port to be rejected")
+	}
+}
@@ -0,0 +1,188 @@
+package vswitch
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+// countersCheckpointInterval is how often the counters of a VLAN are saved
+// when config.CountersDir is set
+const countersCheckpointInterval = time.Minute
+
+// counterCheckpoint is the aggregate counters of a VLAN as saved to disk,
+// by statistic name and, for counts by category, by category name, so that
+// checkpoints survive upgrades adding counters or categories
+type counterCheckpoint struct {
+	SavedAt      time.Time                    `json:"saved_at"`
+	StatsResetAt time.Time                    `json:"stats_reset_at"`
+	Counters     map[string]uint64            `json:"counters"`
+	Categories   map[string]map[string]uint64 `json:"categories"`
+}
+
+// counters returns the monotonic counters of the VLAN by statistic name
+func (vs *VirtualSwitch) counters() map[string]*atomic.Uint64 {
+	counters := map[string]*atomic.Uint64{
+		"total_frames":          &vs.totalFrames,
+		"broadcast_frames":      &vs.broadcastFrames,
+		"unicast_frames":        &vs.unicastFrames,
+		"dropped_frames":        &vs.droppedFrames,
+		"spoofed_frames":        &vs.spoofedFrames,
+		"isolated_frames":       &vs.isolatedFrames,
+		"horizon_drops":         &vs.horizonDrops,
+		"partition_drops":       &vs.partitionDrops,
+		"unknown_unicast_drops": &vs.unknownUnicastDrops,
+		"dhcp_drops":            &vs.dhcpDrops,
+		"nd_drops":              &vs.ndDrops,
+		"rejected_connections":  &vs.rejectedConns,
+		"disabled_drops":        &vs.disabledDrops,
+		"hook_drops":            &vs.hookDrops,
+		"validation_drops":      &vs.validationDrops,
+		"slow_consumers":        &vs.slowConsumers,
+		"mac_moves":             &vs.macMoves,
+		"mac_evictions":         &vs.macTable.evictions,
+		"bind_failures":         &vs.bindRetries.failures,
+	}
+	if vs.proxyARP != nil {
+		counters["proxy_arp_replies"] = &vs.proxyARP.replies
+	}
+	if vs.arpSuppressor != nil {
+		counters["arp_suppressed"] = &vs.arpSuppressor.suppressed
+	}
+	return counters
+}
+
+// categoryCounters returns the counts by category of the VLAN by statistic
+// name and category name
+func (vs *VirtualSwitch) categoryCounters() map[string]map[string]*atomic.Uint64 {
+	return map[string]map[string]*atomic.Uint64{
+		"drop_reasons":     namedCounters(dropReasonNames[:], vs.drops[:]),
+		"ethertype_frames": namedCounters(etherTypeClassNames[:], vs.etherTypeFrames[:]),
+		"ethertype_bytes":  namedCounters(etherTypeClassNames[:], vs.etherTypeBytes[:]),
+		"invalid_frames":   namedCounters(frameCheckNames[:], vs.invalidFrames[:]),
+		"bum_drops":        namedCounters(bumClassNames[:], vs.bumDrops[:]),
+	}
+}
+
+// namedCounters pairs counters with the names of their categories
+func namedCounters(names []string, counters []atomic.Uint64) map[string]*atomic.Uint64 {
+	named := make(map[string]*atomic.Uint64, len(names))
+	for i, name := range names {
+		named[name] = &counters[i]
+	}
+	return named
+}
+
+// countersPath returns the checkpoint file of the VLAN
+func (vs *VirtualSwitch) countersPath() string {
+	return filepath.Join(vs.config.CountersDir, fmt.Sprintf("vlan-%d-counters.json", vs.port()))
+}
+
+// loadCounters adds the checkpointed counters to those of the VLAN at
+// startup and takes over when they were last reset. A missing checkpoint is
+// not an error.
+func (vs *VirtualSwitch) loadCounters() {
+	if vs.config.CountersDir == "" {
+		return
+	}
+
+	path := vs.countersPath()
+	data, err := os.ReadFile(path) // #nosec G304 - path is in the configured counters directory
+	if os.IsNotExist(err) {
+		return
+	}
+	var checkpoint counterCheckpoint
+	if err == nil {
+		if err = json.Unmarshal(data, &checkpoint); err != nil {
+			err = fmt.Errorf("invalid counters file %s: %v", path, err)
+		}
+	}
+	if err != nil {
+		vs.logger.Warn("Failed to load counters", "error", err)
+		return
+	}
+
+	vs.statsMutex.Lock()
+	defer vs.statsMutex.Unlock()
+
+	for name, counter := range vs.counters() {
+		counter.Add(checkpoint.Counters[name])
+	}
+	for name, categories := range vs.categoryCounters() {
+		for category, counter := range categories {
+			counter.Add(checkpoint.Categories[name][category])
+		}
+	}
+	if !checkpoint.StatsResetAt.IsZero() {
+		vs.statsResetAt = checkpoint.StatsResetAt
+	}
+	vs.logger.Info("Loaded saved counters", "saved_at", checkpoint.SavedAt, "since", vs.statsResetAt)
+}
+
+// saveCounters checkpoints the counters of the VLAN. The file is replaced
+// atomically so a crash never leaves it truncated.
+func (vs *VirtualSwitch) saveCounters() {
+	if vs.config.CountersDir == "" {
+		return
+	}
+
+	vs.statsMutex.RLock()
+	checkpoint := counterCheckpoint{
+		SavedAt:      time.Now(),
+		StatsResetAt: vs.statsResetAt,
+		Counters:     make(map[string]uint64),
+		Categories:   make(map[string]map[string]uint64),
+	}
+	for name, counter := range vs.counters() {
+		checkpoint.Counters[name] = counter.Load()
+	}
+	for name, categories := range vs.categoryCounters() {
+		checkpoint.Categories[name] = make(map[string]uint64, len(categories))
+		for category, counter := range categories {
+			checkpoint.Categories[name][category] = counter.Load()
+		}
+	}
+	vs.statsMutex.RUnlock()
+
+	if err := writeCheckpoint(vs.countersPath(), checkpoint); err != nil {
+		vs.logger.Warn("Failed to save counters", "error", err)
+	}
+}
+
+// writeCheckpoint writes a checkpoint through a temporary file
+func writeCheckpoint(path string, checkpoint counterCheckpoint) error {
+	data, err := json.MarshalIndent(checkpoint, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// checkpointCounters saves the counters of the VLAN periodically until the
+// switch stops, which saves them a last time
+func (vs *VirtualSwitch) checkpointCounters() {
+	defer vs.wg.Done()
+
+	ticker := time.NewTicker(countersCheckpointInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-vs.shutdown:
+			return
+		case <-ticker.C:
+			vs.saveCounters()
+		}
+	}
+}
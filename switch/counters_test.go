@@ -0,0 +1,60 @@
+package vswitch
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCountersPersist(t *testing.T) {
+	dir := t.TempDir()
+	config := Config{InMemory: true, CountersDir: dir}
+
+	sw := NewVirtualSwitchWithConfig([]int{9999}, config)
+	if err := sw.Start(context.Background()); err != nil {
+		t.Fatalf("Failed to start: %v", err)
+	}
+	resetAt := sw.ResetStats()
+	sw.totalFrames.Add(5)
+	sw.etherTypeBytes[etherTypeIPv4].Add(1500)
+	sw.drops[dropQueueOverflow].Add(2)
+	sw.Stop()
+
+	if _, err := os.Stat(filepath.Join(dir, "vlan-9999-counters.json")); err != nil {
+		t.Fatalf("Expected the counters to be saved on shutdown: %v", err)
+	}
+
+	// The next run carries on from the saved counters
+	restarted := NewVirtualSwitchWithConfig([]int{9999}, config)
+	if err := restarted.Start(context.Background()); err != nil {
+		t.Fatalf("Failed to restart: %v", err)
+	}
+	restarted.totalFrames.Add(1)
+	stats := restarted.GetStats()
+	restarted.Stop()
+
+	if frames := stats["total_frames"].(uint64); frames != 6 {
+		t.Errorf("Expected the saved frames to be added back, got %d", frames)
+	}
+	if bytes := stats["ethertype_bytes"].(map[string]uint64)["ipv4"]; bytes != 1500 {
+		t.Errorf("Expected the saved bytes by EtherType, got %d", bytes)
+	}
+	if drops := stats["drop_reasons"].(map[string]uint64)[dropReasonNames[dropQueueOverflow]]; drops != 2 {
+		t.Errorf("Expected the saved drops by reason, got %d", drops)
+	}
+	if !stats["stats_reset_at"].(time.Time).Equal(resetAt) {
+		t.Errorf("Expected the counting period to carry over, got %v", stats["stats_reset_at"])
+	}
+
+	// A corrupt checkpoint is ignored
+	if err := os.WriteFile(filepath.Join(dir, "vlan-9999-counters.json"), []byte("{"), 0600); err != nil {
+		t.Fatalf("Failed to corrupt the checkpoint: %v", err)
+	}
+	fresh := NewVirtualSwitchWithConfig([]int{9999}, config)
+	fresh.loadCounters()
+	if frames := fresh.GetStats()["total_frames"].(uint64); frames != 0 {
+		t.Errorf("Expected a corrupt checkpoint to be ignored, got %d frames", frames)
+	}
+}
@@ -1,20 +1,37 @@
 package vswitch
 
 import (
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strconv"
 	"strings"
 	"syscall"
+	"time"
 )
 
-// DaemonManager handles daemonization and PID file management
+const (
+	// daemonEnv is set in the environment of a process started by Daemonize
+	daemonEnv = "VSWITCH_DAEMONIZED"
+
+	// daemonStartTimeout is how long Daemonize waits for the daemon to lock
+	// its PID file
+	daemonStartTimeout = 10 * time.Second
+)
+
+// DaemonManager handles daemonization and PID file management. The daemon
+// holds an exclusive flock on its PID file for as long as it runs, so a
+// stale PID file never makes an unrelated process look like the daemon.
 type DaemonManager struct {
+	// Logger receives the manager's log records; nil discards them
+	Logger *slog.Logger
+
 	pidFile string
 	logFile string
+	lock    *os.File // PID file locked by this process
 }
 
 // NewDaemonManager creates a new daemon manager
@@ -25,8 +42,17 @@ func NewDaemonManager(pidFile, logFile string) *DaemonManager {
 	}
 }
 
+// logger returns the manager's logger
+func (dm *DaemonManager) logger() *slog.Logger {
+	return loggerOrDiscard(dm.Logger)
+}
+
 // Daemonize starts the process as a daemon
 func (dm *DaemonManager) Daemonize(args []string) error {
+	if !daemonSupported {
+		return errDaemonUnsupported
+	}
+
 	// Check if already running
 	if dm.IsRunning() {
 		return fmt.Errorf("daemon already running (PID file: %s)", dm.pidFile)
@@ -62,7 +88,7 @@ func (dm *DaemonManager) Daemonize(args []string) error {
 	cmd := exec.Command(args[0], args[1:]...)
 
 	// Set up environment for daemon
-	cmd.Env = os.Environ()
+	cmd.Env = append(os.Environ(), daemonEnv+"=1")
 
 	// Redirect output to log file if specified
 	if dm.logFile != "" {
@@ -86,19 +112,117 @@ func (dm *DaemonManager) Daemonize(args []string) error {
 		return fmt.Errorf("failed to start daemon: %v", err)
 	}
 
-	// Write PID file
-	if err := dm.writePIDFile(cmd.Process.Pid); err != nil {
-		// Kill the process if we can't write PID file
-		_ = cmd.Process.Kill()
-		return fmt.Errorf("failed to write PID file: %v", err)
+	// The daemon writes the PID file itself once it holds the lock
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+	deadline := time.NewTimer(daemonStartTimeout)
+	defer deadline.Stop()
+	for !dm.IsRunning() {
+		select {
+		case err := <-exited:
+			return fmt.Errorf("daemon exited during startup: %v", err)
+		case <-deadline.C:
+			_ = cmd.Process.Kill()
+			return fmt.Errorf("daemon did not lock its PID file within %v", daemonStartTimeout)
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+
+	dm.logger().Info("Daemon started", "pid", cmd.Process.Pid)
+	return nil
+}
+
+// Daemonized reports whether the process was started by Daemonize
+func Daemonized() bool {
+	return os.Getenv(daemonEnv) != ""
+}
+
+// Lock takes an exclusive lock on the PID file, held until Cleanup or the
+// process exits, and records this process in it. It fails when another
+// daemon holds the lock. A process started by Upgrade takes over the lock
+// of its predecessor.
+func (dm *DaemonManager) Lock() error {
+	key := "pidfile/" + dm.pidFile
+	file := sockets.take(key)
+	if file == nil {
+		if err := os.MkdirAll(filepath.Dir(dm.pidFile), 0750); err != nil {
+			return err
+		}
+		var err error
+		if file, err = dm.lockPIDFile(); err != nil {
+			return err
+		}
+	}
+
+	if err := file.Truncate(0); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if _, err := file.WriteAt([]byte(strconv.Itoa(os.Getpid())), 0); err != nil {
+		_ = file.Close()
+		return err
 	}
 
-	log.Printf("Daemon started with PID %d", cmd.Process.Pid)
+	dm.lock = file
+	sockets.register(key, pidFileLock{file})
 	return nil
 }
 
-// Stop stops the daemon process
+// lockPIDFile opens and locks the PID file. A daemon shutting down unlinks
+// the file before releasing its lock, so a file opened just before that is
+// locked once nobody can find it anymore; such a lock is dropped and the
+// file at the path opened again.
+func (dm *DaemonManager) lockPIDFile() (*os.File, error) {
+	for {
+		file, err := os.OpenFile(dm.pidFile, os.O_RDWR|os.O_CREATE, 0600)
+		if err != nil {
+			return nil, err
+		}
+		if err := lockFile(file); err != nil {
+			_ = file.Close()
+			if errors.Is(err, errLocked) {
+				return nil, fmt.Errorf("daemon already running (PID file: %s)", dm.pidFile)
+			}
+			return nil, fmt.Errorf("failed to lock PID file: %v", err)
+		}
+		if isFileAt(file, dm.pidFile) {
+			return file, nil
+		}
+		_ = file.Close()
+	}
+}
+
+// isFileAt reports whether an open file is still the one at path
+func isFileAt(file *os.File, path string) bool {
+	info, err := file.Stat()
+	if err != nil {
+		return false
+	}
+	current, err := os.Stat(path)
+	return err == nil && os.SameFile(info, current)
+}
+
+// pidFileLock passes a locked PID file to an upgraded process. The copy
+// shares the lock, so the PID file stays locked throughout the upgrade.
+type pidFileLock struct {
+	file *os.File
+}
+
+// File returns a duplicate of the locked file
+func (l pidFileLock) File() (*os.File, error) {
+	return dupFile(l.file)
+}
+
+// Close closes the locked file
+func (l pidFileLock) Close() error {
+	return l.file.Close()
+}
+
+// Stop stops the daemon process, which removes its PID file as it exits
 func (dm *DaemonManager) Stop() error {
+	if !dm.IsRunning() {
+		return fmt.Errorf("no daemon holds the lock on %s", dm.pidFile)
+	}
 	pid, err := dm.readPIDFile()
 	if err != nil {
 		return fmt.Errorf("failed to read PID file: %v", err)
@@ -115,31 +239,20 @@ func (dm *DaemonManager) Stop() error {
 		return fmt.Errorf("failed to send SIGTERM to process %d: %v", pid, err)
 	}
 
-	// Clean up PID file
-	if err := os.Remove(dm.pidFile); err != nil {
-		log.Printf("Warning: failed to remove PID file: %v", err)
-	}
-
-	log.Printf("Daemon stopped (PID %d)", pid)
+	dm.logger().Info("Daemon stopped", "pid", pid)
 	return nil
 }
 
-// IsRunning checks if the daemon is currently running
+// IsRunning checks if the daemon is currently running, that is whether a
+// process holds the lock on the PID file
 func (dm *DaemonManager) IsRunning() bool {
-	pid, err := dm.readPIDFile()
+	file, err := os.Open(dm.pidFile)
 	if err != nil {
 		return false
 	}
+	defer func() { _ = file.Close() }()
 
-	// Check if process exists
-	process, err := os.FindProcess(pid)
-	if err != nil {
-		return false
-	}
-
-	// Send signal 0 to test if process is alive
-	err = process.Signal(syscall.Signal(0))
-	return err == nil
+	return fileLocked(file)
 }
 
 // GetPID returns the PID of the running daemon, or -1 if not running
@@ -174,9 +287,26 @@ func (dm *DaemonManager) readPIDFile() (int, error) {
 	return strconv.Atoi(pidStr)
 }
 
-// Cleanup removes the PID file (called on daemon shutdown)
+// UpdatePIDFile records another process, such as the one an upgrade handed
+// over to, in the PID file. It does nothing unless the file names this
+// process.
+func (dm *DaemonManager) UpdatePIDFile(pid int) error {
+	current, err := dm.readPIDFile()
+	if err != nil || current != os.Getpid() {
+		return nil
+	}
+	return dm.writePIDFile(pid)
+}
+
+// Cleanup removes the PID file and releases its lock (called on daemon
+// shutdown). It does nothing unless this process locked the PID file.
 func (dm *DaemonManager) Cleanup() {
+	if dm.lock == nil {
+		return
+	}
 	if err := os.Remove(dm.pidFile); err != nil {
-		log.Printf("Warning: failed to remove PID file: %v", err)
+		dm.logger().Warn("Failed to remove PID file", "path", dm.pidFile, "error", err)
 	}
+	_ = dm.lock.Close()
+	dm.lock = nil
 }
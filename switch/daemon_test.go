@@ -23,6 +23,10 @@ func TestNewDaemonManager(t *testing.T) {
 }
 
 func TestDaemonManagerIsRunning(t *testing.T) {
+	if !daemonSupported {
+		t.Skip("PID files are not locked without daemon support")
+	}
+
 	// Create temporary PID file
 	tmpDir, err := os.MkdirTemp("", "daemon_test")
 	if err != nil {
@@ -38,25 +42,37 @@ func TestDaemonManagerIsRunning(t *testing.T) {
 		t.Errorf("Expected daemon to not be running initially")
 	}
 
-	// Write current process PID (should be running)
-	currentPID := os.Getpid()
-	err = os.WriteFile(pidFile, []byte(strconv.Itoa(currentPID)), 0644)
+	// A stale PID file naming a live process is not a running daemon
+	err = os.WriteFile(pidFile, []byte(strconv.Itoa(os.Getpid())), 0644)
 	if err != nil {
 		t.Fatalf("Failed to write PID file: %v", err)
 	}
 
+	if dm.IsRunning() {
+		t.Errorf("Expected daemon to not be running without the lock")
+	}
+
+	// Lock the PID file (should be running)
+	daemon := NewDaemonManager(pidFile, "")
+	if err := daemon.Lock(); err != nil {
+		t.Fatalf("Failed to lock PID file: %v", err)
+	}
+
 	if !dm.IsRunning() {
-		t.Errorf("Expected daemon to be running with current PID")
+		t.Errorf("Expected daemon to be running while the PID file is locked")
+	}
+	if pid := dm.GetPID(); pid != os.Getpid() {
+		t.Errorf("Expected PID %d, got %d", os.Getpid(), pid)
 	}
 
-	// Write invalid PID (should not be running)
-	err = os.WriteFile(pidFile, []byte("99999999"), 0644)
-	if err != nil {
-		t.Fatalf("Failed to write invalid PID file: %v", err)
+	// A second daemon cannot take the lock
+	if err := NewDaemonManager(pidFile, "").Lock(); err == nil {
+		t.Errorf("Expected a second lock to fail")
 	}
 
+	daemon.Cleanup()
 	if dm.IsRunning() {
-		t.Errorf("Expected daemon to not be running with invalid PID")
+		t.Errorf("Expected daemon to not be running after cleanup")
 	}
 }
 
@@ -126,6 +142,10 @@ func TestDaemonManagerWriteReadPIDFile(t *testing.T) {
 }
 
 func TestDaemonManagerCleanup(t *testing.T) {
+	if !daemonSupported {
+		t.Skip("PID files are not locked without daemon support")
+	}
+
 	// Create temporary PID file
 	tmpDir, err := os.MkdirTemp("", "daemon_test")
 	if err != nil {
@@ -136,11 +156,20 @@ func TestDaemonManagerCleanup(t *testing.T) {
 	pidFile := filepath.Join(tmpDir, "test.pid")
 	dm := NewDaemonManager(pidFile, "")
 
-	// Write PID file
+	// A PID file this process did not lock is left alone
 	err = dm.writePIDFile(12345)
 	if err != nil {
 		t.Fatalf("Failed to write PID file: %v", err)
 	}
+	dm.Cleanup()
+	if _, err := os.Stat(pidFile); err != nil {
+		t.Errorf("Expected PID file of another process to be kept")
+	}
+
+	// Lock PID file
+	if err := dm.Lock(); err != nil {
+		t.Fatalf("Failed to lock PID file: %v", err)
+	}
 
 	// Verify file exists
 	if _, err := os.Stat(pidFile); os.IsNotExist(err) {
@@ -156,6 +185,43 @@ func TestDaemonManagerCleanup(t *testing.T) {
 	}
 }
 
+func TestDaemonManagerLockAfterUnlink(t *testing.T) {
+	if !daemonSupported {
+		t.Skip("PID files are not locked without daemon support")
+	}
+
+	pidFile := filepath.Join(t.TempDir(), "test.pid")
+	previous := NewDaemonManager(pidFile, "")
+	if err := previous.Lock(); err != nil {
+		t.Fatalf("Failed to lock PID file: %v", err)
+	}
+
+	// A process that opened the PID file just before the previous daemon
+	// removed it locks a file that is no longer there
+	stale, err := os.Open(pidFile)
+	if err != nil {
+		t.Fatalf("Failed to open PID file: %v", err)
+	}
+	defer func() { _ = stale.Close() }()
+	previous.Cleanup()
+	if err := lockFile(stale); err != nil {
+		t.Fatalf("Failed to lock the unlinked file: %v", err)
+	}
+	if isFileAt(stale, pidFile) {
+		t.Errorf("Expected the unlinked file not to count as the PID file")
+	}
+
+	// Which does not keep the next daemon from locking the PID file
+	dm := NewDaemonManager(pidFile, "")
+	if err := dm.Lock(); err != nil {
+		t.Fatalf("Failed to lock PID file: %v", err)
+	}
+	defer dm.Cleanup()
+	if !dm.IsRunning() || !isFileAt(dm.lock, pidFile) {
+		t.Errorf("Expected the daemon to hold the lock on the PID file")
+	}
+}
+
 func TestDaemonManagerWritePIDFileCreatesDirectory(t *testing.T) {
 	// Create temporary directory
 	tmpDir, err := os.MkdirTemp("", "daemon_test")
@@ -0,0 +1,41 @@
+//go:build !windows
+
+package vswitch
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// daemonSupported reports whether Daemonize can start a daemon
+const daemonSupported = true
+
+// errDaemonUnsupported is returned by Daemonize where daemons are not supported
+var errDaemonUnsupported = errors.New("daemon mode is not supported")
+
+// errLocked is returned by lockFile when another process holds the lock
+var errLocked = syscall.EWOULDBLOCK
+
+// lockFile takes an exclusive lock on a file without waiting for it
+func lockFile(file *os.File) error {
+	return syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+}
+
+// fileLocked reports whether a process holds an exclusive lock on a file
+func fileLocked(file *os.File) bool {
+	err := syscall.Flock(int(file.Fd()), syscall.LOCK_SH|syscall.LOCK_NB)
+	if err == nil {
+		_ = syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+	}
+	return errors.Is(err, syscall.EWOULDBLOCK)
+}
+
+// dupFile duplicates a file descriptor, sharing its lock
+func dupFile(file *os.File) (*os.File, error) {
+	fd, err := syscall.Dup(int(file.Fd()))
+	if err != nil {
+		return nil, err
+	}
+	return os.NewFile(uintptr(fd), file.Name()), nil
+}
@@ -0,0 +1,31 @@
+package vswitch
+
+import (
+	"errors"
+	"os"
+)
+
+// daemonSupported reports whether Daemonize can start a daemon. Windows
+// has no detached daemons; run the switch in the foreground or as a service.
+const daemonSupported = false
+
+// errDaemonUnsupported is returned by Daemonize where daemons are not supported
+var errDaemonUnsupported = errors.New("daemon mode is not supported on Windows; run vswitch in the foreground or as a service")
+
+// errLocked is returned by lockFile when another process holds the lock
+var errLocked = errors.New("file is locked")
+
+// lockFile fails, as there are no daemons holding PID file locks
+func lockFile(_ *os.File) error {
+	return errDaemonUnsupported
+}
+
+// fileLocked reports false, as no daemon can hold the lock
+func fileLocked(_ *os.File) bool {
+	return false
+}
+
+// dupFile fails, as sockets are not handed over on Windows
+func dupFile(_ *os.File) (*os.File, error) {
+	return nil, errors.ErrUnsupported
+}
@@ -0,0 +1,207 @@
+package vswitch
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DHCP (BOOTP) UDP ports
+const (
+	dhcpServerPort = 67
+	dhcpClientPort = 68
+)
+
+// BOOTP operation codes
+const (
+	bootRequest = 1
+	bootReply   = 2
+)
+
+// DHCP message types (option 53)
+const (
+	dhcpDiscover = 1
+	dhcpOffer    = 2
+	dhcpRequest  = 3
+	dhcpDecline  = 4
+	dhcpAck      = 5
+	dhcpNak      = 6
+	dhcpRelease  = 7
+	dhcpInform   = 8
+)
+
+// DHCP option codes
+const (
+	dhcpOptPad         = 0
+	dhcpOptSubnetMask  = 1
+	dhcpOptRouter      = 3
+	dhcpOptDNS         = 6
+	dhcpOptRequestedIP = 50
+	dhcpOptLeaseTime   = 51
+	dhcpOptMessageType = 53
+	dhcpOptServerID    = 54
+	dhcpOptRenewalTime = 58
+	dhcpOptRebindTime  = 59
+	dhcpOptTFTPServer  = 66
+	dhcpOptBootFile    = 67
+	dhcpOptClientArch  = 93
+	dhcpOptEnd         = 255
+)
+
+// dhcpMagicCookie marks the start of the DHCP options field
+var dhcpMagicCookie = []byte{99, 130, 83, 99}
+
+// dhcpMessage is a parsed DHCP message
+type dhcpMessage struct {
+	Op      uint8
+	XID     uint32
+	Flags   uint16
+	CIAddr  net.IP
+	YIAddr  net.IP
+	SIAddr  net.IP
+	GIAddr  net.IP
+	CHAddr  net.HardwareAddr
+	File    string // Boot file name
+	Options map[uint8][]byte
+}
+
+// parseDHCP parses a DHCP message from a UDP payload
+func parseDHCP(data []byte) (*dhcpMessage, error) {
+	if len(data) < 240 {
+		return nil, fmt.Errorf("DHCP message too short: %d bytes", len(data))
+	}
+
+	if string(data[236:240]) != string(dhcpMagicCookie) {
+		return nil, fmt.Errorf("missing DHCP magic cookie")
+	}
+
+	hlen := int(data[2])
+	if hlen > 16 {
+		return nil, fmt.Errorf("invalid hardware address length %d", hlen)
+	}
+
+	msg := &dhcpMessage{
+		Op:      data[0],
+		XID:     binary.BigEndian.Uint32(data[4:8]),
+		Flags:   binary.BigEndian.Uint16(data[10:12]),
+		CIAddr:  net.IP(data[12:16]),
+		YIAddr:  net.IP(data[16:20]),
+		SIAddr:  net.IP(data[20:24]),
+		GIAddr:  net.IP(data[24:28]),
+		CHAddr:  net.HardwareAddr(data[28 : 28+hlen]),
+		File:    strings.TrimRight(string(data[108:236]), "\x00"),
+		Options: make(map[uint8][]byte),
+	}
+
+	options := data[240:]
+	for len(options) > 0 {
+		code := options[0]
+		if code == dhcpOptEnd {
+			break
+		}
+		if code == dhcpOptPad {
+			options = options[1:]
+			continue
+		}
+		if len(options) < 2 || len(options) < 2+int(options[1]) {
+			return nil, fmt.Errorf("truncated DHCP option %d", code)
+		}
+		length := int(options[1])
+		msg.Options[code] = append(msg.Options[code], options[2:2+length]...)
+		options = options[2+length:]
+	}
+
+	return msg, nil
+}
+
+// marshal serializes the message with its options in ascending code order
+func (m *dhcpMessage) marshal() []byte {
+	data := make([]byte, 240, 300)
+	data[0] = m.Op
+	data[1] = 1 // Ethernet
+	data[2] = byte(len(m.CHAddr))
+	binary.BigEndian.PutUint32(data[4:8], m.XID)
+	binary.BigEndian.PutUint16(data[10:12], m.Flags)
+	copy(data[12:16], m.CIAddr.To4())
+	copy(data[16:20], m.YIAddr.To4())
+	copy(data[20:24], m.SIAddr.To4())
+	copy(data[24:28], m.GIAddr.To4())
+	copy(data[28:44], m.CHAddr)
+	copy(data[108:235], m.File)
+	copy(data[236:240], dhcpMagicCookie)
+
+	codes := make([]int, 0, len(m.Options))
+	for code := range m.Options {
+		codes = append(codes, int(code))
+	}
+	sort.Ints(codes)
+
+	for _, code := range codes {
+		value := m.Options[uint8(code)]
+		// Long options are split across several instances (RFC 3396)
+		for len(value) > 255 {
+			data = append(data, uint8(code), 255)
+			data = append(data, value[:255]...)
+			value = value[255:]
+		}
+		data = append(data, uint8(code), byte(len(value)))
+		data = append(data, value...)
+	}
+	data = append(data, dhcpOptEnd)
+
+	// Pad to the minimum BOOTP message size
+	for len(data) < 300 {
+		data = append(data, dhcpOptPad)
+	}
+
+	return data
+}
+
+// messageType returns the DHCP message type, or 0 for plain BOOTP
+func (m *dhcpMessage) messageType() uint8 {
+	if value := m.Options[dhcpOptMessageType]; len(value) == 1 {
+		return value[0]
+	}
+	return 0
+}
+
+// leaseTime returns the lease duration granted in the message
+func (m *dhcpMessage) leaseTime() time.Duration {
+	if value := m.Options[dhcpOptLeaseTime]; len(value) == 4 {
+		return time.Duration(binary.BigEndian.Uint32(value)) * time.Second
+	}
+	return 0
+}
+
+// dhcpFromFrame extracts a DHCP message from an Ethernet frame, returning
+// nil if the frame does not carry one
+func dhcpFromFrame(frame *EthernetFrame) (*dhcpMessage, *udpDatagram) {
+	if frame.EtherType != EtherTypeIPv4 {
+		return nil, nil
+	}
+
+	ip, err := parseIPv4(frame.Payload)
+	if err != nil || ip.Protocol != ipProtoUDP || ip.FragmentOffset != 0 {
+		return nil, nil
+	}
+
+	udp, err := parseUDP(ip.Payload)
+	if err != nil {
+		return nil, nil
+	}
+
+	if udp.SrcPort != dhcpServerPort && udp.SrcPort != dhcpClientPort &&
+		udp.DstPort != dhcpServerPort && udp.DstPort != dhcpClientPort {
+		return nil, nil
+	}
+
+	msg, err := parseDHCP(udp.Payload)
+	if err != nil {
+		return nil, udp
+	}
+
+	return msg, udp
+}
@@ -0,0 +1,66 @@
+package vswitch
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestDHCPMarshalParse(t *testing.T) {
+	msg := &dhcpMessage{
+		Op:     bootReply,
+		XID:    0x12345678,
+		CIAddr: net.IPv4zero,
+		YIAddr: net.ParseIP("10.0.0.50"),
+		SIAddr: net.ParseIP("10.0.0.1"),
+		GIAddr: net.IPv4zero,
+		CHAddr: net.HardwareAddr{0x52, 0x54, 0x00, 0x12, 0x34, 0x56},
+		Options: map[uint8][]byte{
+			dhcpOptMessageType: {dhcpAck},
+			dhcpOptLeaseTime:   {0, 0, 0x0e, 0x10},
+		},
+	}
+
+	parsed, err := parseDHCP(msg.marshal())
+	if err != nil {
+		t.Fatalf("Unexpected error parsing DHCP message: %v", err)
+	}
+
+	if parsed.Op != bootReply || parsed.XID != msg.XID {
+		t.Errorf("Unexpected op %d or xid 0x%x", parsed.Op, parsed.XID)
+	}
+
+	if !parsed.YIAddr.Equal(msg.YIAddr) || !parsed.SIAddr.Equal(msg.SIAddr) {
+		t.Errorf("Unexpected addresses yiaddr=%s siaddr=%s", parsed.YIAddr, parsed.SIAddr)
+	}
+
+	if parsed.CHAddr.String() != msg.CHAddr.String() {
+		t.Errorf("Expected chaddr %s, got %s", msg.CHAddr, parsed.CHAddr)
+	}
+
+	if parsed.messageType() != dhcpAck {
+		t.Errorf("Expected ACK, got message type %d", parsed.messageType())
+	}
+
+	if parsed.leaseTime() != time.Hour {
+		t.Errorf("Expected 1h lease, got %v", parsed.leaseTime())
+	}
+}
+
+func TestParseDHCPErrors(t *testing.T) {
+	if _, err := parseDHCP(make([]byte, 100)); err == nil {
+		t.Errorf("Expected error for short message")
+	}
+
+	if _, err := parseDHCP(make([]byte, 300)); err == nil {
+		t.Errorf("Expected error for missing magic cookie")
+	}
+
+	data := make([]byte, 243)
+	copy(data[236:240], dhcpMagicCookie)
+	data[240] = dhcpOptMessageType
+	data[241] = 5 // Claims more bytes than remain
+	if _, err := parseDHCP(data); err == nil {
+		t.Errorf("Expected error for truncated option")
+	}
+}
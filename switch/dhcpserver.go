@@ -0,0 +1,346 @@
+package vswitch
+
+import (
+	"encoding/binary"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// dhcpDefaultLeaseTime is used when no lease time is configured
+	dhcpDefaultLeaseTime = time.Hour
+
+	// dhcpOfferTimeout is how long an offered address stays reserved
+	dhcpOfferTimeout = 30 * time.Second
+
+	// dhcpBroadcastFlag asks the server to broadcast its replies
+	dhcpBroadcastFlag = 0x8000
+)
+
+// dhcpLease is an address assigned or offered to a client
+type dhcpLease struct {
+	ip      uint32
+	mac     string // Empty for addresses declined by a client
+	expires time.Time
+}
+
+// dhcpServer hands out IPv4 addresses from a pool, along with the router,
+// DNS and PXE boot options of the VLAN
+type dhcpServer struct {
+	vs     *VirtualSwitch
+	stack  *hostStack
+	config DHCPServerConfig
+	start  uint32
+	end    uint32
+
+	mutex  sync.Mutex
+	leases map[uint32]*dhcpLease // IP -> lease
+	byMAC  map[string]*dhcpLease
+}
+
+// ipToUint32 converts an IPv4 address to an integer
+func ipToUint32(ip net.IP) uint32 {
+	return binary.BigEndian.Uint32(ip.To4())
+}
+
+// uint32ToIP converts an integer to an IPv4 address
+func uint32ToIP(n uint32) net.IP {
+	ip := make(net.IP, 4)
+	binary.BigEndian.PutUint32(ip, n)
+	return ip
+}
+
+// newDHCPServer creates a DHCP server on the host stack, filling in the
+// defaults of the configuration
+func newDHCPServer(vs *VirtualSwitch, stack *hostStack, cfg DHCPServerConfig) *dhcpServer {
+	if cfg.LeaseTime <= 0 {
+		cfg.LeaseTime = dhcpDefaultLeaseTime
+	}
+
+	network := ipToUint32(cfg.Subnet.IP)
+	broadcast := network | ^binary.BigEndian.Uint32(net.IP(cfg.Subnet.Mask).To4())
+
+	s := &dhcpServer{
+		vs:     vs,
+		stack:  stack,
+		config: cfg,
+		start:  network + 1,
+		end:    broadcast - 1,
+		leases: make(map[uint32]*dhcpLease),
+		byMAC:  make(map[string]*dhcpLease),
+	}
+
+	if cfg.RangeStart != nil {
+		s.start = ipToUint32(cfg.RangeStart)
+	}
+	if cfg.RangeEnd != nil {
+		s.end = ipToUint32(cfg.RangeEnd)
+	}
+
+	stack.addAddress(cfg.ServerIP)
+
+	return s
+}
+
+// handleFrame answers and consumes client messages addressed to this server
+func (s *dhcpServer) handleFrame(frame *EthernetFrame, src *Connection) bool {
+	msg, udp := dhcpFromFrame(frame)
+	if msg == nil || udp.DstPort != dhcpServerPort || msg.Op != bootRequest || len(msg.CHAddr) != 6 {
+		return false
+	}
+
+	// Requests naming another server belong to it
+	if id := msg.Options[dhcpOptServerID]; len(id) == 4 && !net.IP(id).Equal(s.config.ServerIP) {
+		return false
+	}
+
+	switch msg.messageType() {
+	case dhcpDiscover:
+		s.handleDiscover(msg)
+	case dhcpRequest:
+		s.handleRequest(msg, src)
+	case dhcpRelease:
+		s.release(msg.CHAddr, msg.CIAddr)
+	case dhcpDecline:
+		if requested := msg.Options[dhcpOptRequestedIP]; len(requested) == 4 {
+			s.decline(net.IP(requested))
+		}
+	case dhcpInform:
+		s.reply(msg, dhcpAck, nil)
+	default:
+		return false
+	}
+
+	return true
+}
+
+// handleDiscover offers an address to a client
+func (s *dhcpServer) handleDiscover(msg *dhcpMessage) {
+	var requested net.IP
+	if value := msg.Options[dhcpOptRequestedIP]; len(value) == 4 {
+		requested = net.IP(value)
+	}
+
+	ip := s.allocate(msg.CHAddr.String(), requested)
+	if ip == nil {
+		s.vs.logger.Warn("DHCP server has no free address", "mac", msg.CHAddr.String())
+		return
+	}
+
+	s.reply(msg, dhcpOffer, ip)
+}
+
+// handleRequest confirms or refuses the address a client asks for
+func (s *dhcpServer) handleRequest(msg *dhcpMessage, src *Connection) {
+	ip := net.IP(msg.Options[dhcpOptRequestedIP])
+	if len(ip) != 4 {
+		ip = msg.CIAddr
+	}
+	if ip.IsUnspecified() {
+		return
+	}
+
+	expires, ok := s.bind(msg.CHAddr.String(), ip)
+	if !ok {
+		s.reply(msg, dhcpNak, nil)
+		return
+	}
+
+	s.reply(msg, dhcpAck, ip)
+
+	if s.vs.dhcpSnooper != nil {
+		s.vs.dhcpSnooper.add(&DHCPBinding{
+			IP:           append(net.IP{}, ip.To4()...),
+			MAC:          append(net.HardwareAddr{}, msg.CHAddr...),
+			ConnectionID: src.ID,
+			Expires:      expires,
+		})
+	}
+}
+
+// reserved reports whether an address is used by the switch itself or
+// announced to clients as a server
+func (s *dhcpServer) reserved(ip uint32) bool {
+	addr := uint32ToIP(ip)
+	if s.stack.owns(addr) || addr.Equal(s.config.Router) {
+		return true
+	}
+	for _, dns := range s.config.DNS {
+		if addr.Equal(dns) {
+			return true
+		}
+	}
+	return false
+}
+
+// available reports whether an address may be given to a client; the
+// mutex must be held
+func (s *dhcpServer) available(ip uint32, mac string, now time.Time) bool {
+	if ip < s.start || ip > s.end || s.reserved(ip) {
+		return false
+	}
+	lease, found := s.leases[ip]
+	return !found || lease.mac == mac || now.After(lease.expires)
+}
+
+// allocate picks an address for a client, preferring its current lease and
+// then the address it asked for, and reserves it briefly
+func (s *dhcpServer) allocate(mac string, requested net.IP) net.IP {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := time.Now()
+	candidate := uint32(0)
+
+	if lease, found := s.byMAC[mac]; found && s.available(lease.ip, mac, now) {
+		candidate = lease.ip
+	} else if requested != nil && s.available(ipToUint32(requested), mac, now) {
+		candidate = ipToUint32(requested)
+	} else {
+		for ip := s.start; ip <= s.end && ip >= s.start; ip++ {
+			if s.available(ip, mac, now) {
+				candidate = ip
+				break
+			}
+		}
+	}
+
+	if candidate == 0 {
+		return nil
+	}
+
+	expires := now.Add(dhcpOfferTimeout)
+	if lease, found := s.leases[candidate]; found && lease.mac == mac && lease.expires.After(expires) {
+		expires = lease.expires
+	}
+	s.setLease(candidate, mac, expires)
+
+	return uint32ToIP(candidate)
+}
+
+// bind leases an address to a client, returning false if the client may
+// not have it
+func (s *dhcpServer) bind(mac string, ip net.IP) (time.Time, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := time.Now()
+	if ip.To4() == nil || !s.available(ipToUint32(ip), mac, now) {
+		return time.Time{}, false
+	}
+
+	expires := now.Add(s.config.LeaseTime)
+	s.setLease(ipToUint32(ip), mac, expires)
+	return expires, true
+}
+
+// setLease records a lease, replacing the client's previous one; the mutex
+// must be held
+func (s *dhcpServer) setLease(ip uint32, mac string, expires time.Time) {
+	if old, found := s.leases[ip]; found && s.byMAC[old.mac] == old {
+		delete(s.byMAC, old.mac)
+	}
+	if old, found := s.byMAC[mac]; found {
+		delete(s.leases, old.ip)
+	}
+
+	lease := &dhcpLease{ip: ip, mac: mac, expires: expires}
+	s.leases[ip] = lease
+	if mac != "" {
+		s.byMAC[mac] = lease
+	}
+}
+
+// release frees a client's lease
+func (s *dhcpServer) release(mac net.HardwareAddr, ip net.IP) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if lease, found := s.byMAC[mac.String()]; found && ip.To4() != nil && lease.ip == ipToUint32(ip) {
+		delete(s.byMAC, lease.mac)
+		delete(s.leases, lease.ip)
+	}
+
+	if s.vs.dhcpSnooper != nil {
+		s.vs.dhcpSnooper.remove(ip)
+	}
+}
+
+// decline keeps an address a client found to be in use out of the pool
+// for a lease period
+func (s *dhcpServer) decline(ip net.IP) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.vs.logger.Info("DHCP address declined by client", "ip", ip.String())
+	s.setLease(ipToUint32(ip), "", time.Now().Add(s.config.LeaseTime))
+}
+
+// bootFile returns the boot file to offer a client, or "" for none
+func (s *dhcpServer) bootFile(msg *dhcpMessage) string {
+	arch := msg.Options[dhcpOptClientArch]
+	if len(arch) == 2 && binary.BigEndian.Uint16(arch) != 0 && s.config.BootFileUEFI != "" {
+		return s.config.BootFileUEFI
+	}
+	return s.config.BootFile
+}
+
+// reply sends a DHCP reply of the given type; ip is the address assigned
+// to the client, or nil for NAK and INFORM replies
+func (s *dhcpServer) reply(req *dhcpMessage, msgType uint8, ip net.IP) {
+	resp := &dhcpMessage{
+		Op:     bootReply,
+		XID:    req.XID,
+		Flags:  req.Flags,
+		CIAddr: net.IPv4zero,
+		YIAddr: net.IPv4zero,
+		SIAddr: net.IPv4zero,
+		GIAddr: req.GIAddr,
+		CHAddr: req.CHAddr,
+		Options: map[uint8][]byte{
+			dhcpOptMessageType: {msgType},
+			dhcpOptServerID:    s.config.ServerIP.To4(),
+		},
+	}
+
+	if msgType != dhcpNak {
+		resp.SIAddr = s.config.ServerIP
+		resp.Options[dhcpOptSubnetMask] = []byte(s.config.Subnet.Mask)
+		if s.config.Router != nil {
+			resp.Options[dhcpOptRouter] = s.config.Router.To4()
+		}
+		for _, dns := range s.config.DNS {
+			resp.Options[dhcpOptDNS] = append(resp.Options[dhcpOptDNS], dns.To4()...)
+		}
+		if file := s.bootFile(req); file != "" {
+			resp.File = file
+			resp.Options[dhcpOptTFTPServer] = []byte(s.config.ServerIP.String())
+			resp.Options[dhcpOptBootFile] = []byte(file)
+		}
+	}
+
+	if ip != nil {
+		lease := uint32(s.config.LeaseTime / time.Second)
+		resp.YIAddr = ip
+		resp.Options[dhcpOptLeaseTime] = binary.BigEndian.AppendUint32(nil, lease)
+		resp.Options[dhcpOptRenewalTime] = binary.BigEndian.AppendUint32(nil, lease/2)
+		resp.Options[dhcpOptRebindTime] = binary.BigEndian.AppendUint32(nil, lease/8*7)
+	}
+
+	dstMAC, dstIP := BroadcastMAC, net.IPv4bcast
+	switch {
+	case msgType == dhcpNak || req.Flags&dhcpBroadcastFlag != 0:
+	case msgType == dhcpAck && ip == nil:
+		resp.CIAddr = req.CIAddr
+		dstMAC, dstIP = req.CHAddr, req.CIAddr
+	default:
+		dstMAC, dstIP = req.CHAddr, ip
+	}
+
+	s.vs.injectFrame(buildEthernet(dstMAC, s.stack.mac, EtherTypeIPv4,
+		buildUDPv4(s.config.ServerIP, dstIP, dhcpServerPort, dhcpClientPort, resp.marshal())))
+}
+
+// run returns immediately; leases expire lazily as addresses are reused
+func (s *dhcpServer) run(_ <-chan bool) {}
@@ -0,0 +1,202 @@
+package vswitch
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+)
+
+// newDHCPServerTest creates a switch with a DHCP server and one client connection
+func newDHCPServerTest(t *testing.T, cfg Config) (*VirtualSwitch, *Connection, *mockConnSwitch) {
+	t.Helper()
+
+	sw := NewVirtualSwitchWithConfig([]int{8080}, cfg)
+	mockConn := &mockConnSwitch{addr: &mockAddrSwitch{network: "tcp", address: "127.0.0.1:9001"}}
+	conn := NewConnection("client", mockConn)
+	sw.connections.Store("client", conn)
+
+	return sw, conn, mockConn
+}
+
+// exchangeDHCP sends a client message and returns the server's reply, or nil
+func exchangeDHCP(t *testing.T, sw *VirtualSwitch, conn *Connection, mockConn *mockConnSwitch, msg *dhcpMessage) (*dhcpMessage, *ipv4Packet) {
+	t.Helper()
+
+	mockConn.writeData = nil
+	_ = sw.processFrame(dhcpFrame(msg.CHAddr, dhcpClientPort, dhcpServerPort, msg), conn)
+
+	frames := writtenFrames(t, mockConn.writeData)
+	if len(frames) == 0 {
+		return nil, nil
+	}
+
+	ip, err := parseIPv4(rawFrame(frames[0]).Payload)
+	if err != nil {
+		t.Fatalf("Unexpected error parsing reply: %v", err)
+	}
+	udp, _ := parseUDP(ip.Payload)
+	reply, err := parseDHCP(udp.Payload)
+	if err != nil {
+		t.Fatalf("Unexpected error parsing DHCP reply: %v", err)
+	}
+	return reply, ip
+}
+
+// dhcpClientMessage builds a client message of the given type
+func dhcpClientMessage(msgType uint8, mac net.HardwareAddr, options map[uint8][]byte) *dhcpMessage {
+	msg := &dhcpMessage{
+		Op:      bootRequest,
+		XID:     0xabcd,
+		CIAddr:  net.IPv4zero,
+		YIAddr:  net.IPv4zero,
+		SIAddr:  net.IPv4zero,
+		GIAddr:  net.IPv4zero,
+		CHAddr:  mac,
+		Options: map[uint8][]byte{dhcpOptMessageType: {msgType}},
+	}
+	for code, value := range options {
+		msg.Options[code] = value
+	}
+	return msg
+}
+
+func TestDHCPServerLease(t *testing.T) {
+	_, subnet, _ := net.ParseCIDR("10.0.2.0/24")
+	sw, conn, mockConn := newDHCPServerTest(t, Config{
+		DHCPSnooping: true,
+		NAT:          &NATConfig{Gateway: net.ParseIP("10.0.2.2").To4()},
+		DHCPServer: &DHCPServerConfig{
+			ServerIP:   net.ParseIP("10.0.2.3").To4(),
+			Subnet:     subnet,
+			RangeStart: net.ParseIP("10.0.2.100"),
+			RangeEnd:   net.ParseIP("10.0.2.101"),
+			LeaseTime:  time.Hour,
+		},
+	})
+
+	mac1 := net.HardwareAddr{0x52, 0x54, 0x00, 0x00, 0x00, 0x01}
+	mac2 := net.HardwareAddr{0x52, 0x54, 0x00, 0x00, 0x00, 0x02}
+	mac3 := net.HardwareAddr{0x52, 0x54, 0x00, 0x00, 0x00, 0x03}
+
+	offer, packet := exchangeDHCP(t, sw, conn, mockConn, dhcpClientMessage(dhcpDiscover, mac1, nil))
+	if offer == nil || offer.messageType() != dhcpOffer {
+		t.Fatalf("Expected DHCPOFFER, got %+v", offer)
+	}
+	if !offer.YIAddr.Equal(net.ParseIP("10.0.2.100")) || !packet.Src.Equal(net.ParseIP("10.0.2.3")) {
+		t.Errorf("Unexpected offer of %s from %s", offer.YIAddr, packet.Src)
+	}
+	if !net.IP(offer.Options[dhcpOptRouter]).Equal(net.ParseIP("10.0.2.2")) {
+		t.Errorf("Expected the NAT gateway as router, got %v", offer.Options[dhcpOptRouter])
+	}
+	if net.IPMask(offer.Options[dhcpOptSubnetMask]).String() != "ffffff00" || offer.leaseTime() != time.Hour {
+		t.Errorf("Unexpected mask %v or lease time %v", offer.Options[dhcpOptSubnetMask], offer.leaseTime())
+	}
+
+	ack, _ := exchangeDHCP(t, sw, conn, mockConn, dhcpClientMessage(dhcpRequest, mac1, map[uint8][]byte{
+		dhcpOptRequestedIP: offer.YIAddr.To4(),
+		dhcpOptServerID:    net.ParseIP("10.0.2.3").To4(),
+	}))
+	if ack == nil || ack.messageType() != dhcpAck || !ack.YIAddr.Equal(offer.YIAddr) {
+		t.Fatalf("Expected DHCPACK for %s, got %+v", offer.YIAddr, ack)
+	}
+
+	bindings := sw.GetDHCPBindings()
+	if len(bindings) != 1 || bindings[0].ConnectionID != "client" || bindings[0].MAC.String() != mac1.String() {
+		t.Errorf("Expected lease recorded in the snooping table, got %+v", bindings)
+	}
+
+	// Another client cannot take the leased address
+	nak, _ := exchangeDHCP(t, sw, conn, mockConn, dhcpClientMessage(dhcpRequest, mac2, map[uint8][]byte{
+		dhcpOptRequestedIP: offer.YIAddr.To4(),
+	}))
+	if nak == nil || nak.messageType() != dhcpNak {
+		t.Fatalf("Expected DHCPNAK, got %+v", nak)
+	}
+
+	offer2, _ := exchangeDHCP(t, sw, conn, mockConn, dhcpClientMessage(dhcpDiscover, mac2, nil))
+	if offer2 == nil || !offer2.YIAddr.Equal(net.ParseIP("10.0.2.101")) {
+		t.Fatalf("Expected offer of 10.0.2.101, got %+v", offer2)
+	}
+
+	// The pool is exhausted while the offer is outstanding
+	if reply, _ := exchangeDHCP(t, sw, conn, mockConn, dhcpClientMessage(dhcpDiscover, mac3, nil)); reply != nil {
+		t.Errorf("Expected no offer from an exhausted pool, got %+v", reply)
+	}
+
+	// The first client keeps its address across discovers
+	again, _ := exchangeDHCP(t, sw, conn, mockConn, dhcpClientMessage(dhcpDiscover, mac1, nil))
+	if again == nil || !again.YIAddr.Equal(offer.YIAddr) {
+		t.Errorf("Expected the same address to be offered again, got %+v", again)
+	}
+
+	release := dhcpClientMessage(dhcpRelease, mac1, nil)
+	release.CIAddr = offer.YIAddr
+	if reply, _ := exchangeDHCP(t, sw, conn, mockConn, release); reply != nil {
+		t.Errorf("Expected no reply to DHCPRELEASE")
+	}
+	if len(sw.GetDHCPBindings()) != 0 {
+		t.Errorf("Expected release to remove the snooping binding")
+	}
+
+	offer3, _ := exchangeDHCP(t, sw, conn, mockConn, dhcpClientMessage(dhcpDiscover, mac3, nil))
+	if offer3 == nil || !offer3.YIAddr.Equal(offer.YIAddr) {
+		t.Errorf("Expected released address to be offered, got %+v", offer3)
+	}
+}
+
+func TestDHCPServerIgnoresOtherServers(t *testing.T) {
+	_, subnet, _ := net.ParseCIDR("10.0.2.0/24")
+	sw, conn, mockConn := newDHCPServerTest(t, Config{
+		DHCPServer: &DHCPServerConfig{ServerIP: net.ParseIP("10.0.2.3").To4(), Subnet: subnet},
+	})
+
+	mac := net.HardwareAddr{0x52, 0x54, 0x00, 0x00, 0x00, 0x01}
+	request := dhcpClientMessage(dhcpRequest, mac, map[uint8][]byte{
+		dhcpOptRequestedIP: net.ParseIP("10.0.2.50").To4(),
+		dhcpOptServerID:    net.ParseIP("10.0.2.254").To4(),
+	})
+
+	if sw.services[0].handleFrame(dhcpFrame(mac, dhcpClientPort, dhcpServerPort, request), conn) {
+		t.Errorf("Expected request for another server not to be consumed")
+	}
+
+	// The server's own address is never handed out
+	offer, _ := exchangeDHCP(t, sw, conn, mockConn, dhcpClientMessage(dhcpDiscover, mac, map[uint8][]byte{
+		dhcpOptRequestedIP: net.ParseIP("10.0.2.3").To4(),
+	}))
+	if offer == nil || !offer.YIAddr.Equal(net.ParseIP("10.0.2.1")) {
+		t.Errorf("Expected offer of 10.0.2.1, got %+v", offer)
+	}
+}
+
+func TestDHCPServerBootOptions(t *testing.T) {
+	_, subnet, _ := net.ParseCIDR("10.0.2.0/24")
+	sw, conn, mockConn := newDHCPServerTest(t, Config{
+		DHCPServer: &DHCPServerConfig{
+			ServerIP:     net.ParseIP("10.0.2.3").To4(),
+			Subnet:       subnet,
+			BootFile:     "pxelinux.0",
+			BootFileUEFI: "bootx64.efi",
+		},
+	})
+
+	mac := net.HardwareAddr{0x52, 0x54, 0x00, 0x00, 0x00, 0x01}
+
+	offer, _ := exchangeDHCP(t, sw, conn, mockConn, dhcpClientMessage(dhcpDiscover, mac, map[uint8][]byte{
+		dhcpOptClientArch: {0, 0},
+	}))
+	if offer == nil || offer.File != "pxelinux.0" || string(offer.Options[dhcpOptBootFile]) != "pxelinux.0" {
+		t.Fatalf("Expected BIOS boot file, got %+v", offer)
+	}
+	if !offer.SIAddr.Equal(net.ParseIP("10.0.2.3")) || string(offer.Options[dhcpOptTFTPServer]) != "10.0.2.3" {
+		t.Errorf("Expected next server 10.0.2.3, got %s", offer.SIAddr)
+	}
+
+	uefi, _ := exchangeDHCP(t, sw, conn, mockConn, dhcpClientMessage(dhcpDiscover, mac, map[uint8][]byte{
+		dhcpOptClientArch: binary.BigEndian.AppendUint16(nil, 7),
+	}))
+	if uefi == nil || uefi.File != "bootx64.efi" {
+		t.Errorf("Expected UEFI boot file, got %+v", uefi)
+	}
+}
@@ -0,0 +1,205 @@
+package vswitch
+
+import (
+	"log/slog"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DHCPBinding is an IP address lease observed by DHCP snooping
+type DHCPBinding struct {
+	IP           net.IP           `json:"ip"`
+	MAC          net.HardwareAddr `json:"mac"`
+	ConnectionID string           `json:"connection_id"`
+	Expires      time.Time        `json:"expires"`
+}
+
+// dhcpSnooper drops DHCP server messages from untrusted connections and
+// records the leases granted by trusted servers
+type dhcpSnooper struct {
+	bindings map[string]*DHCPBinding // IP -> binding
+	logger   *slog.Logger
+	mutex    sync.Mutex
+}
+
+// newDHCPSnooper creates an empty DHCP snooping table
+func newDHCPSnooper(logger *slog.Logger) *dhcpSnooper {
+	return &dhcpSnooper{
+		bindings: make(map[string]*DHCPBinding),
+		logger:   logger,
+	}
+}
+
+// inspect examines a frame and returns false if it must be dropped
+func (ds *dhcpSnooper) inspect(frame *EthernetFrame, src *Connection, resolve func(net.HardwareAddr) *Connection) bool {
+	msg, udp := dhcpFromFrame(frame)
+	if udp == nil {
+		return true
+	}
+
+	// Anything sent from the server port is a server message
+	fromServer := udp.SrcPort == dhcpServerPort && udp.DstPort == dhcpClientPort
+	if msg != nil && msg.Op == bootReply {
+		fromServer = true
+	}
+
+	if fromServer {
+		if !src.Trusted() {
+			return false
+		}
+		if msg != nil {
+			ds.recordReply(msg, resolve)
+		}
+		return true
+	}
+
+	if msg != nil && msg.messageType() == dhcpRelease {
+		ds.release(msg.CIAddr, msg.CHAddr, src.ID)
+	}
+
+	return true
+}
+
+// recordReply updates the binding table from a trusted server reply
+func (ds *dhcpSnooper) recordReply(msg *dhcpMessage, resolve func(net.HardwareAddr) *Connection) {
+	switch msg.messageType() {
+	case dhcpAck:
+		if msg.YIAddr.IsUnspecified() {
+			return // Reply to DHCPINFORM carries no lease
+		}
+
+		connID := ""
+		if conn := resolve(msg.CHAddr); conn != nil {
+			connID = conn.ID
+		}
+
+		ds.add(&DHCPBinding{
+			IP:           append(net.IP{}, msg.YIAddr...),
+			MAC:          append(net.HardwareAddr{}, msg.CHAddr...),
+			ConnectionID: connID,
+			Expires:      time.Now().Add(msg.leaseTime()),
+		})
+	case dhcpNak:
+		ds.removeMAC(msg.CHAddr)
+	}
+}
+
+// add records a binding, replacing any previous lease for the address
+func (ds *dhcpSnooper) add(binding *DHCPBinding) {
+	ds.mutex.Lock()
+	defer ds.mutex.Unlock()
+
+	ds.bindings[binding.IP.String()] = binding
+	ds.logger.Info("DHCP snooping binding", "ip", binding.IP.String(), "mac", binding.MAC, "connection", binding.ConnectionID)
+}
+
+// release deletes the binding for an IP address when a client releases it,
+// unless the binding belongs to another MAC or connection, so that guests
+// cannot release each other's leases with forged addresses
+func (ds *dhcpSnooper) release(ip net.IP, mac net.HardwareAddr, connID string) {
+	ds.mutex.Lock()
+	defer ds.mutex.Unlock()
+
+	key := ip.String()
+	binding, found := ds.bindings[key]
+	if !found {
+		return
+	}
+	if binding.MAC.String() != mac.String() || binding.ConnectionID != connID {
+		ds.logger.Warn("Ignoring DHCP release of another client's binding", "ip", key, "mac", mac, "connection", connID)
+		return
+	}
+	delete(ds.bindings, key)
+}
+
+// remove deletes the binding for an IP address
+func (ds *dhcpSnooper) remove(ip net.IP) {
+	ds.mutex.Lock()
+	defer ds.mutex.Unlock()
+
+	delete(ds.bindings, ip.String())
+}
+
+// removeMAC deletes all bindings for a MAC address
+func (ds *dhcpSnooper) removeMAC(mac net.HardwareAddr) {
+	ds.mutex.Lock()
+	defer ds.mutex.Unlock()
+
+	for key, binding := range ds.bindings {
+		if binding.MAC.String() == mac.String() {
+			delete(ds.bindings, key)
+		}
+	}
+}
+
+// rebindMAC attributes the bindings of a MAC to the connection it moved to
+func (ds *dhcpSnooper) rebindMAC(mac net.HardwareAddr, connID string) {
+	ds.mutex.Lock()
+	defer ds.mutex.Unlock()
+
+	for _, binding := range ds.bindings {
+		if binding.MAC.String() == mac.String() {
+			binding.ConnectionID = connID
+		}
+	}
+}
+
+// addresses returns the addresses leased to a MAC
+func (ds *dhcpSnooper) addresses(mac net.HardwareAddr) []net.IP {
+	ds.mutex.Lock()
+	defer ds.mutex.Unlock()
+
+	var addresses []net.IP
+	for _, binding := range ds.bindings {
+		if binding.MAC.String() == mac.String() {
+			addresses = append(addresses, binding.IP)
+		}
+	}
+	return addresses
+}
+
+// removeConnection deletes all bindings learned on a connection
+func (ds *dhcpSnooper) removeConnection(connID string) {
+	ds.mutex.Lock()
+	defer ds.mutex.Unlock()
+
+	for key, binding := range ds.bindings {
+		if binding.ConnectionID == connID {
+			delete(ds.bindings, key)
+		}
+	}
+}
+
+// expire deletes bindings whose lease has run out
+func (ds *dhcpSnooper) expire(now time.Time) int {
+	ds.mutex.Lock()
+	defer ds.mutex.Unlock()
+
+	removed := 0
+	for key, binding := range ds.bindings {
+		if now.After(binding.Expires) {
+			delete(ds.bindings, key)
+			removed++
+		}
+	}
+	return removed
+}
+
+// list returns a copy of the binding table sorted by IP address
+func (ds *dhcpSnooper) list() []DHCPBinding {
+	ds.mutex.Lock()
+	defer ds.mutex.Unlock()
+
+	bindings := make([]DHCPBinding, 0, len(ds.bindings))
+	for _, binding := range ds.bindings {
+		bindings = append(bindings, *binding)
+	}
+
+	sort.Slice(bindings, func(i, j int) bool {
+		return string(bindings[i].IP.To16()) < string(bindings[j].IP.To16())
+	})
+
+	return bindings
+}
@@ -0,0 +1,118 @@
+package vswitch
+
+import (
+	"net"
+	"testing"
+)
+
+// dhcpFrame builds an Ethernet frame carrying a DHCP message
+func dhcpFrame(srcMAC net.HardwareAddr, srcPort, dstPort uint16, msg *dhcpMessage) *EthernetFrame {
+	packet := buildUDPv4(net.ParseIP("10.0.0.1"), net.IPv4bcast, srcPort, dstPort, msg.marshal())
+	frame, _ := ParseEthernetFrame(buildEthernet(BroadcastMAC, srcMAC, EtherTypeIPv4, packet))
+	frame.pooled = false
+	return frame
+}
+
+func TestDHCPSnooping(t *testing.T) {
+	sw := NewVirtualSwitchWithConfig([]int{8080}, Config{DHCPSnooping: true})
+
+	mockServer := &mockConnSwitch{addr: &mockAddrSwitch{network: "tcp", address: "127.0.0.1:9001"}}
+	mockRogue := &mockConnSwitch{addr: &mockAddrSwitch{network: "tcp", address: "127.0.0.1:9002"}}
+	mockClient := &mockConnSwitch{addr: &mockAddrSwitch{network: "tcp", address: "127.0.0.1:9003"}}
+	server := NewConnection("server", mockServer)
+	rogue := NewConnection("rogue", mockRogue)
+	client := NewConnection("client", mockClient)
+	server.SetTrusted(true)
+	sw.connections.Store("server", server)
+	sw.connections.Store("rogue", rogue)
+	sw.connections.Store("client", client)
+
+	clientMAC := net.HardwareAddr{0x52, 0x54, 0x00, 0x00, 0x00, 0x03}
+	sw.learnMAC(clientMAC, client)
+
+	ack := &dhcpMessage{
+		Op:      bootReply,
+		XID:     1,
+		YIAddr:  net.ParseIP("10.0.0.50"),
+		CHAddr:  clientMAC,
+		Options: map[uint8][]byte{dhcpOptMessageType: {dhcpAck}, dhcpOptLeaseTime: {0, 0, 0x0e, 0x10}},
+	}
+
+	// A rogue server's reply is dropped
+	if err := sw.processFrame(dhcpFrame(net.HardwareAddr{0x52, 0x54, 0x00, 0x00, 0x00, 0x02}, 67, 68, ack), rogue); err == nil {
+		t.Errorf("Expected DHCP reply from untrusted connection to be dropped")
+	}
+	if len(mockClient.writeData) != 0 {
+		t.Errorf("Expected rogue DHCP reply not to reach the client")
+	}
+	if len(sw.GetDHCPBindings()) != 0 {
+		t.Errorf("Expected no bindings from untrusted replies")
+	}
+
+	// The trusted server's reply is forwarded and recorded
+	if err := sw.processFrame(dhcpFrame(net.HardwareAddr{0x52, 0x54, 0x00, 0x00, 0x00, 0x01}, 67, 68, ack), server); err != nil {
+		t.Errorf("Unexpected error forwarding trusted reply: %v", err)
+	}
+	if len(mockClient.writeData) == 0 {
+		t.Errorf("Expected trusted DHCP reply to reach the client")
+	}
+
+	bindings := sw.GetDHCPBindings()
+	if len(bindings) != 1 {
+		t.Fatalf("Expected 1 binding, got %d", len(bindings))
+	}
+	if bindings[0].IP.String() != "10.0.0.50" || bindings[0].MAC.String() != clientMAC.String() || bindings[0].ConnectionID != "client" {
+		t.Errorf("Unexpected binding %+v", bindings[0])
+	}
+
+	if stats := sw.GetStats(); stats["dhcp_drops"] != uint64(1) {
+		t.Errorf("Expected 1 DHCP drop, got %v", stats["dhcp_drops"])
+	}
+
+	// Releases forged by another guest, whether with its own hardware
+	// address or the client's, leave the binding alone
+	rogueMAC := net.HardwareAddr{0x52, 0x54, 0x00, 0x00, 0x00, 0x02}
+	release := &dhcpMessage{
+		Op:      bootRequest,
+		XID:     2,
+		CIAddr:  net.ParseIP("10.0.0.50"),
+		Options: map[uint8][]byte{dhcpOptMessageType: {dhcpRelease}},
+	}
+	for _, chaddr := range []net.HardwareAddr{rogueMAC, clientMAC} {
+		release.CHAddr = chaddr
+		if err := sw.processFrame(dhcpFrame(rogueMAC, 68, 67, release), rogue); err != nil {
+			t.Errorf("Unexpected error forwarding spoofed release: %v", err)
+		}
+		if len(sw.GetDHCPBindings()) != 1 {
+			t.Errorf("Expected a release spoofed with hardware address %s to keep the binding", chaddr)
+		}
+	}
+
+	// Client requests are always allowed, and releases remove the binding
+	release.CHAddr = clientMAC
+	if err := sw.processFrame(dhcpFrame(clientMAC, 68, 67, release), client); err != nil {
+		t.Errorf("Unexpected error forwarding client release: %v", err)
+	}
+	if len(sw.GetDHCPBindings()) != 0 {
+		t.Errorf("Expected release to remove the binding")
+	}
+}
+
+func TestDHCPSnoopingConnectionCleanup(t *testing.T) {
+	sw := NewVirtualSwitchWithConfig([]int{8080}, Config{DHCPSnooping: true})
+
+	conn := NewConnection("client", &mockConnSwitch{addr: &mockAddrSwitch{network: "tcp", address: "127.0.0.1:9001"}})
+	sw.connections.Store("client", conn)
+
+	sw.dhcpSnooper.add(&DHCPBinding{
+		IP:           net.ParseIP("10.0.0.50"),
+		MAC:          net.HardwareAddr{0x52, 0x54, 0x00, 0x00, 0x00, 0x03},
+		ConnectionID: "client",
+	})
+
+	sw.cleanupConnection(conn)
+
+	if len(sw.GetDHCPBindings()) != 0 {
+		t.Errorf("Expected bindings to be removed with their connection")
+	}
+}
@@ -0,0 +1,268 @@
+package vswitch
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	dnsPort = 53
+
+	// dnsTimeout bounds each upstream query attempt
+	dnsTimeout = 2 * time.Second
+
+	// dnsMaxInflight bounds concurrent upstream queries per VLAN
+	dnsMaxInflight = 64
+
+	// dnsRecordTTL is the TTL of answers served from static records
+	dnsRecordTTL = 60
+
+	// dnsMaxReply is the largest reply that fits an untagged Ethernet frame
+	dnsMaxReply = 1500 - 20 - 8
+)
+
+// DNS record types and response codes used by the forwarder
+const (
+	dnsTypeA      = 1
+	dnsTypeAAAA   = 28
+	dnsClassIN    = 1
+	dnsRcodeOK    = 0
+	dnsRcodeFail  = 2
+	dnsHeaderSize = 12
+)
+
+// dnsForwarder answers DNS queries sent to the switch, serving static
+// records locally and relaying everything else to upstream resolvers
+type dnsForwarder struct {
+	stack     *hostStack
+	upstreams []string
+	records   map[string]net.IP
+	inflight  chan struct{}
+}
+
+// newDNSForwarder creates a forwarder listening on the host stack
+func newDNSForwarder(stack *hostStack, upstreams []string, records map[string]net.IP) *dnsForwarder {
+	normalized := make(map[string]net.IP, len(records))
+	for name, ip := range records {
+		normalized[normalizeDNSName(name)] = ip
+	}
+
+	if len(upstreams) == 0 {
+		var err error
+		if upstreams, err = systemDNSUpstreams("/etc/resolv.conf"); err != nil {
+			stack.vs.logger.Warn("Failed to read DNS resolvers", "error", err)
+		}
+	}
+
+	return &dnsForwarder{
+		stack:     stack,
+		upstreams: upstreams,
+		records:   normalized,
+		inflight:  make(chan struct{}, dnsMaxInflight),
+	}
+}
+
+// handleQuery answers a query from static records or relays it upstream
+func (d *dnsForwarder) handleQuery(req *udpRequest) {
+	if len(req.Payload) < dnsHeaderSize || req.Payload[2]&0x80 != 0 {
+		return // Not a query
+	}
+
+	query := append([]byte{}, req.Payload...)
+	reply := *req
+	reply.SrcMAC = append(net.HardwareAddr{}, req.SrcMAC...)
+	reply.SrcIP = append(net.IP{}, req.SrcIP...)
+	reply.DstIP = append(net.IP{}, req.DstIP...)
+
+	if answer := d.answerLocally(query); answer != nil {
+		d.stack.reply(&reply, answer)
+		return
+	}
+
+	select {
+	case d.inflight <- struct{}{}:
+	default:
+		d.stack.reply(&reply, dnsErrorReply(query, dnsRcodeFail))
+		return
+	}
+
+	// The relay counts as a goroutine of the switch so that stopping it
+	// waits for the relay, which then no longer replies
+	vs := d.stack.vs
+	select {
+	case <-vs.shutdown:
+		<-d.inflight
+		return
+	default:
+	}
+	vs.wg.Add(1)
+	go func() {
+		defer vs.wg.Done()
+		defer func() { <-d.inflight }()
+		answer := d.forward(query)
+		select {
+		case <-vs.shutdown:
+		default:
+			d.stack.reply(&reply, answer)
+		}
+	}()
+}
+
+// answerLocally builds a reply from the static records, or returns nil if
+// the query is not for a static name
+func (d *dnsForwarder) answerLocally(query []byte) []byte {
+	if len(d.records) == 0 || binary.BigEndian.Uint16(query[4:6]) != 1 {
+		return nil
+	}
+
+	name, qtype, qclass, end, err := parseDNSQuestion(query)
+	if err != nil || qclass != dnsClassIN {
+		return nil
+	}
+
+	ip, found := d.records[name]
+	if !found {
+		return nil
+	}
+
+	var rdata []byte
+	switch {
+	case qtype == dnsTypeA && ip.To4() != nil:
+		rdata = ip.To4()
+	case qtype == dnsTypeAAAA && ip.To4() == nil:
+		rdata = ip.To16()
+	}
+
+	// Header and question, with the question count fixed and other sections dropped
+	reply := append([]byte{}, query[:end]...)
+	reply[2] = 0x84 | query[2]&0x79 // QR, AA, opcode and RD from the query
+	reply[3] = 0x80 | dnsRcodeOK    // RA
+	binary.BigEndian.PutUint16(reply[6:8], 0)
+	binary.BigEndian.PutUint16(reply[8:10], 0)
+	binary.BigEndian.PutUint16(reply[10:12], 0)
+
+	if rdata != nil {
+		binary.BigEndian.PutUint16(reply[6:8], 1)
+		answer := make([]byte, 12+len(rdata))
+		binary.BigEndian.PutUint16(answer[0:2], 0xc000|dnsHeaderSize) // Pointer to the question name
+		binary.BigEndian.PutUint16(answer[2:4], qtype)
+		binary.BigEndian.PutUint16(answer[4:6], dnsClassIN)
+		binary.BigEndian.PutUint32(answer[6:10], dnsRecordTTL)
+		binary.BigEndian.PutUint16(answer[10:12], uint16(len(rdata)))
+		copy(answer[12:], rdata)
+		reply = append(reply, answer...)
+	}
+
+	return reply
+}
+
+// forward relays a query to the upstream resolvers in turn
+func (d *dnsForwarder) forward(query []byte) []byte {
+	buf := make([]byte, 65535)
+
+	for _, upstream := range d.upstreams {
+		conn, err := net.DialTimeout("udp", upstream, dnsTimeout)
+		if err != nil {
+			d.stack.vs.logger.Warn("DNS upstream unreachable", "upstream", upstream, "error", err)
+			continue
+		}
+
+		_ = conn.SetDeadline(time.Now().Add(dnsTimeout))
+		if _, err := conn.Write(query); err != nil {
+			_ = conn.Close()
+			continue
+		}
+
+		n, err := conn.Read(buf)
+		_ = conn.Close()
+		if err != nil || n < dnsHeaderSize || buf[0] != query[0] || buf[1] != query[1] {
+			continue
+		}
+
+		if n > dnsMaxReply {
+			return dnsTruncatedReply(query, buf[:n])
+		}
+		return append([]byte{}, buf[:n]...)
+	}
+
+	return dnsErrorReply(query, dnsRcodeFail)
+}
+
+// parseDNSQuestion parses the first question of a DNS message
+func parseDNSQuestion(msg []byte) (name string, qtype, qclass uint16, end int, err error) {
+	var labels []string
+	pos := dnsHeaderSize
+
+	for {
+		if pos >= len(msg) {
+			return "", 0, 0, 0, fmt.Errorf("truncated question name")
+		}
+		length := int(msg[pos])
+		if length == 0 {
+			pos++
+			break
+		}
+		if length&0xc0 != 0 || pos+1+length > len(msg) {
+			return "", 0, 0, 0, fmt.Errorf("unsupported or truncated label")
+		}
+		labels = append(labels, string(msg[pos+1:pos+1+length]))
+		pos += 1 + length
+	}
+
+	if pos+4 > len(msg) {
+		return "", 0, 0, 0, fmt.Errorf("truncated question")
+	}
+
+	qtype = binary.BigEndian.Uint16(msg[pos : pos+2])
+	qclass = binary.BigEndian.Uint16(msg[pos+2 : pos+4])
+	return normalizeDNSName(strings.Join(labels, ".")), qtype, qclass, pos + 4, nil
+}
+
+// dnsErrorReply builds a reply carrying only the header with an error code
+func dnsErrorReply(query []byte, rcode uint8) []byte {
+	reply := make([]byte, dnsHeaderSize)
+	copy(reply, query[:dnsHeaderSize])
+	reply[2] = 0x80 | query[2]&0x79
+	reply[3] = 0x80 | rcode
+	return reply
+}
+
+// dnsTruncatedReply builds a truncated reply for responses too large to
+// deliver, prompting the client to retry over TCP
+func dnsTruncatedReply(query, response []byte) []byte {
+	reply := dnsErrorReply(query, response[3]&0x0f)
+	reply[2] |= 0x02 // TC
+	return reply
+}
+
+// normalizeDNSName lowercases a name and strips the trailing dot
+func normalizeDNSName(name string) string {
+	return strings.TrimSuffix(strings.ToLower(name), ".")
+}
+
+// systemDNSUpstreams returns the nameservers listed in a resolv.conf file
+func systemDNSUpstreams(path string) ([]string, error) {
+	file, err := os.Open(path) // #nosec G304 - fixed system path
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = file.Close() }()
+
+	var upstreams []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "nameserver" {
+			// Strip any IPv6 zone, which the host cannot dial from here anyway
+			host, _, _ := strings.Cut(fields[1], "%")
+			upstreams = append(upstreams, net.JoinHostPort(host, "53"))
+		}
+	}
+
+	return upstreams, scanner.Err()
+}
@@ -0,0 +1,206 @@
+package vswitch
+
+import (
+	"encoding/binary"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// dnsQuery builds a DNS query for a single name
+func dnsQuery(id uint16, name string, qtype uint16) []byte {
+	msg := make([]byte, dnsHeaderSize)
+	binary.BigEndian.PutUint16(msg[0:2], id)
+	msg[2] = 0x01 // RD
+	binary.BigEndian.PutUint16(msg[4:6], 1)
+
+	for _, label := range strings.Split(name, ".") {
+		msg = append(msg, byte(len(label)))
+		msg = append(msg, label...)
+	}
+	msg = append(msg, 0, byte(qtype>>8), byte(qtype), 0, dnsClassIN)
+	return msg
+}
+
+// sendDNSQuery delivers a query from the guest and returns the DNS reply payloads
+func sendDNSQuery(t *testing.T, sw *VirtualSwitch, stack *hostStack, conn *Connection, dnsAddr net.IP, query []byte) {
+	t.Helper()
+
+	guestMAC := net.HardwareAddr{0x52, 0x54, 0x00, 0x00, 0x00, 0x01}
+	sw.learnMAC(guestMAC, conn)
+	packet := buildUDPv4(net.ParseIP("10.0.0.10"), dnsAddr, 40000, dnsPort, query)
+	_ = sw.processFrame(rawFrame(buildEthernet(stack.mac, guestMAC, EtherTypeIPv4, packet)), conn)
+}
+
+// dnsReplies extracts DNS payloads from frames written to a connection
+func dnsReplies(t *testing.T, data []byte) [][]byte {
+	t.Helper()
+
+	var replies [][]byte
+	for _, raw := range writtenFrames(t, data) {
+		ip, err := parseIPv4(rawFrame(raw).Payload)
+		if err != nil {
+			continue
+		}
+		udp, err := parseUDP(ip.Payload)
+		if err != nil || udp.SrcPort != dnsPort {
+			continue
+		}
+		replies = append(replies, udp.Payload)
+	}
+	return replies
+}
+
+func TestDNSForwarderStaticRecords(t *testing.T) {
+	dnsAddr := net.ParseIP("10.0.0.53")
+	sw, stack, conn, mockConn := newTestStack(t, dnsAddr)
+	dns := newDNSForwarder(stack, []string{"127.0.0.1:1"}, map[string]net.IP{
+		"Gateway.Lab.": net.ParseIP("10.0.0.1"),
+	})
+	stack.handleUDP(dnsPort, dns.handleQuery)
+
+	sendDNSQuery(t, sw, stack, conn, dnsAddr, dnsQuery(0x1234, "gateway.lab", dnsTypeA))
+	sendDNSQuery(t, sw, stack, conn, dnsAddr, dnsQuery(0x1235, "gateway.lab", dnsTypeAAAA))
+
+	replies := dnsReplies(t, mockConn.writeData)
+	if len(replies) != 2 {
+		t.Fatalf("Expected 2 replies, got %d", len(replies))
+	}
+
+	reply := replies[0]
+	if binary.BigEndian.Uint16(reply[0:2]) != 0x1234 || reply[2]&0x84 != 0x84 || reply[3]&0x0f != dnsRcodeOK {
+		t.Errorf("Unexpected reply header % x", reply[:dnsHeaderSize])
+	}
+
+	if binary.BigEndian.Uint16(reply[6:8]) != 1 {
+		t.Fatalf("Expected 1 answer, got %d", binary.BigEndian.Uint16(reply[6:8]))
+	}
+
+	if ip := net.IP(reply[len(reply)-4:]); !ip.Equal(net.ParseIP("10.0.0.1")) {
+		t.Errorf("Expected answer 10.0.0.1, got %s", ip)
+	}
+
+	// AAAA for an IPv4-only record is answered with no data
+	if binary.BigEndian.Uint16(replies[1][6:8]) != 0 || replies[1][3]&0x0f != dnsRcodeOK {
+		t.Errorf("Expected empty NOERROR reply for AAAA query")
+	}
+}
+
+func TestDNSForwarderUpstream(t *testing.T) {
+	upstream, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to start upstream: %v", err)
+	}
+	defer func() { _ = upstream.Close() }()
+
+	// Minimal upstream that echoes the query back as a reply
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := upstream.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			buf[2] |= 0x80
+			_, _ = upstream.WriteTo(buf[:n], addr)
+		}
+	}()
+
+	dnsAddr := net.ParseIP("10.0.0.53")
+	sw, stack, conn, mockConn := newTestStack(t, dnsAddr)
+	dns := newDNSForwarder(stack, []string{upstream.LocalAddr().String()}, nil)
+	stack.handleUDP(dnsPort, dns.handleQuery)
+
+	sendDNSQuery(t, sw, stack, conn, dnsAddr, dnsQuery(0x4321, "example.com", dnsTypeA))
+
+	// Wait for the asynchronous relay by claiming every inflight slot
+	timeout := time.After(5 * time.Second)
+	for i := 0; i < cap(dns.inflight); i++ {
+		select {
+		case dns.inflight <- struct{}{}:
+		case <-timeout:
+			t.Fatalf("Timed out waiting for relayed reply")
+		}
+	}
+
+	replies := dnsReplies(t, mockConn.writeData)
+	if len(replies) != 1 || binary.BigEndian.Uint16(replies[0][0:2]) != 0x4321 || replies[0][2]&0x80 == 0 {
+		t.Errorf("Expected relayed reply for query 0x4321, got %d replies", len(replies))
+	}
+}
+
+func TestDNSForwarderStop(t *testing.T) {
+	upstream, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to start upstream: %v", err)
+	}
+	defer func() { _ = upstream.Close() }()
+
+	// Slow upstream that answers once the switch stopped
+	go func() {
+		buf := make([]byte, 512)
+		n, addr, err := upstream.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		time.Sleep(200 * time.Millisecond)
+		buf[2] |= 0x80
+		_, _ = upstream.WriteTo(buf[:n], addr)
+	}()
+
+	dnsAddr := net.ParseIP("10.0.0.53")
+	sw, stack, conn, mockConn := newTestStack(t, dnsAddr)
+	dns := newDNSForwarder(stack, []string{upstream.LocalAddr().String()}, nil)
+	stack.handleUDP(dnsPort, dns.handleQuery)
+
+	sendDNSQuery(t, sw, stack, conn, dnsAddr, dnsQuery(0x4321, "example.com", dnsTypeA))
+	close(sw.shutdown)
+	sw.wg.Wait()
+
+	if len(dns.inflight) != 0 {
+		t.Error("Expected stopping to wait for the relay")
+	}
+	if replies := dnsReplies(t, mockConn.writeData); len(replies) != 0 {
+		t.Errorf("Expected no reply after stopping, got %d", len(replies))
+	}
+}
+
+func TestParseDNSQuestion(t *testing.T) {
+	name, qtype, qclass, end, err := parseDNSQuestion(dnsQuery(1, "Www.Example.COM", dnsTypeAAAA))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if name != "www.example.com" || qtype != dnsTypeAAAA || qclass != dnsClassIN || end != 33 {
+		t.Errorf("Unexpected question %s type %d class %d end %d", name, qtype, qclass, end)
+	}
+
+	query := dnsQuery(1, "example.com", dnsTypeA)
+	if _, _, _, _, err := parseDNSQuestion(query[:len(query)-2]); err == nil {
+		t.Errorf("Expected error for truncated question")
+	}
+}
+
+func TestSystemDNSUpstreams(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "resolv.conf")
+	content := "# comment\nsearch lab\nnameserver 192.0.2.1\nnameserver fe80::1%eth0\nnameserver 2001:db8::53\n"
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("Failed to write resolv.conf: %v", err)
+	}
+
+	upstreams, err := systemDNSUpstreams(path)
+	if err != nil {
+		t.Fatalf("Failed to read resolv.conf: %v", err)
+	}
+	expected := []string{"192.0.2.1:53", "[fe80::1]:53", "[2001:db8::53]:53"}
+	if strings.Join(upstreams, ",") != strings.Join(expected, ",") {
+		t.Errorf("Expected %v, got %v", expected, upstreams)
+	}
+
+	if upstreams, err := systemDNSUpstreams(filepath.Join(t.TempDir(), "missing")); upstreams != nil || err == nil {
+		t.Errorf("Expected an error and no upstreams from a missing file")
+	}
+}
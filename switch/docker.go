@@ -0,0 +1,304 @@
+package vswitch
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	// dockerPluginContentType is the media type of the Docker plugin API
+	dockerPluginContentType = "application/vnd.docker.plugins.v1.2+json"
+
+	// dockerGenericOption holds the -o options of "docker network create"
+	dockerGenericOption = "com.docker.network.generic"
+
+	// dockerVLANOption selects the VLAN a Docker network is attached to
+	dockerVLANOption = "vlan"
+)
+
+// dockerNetwork is a Docker network backed by a VLAN
+type dockerNetwork struct {
+	port    int
+	gateway string
+}
+
+// dockerEndpoint is a container interface on a Docker network
+type dockerEndpoint struct {
+	networkID string
+	mac       string
+	connID    string // set while the container is joined
+}
+
+// DockerDriver implements the Docker libnetwork remote network driver API,
+// attaching containers to VLANs next to the VMs on them. A network created
+// with "docker network create -d vswitch -o vlan=PORT" is backed by the VLAN
+// listening on PORT, and each container joining it gets a TAP device that
+// Docker moves into the container's namespace while the switch forwards
+// its frames like those of any other connection.
+type DockerDriver struct {
+	sm        *SwitchManager
+	mux       *http.ServeMux
+	networks  map[string]*dockerNetwork
+	endpoints map[string]*dockerEndpoint
+	mutex     sync.Mutex
+}
+
+// NewDockerDriver creates a Docker network driver for the manager's VLANs
+func NewDockerDriver(sm *SwitchManager) *DockerDriver {
+	d := &DockerDriver{
+		sm:        sm,
+		mux:       http.NewServeMux(),
+		networks:  make(map[string]*dockerNetwork),
+		endpoints: make(map[string]*dockerEndpoint),
+	}
+
+	d.mux.HandleFunc("POST /Plugin.Activate", func(w http.ResponseWriter, _ *http.Request) {
+		writePluginJSON(w, http.StatusOK, map[string][]string{"Implements": {"NetworkDriver"}})
+	})
+	d.mux.HandleFunc("POST /NetworkDriver.GetCapabilities", func(w http.ResponseWriter, _ *http.Request) {
+		writePluginJSON(w, http.StatusOK, map[string]string{"Scope": "local", "ConnectivityScope": "local"})
+	})
+	d.handle("CreateNetwork", d.createNetwork)
+	d.handle("DeleteNetwork", d.deleteNetwork)
+	d.handle("CreateEndpoint", d.createEndpoint)
+	d.handle("DeleteEndpoint", d.deleteEndpoint)
+	d.handle("EndpointOperInfo", d.endpointInfo)
+	d.handle("Join", d.join)
+	d.handle("Leave", d.leave)
+
+	// Discovery and external connectivity do not apply to a local L2 driver
+	for _, name := range []string{"DiscoverNew", "DiscoverDelete", "ProgramExternalConnectivity", "RevokeExternalConnectivity"} {
+		d.handle(name, func(dockerRequest) (interface{}, error) {
+			return struct{}{}, nil
+		})
+	}
+
+	return d
+}
+
+// ServeHTTP serves the plugin API
+func (d *DockerDriver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	d.mux.ServeHTTP(w, r)
+}
+
+// dockerRequest carries the fields of the network driver requests
+type dockerRequest struct {
+	NetworkID  string
+	EndpointID string
+	Options    map[string]interface{}
+	IPv4Data   []struct {
+		Gateway string
+	}
+	Interface *struct {
+		MacAddress string
+	}
+}
+
+// handle routes a network driver method, replying with its result or with
+// its error in the form Docker expects
+func (d *DockerDriver) handle(method string, fn func(dockerRequest) (interface{}, error)) {
+	d.mux.HandleFunc("POST /NetworkDriver."+method, func(w http.ResponseWriter, r *http.Request) {
+		var request dockerRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			writePluginJSON(w, http.StatusBadRequest, map[string]string{"Err": fmt.Sprintf("invalid request: %v", err)})
+			return
+		}
+
+		response, err := fn(request)
+		if err != nil {
+			d.sm.logger().Warn("Docker network driver request failed", "method", method, "error", err)
+			writePluginJSON(w, http.StatusInternalServerError, map[string]string{"Err": err.Error()})
+			return
+		}
+		writePluginJSON(w, http.StatusOK, response)
+	})
+}
+
+// createNetwork binds a network to the VLAN named by its vlan option
+func (d *DockerDriver) createNetwork(request dockerRequest) (interface{}, error) {
+	generic, _ := request.Options[dockerGenericOption].(map[string]interface{})
+	value, found := generic[dockerVLANOption]
+	if !found {
+		return nil, fmt.Errorf("missing option %s=PORT", dockerVLANOption)
+	}
+	port, err := strconv.Atoi(fmt.Sprint(value))
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s option %q", dockerVLANOption, fmt.Sprint(value))
+	}
+	if _, err := d.sm.GetVLANStats(port); err != nil {
+		return nil, err
+	}
+
+	network := &dockerNetwork{port: port}
+	if len(request.IPv4Data) > 0 {
+		network.gateway, _, _ = strings.Cut(request.IPv4Data[0].Gateway, "/")
+	}
+
+	d.mutex.Lock()
+	d.networks[request.NetworkID] = network
+	d.mutex.Unlock()
+
+	d.sm.logger().Info("Docker network created", "network", request.NetworkID, "vlan", port)
+	return struct{}{}, nil
+}
+
+// deleteNetwork forgets a network
+func (d *DockerDriver) deleteNetwork(request dockerRequest) (interface{}, error) {
+	d.mutex.Lock()
+	delete(d.networks, request.NetworkID)
+	d.mutex.Unlock()
+
+	d.sm.logger().Info("Docker network deleted", "network", request.NetworkID)
+	return struct{}{}, nil
+}
+
+// createEndpoint records a container interface, choosing its MAC address
+// unless Docker was given one
+func (d *DockerDriver) createEndpoint(request dockerRequest) (interface{}, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if _, found := d.networks[request.NetworkID]; !found {
+		return nil, fmt.Errorf("network %s not found", request.NetworkID)
+	}
+
+	endpoint := &dockerEndpoint{networkID: request.NetworkID}
+	response := map[string]interface{}{}
+	if request.Interface != nil && request.Interface.MacAddress != "" {
+		endpoint.mac = request.Interface.MacAddress
+	} else {
+		mac, err := RandomMAC()
+		if err != nil {
+			return nil, err
+		}
+		endpoint.mac = mac.String()
+		// Docker rejects values it already set, so only the MAC is returned
+		response["Interface"] = map[string]string{"MacAddress": endpoint.mac}
+	}
+	d.endpoints[request.EndpointID] = endpoint
+
+	return response, nil
+}
+
+// deleteEndpoint forgets a container interface
+func (d *DockerDriver) deleteEndpoint(request dockerRequest) (interface{}, error) {
+	d.mutex.Lock()
+	delete(d.endpoints, request.EndpointID)
+	d.mutex.Unlock()
+	return struct{}{}, nil
+}
+
+// endpointInfo reports the details of a container interface
+func (d *DockerDriver) endpointInfo(request dockerRequest) (interface{}, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	endpoint, found := d.endpoints[request.EndpointID]
+	if !found {
+		return nil, fmt.Errorf("endpoint %s not found", request.EndpointID)
+	}
+	value := map[string]string{"mac": endpoint.mac}
+	if endpoint.connID != "" {
+		value["connection"] = endpoint.connID
+	}
+	return map[string]interface{}{"Value": value}, nil
+}
+
+// join creates the TAP device of a container interface, or the veth pair
+// of one on a VLAN with the XDP fast path, and connects it to the
+// network's VLAN. Docker moves the device, or the veth's peer, into the
+// container and sets its addresses.
+func (d *DockerDriver) join(request dockerRequest) (interface{}, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	endpoint, found := d.endpoints[request.EndpointID]
+	if !found {
+		return nil, fmt.Errorf("endpoint %s not found", request.EndpointID)
+	}
+	network, found := d.networks[endpoint.networkID]
+	if !found {
+		return nil, fmt.Errorf("network %s not found", endpoint.networkID)
+	}
+
+	name := "vsw" + shortID(request.EndpointID, 12)
+	srcName := name
+	var conn net.Conn
+	var err error
+	if vs := d.sm.vlan(network.port); vs != nil && vs.fastPath != nil {
+		srcName = "vsc" + shortID(request.EndpointID, 12)
+		if conn, err = vs.fastPath.openVeth(name, srcName); err != nil {
+			return nil, fmt.Errorf("failed to create veth %s: %v", name, err)
+		}
+	} else if conn, err = openTAP(name); err != nil {
+		return nil, fmt.Errorf("failed to create TAP device %s: %v", name, err)
+	}
+	connID := "docker-" + shortID(request.EndpointID, 12)
+	if err := d.sm.Attach(network.port, connID, conn); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	endpoint.connID = connID
+
+	response := map[string]interface{}{
+		"InterfaceName": map[string]string{"SrcName": srcName, "DstPrefix": "eth"},
+	}
+	if network.gateway != "" {
+		response["Gateway"] = network.gateway
+	}
+	return response, nil
+}
+
+// leave disconnects a container interface from its VLAN, which removes its
+// TAP device or veth pair
+func (d *DockerDriver) leave(request dockerRequest) (interface{}, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	endpoint, found := d.endpoints[request.EndpointID]
+	if !found {
+		return nil, fmt.Errorf("endpoint %s not found", request.EndpointID)
+	}
+	if endpoint.connID == "" {
+		return struct{}{}, nil
+	}
+	if network, found := d.networks[endpoint.networkID]; found {
+		// The connection is already gone when the container's namespace
+		// was destroyed first
+		_, _ = d.sm.Kick(network.port, endpoint.connID)
+	}
+	endpoint.connID = ""
+
+	return struct{}{}, nil
+}
+
+// shortID truncates a Docker ID to n characters
+func shortID(id string, n int) string {
+	if len(id) > n {
+		return id[:n]
+	}
+	return id
+}
+
+// RandomMAC returns a random locally administered unicast MAC address
+func RandomMAC() (net.HardwareAddr, error) {
+	mac := make(net.HardwareAddr, 6)
+	if _, err := rand.Read(mac); err != nil {
+		return nil, err
+	}
+	mac[0] = mac[0]&0xfe | 0x02
+	return mac, nil
+}
+
+// writePluginJSON writes a plugin API response
+func writePluginJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", dockerPluginContentType)
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(value)
+}
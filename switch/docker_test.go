@@ -0,0 +1,91 @@
+package vswitch
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// pluginCall posts a plugin API request and decodes the JSON response
+func pluginCall(t *testing.T, handler http.Handler, path, body string) (int, map[string]interface{}) {
+	t.Helper()
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, path, strings.NewReader(body)))
+
+	if contentType := recorder.Header().Get("Content-Type"); contentType != dockerPluginContentType {
+		t.Errorf("%s: expected plugin content type, got %q", path, contentType)
+	}
+	var response map[string]interface{}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		t.Fatalf("%s: invalid JSON response: %v", path, err)
+	}
+
+	return recorder.Code, response
+}
+
+func TestDockerDriver(t *testing.T) {
+	sm := NewSwitchManager()
+	if err := sm.AddVLAN(9999); err != nil {
+		t.Fatalf("Failed to add VLAN: %v", err)
+	}
+	driver := NewDockerDriver(sm)
+
+	code, response := pluginCall(t, driver, "/Plugin.Activate", "{}")
+	if implements, _ := response["Implements"].([]interface{}); code != http.StatusOK || len(implements) != 1 || implements[0] != "NetworkDriver" {
+		t.Errorf("Unexpected activation response %d: %v", code, response)
+	}
+	if code, response = pluginCall(t, driver, "/NetworkDriver.GetCapabilities", "{}"); code != http.StatusOK || response["Scope"] != "local" {
+		t.Errorf("Unexpected capabilities %d: %v", code, response)
+	}
+
+	// Networks must name an existing VLAN
+	if code, response = pluginCall(t, driver, "/NetworkDriver.CreateNetwork", `{"NetworkID": "n1", "Options": {}}`); code != http.StatusInternalServerError || response["Err"] == nil {
+		t.Errorf("Expected a network without VLAN to fail, got %d: %v", code, response)
+	}
+	if code, _ = pluginCall(t, driver, "/NetworkDriver.CreateNetwork", `{"NetworkID": "n1", "Options": {"com.docker.network.generic": {"vlan": "8888"}}}`); code != http.StatusInternalServerError {
+		t.Errorf("Expected a network on a missing VLAN to fail, got %d", code)
+	}
+	network := `{"NetworkID": "n1", "Options": {"com.docker.network.generic": {"vlan": "9999"}}, "IPv4Data": [{"Pool": "10.0.2.0/24", "Gateway": "10.0.2.2/24"}]}`
+	if code, response = pluginCall(t, driver, "/NetworkDriver.CreateNetwork", network); code != http.StatusOK {
+		t.Fatalf("Failed to create network %d: %v", code, response)
+	}
+	if got := driver.networks["n1"]; got.port != 9999 || got.gateway != "10.0.2.2" {
+		t.Errorf("Expected network on VLAN 9999 with gateway 10.0.2.2, got %+v", got)
+	}
+
+	// The driver picks a MAC address unless Docker has one
+	code, response = pluginCall(t, driver, "/NetworkDriver.CreateEndpoint", `{"NetworkID": "n1", "EndpointID": "e1", "Interface": {"Address": "10.0.2.15/24"}}`)
+	iface, _ := response["Interface"].(map[string]interface{})
+	mac, err := net.ParseMAC(iface["MacAddress"].(string))
+	if code != http.StatusOK || err != nil || mac[0]&0x03 != 0x02 {
+		t.Errorf("Expected a locally administered MAC address, got %d: %v", code, response)
+	}
+	code, response = pluginCall(t, driver, "/NetworkDriver.CreateEndpoint", `{"NetworkID": "n1", "EndpointID": "e2", "Interface": {"MacAddress": "52:54:00:00:00:02"}}`)
+	if code != http.StatusOK || response["Interface"] != nil {
+		t.Errorf("Expected no interface for an endpoint with a MAC address, got %d: %v", code, response)
+	}
+	code, response = pluginCall(t, driver, "/NetworkDriver.EndpointOperInfo", `{"NetworkID": "n1", "EndpointID": "e2"}`)
+	if value, _ := response["Value"].(map[string]interface{}); code != http.StatusOK || value["mac"] != "52:54:00:00:00:02" {
+		t.Errorf("Unexpected endpoint info %d: %v", code, response)
+	}
+
+	if code, _ = pluginCall(t, driver, "/NetworkDriver.Join", `{"NetworkID": "n1", "EndpointID": "missing"}`); code != http.StatusInternalServerError {
+		t.Errorf("Expected joining a missing endpoint to fail, got %d", code)
+	}
+	if code, _ = pluginCall(t, driver, "/NetworkDriver.Leave", `{"NetworkID": "n1", "EndpointID": "e1"}`); code != http.StatusOK {
+		t.Errorf("Expected leaving an unjoined endpoint to succeed, got %d", code)
+	}
+	if code, _ = pluginCall(t, driver, "/NetworkDriver.DiscoverNew", `{}`); code != http.StatusOK {
+		t.Errorf("Expected discovery to be accepted, got %d", code)
+	}
+
+	pluginCall(t, driver, "/NetworkDriver.DeleteEndpoint", `{"NetworkID": "n1", "EndpointID": "e1"}`)
+	pluginCall(t, driver, "/NetworkDriver.DeleteNetwork", `{"NetworkID": "n1"}`)
+	if code, _ = pluginCall(t, driver, "/NetworkDriver.CreateEndpoint", `{"NetworkID": "n1", "EndpointID": "e3"}`); code != http.StatusInternalServerError {
+		t.Errorf("Expected an endpoint on a deleted network to fail, got %d", code)
+	}
+}
@@ -0,0 +1,76 @@
+package vswitch
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// drainPollInterval is how often Shutdown checks whether the clients closed
+// their half-closed connections
+const drainPollInterval = 10 * time.Millisecond
+
+// Shutdown stops the switch gracefully, unlike Stop, which closes the
+// connections in the middle of whatever they were sending. It stops
+// accepting connections and forwarding the frames it receives, waits until
+// the frames queued for each connection were written and then closes it,
+// or with DrainFIN half-closes it and waits for the client to close its
+// end. Once done, or when ctx is, it stops the switch like Stop and returns
+// ctx's error if the drain did not complete.
+func (vs *VirtualSwitch) Shutdown(ctx context.Context) error {
+	if vs.draining.Swap(true) {
+		// Already draining
+		vs.Stop()
+		return nil
+	}
+	close(vs.drain)
+	vs.syncFastPath()
+	vs.logger.Info("Draining connections", "connections", vs.connectionCount(), "fin", vs.config.DrainFIN)
+
+	var wg sync.WaitGroup
+	vs.connections.Range(func(_, value interface{}) bool {
+		conn := value.(*Connection)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			vs.drainConnection(ctx, conn)
+		}()
+		return true
+	})
+	wg.Wait()
+
+	// Half-closed connections go once their clients close them
+	if vs.config.DrainFIN {
+		ticker := time.NewTicker(drainPollInterval)
+		for vs.connectionCount() > 0 && ctx.Err() == nil {
+			select {
+			case <-ctx.Done():
+			case <-ticker.C:
+			}
+		}
+		ticker.Stop()
+	}
+
+	err := ctx.Err()
+	if err != nil {
+		vs.logger.Warn("Connections still open after drain timeout", "count", vs.connectionCount())
+	}
+	vs.Stop()
+	return err
+}
+
+// drainConnection flushes the egress queue of a connection and closes it,
+// or half-closes it with DrainFIN
+func (vs *VirtualSwitch) drainConnection(ctx context.Context, conn *Connection) {
+	if err := conn.flush(ctx); err != nil {
+		vs.logger.Debug("Failed to flush connection", "connection", conn.ID, "error", err)
+		return
+	}
+	if !vs.config.DrainFIN {
+		_ = conn.Close()
+		return
+	}
+	if err := conn.closeWrite(); err != nil {
+		vs.logger.Debug("Failed to half-close connection", "connection", conn.ID, "error", err)
+	}
+}
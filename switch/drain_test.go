@@ -0,0 +1,112 @@
+package vswitch
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestShutdownFlushesQueues(t *testing.T) {
+	sw := NewVirtualSwitchWithConfig([]int{9999}, Config{InMemory: true, EgressQueueSize: 64})
+	if err := sw.Start(context.Background()); err != nil {
+		t.Fatalf("Failed to start switch: %v", err)
+	}
+	defer sw.Stop()
+
+	sender, receiver := sw.Dial("sender"), sw.Dial("receiver")
+	go func() { _, _ = io.Copy(io.Discard, sender) }()
+	waitUntil(t, "both guests are connected", func() bool { return sw.connectionCount() == 2 })
+
+	// The receiver does not read yet, so the broadcasts wait in its queue
+	mac := net.HardwareAddr{0x52, 0x54, 0x00, 0x00, 0x00, 0x01}
+	const frames = 10
+	for i := 0; i < frames; i++ {
+		if _, err := sender.Write(qemuFrame(buildEthernet(BroadcastMAC, mac, EtherTypeARP, make([]byte, 46)))); err != nil {
+			t.Fatalf("Failed to send frame: %v", err)
+		}
+	}
+	waitUntil(t, "the frames are forwarded", func() bool { return sw.GetStats()["total_frames"] == uint64(frames) })
+
+	done := make(chan error, 1)
+	go func() { done <- sw.Shutdown(context.Background()) }()
+	waitUntil(t, "the switch drains", func() bool { return !sw.Health().Ready })
+
+	// Every queued frame arrives before the connection closes
+	_ = receiver.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for i := 0; i < frames; i++ {
+		readGuestFrame(t, receiver)
+	}
+	if _, err := receiver.Read(make([]byte, 1)); !errors.Is(err, io.EOF) {
+		t.Errorf("Expected the connection to close after the queued frames, got %v", err)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Expected the drain to complete, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the shutdown")
+	}
+
+	// Frames arriving while draining are dropped
+	late := NewConnection("late", &frameSink{})
+	if err := sw.processFrame(rawFrame(buildEthernet(BroadcastMAC, mac, EtherTypeARP, make([]byte, 46))), late); !errors.Is(err, errDraining) {
+		t.Errorf("Expected a frame to be dropped while draining, got %v", err)
+	}
+}
+
+func TestShutdownFIN(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	sw := NewVirtualSwitchWithConfig([]int{9999}, Config{
+		Listen:          func(int) (net.Listener, error) { return listener, nil },
+		EgressQueueSize: 16,
+		DrainFIN:        true,
+	})
+	if err := sw.Start(context.Background()); err != nil {
+		t.Fatalf("Failed to start switch: %v", err)
+	}
+	defer sw.Stop()
+
+	client, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer func() { _ = client.Close() }()
+	waitUntil(t, "the client is connected", func() bool { return sw.connectionCount() == 1 })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- sw.Shutdown(ctx) }()
+
+	// The client sees the end of the stream, and the listener is closed
+	_ = client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := client.Read(make([]byte, 1)); !errors.Is(err, io.EOF) {
+		t.Fatalf("Expected a FIN, got %v", err)
+	}
+	if conn, err := net.Dial("tcp", listener.Addr().String()); err == nil {
+		_ = conn.Close()
+		t.Errorf("Expected the switch to stop accepting")
+	}
+	select {
+	case <-done:
+		t.Fatal("Expected the switch to wait for the client to close its end")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	_ = client.Close()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Expected the drain to complete, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the shutdown")
+	}
+}
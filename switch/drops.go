@@ -0,0 +1,100 @@
+package vswitch
+
+import (
+	"errors"
+)
+
+// dropReason is why the switch dropped a frame
+type dropReason int
+
+const (
+	dropOther dropReason = iota
+	dropParseError
+	dropValidation
+	dropMTUExceeded
+	dropQueueOverflow
+	dropACLDeny
+	dropHook
+	dropStormControl
+	dropUnknownUnicast
+	dropDisabled
+	dropDraining
+	dropBandwidth
+	dropReasonCount
+)
+
+// dropReasonNames names the reasons in the statistics
+var dropReasonNames = [dropReasonCount]string{
+	dropOther:          "other",
+	dropParseError:     "parse_error",
+	dropValidation:     "validation",
+	dropMTUExceeded:    "mtu_exceeded",
+	dropQueueOverflow:  "queue_overflow",
+	dropACLDeny:        "acl_deny",
+	dropHook:           "hook",
+	dropStormControl:   "storm_control",
+	dropUnknownUnicast: "unknown_unicast",
+	dropDisabled:       "disabled",
+	dropDraining:       "draining",
+	dropBandwidth:      "bandwidth",
+}
+
+// dropError is the error of a frame dropped for a known reason
+type dropError struct {
+	reason dropReason
+	err    error
+}
+
+// Error returns the message of the underlying error
+func (e *dropError) Error() string {
+	return e.err.Error()
+}
+
+// Unwrap returns the underlying error
+func (e *dropError) Unwrap() error {
+	return e.err
+}
+
+// dropped tags an error with the reason the frame was dropped
+func dropped(reason dropReason, err error) error {
+	return &dropError{reason: reason, err: err}
+}
+
+// sendDropReason classifies the error of sending a frame to a connection
+func sendDropReason(err error) dropReason {
+	switch {
+	case errors.Is(err, errQueueFull):
+		return dropQueueOverflow
+	case errors.Is(err, errPortDisabled):
+		return dropDisabled
+	case errors.Is(err, errDraining):
+		return dropDraining
+	default:
+		return dropOther
+	}
+}
+
+// dropFrame counts a frame dropped for the reason err carries
+func (vs *VirtualSwitch) dropFrame(err error) {
+	reason := dropOther
+	var dropErr *dropError
+	if errors.As(err, &dropErr) {
+		reason = dropErr.reason
+	}
+	vs.countDrop(reason)
+}
+
+// countDrop counts a dropped frame
+func (vs *VirtualSwitch) countDrop(reason dropReason) {
+	vs.droppedFrames.Add(1)
+	vs.drops[reason].Add(1)
+}
+
+// dropCounts returns the number of frames dropped for each reason
+func (vs *VirtualSwitch) dropCounts() map[string]uint64 {
+	counts := make(map[string]uint64, dropReasonCount)
+	for reason, name := range dropReasonNames {
+		counts[name] = vs.drops[reason].Load()
+	}
+	return counts
+}
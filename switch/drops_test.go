@@ -0,0 +1,115 @@
+package vswitch
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestDropReasons(t *testing.T) {
+	sw := NewVirtualSwitchWithConfig([]int{8080}, Config{StickyMAC: true, DropUnknownUnicast: true})
+	sw.AddIngressHook(func(frame *EthernetFrame, _ *Connection) Verdict {
+		if frame.EtherType == 0x88b5 {
+			return VerdictDrop
+		}
+		return VerdictPass
+	})
+
+	conn1 := NewConnection("conn1", &mockConnSwitch{addr: &mockAddrSwitch{network: "tcp", address: "127.0.0.1:9001"}})
+	conn2 := NewConnection("conn2", &mockConnSwitch{addr: &mockAddrSwitch{network: "tcp", address: "127.0.0.1:9002"}})
+	sw.connections.Store("conn1", conn1)
+	sw.connections.Store("conn2", conn2)
+
+	mac1 := net.HardwareAddr{0x52, 0x54, 0x00, 0x00, 0x00, 0x01}
+	mac2 := net.HardwareAddr{0x52, 0x54, 0x00, 0x00, 0x00, 0x02}
+	sw.handleFrame(rawFrame(buildEthernet(BroadcastMAC, mac1, EtherTypeARP, make([]byte, 46))), conn1)
+
+	frames := []struct {
+		reason string
+		raw    []byte
+		conn   *Connection
+	}{
+		{"mtu_exceeded", buildEthernet(BroadcastMAC, mac1, EtherTypeIPv4, make([]byte, 2000)), conn1},
+		{"validation", buildEthernet(BroadcastMAC, make(net.HardwareAddr, 6), EtherTypeIPv4, make([]byte, 46)), conn1},
+		{"unknown_unicast", buildEthernet(mac2, mac1, EtherTypeIPv4, make([]byte, 46)), conn1},
+		{"acl_deny", buildEthernet(BroadcastMAC, mac1, EtherTypeIPv4, make([]byte, 46)), conn2},
+		{"hook", buildEthernet(BroadcastMAC, mac1, 0x88b5, make([]byte, 46)), conn1},
+	}
+	for _, frame := range frames {
+		sw.handleFrame(rawFrame(frame.raw), frame.conn)
+	}
+
+	conn1.SetDisabled(true)
+	sw.handleFrame(rawFrame(buildEthernet(BroadcastMAC, mac1, EtherTypeARP, make([]byte, 46))), conn1)
+
+	stats := sw.GetStats()
+	reasons := stats["drop_reasons"].(map[string]uint64)
+	for _, frame := range frames {
+		if reasons[frame.reason] != 1 {
+			t.Errorf("Expected 1 %s drop, got %d", frame.reason, reasons[frame.reason])
+		}
+	}
+	if reasons["disabled"] != 1 {
+		t.Errorf("Expected 1 disabled drop, got %d", reasons["disabled"])
+	}
+
+	// The reasons add up to the dropped frames
+	total := uint64(0)
+	for _, count := range reasons {
+		total += count
+	}
+	if dropped := stats["dropped_frames"].(uint64); dropped != uint64(len(frames)+1) || total != dropped {
+		t.Errorf("Expected %d dropped frames broken down by reason, got %d and %v", len(frames)+1, dropped, reasons)
+	}
+}
+
+func TestDropQueueOverflow(t *testing.T) {
+	sw := NewVirtualSwitch([]int{8080})
+	source := NewConnection("source", &mockConnSwitch{addr: &mockAddrSwitch{network: "tcp", address: "127.0.0.1:9001"}})
+	sw.connections.Store("source", source)
+
+	// A receiver that never reads fills its queue
+	stalled, peer := net.Pipe()
+	defer func() { _ = peer.Close() }()
+	receiver := NewConnection("receiver", stalled)
+	receiver.StartWriter(1, SlowConsumerDropNew, time.Second)
+	defer func() { _ = receiver.Close() }()
+	sw.connections.Store("receiver", receiver)
+
+	src := net.HardwareAddr{0x52, 0x54, 0x00, 0x00, 0x00, 0x01}
+	for i := 0; i < 4; i++ {
+		sw.handleFrame(rawFrame(buildEthernet(BroadcastMAC, src, EtherTypeARP, make([]byte, 46))), source)
+	}
+
+	if overflows := sw.GetStats()["drop_reasons"].(map[string]uint64)["queue_overflow"]; overflows < 2 {
+		t.Errorf("Expected flooded frames to overflow the queue, got %d", overflows)
+	}
+}
+
+func TestDropParseError(t *testing.T) {
+	sw := NewVirtualSwitch([]int{8080})
+	guest, switchSide := net.Pipe()
+	defer func() { _ = guest.Close() }()
+	sw.attach("guest", switchSide, nil)
+	defer sw.Stop()
+
+	// A frame too short for an Ethernet header is dropped, and the
+	// connection goes on with the next frame
+	src := net.HardwareAddr{0x52, 0x54, 0x00, 0x00, 0x00, 0x01}
+	data := append(qemuFrame(make([]byte, 5)), qemuFrame(buildEthernet(BroadcastMAC, src, EtherTypeARP, make([]byte, 46)))...)
+	if _, err := guest.Write(data); err != nil {
+		t.Fatalf("Failed to send frames: %v", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for sw.totalFrames.Load() == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	stats := sw.GetStats()
+	if reasons := stats["drop_reasons"].(map[string]uint64); reasons["parse_error"] != 1 {
+		t.Errorf("Expected 1 parse error, got %v", reasons)
+	}
+	if total := stats["total_frames"].(uint64); total != 1 {
+		t.Errorf("Expected the frame after the malformed one to be received, got %d", total)
+	}
+}
@@ -0,0 +1,73 @@
+package vswitch
+
+// etherTypeClass groups frames by EtherType for the traffic counters
+type etherTypeClass int
+
+const (
+	etherTypeARP etherTypeClass = iota
+	etherTypeIPv4
+	etherTypeIPv6
+	etherTypeLLDP
+	etherTypeVLAN
+	etherTypeLLC
+	etherTypeSNAP
+	etherTypeOther
+	etherTypeClassCount
+)
+
+// etherTypeClassNames names the classes in the statistics
+var etherTypeClassNames = [etherTypeClassCount]string{
+	etherTypeARP:   "arp",
+	etherTypeIPv4:  "ipv4",
+	etherTypeIPv6:  "ipv6",
+	etherTypeLLDP:  "lldp",
+	etherTypeVLAN:  "vlan",
+	etherTypeLLC:   "llc",
+	etherTypeSNAP:  "snap",
+	etherTypeOther: "other",
+}
+
+// etherTypeClassOf returns the class of a frame; tagged frames count as
+// VLAN whatever they carry, and 802.3 frames by their LLC encapsulation
+func etherTypeClassOf(frame *EthernetFrame) etherTypeClass {
+	switch frame.Encapsulation {
+	case EncapLLC:
+		return etherTypeLLC
+	case EncapSNAP:
+		return etherTypeSNAP
+	case EncapRaw8023:
+		return etherTypeOther
+	}
+	switch frame.EtherType {
+	case EtherTypeARP:
+		return etherTypeARP
+	case EtherTypeIPv4:
+		return etherTypeIPv4
+	case EtherTypeIPv6:
+		return etherTypeIPv6
+	case EtherTypeLLDP:
+		return etherTypeLLDP
+	case EtherTypeVLAN, EtherTypeQinQ:
+		return etherTypeVLAN
+	default:
+		return etherTypeOther
+	}
+}
+
+// countEtherType counts a received frame and its bytes by EtherType
+func (vs *VirtualSwitch) countEtherType(frame *EthernetFrame) {
+	class := etherTypeClassOf(frame)
+	vs.etherTypeFrames[class].Add(1)
+	vs.etherTypeBytes[class].Add(uint64(len(frame.Raw)))
+}
+
+// etherTypeCounts returns the frames and bytes received of each class
+func (vs *VirtualSwitch) etherTypeCounts() (frames, bytes map[string]uint64) {
+	frames = make(map[string]uint64, etherTypeClassCount)
+	bytes = make(map[string]uint64, etherTypeClassCount)
+	for class, name := range etherTypeClassNames {
+		frames[name] = vs.etherTypeFrames[class].Load()
+		bytes[name] = vs.etherTypeBytes[class].Load()
+	}
+	return frames, bytes
+}
@@ -0,0 +1,48 @@
+package vswitch
+
+import (
+	"net"
+	"testing"
+)
+
+func TestEtherTypeCounters(t *testing.T) {
+	sw := NewVirtualSwitch([]int{8080})
+	conn := NewConnection("conn1", &mockConnSwitch{addr: &mockAddrSwitch{network: "tcp", address: "127.0.0.1:9001"}})
+	sw.connections.Store("conn1", conn)
+
+	src := net.HardwareAddr{0x52, 0x54, 0x00, 0x00, 0x00, 0x01}
+	lldp := net.HardwareAddr{0x01, 0x80, 0xc2, 0x00, 0x00, 0x0e}
+	frames := []struct {
+		class     string
+		etherType uint16
+		dst       net.HardwareAddr
+	}{
+		{"arp", EtherTypeARP, BroadcastMAC},
+		{"ipv4", EtherTypeIPv4, BroadcastMAC},
+		{"ipv4", EtherTypeIPv4, BroadcastMAC},
+		{"ipv6", EtherTypeIPv6, BroadcastMAC},
+		{"lldp", EtherTypeLLDP, lldp},
+		{"vlan", EtherTypeVLAN, BroadcastMAC},
+		{"vlan", EtherTypeQinQ, BroadcastMAC},
+		{"other", 0x88b5, BroadcastMAC},
+	}
+	for _, frame := range frames {
+		_ = sw.processFrame(rawFrame(buildEthernet(frame.dst, src, frame.etherType, make([]byte, 46))), conn)
+	}
+	stp := net.HardwareAddr{0x01, 0x80, 0xc2, 0x00, 0x00, 0x00}
+	_ = sw.processFrame(rawFrame(llcFrame(stp, src, []byte{0x42, 0x42, 0x03, 0x00})), conn)
+	_ = sw.processFrame(rawFrame(llcFrame(BroadcastMAC, src, []byte{0xaa, 0xaa, 0x03, 0x00, 0x00, 0x0c, 0x20, 0x00})), conn)
+
+	stats := sw.GetStats()
+	counts := stats["ethertype_frames"].(map[string]uint64)
+	bytes := stats["ethertype_bytes"].(map[string]uint64)
+	expected := map[string]uint64{"arp": 1, "ipv4": 2, "ipv6": 1, "lldp": 1, "vlan": 2, "llc": 1, "snap": 1, "other": 1}
+	for class, want := range expected {
+		if counts[class] != want {
+			t.Errorf("Expected %d %s frames, got %d", want, class, counts[class])
+		}
+		if bytes[class] != want*60 {
+			t.Errorf("Expected %d %s bytes, got %d", want*60, class, bytes[class])
+		}
+	}
+}
@@ -0,0 +1,227 @@
+package vswitch
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Switch event types
+const (
+	// EventConnectionAccepted reports a connection joining a VLAN
+	EventConnectionAccepted = "connection_accepted"
+	// EventConnectionClosed reports a connection leaving a VLAN
+	EventConnectionClosed = "connection_closed"
+	// EventConnectionResumed reports a client that presented its identity
+	// reconnecting and taking over its previous connection's state
+	EventConnectionResumed = "connection_resumed"
+	// EventConnectionIdentified reports a client presenting its identity
+	// or the VM behind it in a hello
+	EventConnectionIdentified = "connection_identified"
+	// EventConnectionRejected reports a connection refused at connect time
+	EventConnectionRejected = "connection_rejected"
+	// EventVLANAdded reports a VLAN created on the switch
+	EventVLANAdded = "vlan_added"
+	// EventVLANRemoved reports a VLAN removed from the switch
+	EventVLANRemoved = "vlan_removed"
+	// EventLimitViolation reports a connection sending frames its port
+	// settings forbid, such as spoofed MACs or rogue DHCP servers
+	EventLimitViolation = "limit_violation"
+	// EventStormControl reports storm control dropping flooded frames to
+	// a trunk link
+	EventStormControl = "storm_control"
+)
+
+// eventQueueSize is the number of events queued for each stream before
+// further events are dropped from it
+const eventQueueSize = 256
+
+// eventInterval is how often the same violation of a connection is
+// reported while it goes on
+const eventInterval = 10 * time.Second
+
+// eventKeepAlive is how often an idle event stream sends a comment, so
+// that proxies keep it open
+const eventKeepAlive = 15 * time.Second
+
+// Event is a change of the switch's state that dashboards and automation
+// may react to
+type Event struct {
+	Type       string    `json:"type"`
+	VLAN       int       `json:"vlan"`
+	Connection string    `json:"connection,omitempty"`
+	RemoteAddr string    `json:"remote_addr,omitempty"`
+	Label      string    `json:"label,omitempty"`
+	ClientID   string    `json:"client_id,omitempty"`
+	VMName     string    `json:"vm_name,omitempty"`
+	VMUUID     string    `json:"vm_uuid,omitempty"`
+	Interface  string    `json:"interface,omitempty"`
+	Reason     string    `json:"reason,omitempty"`
+	Time       time.Time `json:"time"`
+}
+
+// eventHub fans the switch's events out to the attached event streams
+type eventHub struct {
+	active  atomic.Int32
+	mutex   sync.RWMutex
+	streams map[*eventStream]struct{}
+}
+
+// eventStream is an attached event stream
+type eventStream struct {
+	events  chan Event
+	dropped atomic.Uint64
+}
+
+// subscribe attaches a new event stream
+func (h *eventHub) subscribe() *eventStream {
+	stream := &eventStream{events: make(chan Event, eventQueueSize)}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if h.streams == nil {
+		h.streams = make(map[*eventStream]struct{})
+	}
+	h.streams[stream] = struct{}{}
+	h.active.Add(1)
+	return stream
+}
+
+// unsubscribe detaches an event stream
+func (h *eventHub) unsubscribe(stream *eventStream) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if _, exists := h.streams[stream]; exists {
+		delete(h.streams, stream)
+		h.active.Add(-1)
+	}
+}
+
+// publish sends an event to every attached stream, dropping it for
+// streams whose queue is full
+func (h *eventHub) publish(event Event) {
+	if h == nil || h.active.Load() == 0 {
+		return
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	for stream := range h.streams {
+		select {
+		case stream.events <- event:
+		default:
+			stream.dropped.Add(1)
+		}
+	}
+}
+
+// stream writes the events published from now on to w as server-sent
+// events until ctx is done or a write fails
+func (h *eventHub) stream(ctx context.Context, w io.Writer, logger *slog.Logger) error {
+	stream := h.subscribe()
+	defer func() {
+		h.unsubscribe(stream)
+		if dropped := stream.dropped.Load(); dropped > 0 {
+			logger.Warn("Event stream dropped events", "dropped", dropped)
+		}
+	}()
+
+	flusher, _ := w.(interface{ Flush() })
+	flush := func() {
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+
+	// Let the client know the stream is open before the first event
+	if _, err := io.WriteString(w, ": vswitch events\n\n"); err != nil {
+		return err
+	}
+	flush()
+
+	keepAlive := time.NewTicker(eventKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-keepAlive.C:
+			if _, err := io.WriteString(w, ": keepalive\n\n"); err != nil {
+				return err
+			}
+		case event := <-stream.events:
+			data, err := json.Marshal(event)
+			if err != nil {
+				return err
+			}
+			if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data); err != nil {
+				return err
+			}
+		}
+		flush()
+	}
+}
+
+// connectionEvent publishes an event about a connection of the VLAN
+func (vs *VirtualSwitch) connectionEvent(kind string, conn *Connection, reason string) {
+	if vs.events == nil || vs.events.active.Load() == 0 {
+		return
+	}
+
+	hello := conn.Hello()
+	vs.events.publish(Event{
+		Type:       kind,
+		VLAN:       vs.port(),
+		Connection: conn.ID,
+		RemoteAddr: conn.RemoteAddr(),
+		Label:      conn.Label(),
+		ClientID:   hello.ID,
+		VMName:     hello.VMName,
+		VMUUID:     hello.VMUUID,
+		Interface:  hello.Interface,
+		Reason:     reason,
+	})
+}
+
+// violationEvent publishes an event about a connection breaking a limit,
+// at most once per eventInterval for the same reason while it goes on
+func (vs *VirtualSwitch) violationEvent(kind string, conn *Connection, reason string) {
+	if vs.events == nil || vs.events.active.Load() == 0 {
+		return
+	}
+	if conn.reportDue(kind+"/"+reason, time.Now()) {
+		vs.connectionEvent(kind, conn, reason)
+	}
+}
+
+// reportDue reports whether an event of the given key was not reported
+// for the connection within eventInterval, recording it as reported
+func (c *Connection) reportDue(key string, now time.Time) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if last, found := c.reported[key]; found && now.Sub(last) < eventInterval {
+		return false
+	}
+	if c.reported == nil {
+		c.reported = make(map[string]time.Time)
+	}
+	c.reported[key] = now
+	return true
+}
+
+// StreamEvents writes the events of every VLAN to w as server-sent events
+// until ctx is done or a write fails. Events are dropped from the stream
+// when w cannot keep up.
+func (sm *SwitchManager) StreamEvents(ctx context.Context, w io.Writer) error {
+	return sm.events.stream(ctx, w, sm.logger())
+}
@@ -0,0 +1,132 @@
+package vswitch
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readEvent reads the next event from a server-sent events stream
+func readEvent(t *testing.T, reader *bufio.Reader) Event {
+	t.Helper()
+
+	var kind string
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Failed to read event: %v", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			kind = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			var event Event
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &event); err != nil {
+				t.Fatalf("Failed to decode event: %v", err)
+			}
+			if event.Type != kind {
+				t.Errorf("Event named %s carries type %s", kind, event.Type)
+			}
+			return event
+		}
+	}
+}
+
+func TestStreamEvents(t *testing.T) {
+	sm := NewSwitchManager()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	reader, writer := io.Pipe()
+	done := make(chan error, 1)
+	go func() { done <- sm.StreamEvents(ctx, writer) }()
+
+	// The stream opens with a comment once subscribed
+	events := bufio.NewReader(reader)
+	if line, err := events.ReadString('\n'); err != nil || !strings.HasPrefix(line, ":") {
+		t.Fatalf("Expected the stream to open with a comment, got %q, %v", line, err)
+	}
+
+	if err := sm.AddVLANWithConfig(8080, Config{StickyMAC: true}); err != nil {
+		t.Fatalf("Failed to add VLAN: %v", err)
+	}
+	if event := readEvent(t, events); event.Type != EventVLANAdded || event.VLAN != 8080 {
+		t.Errorf("Expected vlan_added for 8080, got %+v", event)
+	}
+
+	// conn1 is served by the VLAN until it stops
+	vs := sm.vlan(8080)
+	vmSide, switchSide := net.Pipe()
+	defer func() { _ = vmSide.Close() }()
+	vs.attach("conn1", switchSide, func(c *Connection) { c.SetLabel("vm-a") })
+	if event := readEvent(t, events); event.Type != EventConnectionAccepted || event.Connection != "conn1" || event.Label != "vm-a" {
+		t.Errorf("Expected connection_accepted for conn1, got %+v", event)
+	}
+	value, _ := vs.connections.Load("conn1")
+	conn1 := value.(*Connection)
+
+	conn2 := NewConnection("conn2", &mockConnSwitch{addr: &mockAddrSwitch{network: "tcp", address: "127.0.0.1:9002"}})
+	vs.attachConnection(conn2)
+	if event := readEvent(t, events); event.Type != EventConnectionAccepted || event.RemoteAddr != "127.0.0.1:9002" {
+		t.Errorf("Expected connection_accepted for conn2, got %+v", event)
+	}
+
+	// A MAC bound to conn1 claimed by conn2 is reported once while it goes on
+	src := net.HardwareAddr{0x52, 0x54, 0x00, 0x00, 0x00, 0x01}
+	frame := buildEthernet(BroadcastMAC, src, EtherTypeIPv4, make([]byte, 46))
+	_ = vs.processFrame(rawFrame(frame), conn1)
+	_ = vs.processFrame(rawFrame(frame), conn2)
+	_ = vs.processFrame(rawFrame(frame), conn2)
+	if event := readEvent(t, events); event.Type != EventLimitViolation || event.Connection != "conn2" || event.Reason != "sticky MAC" {
+		t.Errorf("Expected a sticky MAC violation of conn2, got %+v", event)
+	}
+
+	vs.cleanupConnection(conn2)
+	if event := readEvent(t, events); event.Type != EventConnectionClosed || event.Connection != "conn2" {
+		t.Errorf("Expected connection_closed for conn2 after a single violation event, got %+v", event)
+	}
+
+	if err := sm.RemoveVLAN(8080); err != nil {
+		t.Fatalf("Failed to remove VLAN: %v", err)
+	}
+	if event := readEvent(t, events); event.Type != EventConnectionClosed || event.Connection != "conn1" {
+		t.Errorf("Expected connection_closed for conn1 when the VLAN stops, got %+v", event)
+	}
+	if event := readEvent(t, events); event.Type != EventVLANRemoved || event.VLAN != 8080 {
+		t.Errorf("Expected vlan_removed for 8080, got %+v", event)
+	}
+
+	cancel()
+	go func() { _, _ = io.Copy(io.Discard, reader) }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Expected the stream to end cleanly, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Stream did not end with its context")
+	}
+}
+
+func TestReportDue(t *testing.T) {
+	conn := NewConnection("conn1", &mockConnSwitch{addr: &mockAddrSwitch{network: "tcp", address: "127.0.0.1:9001"}})
+	now := time.Now()
+
+	if !conn.reportDue("storm_control/broadcast", now) {
+		t.Error("Expected the first report to be due")
+	}
+	if conn.reportDue("storm_control/broadcast", now.Add(time.Second)) {
+		t.Error("Expected a repeated report within the interval to be suppressed")
+	}
+	if !conn.reportDue("storm_control/multicast", now.Add(time.Second)) {
+		t.Error("Expected another reason to be reported")
+	}
+	if !conn.reportDue("storm_control/broadcast", now.Add(eventInterval)) {
+		t.Error("Expected the report to be due again after the interval")
+	}
+}
@@ -0,0 +1,54 @@
+package vswitch
+
+import (
+	"fmt"
+)
+
+// AddressFamily selects the IP versions a VLAN accepts connections over
+type AddressFamily int
+
+const (
+	// FamilyDual listens on IPv4 and IPv6, where the host supports IPv6
+	FamilyDual AddressFamily = iota
+	// FamilyIPv4 only listens on IPv4
+	FamilyIPv4
+	// FamilyIPv6 only listens on IPv6
+	FamilyIPv6
+)
+
+// String returns the name of the address family
+func (f AddressFamily) String() string {
+	switch f {
+	case FamilyDual:
+		return "dual"
+	case FamilyIPv4:
+		return "ipv4"
+	case FamilyIPv6:
+		return "ipv6"
+	default:
+		return "unknown"
+	}
+}
+
+// ParseAddressFamily parses the name of an address family
+func ParseAddressFamily(name string) (AddressFamily, error) {
+	for _, family := range []AddressFamily{FamilyDual, FamilyIPv4, FamilyIPv6} {
+		if family.String() == name {
+			return family, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown address family '%s'", name)
+}
+
+// network returns the network to listen on for the family. Go makes "tcp6"
+// listeners IPv6-only and "tcp" listeners on the wildcard address dual-stack.
+func (f AddressFamily) network() string {
+	switch f {
+	case FamilyIPv4:
+		return "tcp4"
+	case FamilyIPv6:
+		return "tcp6"
+	default:
+		return "tcp"
+	}
+}
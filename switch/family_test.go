@@ -0,0 +1,65 @@
+package vswitch
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"testing"
+)
+
+func TestListenFamily(t *testing.T) {
+	if listener, err := net.Listen("tcp6", "[::1]:0"); err != nil {
+		t.Skipf("IPv6 is not available: %v", err)
+	} else {
+		_ = listener.Close()
+	}
+
+	tests := []struct {
+		family    AddressFamily
+		reachable map[string]bool
+	}{
+		{FamilyDual, map[string]bool{"127.0.0.1": true, "::1": true}},
+		{FamilyIPv4, map[string]bool{"127.0.0.1": true, "::1": false}},
+		{FamilyIPv6, map[string]bool{"127.0.0.1": false, "::1": true}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.family.String(), func(t *testing.T) {
+			listener, err := net.Listen("tcp", ":0")
+			if err != nil {
+				t.Fatalf("Failed to find a free port: %v", err)
+			}
+			port := listener.Addr().(*net.TCPAddr).Port
+			_ = listener.Close()
+
+			sw := NewVirtualSwitchWithConfig([]int{port}, Config{ListenFamily: test.family})
+			if err := sw.Start(context.Background()); err != nil {
+				t.Fatalf("Failed to start switch: %v", err)
+			}
+			defer sw.Stop()
+
+			for host, reachable := range test.reachable {
+				conn, err := net.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(port)))
+				if err == nil {
+					_ = conn.Close()
+				}
+				if reachable && err != nil {
+					t.Errorf("Expected %s to be reachable: %v", host, err)
+				} else if !reachable && err == nil {
+					t.Errorf("Expected %s not to be reachable", host)
+				}
+			}
+		})
+	}
+}
+
+func TestParseAddressFamily(t *testing.T) {
+	for _, family := range []AddressFamily{FamilyDual, FamilyIPv4, FamilyIPv6} {
+		if parsed, err := ParseAddressFamily(family.String()); err != nil || parsed != family {
+			t.Errorf("Expected %s to parse, got %v, %v", family, parsed, err)
+		}
+	}
+	if _, err := ParseAddressFamily("ipx"); err == nil {
+		t.Error("Expected an unknown family to be rejected")
+	}
+}
@@ -0,0 +1,94 @@
+package vswitch
+
+import "net"
+
+// fastPathPort is a connection the kernel fast path can forward frames to,
+// the veth of a Docker container
+type fastPathPort interface {
+	// fastPathIndex returns the index of the interface frames to the
+	// connection are redirected to
+	fastPathIndex() int
+}
+
+// fastPathIndex returns the index of the interface the kernel may redirect
+// frames to conn to, or 0 if they must go through the switch because conn
+// has no veth or port settings the kernel does not apply
+func (vs *VirtualSwitch) fastPathIndex(conn *Connection) int {
+	port, ok := conn.Conn.(fastPathPort)
+	if !ok || conn.IsClosed() || conn.Disabled() || conn.Trunk() || conn.Horizon() != "" || conn.currentImpairment() != nil {
+		return 0
+	}
+	if mode, _ := conn.PortMode(); mode != PortPromiscuous {
+		return 0
+	}
+	return port.fastPathIndex()
+}
+
+// fastPathEnabled reports whether the kernel may forward any frame of the
+// VLAN, which it may not while the VLAN drops or delays frames as a whole,
+// or while they are captured
+func (vs *VirtualSwitch) fastPathEnabled() bool {
+	return !vs.disabled.Load() && !vs.draining.Load() && vs.partitions.active.Load() == 0 &&
+		vs.impairment.Load() == nil && vs.capture.active.Load() == 0
+}
+
+// updateFastPath installs the kernel forwarding entry of a MAC learned on a
+// connection the kernel can forward to, or else removes it
+func (vs *VirtualSwitch) updateFastPath(mac net.HardwareAddr) {
+	if vs.fastPath == nil {
+		return
+	}
+
+	vs.fastPath.update(newMACKey(mac), func() int {
+		if conn := vs.macTable.lookup(mac); conn != nil {
+			return vs.fastPathIndex(conn)
+		}
+		return 0
+	})
+}
+
+// syncFastPath brings the kernel forwarding entries in line with the MAC
+// table after port settings changed
+func (vs *VirtualSwitch) syncFastPath() {
+	if vs.fastPath == nil {
+		return
+	}
+
+	vs.fastPath.sync(func() (bool, map[macKey]int) {
+		entries := make(map[macKey]int)
+		vs.macTable.rangeEntries(func(key macKey, entry MACEntry) {
+			if index := vs.fastPathIndex(entry.Connection); index != 0 {
+				entries[key] = index
+			}
+		})
+		return vs.fastPathEnabled(), entries
+	})
+}
+
+// fastPathConflict names a configured feature that must see every frame,
+// which frames forwarded in the kernel would bypass, or returns ""
+func (c Config) fastPathConflict() string {
+	switch {
+	case len(c.IngressHooks) > 0 || len(c.EgressHooks) > 0:
+		return "frame hooks"
+	case c.OpenFlow != nil:
+		return "OpenFlow"
+	case c.TagDemux:
+		return "tag demultiplexing"
+	case len(c.ProtocolVLANs) > 0:
+		return "protocol VLANs"
+	case c.Bandwidth.Rate > 0:
+		return "bandwidth limits"
+	case c.SFlow != nil:
+		return "sFlow"
+	case c.ProtocolStats > 0:
+		return "protocol statistics"
+	case c.DHCPSnooping:
+		return "DHCP snooping"
+	case c.RAGuard || c.NDInspection:
+		return "neighbor discovery inspection"
+	case c.IPInventory:
+		return "the IP inventory"
+	}
+	return ""
+}
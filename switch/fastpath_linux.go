@@ -0,0 +1,498 @@
+package vswitch
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// fastPathSupported tells whether VLANs can forward frames in the kernel
+	fastPathSupported = true
+
+	// fastPathMaxEntries is the number of MACs the kernel can forward to
+	fastPathMaxEntries = 65536
+
+	// Slots of the fast path's control map
+	fastPathEnabledSlot = 0 // nonzero while the kernel may forward frames
+	fastPathFramesSlot  = 1 // frames the kernel forwarded
+
+	// xdpPass hands a frame to the kernel's network stack
+	xdpPass = 2
+
+	// vethInfoPeer is VETH_INFO_PEER, the nested attribute describing the
+	// other end of a new veth pair
+	vethInfoPeer = 1
+)
+
+// fastPathKey is a MAC address as a key of the forwarding map, padded to
+// 8 bytes
+type fastPathKey [8]byte
+
+// fastPath forwards unicast frames between the veth connections of a VLAN
+// in the kernel. An XDP program attached to each veth redirects a frame to
+// the veth its destination MAC was learned on, if its source MAC was
+// learned on the veth it arrived on; any other frame reaches the switch
+// as usual. The switch fills the program's forwarding map as it learns
+// MACs, with only the connections whose frames need nothing but
+// forwarding.
+type fastPath struct {
+	mutex  sync.Mutex
+	logger *slog.Logger
+
+	// File descriptors of the forwarding map, the control map and the
+	// program, -1 until the first veth is opened
+	fdb     int
+	control int
+	program int
+
+	// entries mirrors the forwarding map
+	entries map[macKey]int
+	enabled bool
+}
+
+// newFastPath creates the fast path of a VLAN. Its kernel objects are
+// created with its first veth.
+func newFastPath(logger *slog.Logger) *fastPath {
+	return &fastPath{
+		logger:  logger,
+		fdb:     -1,
+		control: -1,
+		program: -1,
+		entries: make(map[macKey]int),
+		enabled: true,
+	}
+}
+
+// fastPathProgram returns the XDP program redirecting frames between veths
+func fastPathProgram(fdb, control int) []bpfInsn {
+	program := []bpfInsn{
+		// r6 = ctx, r2 = data, r3 = data_end
+		{code: bpfMovReg, dst: 6, src: 1},
+		{code: bpfLoadWord, dst: 2, src: 6, off: 0},
+		{code: bpfLoadWord, dst: 3, src: 6, off: 4},
+
+		// Pass runts and group addressed frames
+		{code: bpfMovReg, dst: 4, src: 2},
+		{code: bpfAddImm, dst: 4, imm: 14},
+		{code: bpfJumpGtReg, dst: 4, src: 3, target: "pass"},
+		{code: bpfLoadByte, dst: 5, src: 2, off: 0},
+		{code: bpfAndImm, dst: 5, imm: 1},
+		{code: bpfJumpNeImm, dst: 5, imm: 0, target: "pass"},
+
+		// Copy the destination MAC to the key at fp-8 and the source MAC
+		// to the key at fp-16
+		{code: bpfStoreDoubleImm, dst: 10, off: -8},
+		{code: bpfStoreDoubleImm, dst: 10, off: -16},
+	}
+	for i := int16(0); i < 12; i++ {
+		key := int16(-8)
+		if i >= 6 {
+			key = -16 - 6
+		}
+		program = append(program,
+			bpfInsn{code: bpfLoadByte, dst: 5, src: 2, off: i},
+			bpfInsn{code: bpfStoreByte, dst: 10, src: 5, off: key + i})
+	}
+
+	program = append(program,
+		// Pass everything while the switch must see every frame
+		bpfInsn{code: bpfStoreWordImm, dst: 10, off: -20, imm: fastPathEnabledSlot},
+		bpfInsn{code: bpfLoadMapFD, dst: 1, src: bpfPseudoMapFD, imm: int32(control)},
+		bpfInsn{},
+		bpfInsn{code: bpfMovReg, dst: 2, src: 10},
+		bpfInsn{code: bpfAddImm, dst: 2, imm: -20},
+		bpfInsn{code: bpfCall, imm: bpfMapLookupElem},
+		bpfInsn{code: bpfJumpEqImm, dst: 0, imm: 0, target: "pass"},
+		bpfInsn{code: bpfLoadDouble, dst: 1, src: 0},
+		bpfInsn{code: bpfJumpEqImm, dst: 1, imm: 0, target: "pass"},
+
+		// Pass frames whose source was not learned on this veth
+		bpfInsn{code: bpfLoadMapFD, dst: 1, src: bpfPseudoMapFD, imm: int32(fdb)},
+		bpfInsn{},
+		bpfInsn{code: bpfMovReg, dst: 2, src: 10},
+		bpfInsn{code: bpfAddImm, dst: 2, imm: -16},
+		bpfInsn{code: bpfCall, imm: bpfMapLookupElem},
+		bpfInsn{code: bpfJumpEqImm, dst: 0, imm: 0, target: "pass"},
+		bpfInsn{code: bpfLoadWord, dst: 1, src: 0},
+		bpfInsn{code: bpfLoadWord, dst: 2, src: 6, off: 12}, // ingress_ifindex
+		bpfInsn{code: bpfJumpNeReg, dst: 1, src: 2, target: "pass"},
+
+		// And frames to unknown destinations or back to this veth
+		bpfInsn{code: bpfLoadMapFD, dst: 1, src: bpfPseudoMapFD, imm: int32(fdb)},
+		bpfInsn{},
+		bpfInsn{code: bpfMovReg, dst: 2, src: 10},
+		bpfInsn{code: bpfAddImm, dst: 2, imm: -8},
+		bpfInsn{code: bpfCall, imm: bpfMapLookupElem},
+		bpfInsn{code: bpfJumpEqImm, dst: 0, imm: 0, target: "pass"},
+		bpfInsn{code: bpfLoadWord, dst: 7, src: 0},
+		bpfInsn{code: bpfLoadWord, dst: 2, src: 6, off: 12},
+		bpfInsn{code: bpfJumpEqReg, dst: 7, src: 2, target: "pass"},
+
+		// Count the frame and redirect it to the destination's veth
+		bpfInsn{code: bpfStoreWordImm, dst: 10, off: -20, imm: fastPathFramesSlot},
+		bpfInsn{code: bpfLoadMapFD, dst: 1, src: bpfPseudoMapFD, imm: int32(control)},
+		bpfInsn{},
+		bpfInsn{code: bpfMovReg, dst: 2, src: 10},
+		bpfInsn{code: bpfAddImm, dst: 2, imm: -20},
+		bpfInsn{code: bpfCall, imm: bpfMapLookupElem},
+		bpfInsn{code: bpfJumpEqImm, dst: 0, imm: 0, target: "redirect"},
+		bpfInsn{code: bpfMovImm, dst: 1, imm: 1},
+		bpfInsn{code: bpfAtomicAdd, dst: 0, src: 1},
+		bpfInsn{code: bpfMovReg, dst: 1, src: 7, label: "redirect"},
+		bpfInsn{code: bpfMovImm, dst: 2, imm: 0},
+		bpfInsn{code: bpfCall, imm: bpfRedirect},
+		bpfInsn{code: bpfExit},
+
+		bpfInsn{code: bpfMovImm, dst: 0, imm: xdpPass, label: "pass"},
+		bpfInsn{code: bpfExit},
+	)
+	return program
+}
+
+// open creates the maps and loads the program, unless they exist
+func (f *fastPath) open() error {
+	if f.program >= 0 {
+		return nil
+	}
+
+	fdb, err := bpfCreateMap(unix.BPF_MAP_TYPE_HASH, uint32(len(fastPathKey{})), 4, fastPathMaxEntries)
+	if err != nil {
+		return fmt.Errorf("failed to create forwarding map: %v", err)
+	}
+	control, err := bpfCreateMap(unix.BPF_MAP_TYPE_ARRAY, 4, 8, 2)
+	if err != nil {
+		_ = unix.Close(fdb)
+		return fmt.Errorf("failed to create control map: %v", err)
+	}
+	program, err := assembleBPF(fastPathProgram(fdb, control))
+	if err == nil {
+		f.program, err = bpfLoadProgram(unix.BPF_PROG_TYPE_XDP, program, "")
+	}
+	if err != nil {
+		_ = unix.Close(fdb)
+		_ = unix.Close(control)
+		return fmt.Errorf("failed to load XDP program: %v", err)
+	}
+	f.fdb, f.control = fdb, control
+
+	return f.setControl(fastPathEnabledSlot, f.enabled)
+}
+
+// setControl sets a flag of the control map
+func (f *fastPath) setControl(slot uint32, flag bool) error {
+	var value uint64
+	if flag {
+		value = 1
+	}
+	return bpfUpdateElem(f.control, unsafe.Pointer(&slot), unsafe.Pointer(&value))
+}
+
+// apply installs the forwarding entry of a MAC, or with index 0 removes it
+func (f *fastPath) apply(mac macKey, index int) {
+	if f.entries[mac] == index {
+		return
+	}
+
+	var key fastPathKey
+	copy(key[:], mac[:])
+	var err error
+	if index == 0 {
+		err = bpfDeleteElem(f.fdb, unsafe.Pointer(&key))
+		delete(f.entries, mac)
+	} else {
+		value := uint32(index)
+		err = bpfUpdateElem(f.fdb, unsafe.Pointer(&key), unsafe.Pointer(&value))
+		if err == nil {
+			f.entries[mac] = index
+		}
+	}
+	if err != nil {
+		f.logger.Warn("Failed to update fast path entry", "mac", mac.String(), "error", err)
+	}
+}
+
+// update installs the forwarding entry of a MAC with the interface index
+// returned by index, or removes it if that is 0
+func (f *fastPath) update(mac macKey, index func() int) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.program >= 0 {
+		f.apply(mac, index())
+	}
+}
+
+// sync replaces the forwarding entries and the enabled flag with those
+// returned by state
+func (f *fastPath) sync(state func() (bool, map[macKey]int)) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	enabled, entries := state()
+	f.enabled = enabled
+	if f.program < 0 {
+		return
+	}
+	if err := f.setControl(fastPathEnabledSlot, enabled); err != nil {
+		f.logger.Warn("Failed to update fast path state", "error", err)
+	}
+	for mac := range f.entries {
+		if entries[mac] == 0 {
+			f.apply(mac, 0)
+		}
+	}
+	for mac, index := range entries {
+		f.apply(mac, index)
+	}
+}
+
+// stats returns the number of frames the kernel forwarded and of MACs it
+// forwards to
+func (f *fastPath) stats() (uint64, int) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.program < 0 {
+		return 0, 0
+	}
+	slot := uint32(fastPathFramesSlot)
+	var frames uint64
+	_ = bpfLookupElem(f.control, unsafe.Pointer(&slot), unsafe.Pointer(&frames))
+	return frames, len(f.entries)
+}
+
+// close releases the maps and the program. Veths still open keep them
+// until they are deleted.
+func (f *fastPath) close() {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.program < 0 {
+		return
+	}
+	_ = unix.Close(f.program)
+	_ = unix.Close(f.fdb)
+	_ = unix.Close(f.control)
+	f.program, f.fdb, f.control = -1, -1, -1
+	clear(f.entries)
+}
+
+// openVeth creates a veth pair whose host end, name, carries the frames
+// of the connection returned and has the fast path's program attached.
+// The other end, peer, is left for a container.
+func (f *fastPath) openVeth(name, peer string) (net.Conn, error) {
+	if err := createVeth(name, peer); err != nil {
+		return nil, err
+	}
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, err
+	}
+	index := iface.Index
+
+	conn, err := func() (net.Conn, error) {
+		// Frames are read whole from a packet socket, so the container
+		// must neither defer checksums nor send segmentation offloads
+		if err := disableOffloads(peer); err != nil {
+			return nil, fmt.Errorf("failed to disable offloads: %v", err)
+		}
+		if err := setLinkUp(index); err != nil {
+			return nil, err
+		}
+
+		f.mutex.Lock()
+		err := f.open()
+		program := f.program
+		f.mutex.Unlock()
+		if err != nil {
+			return nil, err
+		}
+		if err := setLinkXDP(index, program); err != nil {
+			return nil, fmt.Errorf("failed to attach XDP program: %v", err)
+		}
+
+		file, err := openPacketSocket(index, name)
+		if err != nil {
+			return nil, err
+		}
+		return &vethConn{packetConn: newPacketConn(file, name), index: index}, nil
+	}()
+	if err != nil {
+		_ = deleteLink(index)
+		return nil, err
+	}
+	return conn, nil
+}
+
+// vethConn is the connection of the host end of a veth pair
+type vethConn struct {
+	*packetConn
+	index int
+}
+
+// fastPathIndex returns the index of the veth
+func (c *vethConn) fastPathIndex() int {
+	return c.index
+}
+
+// Close closes the packet socket and deletes the veth pair, including the
+// end that was moved to a container
+func (c *vethConn) Close() error {
+	err := c.packetConn.Close()
+	if deleteErr := deleteLink(c.index); deleteErr != nil && !errors.Is(deleteErr, unix.ENODEV) && err == nil {
+		err = deleteErr
+	}
+	return err
+}
+
+// openPacketSocket opens a packet socket receiving the frames that arrive
+// on an interface and sending frames out of it
+func openPacketSocket(index int, name string) (*os.File, error) {
+	// Bind before receiving anything so that no frame of another
+	// interface is queued
+	fd, err := unix.Socket(unix.AF_PACKET, unix.SOCK_RAW|unix.SOCK_NONBLOCK|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return nil, err
+	}
+	// Frames the kernel redirects to the interface are outgoing, and must
+	// not be switched again
+	if err := unix.SetsockoptInt(fd, unix.SOL_PACKET, unix.PACKET_IGNORE_OUTGOING, 1); err != nil {
+		_ = unix.Close(fd)
+		return nil, err
+	}
+	var protocol [2]byte
+	binary.BigEndian.PutUint16(protocol[:], unix.ETH_P_ALL)
+	if err := unix.Bind(fd, &unix.SockaddrLinklayer{Protocol: binary.NativeEndian.Uint16(protocol[:]), Ifindex: index}); err != nil {
+		_ = unix.Close(fd)
+		return nil, err
+	}
+	return os.NewFile(uintptr(fd), name), nil
+}
+
+// disableOffloads turns off the checksum and segmentation offloads of an
+// interface
+func disableOffloads(name string) error {
+	fd, err := unix.Socket(unix.AF_INET, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = unix.Close(fd) }()
+
+	for _, cmd := range []uint32{unix.ETHTOOL_STXCSUM, unix.ETHTOOL_SSG, unix.ETHTOOL_STSO, unix.ETHTOOL_SGSO} {
+		value := &struct{ cmd, data uint32 }{cmd: cmd}
+		var ifr struct {
+			name [unix.IFNAMSIZ]byte
+			data unsafe.Pointer
+			_    [16]byte
+		}
+		copy(ifr.name[:unix.IFNAMSIZ-1], name)
+		ifr.data = unsafe.Pointer(value)
+		if _, _, errno := unix.Syscall(unix.SYS_IOCTL, uintptr(fd), unix.SIOCETHTOOL, uintptr(unsafe.Pointer(&ifr))); errno != 0 {
+			return errno
+		}
+	}
+	return nil
+}
+
+// createVeth creates a veth pair
+func createVeth(name, peer string) error {
+	peerInfo := netlinkAttr(ifInfoMsg(0, 0, 0), unix.IFLA_IFNAME, cString(peer))
+	linkInfo := netlinkAttr(nil, unix.IFLA_INFO_KIND, []byte("veth"))
+	linkInfo = netlinkAttr(linkInfo, unix.IFLA_INFO_DATA|unix.NLA_F_NESTED, netlinkAttr(nil, vethInfoPeer|unix.NLA_F_NESTED, peerInfo))
+
+	request := netlinkAttr(ifInfoMsg(0, 0, 0), unix.IFLA_IFNAME, cString(name))
+	request = netlinkAttr(request, unix.IFLA_LINKINFO|unix.NLA_F_NESTED, linkInfo)
+	return netlinkRequest(unix.RTM_NEWLINK, unix.NLM_F_CREATE|unix.NLM_F_EXCL, request)
+}
+
+// setLinkUp brings an interface up
+func setLinkUp(index int) error {
+	return netlinkRequest(unix.RTM_NEWLINK, 0, ifInfoMsg(index, unix.IFF_UP, unix.IFF_UP))
+}
+
+// setLinkXDP attaches an XDP program to an interface in generic mode,
+// which veths support without a program on their peer
+func setLinkXDP(index, program int) error {
+	xdp := netlinkAttr(nil, unix.IFLA_XDP_FD, binary.NativeEndian.AppendUint32(nil, uint32(program)))
+	xdp = netlinkAttr(xdp, unix.IFLA_XDP_FLAGS, binary.NativeEndian.AppendUint32(nil, unix.XDP_FLAGS_SKB_MODE))
+	return netlinkRequest(unix.RTM_SETLINK, 0, netlinkAttr(ifInfoMsg(index, 0, 0), unix.IFLA_XDP|unix.NLA_F_NESTED, xdp))
+}
+
+// deleteLink deletes an interface, and with a veth its peer
+func deleteLink(index int) error {
+	return netlinkRequest(unix.RTM_DELLINK, 0, ifInfoMsg(index, 0, 0))
+}
+
+// ifInfoMsg encodes the struct ifinfomsg starting link requests
+func ifInfoMsg(index int, flags, change uint32) []byte {
+	msg := make([]byte, unix.SizeofIfInfomsg)
+	msg[0] = unix.AF_UNSPEC
+	binary.NativeEndian.PutUint32(msg[4:], uint32(index))
+	binary.NativeEndian.PutUint32(msg[8:], flags)
+	binary.NativeEndian.PutUint32(msg[12:], change)
+	return msg
+}
+
+// cString returns a NUL-terminated string
+func cString(s string) []byte {
+	return append([]byte(s), 0)
+}
+
+// netlinkAttr appends an attribute, padded to 4 bytes, to b
+func netlinkAttr(b []byte, kind uint16, value []byte) []byte {
+	length := unix.SizeofRtAttr + len(value)
+	b = binary.NativeEndian.AppendUint16(b, uint16(length))
+	b = binary.NativeEndian.AppendUint16(b, kind)
+	b = append(b, value...)
+	return append(b, make([]byte, (length+3)&^3-length)...)
+}
+
+// netlinkRequest sends a route netlink request and waits for it to be
+// acknowledged
+func netlinkRequest(msgType, flags uint16, payload []byte) error {
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW|unix.SOCK_CLOEXEC, unix.NETLINK_ROUTE)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = unix.Close(fd) }()
+
+	msg := make([]byte, unix.SizeofNlMsghdr, unix.SizeofNlMsghdr+len(payload))
+	binary.NativeEndian.PutUint32(msg[0:], uint32(unix.SizeofNlMsghdr+len(payload)))
+	binary.NativeEndian.PutUint16(msg[4:], msgType)
+	binary.NativeEndian.PutUint16(msg[6:], flags|unix.NLM_F_REQUEST|unix.NLM_F_ACK)
+	binary.NativeEndian.PutUint32(msg[8:], 1)
+	msg = append(msg, payload...)
+	if err := unix.Sendto(fd, msg, 0, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		return err
+	}
+
+	buf := make([]byte, os.Getpagesize())
+	for {
+		n, _, err := unix.Recvfrom(fd, buf, 0)
+		if err != nil {
+			return err
+		}
+		messages, err := syscall.ParseNetlinkMessage(buf[:n])
+		if err != nil {
+			return err
+		}
+		for _, message := range messages {
+			if message.Header.Type != unix.NLMSG_ERROR || len(message.Data) < 4 {
+				continue
+			}
+			if errno := -int32(binary.NativeEndian.Uint32(message.Data)); errno != 0 {
+				return syscall.Errno(errno)
+			}
+			return nil
+		}
+	}
+}
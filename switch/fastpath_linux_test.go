@@ -0,0 +1,170 @@
+package vswitch
+
+import (
+	"bytes"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fastPathEtherType marks the test's frames among those the kernel sends
+// on new interfaces
+const fastPathEtherType = 0x88b5
+
+// containerEnd opens the peer of a veth as its container would use it
+func containerEnd(t *testing.T, name string) *os.File {
+	t.Helper()
+
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		t.Fatalf("Expected the veth peer to exist: %v", err)
+	}
+	if err := setLinkUp(iface.Index); err != nil {
+		t.Fatalf("Failed to bring %s up: %v", name, err)
+	}
+	file, err := openPacketSocket(iface.Index, name)
+	if err != nil {
+		t.Fatalf("Failed to open packet socket: %v", err)
+	}
+	t.Cleanup(func() { _ = file.Close() })
+	return file
+}
+
+// linkUp reports whether an interface has a carrier and is up
+func linkUp(name string) bool {
+	state, err := os.ReadFile("/sys/class/net/" + name + "/operstate")
+	return err == nil && strings.TrimSpace(string(state)) == "up"
+}
+
+// receiveTestFrame waits for a test frame with the given payload on a
+// container's end, reporting whether it arrived
+func receiveTestFrame(file *os.File, payload []byte, timeout time.Duration) bool {
+	buf := make([]byte, 2048)
+	_ = file.SetReadDeadline(time.Now().Add(timeout))
+	for {
+		n, err := file.Read(buf)
+		if err != nil {
+			return false
+		}
+		if frame, err := ParseEthernetFrame(buf[:n]); err == nil && frame.EtherType == fastPathEtherType && bytes.HasPrefix(frame.Payload, payload) {
+			return true
+		}
+	}
+}
+
+func TestFastPath(t *testing.T) {
+	if err := createVeth("vswprobe", "vscprobe"); err != nil {
+		t.Skipf("Cannot create veths: %v", err)
+	}
+	if probe, err := net.InterfaceByName("vswprobe"); err == nil {
+		_ = deleteLink(probe.Index)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to find a free port: %v", err)
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+	_ = listener.Close()
+
+	sm := NewSwitchManager()
+	sm.SetDefaultConfig(Config{FastPath: true})
+	if err := sm.StartVLAN(port); err != nil {
+		t.Fatalf("Failed to start VLAN: %v", err)
+	}
+	defer sm.StopAll()
+	vs := sm.vlan(port)
+
+	driver := NewDockerDriver(sm)
+	network := `{"NetworkID": "n1", "Options": {"com.docker.network.generic": {"vlan": "` + strconv.Itoa(port) + `"}}}`
+	if code, response := pluginCall(t, driver, "/NetworkDriver.CreateNetwork", network); code != http.StatusOK {
+		t.Fatalf("Failed to create network %d: %v", code, response)
+	}
+	ends := make(map[string]*os.File)
+	for _, id := range []string{"aaaaaaaaaaaa0000", "bbbbbbbbbbbb0000"} {
+		pluginCall(t, driver, "/NetworkDriver.CreateEndpoint", `{"NetworkID": "n1", "EndpointID": "`+id+`"}`)
+		code, response := pluginCall(t, driver, "/NetworkDriver.Join", `{"NetworkID": "n1", "EndpointID": "`+id+`", "SandboxKey": "/var/run/docker/netns/x"}`)
+		names, _ := response["InterfaceName"].(map[string]interface{})
+		if code != http.StatusOK || names["SrcName"] != "vsc"+id[:12] {
+			t.Fatalf("Unexpected join response %d: %v", code, response)
+		}
+		ends[id] = containerEnd(t, "vsc"+id[:12])
+		defer pluginCall(t, driver, "/NetworkDriver.Leave", `{"NetworkID": "n1", "EndpointID": "`+id+`"}`)
+	}
+	a, b := ends["aaaaaaaaaaaa0000"], ends["bbbbbbbbbbbb0000"]
+	macA := net.HardwareAddr{0x02, 0x00, 0x00, 0x00, 0x00, 0x0a}
+	macB := net.HardwareAddr{0x02, 0x00, 0x00, 0x00, 0x00, 0x0b}
+	send := func(file *os.File, dst, src net.HardwareAddr, payload string) {
+		if _, err := file.Write(buildEthernet(dst, src, fastPathEtherType, append([]byte(payload), make([]byte, 46)...))); err != nil {
+			t.Fatalf("Failed to send frame: %v", err)
+		}
+	}
+
+	waitUntil(t, "both veths are attached and up", func() bool {
+		connections, _ := sm.GetConnections(port)
+		return len(connections) == 2 && linkUp("vswaaaaaaaaaaaa") && linkUp("vscaaaaaaaaaaaa") &&
+			linkUp("vswbbbbbbbbbbbb") && linkUp("vscbbbbbbbbbbbb")
+	})
+
+	// The switch learns both MACs from their first frames and hands them
+	// to the kernel. A veth may drop frames just after coming up, so the
+	// broadcasts are repeated until they get through.
+	flood := func(from, to *os.File, src net.HardwareAddr, payload string) {
+		for i := 0; i < 10; i++ {
+			send(from, BroadcastMAC, src, payload)
+			if receiveTestFrame(to, []byte(payload), 500*time.Millisecond) {
+				return
+			}
+		}
+		t.Fatalf("Expected %q to be flooded", payload)
+	}
+	flood(a, b, macA, "hello from a")
+	flood(b, a, macB, "hello from b")
+	waitUntil(t, "both MACs are forwarded in the kernel", func() bool { return vs.GetStats()["fast_path_entries"] == 2 })
+
+	// Unicast between them no longer reaches the switch
+	unicast := vs.GetStats()["unicast_frames"]
+	for i := 0; i < 5; i++ {
+		payload := "unicast " + strconv.Itoa(i)
+		send(a, macB, macA, payload)
+		if !receiveTestFrame(b, []byte(payload), 5*time.Second) {
+			t.Fatalf("Expected unicast frame %d to be forwarded", i)
+		}
+	}
+	stats := vs.GetStats()
+	if stats["fast_path_frames"] != uint64(5) || stats["unicast_frames"] != unicast {
+		t.Errorf("Expected 5 frames forwarded in the kernel only, got %v and %v through the switch", stats["fast_path_frames"], stats["unicast_frames"])
+	}
+
+	// Until the switch must see every frame again
+	vs.SetDisabled(true)
+	send(a, macB, macA, "while disabled")
+	if receiveTestFrame(b, []byte("while disabled"), 200*time.Millisecond) {
+		t.Error("Expected a disabled VLAN to forward nothing")
+	}
+	vs.SetDisabled(false)
+
+	// A disabled connection loses its entries
+	if err := vs.SetConnectionDisabled("docker-bbbbbbbbbbbb", true); err != nil {
+		t.Fatalf("Failed to disable connection: %v", err)
+	}
+	if entries := vs.GetStats()["fast_path_entries"]; entries != 1 {
+		t.Errorf("Expected 1 entry, got %v", entries)
+	}
+	if frames := vs.GetStats()["fast_path_frames"]; frames != uint64(5) {
+		t.Errorf("Expected no more frames forwarded in the kernel, got %v", frames)
+	}
+}
+
+func TestFastPathConflicts(t *testing.T) {
+	if err := (Config{FastPath: true, PrivateVLAN: true}).validate(); err != nil {
+		t.Errorf("Expected the fast path to apply private VLAN port modes, got %v", err)
+	}
+	if err := (Config{FastPath: true, DHCPSnooping: true}).validate(); err == nil {
+		t.Error("Expected the fast path to be refused with DHCP snooping")
+	}
+}
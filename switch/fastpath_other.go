@@ -0,0 +1,39 @@
+//go:build !linux
+
+package vswitch
+
+import (
+	"errors"
+	"log/slog"
+	"net"
+)
+
+// fastPathSupported tells whether VLANs can forward frames in the kernel
+const fastPathSupported = false
+
+// fastPath does nothing; it relies on Linux's XDP
+type fastPath struct{}
+
+// newFastPath creates a fast path that forwards nothing
+func newFastPath(_ *slog.Logger) *fastPath {
+	return &fastPath{}
+}
+
+// update does nothing
+func (f *fastPath) update(_ macKey, _ func() int) {}
+
+// sync does nothing
+func (f *fastPath) sync(_ func() (bool, map[macKey]int)) {}
+
+// stats returns no frames and no entries
+func (f *fastPath) stats() (uint64, int) {
+	return 0, 0
+}
+
+// close does nothing
+func (f *fastPath) close() {}
+
+// openVeth fails; the fast path relies on Linux's XDP
+func (f *fastPath) openVeth(_, _ string) (net.Conn, error) {
+	return nil, errors.New("the XDP fast path is only supported on Linux")
+}
@@ -3,8 +3,19 @@ package vswitch
 import (
 	"fmt"
 	"net"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
+// ethernetOverhead is the size of an Ethernet header with a VLAN tag, the
+// difference between the MTU and the largest frame
+const ethernetOverhead = 18
+
+// minFrameSize is the smallest Ethernet frame without its checksum; shorter
+// frames are runts that some drivers discard
+const minFrameSize = 60
+
 // EthernetFrame represents a parsed Ethernet frame
 type EthernetFrame struct {
 	Raw       []byte
@@ -13,6 +24,29 @@ type EthernetFrame struct {
 	EtherType uint16
 	Payload   []byte
 	pooled    bool
+
+	// Encapsulation tells Ethernet II frames from 802.3 ones, whose
+	// EtherType is their length and whose LLC header is in LLC
+	Encapsulation Encapsulation
+	LLC           LLCHeader
+
+	// refs counts the holders of a pooled frame: the receiving connection
+	// and every egress queue it was sent to. The buffer returns to the pool
+	// when the last one releases it.
+	refs atomic.Int32
+
+	// recycled frames return themselves to framePool on release
+	recycled bool
+
+	// received is when the frame was read from its connection
+	received time.Time
+}
+
+// framePool recycles the frames read from connections
+var framePool = sync.Pool{
+	New: func() interface{} {
+		return &EthernetFrame{}
+	},
 }
 
 // BroadcastMAC is the Ethernet broadcast address
@@ -32,17 +66,61 @@ func ParseEthernetFrame(data []byte) (*EthernetFrame, error) {
 		Payload:   data[14:],
 		pooled:    true,
 	}
+	frame.parseEncapsulation()
+	frame.refs.Store(1)
 
 	return frame, nil
 }
 
-// Release returns the frame buffer to the pool if it was pooled
+// newPooledFrame parses a pooled buffer into a frame taken from framePool,
+// avoiding an allocation per received frame
+func newPooledFrame(data []byte) (*EthernetFrame, error) {
+	if len(data) < 14 {
+		return nil, fmt.Errorf("frame too short: %d bytes (minimum 14)", len(data))
+	}
+
+	frame := framePool.Get().(*EthernetFrame)
+	frame.Raw = data
+	frame.DestMAC = data[0:6]
+	frame.SrcMAC = data[6:12]
+	frame.EtherType = uint16(data[12])<<8 | uint16(data[13])
+	frame.Payload = data[14:]
+	frame.parseEncapsulation()
+	frame.pooled = true
+	frame.recycled = true
+	frame.refs.Store(1)
+
+	return frame, nil
+}
+
+// retain adds a holder to a pooled frame, which must then release it too
+func (f *EthernetFrame) retain() {
+	f.refs.Add(1)
+}
+
+// Release drops a holder of the frame, returning the frame buffer to the
+// pool once the last holder of a pooled frame released it
 func (f *EthernetFrame) Release() {
-	if f.pooled && f.Raw != nil {
-		putFrameBuffer(f.Raw)
-		f.Raw = nil
-		f.pooled = false
+	if !f.pooled || f.Raw == nil || f.refs.Add(-1) > 0 {
+		return
+	}
+
+	putFrameBuffer(f.Raw)
+	f.Raw = nil
+	f.pooled = false
+	if f.recycled {
+		*f = EthernetFrame{}
+		framePool.Put(f)
+	}
+}
+
+// timestamp returns when the frame was received, or the current time for
+// frames originated by the switch
+func (f *EthernetFrame) timestamp() time.Time {
+	if f.received.IsZero() {
+		return time.Now()
 	}
+	return f.received
 }
 
 // IsBroadcast returns true if the frame is a broadcast frame
@@ -67,7 +145,7 @@ func (f *EthernetFrame) Validate() error {
 		return fmt.Errorf("frame too short: %d bytes", len(f.Raw))
 	}
 
-	if len(f.Raw) > 1518 {
+	if len(f.Raw) > DefaultMTU+ethernetOverhead {
 		return fmt.Errorf("frame too long: %d bytes", len(f.Raw))
 	}
 
@@ -30,7 +30,7 @@ func BenchmarkParseEthernetFrameWithPool(b *testing.B) {
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
 		// Simulate reading from network with pooled buffer
-		buf := getFrameBuffer()[:len(testFrameData)]
+		buf := getFrameBuffer(len(testFrameData))
 		copy(buf, testFrameData)
 
 		frame, err := ParseEthernetFrame(buf)
@@ -67,3 +67,34 @@ func BenchmarkMACComparison(b *testing.B) {
 		_ = frame.IsMulticast()
 	}
 }
+
+// BenchmarkForwardUnicast measures the receive-to-enqueue path of a unicast
+// frame between two connections with egress queues
+func BenchmarkForwardUnicast(b *testing.B) {
+	sw := NewVirtualSwitchWithConfig([]int{8080}, Config{})
+
+	// Drain the destination's queue without writing to a socket
+	dst := NewConnection("dst", &mockConnSwitch{})
+	dst.queue = make(chan *EthernetFrame, 1024)
+	defer close(dst.queue)
+	go func() {
+		for frame := range dst.queue {
+			frame.Release()
+		}
+	}()
+	src := NewConnection("src", &mockConnSwitch{})
+
+	sw.learnMAC(testFrameData[0:6], dst)
+	sw.learnMAC(testFrameData[6:12], src)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		frame, err := newPooledFrame(append(getFrameBuffer(len(testFrameData))[:0], testFrameData...))
+		if err != nil {
+			b.Fatal(err)
+		}
+		_ = sw.processFrame(frame, src)
+		frame.Release()
+	}
+}
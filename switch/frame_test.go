@@ -63,6 +63,52 @@ func TestParseEthernetFrame(t *testing.T) {
 	}
 }
 
+// llcFrame builds an 802.3 frame carrying an LLC PDU, padded to the
+// minimum frame size
+func llcFrame(dst, src net.HardwareAddr, pdu []byte) []byte {
+	payload := append([]byte(nil), pdu...)
+	if len(payload) < 46 {
+		payload = append(payload, make([]byte, 46-len(payload))...)
+	}
+	return buildEthernet(dst, src, uint16(len(pdu)), payload)
+}
+
+func TestParseLLCFrame(t *testing.T) {
+	stp := net.HardwareAddr{0x01, 0x80, 0xc2, 0x00, 0x00, 0x00}
+	src := net.HardwareAddr{0x52, 0x54, 0x00, 0x00, 0x00, 0x01}
+
+	// A configuration BPDU is an LLC frame whose payload stops before the
+	// padding
+	bpdu, err := ParseEthernetFrame(llcFrame(stp, src, append([]byte{0x42, 0x42, 0x03}, make([]byte, 35)...)))
+	if err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+	if bpdu.Encapsulation != EncapLLC || !bpdu.IsBPDU() || bpdu.LLC.Control != 0x03 || len(bpdu.Payload) != 35 {
+		t.Errorf("Unexpected BPDU %+v, %d byte payload", bpdu.LLC, len(bpdu.Payload))
+	}
+
+	// A SNAP frame names its protocol by OUI and protocol ID
+	snap, _ := ParseEthernetFrame(llcFrame(BroadcastMAC, src, []byte{0xaa, 0xaa, 0x03, 0x00, 0x00, 0x0c, 0x20, 0x00, 0x01}))
+	if snap.Encapsulation != EncapSNAP || snap.LLC.OUI != [3]byte{0x00, 0x00, 0x0c} || snap.LLC.ProtocolID != 0x2000 || len(snap.Payload) != 1 {
+		t.Errorf("Unexpected SNAP frame %+v, %d byte payload", snap.LLC, len(snap.Payload))
+	}
+
+	// I format frames have a two-byte control field
+	info, _ := ParseEthernetFrame(llcFrame(BroadcastMAC, src, []byte{0xf0, 0xf0, 0x00, 0x02, 0x01}))
+	if info.Encapsulation != EncapLLC || info.LLC.Control != 0x0002 || len(info.Payload) != 1 {
+		t.Errorf("Unexpected I format frame %+v, %d byte payload", info.LLC, len(info.Payload))
+	}
+
+	raw, _ := ParseEthernetFrame(llcFrame(BroadcastMAC, src, []byte{0xff, 0xff, 0x00, 0x1e}))
+	if raw.Encapsulation != EncapRaw8023 || raw.IsBPDU() {
+		t.Errorf("Expected raw 802.3, got %s", raw.Encapsulation)
+	}
+	ipv4, _ := ParseEthernetFrame(buildEthernet(BroadcastMAC, src, EtherTypeIPv4, make([]byte, 46)))
+	if ipv4.Encapsulation != EncapEthernetII || len(ipv4.Payload) != 46 {
+		t.Errorf("Expected Ethernet II, got %s", ipv4.Encapsulation)
+	}
+}
+
 func TestEthernetFrameIsBroadcast(t *testing.T) {
 	tests := []struct {
 		name           string
@@ -0,0 +1,419 @@
+package vswitch
+
+import (
+	"context"
+	"crypto/subtle"
+	"net"
+	"slices"
+	"strings"
+	"time"
+
+	vswitchv1 "vswitch/proto/vswitch/v1"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	// defaultStatsInterval is the interval of StreamStats when the request
+	// sets none
+	defaultStatsInterval = time.Second
+
+	// minStatsInterval bounds how often StreamStats sends snapshots
+	minStatsInterval = 100 * time.Millisecond
+)
+
+// grpcManagementMethods are the SwitchControl methods changing the switch,
+// which need management enabled and are recorded in the audit log
+var grpcManagementMethods = []string{
+	vswitchv1.SwitchControl_AddVLAN_FullMethodName,
+	vswitchv1.SwitchControl_RemoveVLAN_FullMethodName,
+	vswitchv1.SwitchControl_Kick_FullMethodName,
+}
+
+// NewGRPCServer returns a gRPC server of the SwitchControl service, for
+// serving on a TCP address. Its access matches NewAPIHandler's: when token
+// is set every call must carry it as a bearer token in its authorization
+// metadata, and without a token the methods changing the switch are
+// disabled.
+func NewGRPCServer(sm *SwitchManager, token string) *grpc.Server {
+	return newGRPCServer(sm, token, token != "")
+}
+
+// NewControlGRPCServer returns a gRPC server of the SwitchControl service
+// with management enabled and no authentication, for serving on a socket
+// only its owner can open, such as one from ListenControlSocket
+func NewControlGRPCServer(sm *SwitchManager) *grpc.Server {
+	return newGRPCServer(sm, "", true, grpc.Creds(controlCredentials{}))
+}
+
+// newGRPCServer creates the gRPC server with its access checks
+func newGRPCServer(sm *SwitchManager, token string, management bool, options ...grpc.ServerOption) *grpc.Server {
+	access := &grpcAccess{sm: sm, management: management}
+	if token != "" {
+		access.expected = []byte("Bearer " + token)
+	}
+	options = append(options,
+		grpc.UnaryInterceptor(access.unary),
+		grpc.StreamInterceptor(access.stream),
+	)
+	server := grpc.NewServer(options...)
+	vswitchv1.RegisterSwitchControlServer(server, &grpcService{sm: sm})
+	return server
+}
+
+// grpcAccess checks the calls of the gRPC server against the token and
+// records the management calls in the audit log
+type grpcAccess struct {
+	sm         *SwitchManager
+	expected   []byte
+	management bool
+}
+
+// check rejects a call missing the token, or changing the switch without
+// management enabled
+func (a *grpcAccess) check(ctx context.Context, method string) error {
+	if a.expected != nil {
+		md, _ := metadata.FromIncomingContext(ctx)
+		authorization := strings.Join(md.Get("authorization"), "")
+		if subtle.ConstantTimeCompare([]byte(authorization), a.expected) != 1 {
+			return status.Error(codes.Unauthenticated, "unauthorized")
+		}
+	}
+	if !a.management && slices.Contains(grpcManagementMethods, method) {
+		return status.Error(codes.PermissionDenied, "VLAN management requires an API token")
+	}
+	return nil
+}
+
+// unary checks a call and records it if it changes the switch
+func (a *grpcAccess) unary(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if err := a.check(ctx, info.FullMethod); err != nil {
+		return nil, err
+	}
+	if !slices.Contains(grpcManagementMethods, info.FullMethod) {
+		return handler(ctx, req)
+	}
+
+	resp, err := handler(ctx, req)
+	if log := a.sm.auditLogger(); log != nil {
+		entry := AuditEntry{
+			Time:      time.Now(),
+			Principal: grpcPrincipal(ctx),
+			Action:    "grpc " + info.FullMethod,
+			Status:    grpcHTTPStatus(status.Code(err)),
+		}
+		if message, ok := req.(proto.Message); ok {
+			entry.Body, _ = protojson.Marshal(message)
+		}
+		if err := log.Record(entry); err != nil {
+			a.sm.logger().Error("Failed to write audit log", "action", entry.Action, "principal", entry.Principal, "error", err)
+		}
+	}
+	return resp, err
+}
+
+// stream checks a streaming call
+func (a *grpcAccess) stream(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if err := a.check(ss.Context(), info.FullMethod); err != nil {
+		return err
+	}
+	return handler(srv, ss)
+}
+
+// grpcPrincipal names who made a call: the control socket user, or the
+// address of a gRPC client
+func grpcPrincipal(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return "grpc"
+	}
+	if info, ok := p.AuthInfo.(controlAuthInfo); ok {
+		return info.principal
+	}
+	if host, _, err := net.SplitHostPort(p.Addr.String()); err == nil {
+		return "grpc " + host
+	}
+	return "grpc " + p.Addr.String()
+}
+
+// grpcHTTPStatus returns the HTTP status the API answers with for a gRPC
+// status code, so that the audit log reads the same for both
+func grpcHTTPStatus(code codes.Code) int {
+	switch code {
+	case codes.OK:
+		return 200
+	case codes.InvalidArgument:
+		return 400
+	case codes.Unauthenticated:
+		return 401
+	case codes.PermissionDenied:
+		return 403
+	case codes.NotFound:
+		return 404
+	case codes.AlreadyExists, codes.FailedPrecondition:
+		return 409
+	default:
+		return 500
+	}
+}
+
+// controlCredentials tag the connections of a control socket with the user
+// of the process on the other end, as ControlConnContext does for HTTP
+type controlCredentials struct{}
+
+// controlAuthInfo is the user of a control socket connection
+type controlAuthInfo struct {
+	credentials.CommonAuthInfo
+	principal string
+}
+
+// AuthType returns "control"
+func (controlAuthInfo) AuthType() string {
+	return "control"
+}
+
+// ClientHandshake passes the connection through, the credentials are meant
+// for servers
+func (controlCredentials) ClientHandshake(_ context.Context, _ string, conn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	return conn, controlAuthInfo{}, nil
+}
+
+// ServerHandshake looks up the user of the process on the other end
+func (controlCredentials) ServerHandshake(conn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	info := controlAuthInfo{principal: "control " + peerCredentials(conn)}
+	info.SecurityLevel = credentials.PrivacyAndIntegrity
+	return conn, info, nil
+}
+
+// Info describes the credentials
+func (controlCredentials) Info() credentials.ProtocolInfo {
+	return credentials.ProtocolInfo{SecurityProtocol: "control"}
+}
+
+// Clone returns the credentials, which have no state
+func (c controlCredentials) Clone() credentials.TransportCredentials {
+	return c
+}
+
+// OverrideServerName is deprecated and does nothing
+func (controlCredentials) OverrideServerName(string) error {
+	return nil
+}
+
+// grpcService implements the SwitchControl service on a manager
+type grpcService struct {
+	vswitchv1.UnimplementedSwitchControlServer
+	sm *SwitchManager
+}
+
+// ListVLANs returns the VLANs and their statistics
+func (s *grpcService) ListVLANs(context.Context, *vswitchv1.ListVLANsRequest) (*vswitchv1.ListVLANsResponse, error) {
+	return &vswitchv1.ListVLANsResponse{Vlans: s.vlans()}, nil
+}
+
+// AddVLAN creates and starts a VLAN with the manager's default settings
+func (s *grpcService) AddVLAN(_ context.Context, req *vswitchv1.AddVLANRequest) (*vswitchv1.VLAN, error) {
+	port := int(req.GetPort())
+	if port < 1 || port > 65535 {
+		return nil, status.Error(codes.InvalidArgument, "invalid port")
+	}
+	if err := s.sm.StartVLAN(port); err != nil {
+		return nil, status.Error(codes.AlreadyExists, err.Error())
+	}
+	stats, err := s.sm.GetVLANStats(port)
+	if err != nil {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+	return &vswitchv1.VLAN{Port: int32(port), Stats: grpcVLANStats(stats)}, nil
+}
+
+// RemoveVLAN stops a VLAN, disconnecting its connections
+func (s *grpcService) RemoveVLAN(_ context.Context, req *vswitchv1.RemoveVLANRequest) (*vswitchv1.RemoveVLANResponse, error) {
+	if err := s.sm.RemoveVLAN(int(req.GetPort())); err != nil {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+	return &vswitchv1.RemoveVLANResponse{}, nil
+}
+
+// ListConnections returns the connections of a VLAN
+func (s *grpcService) ListConnections(_ context.Context, req *vswitchv1.ListConnectionsRequest) (*vswitchv1.ListConnectionsResponse, error) {
+	infos, err := s.sm.GetConnections(int(req.GetPort()))
+	if err != nil {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+	connections := make([]*vswitchv1.Connection, 0, len(infos))
+	for _, info := range infos {
+		connections = append(connections, &vswitchv1.Connection{
+			Id:             info.ID,
+			RemoteAddr:     info.RemoteAddr,
+			Mode:           info.Mode,
+			Community:      info.Community,
+			Hairpin:        info.Hairpin,
+			Trusted:        info.Trusted,
+			FramesSent:     info.FramesSent,
+			FramesReceived: info.FramesReceived,
+			BytesSent:      info.BytesSent,
+			BytesReceived:  info.BytesReceived,
+			LastSeen:       timestamppb.New(info.LastSeen),
+		})
+	}
+	return &vswitchv1.ListConnectionsResponse{Connections: connections}, nil
+}
+
+// Kick disconnects a connection from a VLAN, flushing its MAC entries
+func (s *grpcService) Kick(_ context.Context, req *vswitchv1.KickRequest) (*vswitchv1.KickResponse, error) {
+	flushed, err := s.sm.Kick(int(req.GetPort()), req.GetConnectionId())
+	if err != nil {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+	return &vswitchv1.KickResponse{FlushedMacs: uint32(flushed)}, nil
+}
+
+// GetMACTable returns the learned MAC addresses of a VLAN
+func (s *grpcService) GetMACTable(_ context.Context, req *vswitchv1.GetMACTableRequest) (*vswitchv1.GetMACTableResponse, error) {
+	table, err := s.sm.GetMACTable(int(req.GetPort()))
+	if err != nil {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+	entries := make([]*vswitchv1.MACTableEntry, 0, len(table))
+	for _, entry := range table {
+		entries = append(entries, &vswitchv1.MACTableEntry{
+			Mac:          entry.MAC,
+			ConnectionId: entry.ConnectionID,
+			LearnedAt:    timestamppb.New(entry.LearnedAt),
+		})
+	}
+	return &vswitchv1.GetMACTableResponse{Entries: entries}, nil
+}
+
+// grpcEventTypes maps the switch's event types to the service's
+var grpcEventTypes = map[string]vswitchv1.Event_Type{
+	EventConnectionAccepted:   vswitchv1.Event_TYPE_CONNECTED,
+	EventConnectionClosed:     vswitchv1.Event_TYPE_DISCONNECTED,
+	EventConnectionResumed:    vswitchv1.Event_TYPE_RESUMED,
+	EventConnectionIdentified: vswitchv1.Event_TYPE_IDENTIFIED,
+	EventConnectionRejected:   vswitchv1.Event_TYPE_REJECTED,
+	EventVLANAdded:            vswitchv1.Event_TYPE_VLAN_ADDED,
+	EventVLANRemoved:          vswitchv1.Event_TYPE_VLAN_REMOVED,
+	EventLimitViolation:       vswitchv1.Event_TYPE_LIMIT_VIOLATION,
+	EventStormControl:         vswitchv1.Event_TYPE_STORM_CONTROL,
+}
+
+// StreamEvents sends the events of the watched VLANs published from now on
+// until the client cancels the call
+func (s *grpcService) StreamEvents(req *vswitchv1.StreamEventsRequest, stream vswitchv1.SwitchControl_StreamEventsServer) error {
+	events := s.sm.events.subscribe()
+	defer func() {
+		s.sm.events.unsubscribe(events)
+		if dropped := events.dropped.Load(); dropped > 0 {
+			s.sm.logger().Warn("Event stream dropped events", "dropped", dropped)
+		}
+	}()
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case event := <-events.events:
+			if ports := req.GetPorts(); len(ports) > 0 && !slices.Contains(ports, int32(event.VLAN)) {
+				continue
+			}
+			err := stream.Send(&vswitchv1.Event{
+				Type:         grpcEventTypes[event.Type],
+				Time:         timestamppb.New(event.Time),
+				Port:         int32(event.VLAN),
+				ConnectionId: event.Connection,
+				RemoteAddr:   event.RemoteAddr,
+				Label:        event.Label,
+				VmName:       event.VMName,
+				Reason:       event.Reason,
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// StreamStats sends the statistics of every VLAN at a fixed interval until
+// the client cancels the call
+func (s *grpcService) StreamStats(req *vswitchv1.StreamStatsRequest, stream vswitchv1.SwitchControl_StreamStatsServer) error {
+	interval := time.Duration(req.GetIntervalMs()) * time.Millisecond
+	if interval == 0 {
+		interval = defaultStatsInterval
+	}
+	interval = max(interval, minStatsInterval)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := stream.Send(&vswitchv1.StatsSnapshot{Time: timestamppb.Now(), Vlans: s.vlans()}); err != nil {
+			return err
+		}
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// vlans returns the VLANs of the manager with their statistics
+func (s *grpcService) vlans() []*vswitchv1.VLAN {
+	ports := s.sm.GetVLANs()
+	vlans := make([]*vswitchv1.VLAN, 0, len(ports))
+	for _, port := range ports {
+		// The VLAN may have been removed since it was listed
+		stats, err := s.sm.GetVLANStats(port)
+		if err != nil {
+			continue
+		}
+		vlans = append(vlans, &vswitchv1.VLAN{Port: int32(port), Stats: grpcVLANStats(stats)})
+	}
+	return vlans
+}
+
+// grpcVLANStats picks the statistics of the service from those of a VLAN
+func grpcVLANStats(stats map[string]interface{}) *vswitchv1.VLANStats {
+	return &vswitchv1.VLANStats{
+		TotalFrames:     statUint64(stats, "total_frames"),
+		BroadcastFrames: statUint64(stats, "broadcast_frames"),
+		UnicastFrames:   statUint64(stats, "unicast_frames"),
+		DroppedFrames:   statUint64(stats, "dropped_frames"),
+		SpoofedFrames:   statUint64(stats, "spoofed_frames"),
+		IsolatedFrames:  statUint64(stats, "isolated_frames"),
+		DhcpDrops:       statUint64(stats, "dhcp_drops"),
+		NdDrops:         statUint64(stats, "nd_drops"),
+		ProxyArpReplies: statUint64(stats, "proxy_arp_replies"),
+		NatFlows:        uint32(statUint64(stats, "nat_flows")),
+		Connections:     uint32(statUint64(stats, "connections")),
+		MacEntries:      uint32(statUint64(stats, "mac_entries")),
+	}
+}
+
+// statUint64 returns a counter of a VLAN's statistics, whichever integer
+// type it has
+func statUint64(stats map[string]interface{}, key string) uint64 {
+	switch value := stats[key].(type) {
+	case uint64:
+		return value
+	case int:
+		return uint64(max(value, 0))
+	case int64:
+		return uint64(max(value, 0))
+	case uint32:
+		return uint64(value)
+	case int32:
+		return uint64(max(value, 0))
+	default:
+		return 0
+	}
+}
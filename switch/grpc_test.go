@@ -0,0 +1,191 @@
+package vswitch
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	vswitchv1 "vswitch/proto/vswitch/v1"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// serveGRPC serves a gRPC server on a listener for the duration of a test
+// and returns a client of it
+func serveGRPC(t *testing.T, server *grpc.Server, listener net.Listener, target string) vswitchv1.SwitchControlClient {
+	t.Helper()
+
+	go func() { _ = server.Serve(listener) }()
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.NewClient(target, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	return vswitchv1.NewSwitchControlClient(conn)
+}
+
+func TestGRPCManagement(t *testing.T) {
+	sm := NewSwitchManager()
+	sm.SetDefaultConfig(Config{InMemory: true})
+	defer sm.StopAll()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	client := serveGRPC(t, NewGRPCServer(sm, "secret"), listener, "passthrough:///"+listener.Addr().String())
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if _, err := client.ListVLANs(ctx, &vswitchv1.ListVLANsRequest{}); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("Expected calls without the token to be rejected, got %v", err)
+	}
+	wrong := metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer wrong")
+	if _, err := client.ListVLANs(wrong, &vswitchv1.ListVLANsRequest{}); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("Expected calls with a wrong token to be rejected, got %v", err)
+	}
+
+	ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer secret")
+	if _, err := client.AddVLAN(ctx, &vswitchv1.AddVLANRequest{Port: 0}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected an invalid port to be rejected, got %v", err)
+	}
+
+	// Events of the VLANs watched are streamed as they happen
+	events, err := client.StreamEvents(ctx, &vswitchv1.StreamEventsRequest{Ports: []int32{8080}})
+	if err != nil {
+		t.Fatalf("Failed to stream events: %v", err)
+	}
+	waitUntil(t, "the event stream is attached", func() bool { return sm.events.active.Load() == 1 })
+
+	if vlan, err := client.AddVLAN(ctx, &vswitchv1.AddVLANRequest{Port: 8081}); err != nil || vlan.GetPort() != 8081 {
+		t.Fatalf("Failed to add VLAN: %v", err)
+	}
+	if _, err := client.AddVLAN(ctx, &vswitchv1.AddVLANRequest{Port: 8080}); err != nil {
+		t.Fatalf("Failed to add VLAN: %v", err)
+	}
+	if _, err := client.AddVLAN(ctx, &vswitchv1.AddVLANRequest{Port: 8080}); status.Code(err) != codes.AlreadyExists {
+		t.Errorf("Expected a duplicate VLAN to be rejected, got %v", err)
+	}
+	if event, err := events.Recv(); err != nil || event.GetType() != vswitchv1.Event_TYPE_VLAN_ADDED || event.GetPort() != 8080 {
+		t.Errorf("Expected VLAN 8080 to be reported added, got %v, %v", event, err)
+	}
+
+	vlans, err := client.ListVLANs(ctx, &vswitchv1.ListVLANsRequest{})
+	if err != nil || len(vlans.GetVlans()) != 2 {
+		t.Fatalf("Expected 2 VLANs, got %v, %v", vlans, err)
+	}
+
+	// Kicking a connection disconnects it and flushes its MACs
+	vs := sm.vlan(8080)
+	guest := vs.Dial("guest")
+	defer func() { _ = guest.Close() }()
+	mac := net.HardwareAddr{0x52, 0x54, 0x00, 0x00, 0x00, 0x01}
+	if _, err := guest.Write(qemuFrame(buildEthernet(BroadcastMAC, mac, EtherTypeIPv4, make([]byte, 46)))); err != nil {
+		t.Fatalf("Failed to send frame: %v", err)
+	}
+	waitUntil(t, "the MAC is learned", func() bool {
+		table, err := client.GetMACTable(ctx, &vswitchv1.GetMACTableRequest{Port: 8080})
+		return err == nil && len(table.GetEntries()) == 1 && table.GetEntries()[0].GetConnectionId() == "guest"
+	})
+	if connections, err := client.ListConnections(ctx, &vswitchv1.ListConnectionsRequest{Port: 8080}); err != nil || len(connections.GetConnections()) != 1 {
+		t.Errorf("Expected 1 connection, got %v, %v", connections, err)
+	}
+	if kicked, err := client.Kick(ctx, &vswitchv1.KickRequest{Port: 8080, ConnectionId: "guest"}); err != nil || kicked.GetFlushedMacs() != 1 {
+		t.Errorf("Expected the connection to be kicked with its MAC, got %v, %v", kicked, err)
+	}
+	if _, err := client.Kick(ctx, &vswitchv1.KickRequest{Port: 8080, ConnectionId: "missing"}); status.Code(err) != codes.NotFound {
+		t.Errorf("Expected an unknown connection to be reported, got %v", err)
+	}
+	if _, err := client.ListConnections(ctx, &vswitchv1.ListConnectionsRequest{Port: 9999}); status.Code(err) != codes.NotFound {
+		t.Errorf("Expected an unknown VLAN to be reported, got %v", err)
+	}
+
+	if _, err := client.RemoveVLAN(ctx, &vswitchv1.RemoveVLANRequest{Port: 8081}); err != nil {
+		t.Errorf("Failed to remove VLAN: %v", err)
+	}
+	if _, err := client.RemoveVLAN(ctx, &vswitchv1.RemoveVLANRequest{Port: 8081}); status.Code(err) != codes.NotFound {
+		t.Errorf("Expected an unknown VLAN to be reported, got %v", err)
+	}
+
+	stats, err := client.StreamStats(ctx, &vswitchv1.StreamStatsRequest{IntervalMs: 100})
+	if err != nil {
+		t.Fatalf("Failed to stream statistics: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if snapshot, err := stats.Recv(); err != nil || len(snapshot.GetVlans()) != 1 || snapshot.GetVlans()[0].GetStats().GetTotalFrames() != 1 {
+			t.Fatalf("Unexpected statistics %v, %v", snapshot, err)
+		}
+	}
+}
+
+func TestGRPCWithoutToken(t *testing.T) {
+	sm := NewSwitchManager()
+	sm.SetDefaultConfig(Config{InMemory: true})
+	defer sm.StopAll()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	client := serveGRPC(t, NewGRPCServer(sm, ""), listener, "passthrough:///"+listener.Addr().String())
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// Without a token the switch can be watched, not changed
+	if _, err := client.ListVLANs(ctx, &vswitchv1.ListVLANsRequest{}); err != nil {
+		t.Errorf("Failed to list VLANs: %v", err)
+	}
+	if _, err := client.AddVLAN(ctx, &vswitchv1.AddVLANRequest{Port: 8080}); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("Expected management to be disabled without a token, got %v", err)
+	}
+	if ports := sm.GetVLANs(); len(ports) != 0 {
+		t.Errorf("Expected no VLANs, got %v", ports)
+	}
+}
+
+func TestGRPCControlSocket(t *testing.T) {
+	sm := NewSwitchManager()
+	sm.SetDefaultConfig(Config{InMemory: true})
+	defer sm.StopAll()
+
+	logPath := filepath.Join(t.TempDir(), "audit.log")
+	log, err := OpenAuditLog(logPath)
+	if err != nil {
+		t.Fatalf("Failed to open audit log: %v", err)
+	}
+	defer func() { _ = log.Close() }()
+	sm.SetAuditLog(log)
+
+	path := filepath.Join(t.TempDir(), "grpc.sock")
+	listener, err := ListenControlSocket(path)
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	client := serveGRPC(t, NewControlGRPCServer(sm), listener, "unix://"+path)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// The socket's owner manages the switch without a token
+	if _, err := client.AddVLAN(ctx, &vswitchv1.AddVLANRequest{Port: 8080}); err != nil {
+		t.Fatalf("Failed to add VLAN: %v", err)
+	}
+	if ports := sm.GetVLANs(); len(ports) != 1 || ports[0] != 8080 {
+		t.Errorf("Expected VLAN 8080, got %v", ports)
+	}
+
+	// Changes are recorded with the user who made them
+	record, err := os.ReadFile(logPath)
+	if err != nil || !strings.Contains(string(record), `"principal":"control `) || !strings.Contains(string(record), `"action":"grpc /vswitch.v1.SwitchControl/AddVLAN"`) {
+		t.Errorf("Unexpected audit log %q, %v", record, err)
+	}
+}
@@ -0,0 +1,195 @@
+package vswitch
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"sync"
+)
+
+const (
+	// handoverEnv names the unix socket an upgraded process receives the
+	// sockets of its predecessor from
+	handoverEnv = "VSWITCH_HANDOVER_SOCKET"
+
+	// maxHandoverSockets is the number of sockets passed in the single
+	// handover message, just below the kernel's SCM_MAX_FD
+	maxHandoverSockets = 250
+
+	// handoverReady is the message an upgraded process sends once started
+	handoverReady = "ready"
+)
+
+// handoverSocket is a listening socket that can be passed to another process
+type handoverSocket interface {
+	File() (*os.File, error)
+	Close() error
+}
+
+// socketRegistry tracks the sockets opened through Listen and listenUDP so
+// that an upgrade can hand them to the new process, and holds the sockets
+// inherited from the previous process until they are reused
+type socketRegistry struct {
+	mutex     sync.Mutex
+	sockets   map[string]handoverSocket // by network/address
+	inherited map[string]*os.File
+	handover  *net.UnixConn
+	closed    bool
+}
+
+// sockets is the registry of the process
+var sockets = newSocketRegistry()
+
+// newSocketRegistry creates an empty socket registry
+func newSocketRegistry() *socketRegistry {
+	return &socketRegistry{
+		sockets:   make(map[string]handoverSocket),
+		inherited: make(map[string]*os.File),
+	}
+}
+
+// take removes and returns the inherited socket for a key, or nil
+func (r *socketRegistry) take(key string) *os.File {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	file := r.inherited[key]
+	delete(r.inherited, key)
+	return file
+}
+
+// inherits reports whether a socket was inherited for a key
+func (r *socketRegistry) inherits(key string) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	_, found := r.inherited[key]
+	return found
+}
+
+// handedOver reports whether the sockets were closed after handing them to
+// an upgraded process
+func (r *socketRegistry) handedOver() bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.closed
+}
+
+// register records an open socket
+func (r *socketRegistry) register(key string, socket handoverSocket) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.sockets[key] = socket
+}
+
+// Listen listens on a network address like net.Listen, reusing the socket
+// inherited from the process that started this one through Upgrade when
+// it listened on the same address
+func Listen(network, addr string) (net.Listener, error) {
+	return listenInherited(network+"/"+addr, func() (net.Listener, error) {
+		return net.Listen(network, addr)
+	})
+}
+
+// listenShared opens the index-th of several listeners sharing an address
+// with SO_REUSEPORT, reusing the inherited socket of the same index
+func listenShared(network, addr string, index int) (net.Listener, error) {
+	return listenInherited(fmt.Sprintf("%s/%s/%d", network, addr, index), func() (net.Listener, error) {
+		return listenReusePort(network, addr)
+	})
+}
+
+// listenInherited reuses the inherited socket for a key, or else opens a
+// listener with open, and registers it for the next upgrade
+func listenInherited(key string, open func() (net.Listener, error)) (net.Listener, error) {
+	var listener net.Listener
+	var err error
+	if file := sockets.take(key); file != nil {
+		listener, err = net.FileListener(file)
+		_ = file.Close()
+		if unixListener, ok := listener.(*net.UnixListener); ok {
+			unixListener.SetUnlinkOnClose(true)
+		}
+	} else {
+		listener, err = open()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if socket, ok := listener.(handoverSocket); ok {
+		sockets.register(key, socket)
+	}
+	return listener, nil
+}
+
+// listenUDP opens a UDP socket like net.ListenUDP, reusing an inherited
+// socket bound to the same address
+func listenUDP(network, addr string) (*net.UDPConn, error) {
+	key := network + "/" + addr
+
+	var conn *net.UDPConn
+	if file := sockets.take(key); file != nil {
+		packetConn, err := net.FilePacketConn(file)
+		_ = file.Close()
+		if err != nil {
+			return nil, err
+		}
+		udpConn, ok := packetConn.(*net.UDPConn)
+		if !ok {
+			_ = packetConn.Close()
+			return nil, fmt.Errorf("inherited socket for %s is not UDP", addr)
+		}
+		conn = udpConn
+	} else {
+		udpAddr, err := net.ResolveUDPAddr(network, addr)
+		if err != nil {
+			return nil, err
+		}
+		if conn, err = net.ListenUDP(network, udpAddr); err != nil {
+			return nil, err
+		}
+	}
+
+	sockets.register(key, conn)
+	return conn, nil
+}
+
+// CompleteUpgrade tells the previous process that this one has started and
+// closes the inherited sockets that were not reused. It does nothing when
+// the process was started normally.
+func CompleteUpgrade() error {
+	sockets.mutex.Lock()
+	defer sockets.mutex.Unlock()
+
+	for key, file := range sockets.inherited {
+		_ = file.Close()
+		delete(sockets.inherited, key)
+	}
+
+	if sockets.handover == nil {
+		return nil
+	}
+	defer func() {
+		_ = sockets.handover.Close()
+		sockets.handover = nil
+	}()
+	_, err := sockets.handover.Write([]byte(handoverReady))
+	return err
+}
+
+// CloseSockets closes every socket opened through Listen, so that only the
+// process they were handed to accepts on them. Unix sockets are left in
+// place for it.
+func CloseSockets() {
+	sockets.mutex.Lock()
+	defer sockets.mutex.Unlock()
+
+	sockets.closed = true
+	for key, socket := range sockets.sockets {
+		if unixListener, ok := socket.(*net.UnixListener); ok {
+			unixListener.SetUnlinkOnClose(false)
+		}
+		_ = socket.Close()
+		delete(sockets.sockets, key)
+	}
+}
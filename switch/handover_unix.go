@@ -0,0 +1,194 @@
+//go:build !windows
+
+package vswitch
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Upgrade starts the running executable again with the same arguments and
+// passes it every socket opened through Listen over a unix socket. It
+// returns the new process once it reports that it started; the caller then
+// stops accepting with CloseSockets and drains its connections. If the new
+// process fails to start, Upgrade returns an error and nothing changes.
+func Upgrade(timeout time.Duration) (*os.Process, error) {
+	dir, err := os.MkdirTemp("", "vswitch-upgrade")
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	path := filepath.Join(dir, "handover.sock")
+	listener, err := net.ListenUnix("unixpacket", &net.UnixAddr{Name: path, Net: "unixpacket"})
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = listener.Close() }()
+
+	executable, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("failed to find the executable: %v", err)
+	}
+	// #nosec G204 - the running executable is started with its own arguments
+	cmd := exec.Command(executable, os.Args[1:]...)
+	// The new process answers the service manager's watchdog in place of
+	// this one, so the watchdog must not be bound to this process
+	cmd.Env = []string{handoverEnv + "=" + path}
+	for _, variable := range os.Environ() {
+		if !strings.HasPrefix(variable, watchdogPIDEnv+"=") {
+			cmd.Env = append(cmd.Env, variable)
+		}
+	}
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start new process: %v", err)
+	}
+	// Reap the new process should it exit while this one still runs
+	go func() { _ = cmd.Wait() }()
+
+	fail := func(err error) (*os.Process, error) {
+		_ = cmd.Process.Kill()
+		return nil, err
+	}
+
+	_ = listener.SetDeadline(time.Now().Add(timeout))
+	conn, err := listener.AcceptUnix()
+	if err != nil {
+		return fail(fmt.Errorf("new process did not connect: %v", err))
+	}
+	defer func() { _ = conn.Close() }()
+	_ = conn.SetDeadline(time.Now().Add(timeout))
+
+	if err := sockets.send(conn); err != nil {
+		return fail(fmt.Errorf("failed to pass sockets: %v", err))
+	}
+
+	buf := make([]byte, len(handoverReady))
+	if n, err := conn.Read(buf); err != nil || string(buf[:n]) != handoverReady {
+		return fail(fmt.Errorf("new process failed to start"))
+	}
+
+	return cmd.Process, nil
+}
+
+// send passes the open sockets with their keys in one message
+func (r *socketRegistry) send(conn *net.UnixConn) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	keys := make([]string, 0, len(r.sockets))
+	files := make([]*os.File, 0, len(r.sockets))
+	defer func() {
+		for _, file := range files {
+			_ = file.Close()
+		}
+	}()
+
+	for key, socket := range r.sockets {
+		file, err := socket.File()
+		if err != nil {
+			// The socket was closed, e.g. when its VLAN was removed
+			delete(r.sockets, key)
+			continue
+		}
+		keys = append(keys, key)
+		files = append(files, file)
+	}
+	if len(files) > maxHandoverSockets {
+		return fmt.Errorf("too many sockets to pass (%d)", len(files))
+	}
+
+	data, err := json.Marshal(keys)
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		_, err = conn.Write(data)
+		return err
+	}
+
+	// Fd would switch the sockets, which share their flags with the ones
+	// still in use, to blocking mode
+	fds := make([]int, len(files))
+	for i, file := range files {
+		rawConn, err := file.SyscallConn()
+		if err != nil {
+			return err
+		}
+		if err := rawConn.Control(func(fd uintptr) { fds[i] = int(fd) }); err != nil {
+			return err
+		}
+	}
+
+	_, _, err = conn.WriteMsgUnix(data, syscall.UnixRights(fds...), nil)
+	return err
+}
+
+// InheritSockets receives the sockets of the process that started this one
+// through Upgrade, for Listen to reuse. It does nothing when the process was
+// started normally.
+func InheritSockets() error {
+	path := os.Getenv(handoverEnv)
+	if path == "" {
+		return nil
+	}
+	_ = os.Unsetenv(handoverEnv)
+
+	conn, err := net.DialUnix("unixpacket", nil, &net.UnixAddr{Name: path, Net: "unixpacket"})
+	if err != nil {
+		return fmt.Errorf("failed to connect to previous process: %v", err)
+	}
+	if err := sockets.receive(conn); err != nil {
+		_ = conn.Close()
+		return err
+	}
+	return nil
+}
+
+// receive reads the sockets passed by send and keeps conn to report back
+// to the previous process
+func (r *socketRegistry) receive(conn *net.UnixConn) error {
+	data := make([]byte, 64*1024)
+	oob := make([]byte, syscall.CmsgSpace(maxHandoverSockets*4))
+	n, oobn, _, _, err := conn.ReadMsgUnix(data, oob)
+	if err != nil {
+		return fmt.Errorf("failed to receive sockets: %v", err)
+	}
+
+	var fds []int
+	messages, err := syscall.ParseSocketControlMessage(oob[:oobn])
+	if err == nil && len(messages) == 1 {
+		fds, err = syscall.ParseUnixRights(&messages[0])
+	}
+	var keys []string
+	if err == nil {
+		err = json.Unmarshal(data[:n], &keys)
+	}
+	if err == nil && len(keys) != len(fds) {
+		err = fmt.Errorf("got %d sockets for %d addresses", len(fds), len(keys))
+	}
+	if err != nil {
+		for _, fd := range fds {
+			_ = syscall.Close(fd)
+		}
+		return fmt.Errorf("invalid handover message: %v", err)
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for i, key := range keys {
+		syscall.CloseOnExec(fds[i])
+		r.inherited[key] = os.NewFile(uintptr(fds[i]), key)
+	}
+	r.handover = conn
+	return nil
+}
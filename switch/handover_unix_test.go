@@ -0,0 +1,111 @@
+//go:build !windows
+
+package vswitch
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// handoverPair returns both ends of a handover connection
+func handoverPair(t *testing.T) (*net.UnixConn, *net.UnixConn) {
+	t.Helper()
+
+	addr := &net.UnixAddr{Name: filepath.Join(t.TempDir(), "handover.sock"), Net: "unixpacket"}
+	listener, err := net.ListenUnix("unixpacket", addr)
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer func() { _ = listener.Close() }()
+
+	client, err := net.DialUnix("unixpacket", nil, addr)
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	server, err := listener.AcceptUnix()
+	if err != nil {
+		t.Fatalf("Failed to accept: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = client.Close()
+		_ = server.Close()
+	})
+	return server, client
+}
+
+func TestSocketHandover(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	key := "tcp/" + listener.Addr().String()
+
+	old := newSocketRegistry()
+	old.register(key, listener.(handoverSocket))
+	closed, _ := net.Listen("tcp", "127.0.0.1:0")
+	_ = closed.Close()
+	old.register("tcp/closed", closed.(handoverSocket))
+
+	oldEnd, newEnd := handoverPair(t)
+	sent := make(chan error, 1)
+	go func() { sent <- old.send(oldEnd) }()
+
+	upgraded := newSocketRegistry()
+	if err := upgraded.receive(newEnd); err != nil {
+		t.Fatalf("Failed to receive sockets: %v", err)
+	}
+	if err := <-sent; err != nil {
+		t.Fatalf("Failed to send sockets: %v", err)
+	}
+	if upgraded.inherits("tcp/closed") {
+		t.Error("Expected closed sockets not to be passed")
+	}
+
+	file := upgraded.take(key)
+	if file == nil {
+		t.Fatalf("Expected the listener to be inherited")
+	}
+	inherited, err := net.FileListener(file)
+	_ = file.Close()
+	if err != nil {
+		t.Fatalf("Failed to use inherited socket: %v", err)
+	}
+	defer func() { _ = inherited.Close() }()
+
+	// The old process stops accepting; the socket stays open for the new one
+	_ = listener.Close()
+
+	client, err := net.Dial("tcp", inherited.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer func() { _ = client.Close() }()
+
+	_ = inherited.(*net.TCPListener).SetDeadline(time.Now().Add(5 * time.Second))
+	conn, err := inherited.Accept()
+	if err != nil {
+		t.Fatalf("Expected the inherited listener to accept: %v", err)
+	}
+	_ = conn.Close()
+}
+
+func TestSocketHandoverEmpty(t *testing.T) {
+	oldEnd, newEnd := handoverPair(t)
+	go func() { _ = newSocketRegistry().send(oldEnd) }()
+
+	upgraded := newSocketRegistry()
+	if err := upgraded.receive(newEnd); err != nil {
+		t.Fatalf("Failed to receive an empty handover: %v", err)
+	}
+	if len(upgraded.inherited) != 0 {
+		t.Errorf("Expected no inherited sockets, got %d", len(upgraded.inherited))
+	}
+}
+
+func TestCompleteUpgradeWithoutHandover(t *testing.T) {
+	if err := CompleteUpgrade(); err != nil {
+		t.Errorf("Expected no error outside an upgrade, got %v", err)
+	}
+}
@@ -0,0 +1,19 @@
+package vswitch
+
+import (
+	"errors"
+	"os"
+	"time"
+)
+
+// Upgrade fails on Windows, which cannot pass sockets to another process
+// over a unix socket
+func Upgrade(_ time.Duration) (*os.Process, error) {
+	return nil, errors.New("upgrades are not supported on Windows")
+}
+
+// InheritSockets does nothing on Windows, where processes are never
+// started through Upgrade
+func InheritSockets() error {
+	return nil
+}
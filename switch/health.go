@@ -0,0 +1,94 @@
+package vswitch
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// heartbeatInterval is how often idle loops beat their heartbeat
+	heartbeatInterval = time.Second
+
+	// heartbeatTimeout is how old a heartbeat may get before its loop is
+	// considered stuck
+	heartbeatTimeout = 5 * time.Second
+)
+
+// heartbeat records when a loop last showed that it is alive
+type heartbeat struct {
+	last atomic.Int64 // UnixNano, zero while the loop is not running
+}
+
+// beat marks the loop alive now
+func (h *heartbeat) beat() {
+	h.last.Store(time.Now().UnixNano())
+}
+
+// stop marks the loop as no longer running
+func (h *heartbeat) stop() {
+	h.last.Store(0)
+}
+
+// stale reports whether the loop runs but has not beaten for too long
+func (h *heartbeat) stale(now time.Time) bool {
+	last := h.last.Load()
+	return last != 0 && now.Sub(time.Unix(0, last)) > heartbeatTimeout
+}
+
+// VLANHealth reports whether a VLAN's loops are alive and whether it is
+// ready to take connections
+type VLANHealth struct {
+	Port      int      `json:"port"`
+	Ports     int      `json:"ports"`
+	Listening int      `json:"listening"`
+	Disabled  bool     `json:"disabled"`
+	Live      bool     `json:"live"`
+	Ready     bool     `json:"ready"`
+	Problems  []string `json:"problems,omitempty"`
+}
+
+// Health checks the heartbeats of the VLAN's accept loops and forwarding
+// workers. The VLAN is live while none of them is stuck, and ready while it
+// is also listening on all of its ports and not disabled.
+func (vs *VirtualSwitch) Health() VLANHealth {
+	now := time.Now()
+	health := VLANHealth{
+		Port:      vs.port(),
+		Ports:     len(vs.ports),
+		Listening: int(vs.listening.Load()),
+		Disabled:  vs.disabled.Load(),
+	}
+	if vs.config.InMemory {
+		// Nothing to listen on
+		health.Ports = 0
+	}
+
+	for i := range vs.listenerBeats {
+		if vs.listenerBeats[i].stale(now) {
+			health.Problems = append(health.Problems, fmt.Sprintf("accept loop on port %d is stuck", vs.ports[i/vs.listenersPerPort()]))
+		}
+	}
+	for i := range vs.workerBeats {
+		if vs.workerBeats[i].stale(now) {
+			health.Problems = append(health.Problems, fmt.Sprintf("forwarding worker %d is stuck", i))
+		}
+	}
+	health.Live = len(health.Problems) == 0
+
+	if failure, ok := vs.startFailure.Load().(string); ok {
+		health.Problems = append(health.Problems, failure)
+	}
+	if health.Listening < health.Ports {
+		health.Problems = append(health.Problems, fmt.Sprintf("listening on %d of %d ports", health.Listening, health.Ports))
+	}
+	if health.Disabled {
+		health.Problems = append(health.Problems, "VLAN is disabled")
+	}
+	if vs.draining.Load() {
+		health.Problems = append(health.Problems, "VLAN is draining")
+	}
+	health.Ready = len(health.Problems) == 0
+
+	return health
+}
@@ -0,0 +1,66 @@
+package vswitch
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestHeartbeatStale(t *testing.T) {
+	var alive heartbeat
+	now := time.Now()
+	if alive.stale(now) {
+		t.Error("Expected a loop that is not running not to be stale")
+	}
+
+	alive.beat()
+	if alive.stale(now) {
+		t.Error("Expected a fresh heartbeat not to be stale")
+	}
+	if !alive.stale(now.Add(2 * heartbeatTimeout)) {
+		t.Error("Expected an old heartbeat to be stale")
+	}
+
+	alive.stop()
+	if alive.stale(now.Add(2 * heartbeatTimeout)) {
+		t.Error("Expected a stopped loop not to be stale")
+	}
+}
+
+func TestVLANHealth(t *testing.T) {
+	probe, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to find a free port: %v", err)
+	}
+	port := probe.Addr().(*net.TCPAddr).Port
+	_ = probe.Close()
+
+	vs := NewVirtualSwitch([]int{port})
+	if health := vs.Health(); !health.Live || health.Ready {
+		t.Errorf("Expected a stopped VLAN to be live but not ready, got %+v", health)
+	}
+
+	if err := vs.Start(context.Background()); err != nil {
+		t.Fatalf("Failed to start switch: %v", err)
+	}
+	defer vs.Stop()
+
+	if health := vs.Health(); !health.Live || !health.Ready || health.Listening != 1 {
+		t.Errorf("Expected a started VLAN to be ready, got %+v", health)
+	}
+
+	vs.SetDisabled(true)
+	if health := vs.Health(); !health.Live || health.Ready {
+		t.Errorf("Expected a disabled VLAN not to be ready, got %+v", health)
+	}
+}
+
+func TestVLANHealthStuckLoop(t *testing.T) {
+	vs := NewVirtualSwitch([]int{9999})
+	vs.listenerBeats[0].last.Store(time.Now().Add(-2 * heartbeatTimeout).UnixNano())
+
+	if health := vs.Health(); health.Live || health.Ready || len(health.Problems) == 0 {
+		t.Errorf("Expected a stuck accept loop to fail liveness, got %+v", health)
+	}
+}
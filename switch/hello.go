@@ -0,0 +1,230 @@
+package vswitch
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"time"
+)
+
+const (
+	// helloEtherType is the IEEE local experimental EtherType carrying
+	// hello frames; the echo and self-test tools use the other one, 0x88b5
+	helloEtherType = 0x88b6
+
+	// helloMagic follows the Ethernet header of hello frames
+	helloMagic = "vswitch-hello"
+
+	// helloMaxField bounds the length of each field of a hello
+	helloMaxField = 256
+)
+
+// Hello is the message a client may send in the first frame of a
+// connection to present a persistent identity and describe the VM behind
+// it. When a client with the same ID reconnects from the same host, the new
+// connection takes over the statistics, label and MAC entries of the old
+// one instead of starting as a brand new peer, and replaces it if it is
+// still open. The VM metadata is reported with the connection in the
+// statistics, logs, events and LLDP advertisements.
+//
+// The message travels as JSON in an Ethernet frame of EtherType 0x88b6
+// whose payload starts with "vswitch-hello", so it uses the same stream
+// framing as any other frame. The switch consumes the frame; it is never
+// forwarded.
+type Hello struct {
+	// ID identifies the client across reconnects, e.g. a VM's UUID and
+	// the name of its NIC. Clients that only report metadata leave it
+	// empty.
+	ID string `json:"id,omitempty"`
+
+	// VMName, VMUUID and Interface describe the VM and its network
+	// interface, as a QEMU wrapper knows them
+	VMName    string `json:"vm_name,omitempty"`
+	VMUUID    string `json:"vm_uuid,omitempty"`
+	Interface string `json:"interface,omitempty"`
+}
+
+// validate checks that a hello carries an ID or metadata, and that no field
+// is too long
+func (h Hello) validate() error {
+	if h.ID == "" && h.VMName == "" && h.VMUUID == "" && h.Interface == "" {
+		return fmt.Errorf("hello needs a client ID or VM metadata")
+	}
+	for _, field := range []string{h.ID, h.VMName, h.VMUUID, h.Interface} {
+		if len(field) > helloMaxField {
+			return fmt.Errorf("hello fields must be at most %d bytes", helloMaxField)
+		}
+	}
+	return nil
+}
+
+// attrs returns the fields of a hello that are set as log attributes
+func (h Hello) attrs() []any {
+	var attrs []any
+	for _, attr := range []struct{ key, value string }{
+		{"client", h.ID},
+		{"vm", h.VMName},
+		{"vm_uuid", h.VMUUID},
+		{"interface", h.Interface},
+	} {
+		if attr.value != "" {
+			attrs = append(attrs, attr.key, attr.value)
+		}
+	}
+	return attrs
+}
+
+// departedClient is an identified client whose connection closed, kept
+// for the MAC timeout in case it returns
+type departedClient struct {
+	conn *Connection
+	host string
+	macs []net.HardwareAddr
+	left time.Time
+}
+
+// HelloFrame builds the hello frame presenting a client's identity and VM
+func HelloFrame(hello Hello) ([]byte, error) {
+	if err := hello.validate(); err != nil {
+		return nil, err
+	}
+	body, err := json.Marshal(hello)
+	if err != nil {
+		return nil, err
+	}
+	return buildEthernet(BroadcastMAC, make(net.HardwareAddr, 6), helloEtherType, append([]byte(helloMagic), body...)), nil
+}
+
+// parseHello returns the message of a hello frame, or false if the frame
+// is not a valid one
+func parseHello(frame *EthernetFrame) (Hello, bool) {
+	var hello Hello
+	if frame.EtherType != helloEtherType || !bytes.HasPrefix(frame.Payload, []byte(helloMagic)) {
+		return hello, false
+	}
+	if err := json.Unmarshal(frame.Payload[len(helloMagic):], &hello); err != nil {
+		return hello, false
+	}
+	return hello, hello.validate() == nil
+}
+
+// greet gives a connection the identity and VM metadata its client
+// presented. If a connection of the same client from the same host is
+// still open, it is closed and its MAC entries and leases move over; if one
+// closed within the MAC timeout, the MACs learned on it are rebound. Either
+// way the new connection carries on the old one's statistics and label.
+func (vs *VirtualSwitch) greet(conn *Connection, hello Hello) {
+	conn.setHello(hello)
+	if vs.lldp != nil {
+		vs.lldp.advertise(conn)
+	}
+	if hello.ID == "" {
+		vs.logger.Info("Client identified", append([]any{"connection", conn.ID}, hello.attrs()...)...)
+		vs.connectionEvent(EventConnectionIdentified, conn, "")
+		return
+	}
+	host := conn.host()
+
+	var previous *Connection
+	vs.connections.Range(func(_, value interface{}) bool {
+		other := value.(*Connection)
+		if other != conn && other.ClientID() == hello.ID && other.host() == host {
+			previous = other
+			return false
+		}
+		return true
+	})
+
+	var macs []net.HardwareAddr
+	if previous != nil {
+		previous.replaced.Store(true)
+		macs = vs.macTable.rebind(previous, conn)
+		vs.macBindings.Range(func(key, value interface{}) bool {
+			if value.(*Connection) == previous {
+				vs.macBindings.Store(key, conn)
+			}
+			return true
+		})
+		_ = previous.Close()
+		for _, mac := range macs {
+			if vs.dhcpSnooper != nil {
+				vs.dhcpSnooper.rebindMAC(mac, conn.ID)
+			}
+			if vs.ndGuard != nil {
+				vs.ndGuard.rebindMAC(mac, conn.ID)
+			}
+		}
+	} else if departed, found := vs.claimDeparted(hello.ID, host); found {
+		previous = departed.conn
+		now := time.Now()
+		for _, mac := range departed.macs {
+			if _, learned := vs.macTable.get(mac); !learned {
+				vs.macTable.store(mac, MACEntry{Connection: conn, LearnedAt: now})
+				macs = append(macs, mac)
+			}
+		}
+	}
+
+	// MACs saved under the client's identity before a restart
+	vs.restoreMACs(conn)
+
+	if previous == nil {
+		vs.logger.Info("Client identified", append([]any{"connection", conn.ID}, hello.attrs()...)...)
+		vs.connectionEvent(EventConnectionIdentified, conn, "")
+		return
+	}
+	conn.inherit(previous)
+	vs.logger.Info("Client reconnected", append([]any{"connection", conn.ID, "previous", previous.ID, "macs", len(macs)}, hello.attrs()...)...)
+	vs.connectionEvent(EventConnectionResumed, conn, "replaces "+previous.ID)
+}
+
+// departClient keeps the MACs learned on a closing connection of an
+// identified client, so they can be rebound if it returns
+func (vs *VirtualSwitch) departClient(conn *Connection) {
+	id := conn.ClientID()
+	if id == "" || conn.replaced.Load() {
+		return
+	}
+
+	var macs []net.HardwareAddr
+	vs.macTable.rangeEntries(func(key macKey, entry MACEntry) {
+		if entry.Connection == conn {
+			macs = append(macs, net.HardwareAddr(bytes.Clone(key[:])))
+		}
+	})
+
+	vs.departedMutex.Lock()
+	defer vs.departedMutex.Unlock()
+	if vs.departed == nil {
+		vs.departed = make(map[string]departedClient)
+	}
+	vs.departed[id] = departedClient{conn: conn, host: conn.host(), macs: macs, left: time.Now()}
+}
+
+// claimDeparted removes and returns the state of a client that left from
+// the given host
+func (vs *VirtualSwitch) claimDeparted(id, host string) (departedClient, bool) {
+	vs.departedMutex.Lock()
+	defer vs.departedMutex.Unlock()
+
+	departed, found := vs.departed[id]
+	if !found || departed.host != host {
+		return departedClient{}, false
+	}
+	delete(vs.departed, id)
+	return departed, true
+}
+
+// expireDeparted forgets the clients that left longer than the MAC
+// timeout ago
+func (vs *VirtualSwitch) expireDeparted(now time.Time) {
+	vs.departedMutex.Lock()
+	defer vs.departedMutex.Unlock()
+
+	for id, departed := range vs.departed {
+		if now.Sub(departed.left) > vs.macTimeout {
+			delete(vs.departed, id)
+		}
+	}
+}
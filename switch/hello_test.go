@@ -0,0 +1,175 @@
+package vswitch
+
+import (
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// helloClient attaches a client to the switch over a pipe, sends the hello
+// unless it is empty and drains what the switch sends it
+func helloClient(t *testing.T, sw *VirtualSwitch, connID string, message Hello) net.Conn {
+	t.Helper()
+
+	client, server := net.Pipe()
+	go func() { _, _ = io.Copy(io.Discard, client) }()
+	sw.Attach(connID, server)
+	if message != (Hello{}) {
+		hello, err := HelloFrame(message)
+		if err != nil {
+			t.Fatalf("Failed to build hello: %v", err)
+		}
+		if _, err := client.Write(qemuFrame(hello)); err != nil {
+			t.Fatalf("Failed to send hello: %v", err)
+		}
+	}
+	return client
+}
+
+// waitUntil polls condition until it holds or a second has passed
+func waitUntil(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting until %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// connectionInfo returns the snapshot of a connection of the switch
+func connectionInfo(sw *VirtualSwitch, id string) (ConnectionInfo, bool) {
+	for _, info := range sw.GetConnections() {
+		if info.ID == id {
+			return info, true
+		}
+	}
+	return ConnectionInfo{}, false
+}
+
+func TestHelloReconnect(t *testing.T) {
+	sw := NewVirtualSwitch([]int{8080})
+	defer sw.Stop()
+
+	mac := net.HardwareAddr{0x52, 0x54, 0x00, 0x00, 0x00, 0x01}
+	frame := qemuFrame(buildEthernet(BroadcastMAC, mac, EtherTypeARP, make([]byte, 46)))
+
+	first := helloClient(t, sw, "first", Hello{ID: "vm-1"})
+	if _, err := first.Write(frame); err != nil {
+		t.Fatalf("Failed to send frame: %v", err)
+	}
+	waitUntil(t, "the MAC is learned", func() bool {
+		conn := sw.lookupMAC(mac)
+		return conn != nil && conn.ID == "first"
+	})
+	if info, _ := connectionInfo(sw, "first"); info.ClientID != "vm-1" {
+		t.Errorf("Expected the client ID to be reported, got %+v", info)
+	}
+
+	// The client reconnects before the switch noticed the old connection
+	// dropping; the new one replaces it
+	second := helloClient(t, sw, "second", Hello{ID: "vm-1"})
+	waitUntil(t, "the old connection is replaced", func() bool {
+		_, found := connectionInfo(sw, "first")
+		return !found
+	})
+	if conn := sw.lookupMAC(mac); conn == nil || conn.ID != "second" {
+		t.Errorf("Expected the MAC to move to the new connection")
+	}
+	info, _ := connectionInfo(sw, "second")
+	// Both hellos and the frame
+	if info.FramesReceived != 3 {
+		t.Errorf("Expected the statistics of the old connection to carry over, got %d frames", info.FramesReceived)
+	}
+
+	// After a clean disconnect its MACs are rebound on return
+	_ = second.Close()
+	waitUntil(t, "the connection closes", func() bool { return sw.lookupMAC(mac) == nil })
+	helloClient(t, sw, "third", Hello{ID: "vm-1"})
+	waitUntil(t, "the MAC is rebound", func() bool {
+		conn := sw.lookupMAC(mac)
+		return conn != nil && conn.ID == "third"
+	})
+	if info, _ := connectionInfo(sw, "third"); info.FramesReceived != 4 {
+		t.Errorf("Expected the statistics to carry over again, got %d frames", info.FramesReceived)
+	}
+}
+
+func TestHelloOtherClients(t *testing.T) {
+	sw := NewVirtualSwitch([]int{8080})
+	defer sw.Stop()
+
+	mac := net.HardwareAddr{0x52, 0x54, 0x00, 0x00, 0x00, 0x01}
+	first := helloClient(t, sw, "first", Hello{ID: "vm-1"})
+	if _, err := first.Write(qemuFrame(buildEthernet(BroadcastMAC, mac, EtherTypeARP, make([]byte, 46)))); err != nil {
+		t.Fatalf("Failed to send frame: %v", err)
+	}
+	waitUntil(t, "the MAC is learned", func() bool { return sw.lookupMAC(mac) != nil })
+
+	// Neither another client nor one without an identity takes it over
+	helloClient(t, sw, "other", Hello{ID: "vm-2"})
+	helloClient(t, sw, "anonymous", Hello{})
+	waitUntil(t, "the other client is identified", func() bool {
+		info, _ := connectionInfo(sw, "other")
+		return info.ClientID == "vm-2"
+	})
+	if _, found := connectionInfo(sw, "first"); !found || sw.lookupMAC(mac).ID != "first" {
+		t.Errorf("Expected the first connection to keep its MAC")
+	}
+
+	// A hello after the first frame is just a frame
+	hello, _ := HelloFrame(Hello{ID: "vm-2"})
+	if _, err := first.Write(qemuFrame(hello)); err != nil {
+		t.Fatalf("Failed to send hello: %v", err)
+	}
+	waitUntil(t, "the frame is received", func() bool {
+		info, _ := connectionInfo(sw, "first")
+		return info.FramesReceived == 3
+	})
+	if info, _ := connectionInfo(sw, "first"); info.ClientID != "vm-1" {
+		t.Errorf("Expected a late hello to be ignored, got %+v", info)
+	}
+	if _, found := connectionInfo(sw, "other"); !found {
+		t.Errorf("Expected a late hello not to replace another client's connection")
+	}
+
+	if _, err := HelloFrame(Hello{}); err == nil {
+		t.Errorf("Expected an error for an empty hello")
+	}
+	if _, err := HelloFrame(Hello{VMName: strings.Repeat("x", helloMaxField+1)}); err == nil {
+		t.Errorf("Expected an error for an overlong VM name")
+	}
+}
+
+func TestHelloVMMetadata(t *testing.T) {
+	events := make(chan MACEvent, 1)
+	sw := NewVirtualSwitchWithConfig([]int{8080}, Config{
+		MACEventHandler: func(event MACEvent) { events <- event },
+	})
+	defer sw.Stop()
+
+	// A QEMU wrapper reports the VM without asking for a persistent identity
+	mac := net.HardwareAddr{0x52, 0x54, 0x00, 0x00, 0x00, 0x01}
+	vm := Hello{VMName: "web-1", VMUUID: "5f0c1a52-3c1e-4d8a-9f5e-1b2c3d4e5f60", Interface: "net0"}
+	client := helloClient(t, sw, "web", vm)
+	if _, err := client.Write(qemuFrame(buildEthernet(BroadcastMAC, mac, EtherTypeARP, make([]byte, 46)))); err != nil {
+		t.Fatalf("Failed to send frame: %v", err)
+	}
+
+	select {
+	case event := <-events:
+		if event.VMName != vm.VMName || event.VMUUID != vm.VMUUID || event.Interface != vm.Interface {
+			t.Errorf("Expected the MAC event to carry the VM metadata, got %+v", event)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Timed out waiting for the MAC event")
+	}
+	info, _ := connectionInfo(sw, "web")
+	if info.ClientID != "" || info.VMName != vm.VMName || info.VMUUID != vm.VMUUID || info.Interface != vm.Interface {
+		t.Errorf("Expected the connection to report the VM metadata, got %+v", info)
+	}
+}
@@ -0,0 +1,124 @@
+package vswitch
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// latencyBuckets are the upper bounds of the forwarding latency histogram
+var latencyBuckets = [...]time.Duration{
+	time.Microsecond,
+	2 * time.Microsecond,
+	5 * time.Microsecond,
+	10 * time.Microsecond,
+	20 * time.Microsecond,
+	50 * time.Microsecond,
+	100 * time.Microsecond,
+	200 * time.Microsecond,
+	500 * time.Microsecond,
+	time.Millisecond,
+	2 * time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+}
+
+// latencyHistogram counts durations in latencyBuckets without locking
+type latencyHistogram struct {
+	counts [len(latencyBuckets) + 1]atomic.Uint64 // Last one is +Inf
+	sum    atomic.Int64                           // Nanoseconds
+}
+
+// HistogramBucket is the number of observations up to an upper bound
+type HistogramBucket struct {
+	UpperBound float64 `json:"le"` // Seconds
+	Count      uint64  `json:"count"`
+}
+
+// LatencyHistogram is a snapshot of a latency histogram with cumulative
+// bucket counts, as used by Prometheus. Count includes the observations
+// beyond the last bucket.
+type LatencyHistogram struct {
+	Buckets    []HistogramBucket `json:"buckets"`
+	Count      uint64            `json:"count"`
+	SumSeconds float64           `json:"sum_seconds"`
+}
+
+// observe records a duration
+func (h *latencyHistogram) observe(d time.Duration) {
+	i := 0
+	for i < len(latencyBuckets) && d > latencyBuckets[i] {
+		i++
+	}
+	h.counts[i].Add(1)
+	h.sum.Add(int64(d))
+}
+
+// snapshot returns the cumulative counts of the histogram
+func (h *latencyHistogram) snapshot() LatencyHistogram {
+	snapshot := LatencyHistogram{Buckets: make([]HistogramBucket, 0, len(latencyBuckets))}
+
+	for i := range h.counts {
+		snapshot.Count += h.counts[i].Load()
+		if i < len(latencyBuckets) {
+			snapshot.Buckets = append(snapshot.Buckets, HistogramBucket{
+				UpperBound: latencyBuckets[i].Seconds(),
+				Count:      snapshot.Count,
+			})
+		}
+	}
+	snapshot.SumSeconds = time.Duration(h.sum.Load()).Seconds()
+
+	return snapshot
+}
+
+// frameSizeBuckets are the upper bounds of the frame size histogram, the
+// RFC 2819 size ranges up to the largest standard frame; larger frames are
+// jumbo frames
+var frameSizeBuckets = [...]int{64, 128, 256, 512, 1024, 1518}
+
+// frameCheckSequenceSize is the size of the Ethernet checksum, stripped
+// from frames but counted in their size on the wire
+const frameCheckSequenceSize = 4
+
+// sizeHistogram counts frame sizes in frameSizeBuckets without locking
+type sizeHistogram struct {
+	counts [len(frameSizeBuckets) + 1]atomic.Uint64 // Last one is jumbo
+	sum    atomic.Uint64                            // Bytes
+}
+
+// SizeHistogram is a snapshot of a frame size histogram with cumulative
+// bucket counts. Count includes the jumbo frames beyond the last bucket.
+type SizeHistogram struct {
+	Buckets  []HistogramBucket `json:"buckets"`
+	Count    uint64            `json:"count"`
+	SumBytes uint64            `json:"sum_bytes"`
+}
+
+// observe records the size of a frame on the wire, including its checksum
+func (h *sizeHistogram) observe(frame *EthernetFrame) {
+	size := len(frame.Raw) + frameCheckSequenceSize
+	i := 0
+	for i < len(frameSizeBuckets) && size > frameSizeBuckets[i] {
+		i++
+	}
+	h.counts[i].Add(1)
+	h.sum.Add(uint64(size))
+}
+
+// snapshot returns the cumulative counts of the histogram
+func (h *sizeHistogram) snapshot() SizeHistogram {
+	snapshot := SizeHistogram{Buckets: make([]HistogramBucket, 0, len(frameSizeBuckets))}
+
+	for i := range h.counts {
+		snapshot.Count += h.counts[i].Load()
+		if i < len(frameSizeBuckets) {
+			snapshot.Buckets = append(snapshot.Buckets, HistogramBucket{
+				UpperBound: float64(frameSizeBuckets[i]),
+				Count:      snapshot.Count,
+			})
+		}
+	}
+	snapshot.SumBytes = h.sum.Load()
+
+	return snapshot
+}
@@ -0,0 +1,56 @@
+package vswitch
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLatencyHistogram(t *testing.T) {
+	var h latencyHistogram
+	h.observe(500 * time.Nanosecond)
+	h.observe(time.Microsecond)
+	h.observe(30 * time.Microsecond)
+	h.observe(time.Second)
+
+	snapshot := h.snapshot()
+	if snapshot.Count != 4 || len(snapshot.Buckets) != len(latencyBuckets) {
+		t.Fatalf("Unexpected snapshot: %+v", snapshot)
+	}
+
+	// Bucket counts are cumulative; the second is beyond every bound
+	expected := map[float64]uint64{1e-06: 2, 2e-05: 2, 5e-05: 3, 0.01: 3}
+	for _, bucket := range snapshot.Buckets {
+		if count, ok := expected[bucket.UpperBound]; ok && bucket.Count != count {
+			t.Errorf("Expected %d observations up to %gs, got %d", count, bucket.UpperBound, bucket.Count)
+		}
+	}
+
+	if sum := snapshot.SumSeconds; sum < 1.0000315 || sum > 1.0000316 {
+		t.Errorf("Unexpected sum %g", sum)
+	}
+}
+
+func TestSizeHistogram(t *testing.T) {
+	var h sizeHistogram
+	for _, size := range []int{60, 124, 125, 1514, 9000} {
+		h.observe(&EthernetFrame{Raw: make([]byte, size)})
+	}
+
+	snapshot := h.snapshot()
+	if snapshot.Count != 5 || len(snapshot.Buckets) != len(frameSizeBuckets) {
+		t.Fatalf("Unexpected snapshot: %+v", snapshot)
+	}
+
+	// Sizes include the checksum: a minimal frame is 64 bytes on the wire,
+	// and the largest standard frame 1518
+	expected := map[float64]uint64{64: 1, 128: 2, 256: 3, 1024: 3, 1518: 4}
+	for _, bucket := range snapshot.Buckets {
+		if count, ok := expected[bucket.UpperBound]; ok && bucket.Count != count {
+			t.Errorf("Expected %d frames up to %g bytes, got %d", count, bucket.UpperBound, bucket.Count)
+		}
+	}
+
+	if sum := snapshot.SumBytes; sum != 60+124+125+1514+9000+5*4 {
+		t.Errorf("Unexpected sum %d", sum)
+	}
+}
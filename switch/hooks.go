@@ -0,0 +1,104 @@
+package vswitch
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// Verdict is what a frame hook decides about a frame
+type Verdict int
+
+const (
+	// VerdictPass hands the frame on to the next hook and then to the switch
+	VerdictPass Verdict = iota
+
+	// VerdictDrop discards the frame, counting it as dropped by a hook
+	VerdictDrop
+
+	// VerdictConsume stops processing the frame, which the hook took over,
+	// without counting it as dropped
+	VerdictConsume
+)
+
+// String returns the name of the verdict
+func (v Verdict) String() string {
+	switch v {
+	case VerdictPass:
+		return "pass"
+	case VerdictDrop:
+		return "drop"
+	case VerdictConsume:
+		return "consume"
+	default:
+		return "unknown"
+	}
+}
+
+// FrameHook inspects a frame on its way through a VLAN. Ingress hooks get
+// the connection the frame came from and may rewrite the frame in place
+// without changing its length. Egress hooks get the connection the frame
+// is about to be sent to and must not modify it, as a flooded frame is
+// shared by all its destinations. Hooks run on the forwarding path and
+// must not block.
+type FrameHook func(frame *EthernetFrame, conn *Connection) Verdict
+
+// hookChain is an ordered list of frame hooks. Hooks are added rarely and
+// run for every frame, so the list is replaced on change and read without
+// locking.
+type hookChain struct {
+	hooks atomic.Pointer[[]FrameHook]
+	mutex sync.Mutex
+}
+
+// add appends hooks to the end of the chain
+func (c *hookChain) add(hooks ...FrameHook) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	var chain []FrameHook
+	if current := c.hooks.Load(); current != nil {
+		chain = append(chain, *current...)
+	}
+	chain = append(chain, hooks...)
+	c.hooks.Store(&chain)
+}
+
+// run passes a frame through the hooks in order, stopping at the first
+// that does not let it pass
+func (c *hookChain) run(frame *EthernetFrame, conn *Connection) Verdict {
+	chain := c.hooks.Load()
+	if chain == nil {
+		return VerdictPass
+	}
+	for _, hook := range *chain {
+		if verdict := hook(frame, conn); verdict != VerdictPass {
+			return verdict
+		}
+	}
+	return VerdictPass
+}
+
+// AddIngressHook appends a hook run on every frame received by the VLAN,
+// after the hooks added before it and before the switch's own filtering,
+// learning and forwarding
+func (vs *VirtualSwitch) AddIngressHook(hook FrameHook) {
+	vs.ingressHooks.add(hook)
+}
+
+// AddEgressHook appends a hook run on every frame before it is sent to a
+// connection, after the hooks added before it
+func (vs *VirtualSwitch) AddEgressHook(hook FrameHook) {
+	vs.egressHooks.add(hook)
+}
+
+// egress runs the egress hooks for a frame about to be sent to a
+// connection and returns whether it may be sent
+func (vs *VirtualSwitch) egress(frame *EthernetFrame, conn *Connection) bool {
+	switch vs.egressHooks.run(frame, conn) {
+	case VerdictPass:
+		return true
+	case VerdictDrop:
+		vs.hookDrops.Add(1)
+	}
+	return false
+}
@@ -0,0 +1,130 @@
+package vswitch
+
+import (
+	"net"
+	"testing"
+)
+
+func TestFrameHooks(t *testing.T) {
+	mac1 := net.HardwareAddr{0x52, 0x54, 0x00, 0x00, 0x00, 0x01}
+	mac2 := net.HardwareAddr{0x52, 0x54, 0x00, 0x00, 0x00, 0x02}
+
+	var order []string
+	sw := NewVirtualSwitchWithConfig([]int{8080}, Config{
+		IngressHooks: []FrameHook{
+			func(frame *EthernetFrame, _ *Connection) Verdict {
+				order = append(order, "first")
+				if frame.EtherType == EtherTypeIPv6 {
+					return VerdictDrop
+				}
+				return VerdictPass
+			},
+			func(frame *EthernetFrame, _ *Connection) Verdict {
+				order = append(order, "second")
+				// Rewrite the EtherType in place
+				frame.Raw[12], frame.Raw[13] = 0x88, 0xb5
+				frame.EtherType = 0x88b5
+				return VerdictPass
+			},
+		},
+	})
+
+	sink1, sink2 := &frameSink{}, &frameSink{}
+	conn1 := NewConnection("conn1", sink1)
+	conn2 := NewConnection("conn2", sink2)
+	sw.connections.Store("conn1", conn1)
+	sw.connections.Store("conn2", conn2)
+
+	// Hooks run in order and may rewrite the frame
+	_ = sw.processFrame(rawFrame(buildEthernet(BroadcastMAC, mac1, EtherTypeARP, make([]byte, 46))), conn1)
+	if len(order) != 2 || order[0] != "first" || order[1] != "second" {
+		t.Errorf("Expected the hooks to run in order, got %v", order)
+	}
+	if frame := sink2.nextFrame(t); frame.EtherType != 0x88b5 {
+		t.Errorf("Expected the rewritten frame to be forwarded, got EtherType %#04x", frame.EtherType)
+	}
+
+	// A dropped frame stops at the first hook and is neither learned nor forwarded
+	order = nil
+	if err := sw.processFrame(rawFrame(buildEthernet(BroadcastMAC, mac2, EtherTypeIPv6, make([]byte, 46))), conn2); err == nil {
+		t.Error("Expected the dropped frame to be reported")
+	}
+	if len(order) != 1 {
+		t.Errorf("Expected the chain to stop at the dropping hook, got %v", order)
+	}
+	if sw.lookupMAC(mac2) != nil {
+		t.Error("Expected the dropped frame's source not to be learned")
+	}
+	if drops := sw.GetStats()["hook_drops"].(uint64); drops != 1 {
+		t.Errorf("Expected 1 hook drop, got %d", drops)
+	}
+
+	// A consumed frame goes no further but is not counted as dropped
+	sw.AddIngressHook(func(*EthernetFrame, *Connection) Verdict { return VerdictConsume })
+	if err := sw.processFrame(rawFrame(buildEthernet(BroadcastMAC, mac2, EtherTypeARP, make([]byte, 46))), conn2); err != nil {
+		t.Errorf("Expected the consumed frame not to be an error: %v", err)
+	}
+	if sw.lookupMAC(mac2) != nil {
+		t.Error("Expected the consumed frame's source not to be learned")
+	}
+	if drops := sw.GetStats()["hook_drops"].(uint64); drops != 1 {
+		t.Errorf("Expected consumed frames not to count as drops, got %d", drops)
+	}
+}
+
+func TestEgressHooks(t *testing.T) {
+	mac1 := net.HardwareAddr{0x52, 0x54, 0x00, 0x00, 0x00, 0x01}
+	mac2 := net.HardwareAddr{0x52, 0x54, 0x00, 0x00, 0x00, 0x02}
+
+	sw := NewVirtualSwitch([]int{8080})
+	sink1, sink2, sink3 := &frameSink{}, &frameSink{}, &frameSink{}
+	conn1 := NewConnection("conn1", sink1)
+	conn2 := NewConnection("conn2", sink2)
+	conn3 := NewConnection("conn3", sink3)
+	sw.connections.Store("conn1", conn1)
+	sw.connections.Store("conn2", conn2)
+	sw.connections.Store("conn3", conn3)
+
+	var sent []string
+	sw.AddEgressHook(func(_ *EthernetFrame, conn *Connection) Verdict {
+		if conn.ID == "conn3" {
+			return VerdictDrop
+		}
+		sent = append(sent, conn.ID)
+		return VerdictPass
+	})
+
+	// Flooded frames pass the hooks once per destination
+	_ = sw.processFrame(rawFrame(buildEthernet(BroadcastMAC, mac1, EtherTypeARP, make([]byte, 46))), conn1)
+	if len(sent) != 1 || sent[0] != "conn2" {
+		t.Errorf("Expected the frame to be sent to conn2 only, got %v", sent)
+	}
+	sink2.nextFrame(t)
+	if len(sink3.data) != 0 {
+		t.Error("Expected the egress hook to keep the frame from conn3")
+	}
+
+	// Forwarded frames pass them too
+	_ = sw.processFrame(rawFrame(buildEthernet(BroadcastMAC, mac2, EtherTypeARP, make([]byte, 46))), conn3)
+	_ = sw.processFrame(rawFrame(buildEthernet(mac2, mac1, EtherTypeIPv4, make([]byte, 46))), conn1)
+	if len(sink3.data) != 0 {
+		t.Error("Expected the egress hook to keep the unicast frame from conn3")
+	}
+	if drops := sw.GetStats()["hook_drops"].(uint64); drops != 2 {
+		t.Errorf("Expected 2 hook drops, got %d", drops)
+	}
+}
+
+func TestManagerFrameHooks(t *testing.T) {
+	sm := NewSwitchManager()
+	hook := func(*EthernetFrame, *Connection) Verdict { return VerdictPass }
+	if err := sm.AddIngressHook(8080, hook); err == nil {
+		t.Error("Expected adding a hook to a missing VLAN to fail")
+	}
+	if err := sm.AddVLAN(8080); err != nil {
+		t.Fatalf("Failed to add VLAN: %v", err)
+	}
+	if err := sm.AddEgressHook(8080, hook); err != nil {
+		t.Errorf("Failed to add egress hook: %v", err)
+	}
+}
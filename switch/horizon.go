@@ -0,0 +1,70 @@
+package vswitch
+
+import (
+	"fmt"
+	"net"
+)
+
+// DefaultClusterHorizon is the horizon group of cluster trunks unless
+// configured otherwise
+const DefaultClusterHorizon = "cluster"
+
+// HorizonGroup is a split horizon group. Frames received from a connection
+// of the group are never forwarded to another connection of the same
+// group, so that switches linked in a full mesh by trunks sharing a group
+// need no spanning tree to avoid loops.
+type HorizonGroup struct {
+	// Name identifies the group
+	Name string
+
+	// Peers are the remote addresses whose connections join the group
+	Peers []*net.IPNet
+}
+
+// horizonGroup returns the name of the first horizon group matching a
+// remote address, or "" if none does
+func (c Config) horizonGroup(addr net.Addr) string {
+	for _, group := range c.HorizonGroups {
+		if matchesPeer(addr, group.Peers) {
+			return group.Name
+		}
+	}
+	return ""
+}
+
+// SetHorizon places the connection in a split horizon group; "" removes it
+// from its group
+func (c *Connection) SetHorizon(group string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.horizon = group
+}
+
+// Horizon returns the split horizon group of the connection, or ""
+func (c *Connection) Horizon() string {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	return c.horizon
+}
+
+// sameHorizon reports whether split horizon keeps frames from c from
+// reaching dst
+func (c *Connection) sameHorizon(dst *Connection) bool {
+	group := c.Horizon()
+	return group != "" && group == dst.Horizon()
+}
+
+// SetHorizon places an active connection in a split horizon group
+func (vs *VirtualSwitch) SetHorizon(connID string, group string) error {
+	value, found := vs.connections.Load(connID)
+	if !found {
+		return fmt.Errorf("connection %s not found", connID)
+	}
+
+	value.(*Connection).SetHorizon(group)
+	vs.logger.Info("Connection horizon group changed", "connection", connID, "horizon", group)
+	vs.syncFastPath()
+	return nil
+}
@@ -0,0 +1,63 @@
+package vswitch
+
+import (
+	"net"
+	"testing"
+)
+
+func TestSplitHorizon(t *testing.T) {
+	mesh, _ := ParseCIDRList("10.0.0.1,10.0.0.2")
+	sw := NewVirtualSwitchWithConfig([]int{8080}, Config{HorizonGroups: []HorizonGroup{{Name: "mesh", Peers: mesh}}})
+
+	sinks := map[string]*mockConnSwitch{}
+	conns := map[string]*Connection{}
+	for id, address := range map[string]string{"trunk1": "10.0.0.1:7000", "trunk2": "10.0.0.2:7000", "guest": "10.0.0.3:7000"} {
+		sinks[id] = &mockConnSwitch{addr: &mockAddrSwitch{network: "tcp", address: address}}
+		conns[id] = NewConnection(id, sinks[id])
+		sw.applyPortDefaults(conns[id])
+		sw.connections.Store(id, conns[id])
+	}
+	if conns["trunk1"].Horizon() != "mesh" || conns["guest"].Horizon() != "" {
+		t.Fatalf("Expected only the trunks in the mesh group, got %q and %q", conns["trunk1"].Horizon(), conns["guest"].Horizon())
+	}
+
+	// A broadcast from one trunk reaches the guest but no other trunk
+	remote := net.HardwareAddr{0x52, 0x54, 0x00, 0x00, 0x00, 0x01}
+	_ = sw.processFrame(rawFrame(buildEthernet(BroadcastMAC, remote, EtherTypeARP, make([]byte, 46))), conns["trunk1"])
+	if len(sinks["guest"].writeData) == 0 {
+		t.Error("Expected a broadcast from a trunk to reach the guest")
+	}
+	if len(sinks["trunk2"].writeData) != 0 {
+		t.Error("Expected a broadcast from a trunk not to be flooded out another trunk")
+	}
+
+	// Nor is unicast relayed between trunks
+	behindTrunk2 := net.HardwareAddr{0x52, 0x54, 0x00, 0x00, 0x00, 0x02}
+	sw.learnMAC(behindTrunk2, conns["trunk2"])
+	_ = sw.processFrame(rawFrame(buildEthernet(behindTrunk2, remote, EtherTypeIPv4, make([]byte, 46))), conns["trunk1"])
+	if len(sinks["trunk2"].writeData) != 0 {
+		t.Error("Expected unicast from a trunk not to be forwarded out another trunk")
+	}
+	if drops := sw.GetStats()["horizon_drops"].(uint64); drops != 1 {
+		t.Errorf("Expected 1 horizon drop, got %d", drops)
+	}
+
+	// Frames from the guest reach both trunks
+	_ = sw.processFrame(rawFrame(buildEthernet(BroadcastMAC, net.HardwareAddr{0x52, 0x54, 0x00, 0x00, 0x00, 0x03}, EtherTypeARP, make([]byte, 46))), conns["guest"])
+	if len(sinks["trunk1"].writeData) == 0 || len(sinks["trunk2"].writeData) == 0 {
+		t.Error("Expected a broadcast from the guest to reach both trunks")
+	}
+
+	// Moving a trunk to another group lets frames cross again
+	if err := sw.SetHorizon("trunk2", "spoke"); err != nil {
+		t.Fatalf("Failed to change the horizon group: %v", err)
+	}
+	sinks["trunk2"].writeData = nil
+	_ = sw.processFrame(rawFrame(buildEthernet(behindTrunk2, remote, EtherTypeIPv4, make([]byte, 46))), conns["trunk1"])
+	if len(sinks["trunk2"].writeData) == 0 {
+		t.Error("Expected unicast to cross between different horizon groups")
+	}
+	if err := sw.SetHorizon("missing", "mesh"); err == nil {
+		t.Error("Expected an error setting the horizon group of a missing connection")
+	}
+}
@@ -0,0 +1,257 @@
+package vswitch
+
+import (
+	"encoding/binary"
+	"net"
+	"sync"
+	"time"
+)
+
+// ipProtoICMP is the IP protocol number of ICMPv4
+const ipProtoICMP = 1
+
+// ICMPv4 message types
+const (
+	icmpEchoReply   = 0
+	icmpEchoRequest = 8
+)
+
+// udpRequest is a UDP datagram addressed to the switch's host stack
+type udpRequest struct {
+	SrcMAC  net.HardwareAddr
+	SrcIP   net.IP
+	DstIP   net.IP
+	SrcPort uint16
+	DstPort uint16
+	Payload []byte
+}
+
+// udpHandler handles a datagram received by the host stack. The request
+// refers to the frame buffer, so handlers must copy anything they keep.
+type udpHandler func(req *udpRequest)
+
+// packetRouter handles IPv4 packets sent to the stack's MAC that the stack
+// does not terminate itself, such as traffic to off-link destinations
+type packetRouter interface {
+	routePacket(srcMAC net.HardwareAddr, packet *ipv4Packet)
+}
+
+// hostStack is the switch's own IPv4 presence on a VLAN. It answers ARP and
+// ping for its addresses and dispatches UDP datagrams sent to them to the
+// services registered on each port.
+type hostStack struct {
+	vs        *VirtualSwitch
+	mac       net.HardwareAddr
+	addrs     []net.IP
+	router    packetRouter
+	neighbors sync.Map // IP string -> net.HardwareAddr
+
+	udpMutex sync.RWMutex
+	udp      map[uint16]udpHandler
+	nextPort uint16
+}
+
+// Ephemeral UDP ports the stack allocates for its own transfers, kept
+// below the range the NAT gateway uses for port forwards
+const (
+	stackFirstEphemeralPort = 32768
+	stackLastEphemeralPort  = 49151
+)
+
+// newHostStack creates a host stack using the switch's service MAC
+func newHostStack(vs *VirtualSwitch) *hostStack {
+	return &hostStack{
+		vs:       vs,
+		mac:      serviceMAC(vs.port()),
+		udp:      make(map[uint16]udpHandler),
+		nextPort: stackFirstEphemeralPort,
+	}
+}
+
+// addAddress adds an IPv4 address the stack answers for
+func (h *hostStack) addAddress(ip net.IP) {
+	if !h.owns(ip) {
+		h.addrs = append(h.addrs, ip.To4())
+	}
+}
+
+// handleUDP registers the handler for datagrams sent to a UDP port
+func (h *hostStack) handleUDP(port uint16, handler udpHandler) {
+	h.udpMutex.Lock()
+	defer h.udpMutex.Unlock()
+	h.udp[port] = handler
+}
+
+// handleEphemeralUDP registers a handler on a free ephemeral port and
+// returns the port, or 0 if none is free
+func (h *hostStack) handleEphemeralUDP(handler udpHandler) uint16 {
+	h.udpMutex.Lock()
+	defer h.udpMutex.Unlock()
+
+	for i := 0; i <= stackLastEphemeralPort-stackFirstEphemeralPort; i++ {
+		port := h.nextPort
+		if h.nextPort++; h.nextPort > stackLastEphemeralPort {
+			h.nextPort = stackFirstEphemeralPort
+		}
+		if _, found := h.udp[port]; !found {
+			h.udp[port] = handler
+			return port
+		}
+	}
+	return 0
+}
+
+// removeUDP unregisters the handler of a UDP port
+func (h *hostStack) removeUDP(port uint16) {
+	h.udpMutex.Lock()
+	defer h.udpMutex.Unlock()
+	delete(h.udp, port)
+}
+
+// owns reports whether the IP address belongs to the stack
+func (h *hostStack) owns(ip net.IP) bool {
+	for _, addr := range h.addrs {
+		if addr.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// setRouter installs the handler for packets the stack does not terminate
+func (h *hostStack) setRouter(router packetRouter) {
+	h.router = router
+}
+
+// learn records the MAC address of a host on the VLAN
+func (h *hostStack) learn(ip net.IP, mac net.HardwareAddr) {
+	h.neighbors.Store(ip.String(), append(net.HardwareAddr{}, mac...))
+}
+
+// resolve returns the MAC address of a host on the VLAN, sending ARP
+// requests until it answers or the timeout expires. It returns nil if the
+// address could not be resolved.
+func (h *hostStack) resolve(ip net.IP, timeout time.Duration) net.HardwareAddr {
+	if len(h.addrs) == 0 {
+		return nil
+	}
+
+	request := &arpPacket{
+		Op:        arpRequest,
+		SenderMAC: h.mac,
+		SenderIP:  h.addrs[0],
+		TargetMAC: make(net.HardwareAddr, 6),
+		TargetIP:  ip,
+	}
+
+	deadline := time.Now().Add(timeout)
+	for attempt := 0; ; attempt++ {
+		if mac, found := h.neighbors.Load(ip.String()); found {
+			return mac.(net.HardwareAddr)
+		}
+		if !time.Now().Before(deadline) {
+			return nil
+		}
+		if attempt%10 == 0 {
+			h.vs.injectFrame(buildEthernet(BroadcastMAC, h.mac, EtherTypeARP, request.marshal()))
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// handleFrame consumes ARP traffic and IPv4 packets for the stack
+func (h *hostStack) handleFrame(frame *EthernetFrame, _ *Connection) bool {
+	switch frame.EtherType {
+	case EtherTypeARP:
+		packet, err := parseARP(frame.Payload)
+		if err != nil {
+			return false
+		}
+		if packet.Op == arpReply && packet.TargetMAC.String() == h.mac.String() {
+			h.learn(packet.SenderIP, packet.SenderMAC)
+			return true
+		}
+		if packet.Op != arpRequest || !h.owns(packet.TargetIP) {
+			return false
+		}
+		h.learn(packet.SenderIP, packet.SenderMAC)
+		h.vs.injectFrame(buildARPReply(packet, h.mac))
+		return true
+	case EtherTypeIPv4:
+		if frame.DestMAC.String() != h.mac.String() {
+			return false
+		}
+		packet, err := parseIPv4(frame.Payload)
+		if err != nil || (h.router == nil && !h.owns(packet.Dst)) {
+			return false
+		}
+		if packet.FragmentOffset != 0 || packet.MoreFragments {
+			return true
+		}
+		if h.router != nil {
+			h.learn(packet.Src, frame.SrcMAC)
+		}
+		if !h.owns(packet.Dst) || !h.handleIPv4(frame, packet) {
+			if h.router != nil {
+				h.router.routePacket(frame.SrcMAC, packet)
+			}
+		}
+		return true
+	}
+
+	return false
+}
+
+// handleIPv4 dispatches a packet addressed to the stack. It returns false if
+// no local handler accepted the packet.
+func (h *hostStack) handleIPv4(frame *EthernetFrame, packet *ipv4Packet) bool {
+	switch packet.Protocol {
+	case ipProtoICMP:
+		msg := packet.Payload
+		if len(msg) < 8 || msg[0] != icmpEchoRequest {
+			return false
+		}
+		reply := append([]byte{}, msg...)
+		reply[0] = icmpEchoReply
+		reply[2] = 0
+		reply[3] = 0
+		binary.BigEndian.PutUint16(reply[2:4], checksumFinish(checksumAdd(0, reply)))
+		h.vs.injectFrame(buildEthernet(frame.SrcMAC, h.mac, EtherTypeIPv4,
+			buildIPv4(packet.Dst, packet.Src, ipProtoICMP, 64, reply)))
+		return true
+	case ipProtoUDP:
+		udp, err := parseUDP(packet.Payload)
+		if err != nil {
+			return true
+		}
+		h.udpMutex.RLock()
+		handler, found := h.udp[udp.DstPort]
+		h.udpMutex.RUnlock()
+		if found {
+			handler(&udpRequest{
+				SrcMAC:  frame.SrcMAC,
+				SrcIP:   packet.Src,
+				DstIP:   packet.Dst,
+				SrcPort: udp.SrcPort,
+				DstPort: udp.DstPort,
+				Payload: udp.Payload,
+			})
+			return true
+		}
+	}
+
+	return false
+}
+
+// sendUDP sends a datagram from the stack to a host on the VLAN
+func (h *hostStack) sendUDP(dstMAC net.HardwareAddr, srcIP, dstIP net.IP, srcPort, dstPort uint16, payload []byte) {
+	h.vs.injectFrame(buildEthernet(dstMAC, h.mac, EtherTypeIPv4, buildUDPv4(srcIP, dstIP, srcPort, dstPort, payload)))
+}
+
+// reply answers a request from the port it was sent to
+func (h *hostStack) reply(req *udpRequest, payload []byte) {
+	h.sendUDP(req.SrcMAC, req.DstIP, req.SrcIP, req.DstPort, req.SrcPort, payload)
+}
+
+// run returns immediately; the stack is driven entirely by received frames
+func (h *hostStack) run(_ <-chan bool) {}
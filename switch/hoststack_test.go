@@ -0,0 +1,113 @@
+package vswitch
+
+import (
+	"net"
+	"testing"
+)
+
+// newTestStack creates a switch with a host stack and one guest connection
+func newTestStack(t *testing.T, addr net.IP) (*VirtualSwitch, *hostStack, *Connection, *mockConnSwitch) {
+	t.Helper()
+
+	sw := NewVirtualSwitch([]int{8080})
+	stack := newHostStack(sw)
+	stack.addAddress(addr)
+	sw.services = append(sw.services, stack)
+
+	mockConn := &mockConnSwitch{addr: &mockAddrSwitch{network: "tcp", address: "127.0.0.1:9001"}}
+	conn := NewConnection("guest", mockConn)
+	sw.connections.Store("guest", conn)
+
+	return sw, stack, conn, mockConn
+}
+
+// rawFrame parses raw bytes into a non-pooled frame
+func rawFrame(raw []byte) *EthernetFrame {
+	frame, _ := ParseEthernetFrame(raw)
+	frame.pooled = false
+	return frame
+}
+
+func TestHostStackARP(t *testing.T) {
+	addr := net.ParseIP("10.0.0.1")
+	sw, stack, conn, mockConn := newTestStack(t, addr)
+
+	guestMAC := net.HardwareAddr{0x52, 0x54, 0x00, 0x00, 0x00, 0x01}
+	request := &arpPacket{
+		Op:        arpRequest,
+		SenderMAC: guestMAC,
+		SenderIP:  net.ParseIP("10.0.0.10"),
+		TargetMAC: make(net.HardwareAddr, 6),
+		TargetIP:  addr,
+	}
+
+	_ = sw.processFrame(rawFrame(buildEthernet(BroadcastMAC, guestMAC, EtherTypeARP, request.marshal())), conn)
+
+	frames := writtenFrames(t, mockConn.writeData)
+	if len(frames) != 1 {
+		t.Fatalf("Expected 1 ARP reply, got %d frames", len(frames))
+	}
+
+	reply, err := parseARP(rawFrame(frames[0]).Payload)
+	if err != nil || reply.Op != arpReply || reply.SenderMAC.String() != stack.mac.String() {
+		t.Errorf("Unexpected ARP reply %+v (%v)", reply, err)
+	}
+
+	// Requests for other addresses are left alone
+	request.TargetIP = net.ParseIP("10.0.0.2")
+	if stack.handleFrame(rawFrame(buildEthernet(BroadcastMAC, guestMAC, EtherTypeARP, request.marshal())), conn) {
+		t.Errorf("Expected ARP for a foreign address not to be consumed")
+	}
+}
+
+func TestHostStackPing(t *testing.T) {
+	addr := net.ParseIP("10.0.0.1")
+	sw, stack, conn, mockConn := newTestStack(t, addr)
+
+	guestMAC := net.HardwareAddr{0x52, 0x54, 0x00, 0x00, 0x00, 0x01}
+	guestIP := net.ParseIP("10.0.0.10")
+	echo := []byte{icmpEchoRequest, 0, 0, 0, 0x12, 0x34, 0, 1, 'p', 'i', 'n', 'g'}
+	packet := buildIPv4(guestIP, addr, ipProtoICMP, 64, echo)
+
+	_ = sw.processFrame(rawFrame(buildEthernet(stack.mac, guestMAC, EtherTypeIPv4, packet)), conn)
+
+	frames := writtenFrames(t, mockConn.writeData)
+	if len(frames) != 1 {
+		t.Fatalf("Expected 1 echo reply, got %d frames", len(frames))
+	}
+
+	ip, err := parseIPv4(rawFrame(frames[0]).Payload)
+	if err != nil {
+		t.Fatalf("Failed to parse reply: %v", err)
+	}
+
+	if !ip.Dst.Equal(guestIP) || ip.Payload[0] != icmpEchoReply || string(ip.Payload[8:]) != "ping" {
+		t.Errorf("Unexpected echo reply to %s type %d", ip.Dst, ip.Payload[0])
+	}
+
+	if checksumFinish(checksumAdd(0, ip.Payload)) != 0 {
+		t.Errorf("Echo reply checksum does not verify")
+	}
+}
+
+func TestHostStackUDPDispatch(t *testing.T) {
+	addr := net.ParseIP("10.0.0.1")
+	sw, stack, conn, _ := newTestStack(t, addr)
+
+	var received *udpRequest
+	stack.handleUDP(7, func(req *udpRequest) {
+		received = req
+	})
+
+	guestMAC := net.HardwareAddr{0x52, 0x54, 0x00, 0x00, 0x00, 0x01}
+	packet := buildUDPv4(net.ParseIP("10.0.0.10"), addr, 40000, 7, []byte("echo"))
+	_ = sw.processFrame(rawFrame(buildEthernet(stack.mac, guestMAC, EtherTypeIPv4, packet)), conn)
+
+	if received == nil {
+		t.Fatalf("Expected UDP handler to be called")
+	}
+
+	if received.SrcPort != 40000 || string(received.Payload) != "echo" || received.SrcMAC.String() != guestMAC.String() {
+		t.Errorf("Unexpected request %+v", received)
+	}
+}
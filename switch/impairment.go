@@ -0,0 +1,308 @@
+package vswitch
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand/v2"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Impairment degrades the frames sent to connections on egress, like netem
+// would, so that guests can be tested against a bad network without
+// privileges on the host. Percentages are of the frames sent; the zero
+// value leaves frames untouched.
+type Impairment struct {
+	// Delay holds every frame back before it is sent, give or take up to
+	// Jitter chosen uniformly at random. Jitter reorders the frames it
+	// delays by different amounts.
+	Delay  time.Duration
+	Jitter time.Duration
+
+	// Loss drops, Duplicate sends twice and Reorder sends at once, ahead
+	// of the frames held back by Delay, this percentage of the frames
+	Loss      float64
+	Duplicate float64
+	Reorder   float64
+
+	// Limit is the number of frames a connection may hold back for Delay
+	// at once; frames beyond it are dropped. Zero means
+	// defaultImpairmentLimit.
+	Limit int
+}
+
+// defaultImpairmentLimit is the number of delayed frames a connection holds
+// back when the impairment sets no limit, the default of netem
+const defaultImpairmentLimit = 1000
+
+// impairmentJSON is the JSON form of an Impairment, with readable durations
+type impairmentJSON struct {
+	Delay     string  `json:"delay,omitempty"`
+	Jitter    string  `json:"jitter,omitempty"`
+	Loss      float64 `json:"loss,omitempty"`
+	Duplicate float64 `json:"duplicate,omitempty"`
+	Reorder   float64 `json:"reorder,omitempty"`
+	Limit     int     `json:"limit,omitempty"`
+}
+
+// IsZero reports whether the impairment leaves frames untouched, which a
+// limit alone does not change
+func (i Impairment) IsZero() bool {
+	i.Limit = 0
+	return i == Impairment{}
+}
+
+// validate checks that the durations and percentages are in range
+func (i Impairment) validate() error {
+	if i.Delay < 0 || i.Jitter < 0 {
+		return fmt.Errorf("impairment delay and jitter must not be negative")
+	}
+	if i.Limit < 0 {
+		return fmt.Errorf("impairment limit must not be negative")
+	}
+	for _, percentage := range []struct {
+		name  string
+		value float64
+	}{{"loss", i.Loss}, {"duplicate", i.Duplicate}, {"reorder", i.Reorder}} {
+		if !(percentage.value >= 0 && percentage.value <= 100) {
+			return fmt.Errorf("impairment %s must be a percentage between 0 and 100", percentage.name)
+		}
+	}
+	if i.Reorder > 0 && i.Delay == 0 {
+		return fmt.Errorf("impairment reorder needs a delay to send frames ahead of")
+	}
+	return nil
+}
+
+// ParseImpairment parses comma-separated name:value terms, e.g.
+// delay:50ms,jitter:10ms,loss:1%, into a valid impairment. Percentages may
+// omit the % sign.
+func ParseImpairment(spec string) (Impairment, error) {
+	var impairment Impairment
+	for _, term := range strings.Split(spec, ",") {
+		term = strings.TrimSpace(term)
+		if term == "" {
+			continue
+		}
+		name, value, found := strings.Cut(term, ":")
+		if !found {
+			return Impairment{}, fmt.Errorf("invalid impairment '%s': expected name:value", term)
+		}
+
+		var err error
+		switch name {
+		case "delay":
+			impairment.Delay, err = time.ParseDuration(value)
+		case "jitter":
+			impairment.Jitter, err = time.ParseDuration(value)
+		case "loss":
+			impairment.Loss, err = strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64)
+		case "duplicate":
+			impairment.Duplicate, err = strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64)
+		case "reorder":
+			impairment.Reorder, err = strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64)
+		case "limit":
+			impairment.Limit, err = strconv.Atoi(value)
+		default:
+			return Impairment{}, fmt.Errorf("unknown impairment '%s'", name)
+		}
+		if err != nil {
+			return Impairment{}, fmt.Errorf("invalid impairment '%s': %v", term, err)
+		}
+	}
+	return impairment, impairment.validate()
+}
+
+// String formats the impairment as ParseImpairment parses it, or "none"
+func (i Impairment) String() string {
+	if i.IsZero() {
+		return "none"
+	}
+
+	var terms []string
+	if i.Delay > 0 {
+		terms = append(terms, "delay:"+i.Delay.String())
+	}
+	if i.Jitter > 0 {
+		terms = append(terms, "jitter:"+i.Jitter.String())
+	}
+	for _, percentage := range []struct {
+		name  string
+		value float64
+	}{{"loss", i.Loss}, {"duplicate", i.Duplicate}, {"reorder", i.Reorder}} {
+		if percentage.value > 0 {
+			terms = append(terms, percentage.name+":"+strconv.FormatFloat(percentage.value, 'g', -1, 64)+"%")
+		}
+	}
+	if i.Limit > 0 {
+		terms = append(terms, "limit:"+strconv.Itoa(i.Limit))
+	}
+	return strings.Join(terms, ",")
+}
+
+// MarshalJSON encodes the impairment with durations such as "50ms"
+func (i Impairment) MarshalJSON() ([]byte, error) {
+	encoded := impairmentJSON{Loss: i.Loss, Duplicate: i.Duplicate, Reorder: i.Reorder, Limit: i.Limit}
+	if i.Delay > 0 {
+		encoded.Delay = i.Delay.String()
+	}
+	if i.Jitter > 0 {
+		encoded.Jitter = i.Jitter.String()
+	}
+	return json.Marshal(encoded)
+}
+
+// UnmarshalJSON decodes an impairment encoded by MarshalJSON
+func (i *Impairment) UnmarshalJSON(data []byte) error {
+	var encoded impairmentJSON
+	if err := json.Unmarshal(data, &encoded); err != nil {
+		return err
+	}
+
+	decoded := Impairment{Loss: encoded.Loss, Duplicate: encoded.Duplicate, Reorder: encoded.Reorder, Limit: encoded.Limit}
+	if err := parseSettingDuration("delay", encoded.Delay, &decoded.Delay); err != nil {
+		return err
+	}
+	if err := parseSettingDuration("jitter", encoded.Jitter, &decoded.Jitter); err != nil {
+		return err
+	}
+	*i = decoded
+	return nil
+}
+
+// delay returns how long to hold a frame back, zero to send it at once
+func (i *Impairment) delay() time.Duration {
+	if i.Delay == 0 || chance(i.Reorder) {
+		return 0
+	}
+	delay := i.Delay
+	if i.Jitter > 0 {
+		delay += time.Duration(rand.Int64N(int64(2*i.Jitter)+1)) - i.Jitter
+	}
+	return delay
+}
+
+// limit returns the number of frames a connection may hold back
+func (i *Impairment) limit() int64 {
+	if i.Limit > 0 {
+		return int64(i.Limit)
+	}
+	return defaultImpairmentLimit
+}
+
+// chance returns true with the given percentage of probability
+func chance(percentage float64) bool {
+	return percentage > 0 && rand.Float64()*100 < percentage
+}
+
+// currentImpairment returns the impairment of the frames sent to the
+// connection: its own if it has one, or else its VLAN's
+func (c *Connection) currentImpairment() *Impairment {
+	if impairment := c.impairment.Load(); impairment != nil {
+		return impairment
+	}
+	if c.vlanImpairment != nil {
+		return c.vlanImpairment.Load()
+	}
+	return nil
+}
+
+// SetImpairment gives the connection its own impairment, overriding its
+// VLAN's; a zero impairment exempts it. With nil it follows its VLAN's
+// again.
+func (c *Connection) SetImpairment(impairment *Impairment) {
+	c.impairment.Store(impairment)
+}
+
+// sendImpaired sends a frame through an impairment: it may be dropped,
+// duplicated, or held back and sent once its delay expired. Frames beyond
+// the impairment's limit of those held back are dropped.
+func (c *Connection) sendImpaired(frame *EthernetFrame, impairment *Impairment) error {
+	if chance(impairment.Loss) {
+		c.impairmentDrops.Add(1)
+		return nil
+	}
+
+	copies := 1
+	if chance(impairment.Duplicate) {
+		copies = 2
+	}
+	for range copies {
+		delay := impairment.delay()
+		if delay <= 0 {
+			if err := c.send(frame); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if c.impairmentHeld.Add(1) > impairment.limit() {
+			c.impairmentHeld.Add(-1)
+			c.impairmentDrops.Add(1)
+			continue
+		}
+		held, err := hold(frame)
+		if err != nil {
+			c.impairmentHeld.Add(-1)
+			return err
+		}
+		time.AfterFunc(delay, func() {
+			if err := c.send(held); err != nil {
+				c.logger.Debug("Failed to send delayed frame", "connection", c.ID, "error", err)
+			}
+			held.Release()
+			c.impairmentHeld.Add(-1)
+		})
+	}
+	return nil
+}
+
+// SetImpairment sets the impairment of the frames the VLAN sends to its
+// connections, except those with their own. A zero impairment removes it.
+func (vs *VirtualSwitch) SetImpairment(impairment Impairment) error {
+	if err := impairment.validate(); err != nil {
+		return err
+	}
+	if impairment.IsZero() {
+		vs.impairment.Store(nil)
+	} else {
+		vs.impairment.Store(&impairment)
+	}
+	vs.logger.Info("VLAN impairment changed", "impairment", impairment.String())
+	vs.syncFastPath()
+	return nil
+}
+
+// Impairment returns the impairment of the frames the VLAN sends
+func (vs *VirtualSwitch) Impairment() Impairment {
+	if impairment := vs.impairment.Load(); impairment != nil {
+		return *impairment
+	}
+	return Impairment{}
+}
+
+// SetConnectionImpairment gives a connection its own impairment, or with
+// nil makes it follow the VLAN's again
+func (vs *VirtualSwitch) SetConnectionImpairment(connID string, impairment *Impairment) error {
+	if impairment != nil {
+		if err := impairment.validate(); err != nil {
+			return err
+		}
+		copied := *impairment
+		impairment = &copied
+	}
+	value, found := vs.connections.Load(connID)
+	if !found {
+		return fmt.Errorf("connection %s not found", connID)
+	}
+
+	value.(*Connection).SetImpairment(impairment)
+	if impairment != nil {
+		vs.logger.Info("Connection impairment changed", "connection", connID, "impairment", impairment.String())
+	} else {
+		vs.logger.Info("Connection impairment removed", "connection", connID)
+	}
+	vs.syncFastPath()
+	return nil
+}
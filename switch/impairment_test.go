@@ -0,0 +1,187 @@
+package vswitch
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestParseImpairment(t *testing.T) {
+	impairment, err := ParseImpairment("delay:50ms, jitter:10ms,loss:1.5%,duplicate:2,reorder:5%,limit:100")
+	if err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+	expected := Impairment{Delay: 50 * time.Millisecond, Jitter: 10 * time.Millisecond, Loss: 1.5, Duplicate: 2, Reorder: 5, Limit: 100}
+	if impairment != expected {
+		t.Errorf("Expected %+v, got %+v", expected, impairment)
+	}
+	if reparsed, err := ParseImpairment(impairment.String()); err != nil || reparsed != impairment {
+		t.Errorf("Expected %s to parse back, got %+v, %v", impairment, reparsed, err)
+	}
+	if none := (Impairment{}).String(); none != "none" {
+		t.Errorf("Expected none, got %s", none)
+	}
+
+	for _, spec := range []string{"delay", "latency:5ms", "delay:soon", "loss:101%", "loss:-1", "delay:-5ms", "reorder:5%", "limit:-1", "limit:many"} {
+		if _, err := ParseImpairment(spec); err == nil {
+			t.Errorf("Expected %s to be rejected", spec)
+		}
+	}
+}
+
+func TestImpairmentJSON(t *testing.T) {
+	impairment := Impairment{Delay: 50 * time.Millisecond, Loss: 1}
+	data, err := json.Marshal(impairment)
+	if err != nil {
+		t.Fatalf("Failed to marshal: %v", err)
+	}
+	if string(data) != `{"delay":"50ms","loss":1}` {
+		t.Errorf("Unexpected JSON %s", data)
+	}
+
+	var decoded Impairment
+	if err := json.Unmarshal(data, &decoded); err != nil || decoded != impairment {
+		t.Errorf("Expected %+v, got %+v, %v", impairment, decoded, err)
+	}
+	if err := json.Unmarshal([]byte(`{"delay":"soon"}`), &decoded); err == nil {
+		t.Errorf("Expected an invalid delay to be rejected")
+	}
+}
+
+func TestConnectionImpairment(t *testing.T) {
+	sink := &frameSink{}
+	conn := NewConnection("guest", sink)
+	raw := buildEthernet(BroadcastMAC, net.HardwareAddr{0x52, 0x54, 0, 0, 0, 1}, EtherTypeIPv4, make([]byte, 46))
+
+	// Lost frames are counted and never written
+	conn.SetImpairment(&Impairment{Loss: 100})
+	if err := conn.SendFrame(rawFrame(raw)); err != nil {
+		t.Fatalf("Failed to send: %v", err)
+	}
+	if info := conn.Info(); info.ImpairmentDrops != 1 || info.FramesSent != 0 {
+		t.Errorf("Expected the frame to be dropped, got %+v", info)
+	}
+
+	// Duplicated frames are written twice
+	conn.SetImpairment(&Impairment{Duplicate: 100})
+	if err := conn.SendFrame(rawFrame(raw)); err != nil {
+		t.Fatalf("Failed to send: %v", err)
+	}
+	if info := conn.Info(); info.FramesSent != 2 {
+		t.Errorf("Expected the frame to be sent twice, got %d", info.FramesSent)
+	}
+
+	// Delayed frames are written once the delay expired
+	conn.SetImpairment(&Impairment{Delay: 50 * time.Millisecond})
+	sent := time.Now()
+	if err := conn.SendFrame(rawFrame(raw)); err != nil {
+		t.Fatalf("Failed to send: %v", err)
+	}
+	if info := conn.Info(); info.FramesSent != 2 {
+		t.Errorf("Expected the frame to be held back")
+	}
+	sink.nextFrame(t)
+	sink.nextFrame(t)
+	sink.nextFrame(t)
+	if elapsed := time.Since(sent); elapsed < 50*time.Millisecond {
+		t.Errorf("Expected the frame to be delayed by 50ms, got %v", elapsed)
+	}
+
+	// Delayed frames beyond the limit are dropped
+	conn.SetImpairment(&Impairment{Delay: 50 * time.Millisecond, Limit: 2})
+	for range 3 {
+		if err := conn.SendFrame(rawFrame(raw)); err != nil {
+			t.Fatalf("Failed to send: %v", err)
+		}
+	}
+	if drops := conn.Info().ImpairmentDrops; drops != 2 {
+		t.Errorf("Expected the frame beyond the limit to be dropped, got %d drops", drops)
+	}
+	sink.nextFrame(t)
+	sink.nextFrame(t)
+	waitUntil(t, "the held frames are released", func() bool { return conn.impairmentHeld.Load() == 0 })
+}
+
+func TestVLANImpairment(t *testing.T) {
+	sw := NewVirtualSwitchWithConfig([]int{8080}, Config{Impairment: Impairment{Loss: 100}})
+	conn := NewConnection("guest", &frameSink{})
+	sw.attachConnection(conn)
+	raw := buildEthernet(BroadcastMAC, net.HardwareAddr{0x52, 0x54, 0, 0, 0, 1}, EtherTypeIPv4, make([]byte, 46))
+
+	send := func() uint64 {
+		t.Helper()
+		if err := conn.SendFrame(rawFrame(raw)); err != nil {
+			t.Fatalf("Failed to send: %v", err)
+		}
+		return conn.Info().ImpairmentDrops
+	}
+	if drops := send(); drops != 1 {
+		t.Errorf("Expected the VLAN's impairment to apply, got %d drops", drops)
+	}
+
+	// A connection's own impairment overrides the VLAN's until removed
+	if err := sw.SetConnectionImpairment("guest", &Impairment{}); err != nil {
+		t.Fatalf("Failed to set the connection's impairment: %v", err)
+	}
+	if drops := send(); drops != 1 {
+		t.Errorf("Expected the connection to be exempt, got %d drops", drops)
+	}
+	if err := sw.SetConnectionImpairment("guest", nil); err != nil {
+		t.Fatalf("Failed to remove the connection's impairment: %v", err)
+	}
+	if drops := send(); drops != 2 {
+		t.Errorf("Expected the VLAN's impairment to apply again, got %d drops", drops)
+	}
+
+	if err := sw.SetImpairment(Impairment{}); err != nil {
+		t.Fatalf("Failed to remove the VLAN's impairment: %v", err)
+	}
+	if drops := send(); drops != 2 {
+		t.Errorf("Expected no more impairment, got %d drops", drops)
+	}
+	if err := sw.SetImpairment(Impairment{Reorder: 10}); err == nil {
+		t.Errorf("Expected reordering without a delay to be rejected")
+	}
+	if err := sw.SetConnectionImpairment("missing", nil); err == nil {
+		t.Errorf("Expected an unknown connection to be rejected")
+	}
+}
+
+func TestAPIImpairment(t *testing.T) {
+	sm := NewSwitchManager()
+	if err := sm.AddVLAN(8080); err != nil {
+		t.Fatalf("Failed to add VLAN: %v", err)
+	}
+	handler := NewAPIHandler(sm, "secret")
+
+	if status := apiRequest(handler, http.MethodPut, "/vlans/8080/impairment", `{"delay":"20ms","loss":5}`, "secret"); status != http.StatusOK {
+		t.Fatalf("Expected the impairment to be set, got %d", status)
+	}
+	if impairment := sm.vlan(8080).Impairment(); impairment != (Impairment{Delay: 20 * time.Millisecond, Loss: 5}) {
+		t.Errorf("Unexpected impairment %+v", impairment)
+	}
+	if settings := sm.Snapshot().VLANs[0].Settings; settings.Impairment == nil || settings.Impairment.Loss != 5 {
+		t.Errorf("Expected the impairment in the snapshot, got %+v", settings.Impairment)
+	}
+
+	for _, body := range []string{`{"loss":200}`, `{"delay":"soon"}`, `{"reorder":5}`} {
+		if status := apiRequest(handler, http.MethodPut, "/vlans/8080/impairment", body, "secret"); status != http.StatusBadRequest {
+			t.Errorf("Expected %s to be rejected, got %d", body, status)
+		}
+	}
+	if status := apiRequest(handler, http.MethodPut, "/vlans/8081/impairment", `{"loss":5}`, "secret"); status != http.StatusNotFound {
+		t.Errorf("Expected an unknown VLAN to be rejected, got %d", status)
+	}
+	if status := apiRequest(handler, http.MethodPut, "/vlans/8080/connections/missing/impairment", `{"loss":5}`, "secret"); status != http.StatusNotFound {
+		t.Errorf("Expected an unknown connection to be rejected, got %d", status)
+	}
+
+	if status := apiRequest(handler, http.MethodDelete, "/vlans/8080/impairment", "", "secret"); status != http.StatusOK {
+		t.Fatalf("Expected the impairment to be removed, got %d", status)
+	}
+	if impairment := sm.vlan(8080).Impairment(); !impairment.IsZero() {
+		t.Errorf("Expected no impairment, got %+v", impairment)
+	}
+}
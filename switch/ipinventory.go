@@ -0,0 +1,283 @@
+package vswitch
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+// maxIPInventory is the number of addresses the inventory of a VLAN
+// records; further addresses are ignored until others age out
+const maxIPInventory = 4096
+
+// maxHeaderAddresses is the number of addresses a MAC is recorded with from
+// IPv4 headers alone. A MAC sending from more addresses is a router
+// forwarding other networks' packets, whose addresses would only crowd the
+// inventory.
+const maxHeaderAddresses = 8
+
+// Sources of the addresses in the IP inventory
+const (
+	IPSourceARP  = "arp"
+	IPSourceIPv4 = "ipv4"
+	IPSourceNDP  = "ndp"
+)
+
+// IPInventoryEntry is an IP address seen on a VLAN, with the MAC and the
+// connection it was seen from
+type IPInventoryEntry struct {
+	VLAN         int       `json:"vlan"`
+	IP           net.IP    `json:"ip"`
+	MAC          string    `json:"mac"`
+	Vendor       string    `json:"vendor,omitempty"`
+	ConnectionID string    `json:"connection_id"`
+	Label        string    `json:"label,omitempty"`
+	VMName       string    `json:"vm_name,omitempty"`
+	Source       string    `json:"source"`
+	FirstSeen    time.Time `json:"first_seen"`
+	LastSeen     time.Time `json:"last_seen"`
+}
+
+// ipSighting is where an address of the inventory was last seen
+type ipSighting struct {
+	mac       macKey
+	conn      *Connection
+	source    string
+	firstSeen time.Time
+	lastSeen  time.Time
+}
+
+// ipInventory records the addresses the guests of a VLAN use, learned
+// passively from the sender of ARP packets, the source of IPv4 packets and
+// the addresses IPv6 neighbor solicitations and advertisements are sent for,
+// so that operators can tell which VM has an address
+type ipInventory struct {
+	vs *VirtualSwitch
+
+	mutex     sync.Mutex
+	sightings map[[16]byte]*ipSighting
+	fromIP    map[macKey]int // addresses of each MAC learned from IPv4 headers
+}
+
+// newIPInventory creates an empty IP inventory
+func newIPInventory(vs *VirtualSwitch) *ipInventory {
+	return &ipInventory{
+		vs:        vs,
+		sightings: make(map[[16]byte]*ipSighting),
+		fromIP:    make(map[macKey]int),
+	}
+}
+
+// observe records the source address of an ARP or IPv4 frame, or the
+// address of an IPv6 neighbor discovery message
+func (inv *ipInventory) observe(frame *EthernetFrame, conn *Connection) {
+	switch frame.EtherType {
+	case EtherTypeARP:
+		packet, err := parseARP(frame.Payload)
+		if err != nil {
+			return
+		}
+		inv.record(packet.SenderIP, packet.SenderMAC, conn, IPSourceARP)
+	case EtherTypeIPv4:
+		packet, err := parseIPv4(frame.Payload)
+		if err != nil {
+			return
+		}
+		inv.record(packet.Src, frame.SrcMAC, conn, IPSourceIPv4)
+	case EtherTypeIPv6:
+		packet, err := parseIPv6(frame.Payload)
+		if err != nil {
+			return
+		}
+		proto, msg, ok := ipv6UpperLayer(packet)
+		if !ok || proto != ipProtoICMPv6 || len(msg) < 24 {
+			return
+		}
+		switch msg[0] {
+		case icmpv6NeighborSolicitation:
+			// Solicitations count for their source; duplicate address
+			// detection probes come from the unspecified address, as their
+			// target is only tentative
+			if lla := ndLinkLayerOption(msg[24:], 1); lla == nil || lla.String() == frame.SrcMAC.String() {
+				inv.record(packet.Src, frame.SrcMAC, conn, IPSourceNDP)
+			}
+		case icmpv6NeighborAdvertisement:
+			if lla := ndLinkLayerOption(msg[24:], 2); lla == nil || lla.String() == frame.SrcMAC.String() {
+				inv.record(net.IP(msg[8:24]), frame.SrcMAC, conn, IPSourceNDP)
+			}
+		}
+	}
+}
+
+// record notes an address seen from a MAC on a connection
+func (inv *ipInventory) record(ip net.IP, mac net.HardwareAddr, conn *Connection, source string) {
+	// Probes and broadcasts come from addresses no guest owns
+	if ip.IsUnspecified() || ip.IsLoopback() || ip.IsMulticast() || ip.Equal(net.IPv4bcast) {
+		return
+	}
+
+	key := [16]byte(ip.To16())
+	macKey := newMACKey(mac)
+	now := time.Now()
+
+	inv.mutex.Lock()
+	defer inv.mutex.Unlock()
+
+	sighting, found := inv.sightings[key]
+	if found && sighting.mac == macKey {
+		sighting.conn = conn
+		sighting.lastSeen = now
+		// An address announced by ARP or NDP is the MAC's own
+		if source != IPSourceIPv4 && sighting.source == IPSourceIPv4 {
+			inv.forget(sighting)
+			sighting.source = source
+		}
+		return
+	}
+	if source == IPSourceIPv4 && inv.fromIP[macKey] >= maxHeaderAddresses {
+		return
+	}
+	if !found && len(inv.sightings) >= maxIPInventory {
+		return
+	}
+
+	if found {
+		inv.forget(sighting)
+		inv.vs.logger.Info("IP address moved", "ip", ip.String(), "mac", mac.String(), "previous_mac", sighting.mac.String(), "connection", conn.ID)
+	}
+	inv.sightings[key] = &ipSighting{mac: macKey, conn: conn, source: source, firstSeen: now, lastSeen: now}
+	if source == IPSourceIPv4 {
+		inv.fromIP[macKey]++
+	}
+}
+
+// forget uncounts a sighting about to be removed; the caller holds the mutex
+func (inv *ipInventory) forget(sighting *ipSighting) {
+	if sighting.source != IPSourceIPv4 {
+		return
+	}
+	if inv.fromIP[sighting.mac]--; inv.fromIP[sighting.mac] <= 0 {
+		delete(inv.fromIP, sighting.mac)
+	}
+}
+
+// removeConnection forgets the addresses last seen on a connection
+func (inv *ipInventory) removeConnection(conn *Connection) {
+	inv.mutex.Lock()
+	defer inv.mutex.Unlock()
+
+	for key, sighting := range inv.sightings {
+		if sighting.conn == conn {
+			inv.forget(sighting)
+			delete(inv.sightings, key)
+		}
+	}
+}
+
+// expire forgets the addresses not seen for the timeout and returns how
+// many it forgot
+func (inv *ipInventory) expire(now time.Time, timeout time.Duration) int {
+	inv.mutex.Lock()
+	defer inv.mutex.Unlock()
+
+	removed := 0
+	for key, sighting := range inv.sightings {
+		if now.Sub(sighting.lastSeen) > timeout {
+			inv.forget(sighting)
+			delete(inv.sightings, key)
+			removed++
+		}
+	}
+	return removed
+}
+
+// list returns the inventory sorted by address
+func (inv *ipInventory) list() []IPInventoryEntry {
+	inv.mutex.Lock()
+	entries := make([]IPInventoryEntry, 0, len(inv.sightings))
+	conns := make([]*Connection, 0, len(inv.sightings))
+	for key, sighting := range inv.sightings {
+		ip := net.IP(append([]byte{}, key[:]...))
+		if ip4 := ip.To4(); ip4 != nil {
+			ip = ip4
+		}
+		entries = append(entries, IPInventoryEntry{
+			VLAN:         inv.vs.port(),
+			IP:           ip,
+			MAC:          sighting.mac.String(),
+			Vendor:       LookupVendor(sighting.mac[:]),
+			ConnectionID: sighting.conn.ID,
+			Source:       sighting.source,
+			FirstSeen:    sighting.firstSeen,
+			LastSeen:     sighting.lastSeen,
+		})
+		conns = append(conns, sighting.conn)
+	}
+	inv.mutex.Unlock()
+
+	// Connections are asked for their label and VM once the mutex is free
+	for i, conn := range conns {
+		entries[i].Label = conn.Label()
+		entries[i].VMName = conn.Hello().VMName
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return string(entries[i].IP.To16()) < string(entries[j].IP.To16())
+	})
+	return entries
+}
+
+// GetIPInventory returns the IP addresses seen on the VLAN, or nil unless
+// the IP inventory is enabled
+func (vs *VirtualSwitch) GetIPInventory() []IPInventoryEntry {
+	if vs.ipInventory == nil {
+		return nil
+	}
+	return vs.ipInventory.list()
+}
+
+// GetIPInventory returns the IP addresses seen on the VLAN at a port
+func (sm *SwitchManager) GetIPInventory(port int) ([]IPInventoryEntry, error) {
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+
+	vs, exists := sm.switches[port]
+	if !exists {
+		return nil, fmt.Errorf("VLAN does not exist on port %d", port)
+	}
+	if vs.ipInventory == nil {
+		return nil, fmt.Errorf("VLAN on port %d keeps no IP inventory", port)
+	}
+	return vs.GetIPInventory(), nil
+}
+
+// GetAllIPInventories returns the IP addresses seen on all VLANs sorted by
+// VLAN
+func (sm *SwitchManager) GetAllIPInventories() []IPInventoryEntry {
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+
+	ports := make([]int, 0, len(sm.switches))
+	for port := range sm.switches {
+		ports = append(ports, port)
+	}
+	sort.Ints(ports)
+
+	entries := make([]IPInventoryEntry, 0)
+	for _, port := range ports {
+		entries = append(entries, sm.switches[port].GetIPInventory()...)
+	}
+	return entries
+}
+
+// FindIP returns the VLANs, MACs and connections an IP address was seen on
+func (sm *SwitchManager) FindIP(ip net.IP) []IPInventoryEntry {
+	entries := make([]IPInventoryEntry, 0)
+	for _, entry := range sm.GetAllIPInventories() {
+		if entry.IP.Equal(ip) {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
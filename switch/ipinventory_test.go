@@ -0,0 +1,142 @@
+package vswitch
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestIPInventory(t *testing.T) {
+	sw := NewVirtualSwitchWithConfig([]int{8080}, Config{InMemory: true, IPInventory: true})
+	vm := NewConnection("vm", &mockConnSwitch{addr: &mockAddrSwitch{network: "tcp", address: "127.0.0.1:9001"}})
+	router := NewConnection("router", &mockConnSwitch{addr: &mockAddrSwitch{network: "tcp", address: "127.0.0.1:9002"}})
+	sw.connections.Store("vm", vm)
+	sw.connections.Store("router", router)
+
+	vmMAC := net.HardwareAddr{0x52, 0x54, 0x00, 0x00, 0x00, 0x01}
+	routerMAC := net.HardwareAddr{0x52, 0x54, 0x00, 0x00, 0x00, 0x02}
+	arp := func(mac net.HardwareAddr, ip string) *EthernetFrame {
+		packet := &arpPacket{Op: arpRequest, SenderMAC: mac, SenderIP: net.ParseIP(ip), TargetMAC: make(net.HardwareAddr, 6), TargetIP: net.ParseIP("10.0.0.254")}
+		return rawFrame(buildEthernet(BroadcastMAC, mac, EtherTypeARP, packet.marshal()))
+	}
+	ipv4 := func(mac net.HardwareAddr, src string) *EthernetFrame {
+		packet := buildIPv4(net.ParseIP(src), net.ParseIP("10.0.0.254"), ipProtoUDP, 64, make([]byte, 26))
+		return rawFrame(buildEthernet(BroadcastMAC, mac, EtherTypeIPv4, packet))
+	}
+
+	// The VM is found by the address it announces, and probes teach nothing
+	_ = sw.processFrame(arp(vmMAC, "10.0.0.7"), vm)
+	_ = sw.processFrame(arp(vmMAC, "0.0.0.0"), vm)
+	_ = sw.processFrame(ipv4(vmMAC, "10.0.0.7"), vm)
+	entries := sw.GetIPInventory()
+	if len(entries) != 1 || !entries[0].IP.Equal(net.ParseIP("10.0.0.7")) || entries[0].MAC != vmMAC.String() ||
+		entries[0].ConnectionID != "vm" || entries[0].Source != IPSourceARP {
+		t.Fatalf("Unexpected inventory %+v", entries)
+	}
+
+	// A router forwarding other networks' packets is recorded with a few of
+	// their addresses only, but with every address it announces
+	for i := 1; i <= 2*maxHeaderAddresses; i++ {
+		_ = sw.processFrame(ipv4(routerMAC, fmt.Sprintf("192.0.2.%d", i)), router)
+	}
+	_ = sw.processFrame(arp(routerMAC, "10.0.0.1"), router)
+	if entries := sw.GetIPInventory(); len(entries) != maxHeaderAddresses+2 {
+		t.Errorf("Expected %d addresses, got %d", maxHeaderAddresses+2, len(entries))
+	}
+
+	// An address moving to another MAC follows it
+	_ = sw.processFrame(arp(routerMAC, "10.0.0.7"), router)
+	for _, entry := range sw.GetIPInventory() {
+		if entry.IP.Equal(net.ParseIP("10.0.0.7")) && entry.ConnectionID != "router" {
+			t.Errorf("Expected 10.0.0.7 to have moved to the router, got %+v", entry)
+		}
+	}
+
+	// Addresses go away with their connection, or when not seen for long
+	sw.cleanupConnection(router)
+	if entries := sw.GetIPInventory(); len(entries) != 0 {
+		t.Errorf("Expected the router's addresses to be forgotten, got %+v", entries)
+	}
+	_ = sw.processFrame(arp(vmMAC, "10.0.0.8"), vm)
+	if expired := sw.ipInventory.expire(time.Now().Add(sw.macTimeout+time.Second), sw.macTimeout); expired != 1 {
+		t.Errorf("Expected 1 address to expire, got %d", expired)
+	}
+}
+
+func TestIPInventoryNDP(t *testing.T) {
+	sw := NewVirtualSwitchWithConfig([]int{8080}, Config{InMemory: true, IPInventory: true})
+	vm := NewConnection("vm", &mockConnSwitch{addr: &mockAddrSwitch{network: "tcp", address: "127.0.0.1:9001"}})
+	sw.connections.Store("vm", vm)
+
+	mac := net.HardwareAddr{0x52, 0x54, 0x00, 0x00, 0x00, 0x01}
+	otherMAC := net.HardwareAddr{0x52, 0x54, 0x00, 0x00, 0x00, 0x02}
+	linkLocal := linkLocalFromMAC(mac)
+	global := net.ParseIP("2001:db8::10")
+	solicitation := func(target net.IP) []byte {
+		msg := make([]byte, 24)
+		msg[0] = icmpv6NeighborSolicitation
+		copy(msg[8:24], target.To16())
+		return msg
+	}
+
+	// A duplicate address detection probe teaches nothing, the solicitations
+	// and advertisements that follow teach the link-local and global
+	// addresses
+	_ = sw.processFrame(ndFrame(mac, net.IPv6unspecified, allNodesIPv6, solicitation(global)), vm)
+	if entries := sw.GetIPInventory(); len(entries) != 0 {
+		t.Errorf("Expected a tentative address to be ignored, got %+v", entries)
+	}
+	_ = sw.processFrame(ndFrame(mac, linkLocal, allNodesIPv6, solicitation(net.ParseIP("fe80::1"))), vm)
+	_ = sw.processFrame(ndFrame(mac, linkLocal, allNodesIPv6, neighborAdvertisement(global, mac)), vm)
+
+	// Advertisements for another MAC are not the sender's
+	_ = sw.processFrame(ndFrame(mac, linkLocal, allNodesIPv6, neighborAdvertisement(net.ParseIP("2001:db8::20"), otherMAC)), vm)
+
+	entries := sw.GetIPInventory()
+	if len(entries) != 2 || !entries[0].IP.Equal(global) || !entries[1].IP.Equal(linkLocal) {
+		t.Fatalf("Unexpected inventory %+v", entries)
+	}
+	for _, entry := range entries {
+		if entry.MAC != mac.String() || entry.ConnectionID != "vm" || entry.Source != IPSourceNDP {
+			t.Errorf("Unexpected entry %+v", entry)
+		}
+	}
+}
+
+func TestAPIIPInventory(t *testing.T) {
+	sm := NewSwitchManager()
+	_ = sm.AddVLAN(8080)
+	_ = sm.AddVLANWithConfig(8081, Config{InMemory: true, IPInventory: true, EgressQueueSize: 64})
+	if err := sm.StartAll(context.Background()); err != nil {
+		t.Fatalf("Failed to start VLANs: %v", err)
+	}
+	defer sm.StopAll()
+	handler := NewAPIHandler(sm, "secret")
+
+	vs := sm.vlan(8081)
+	mac := net.HardwareAddr{0x52, 0x54, 0x00, 0x00, 0x00, 0x01}
+	guest := vs.Dial("vm")
+	defer func() { _ = guest.Close() }()
+	packet := &arpPacket{Op: arpRequest, SenderMAC: mac, SenderIP: net.ParseIP("10.0.0.7"), TargetMAC: make(net.HardwareAddr, 6), TargetIP: net.ParseIP("10.0.0.1")}
+	if _, err := guest.Write(qemuFrame(buildEthernet(BroadcastMAC, mac, EtherTypeARP, packet.marshal()))); err != nil {
+		t.Fatalf("Failed to send frame: %v", err)
+	}
+	waitUntil(t, "the address is recorded", func() bool { return len(sm.FindIP(net.ParseIP("10.0.0.7"))) == 1 })
+
+	for path, expected := range map[string]int{
+		"/vlans/8081/ips":  http.StatusOK,
+		"/vlans/8080/ips":  http.StatusNotFound,
+		"/ips":             http.StatusOK,
+		"/ips/10.0.0.7":    http.StatusOK,
+		"/ips/10.0.0.8":    http.StatusNotFound,
+		"/ips/fe80::1":     http.StatusNotFound,
+		"/ips/not-an-addr": http.StatusBadRequest,
+	} {
+		if status := apiRequest(handler, http.MethodGet, path, "", "secret"); status != expected {
+			t.Errorf("Expected %d from %s, got %d", expected, path, status)
+		}
+	}
+}
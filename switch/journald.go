@@ -0,0 +1,162 @@
+package vswitch
+
+import (
+	"context"
+	"encoding/binary"
+	"log/slog"
+	"net"
+	"strings"
+)
+
+// journalSocket is where systemd-journald accepts native protocol messages
+const journalSocket = "/run/systemd/journal/socket"
+
+// JournalHandler is a slog handler sending records to systemd-journald over
+// its native protocol. Attributes become journal fields, so that for example
+// `journalctl VLAN=9999 CONNECTION=...` selects the records of a connection.
+type JournalHandler struct {
+	conn   *net.UnixConn
+	level  slog.Leveler
+	fields []byte // Encoded fields of attributes added with WithAttrs
+	prefix string // Field name prefix of the open groups
+}
+
+// NewJournalHandler connects to the local journald and returns a handler
+// logging records at or above level under the given syslog identifier
+func NewJournalHandler(identifier string, level slog.Leveler) (*JournalHandler, error) {
+	return newJournalHandler(journalSocket, identifier, level)
+}
+
+// newJournalHandler connects to a journald socket at path
+func newJournalHandler(path, identifier string, level slog.Leveler) (*JournalHandler, error) {
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		return nil, err
+	}
+
+	return &JournalHandler{
+		conn:   conn,
+		level:  level,
+		fields: appendJournalField(nil, "SYSLOG_IDENTIFIER", identifier),
+	}, nil
+}
+
+// Enabled reports whether records at the level are logged
+func (h *JournalHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+// Handle sends a record to the journal as one datagram
+func (h *JournalHandler) Handle(_ context.Context, record slog.Record) error {
+	msg := appendJournalField(nil, "MESSAGE", record.Message)
+	msg = appendJournalField(msg, "PRIORITY", journalPriority(record.Level))
+	msg = append(msg, h.fields...)
+	record.Attrs(func(attr slog.Attr) bool {
+		msg = appendJournalAttr(msg, h.prefix, attr)
+		return true
+	})
+
+	_, err := h.conn.Write(msg)
+	return err
+}
+
+// WithAttrs returns a handler adding the attributes to every record
+func (h *JournalHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	fields := append([]byte(nil), h.fields...)
+	for _, attr := range attrs {
+		fields = appendJournalAttr(fields, h.prefix, attr)
+	}
+
+	clone := *h
+	clone.fields = fields
+	return &clone
+}
+
+// WithGroup returns a handler prefixing the fields of later attributes
+func (h *JournalHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+
+	clone := *h
+	clone.prefix = h.prefix + name + "_"
+	return &clone
+}
+
+// Close disconnects from the journal
+func (h *JournalHandler) Close() error {
+	return h.conn.Close()
+}
+
+// journalPriority maps a log level to a syslog priority
+func journalPriority(level slog.Level) string {
+	switch {
+	case level >= slog.LevelError:
+		return "3"
+	case level >= slog.LevelWarn:
+		return "4"
+	case level >= slog.LevelInfo:
+		return "6"
+	default:
+		return "7"
+	}
+}
+
+// appendJournalAttr appends an attribute as a journal field, flattening
+// groups into prefixed field names
+func appendJournalAttr(b []byte, prefix string, attr slog.Attr) []byte {
+	attr.Value = attr.Value.Resolve()
+	if attr.Equal(slog.Attr{}) {
+		return b
+	}
+
+	if attr.Value.Kind() == slog.KindGroup {
+		if attr.Key != "" {
+			prefix += attr.Key + "_"
+		}
+		for _, member := range attr.Value.Group() {
+			b = appendJournalAttr(b, prefix, member)
+		}
+		return b
+	}
+
+	return appendJournalField(b, journalFieldName(prefix+attr.Key), attr.Value.String())
+}
+
+// journalFieldName converts an attribute key to a valid journal field name:
+// upper case letters, digits and underscores, not starting with an underscore
+func journalFieldName(key string) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		default:
+			return '_'
+		}
+	}, key)
+
+	name = strings.TrimLeft(name, "_")
+	if name == "" {
+		return "FIELD"
+	}
+	return name
+}
+
+// appendJournalField appends a field in the native protocol, using the
+// length-prefixed form for values spanning several lines
+func appendJournalField(b []byte, name, value string) []byte {
+	if !strings.Contains(value, "\n") {
+		b = append(b, name...)
+		b = append(b, '=')
+		b = append(b, value...)
+		return append(b, '\n')
+	}
+
+	b = append(b, name...)
+	b = append(b, '\n')
+	b = binary.LittleEndian.AppendUint64(b, uint64(len(value)))
+	b = append(b, value...)
+	return append(b, '\n')
+}
@@ -0,0 +1,99 @@
+//go:build !windows
+
+package vswitch
+
+import (
+	"bytes"
+	"encoding/binary"
+	"log/slog"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// parseJournalFields decodes a native protocol datagram
+func parseJournalFields(t *testing.T, data []byte) map[string]string {
+	t.Helper()
+
+	fields := make(map[string]string)
+	for len(data) > 0 {
+		line := data
+		if i := bytes.IndexByte(data, '\n'); i >= 0 {
+			line = data[:i]
+		}
+
+		if name, value, found := bytes.Cut(line, []byte("=")); found {
+			fields[string(name)] = string(value)
+			data = data[min(len(line)+1, len(data)):]
+			continue
+		}
+
+		// Length-prefixed value
+		rest := data[len(line)+1:]
+		size := binary.LittleEndian.Uint64(rest[:8])
+		fields[string(line)] = string(rest[8 : 8+size])
+		data = rest[8+size+1:]
+	}
+	return fields
+}
+
+func TestJournalHandler(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal.sock")
+	journal, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer func() { _ = journal.Close() }()
+
+	handler, err := newJournalHandler(path, "vswitch", slog.LevelInfo)
+	if err != nil {
+		t.Fatalf("Failed to create handler: %v", err)
+	}
+	defer func() { _ = handler.Close() }()
+
+	logger := slog.New(handler).With("vlan", 9999)
+	logger.Debug("Learned MAC", "mac", "52:54:00:00:00:01")
+	logger.Warn("MAC moved", "mac", "52:54:00:00:00:01", "connection", "conn2",
+		slog.Group("stats", "frames", 3), "detail", "two\nlines")
+
+	buf := make([]byte, 4096)
+	_ = journal.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, err := journal.Read(buf)
+	if err != nil {
+		t.Fatalf("Failed to read datagram: %v", err)
+	}
+
+	fields := parseJournalFields(t, buf[:n])
+	expected := map[string]string{
+		"MESSAGE":           "MAC moved",
+		"PRIORITY":          "4",
+		"SYSLOG_IDENTIFIER": "vswitch",
+		"VLAN":              "9999",
+		"MAC":               "52:54:00:00:00:01",
+		"CONNECTION":        "conn2",
+		"STATS_FRAMES":      "3",
+		"DETAIL":            "two\nlines",
+	}
+	for name, value := range expected {
+		if fields[name] != value {
+			t.Errorf("Expected %s=%q, got %q", name, value, fields[name])
+		}
+	}
+}
+
+func TestJournalFieldName(t *testing.T) {
+	tests := map[string]string{
+		"vlan":         "VLAN",
+		"frames_sent":  "FRAMES_SENT",
+		"_private":     "PRIVATE",
+		"of-port.name": "OF_PORT_NAME",
+		"":             "FIELD",
+	}
+
+	for key, expected := range tests {
+		if name := journalFieldName(key); name != expected {
+			t.Errorf("journalFieldName(%q) = %q, expected %q", key, name, expected)
+		}
+	}
+}
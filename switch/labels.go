@@ -0,0 +1,77 @@
+package vswitch
+
+import (
+	"net"
+	"sort"
+)
+
+// PeerLabel names the VM connecting from a peer address and reports
+// whether it is connected, so an orchestrator can tell when a VM's
+// interface is up
+type PeerLabel struct {
+	Address     string   `json:"address"`
+	Label       string   `json:"label"`
+	Connected   bool     `json:"connected"`
+	Connections []string `json:"connections,omitempty"`
+}
+
+// SetPeerLabel labels the connections from a peer address, present and
+// future, with the name of the VM behind them
+func (vs *VirtualSwitch) SetPeerLabel(ip net.IP, label string) {
+	vs.peerLabels.Store(ip.String(), label)
+	vs.relabel(ip, label)
+	vs.logger.Info("Labeled peer", "address", ip.String(), "label", label)
+}
+
+// RemovePeerLabel forgets the label of a peer address
+func (vs *VirtualSwitch) RemovePeerLabel(ip net.IP) {
+	vs.peerLabels.Delete(ip.String())
+	vs.relabel(ip, "")
+}
+
+// relabel sets the label of the active connections from a peer address
+func (vs *VirtualSwitch) relabel(ip net.IP, label string) {
+	vs.connections.Range(func(_, value interface{}) bool {
+		conn := value.(*Connection)
+		if addrIP(conn.Conn.RemoteAddr()).Equal(ip) {
+			conn.SetLabel(label)
+		}
+		return true
+	})
+}
+
+// peerLabel returns the label registered for a connection's peer address
+func (vs *VirtualSwitch) peerLabel(conn *Connection) string {
+	ip := addrIP(conn.Conn.RemoteAddr())
+	if ip == nil {
+		return ""
+	}
+	if label, found := vs.peerLabels.Load(ip.String()); found {
+		return label.(string)
+	}
+	return ""
+}
+
+// PeerLabels returns the labeled peers sorted by address, with the
+// connections each has open
+func (vs *VirtualSwitch) PeerLabels() []PeerLabel {
+	peers := []PeerLabel{}
+	vs.peerLabels.Range(func(key, value interface{}) bool {
+		peer := PeerLabel{Address: key.(string), Label: value.(string)}
+		ip := net.ParseIP(peer.Address)
+		vs.connections.Range(func(_, value interface{}) bool {
+			conn := value.(*Connection)
+			if addrIP(conn.Conn.RemoteAddr()).Equal(ip) {
+				peer.Connections = append(peer.Connections, conn.ID)
+			}
+			return true
+		})
+		sort.Strings(peer.Connections)
+		peer.Connected = len(peer.Connections) > 0
+		peers = append(peers, peer)
+		return true
+	})
+
+	sort.Slice(peers, func(i, j int) bool { return peers[i].Address < peers[j].Address })
+	return peers
+}
@@ -0,0 +1,75 @@
+package vswitch
+
+import (
+	"net"
+	"net/http"
+	"testing"
+)
+
+func TestPeerLabels(t *testing.T) {
+	vs := NewVirtualSwitch([]int{9999})
+	existing := NewConnection("existing", &mockConnSwitch{addr: &mockAddrSwitch{network: "tcp", address: "10.244.1.5:40000"}})
+	vs.connections.Store(existing.ID, existing)
+
+	// Labels apply to the peer's connections already open
+	vs.SetPeerLabel(net.ParseIP("10.244.1.5"), "lab/vm1")
+	vs.SetPeerLabel(net.ParseIP("10.244.1.6"), "lab/vm2")
+	if existing.Label() != "lab/vm1" {
+		t.Errorf("Expected the open connection to be labeled, got %q", existing.Label())
+	}
+
+	// and to those opened later
+	later := NewConnection("later", &mockConnSwitch{addr: &mockAddrSwitch{network: "tcp", address: "10.244.1.6:40001"}})
+	vs.attachConnection(later)
+	if info := later.Info(); info.Label != "lab/vm2" {
+		t.Errorf("Expected the new connection to be labeled, got %q", info.Label)
+	}
+
+	peers := vs.PeerLabels()
+	if len(peers) != 2 || peers[0].Address != "10.244.1.5" || !peers[0].Connected || peers[0].Connections[0] != "existing" {
+		t.Fatalf("Unexpected peers %+v", peers)
+	}
+
+	vs.connections.Delete("later")
+	vs.RemovePeerLabel(net.ParseIP("10.244.1.5"))
+	peers = vs.PeerLabels()
+	if len(peers) != 1 || peers[0].Label != "lab/vm2" || peers[0].Connected {
+		t.Errorf("Expected only the disconnected vm2, got %+v", peers)
+	}
+	if existing.Label() != "" {
+		t.Errorf("Expected the label to be removed, got %q", existing.Label())
+	}
+}
+
+func TestAPIPeerLabels(t *testing.T) {
+	sm := NewSwitchManager()
+	if err := sm.AddVLAN(9999); err != nil {
+		t.Fatalf("Failed to add VLAN: %v", err)
+	}
+	handler := NewAPIHandler(sm, "secret")
+
+	if code := apiRequest(handler, http.MethodPut, "/vlans/9999/peers/10.244.1.5", `{"label": "lab/vm1"}`, "secret"); code != http.StatusOK {
+		t.Errorf("Expected the peer to be labeled, got %d", code)
+	}
+	if code := apiRequest(handler, http.MethodPut, "/vlans/9999/peers/vm1", `{"label": "lab/vm1"}`, "secret"); code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an invalid address, got %d", code)
+	}
+	if code := apiRequest(handler, http.MethodPut, "/vlans/9998/peers/10.244.1.5", `{"label": "lab/vm1"}`, "secret"); code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown VLAN, got %d", code)
+	}
+	if code := apiRequest(NewAPIHandler(sm, ""), http.MethodPut, "/vlans/9999/peers/10.244.1.6", `{"label": "lab/vm2"}`, ""); code != http.StatusForbidden {
+		t.Errorf("Expected labeling to require management, got %d", code)
+	}
+
+	var peers []PeerLabel
+	if code := getJSON(t, NewAPIHandler(sm, ""), "/vlans/9999/peers", &peers); code != http.StatusOK || len(peers) != 1 || peers[0].Label != "lab/vm1" {
+		t.Errorf("Unexpected peers %d: %+v", code, peers)
+	}
+
+	if code := apiRequest(handler, http.MethodDelete, "/vlans/9999/peers/10.244.1.5", "", "secret"); code != http.StatusOK {
+		t.Errorf("Expected the label to be removed, got %d", code)
+	}
+	if code := getJSON(t, NewAPIHandler(sm, ""), "/vlans/9999/peers", &peers); code != http.StatusOK || len(peers) != 0 {
+		t.Errorf("Expected no peers, got %d: %+v", code, peers)
+	}
+}
@@ -0,0 +1,113 @@
+package vswitch
+
+import "encoding/binary"
+
+// maxFrameLength is the largest value of the EtherType field that is an
+// 802.3 length rather than an EtherType
+const maxFrameLength = 1500
+
+// LLC service access points of note
+const (
+	// LLCSAPSTP is the SAP of the spanning tree protocol's BPDUs
+	LLCSAPSTP uint8 = 0x42
+	// LLCSAPSNAP is the SAP of frames with a SNAP header
+	LLCSAPSNAP uint8 = 0xaa
+)
+
+// llcControlUI is the control field of unnumbered information frames, the
+// only ones carrying a SNAP header
+const llcControlUI = 0x03
+
+// Encapsulation is how a frame carries its protocol
+type Encapsulation int
+
+const (
+	// EncapEthernetII frames name their protocol by EtherType
+	EncapEthernetII Encapsulation = iota
+	// EncapLLC frames have an 802.3 length field followed by an 802.2 LLC
+	// header, whose SAPs name the protocol, e.g. STP BPDUs
+	EncapLLC
+	// EncapSNAP frames have an LLC header followed by a SNAP header, whose
+	// OUI and protocol ID name the protocol
+	EncapSNAP
+	// EncapRaw8023 frames have an 802.3 length field and no LLC header, as
+	// Novell's raw IPX, or one too short to parse
+	EncapRaw8023
+)
+
+// String returns the name of the encapsulation
+func (e Encapsulation) String() string {
+	switch e {
+	case EncapEthernetII:
+		return "ethernet-ii"
+	case EncapLLC:
+		return "llc"
+	case EncapSNAP:
+		return "snap"
+	case EncapRaw8023:
+		return "raw-802.3"
+	default:
+		return "unknown"
+	}
+}
+
+// LLCHeader is the 802.2 LLC header of an 802.3 frame, with its SNAP header
+// if it has one
+type LLCHeader struct {
+	DSAP    uint8
+	SSAP    uint8
+	Control uint16 // one byte, except for I and S format frames
+
+	// OUI and ProtocolID are those of the SNAP header; with the OUI
+	// 00-00-00, the protocol ID is an EtherType
+	OUI        [3]byte
+	ProtocolID uint16
+}
+
+// parseEncapsulation sets a freshly parsed frame's encapsulation. The
+// payload of 802.3 frames is what follows their LLC and SNAP headers, up to
+// their length field, leaving out any padding.
+func (f *EthernetFrame) parseEncapsulation() {
+	f.Encapsulation = EncapEthernetII
+	f.LLC = LLCHeader{}
+	if f.EtherType > maxFrameLength {
+		return
+	}
+
+	f.Encapsulation = EncapRaw8023
+	data := f.Payload
+	if int(f.EtherType) < len(data) {
+		data = data[:f.EtherType]
+	}
+	// Raw IPX starts with a checksum of all ones where the SAPs would be
+	if len(data) < 3 || (data[0] == 0xff && data[1] == 0xff) {
+		f.Payload = data
+		return
+	}
+
+	f.Encapsulation = EncapLLC
+	f.LLC.DSAP, f.LLC.SSAP = data[0], data[1]
+	headerLen := 3
+	f.LLC.Control = uint16(data[2])
+	if data[2]&0x03 != 0x03 {
+		if len(data) < 4 {
+			f.Encapsulation = EncapRaw8023
+			f.Payload = data
+			return
+		}
+		headerLen = 4
+		f.LLC.Control = binary.BigEndian.Uint16(data[2:4])
+	}
+	if f.LLC.DSAP == LLCSAPSNAP && f.LLC.SSAP == LLCSAPSNAP && f.LLC.Control == llcControlUI && len(data) >= 8 {
+		f.Encapsulation = EncapSNAP
+		copy(f.LLC.OUI[:], data[3:6])
+		f.LLC.ProtocolID = binary.BigEndian.Uint16(data[6:8])
+		headerLen = 8
+	}
+	f.Payload = data[headerLen:]
+}
+
+// IsBPDU returns whether the frame is a spanning tree BPDU
+func (f *EthernetFrame) IsBPDU() bool {
+	return f.Encapsulation == EncapLLC && f.LLC.DSAP == LLCSAPSTP && f.LLC.SSAP == LLCSAPSTP
+}
@@ -0,0 +1,142 @@
+package vswitch
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+const (
+	// lldpInterval is how often the port of each connection is advertised
+	lldpInterval = 30 * time.Second
+
+	// lldpTTL is how long, in seconds, guests keep an advertisement
+	lldpTTL = 4 * uint16(lldpInterval/time.Second)
+
+	// lldpMaxDescription bounds the length of the port description
+	lldpMaxDescription = 255
+)
+
+// LLDP TLV types and the subtypes of the IDs the switch sends
+const (
+	lldpTLVEnd             = 0
+	lldpTLVChassisID       = 1
+	lldpTLVPortID          = 2
+	lldpTLVTTL             = 3
+	lldpTLVPortDescription = 4
+	lldpTLVSystemName      = 5
+
+	lldpChassisMAC   = 4
+	lldpPortAssigned = 7
+)
+
+// lldpMulticast is the nearest bridge group address LLDP is sent to
+var lldpMulticast = net.HardwareAddr{0x01, 0x80, 0xc2, 0x00, 0x00, 0x0e}
+
+// lldpService advertises the switch port of each connection with LLDP, so
+// that tools in the guests such as lldpctl show the VLAN and port they are
+// attached to and what the switch knows of the VM behind the port.
+type lldpService struct {
+	vs  *VirtualSwitch
+	mac net.HardwareAddr
+}
+
+// newLLDPService creates an LLDP service for the switch
+func newLLDPService(vs *VirtualSwitch) *lldpService {
+	return &lldpService{vs: vs, mac: serviceMAC(vs.port())}
+}
+
+// handleFrame consumes the LLDP frames of the guests; bridges never forward
+// frames to the nearest bridge group
+func (l *lldpService) handleFrame(frame *EthernetFrame, _ *Connection) bool {
+	return frame.EtherType == EtherTypeLLDP && bytes.Equal(frame.DestMAC, lldpMulticast)
+}
+
+// run advertises the port of every connection periodically
+func (l *lldpService) run(shutdown <-chan bool) {
+	ticker := time.NewTicker(lldpInterval)
+	defer ticker.Stop()
+
+	for {
+		l.vs.connections.Range(func(_, value interface{}) bool {
+			if conn := value.(*Connection); !conn.IsClosed() {
+				l.advertise(conn)
+			}
+			return true
+		})
+
+		select {
+		case <-shutdown:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// advertise sends a connection the advertisement of its port
+func (l *lldpService) advertise(conn *Connection) {
+	if l.vs.disabled.Load() {
+		return
+	}
+	raw := l.buildAdvertisement(conn)
+	frame, err := ParseEthernetFrame(raw)
+	if err != nil {
+		return
+	}
+	frame.pooled = false
+	l.vs.capture.tap(raw, frame.timestamp())
+	if err := conn.SendFrame(frame); err != nil {
+		l.vs.logger.Debug("Failed to send LLDP advertisement", "connection", conn.ID, "error", err)
+	}
+}
+
+// buildAdvertisement builds the LLDP frame describing a connection's port
+func (l *lldpService) buildAdvertisement(conn *Connection) []byte {
+	ttl := make([]byte, 2)
+	binary.BigEndian.PutUint16(ttl, lldpTTL)
+
+	var du []byte
+	du = appendLLDPTLV(du, lldpTLVChassisID, append([]byte{lldpChassisMAC}, l.mac...))
+	du = appendLLDPTLV(du, lldpTLVPortID, append([]byte{lldpPortAssigned}, conn.ID...))
+	du = appendLLDPTLV(du, lldpTLVTTL, ttl)
+	if description := portDescription(conn); description != "" {
+		du = appendLLDPTLV(du, lldpTLVPortDescription, []byte(description))
+	}
+	du = appendLLDPTLV(du, lldpTLVSystemName, []byte(fmt.Sprintf("vswitch VLAN %d", l.vs.port())))
+	du = appendLLDPTLV(du, lldpTLVEnd, nil)
+
+	return buildEthernet(lldpMulticast, l.mac, EtherTypeLLDP, du)
+}
+
+// portDescription describes the peer of a connection: the VM its client
+// reported, or else its label
+func portDescription(conn *Connection) string {
+	hello := conn.Hello()
+	var parts []string
+	for _, part := range []string{hello.VMName, hello.Interface} {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	if hello.VMUUID != "" {
+		parts = append(parts, "("+hello.VMUUID+")")
+	}
+	description := strings.Join(parts, " ")
+	if description == "" {
+		description = conn.Label()
+	}
+	if len(description) > lldpMaxDescription {
+		description = description[:lldpMaxDescription]
+	}
+	return description
+}
+
+// appendLLDPTLV appends a TLV, whose header packs a 7-bit type and a 9-bit
+// length
+func appendLLDPTLV(du []byte, tlvType int, value []byte) []byte {
+	du = binary.BigEndian.AppendUint16(du, uint16(tlvType)<<9|uint16(len(value)))
+	return append(du, value...)
+}
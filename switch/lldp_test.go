@@ -0,0 +1,79 @@
+package vswitch
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+)
+
+// lldpTLVs decodes the TLVs of an LLDP frame by type
+func lldpTLVs(t *testing.T, frame []byte) map[int][]byte {
+	t.Helper()
+
+	if !bytes.Equal(frame[0:6], lldpMulticast) || binary.BigEndian.Uint16(frame[12:14]) != EtherTypeLLDP {
+		t.Fatalf("Expected an LLDP frame, got %x", frame)
+	}
+	tlvs := make(map[int][]byte)
+	du := frame[14:]
+	for len(du) >= 2 {
+		header := binary.BigEndian.Uint16(du)
+		tlvType, length := int(header>>9), int(header&0x1ff)
+		if len(du) < 2+length {
+			t.Fatalf("Truncated TLV in %x", frame)
+		}
+		if tlvType == lldpTLVEnd {
+			return tlvs
+		}
+		tlvs[tlvType] = du[2 : 2+length]
+		du = du[2+length:]
+	}
+	t.Fatalf("Expected an end TLV in %x", frame)
+	return nil
+}
+
+func TestLLDP(t *testing.T) {
+	sw := NewVirtualSwitchWithConfig([]int{8080}, Config{LLDP: true})
+	guest, other := &frameSink{}, &frameSink{}
+	conn := NewConnection("guest", guest)
+	sw.connections.Store("guest", conn)
+	sw.connections.Store("other", NewConnection("other", other))
+
+	// The port is advertised to the guest as soon as its client says hello
+	sw.greet(conn, Hello{VMName: "web-1", VMUUID: "5f0c1a52-3c1e-4d8a-9f5e-1b2c3d4e5f60", Interface: "net0"})
+	frames := writtenFrames(t, guest.data)
+	if len(frames) != 1 {
+		t.Fatalf("Expected an advertisement, got %d frames", len(frames))
+	}
+	tlvs := lldpTLVs(t, frames[0])
+	for tlvType, value := range map[int]string{
+		lldpTLVChassisID:       string(append([]byte{lldpChassisMAC}, serviceMAC(8080)...)),
+		lldpTLVPortID:          "\x07guest",
+		lldpTLVTTL:             "\x00\x78",
+		lldpTLVPortDescription: "web-1 net0 (5f0c1a52-3c1e-4d8a-9f5e-1b2c3d4e5f60)",
+		lldpTLVSystemName:      "vswitch VLAN 8080",
+	} {
+		if string(tlvs[tlvType]) != value {
+			t.Errorf("Expected TLV %d to be %q, got %q", tlvType, value, tlvs[tlvType])
+		}
+	}
+	if len(other.data) != 0 {
+		t.Errorf("Expected the advertisement to reach the guest only")
+	}
+
+	// Without a hello the label describes the port
+	conn.SetLabel("db-1")
+	conn.setHello(Hello{})
+	if description := portDescription(conn); description != "db-1" {
+		t.Errorf("Expected the label as the port description, got %q", description)
+	}
+
+	// The guests' own LLDP frames stay on their link
+	src := net.HardwareAddr{0x52, 0x54, 0x00, 0x00, 0x00, 0x01}
+	if err := sw.processFrame(rawFrame(buildEthernet(lldpMulticast, src, EtherTypeLLDP, make([]byte, 46))), conn); err != nil {
+		t.Fatalf("Failed to process frame: %v", err)
+	}
+	if len(other.data) != 0 {
+		t.Errorf("Expected the guest's LLDP frame not to be forwarded")
+	}
+}
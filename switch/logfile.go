@@ -0,0 +1,152 @@
+package vswitch
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// LogFile is an append-only log file that rotates itself once it grows
+// past a size or gets older than an interval, keeping a number of old files
+// as path.1 (newest) to path.N. It can also be reopened after an external
+// tool such as logrotate moved it away.
+type LogFile struct {
+	path       string
+	maxSize    int64
+	interval   time.Duration
+	maxBackups int
+
+	mutex  sync.Mutex
+	file   *os.File
+	size   int64
+	opened time.Time
+}
+
+// OpenLogFile opens or creates a log file. A zero maxSize or interval
+// disables that rotation trigger.
+func OpenLogFile(path string, maxSize int64, interval time.Duration, maxBackups int) (*LogFile, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+		return nil, fmt.Errorf("failed to create log directory: %v", err)
+	}
+
+	l := &LogFile{
+		path:       path,
+		maxSize:    maxSize,
+		interval:   interval,
+		maxBackups: max(maxBackups, 0),
+	}
+	if err := l.openLocked(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// openLocked opens the log file for appending; the caller holds the mutex
+func (l *LogFile) openLocked() error {
+	file, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %v", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return fmt.Errorf("failed to stat log file: %v", err)
+	}
+
+	l.file = file
+	l.size = info.Size()
+	l.opened = time.Now()
+	return nil
+}
+
+// Write appends to the log file, rotating it first if it is due
+func (l *LogFile) Write(p []byte) (int, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.file == nil {
+		return 0, os.ErrClosed
+	}
+
+	if l.size > 0 && ((l.maxSize > 0 && l.size+int64(len(p)) > l.maxSize) ||
+		(l.interval > 0 && time.Since(l.opened) >= l.interval)) {
+		if err := l.rotateLocked(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := l.file.Write(p)
+	l.size += int64(n)
+	return n, err
+}
+
+// Rotate moves the current log file to path.1, shifting older files up and
+// removing those beyond the retention count, and starts a new file
+func (l *LogFile) Rotate() error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.file == nil {
+		return os.ErrClosed
+	}
+	return l.rotateLocked()
+}
+
+// rotateLocked rotates the log file; the caller holds the mutex
+func (l *LogFile) rotateLocked() error {
+	if err := l.file.Close(); err != nil {
+		return err
+	}
+	l.file = nil
+
+	if l.maxBackups == 0 {
+		if err := os.Remove(l.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	} else {
+		_ = os.Remove(l.backupPath(l.maxBackups))
+		for i := l.maxBackups - 1; i >= 1; i-- {
+			if err := os.Rename(l.backupPath(i), l.backupPath(i+1)); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+		if err := os.Rename(l.path, l.backupPath(1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return l.openLocked()
+}
+
+// Reopen closes and reopens the log file by name, for use after an
+// external tool has rotated it
+func (l *LogFile) Reopen() error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.file != nil {
+		_ = l.file.Close()
+		l.file = nil
+	}
+	return l.openLocked()
+}
+
+// Close closes the log file; further writes fail
+func (l *LogFile) Close() error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
+
+// backupPath returns the name of the nth old log file
+func (l *LogFile) backupPath(n int) string {
+	return fmt.Sprintf("%s.%d", l.path, n)
+}
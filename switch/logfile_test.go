@@ -0,0 +1,99 @@
+package vswitch
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// readLogFile returns the content of a log file, or "" if it is missing
+func readLogFile(t *testing.T, path string) string {
+	t.Helper()
+
+	data, err := os.ReadFile(path) // #nosec G304 - test file
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatalf("Failed to read %s: %v", path, err)
+	}
+	return string(data)
+}
+
+func TestLogFileRotatesBySize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logs", "vswitch.log")
+	l, err := OpenLogFile(path, 10, 0, 2)
+	if err != nil {
+		t.Fatalf("Failed to open log file: %v", err)
+	}
+	defer func() { _ = l.Close() }()
+
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		if _, err := l.Write([]byte(line)); err != nil {
+			t.Fatalf("Failed to write: %v", err)
+		}
+	}
+
+	// Only two old files are kept; "first" was dropped
+	if got := readLogFile(t, path); got != "fourth\n" {
+		t.Errorf("Expected current file to hold the last line, got %q", got)
+	}
+	if got := readLogFile(t, path+".1"); got != "third\n" {
+		t.Errorf("Expected newest backup to hold third line, got %q", got)
+	}
+	if got := readLogFile(t, path+".2"); got != "second\n" {
+		t.Errorf("Expected oldest backup to hold second line, got %q", got)
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("Expected no third backup")
+	}
+}
+
+func TestLogFileRotatesByAge(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "vswitch.log")
+	l, err := OpenLogFile(path, 0, time.Hour, 1)
+	if err != nil {
+		t.Fatalf("Failed to open log file: %v", err)
+	}
+	defer func() { _ = l.Close() }()
+
+	_, _ = l.Write([]byte("old\n"))
+	_, _ = l.Write([]byte("still current\n"))
+	if got := readLogFile(t, path+".1"); got != "" {
+		t.Fatalf("Expected no rotation before the interval, got backup %q", got)
+	}
+
+	l.opened = l.opened.Add(-2 * time.Hour)
+	_, _ = l.Write([]byte("new\n"))
+	if got := readLogFile(t, path); got != "new\n" {
+		t.Errorf("Expected a fresh file after the interval, got %q", got)
+	}
+	if got := readLogFile(t, path+".1"); got != "old\nstill current\n" {
+		t.Errorf("Expected backup of the old file, got %q", got)
+	}
+}
+
+func TestLogFileReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "vswitch.log")
+	l, err := OpenLogFile(path, 0, 0, 0)
+	if err != nil {
+		t.Fatalf("Failed to open log file: %v", err)
+	}
+	defer func() { _ = l.Close() }()
+
+	_, _ = l.Write([]byte("before\n"))
+
+	// Simulate logrotate moving the file away
+	if err := os.Rename(path, path+".rotated"); err != nil {
+		t.Fatalf("Failed to move log file: %v", err)
+	}
+	if err := l.Reopen(); err != nil {
+		t.Fatalf("Failed to reopen: %v", err)
+	}
+	_, _ = l.Write([]byte("after\n"))
+
+	if got := readLogFile(t, path); got != "after\n" {
+		t.Errorf("Expected writes to go to the new file, got %q", got)
+	}
+	if got := readLogFile(t, path+".rotated"); got != "before\n" {
+		t.Errorf("Expected the moved file to keep old lines, got %q", got)
+	}
+}
@@ -0,0 +1,82 @@
+package vswitch
+
+import (
+	"net"
+	"time"
+)
+
+// MAC event types
+const (
+	// MACLearned reports a MAC seen for the first time
+	MACLearned = "learned"
+	// MACMoved reports a MAC seen on another connection than before
+	MACMoved = "moved"
+	// MACAged reports a MAC removed after MACTimeout without frames
+	MACAged = "aged"
+	// MACEvicted reports a MAC removed to make room in a full table
+	MACEvicted = "evicted"
+	// MACFlushed reports a MAC removed with the connection it was on
+	MACFlushed = "flushed"
+)
+
+// MACEvent reports a change of a VLAN's MAC table, such as a VM appearing
+// or migrating
+type MACEvent struct {
+	Type       string    `json:"type"`
+	VLAN       int       `json:"vlan"`
+	MAC        string    `json:"mac"`
+	Vendor     string    `json:"vendor,omitempty"`
+	Connection string    `json:"connection"`
+	Label      string    `json:"label,omitempty"`
+	RemoteAddr string    `json:"remote_addr,omitempty"`
+	VMName     string    `json:"vm_name,omitempty"`
+	VMUUID     string    `json:"vm_uuid,omitempty"`
+	Interface  string    `json:"interface,omitempty"`
+	Previous   string    `json:"previous_connection,omitempty"`
+	Time       time.Time `json:"time"`
+}
+
+// macEvent passes a MAC table change to the kernel fast path and to the
+// configured handler, if any
+func (vs *VirtualSwitch) macEvent(kind string, mac net.HardwareAddr, conn, previous *Connection) {
+	vs.updateFastPath(mac)
+
+	handler := vs.config.MACEventHandler
+	if handler == nil {
+		return
+	}
+
+	hello := conn.Hello()
+	event := MACEvent{
+		Type:       kind,
+		VLAN:       vs.port(),
+		MAC:        mac.String(),
+		Vendor:     LookupVendor(mac),
+		Connection: conn.ID,
+		Label:      conn.Label(),
+		RemoteAddr: conn.RemoteAddr(),
+		VMName:     hello.VMName,
+		VMUUID:     hello.VMUUID,
+		Interface:  hello.Interface,
+		Time:       time.Now(),
+	}
+	if previous != nil {
+		event.Previous = previous.ID
+	}
+	handler(event)
+}
+
+// removedMAC is a MAC entry removed from the table, reported once the
+// table is unlocked
+type removedMAC struct {
+	mac  net.HardwareAddr
+	conn *Connection
+	kind string
+}
+
+// reportRemovedMACs passes the removed entries to the MAC event handler
+func (vs *VirtualSwitch) reportRemovedMACs(removed []removedMAC) {
+	for _, entry := range removed {
+		vs.macEvent(entry.kind, entry.mac, entry.conn, nil)
+	}
+}
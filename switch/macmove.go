@@ -0,0 +1,92 @@
+package vswitch
+
+import (
+	"bytes"
+	"net"
+	"sort"
+)
+
+// rarpRequest is the operation of the reverse ARP requests hypervisors
+// broadcast to announce a guest's MAC after a migration
+const rarpRequest = 3
+
+// moveMAC handles a MAC learned on another connection than before, as
+// after a live migration or a failover. The table already points to the
+// new connection; state bound to the old one moves along, so that it is
+// not lost when the old connection closes, and the move is announced if
+// the VLAN is configured to.
+func (vs *VirtualSwitch) moveMAC(mac net.HardwareAddr, conn, previous *Connection) {
+	vs.macMoves.Add(1)
+	vs.logger.Info("MAC moved", "mac", mac.String(), "from", previous.ID, "connection", conn.ID)
+	vs.macEvent(MACMoved, mac, conn, previous)
+
+	if vs.dhcpSnooper != nil {
+		vs.dhcpSnooper.rebindMAC(mac, conn.ID)
+	}
+	if vs.ndGuard != nil {
+		vs.ndGuard.rebindMAC(mac, conn.ID)
+	}
+
+	if vs.config.MACMoveAnnounce {
+		vs.announceMAC(mac, conn)
+	}
+}
+
+// announceMAC floods a RARP announcement of a MAC, and a gratuitous ARP for
+// each IPv4 address it is known to hold, as if sent by the connection it
+// moved to
+func (vs *VirtualSwitch) announceMAC(mac net.HardwareAddr, conn *Connection) {
+	rarp := &arpPacket{
+		Op:        rarpRequest,
+		SenderMAC: mac,
+		SenderIP:  net.IPv4zero,
+		TargetMAC: mac,
+		TargetIP:  net.IPv4zero,
+	}
+	announcements := [][]byte{buildEthernet(BroadcastMAC, mac, EtherTypeRARP, rarp.marshal())}
+
+	for _, ip := range vs.macAddresses(mac) {
+		garp := &arpPacket{
+			Op:        arpRequest,
+			SenderMAC: mac,
+			SenderIP:  ip,
+			TargetMAC: make(net.HardwareAddr, 6),
+			TargetIP:  ip,
+		}
+		announcements = append(announcements, buildEthernet(BroadcastMAC, mac, EtherTypeARP, garp.marshal()))
+	}
+
+	for _, raw := range announcements {
+		frame, err := ParseEthernetFrame(raw)
+		if err != nil {
+			continue
+		}
+		frame.pooled = false
+		vs.capture.tap(raw, frame.timestamp())
+		_ = vs.floodFrame(frame, conn)
+	}
+	vs.logger.Debug("Announced moved MAC", "mac", mac.String(), "connection", conn.ID, "addresses", len(announcements)-1)
+}
+
+// macAddresses returns the IPv4 addresses known to belong to a MAC from
+// DHCP snooping and ARP suppression, sorted
+func (vs *VirtualSwitch) macAddresses(mac net.HardwareAddr) []net.IP {
+	var addresses []net.IP
+	if vs.dhcpSnooper != nil {
+		addresses = append(addresses, vs.dhcpSnooper.addresses(mac)...)
+	}
+	if vs.arpSuppressor != nil {
+		addresses = append(addresses, vs.arpSuppressor.addresses(mac)...)
+	}
+
+	sort.Slice(addresses, func(i, j int) bool {
+		return bytes.Compare(addresses[i].To16(), addresses[j].To16()) < 0
+	})
+	unique := addresses[:0]
+	for i, ip := range addresses {
+		if ip.To4() != nil && (i == 0 || !ip.Equal(addresses[i-1])) {
+			unique = append(unique, ip)
+		}
+	}
+	return unique
+}
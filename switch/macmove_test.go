@@ -0,0 +1,99 @@
+package vswitch
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestMACMove(t *testing.T) {
+	sw := NewVirtualSwitchWithConfig([]int{8080}, Config{
+		DHCPSnooping:    true,
+		NDInspection:    true,
+		ARPSuppression:  true,
+		MACMoveAnnounce: true,
+	})
+	source, target, bystander := &frameSink{}, &frameSink{}, &frameSink{}
+	sourceConn := NewConnection("source", source)
+	targetConn := NewConnection("target", target)
+	sw.connections.Store("source", sourceConn)
+	sw.connections.Store("target", targetConn)
+	sw.connections.Store("bystander", NewConnection("bystander", bystander))
+
+	// The VM has a lease and an IPv6 address on the source host
+	mac := net.HardwareAddr{0x52, 0x54, 0x00, 0x00, 0x00, 0x01}
+	sw.learnMAC(mac, sourceConn)
+	sw.dhcpSnooper.add(&DHCPBinding{IP: net.IPv4(10, 0, 0, 50).To4(), MAC: mac, ConnectionID: "source", Expires: time.Now().Add(time.Hour)})
+	sw.arpSuppressor.learn(net.IPv4(10, 0, 0, 51), mac)
+	ipv6 := net.ParseIP("2001:db8::50")
+	sw.ndGuard.claim(ipv6, mac, sourceConn)
+
+	// Its first frame after the migration moves it
+	if err := sw.processFrame(rawFrame(buildEthernet(BroadcastMAC, mac, EtherTypeIPv4, make([]byte, 46))), targetConn); err != nil {
+		t.Fatalf("Failed to process frame: %v", err)
+	}
+	if conn := sw.lookupMAC(mac); conn != targetConn {
+		t.Fatalf("Expected the MAC to move to the target connection")
+	}
+	if moves := sw.GetStats()["mac_moves"].(uint64); moves != 1 {
+		t.Errorf("Expected 1 MAC move, got %d", moves)
+	}
+
+	// The rest of the VLAN hears a RARP and a gratuitous ARP per address
+	// before the frame itself
+	frames := writtenFrames(t, bystander.data)
+	if len(frames) != 4 {
+		t.Fatalf("Expected 3 announcements and the frame, got %d frames", len(frames))
+	}
+	if !bytes.Equal(frames[0][0:6], BroadcastMAC) || !bytes.Equal(frames[0][6:12], mac) ||
+		binary.BigEndian.Uint16(frames[0][12:14]) != EtherTypeRARP || binary.BigEndian.Uint16(frames[0][20:22]) != rarpRequest {
+		t.Errorf("Expected a RARP announcement, got %x", frames[0])
+	}
+	for i, ip := range []net.IP{net.IPv4(10, 0, 0, 50), net.IPv4(10, 0, 0, 51)} {
+		packet, err := parseARP(frames[i+1][14:])
+		if err != nil || packet.Op != arpRequest || !packet.SenderIP.Equal(ip) || !packet.TargetIP.Equal(ip) || !bytes.Equal(packet.SenderMAC, mac) {
+			t.Errorf("Expected a gratuitous ARP for %s, got %x", ip, frames[i+1])
+		}
+	}
+	if len(target.data) != 0 {
+		t.Errorf("Expected no announcements to the connection the MAC moved to")
+	}
+	if len(writtenFrames(t, source.data)) != 4 {
+		t.Errorf("Expected the announcements to reach the source connection")
+	}
+
+	// The VM's lease and addresses survive the source connection closing
+	sw.cleanupConnection(sourceConn)
+	bindings := sw.GetDHCPBindings()
+	if len(bindings) != 1 || bindings[0].ConnectionID != "target" {
+		t.Errorf("Expected the lease to move to the target connection, got %+v", bindings)
+	}
+	if !sw.ndGuard.claim(ipv6, mac, targetConn) {
+		t.Errorf("Expected the IPv6 address to move to the target connection")
+	}
+}
+
+func TestMACMoveWithoutAnnounce(t *testing.T) {
+	sw := NewVirtualSwitch([]int{8080})
+	bystander := &frameSink{}
+	sourceConn := NewConnection("source", &frameSink{})
+	targetConn := NewConnection("target", &frameSink{})
+	sw.connections.Store("source", sourceConn)
+	sw.connections.Store("target", targetConn)
+	sw.connections.Store("bystander", NewConnection("bystander", bystander))
+
+	mac := net.HardwareAddr{0x52, 0x54, 0x00, 0x00, 0x00, 0x01}
+	sw.learnMAC(mac, sourceConn)
+	sw.learnMAC(mac, targetConn)
+	if conn := sw.lookupMAC(mac); conn != targetConn {
+		t.Errorf("Expected the MAC to move to the target connection")
+	}
+	if moves := sw.GetStats()["mac_moves"].(uint64); moves != 1 {
+		t.Errorf("Expected 1 MAC move, got %d", moves)
+	}
+	if len(bystander.data) != 0 {
+		t.Errorf("Expected no announcements by default")
+	}
+}
@@ -0,0 +1,232 @@
+package vswitch
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+// macStateEntry is a MAC table entry as saved to disk. Connections do not
+// survive a restart, so entries are kept by the remote address of their
+// connection and rebound when a connection from that address returns.
+type macStateEntry struct {
+	MAC       string    `json:"mac"`
+	Peer      string    `json:"peer"`
+	Static    bool      `json:"static"`
+	LearnedAt time.Time `json:"learned_at"`
+}
+
+// macState holds the saved MAC entries of a VLAN that no connection has
+// claimed yet; without a path they are only kept in memory
+type macState struct {
+	path    string
+	mutex   sync.Mutex
+	pending map[string][]macStateEntry // by peer
+}
+
+// newMACState creates the MAC state of a VLAN saved in a directory, or in
+// memory if dir is empty
+func newMACState(dir string, port int) *macState {
+	s := &macState{pending: make(map[string][]macStateEntry)}
+	if dir != "" {
+		s.path = filepath.Join(dir, fmt.Sprintf("vlan-%d.json", port))
+	}
+	return s
+}
+
+// load reads the saved entries, skipping those older than maxAge. A missing
+// file is not an error.
+func (s *macState) load(maxAge time.Duration) (int, error) {
+	data, err := os.ReadFile(s.path) // #nosec G304 - path is in the configured state directory
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	var entries []macStateEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return 0, fmt.Errorf("invalid MAC state file %s: %v", s.path, err)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	loaded := 0
+	now := time.Now()
+	for _, entry := range entries {
+		if _, err := net.ParseMAC(entry.MAC); err != nil || now.Sub(entry.LearnedAt) > maxAge {
+			continue
+		}
+		s.pending[entry.Peer] = append(s.pending[entry.Peer], entry)
+		loaded++
+	}
+	return loaded, nil
+}
+
+// claim removes and returns the saved entries of a peer
+func (s *macState) claim(peer string) []macStateEntry {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	entries := s.pending[peer]
+	delete(s.pending, peer)
+	return entries
+}
+
+// save writes the given entries and the unclaimed ones younger than maxAge.
+// The file is replaced atomically so a crash never leaves it truncated.
+func (s *macState) save(entries []macStateEntry, maxAge time.Duration) error {
+	s.mutex.Lock()
+	now := time.Now()
+	for peer, pending := range s.pending {
+		kept := pending[:0]
+		for _, entry := range pending {
+			if now.Sub(entry.LearnedAt) <= maxAge {
+				kept = append(kept, entry)
+			}
+		}
+		if len(kept) == 0 {
+			delete(s.pending, peer)
+			continue
+		}
+		s.pending[peer] = kept
+		entries = append(entries, kept...)
+	}
+	s.mutex.Unlock()
+
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// add queues entries for their peers to claim
+func (s *macState) add(entries []macStateEntry) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, entry := range entries {
+		s.pending[entry.Peer] = append(s.pending[entry.Peer], entry)
+	}
+}
+
+// static returns the unclaimed entries of sticky bindings
+func (s *macState) static() []macStateEntry {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var entries []macStateEntry
+	for _, pending := range s.pending {
+		for _, entry := range pending {
+			if entry.Static {
+				entries = append(entries, entry)
+			}
+		}
+	}
+	return entries
+}
+
+// removeStatic forgets the unclaimed entries of a MAC's sticky binding
+func (s *macState) removeStatic(mac string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for peer, pending := range s.pending {
+		kept := slices.DeleteFunc(pending, func(entry macStateEntry) bool {
+			return entry.Static && entry.MAC == mac
+		})
+		if len(kept) == 0 {
+			delete(s.pending, peer)
+			continue
+		}
+		s.pending[peer] = kept
+	}
+}
+
+// loadMACState restores the saved MAC entries at startup
+func (vs *VirtualSwitch) loadMACState() {
+	if vs.macState.path == "" {
+		return
+	}
+
+	loaded, err := vs.macState.load(vs.macTimeout)
+	if err != nil {
+		vs.logger.Warn("Failed to load MAC state", "error", err)
+		return
+	}
+	if loaded > 0 {
+		vs.logger.Info("Loaded saved MAC entries", "count", loaded)
+	}
+}
+
+// saveMACState writes the MAC table to the VLAN's state file
+func (vs *VirtualSwitch) saveMACState() {
+	if vs.macState.path == "" {
+		return
+	}
+
+	peers := make(map[string]string)
+	vs.connections.Range(func(key, value interface{}) bool {
+		peers[key.(string)] = value.(*Connection).stateKey()
+		return true
+	})
+
+	entries := make([]macStateEntry, 0)
+	for _, entry := range vs.GetMACTable() {
+		peer, found := peers[entry.ConnectionID]
+		if !found {
+			continue
+		}
+		entries = append(entries, macStateEntry{
+			MAC:       entry.MAC,
+			Peer:      peer,
+			Static:    entry.Static,
+			LearnedAt: entry.LearnedAt,
+		})
+	}
+
+	if err := vs.macState.save(entries, vs.macTimeout); err != nil {
+		vs.logger.Warn("Failed to save MAC state", "error", err)
+	}
+}
+
+// restoreMACs rebinds the saved MAC entries of a new connection's peer to
+// it, so frames to its guests are not flooded until they speak again. It
+// runs again once a client presents its identity.
+func (vs *VirtualSwitch) restoreMACs(conn *Connection) {
+	restored := 0
+	for _, entry := range vs.macState.claim(conn.stateKey()) {
+		mac, err := net.ParseMAC(entry.MAC)
+		if err != nil {
+			continue
+		}
+		if _, learned := vs.macTable.get(mac); learned {
+			continue
+		}
+		vs.macTable.store(mac, MACEntry{Connection: conn, LearnedAt: entry.LearnedAt})
+		if entry.Static && vs.config.StickyMAC {
+			vs.macBindings.LoadOrStore(entry.MAC, conn)
+		}
+		restored++
+	}
+
+	if restored > 0 {
+		vs.logger.Info("Restored saved MAC entries", "connection", conn.ID, "count", restored)
+	}
+}
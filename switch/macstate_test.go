@@ -0,0 +1,83 @@
+package vswitch
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMACStateSaveAndRestore(t *testing.T) {
+	dir := t.TempDir()
+	config := DefaultConfig()
+	config.MACStateDir = dir
+	config.StickyMAC = true
+
+	mac := net.HardwareAddr{0x52, 0x54, 0x00, 0x00, 0x00, 0x01}
+	addr := &mockAddrSwitch{network: "tcp", address: "127.0.0.1:40001"}
+
+	vs := NewVirtualSwitchWithConfig([]int{9999}, config)
+	conn := NewConnection("guest", &mockConnSwitch{addr: addr})
+	vs.connections.Store(conn.ID, conn)
+	if !vs.checkMACBinding(mac, conn) {
+		t.Fatal("Expected the MAC to be bound")
+	}
+	vs.learnMAC(mac, conn)
+	vs.saveMACState()
+
+	if _, err := os.Stat(filepath.Join(dir, "vlan-9999.json")); err != nil {
+		t.Fatalf("Expected a state file: %v", err)
+	}
+
+	restarted := NewVirtualSwitchWithConfig([]int{9999}, config)
+	restarted.loadMACState()
+
+	other := NewConnection("other", &mockConnSwitch{addr: &mockAddrSwitch{network: "tcp", address: "127.0.0.1:40002"}})
+	restarted.restoreMACs(other)
+	if restarted.lookupMAC(mac) != nil {
+		t.Error("Expected the MAC not to be restored to another peer")
+	}
+
+	returned := NewConnection("guest-again", &mockConnSwitch{addr: addr})
+	restarted.restoreMACs(returned)
+	if restarted.lookupMAC(mac) != returned {
+		t.Error("Expected the MAC to be restored to the returning peer")
+	}
+	if restarted.checkMACBinding(mac, other) {
+		t.Error("Expected the sticky binding to be restored")
+	}
+}
+
+func TestMACStateSkipsExpiredEntries(t *testing.T) {
+	state := newMACState(t.TempDir(), 9999)
+	old := macStateEntry{MAC: "52:54:00:00:00:01", Peer: "127.0.0.1:40001", LearnedAt: time.Now().Add(-time.Hour)}
+	fresh := macStateEntry{MAC: "52:54:00:00:00:02", Peer: "127.0.0.1:40001", LearnedAt: time.Now()}
+	if err := state.save([]macStateEntry{old, fresh}, time.Hour*2); err != nil {
+		t.Fatalf("Failed to save: %v", err)
+	}
+
+	reloaded := newMACState(filepath.Dir(state.path), 9999)
+	loaded, err := reloaded.load(time.Minute)
+	if err != nil {
+		t.Fatalf("Failed to load: %v", err)
+	}
+	if loaded != 1 {
+		t.Errorf("Expected only the fresh entry to load, got %d", loaded)
+	}
+
+	// Unclaimed entries are kept across saves until they expire
+	if err := reloaded.save(nil, time.Minute); err != nil {
+		t.Fatalf("Failed to save: %v", err)
+	}
+	if entries := reloaded.claim("127.0.0.1:40001"); len(entries) != 1 || entries[0].MAC != fresh.MAC {
+		t.Errorf("Expected the unclaimed entry to be kept, got %+v", entries)
+	}
+}
+
+func TestMACStateMissingFile(t *testing.T) {
+	state := newMACState(t.TempDir(), 9999)
+	if loaded, err := state.load(time.Minute); err != nil || loaded != 0 {
+		t.Errorf("Expected a missing file to load nothing, got %d (%v)", loaded, err)
+	}
+}
@@ -0,0 +1,278 @@
+package vswitch
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// macTableShardBits selects the number of MAC table shards
+	macTableShardBits = 6
+	macTableShards    = 1 << macTableShardBits
+
+	// macShardCapacity is the number of entries preallocated per shard
+	macShardCapacity = 16
+
+	// macRefreshInterval is how stale an entry's learn time may get before
+	// a frame from the same connection refreshes it. Refreshing on every
+	// frame would take the shard's write lock for each one.
+	macRefreshInterval = time.Second
+)
+
+// macKey is a MAC address usable as a map key without allocating
+type macKey [6]byte
+
+// newMACKey converts a MAC address to a map key
+func newMACKey(mac net.HardwareAddr) macKey {
+	var key macKey
+	copy(key[:], mac)
+	return key
+}
+
+// String returns the MAC address in its usual notation
+func (k macKey) String() string {
+	return net.HardwareAddr(k[:]).String()
+}
+
+// macShard is one lock-protected part of the MAC table
+type macShard struct {
+	mutex   sync.RWMutex
+	entries map[macKey]MACEntry
+}
+
+// macTable maps learned MAC addresses to connections. It is split into
+// shards chosen by a hash of the MAC so that learning and lookups on
+// different MACs rarely contend, and stores entries by value so that
+// learning does not allocate.
+type macTable struct {
+	shards [macTableShards]macShard
+
+	// limit caps the number of entries, 0 for no limit; beyond it the
+	// least recently used entries are evicted
+	limit     int
+	count     atomic.Int64
+	evictions atomic.Uint64
+
+	// evicted, if set, is called with every evicted entry once the table
+	// is unlocked
+	evicted func(key macKey, entry MACEntry)
+}
+
+// newMACTable creates an empty MAC table holding at most limit entries,
+// or any number if limit is 0
+func newMACTable(limit int) *macTable {
+	t := &macTable{limit: limit}
+	for i := range t.shards {
+		t.shards[i].entries = make(map[macKey]MACEntry, macShardCapacity)
+	}
+	return t
+}
+
+// shard returns the shard holding a MAC. The NIC-specific low bytes are
+// mixed with a Fibonacci hash, as MACs of one vendor share their high bytes.
+func (t *macTable) shard(key macKey) *macShard {
+	low := uint32(key[2])<<24 | uint32(key[3])<<16 | uint32(key[4])<<8 | uint32(key[5])
+	return &t.shards[(low*2654435769)>>(32-macTableShardBits)]
+}
+
+// lookup returns the connection a MAC was learned on, or nil
+func (t *macTable) lookup(mac net.HardwareAddr) *Connection {
+	key := newMACKey(mac)
+	shard := t.shard(key)
+
+	shard.mutex.RLock()
+	defer shard.mutex.RUnlock()
+	return shard.entries[key].Connection
+}
+
+// get returns a copy of the entry of a MAC
+func (t *macTable) get(mac net.HardwareAddr) (MACEntry, bool) {
+	key := newMACKey(mac)
+	shard := t.shard(key)
+
+	shard.mutex.RLock()
+	defer shard.mutex.RUnlock()
+	entry, found := shard.entries[key]
+	return entry, found
+}
+
+// store sets the entry of a MAC
+func (t *macTable) store(mac net.HardwareAddr, entry MACEntry) {
+	key := newMACKey(mac)
+	shard := t.shard(key)
+
+	shard.mutex.Lock()
+	_, found := shard.entries[key]
+	shard.entries[key] = entry
+	shard.mutex.Unlock()
+
+	if !found {
+		t.count.Add(1)
+		t.evict(key)
+	}
+}
+
+// learn records that a MAC was seen on a connection at the given time. It
+// returns the connection the MAC was previously learned on, or nil if it
+// is new.
+func (t *macTable) learn(mac net.HardwareAddr, conn *Connection, now time.Time) *Connection {
+	key := newMACKey(mac)
+	shard := t.shard(key)
+
+	// Most frames come from a MAC already learned on their connection
+	shard.mutex.RLock()
+	entry, found := shard.entries[key]
+	shard.mutex.RUnlock()
+	if found && entry.Connection == conn && now.Sub(entry.LearnedAt) < macRefreshInterval {
+		return conn
+	}
+
+	shard.mutex.Lock()
+	previous, found := shard.entries[key]
+	shard.entries[key] = MACEntry{Connection: conn, LearnedAt: now}
+	shard.mutex.Unlock()
+
+	if !found {
+		t.count.Add(1)
+		t.evict(key)
+	}
+	return previous.Connection
+}
+
+// evict removes least recently used entries while the table is over its
+// limit, other than the entry just added. The whole table is searched, as
+// the oldest entry of the added entry's shard may be in active use while
+// another shard holds a stale one. Learn times are refreshed at most every
+// macRefreshInterval, so recency is only that precise.
+func (t *macTable) evict(added macKey) {
+	for t.limit > 0 && t.count.Load() > int64(t.limit) {
+		if !t.evictOldest(added) {
+			return
+		}
+	}
+}
+
+// evictFrom evicts the least recently used entry of a shard other than
+// keep, returning false if there is none
+func (t *macTable) evictFrom(shard *macShard, keep macKey) bool {
+	shard.mutex.Lock()
+	key, entry, found := shard.oldest(keep)
+	if found {
+		delete(shard.entries, key)
+		t.count.Add(-1)
+	}
+	shard.mutex.Unlock()
+
+	if !found {
+		return false
+	}
+	t.evictions.Add(1)
+	if t.evicted != nil {
+		t.evicted(key, entry)
+	}
+	return true
+}
+
+// evictOldest evicts the least recently used entry of the whole table
+// other than keep, returning false if there is none
+func (t *macTable) evictOldest(keep macKey) bool {
+	var oldest *macShard
+	var oldestAt time.Time
+
+	for i := range t.shards {
+		shard := &t.shards[i]
+		shard.mutex.RLock()
+		if _, entry, found := shard.oldest(keep); found && (oldest == nil || entry.LearnedAt.Before(oldestAt)) {
+			oldest, oldestAt = shard, entry.LearnedAt
+		}
+		shard.mutex.RUnlock()
+	}
+
+	return oldest != nil && t.evictFrom(oldest, keep)
+}
+
+// oldest returns the entry of the locked shard learned longest ago, other
+// than keep
+func (s *macShard) oldest(keep macKey) (macKey, MACEntry, bool) {
+	var oldestKey macKey
+	var oldest MACEntry
+	found := false
+
+	for key, entry := range s.entries {
+		if key != keep && (!found || entry.LearnedAt.Before(oldest.LearnedAt)) {
+			oldestKey, oldest, found = key, entry, true
+		}
+	}
+	return oldestKey, oldest, found
+}
+
+// rebind moves the entries learned on one connection to another and
+// returns their MACs
+func (t *macTable) rebind(from, to *Connection) []net.HardwareAddr {
+	var macs []net.HardwareAddr
+
+	for i := range t.shards {
+		shard := &t.shards[i]
+		shard.mutex.Lock()
+		for key, entry := range shard.entries {
+			if entry.Connection == from {
+				entry.Connection = to
+				shard.entries[key] = entry
+				macs = append(macs, net.HardwareAddr(append([]byte{}, key[:]...)))
+			}
+		}
+		shard.mutex.Unlock()
+	}
+
+	return macs
+}
+
+// deleteIf removes the entries for which remove returns true and returns
+// how many were removed
+func (t *macTable) deleteIf(remove func(key macKey, entry MACEntry) bool) int {
+	removed := 0
+
+	for i := range t.shards {
+		shard := &t.shards[i]
+		shard.mutex.Lock()
+		for key, entry := range shard.entries {
+			if remove(key, entry) {
+				delete(shard.entries, key)
+				removed++
+			}
+		}
+		shard.mutex.Unlock()
+	}
+
+	t.count.Add(-int64(removed))
+	return removed
+}
+
+// rangeEntries calls fn for every entry. The shard being visited is locked,
+// so fn must not modify the table.
+func (t *macTable) rangeEntries(fn func(key macKey, entry MACEntry)) {
+	for i := range t.shards {
+		shard := &t.shards[i]
+		shard.mutex.RLock()
+		for key, entry := range shard.entries {
+			fn(key, entry)
+		}
+		shard.mutex.RUnlock()
+	}
+}
+
+// len returns the number of learned MACs
+func (t *macTable) len() int {
+	count := 0
+
+	for i := range t.shards {
+		shard := &t.shards[i]
+		shard.mutex.RLock()
+		count += len(shard.entries)
+		shard.mutex.RUnlock()
+	}
+
+	return count
+}
@@ -0,0 +1,253 @@
+package vswitch
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMACTableLearn(t *testing.T) {
+	table := newMACTable(0)
+	conn1 := NewConnection("conn1", &mockConnSwitch{})
+	conn2 := NewConnection("conn2", &mockConnSwitch{})
+	mac := net.HardwareAddr{0x52, 0x54, 0x00, 0x12, 0x34, 0x56}
+	now := time.Now()
+
+	if previous := table.learn(mac, conn1, now); previous != nil {
+		t.Errorf("Expected a new MAC, got previous %s", previous.ID)
+	}
+	if previous := table.learn(mac, conn1, now.Add(2*time.Second)); previous != conn1 {
+		t.Errorf("Expected the MAC to stay on conn1")
+	}
+	if entry, _ := table.get(mac); !entry.LearnedAt.Equal(now.Add(2 * time.Second)) {
+		t.Errorf("Expected the learn time to be refreshed, got %v", entry.LearnedAt)
+	}
+	if previous := table.learn(mac, conn2, now.Add(3*time.Second)); previous != conn1 {
+		t.Errorf("Expected the MAC to move from conn1")
+	}
+	if table.lookup(mac) != conn2 || table.len() != 1 {
+		t.Errorf("Expected the MAC to be learned on conn2 only")
+	}
+}
+
+func TestMACTableDeleteIf(t *testing.T) {
+	table := newMACTable(0)
+	conn1 := NewConnection("conn1", &mockConnSwitch{})
+	conn2 := NewConnection("conn2", &mockConnSwitch{})
+
+	// Enough MACs to land in several shards
+	for i := 0; i < 200; i++ {
+		conn := conn1
+		if i%2 == 1 {
+			conn = conn2
+		}
+		table.learn(net.HardwareAddr{0x52, 0x54, 0x00, 0x00, byte(i >> 8), byte(i)}, conn, time.Now())
+	}
+
+	removed := table.deleteIf(func(_ macKey, entry MACEntry) bool {
+		return entry.Connection == conn1
+	})
+	if removed != 100 || table.len() != 100 {
+		t.Errorf("Expected 100 MACs removed and 100 left, got %d and %d", removed, table.len())
+	}
+
+	table.rangeEntries(func(key macKey, entry MACEntry) {
+		if entry.Connection != conn2 {
+			t.Errorf("Expected %s to remain on conn2", key)
+		}
+	})
+}
+
+func TestMACTableLimit(t *testing.T) {
+	table := newMACTable(4)
+	var evicted []string
+	table.evicted = func(key macKey, _ MACEntry) { evicted = append(evicted, key.String()) }
+	conn := NewConnection("conn1", &mockConnSwitch{})
+	now := time.Now()
+
+	macs := make([]net.HardwareAddr, 6)
+	for i := range macs {
+		macs[i] = net.HardwareAddr{0x52, 0x54, 0x00, 0x00, 0x00, byte(i)}
+	}
+	for i := 0; i < 4; i++ {
+		table.learn(macs[i], conn, now.Add(time.Duration(i)*time.Second))
+	}
+
+	// Traffic from the first MAC makes the second the least recently used
+	table.learn(macs[0], conn, now.Add(10*time.Second))
+	table.learn(macs[4], conn, now.Add(11*time.Second))
+	table.learn(macs[5], conn, now.Add(12*time.Second))
+
+	if table.len() != 4 || table.evictions.Load() != 2 {
+		t.Errorf("Expected 4 MACs and 2 evictions, got %d and %d", table.len(), table.evictions.Load())
+	}
+	if len(evicted) != 2 || evicted[0] != macs[1].String() || evicted[1] != macs[2].String() {
+		t.Errorf("Expected %s and %s to be evicted, got %v", macs[1], macs[2], evicted)
+	}
+	for _, mac := range []net.HardwareAddr{macs[0], macs[3], macs[4], macs[5]} {
+		if table.lookup(mac) != conn {
+			t.Errorf("Expected %s to remain", mac)
+		}
+	}
+}
+
+func TestMACTableLimitAcrossShards(t *testing.T) {
+	table := newMACTable(2)
+	conn := NewConnection("conn1", &mockConnSwitch{})
+	now := time.Now()
+
+	// A new MAC in the shard of an active one evicts the stale MAC of
+	// another shard, not its neighbor
+	active := net.HardwareAddr{0x52, 0x54, 0x00, 0x00, 0x00, 0x01}
+	var stale, added net.HardwareAddr
+	for i := 2; stale == nil || added == nil; i++ {
+		mac := net.HardwareAddr{0x52, 0x54, 0x00, 0x00, byte(i >> 8), byte(i)}
+		if table.shard(newMACKey(mac)) == table.shard(newMACKey(active)) {
+			if added == nil {
+				added = mac
+			}
+		} else if stale == nil {
+			stale = mac
+		}
+	}
+	table.learn(stale, conn, now)
+	table.learn(active, conn, now.Add(10*time.Second))
+	table.learn(added, conn, now.Add(11*time.Second))
+
+	if table.lookup(stale) != nil {
+		t.Errorf("Expected the stale MAC %s to be evicted", stale)
+	}
+	for _, mac := range []net.HardwareAddr{active, added} {
+		if table.lookup(mac) != conn {
+			t.Errorf("Expected %s to remain", mac)
+		}
+	}
+}
+
+func TestMACTableLimitFlood(t *testing.T) {
+	table := newMACTable(100)
+	conn := NewConnection("conn1", &mockConnSwitch{})
+
+	// A guest sending from random source MACs cannot grow the table
+	for i := 0; i < 5000; i++ {
+		table.learn(net.HardwareAddr{0x02, byte(i * 7), byte(i >> 16), byte(i >> 8), byte(i), byte(i * 13)}, conn, time.Now())
+	}
+	if table.len() != 100 || table.evictions.Load() != 4900 {
+		t.Errorf("Expected 100 MACs and 4900 evictions, got %d and %d", table.len(), table.evictions.Load())
+	}
+
+	// Removed entries make room again
+	table.deleteIf(func(macKey, MACEntry) bool { return true })
+	table.learn(net.HardwareAddr{0x52, 0x54, 0x00, 0x00, 0x00, 0x01}, conn, time.Now())
+	if table.len() != 1 || table.evictions.Load() != 4900 {
+		t.Errorf("Expected the emptied table to learn without evicting")
+	}
+}
+
+func TestMACEvictionEvent(t *testing.T) {
+	var events []MACEvent
+	sw := NewVirtualSwitchWithConfig([]int{8080}, Config{
+		MACTableSize:    1,
+		MACEventHandler: func(event MACEvent) { events = append(events, event) },
+	})
+	conn := NewConnection("conn1", &frameSink{})
+
+	sw.learnMAC(net.HardwareAddr{0x52, 0x54, 0x00, 0x00, 0x00, 0x01}, conn)
+	sw.learnMAC(net.HardwareAddr{0x52, 0x54, 0x00, 0x00, 0x00, 0x02}, conn)
+	if evictions := sw.GetStats()["mac_evictions"].(uint64); evictions != 1 {
+		t.Errorf("Expected 1 eviction, got %d", evictions)
+	}
+	if len(events) != 3 || events[1].Type != MACEvicted || events[1].MAC != "52:54:00:00:00:01" {
+		t.Errorf("Expected the first MAC to be reported evicted, got %+v", events)
+	}
+}
+
+// syncMapMACTable is the sync.Map based MAC table the sharded table
+// replaced, kept to compare their performance
+type syncMapMACTable struct {
+	entries sync.Map // map[string]*MACEntry
+}
+
+func (t *syncMapMACTable) learn(mac net.HardwareAddr, conn *Connection) {
+	macStr := mac.String()
+	if value, found := t.entries.Load(macStr); found {
+		if entry := value.(*MACEntry); entry.Connection.ID == conn.ID {
+			entry.LearnedAt = time.Now()
+			return
+		}
+	}
+	t.entries.Store(macStr, &MACEntry{Connection: conn, LearnedAt: time.Now()})
+}
+
+func (t *syncMapMACTable) lookup(mac net.HardwareAddr) *Connection {
+	if value, found := t.entries.Load(mac.String()); found {
+		return value.(*MACEntry).Connection
+	}
+	return nil
+}
+
+// benchmarkMACs returns distinct MAC addresses of a VLAN with many guests
+func benchmarkMACs() []net.HardwareAddr {
+	macs := make([]net.HardwareAddr, 1024)
+	for i := range macs {
+		macs[i] = net.HardwareAddr{0x52, 0x54, 0x00, 0x00, byte(i >> 8), byte(i)}
+	}
+	return macs
+}
+
+func BenchmarkMACTableLearn(b *testing.B) {
+	macs := benchmarkMACs()
+	conn := NewConnection("conn1", &mockConnSwitch{})
+
+	b.Run("sharded", func(b *testing.B) {
+		table := newMACTable(0)
+		b.ReportAllocs()
+		b.RunParallel(func(pb *testing.PB) {
+			for i := 0; pb.Next(); i++ {
+				table.learn(macs[i%len(macs)], conn, time.Now())
+			}
+		})
+	})
+
+	b.Run("sync.Map", func(b *testing.B) {
+		table := &syncMapMACTable{}
+		b.ReportAllocs()
+		b.RunParallel(func(pb *testing.PB) {
+			for i := 0; pb.Next(); i++ {
+				table.learn(macs[i%len(macs)], conn)
+			}
+		})
+	})
+}
+
+func BenchmarkMACTableLookup(b *testing.B) {
+	macs := benchmarkMACs()
+	conn := NewConnection("conn1", &mockConnSwitch{})
+
+	b.Run("sharded", func(b *testing.B) {
+		table := newMACTable(0)
+		for _, mac := range macs {
+			table.learn(mac, conn, time.Now())
+		}
+		b.ReportAllocs()
+		b.RunParallel(func(pb *testing.PB) {
+			for i := 0; pb.Next(); i++ {
+				_ = table.lookup(macs[i%len(macs)])
+			}
+		})
+	})
+
+	b.Run("sync.Map", func(b *testing.B) {
+		table := &syncMapMACTable{}
+		for _, mac := range macs {
+			table.learn(mac, conn)
+		}
+		b.ReportAllocs()
+		b.RunParallel(func(pb *testing.PB) {
+			for i := 0; pb.Next(); i++ {
+				_ = table.lookup(macs[i%len(macs)])
+			}
+		})
+	})
+}
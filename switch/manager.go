@@ -1,38 +1,165 @@
 package vswitch
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
+	"net"
+	"slices"
+	"sort"
 	"sync"
+	"time"
 )
 
 // SwitchManager manages multiple isolated virtual switches (VLANs)
 type SwitchManager struct {
-	switches map[int]*VirtualSwitch // port -> switch mapping
-	mutex    sync.RWMutex
+	switches      map[int]*VirtualSwitch // port -> switch mapping
+	defaultConfig Config
+	instance      string
+	cluster       *Cluster
+	events        eventHub
+	auditLog      *AuditLog
+	mutex         sync.RWMutex
+
+	// siblings mirrors switches for the forwarding paths of the VLANs,
+	// which cannot take mutex: it is held while VLANs stop
+	siblings sync.Map // map[int]*VirtualSwitch
+
+	// reconcileMutex serializes imports and reconciliations to a desired
+	// state
+	reconcileMutex sync.Mutex
+
+	// bridges pass the frames their rules select between two VLANs
+	bridges      map[string]*bridge
+	nextBridgeID int
+	bridgeMutex  sync.Mutex
+
+	// statsResetAt is when the statistics of all VLANs were last reset, or
+	// else the manager was created
+	statsResetAt time.Time
+
+	// ctx is the context VLANs started by StartAll run under, and those
+	// started later by StartVLAN
+	ctx context.Context
 }
 
 // NewSwitchManager creates a new switch manager
 func NewSwitchManager() *SwitchManager {
 	return &SwitchManager{
-		switches: make(map[int]*VirtualSwitch),
+		switches:      make(map[int]*VirtualSwitch),
+		defaultConfig: DefaultConfig(),
+		statsResetAt:  time.Now(),
 	}
 }
 
-// AddVLAN creates a new isolated VLAN on the specified port
+// SetDefaultConfig sets the configuration used by VLANs created with AddVLAN
+func (sm *SwitchManager) SetDefaultConfig(config Config) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	sm.defaultConfig = config
+}
+
+// logger returns the logger of the manager, that of the default
+// configuration
+func (sm *SwitchManager) logger() *slog.Logger {
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+
+	return sm.defaultConfig.logger()
+}
+
+// SetInstance names the switch among several running on the same host. The
+// name is reported in the statistics.
+func (sm *SwitchManager) SetInstance(name string) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	sm.instance = name
+}
+
+// AddVLAN creates a new isolated VLAN on the specified port using the default configuration
 func (sm *SwitchManager) AddVLAN(port int) error {
+	sm.mutex.RLock()
+	config := sm.defaultConfig
+	sm.mutex.RUnlock()
+
+	return sm.AddVLANWithConfig(port, config)
+}
+
+// AddVLANWithConfig creates a new isolated VLAN on the specified port
+func (sm *SwitchManager) AddVLANWithConfig(port int, config Config) error {
+	return sm.AddVLANWithPorts([]int{port}, config)
+}
+
+// AddVLANWithPorts creates a new isolated VLAN whose connections arrive on
+// several ports, which then share its broadcast domain. The first port
+// identifies the VLAN.
+func (sm *SwitchManager) AddVLANWithPorts(ports []int, config Config) error {
+	if len(ports) == 0 {
+		return fmt.Errorf("a VLAN needs a port")
+	}
+	port := ports[0]
+
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	if err := sm.checkPortsFree(ports); err != nil {
+		return err
+	}
+
+	vs := NewVirtualSwitchWithConfig(slices.Clone(ports), config)
+	vs.events = &sm.events
+	vs.siblings = &sm.siblings
+	sm.switches[port] = vs
+	sm.siblings.Store(port, vs)
+
+	if len(ports) > 1 {
+		sm.defaultConfig.logger().Info("Created VLAN", "vlan", port, "ports", ports)
+	} else {
+		sm.defaultConfig.logger().Info("Created VLAN", "vlan", port)
+	}
+	sm.events.publish(Event{Type: EventVLANAdded, VLAN: port})
+	return nil
+}
+
+// StartVLAN creates a VLAN on the specified port using the default
+// configuration and starts it while the other VLANs keep running
+func (sm *SwitchManager) StartVLAN(port int) error {
+	sm.mutex.RLock()
+	config := sm.defaultConfig
+	sm.mutex.RUnlock()
+
+	return sm.StartVLANWithConfig(port, config)
+}
+
+// StartVLANWithConfig creates a VLAN on the specified port and starts it
+// while the other VLANs keep running
+func (sm *SwitchManager) StartVLANWithConfig(port int, config Config) error {
 	sm.mutex.Lock()
 	defer sm.mutex.Unlock()
 
-	if _, exists := sm.switches[port]; exists {
-		return fmt.Errorf("VLAN already exists on port %d", port)
+	if err := sm.checkPortsFree([]int{port}); err != nil {
+		return err
 	}
 
-	// Create a single-port virtual switch for this VLAN
-	vs := NewVirtualSwitch([]int{port})
+	ctx := sm.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	vs := NewVirtualSwitchWithConfig([]int{port}, config)
+	vs.events = &sm.events
+	vs.siblings = &sm.siblings
+	if err := vs.Start(ctx); err != nil {
+		return fmt.Errorf("failed to start VLAN on port %d: %v", port, err)
+	}
 	sm.switches[port] = vs
+	sm.siblings.Store(port, vs)
 
-	log.Printf("Created VLAN on port %d", port)
+	sm.defaultConfig.logger().Info("Started VLAN", "vlan", port)
+	sm.events.publish(Event{Type: EventVLANAdded, VLAN: port})
 	return nil
 }
 
@@ -48,24 +175,57 @@ func (sm *SwitchManager) RemoveVLAN(port int) error {
 
 	vs.Stop()
 	delete(sm.switches, port)
+	sm.siblings.Delete(port)
 
-	log.Printf("Removed VLAN on port %d", port)
+	sm.defaultConfig.logger().Info("Removed VLAN", "vlan", port)
+	sm.events.publish(Event{Type: EventVLANRemoved, VLAN: port})
 	return nil
 }
 
-// StartAll starts all VLANs
-func (sm *SwitchManager) StartAll() error {
-	sm.mutex.RLock()
-	defer sm.mutex.RUnlock()
+// checkPortsFree returns an error if a VLAN already uses one of the ports;
+// the caller holds the mutex
+func (sm *SwitchManager) checkPortsFree(ports []int) error {
+	for i, port := range ports {
+		if slices.Contains(ports[:i], port) {
+			return fmt.Errorf("port %d listed twice", port)
+		}
+		if _, exists := sm.switches[port]; exists {
+			return fmt.Errorf("VLAN already exists on port %d", port)
+		}
+		for vlan, vs := range sm.switches {
+			if slices.Contains(vs.ports, port) {
+				return fmt.Errorf("port %d already feeds the VLAN on port %d", port, vlan)
+			}
+		}
+	}
+	return nil
+}
 
-	for port, vs := range sm.switches {
-		if err := vs.Start(); err != nil {
-			return fmt.Errorf("failed to start VLAN on port %d: %v", port, err)
+// StartAll starts all VLANs, which stop when ctx is done, as do the VLANs
+// started later with StartVLAN. A VLAN failing to start does not keep the
+// others from starting; the error names every VLAN that failed, and the
+// caller decides whether to stop or to run degraded without them.
+func (sm *SwitchManager) StartAll(ctx context.Context) error {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	sm.ctx = ctx
+	ports := make([]int, 0, len(sm.switches))
+	for port := range sm.switches {
+		ports = append(ports, port)
+	}
+	sort.Ints(ports)
+
+	var errs []error
+	for _, port := range ports {
+		if err := sm.switches[port].Start(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("failed to start VLAN on port %d: %w", port, err))
+			continue
 		}
-		log.Printf("Started VLAN on port %d", port)
+		sm.defaultConfig.logger().Info("Started VLAN", "vlan", port)
 	}
 
-	return nil
+	return errors.Join(errs...)
 }
 
 // StopAll stops all VLANs
@@ -75,8 +235,86 @@ func (sm *SwitchManager) StopAll() {
 
 	for port, vs := range sm.switches {
 		vs.Stop()
-		log.Printf("Stopped VLAN on port %d", port)
+		sm.defaultConfig.logger().Info("Stopped VLAN", "vlan", port)
+	}
+}
+
+// Shutdown drains and stops all VLANs in parallel, bounded by ctx, as
+// VirtualSwitch.Shutdown does. It returns ctx's error if some connections
+// were still open when ctx was done.
+func (sm *SwitchManager) Shutdown(ctx context.Context) error {
+	// Bridges would keep their VLANs from draining
+	sm.closeBridges()
+
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+
+	var wg sync.WaitGroup
+	for port, vs := range sm.switches {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_ = vs.Shutdown(ctx)
+			sm.defaultConfig.logger().Info("Stopped VLAN", "vlan", port)
+		}()
+	}
+	wg.Wait()
+	return ctx.Err()
+}
+
+// Drain waits until the connections of all VLANs have closed or the timeout
+// expires, and reports whether they all closed. It is used once the
+// listeners were handed to an upgraded process, so no new ones arrive.
+func (sm *SwitchManager) Drain(timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	sm.closeBridges()
+
+	for {
+		remaining := 0
+		sm.mutex.RLock()
+		for _, vs := range sm.switches {
+			remaining += vs.connectionCount()
+		}
+		sm.mutex.RUnlock()
+
+		if remaining == 0 {
+			return true
+		}
+		if time.Now().After(deadline) {
+			sm.logger().Warn("Connections still open after drain timeout", "count", remaining)
+			return false
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// vlan returns the switch of the VLAN on a port, or nil
+func (sm *SwitchManager) vlan(port int) *VirtualSwitch {
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+
+	return sm.switches[port]
+}
+
+// setCluster records the cluster the manager's VLANs span, or nil
+func (sm *SwitchManager) setCluster(cluster *Cluster) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	sm.cluster = cluster
+}
+
+// ClusterMembers returns the cluster nodes linked to this switch, or nil
+// when it is not clustered
+func (sm *SwitchManager) ClusterMembers() []ClusterMember {
+	sm.mutex.RLock()
+	cluster := sm.cluster
+	sm.mutex.RUnlock()
+
+	if cluster == nil {
+		return nil
 	}
+	return cluster.Members()
 }
 
 // GetVLANs returns a list of active VLAN ports
@@ -92,6 +330,388 @@ func (sm *SwitchManager) GetVLANs() []int {
 	return ports
 }
 
+// ClearMACBindings removes all sticky MAC bindings on the VLAN at the given port
+func (sm *SwitchManager) ClearMACBindings(port int) (int, error) {
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+
+	vs, exists := sm.switches[port]
+	if !exists {
+		return 0, fmt.Errorf("VLAN does not exist on port %d", port)
+	}
+
+	return vs.ClearMACBindings(), nil
+}
+
+// SetPortMode changes the private VLAN mode of a connection on the VLAN at the given port
+func (sm *SwitchManager) SetPortMode(port int, connID string, mode PortMode, community string) error {
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+
+	vs, exists := sm.switches[port]
+	if !exists {
+		return fmt.Errorf("VLAN does not exist on port %d", port)
+	}
+
+	return vs.SetPortMode(connID, mode, community)
+}
+
+// SetVLANDisabled administratively shuts down or re-enables the VLAN at the given port
+func (sm *SwitchManager) SetVLANDisabled(port int, disabled bool) error {
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+
+	vs, exists := sm.switches[port]
+	if !exists {
+		return fmt.Errorf("VLAN does not exist on port %d", port)
+	}
+
+	vs.SetDisabled(disabled)
+	return nil
+}
+
+// SetConnectionDisabled administratively disables or re-enables a connection on the VLAN at the given port
+func (sm *SwitchManager) SetConnectionDisabled(port int, connID string, disabled bool) error {
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+
+	vs, exists := sm.switches[port]
+	if !exists {
+		return fmt.Errorf("VLAN does not exist on port %d", port)
+	}
+
+	return vs.SetConnectionDisabled(connID, disabled)
+}
+
+// SetVLANImpairment sets the impairment of the frames sent by the VLAN at the given port
+func (sm *SwitchManager) SetVLANImpairment(port int, impairment Impairment) error {
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+
+	vs, exists := sm.switches[port]
+	if !exists {
+		return fmt.Errorf("VLAN does not exist on port %d", port)
+	}
+
+	return vs.SetImpairment(impairment)
+}
+
+// SetConnectionImpairment gives a connection on the VLAN at the given port its own impairment, or with nil removes it
+func (sm *SwitchManager) SetConnectionImpairment(port int, connID string, impairment *Impairment) error {
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+
+	vs, exists := sm.switches[port]
+	if !exists {
+		return fmt.Errorf("VLAN does not exist on port %d", port)
+	}
+
+	return vs.SetConnectionImpairment(connID, impairment)
+}
+
+// Partition blackholes the traffic between groups of connections on the VLAN at the given port
+func (sm *SwitchManager) Partition(port int, groups [][]string, duration time.Duration) (Partition, error) {
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+
+	vs, exists := sm.switches[port]
+	if !exists {
+		return Partition{}, fmt.Errorf("VLAN does not exist on port %d", port)
+	}
+
+	return vs.Partition(groups, duration)
+}
+
+// Heal removes a partition of the VLAN at the given port, or all of them if id is ""
+func (sm *SwitchManager) Heal(port int, id string) ([]Partition, error) {
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+
+	vs, exists := sm.switches[port]
+	if !exists {
+		return nil, fmt.Errorf("VLAN does not exist on port %d", port)
+	}
+
+	return vs.Heal(id)
+}
+
+// GetPartitions returns the active partitions of the VLAN at the given port
+func (sm *SwitchManager) GetPartitions(port int) ([]Partition, error) {
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+
+	vs, exists := sm.switches[port]
+	if !exists {
+		return nil, fmt.Errorf("VLAN does not exist on port %d", port)
+	}
+
+	return vs.Partitions(), nil
+}
+
+// SetHairpin enables or disables reflective relay on a connection on the VLAN at the given port
+func (sm *SwitchManager) SetHairpin(port int, connID string, enabled bool) error {
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+
+	vs, exists := sm.switches[port]
+	if !exists {
+		return fmt.Errorf("VLAN does not exist on port %d", port)
+	}
+
+	return vs.SetHairpin(connID, enabled)
+}
+
+// SetTrusted marks a connection on the VLAN at the given port as trusted or untrusted
+func (sm *SwitchManager) SetTrusted(port int, connID string, trusted bool) error {
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+
+	vs, exists := sm.switches[port]
+	if !exists {
+		return fmt.Errorf("VLAN does not exist on port %d", port)
+	}
+
+	return vs.SetTrusted(connID, trusted)
+}
+
+// SetHorizon places a connection on the VLAN at the given port in a split horizon group
+func (sm *SwitchManager) SetHorizon(port int, connID string, group string) error {
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+
+	vs, exists := sm.switches[port]
+	if !exists {
+		return fmt.Errorf("VLAN does not exist on port %d", port)
+	}
+
+	return vs.SetHorizon(connID, group)
+}
+
+// Kick disconnects a connection from the VLAN at the given port and
+// returns the number of MAC entries flushed with it
+func (sm *SwitchManager) Kick(port int, connID string) (int, error) {
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+
+	vs, exists := sm.switches[port]
+	if !exists {
+		return 0, fmt.Errorf("VLAN does not exist on port %d", port)
+	}
+
+	return vs.Kick(connID)
+}
+
+// Attach connects a stream of frames to the VLAN at the given port
+func (sm *SwitchManager) Attach(port int, id string, conn net.Conn) error {
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+
+	vs, exists := sm.switches[port]
+	if !exists {
+		return fmt.Errorf("VLAN does not exist on port %d", port)
+	}
+
+	vs.Attach(id, conn)
+	return nil
+}
+
+// Dial connects an in-process client to the VLAN at the given port and
+// returns its end of the stream
+func (sm *SwitchManager) Dial(port int, id string) (net.Conn, error) {
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+
+	vs, exists := sm.switches[port]
+	if !exists {
+		return nil, fmt.Errorf("VLAN does not exist on port %d", port)
+	}
+
+	return vs.Dial(id), nil
+}
+
+// SetPeerLabel labels the connections from a peer address on the VLAN at
+// the given port
+func (sm *SwitchManager) SetPeerLabel(port int, ip net.IP, label string) error {
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+
+	vs, exists := sm.switches[port]
+	if !exists {
+		return fmt.Errorf("VLAN does not exist on port %d", port)
+	}
+
+	vs.SetPeerLabel(ip, label)
+	return nil
+}
+
+// AddIngressHook appends a hook run on every frame received by the VLAN at
+// the given port
+func (sm *SwitchManager) AddIngressHook(port int, hook FrameHook) error {
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+
+	vs, exists := sm.switches[port]
+	if !exists {
+		return fmt.Errorf("VLAN does not exist on port %d", port)
+	}
+
+	vs.AddIngressHook(hook)
+	return nil
+}
+
+// AddEgressHook appends a hook run on every frame sent by the VLAN at the
+// given port
+func (sm *SwitchManager) AddEgressHook(port int, hook FrameHook) error {
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+
+	vs, exists := sm.switches[port]
+	if !exists {
+		return fmt.Errorf("VLAN does not exist on port %d", port)
+	}
+
+	vs.AddEgressHook(hook)
+	return nil
+}
+
+// RemovePeerLabel forgets the label of a peer address on the VLAN at the
+// given port
+func (sm *SwitchManager) RemovePeerLabel(port int, ip net.IP) error {
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+
+	vs, exists := sm.switches[port]
+	if !exists {
+		return fmt.Errorf("VLAN does not exist on port %d", port)
+	}
+
+	vs.RemovePeerLabel(ip)
+	return nil
+}
+
+// GetPeerLabels returns the labeled peers of the VLAN at the given port
+func (sm *SwitchManager) GetPeerLabels(port int) ([]PeerLabel, error) {
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+
+	vs, exists := sm.switches[port]
+	if !exists {
+		return nil, fmt.Errorf("VLAN does not exist on port %d", port)
+	}
+
+	return vs.PeerLabels(), nil
+}
+
+// StreamCapture writes the frames of the VLAN at the given port to w in
+// pcap format until ctx is done. The VLAN can be removed while streaming.
+func (sm *SwitchManager) StreamCapture(ctx context.Context, port int, w io.Writer) error {
+	sm.mutex.RLock()
+	vs, exists := sm.switches[port]
+	sm.mutex.RUnlock()
+
+	if !exists {
+		return fmt.Errorf("VLAN does not exist on port %d", port)
+	}
+
+	return vs.StreamCapture(ctx, w)
+}
+
+// Replay injects the frames of a pcap file into the VLAN at the given port
+// from a synthetic connection; see VirtualSwitch.Replay
+func (sm *SwitchManager) Replay(ctx context.Context, port int, r io.Reader, speed float64) (ReplayResult, error) {
+	vs := sm.vlan(port)
+	if vs == nil {
+		return ReplayResult{}, fmt.Errorf("VLAN does not exist on port %d", port)
+	}
+
+	return vs.Replay(ctx, r, speed)
+}
+
+// GetDHCPBindings returns the DHCP snooping bindings of the VLAN at the given port
+func (sm *SwitchManager) GetDHCPBindings(port int) ([]DHCPBinding, error) {
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+
+	vs, exists := sm.switches[port]
+	if !exists {
+		return nil, fmt.Errorf("VLAN does not exist on port %d", port)
+	}
+
+	return vs.GetDHCPBindings(), nil
+}
+
+// GetVLANStats returns the statistics of the VLAN at the given port
+func (sm *SwitchManager) GetVLANStats(port int) (map[string]interface{}, error) {
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+
+	vs, exists := sm.switches[port]
+	if !exists {
+		return nil, fmt.Errorf("VLAN does not exist on port %d", port)
+	}
+
+	return vs.GetStats(), nil
+}
+
+// GetConnections returns the connections of the VLAN at the given port
+func (sm *SwitchManager) GetConnections(port int) ([]ConnectionInfo, error) {
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+
+	vs, exists := sm.switches[port]
+	if !exists {
+		return nil, fmt.Errorf("VLAN does not exist on port %d", port)
+	}
+
+	return vs.GetConnections(), nil
+}
+
+// GetMACTable returns the MAC table of the VLAN at the given port
+func (sm *SwitchManager) GetMACTable(port int) ([]MACTableEntry, error) {
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+
+	vs, exists := sm.switches[port]
+	if !exists {
+		return nil, fmt.Errorf("VLAN does not exist on port %d", port)
+	}
+
+	return vs.GetMACTable(), nil
+}
+
+// GetAllMACTables returns the MAC tables of all VLANs sorted by VLAN
+func (sm *SwitchManager) GetAllMACTables() []MACTableEntry {
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+
+	ports := make([]int, 0, len(sm.switches))
+	for port := range sm.switches {
+		ports = append(ports, port)
+	}
+	sort.Ints(ports)
+
+	entries := make([]MACTableEntry, 0)
+	for _, port := range ports {
+		entries = append(entries, sm.switches[port].GetMACTable()...)
+	}
+	return entries
+}
+
+// Health returns the health of all VLANs sorted by port
+func (sm *SwitchManager) Health() []VLANHealth {
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+
+	health := make([]VLANHealth, 0, len(sm.switches))
+	for _, vs := range sm.switches {
+		health = append(health, vs.Health())
+	}
+	sort.Slice(health, func(i, j int) bool {
+		return health[i].Port < health[j].Port
+	})
+	return health
+}
+
 // GetStats returns aggregated statistics from all VLANs
 func (sm *SwitchManager) GetStats() map[string]interface{} {
 	sm.mutex.RLock()
@@ -101,8 +721,26 @@ func (sm *SwitchManager) GetStats() map[string]interface{} {
 	totalBroadcast := uint64(0)
 	totalUnicast := uint64(0)
 	totalDropped := uint64(0)
+	totalSpoofed := uint64(0)
+	totalIsolated := uint64(0)
+	totalHorizonDrops := uint64(0)
+	totalPartitionDrops := uint64(0)
+	totalUnknownUnicastDrops := uint64(0)
+	totalDHCPDrops := uint64(0)
+	totalNDDrops := uint64(0)
+	totalRejected := uint64(0)
+	totalDisabledDrops := uint64(0)
+	totalHookDrops := uint64(0)
+	totalValidationDrops := uint64(0)
+	totalSlowConsumers := uint64(0)
+	totalProxyARPReplies := uint64(0)
+	totalARPSuppressed := uint64(0)
+	totalNATFlows := 0
 	totalConnections := 0
 	totalMACEntries := 0
+	totalDropReasons := make(map[string]uint64, dropReasonCount)
+	totalEtherTypeFrames := make(map[string]uint64, etherTypeClassCount)
+	totalEtherTypeBytes := make(map[string]uint64, etherTypeClassCount)
 
 	vlanStats := make(map[string]interface{})
 
@@ -113,20 +751,110 @@ func (sm *SwitchManager) GetStats() map[string]interface{} {
 		totalBroadcast += stats["broadcast_frames"].(uint64)
 		totalUnicast += stats["unicast_frames"].(uint64)
 		totalDropped += stats["dropped_frames"].(uint64)
+		totalSpoofed += stats["spoofed_frames"].(uint64)
+		totalIsolated += stats["isolated_frames"].(uint64)
+		totalHorizonDrops += stats["horizon_drops"].(uint64)
+		totalPartitionDrops += stats["partition_drops"].(uint64)
+		totalUnknownUnicastDrops += stats["unknown_unicast_drops"].(uint64)
+		totalDHCPDrops += stats["dhcp_drops"].(uint64)
+		totalNDDrops += stats["nd_drops"].(uint64)
+		totalRejected += stats["rejected_connections"].(uint64)
+		totalDisabledDrops += stats["disabled_drops"].(uint64)
+		totalHookDrops += stats["hook_drops"].(uint64)
+		totalValidationDrops += stats["validation_drops"].(uint64)
+		totalSlowConsumers += stats["slow_consumers"].(uint64)
+		totalProxyARPReplies += stats["proxy_arp_replies"].(uint64)
+		totalARPSuppressed += stats["arp_suppressed"].(uint64)
+		totalNATFlows += stats["nat_flows"].(int)
 		totalConnections += stats["connections"].(int)
 		totalMACEntries += stats["mac_entries"].(int)
+		addCounts(totalDropReasons, stats["drop_reasons"])
+		addCounts(totalEtherTypeFrames, stats["ethertype_frames"])
+		addCounts(totalEtherTypeBytes, stats["ethertype_bytes"])
 
 		vlanStats[fmt.Sprintf("vlan_%d", port)] = stats
 	}
 
-	return map[string]interface{}{
-		"total_frames":      totalFrames,
-		"broadcast_frames":  totalBroadcast,
-		"unicast_frames":    totalUnicast,
-		"dropped_frames":    totalDropped,
-		"total_connections": totalConnections,
-		"total_mac_entries": totalMACEntries,
-		"vlans":             vlanStats,
-		"vlan_count":        len(sm.switches),
+	stats := map[string]interface{}{
+		"total_frames":          totalFrames,
+		"broadcast_frames":      totalBroadcast,
+		"unicast_frames":        totalUnicast,
+		"dropped_frames":        totalDropped,
+		"drop_reasons":          totalDropReasons,
+		"ethertype_frames":      totalEtherTypeFrames,
+		"ethertype_bytes":       totalEtherTypeBytes,
+		"spoofed_frames":        totalSpoofed,
+		"isolated_frames":       totalIsolated,
+		"horizon_drops":         totalHorizonDrops,
+		"partition_drops":       totalPartitionDrops,
+		"unknown_unicast_drops": totalUnknownUnicastDrops,
+		"dhcp_drops":            totalDHCPDrops,
+		"nd_drops":              totalNDDrops,
+		"rejected_connections":  totalRejected,
+		"disabled_drops":        totalDisabledDrops,
+		"hook_drops":            totalHookDrops,
+		"validation_drops":      totalValidationDrops,
+		"slow_consumers":        totalSlowConsumers,
+		"proxy_arp_replies":     totalProxyARPReplies,
+		"arp_suppressed":        totalARPSuppressed,
+		"nat_flows":             totalNATFlows,
+		"total_connections":     totalConnections,
+		"total_mac_entries":     totalMACEntries,
+		"vlans":                 vlanStats,
+		"vlan_count":            len(sm.switches),
+		"stats_reset_at":        sm.statsResetAt,
+	}
+	if sm.instance != "" {
+		stats["instance"] = sm.instance
+	}
+	return stats
+}
+
+// ResetStats zeroes the statistics of every VLAN at once: readers of the
+// global statistics never see some VLANs reset and others not. It returns
+// the time of the reset.
+func (sm *SwitchManager) ResetStats() time.Time {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	sm.statsResetAt = time.Now()
+	for _, vs := range sm.switches {
+		vs.ResetStats()
+	}
+	return sm.statsResetAt
+}
+
+// ResetVLANStats zeroes the statistics of the VLAN at the given port and
+// returns the time of the reset
+func (sm *SwitchManager) ResetVLANStats(port int) (time.Time, error) {
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+
+	vs, exists := sm.switches[port]
+	if !exists {
+		return time.Time{}, fmt.Errorf("VLAN does not exist on port %d", port)
+	}
+
+	return vs.ResetStats(), nil
+}
+
+// ResetConnectionStats zeroes the statistics of a connection of the VLAN at
+// the given port and returns the time of the reset
+func (sm *SwitchManager) ResetConnectionStats(port int, connID string) (time.Time, error) {
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+
+	vs, exists := sm.switches[port]
+	if !exists {
+		return time.Time{}, fmt.Errorf("VLAN does not exist on port %d", port)
+	}
+
+	return vs.ResetConnectionStats(connID)
+}
+
+// addCounts adds the counts by category of a VLAN to the totals
+func addCounts(totals map[string]uint64, counts interface{}) {
+	for category, count := range counts.(map[string]uint64) {
+		totals[category] += count
 	}
 }
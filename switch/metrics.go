@@ -0,0 +1,167 @@
+package vswitch
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+)
+
+// labeledMetrics maps the statistics counting by category to the metric
+// they are exported as and the label naming the category
+var labeledMetrics = map[string]struct{ name, label string }{
+	"drop_reasons":     {"dropped_frames_by_reason", "reason"},
+	"ethertype_frames": {"frames_by_ethertype", "ethertype"},
+	"ethertype_bytes":  {"bytes_by_ethertype", "ethertype"},
+}
+
+// writeMetrics writes the per-VLAN statistics in the Prometheus text
+// exposition format. Numeric statistics become untyped metrics named after
+// their key, and counts by category metrics with a label for the category;
+// the forwarding latency and frame sizes are histograms. VLANs sampling
+// frames for a protocol breakdown also export it.
+func writeMetrics(w io.Writer, sm *SwitchManager) {
+	ports := sm.GetVLANs()
+	sort.Ints(ports)
+
+	stats := make(map[int]map[string]interface{}, len(ports))
+	for _, port := range ports {
+		if vlanStats, err := sm.GetVLANStats(port); err == nil {
+			stats[port] = vlanStats
+		}
+	}
+
+	// Group the samples of each metric as the format requires
+	keySet := make(map[string]bool)
+	for _, vlanStats := range stats {
+		for key, value := range vlanStats {
+			if _, ok := metricValue(value); ok {
+				keySet[key] = true
+			}
+		}
+	}
+	keys := make([]string, 0, len(keySet))
+	for key := range keySet {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		fmt.Fprintf(w, "# TYPE vswitch_%s untyped\n", key)
+		for _, port := range ports {
+			if value, ok := metricValue(stats[port][key]); ok {
+				fmt.Fprintf(w, "vswitch_%s{vlan=\"%d\"} %s\n", key, port, value)
+			}
+		}
+	}
+
+	labeledKeys := make([]string, 0, len(labeledMetrics))
+	for key := range labeledMetrics {
+		labeledKeys = append(labeledKeys, key)
+	}
+	sort.Strings(labeledKeys)
+
+	for _, key := range labeledKeys {
+		metric := labeledMetrics[key]
+		writeLabeledMetric(w, "vswitch_"+metric.name, metric.label, ports, func(port int) map[string]uint64 {
+			counts, _ := stats[port][key].(map[string]uint64)
+			return counts
+		})
+	}
+
+	fmt.Fprintln(w, "# HELP vswitch_forwarding_latency_seconds Time from reading a frame until it is queued for its destinations")
+	fmt.Fprintln(w, "# TYPE vswitch_forwarding_latency_seconds histogram")
+	for _, port := range ports {
+		if latency, ok := stats[port]["forwarding_latency"].(LatencyHistogram); ok {
+			writeHistogram(w, "vswitch_forwarding_latency_seconds", port, latency.Buckets, latency.Count, latency.SumSeconds)
+		}
+	}
+
+	fmt.Fprintln(w, "# HELP vswitch_delivery_latency_seconds Time from reading a frame until it is written to a destination")
+	fmt.Fprintln(w, "# TYPE vswitch_delivery_latency_seconds histogram")
+	for _, port := range ports {
+		if latency, ok := stats[port]["delivery_latency"].(LatencyHistogram); ok {
+			writeHistogram(w, "vswitch_delivery_latency_seconds", port, latency.Buckets, latency.Count, latency.SumSeconds)
+		}
+	}
+
+	fmt.Fprintln(w, "# HELP vswitch_frame_size_bytes Size of the frames received, including the Ethernet checksum")
+	fmt.Fprintln(w, "# TYPE vswitch_frame_size_bytes histogram")
+	for _, port := range ports {
+		if sizes, ok := stats[port]["frame_sizes"].(SizeHistogram); ok {
+			writeHistogram(w, "vswitch_frame_size_bytes", port, sizes.Buckets, sizes.Count, float64(sizes.SumBytes))
+		}
+	}
+
+	// The protocol breakdown only exists on VLANs sampling frames for it
+	protocols := make(map[int]ProtocolStats)
+	for _, port := range ports {
+		if breakdown, ok := stats[port]["protocol_stats"].(ProtocolStats); ok {
+			protocols[port] = breakdown
+		}
+	}
+	if len(protocols) == 0 {
+		return
+	}
+	fmt.Fprintln(w, "# TYPE vswitch_protocol_sample_rate untyped")
+	for _, port := range ports {
+		if breakdown, ok := protocols[port]; ok {
+			fmt.Fprintf(w, "vswitch_protocol_sample_rate{vlan=\"%d\"} %d\n", port, breakdown.SampleRate)
+		}
+	}
+	writeLabeledMetric(w, "vswitch_protocol_sampled_frames", "protocol", ports, func(port int) map[string]uint64 {
+		return protocols[port].IPProtocols
+	})
+	writeLabeledMetric(w, "vswitch_protocol_sampled_port_frames", "port", ports, func(port int) map[string]uint64 {
+		counts := make(map[string]uint64)
+		for _, top := range protocols[port].TopPorts {
+			counts[top.Port] = top.Frames
+		}
+		return counts
+	})
+	writeLabeledMetric(w, "vswitch_protocol_sampled_dns_queries", "type", ports, func(port int) map[string]uint64 {
+		return protocols[port].DNSQueries
+	})
+}
+
+// writeLabeledMetric writes a metric counting by category, with a sample
+// for each category in the counts of each VLAN
+func writeLabeledMetric(w io.Writer, name, label string, ports []int, counts func(port int) map[string]uint64) {
+	fmt.Fprintf(w, "# TYPE %s untyped\n", name)
+	for _, port := range ports {
+		vlanCounts := counts(port)
+		categories := make([]string, 0, len(vlanCounts))
+		for category := range vlanCounts {
+			categories = append(categories, category)
+		}
+		sort.Strings(categories)
+		for _, category := range categories {
+			fmt.Fprintf(w, "%s{vlan=\"%d\",%s=\"%s\"} %d\n", name, port, label, category, vlanCounts[category])
+		}
+	}
+}
+
+// writeHistogram writes the samples of a VLAN's histogram
+func writeHistogram(w io.Writer, name string, port int, buckets []HistogramBucket, count uint64, sum float64) {
+	for _, bucket := range buckets {
+		fmt.Fprintf(w, "%s_bucket{vlan=\"%d\",le=\"%s\"} %d\n",
+			name, port, strconv.FormatFloat(bucket.UpperBound, 'g', -1, 64), bucket.Count)
+	}
+	fmt.Fprintf(w, "%s_bucket{vlan=\"%d\",le=\"+Inf\"} %d\n", name, port, count)
+	fmt.Fprintf(w, "%s_sum{vlan=\"%d\"} %s\n", name, port, strconv.FormatFloat(sum, 'g', -1, 64))
+	fmt.Fprintf(w, "%s_count{vlan=\"%d\"} %d\n", name, port, count)
+}
+
+// metricValue formats a numeric statistic as a sample value
+func metricValue(value interface{}) (string, bool) {
+	switch v := value.(type) {
+	case uint64:
+		return strconv.FormatUint(v, 10), true
+	case int:
+		return strconv.Itoa(v), true
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64), true
+	default:
+		return "", false
+	}
+}
@@ -0,0 +1,439 @@
+package vswitch
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// natMaxFlows bounds the number of TCP and UDP flows per VLAN
+	natMaxFlows = 4096
+
+	// natUDPTimeout is how long an idle UDP flow is kept
+	natUDPTimeout = 60 * time.Second
+
+	// natResolveTimeout bounds ARP resolution of port forward targets
+	natResolveTimeout = time.Second
+
+	// natTickInterval is how often flows are retransmitted and expired
+	natTickInterval = 250 * time.Millisecond
+
+	// natFirstPort is the start of the gateway port range used for port forwards
+	natFirstPort = 49152
+)
+
+// natFlowKey identifies a flow by the guest's endpoint and the remote
+// endpoint as seen by the guest
+type natFlowKey struct {
+	guestIP    [4]byte
+	guestPort  uint16
+	remoteIP   [4]byte
+	remotePort uint16
+}
+
+// newNATFlowKey builds a flow key from the guest and remote endpoints
+func newNATFlowKey(guestIP net.IP, guestPort uint16, remoteIP net.IP, remotePort uint16) natFlowKey {
+	key := natFlowKey{guestPort: guestPort, remotePort: remotePort}
+	copy(key.guestIP[:], guestIP.To4())
+	copy(key.remoteIP[:], remoteIP.To4())
+	return key
+}
+
+// guest returns the guest IP of the flow
+func (k natFlowKey) guest() net.IP {
+	return net.IPv4(k.guestIP[0], k.guestIP[1], k.guestIP[2], k.guestIP[3]).To4()
+}
+
+// remote returns the remote IP of the flow
+func (k natFlowKey) remote() net.IP {
+	return net.IPv4(k.remoteIP[0], k.remoteIP[1], k.remoteIP[2], k.remoteIP[3]).To4()
+}
+
+// natUDPFlow relays datagrams between a guest endpoint and a host socket
+type natUDPFlow struct {
+	key        natFlowKey
+	guestMAC   net.HardwareAddr
+	conn       *net.UDPConn
+	dest       *net.UDPAddr
+	inbound    bool   // Created by a port forward; conn is the shared listener
+	peerKey    string // Index of inbound flows in natEngine.udpPeers
+	lastActive time.Time
+}
+
+// natEngine is a user-mode NAT gateway. Guests route through the host
+// stack's gateway address; their TCP connections are terminated by the
+// switch and re-originated from host sockets, and UDP datagrams are relayed
+// through per-flow host sockets.
+type natEngine struct {
+	vs            *VirtualSwitch
+	stack         *hostStack
+	gateway       net.IP
+	allowLoopback bool
+	forwards      []PortForward
+	listeners     []interface{ Close() error }
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mutex    sync.Mutex
+	tcp      map[natFlowKey]*natTCPConn
+	udp      map[natFlowKey]*natUDPFlow
+	udpPeers map[string]*natUDPFlow
+	nextPort uint16
+}
+
+// newNATEngine creates a NAT gateway on the host stack
+func newNATEngine(vs *VirtualSwitch, stack *hostStack, cfg *NATConfig) *natEngine {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	n := &natEngine{
+		vs:            vs,
+		stack:         stack,
+		gateway:       cfg.Gateway.To4(),
+		allowLoopback: cfg.AllowLoopback,
+		forwards:      cfg.PortForwards,
+		ctx:           ctx,
+		cancel:        cancel,
+		tcp:           make(map[natFlowKey]*natTCPConn),
+		udp:           make(map[natFlowKey]*natUDPFlow),
+		udpPeers:      make(map[string]*natUDPFlow),
+		nextPort:      natFirstPort,
+	}
+
+	stack.addAddress(n.gateway)
+	stack.setRouter(n)
+
+	return n
+}
+
+// handleFrame lets the host stack receive frames for the gateway
+func (n *natEngine) handleFrame(_ *EthernetFrame, _ *Connection) bool {
+	return false
+}
+
+// listen opens the host sockets of the port forwards
+func (n *natEngine) listen() {
+	for _, fwd := range n.forwards {
+		switch fwd.Protocol {
+		case "tcp":
+			listener, err := Listen("tcp", fwd.HostAddr)
+			if err != nil {
+				n.vs.logger.Error("NAT port forward failed", "protocol", fwd.Protocol, "addr", fwd.HostAddr, "error", err)
+				continue
+			}
+			n.listeners = append(n.listeners, listener)
+			n.wg.Add(1)
+			go n.acceptTCP(listener, fwd)
+		case "udp":
+			conn, err := listenUDP("udp4", fwd.HostAddr)
+			if err != nil {
+				n.vs.logger.Error("NAT port forward failed", "protocol", fwd.Protocol, "addr", fwd.HostAddr, "error", err)
+				continue
+			}
+			n.listeners = append(n.listeners, conn)
+			n.wg.Add(1)
+			go n.forwardUDP(conn, fwd)
+		}
+	}
+}
+
+// run services flow timers until shutdown
+func (n *natEngine) run(shutdown <-chan bool) {
+	ticker := time.NewTicker(natTickInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-shutdown:
+			n.cancel()
+			for _, listener := range n.listeners {
+				_ = listener.Close()
+			}
+			n.closeAll()
+			n.wg.Wait()
+			return
+		case now := <-ticker.C:
+			n.tick(now)
+		}
+	}
+}
+
+// routePacket relays a packet sent by a guest to the gateway
+func (n *natEngine) routePacket(srcMAC net.HardwareAddr, packet *ipv4Packet) {
+	switch packet.Protocol {
+	case ipProtoTCP:
+		if ipv4Checksum(packet.Src, packet.Dst, ipProtoTCP, packet.Payload) != 0 {
+			return
+		}
+		seg, err := parseTCP(packet.Payload)
+		if err != nil {
+			return
+		}
+		n.handleTCP(srcMAC, packet, seg)
+	case ipProtoUDP:
+		udp, err := parseUDP(packet.Payload)
+		if err != nil {
+			return
+		}
+		n.handleUDP(srcMAC, packet, udp)
+	}
+}
+
+// hostAddress maps a destination chosen by a guest to the address dialed on
+// the host. Loopback, multicast and broadcast destinations are refused, and
+// the gateway itself maps to the host's loopback only if allowed.
+func (n *natEngine) hostAddress(dst net.IP) (net.IP, bool) {
+	if dst.Equal(n.gateway) {
+		if n.allowLoopback {
+			return net.IPv4(127, 0, 0, 1), true
+		}
+		return nil, false
+	}
+
+	if dst.IsLoopback() || dst.IsUnspecified() || dst.IsMulticast() || dst.Equal(net.IPv4bcast) {
+		return nil, false
+	}
+
+	return append(net.IP{}, dst.To4()...), true
+}
+
+// handleUDP relays a datagram from a guest through the flow's host socket
+func (n *natEngine) handleUDP(srcMAC net.HardwareAddr, packet *ipv4Packet, udp *udpDatagram) {
+	key := newNATFlowKey(packet.Src, udp.SrcPort, packet.Dst, udp.DstPort)
+
+	n.mutex.Lock()
+	flow, found := n.udp[key]
+	if !found {
+		hostIP, ok := n.hostAddress(packet.Dst)
+		if !ok || n.flowCount() >= natMaxFlows {
+			n.mutex.Unlock()
+			return
+		}
+
+		conn, err := net.ListenUDP("udp4", nil)
+		if err != nil {
+			n.mutex.Unlock()
+			n.vs.logger.Warn("NAT failed to open UDP socket", "error", err)
+			return
+		}
+
+		flow = &natUDPFlow{
+			key:      key,
+			guestMAC: append(net.HardwareAddr{}, srcMAC...),
+			conn:     conn,
+			dest:     &net.UDPAddr{IP: hostIP, Port: int(udp.DstPort)},
+		}
+		n.udp[key] = flow
+
+		n.wg.Add(1)
+		go n.relayUDP(flow)
+	}
+	flow.lastActive = time.Now()
+	n.mutex.Unlock()
+
+	if _, err := flow.conn.WriteToUDP(udp.Payload, flow.dest); err != nil {
+		n.vs.logger.Debug("NAT failed to send UDP", "dest", flow.dest.String(), "error", err)
+	}
+}
+
+// relayUDP delivers replies from the flow's remote endpoint to the guest
+func (n *natEngine) relayUDP(flow *natUDPFlow) {
+	defer n.wg.Done()
+
+	buf := make([]byte, 65535)
+	for {
+		nr, addr, err := flow.conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+
+		// Only the endpoint the guest sent to may answer through the flow
+		if !addr.IP.Equal(flow.dest.IP) || addr.Port != flow.dest.Port {
+			continue
+		}
+
+		n.mutex.Lock()
+		flow.lastActive = time.Now()
+		n.mutex.Unlock()
+
+		n.stack.sendUDP(flow.guestMAC, flow.key.remote(), flow.key.guest(),
+			flow.key.remotePort, flow.key.guestPort, buf[:nr])
+	}
+}
+
+// forwardUDP relays datagrams received on a port forward to the guest,
+// using a separate gateway port for each host peer so replies can be
+// matched back to it
+func (n *natEngine) forwardUDP(conn *net.UDPConn, fwd PortForward) {
+	defer n.wg.Done()
+
+	buf := make([]byte, 65535)
+	for {
+		nr, addr, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+
+		flow := n.inboundUDPFlow(conn, addr, fwd)
+		if flow == nil {
+			continue
+		}
+
+		n.stack.sendUDP(flow.guestMAC, n.gateway, flow.key.guest(),
+			flow.key.remotePort, flow.key.guestPort, buf[:nr])
+	}
+}
+
+// inboundUDPFlow returns the flow for a host peer of a UDP port forward,
+// creating it if needed
+func (n *natEngine) inboundUDPFlow(conn *net.UDPConn, peer *net.UDPAddr, fwd PortForward) *natUDPFlow {
+	peerKey := conn.LocalAddr().String() + "/" + peer.String()
+
+	n.mutex.Lock()
+	if flow, found := n.udpPeers[peerKey]; found {
+		flow.lastActive = time.Now()
+		n.mutex.Unlock()
+		return flow
+	}
+	n.mutex.Unlock()
+
+	guestMAC := n.stack.resolve(fwd.GuestIP, natResolveTimeout)
+	if guestMAC == nil {
+		n.vs.logger.Warn("NAT port forward guest did not answer ARP", "protocol", fwd.Protocol, "addr", fwd.HostAddr, "ip", fwd.GuestIP.String())
+		return nil
+	}
+
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	if flow, found := n.udpPeers[peerKey]; found {
+		return flow
+	}
+	if n.flowCount() >= natMaxFlows {
+		return nil
+	}
+
+	key, ok := n.allocateKey(fwd.GuestIP, fwd.GuestPort, n.udpKeyInUse)
+	if !ok {
+		return nil
+	}
+
+	flow := &natUDPFlow{
+		key:        key,
+		guestMAC:   guestMAC,
+		conn:       conn,
+		dest:       peer,
+		inbound:    true,
+		peerKey:    peerKey,
+		lastActive: time.Now(),
+	}
+	n.udp[key] = flow
+	n.udpPeers[peerKey] = flow
+
+	return flow
+}
+
+// udpKeyInUse reports whether a UDP flow exists; the mutex must be held
+func (n *natEngine) udpKeyInUse(key natFlowKey) bool {
+	_, found := n.udp[key]
+	return found
+}
+
+// tcpKeyInUse reports whether a TCP flow exists; the mutex must be held
+func (n *natEngine) tcpKeyInUse(key natFlowKey) bool {
+	_, found := n.tcp[key]
+	return found
+}
+
+// allocateKey picks a free gateway port for a flow towards a guest
+// endpoint. The mutex must be held.
+func (n *natEngine) allocateKey(guestIP net.IP, guestPort uint16, inUse func(natFlowKey) bool) (natFlowKey, bool) {
+	for i := 0; i < 65536-natFirstPort; i++ {
+		port := n.nextPort
+		n.nextPort++
+		if n.nextPort < natFirstPort {
+			n.nextPort = natFirstPort
+		}
+
+		key := newNATFlowKey(guestIP, guestPort, n.gateway, port)
+		if !inUse(key) {
+			return key, true
+		}
+	}
+	return natFlowKey{}, false
+}
+
+// flowCount returns the number of active flows; the mutex must be held
+func (n *natEngine) flowCount() int {
+	return len(n.tcp) + len(n.udp)
+}
+
+// flows returns the number of active flows
+func (n *natEngine) flows() int {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	return n.flowCount()
+}
+
+// removeUDP deletes a UDP flow and closes its socket; the mutex must be held
+func (n *natEngine) removeUDP(flow *natUDPFlow) {
+	delete(n.udp, flow.key)
+	if flow.inbound {
+		delete(n.udpPeers, flow.peerKey)
+	} else {
+		_ = flow.conn.Close()
+	}
+}
+
+// tick retransmits unacknowledged TCP data and expires idle flows
+func (n *natEngine) tick(now time.Time) {
+	n.mutex.Lock()
+	conns := make([]*natTCPConn, 0, len(n.tcp))
+	for _, conn := range n.tcp {
+		conns = append(conns, conn)
+	}
+	for _, flow := range n.udp {
+		if now.Sub(flow.lastActive) > natUDPTimeout {
+			n.removeUDP(flow)
+		}
+	}
+	n.mutex.Unlock()
+
+	for _, conn := range conns {
+		conn.tick(now)
+	}
+}
+
+// closeAll aborts every flow
+func (n *natEngine) closeAll() {
+	n.mutex.Lock()
+	conns := make([]*natTCPConn, 0, len(n.tcp))
+	for _, conn := range n.tcp {
+		conns = append(conns, conn)
+	}
+	for _, flow := range n.udp {
+		n.removeUDP(flow)
+	}
+	n.mutex.Unlock()
+
+	for _, conn := range conns {
+		conn.mutex.Lock()
+		conn.reset()
+		conn.mutex.Unlock()
+	}
+}
+
+// String describes the gateway for logging
+func (n *natEngine) String() string {
+	forwards := make([]string, 0, len(n.forwards))
+	for _, fwd := range n.forwards {
+		forwards = append(forwards, fmt.Sprintf("%s %s->%s",
+			fwd.Protocol, fwd.HostAddr, net.JoinHostPort(fwd.GuestIP.String(), strconv.Itoa(int(fwd.GuestPort)))))
+	}
+	return fmt.Sprintf("gateway %s, forwards %v", n.gateway, forwards)
+}
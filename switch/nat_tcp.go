@@ -0,0 +1,557 @@
+package vswitch
+
+import (
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// natMSS is the segment size the gateway advertises and assumes
+	natMSS = 1460
+
+	// natDefaultMSS is used when a guest does not advertise an MSS
+	natDefaultMSS = 536
+
+	// natWindow is the receive window advertised to guests
+	natWindow = 65535
+
+	// natWriteQueue bounds the guest segments queued for the host socket
+	natWriteQueue = 64
+
+	// natDialTimeout bounds outbound connection attempts
+	natDialTimeout = 10 * time.Second
+
+	// natRetransmitTimeout is how long unacknowledged segments wait before
+	// being sent again
+	natRetransmitTimeout = time.Second
+
+	// natMaxRetransmits is how many retransmissions are attempted before
+	// the connection is reset
+	natMaxRetransmits = 8
+
+	// natTCPIdleTimeout is how long a connection may go without guest traffic
+	natTCPIdleTimeout = 2 * time.Hour
+)
+
+// natTCPState is the state of a NAT TCP connection
+type natTCPState int
+
+const (
+	natTCPDialing     natTCPState = iota // Guest SYN received, host connection in progress
+	natTCPSynReceived                    // SYN-ACK sent to the guest
+	natTCPSynSent                        // SYN sent to the guest for a port forward
+	natTCPEstablished
+	natTCPClosed
+)
+
+// natTCPConn terminates a guest TCP connection and relays its byte stream
+// to a host socket. Only in-order guest segments are accepted; the guest
+// retransmits anything that is dropped.
+type natTCPConn struct {
+	nat      *natEngine
+	key      natFlowKey
+	guestMAC net.HardwareAddr
+	host     net.Conn
+
+	mutex sync.Mutex
+	cond  *sync.Cond
+	state natTCPState
+
+	// Stream from the host to the guest
+	iss     uint32
+	sndUna  uint32
+	sndNxt  uint32
+	sndWnd  uint32
+	mss     int
+	unacked []byte
+	finSent bool
+
+	// Stream from the guest to the host
+	rcvNxt      uint32
+	guestFIN    bool
+	writeQ      chan []byte
+	writeClosed bool
+
+	readerDone bool
+	writerDone bool
+	lastSend   time.Time
+	lastActive time.Time
+	retries    int
+}
+
+// newNATTCPConn creates a connection; the caller sets its state
+func newNATTCPConn(n *natEngine, key natFlowKey, guestMAC net.HardwareAddr) *natTCPConn {
+	iss := rand.Uint32()
+	c := &natTCPConn{
+		nat:        n,
+		key:        key,
+		guestMAC:   append(net.HardwareAddr{}, guestMAC...),
+		iss:        iss,
+		sndUna:     iss,
+		sndNxt:     iss,
+		mss:        natDefaultMSS,
+		writeQ:     make(chan []byte, natWriteQueue),
+		lastActive: time.Now(),
+	}
+	c.cond = sync.NewCond(&c.mutex)
+	return c
+}
+
+// seqLess reports whether sequence number a precedes b
+func seqLess(a, b uint32) bool {
+	return int32(a-b) < 0
+}
+
+// handleTCP processes a segment sent by a guest through the gateway
+func (n *natEngine) handleTCP(srcMAC net.HardwareAddr, packet *ipv4Packet, seg *tcpSegment) {
+	key := newNATFlowKey(packet.Src, seg.SrcPort, packet.Dst, seg.DstPort)
+
+	n.mutex.Lock()
+	c, found := n.tcp[key]
+	if !found && seg.Flags&(tcpSYN|tcpACK|tcpRST) == tcpSYN {
+		hostIP, ok := n.hostAddress(packet.Dst)
+		if !ok || n.flowCount() >= natMaxFlows {
+			n.mutex.Unlock()
+			n.sendReset(srcMAC, packet, seg)
+			return
+		}
+
+		c = newNATTCPConn(n, key, srcMAC)
+		c.state = natTCPDialing
+		c.rcvNxt = seg.Seq + 1
+		c.sndWnd = uint32(seg.Window)
+		if seg.MSS != 0 {
+			c.mss = min(int(seg.MSS), natMSS)
+		}
+		n.tcp[key] = c
+
+		n.wg.Add(1)
+		go c.dial(net.JoinHostPort(hostIP.String(), strconv.Itoa(int(seg.DstPort))))
+		n.mutex.Unlock()
+		return
+	}
+	n.mutex.Unlock()
+
+	if !found {
+		if seg.Flags&tcpRST == 0 {
+			n.sendReset(srcMAC, packet, seg)
+		}
+		return
+	}
+
+	c.mutex.Lock()
+	c.handleSegment(seg)
+	c.mutex.Unlock()
+}
+
+// sendReset answers a segment that does not belong to any connection
+func (n *natEngine) sendReset(dstMAC net.HardwareAddr, packet *ipv4Packet, seg *tcpSegment) {
+	reply := &tcpSegment{SrcPort: seg.DstPort, DstPort: seg.SrcPort, Flags: tcpRST}
+	if seg.Flags&tcpACK != 0 {
+		reply.Seq = seg.Ack
+	} else {
+		reply.Flags |= tcpACK
+		reply.Ack = seg.Seq + uint32(len(seg.Payload))
+		if seg.Flags&tcpSYN != 0 {
+			reply.Ack++
+		}
+		if seg.Flags&tcpFIN != 0 {
+			reply.Ack++
+		}
+	}
+
+	n.vs.injectFrame(buildEthernet(dstMAC, n.stack.mac, EtherTypeIPv4, buildTCPv4(packet.Dst, packet.Src, reply)))
+}
+
+// acceptTCP opens a guest connection for each client of a port forward
+func (n *natEngine) acceptTCP(listener net.Listener, fwd PortForward) {
+	defer n.wg.Done()
+
+	for {
+		host, err := listener.Accept()
+		if err != nil {
+			return
+		}
+
+		n.wg.Add(1)
+		go n.connectGuest(host, fwd)
+	}
+}
+
+// connectGuest actively opens a connection to the guest of a port forward
+// and relays the accepted host connection over it
+func (n *natEngine) connectGuest(host net.Conn, fwd PortForward) {
+	defer n.wg.Done()
+
+	guestMAC := n.stack.resolve(fwd.GuestIP, natResolveTimeout)
+	if guestMAC == nil {
+		n.vs.logger.Warn("NAT port forward guest did not answer ARP", "protocol", fwd.Protocol, "addr", fwd.HostAddr, "ip", fwd.GuestIP.String())
+		_ = host.Close()
+		return
+	}
+
+	n.mutex.Lock()
+	if n.ctx.Err() != nil || n.flowCount() >= natMaxFlows {
+		n.mutex.Unlock()
+		_ = host.Close()
+		return
+	}
+	key, ok := n.allocateKey(fwd.GuestIP, fwd.GuestPort, n.tcpKeyInUse)
+	if !ok {
+		n.mutex.Unlock()
+		_ = host.Close()
+		return
+	}
+	c := newNATTCPConn(n, key, guestMAC)
+	c.host = host
+	c.state = natTCPSynSent
+	n.tcp[key] = c
+	n.mutex.Unlock()
+
+	c.mutex.Lock()
+	c.sendSYN()
+	c.mutex.Unlock()
+}
+
+// dial connects to the host destination of a guest connection
+func (c *natTCPConn) dial(addr string) {
+	defer c.nat.wg.Done()
+
+	dialer := net.Dialer{Timeout: natDialTimeout}
+	host, err := dialer.DialContext(c.nat.ctx, "tcp4", addr)
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.state != natTCPDialing {
+		if host != nil {
+			_ = host.Close()
+		}
+		return
+	}
+
+	if err != nil {
+		c.reset()
+		return
+	}
+
+	c.host = host
+	c.state = natTCPSynReceived
+	c.sendSYN()
+}
+
+// sendSYN sends the connection's SYN or SYN-ACK; the mutex must be held
+func (c *natTCPConn) sendSYN() {
+	flags := uint8(tcpSYN)
+	if c.state == natTCPSynReceived {
+		flags |= tcpACK
+	}
+	c.send(&tcpSegment{Seq: c.iss, Ack: c.rcvNxt, Flags: flags, MSS: natMSS})
+	c.sndNxt = c.iss + 1
+	c.lastSend = time.Now()
+}
+
+// send transmits a segment to the guest; the mutex must be held
+func (c *natTCPConn) send(seg *tcpSegment) {
+	seg.SrcPort = c.key.remotePort
+	seg.DstPort = c.key.guestPort
+	seg.Window = natWindow
+	if seg.Flags&tcpSYN == 0 {
+		seg.Flags |= tcpACK
+		seg.Ack = c.rcvNxt
+	}
+
+	c.nat.vs.injectFrame(buildEthernet(c.guestMAC, c.nat.stack.mac, EtherTypeIPv4,
+		buildTCPv4(c.key.remote(), c.key.guest(), seg)))
+}
+
+// sendAck acknowledges everything received so far; the mutex must be held
+func (c *natTCPConn) sendAck() {
+	c.send(&tcpSegment{Seq: c.sndNxt})
+}
+
+// handleSegment processes a segment from the guest; the mutex must be held
+func (c *natTCPConn) handleSegment(seg *tcpSegment) {
+	if c.state == natTCPClosed || c.state == natTCPDialing {
+		return // Retransmitted SYNs are ignored until the host answers
+	}
+	c.lastActive = time.Now()
+
+	if seg.Flags&tcpRST != 0 {
+		if c.state == natTCPSynSent {
+			if seg.Flags&tcpACK != 0 && seg.Ack == c.iss+1 {
+				c.close(false)
+			}
+		} else if seg.Seq-c.rcvNxt < natWindow {
+			c.close(false)
+		}
+		return
+	}
+
+	switch c.state {
+	case natTCPSynSent:
+		if seg.Flags&(tcpSYN|tcpACK) != tcpSYN|tcpACK || seg.Ack != c.iss+1 {
+			return
+		}
+		c.rcvNxt = seg.Seq + 1
+		if seg.MSS != 0 {
+			c.mss = min(int(seg.MSS), natMSS)
+		}
+		c.establish(seg)
+		c.sendAck()
+		return
+	case natTCPSynReceived:
+		if seg.Flags&tcpSYN != 0 {
+			c.sendSYN()
+			return
+		}
+		if seg.Flags&tcpACK == 0 || seg.Ack != c.iss+1 {
+			return
+		}
+		c.establish(seg)
+	default:
+		if seg.Flags&tcpSYN != 0 {
+			c.sendAck()
+			return
+		}
+		if seg.Flags&tcpACK != 0 {
+			c.processAck(seg)
+		}
+	}
+
+	c.processData(seg)
+	c.maybeFinish()
+}
+
+// establish completes the handshake and starts relaying; the mutex must be held
+func (c *natTCPConn) establish(seg *tcpSegment) {
+	c.state = natTCPEstablished
+	c.sndUna = seg.Ack
+	c.sndWnd = uint32(seg.Window)
+	c.retries = 0
+
+	c.nat.wg.Add(2)
+	go c.hostReader()
+	go c.hostWriter()
+}
+
+// processAck releases acknowledged data; the mutex must be held
+func (c *natTCPConn) processAck(seg *tcpSegment) {
+	if seqLess(seg.Ack, c.sndUna) || seqLess(c.sndNxt, seg.Ack) {
+		return
+	}
+
+	acked := int(seg.Ack - c.sndUna)
+	if acked > len(c.unacked) {
+		acked = len(c.unacked) // The remainder acknowledges our FIN
+	}
+	c.unacked = c.unacked[acked:]
+	if seg.Ack != c.sndUna {
+		c.retries = 0
+		c.lastSend = time.Now()
+	}
+	c.sndUna = seg.Ack
+	c.sndWnd = uint32(seg.Window)
+	c.cond.Broadcast()
+}
+
+// processData queues in-order guest data for the host; the mutex must be held
+func (c *natTCPConn) processData(seg *tcpSegment) {
+	fin := seg.Flags&tcpFIN != 0
+	if len(seg.Payload) == 0 && !fin {
+		return
+	}
+
+	if seg.Seq != c.rcvNxt || c.guestFIN {
+		c.sendAck() // Duplicate or out of order; let the guest retransmit
+		return
+	}
+
+	if len(seg.Payload) > 0 {
+		select {
+		case c.writeQ <- append([]byte{}, seg.Payload...):
+			c.rcvNxt += uint32(len(seg.Payload))
+		default:
+			return // The host is not keeping up; the guest will retransmit
+		}
+	}
+
+	if fin {
+		c.guestFIN = true
+		c.rcvNxt++
+		c.closeWriteQ()
+	}
+
+	c.sendAck()
+}
+
+// maybeFinish removes the connection once both directions have closed
+// cleanly; the mutex must be held
+func (c *natTCPConn) maybeFinish() {
+	if c.guestFIN && c.finSent && c.sndUna == c.sndNxt {
+		c.close(true)
+	}
+}
+
+// hostReader relays data from the host socket to the guest within the
+// guest's receive window
+func (c *natTCPConn) hostReader() {
+	defer c.nat.wg.Done()
+
+	buf := make([]byte, natMSS)
+	for {
+		c.mutex.Lock()
+		for c.state == natTCPEstablished && c.sndNxt-c.sndUna >= c.sndWnd {
+			c.cond.Wait()
+		}
+		if c.state != natTCPEstablished {
+			c.mutex.Unlock()
+			return
+		}
+		room := min(int(c.sndWnd-(c.sndNxt-c.sndUna)), c.mss)
+		c.mutex.Unlock()
+
+		nr, err := c.host.Read(buf[:room])
+
+		c.mutex.Lock()
+		if c.state != natTCPEstablished {
+			c.mutex.Unlock()
+			return
+		}
+		if nr > 0 {
+			c.send(&tcpSegment{Seq: c.sndNxt, Flags: tcpPSH, Payload: buf[:nr]})
+			c.unacked = append(c.unacked, buf[:nr]...)
+			c.sndNxt += uint32(nr)
+			c.lastSend = time.Now()
+		}
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				c.send(&tcpSegment{Seq: c.sndNxt, Flags: tcpFIN})
+				c.sndNxt++
+				c.finSent = true
+				c.lastSend = time.Now()
+				c.readerDone = true
+				if c.writerDone {
+					_ = c.host.Close()
+				}
+				c.maybeFinish()
+			} else {
+				c.reset()
+			}
+			c.mutex.Unlock()
+			return
+		}
+		c.mutex.Unlock()
+	}
+}
+
+// hostWriter writes queued guest data to the host socket, half-closing it
+// once the guest has sent its FIN
+func (c *natTCPConn) hostWriter() {
+	defer c.nat.wg.Done()
+
+	for data := range c.writeQ {
+		if _, err := c.host.Write(data); err != nil {
+			c.mutex.Lock()
+			c.reset()
+			c.mutex.Unlock()
+			for range c.writeQ {
+			}
+			return
+		}
+	}
+
+	if tcpConn, ok := c.host.(*net.TCPConn); ok {
+		_ = tcpConn.CloseWrite()
+	}
+
+	c.mutex.Lock()
+	c.writerDone = true
+	if c.readerDone {
+		_ = c.host.Close()
+	}
+	c.mutex.Unlock()
+}
+
+// tick retransmits unacknowledged segments and expires idle connections
+func (c *natTCPConn) tick(now time.Time) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.state == natTCPClosed || c.state == natTCPDialing {
+		return
+	}
+
+	if now.Sub(c.lastActive) > natTCPIdleTimeout {
+		c.reset()
+		return
+	}
+
+	if c.sndUna == c.sndNxt || now.Sub(c.lastSend) < natRetransmitTimeout {
+		return
+	}
+
+	c.retries++
+	if c.retries > natMaxRetransmits {
+		c.reset()
+		return
+	}
+
+	if c.state != natTCPEstablished {
+		c.sendSYN()
+		return
+	}
+
+	size := min(len(c.unacked), c.mss)
+	if size > 0 {
+		c.send(&tcpSegment{Seq: c.sndUna, Flags: tcpPSH, Payload: c.unacked[:size]})
+	}
+	if size == len(c.unacked) && c.finSent {
+		c.send(&tcpSegment{Seq: c.sndUna + uint32(size), Flags: tcpFIN})
+	}
+	c.lastSend = now
+}
+
+// reset aborts the connection, notifying the guest; the mutex must be held
+func (c *natTCPConn) reset() {
+	if c.state == natTCPClosed {
+		return
+	}
+	seq := c.sndNxt
+	if c.state == natTCPDialing {
+		seq = 0
+	}
+	c.send(&tcpSegment{Seq: seq, Flags: tcpRST})
+	c.close(false)
+}
+
+// close removes the connection. A graceful close leaves the host socket to
+// be closed by the relay goroutines once they finish; the mutex must be held.
+func (c *natTCPConn) close(graceful bool) {
+	c.state = natTCPClosed
+	c.closeWriteQ()
+	c.cond.Broadcast()
+
+	if !graceful && c.host != nil {
+		_ = c.host.Close()
+	}
+
+	c.nat.mutex.Lock()
+	if c.nat.tcp[c.key] == c {
+		delete(c.nat.tcp, c.key)
+	}
+	c.nat.mutex.Unlock()
+}
+
+// closeWriteQ stops the host writer once the queue drains; the mutex must be held
+func (c *natTCPConn) closeWriteQ() {
+	if !c.writeClosed {
+		c.writeClosed = true
+		close(c.writeQ)
+	}
+}
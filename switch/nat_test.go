@@ -0,0 +1,365 @@
+package vswitch
+
+import (
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// frameSink is a net.Conn that collects frames written by the switch from
+// any goroutine
+type frameSink struct {
+	mutex sync.Mutex
+	data  []byte
+	next  int
+}
+
+func (s *frameSink) Read(_ []byte) (int, error) { return 0, io.EOF }
+
+func (s *frameSink) Write(b []byte) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.data = append(s.data, b...)
+	return len(b), nil
+}
+
+func (s *frameSink) Close() error { return nil }
+func (s *frameSink) LocalAddr() net.Addr {
+	return &mockAddrSwitch{network: "tcp", address: "127.0.0.1:9001"}
+}
+func (s *frameSink) RemoteAddr() net.Addr               { return s.LocalAddr() }
+func (s *frameSink) SetDeadline(_ time.Time) error      { return nil }
+func (s *frameSink) SetReadDeadline(_ time.Time) error  { return nil }
+func (s *frameSink) SetWriteDeadline(_ time.Time) error { return nil }
+
+// nextFrame waits for the next complete frame that has not been returned yet
+func (s *frameSink) nextFrame(t *testing.T) *EthernetFrame {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		s.mutex.Lock()
+		data := s.data[s.next:]
+		if len(data) >= 4 {
+			frameLen := int(data[0])<<24 | int(data[1])<<16 | int(data[2])<<8 | int(data[3])
+			if len(data) >= 4+frameLen {
+				raw := append([]byte{}, data[4:4+frameLen]...)
+				s.next += 4 + frameLen
+				s.mutex.Unlock()
+				return rawFrame(raw)
+			}
+		}
+		s.mutex.Unlock()
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	t.Fatalf("Timed out waiting for a frame")
+	return nil
+}
+
+// natTestGuest drives a NAT gateway from a simulated guest
+type natTestGuest struct {
+	t    *testing.T
+	sw   *VirtualSwitch
+	conn *Connection
+	sink *frameSink
+	mac  net.HardwareAddr
+	ip   net.IP
+}
+
+// newNATTest creates a switch with a running NAT gateway and one guest
+func newNATTest(t *testing.T, cfg *NATConfig) *natTestGuest {
+	t.Helper()
+
+	sw := NewVirtualSwitchWithConfig([]int{8080}, Config{NAT: cfg})
+	sink := &frameSink{}
+	conn := NewConnection("guest", sink)
+	sw.connections.Store("guest", conn)
+
+	sw.nat.listen()
+	shutdown := make(chan bool)
+	done := make(chan struct{})
+	go func() {
+		sw.nat.run(shutdown)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		close(shutdown)
+		<-done
+	})
+
+	return &natTestGuest{
+		t:    t,
+		sw:   sw,
+		conn: conn,
+		sink: sink,
+		mac:  net.HardwareAddr{0x52, 0x54, 0x00, 0x00, 0x00, 0x01},
+		ip:   net.ParseIP("10.0.2.15").To4(),
+	}
+}
+
+// sendTCP sends a segment from the guest to the gateway
+func (g *natTestGuest) sendTCP(dst net.IP, seg *tcpSegment) {
+	packet := buildTCPv4(g.ip, dst, seg)
+	_ = g.sw.processFrame(rawFrame(buildEthernet(g.sw.nat.stack.mac, g.mac, EtherTypeIPv4, packet)), g.conn)
+}
+
+// sendUDP sends a datagram from the guest to the gateway
+func (g *natTestGuest) sendUDP(dst net.IP, srcPort, dstPort uint16, payload []byte) {
+	packet := buildUDPv4(g.ip, dst, srcPort, dstPort, payload)
+	_ = g.sw.processFrame(rawFrame(buildEthernet(g.sw.nat.stack.mac, g.mac, EtherTypeIPv4, packet)), g.conn)
+}
+
+// receive waits for the next IPv4 packet sent to the guest
+func (g *natTestGuest) receive() *ipv4Packet {
+	g.t.Helper()
+
+	for {
+		frame := g.sink.nextFrame(g.t)
+		if frame.EtherType != EtherTypeIPv4 {
+			continue
+		}
+		if frame.DestMAC.String() != g.mac.String() {
+			g.t.Fatalf("Frame sent to %s instead of the guest", frame.DestMAC)
+		}
+		packet, err := parseIPv4(frame.Payload)
+		if err != nil {
+			g.t.Fatalf("Unexpected error parsing IPv4: %v", err)
+		}
+		return packet
+	}
+}
+
+// receiveTCP waits for the next TCP segment sent to the guest
+func (g *natTestGuest) receiveTCP() *tcpSegment {
+	g.t.Helper()
+
+	packet := g.receive()
+	if packet.Protocol != ipProtoTCP || !packet.Dst.Equal(g.ip) {
+		g.t.Fatalf("Expected TCP to the guest, got protocol %d to %s", packet.Protocol, packet.Dst)
+	}
+	if ipv4Checksum(packet.Src, packet.Dst, ipProtoTCP, packet.Payload) != 0 {
+		g.t.Fatalf("TCP checksum does not verify")
+	}
+	seg, err := parseTCP(packet.Payload)
+	if err != nil {
+		g.t.Fatalf("Unexpected error parsing TCP: %v", err)
+	}
+	return seg
+}
+
+// startEchoServer runs a loopback TCP server that echoes what it receives
+func startEchoServer(t *testing.T) *net.TCPAddr {
+	t.Helper()
+
+	listener, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { _ = listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer func() { _ = conn.Close() }()
+				_, _ = io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	return listener.Addr().(*net.TCPAddr)
+}
+
+func TestNATHostAddress(t *testing.T) {
+	sw := NewVirtualSwitchWithConfig([]int{8080}, Config{NAT: &NATConfig{Gateway: net.ParseIP("10.0.2.2")}})
+	n := sw.nat
+
+	if ip, ok := n.hostAddress(net.ParseIP("192.0.2.1")); !ok || !ip.Equal(net.ParseIP("192.0.2.1")) {
+		t.Errorf("Expected external address to be relayed, got %v %v", ip, ok)
+	}
+
+	for _, dst := range []string{"10.0.2.2", "127.0.0.1", "0.0.0.0", "224.0.0.251", "255.255.255.255"} {
+		if _, ok := n.hostAddress(net.ParseIP(dst)); ok {
+			t.Errorf("Expected %s to be refused", dst)
+		}
+	}
+
+	n.allowLoopback = true
+	if ip, ok := n.hostAddress(net.ParseIP("10.0.2.2")); !ok || !ip.Equal(net.ParseIP("127.0.0.1")) {
+		t.Errorf("Expected gateway to map to loopback, got %v %v", ip, ok)
+	}
+
+	if !n.stack.owns(net.ParseIP("10.0.2.2")) {
+		t.Errorf("Expected the host stack to own the gateway address")
+	}
+}
+
+func TestNATUDP(t *testing.T) {
+	gateway := net.ParseIP("10.0.2.2").To4()
+	g := newNATTest(t, &NATConfig{Gateway: gateway, AllowLoopback: true})
+
+	server, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer func() { _ = server.Close() }()
+	serverPort := uint16(server.LocalAddr().(*net.UDPAddr).Port)
+
+	g.sendUDP(gateway, 5000, serverPort, []byte("ping"))
+
+	buf := make([]byte, 64)
+	_ = server.SetReadDeadline(time.Now().Add(5 * time.Second))
+	nr, addr, err := server.ReadFromUDP(buf)
+	if err != nil || string(buf[:nr]) != "ping" {
+		t.Fatalf("Expected datagram on the host, got %q (%v)", buf[:nr], err)
+	}
+
+	_, _ = server.WriteToUDP([]byte("pong"), addr)
+
+	packet := g.receive()
+	udp, err := parseUDP(packet.Payload)
+	if err != nil || packet.Protocol != ipProtoUDP {
+		t.Fatalf("Expected UDP reply, got protocol %d (%v)", packet.Protocol, err)
+	}
+	if !packet.Src.Equal(gateway) || udp.SrcPort != serverPort || udp.DstPort != 5000 || string(udp.Payload) != "pong" {
+		t.Errorf("Unexpected reply from %s:%d to port %d: %q", packet.Src, udp.SrcPort, udp.DstPort, udp.Payload)
+	}
+
+	if flows := g.sw.GetStats()["nat_flows"].(int); flows != 1 {
+		t.Errorf("Expected 1 NAT flow, got %d", flows)
+	}
+}
+
+func TestNATTCPOutbound(t *testing.T) {
+	gateway := net.ParseIP("10.0.2.2").To4()
+	g := newNATTest(t, &NATConfig{Gateway: gateway, AllowLoopback: true})
+	port := uint16(startEchoServer(t).Port)
+
+	g.sendTCP(gateway, &tcpSegment{SrcPort: 40000, DstPort: port, Seq: 100, Flags: tcpSYN, Window: 65535, MSS: 1460})
+
+	synAck := g.receiveTCP()
+	if synAck.Flags != tcpSYN|tcpACK || synAck.Ack != 101 || synAck.SrcPort != port || synAck.MSS != natMSS {
+		t.Fatalf("Expected SYN-ACK, got %+v", synAck)
+	}
+	serverSeq := synAck.Seq + 1
+
+	g.sendTCP(gateway, &tcpSegment{SrcPort: 40000, DstPort: port, Seq: 101, Ack: serverSeq, Flags: tcpACK, Window: 65535})
+	g.sendTCP(gateway, &tcpSegment{SrcPort: 40000, DstPort: port, Seq: 101, Ack: serverSeq, Flags: tcpACK | tcpPSH, Window: 65535, Payload: []byte("hello")})
+
+	var echoed []byte
+	for len(echoed) < 5 {
+		seg := g.receiveTCP()
+		if seg.Flags&tcpRST != 0 {
+			t.Fatalf("Unexpected reset")
+		}
+		if len(seg.Payload) > 0 {
+			if seg.Seq != serverSeq+uint32(len(echoed)) {
+				t.Fatalf("Unexpected sequence number %d", seg.Seq)
+			}
+			echoed = append(echoed, seg.Payload...)
+		} else if seg.Ack != 106 {
+			t.Fatalf("Expected ACK of the guest's data, got %+v", seg)
+		}
+	}
+	if string(echoed) != "hello" {
+		t.Errorf("Expected echo of 'hello', got %q", echoed)
+	}
+	serverSeq += 5
+
+	// Closing from the guest half-closes the host socket; the echo server
+	// then closes its side
+	g.sendTCP(gateway, &tcpSegment{SrcPort: 40000, DstPort: port, Seq: 106, Ack: serverSeq, Flags: tcpACK | tcpFIN, Window: 65535})
+
+	for {
+		seg := g.receiveTCP()
+		if seg.Flags&tcpFIN != 0 {
+			if seg.Seq != serverSeq || seg.Ack != 107 {
+				t.Errorf("Unexpected FIN %+v", seg)
+			}
+			break
+		}
+	}
+
+	g.sendTCP(gateway, &tcpSegment{SrcPort: 40000, DstPort: port, Seq: 107, Ack: serverSeq + 1, Flags: tcpACK, Window: 65535})
+
+	if flows := g.sw.nat.flows(); flows != 0 {
+		t.Errorf("Expected connection to be removed after close, got %d flows", flows)
+	}
+}
+
+func TestNATTCPRefused(t *testing.T) {
+	gateway := net.ParseIP("10.0.2.2").To4()
+	g := newNATTest(t, &NATConfig{Gateway: gateway})
+
+	// The gateway is not relayed to loopback unless allowed
+	g.sendTCP(gateway, &tcpSegment{SrcPort: 40000, DstPort: 22, Seq: 100, Flags: tcpSYN, Window: 65535})
+
+	seg := g.receiveTCP()
+	if seg.Flags != tcpRST|tcpACK || seg.Ack != 101 {
+		t.Errorf("Expected RST for refused destination, got %+v", seg)
+	}
+
+	// Segments for unknown connections are reset too
+	g.sendTCP(net.ParseIP("192.0.2.1"), &tcpSegment{SrcPort: 40001, DstPort: 80, Seq: 5, Ack: 77, Flags: tcpACK, Window: 65535})
+
+	seg = g.receiveTCP()
+	if seg.Flags != tcpRST || seg.Seq != 77 {
+		t.Errorf("Expected RST for unknown connection, got %+v", seg)
+	}
+}
+
+func TestNATTCPPortForward(t *testing.T) {
+	gateway := net.ParseIP("10.0.2.2").To4()
+	g := newNATTest(t, &NATConfig{Gateway: gateway})
+	g.sw.nat.stack.learn(g.ip, g.mac)
+	g.sw.learnMAC(g.mac, g.conn)
+
+	listener, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer func() { _ = listener.Close() }()
+
+	g.sw.nat.wg.Add(1)
+	go g.sw.nat.acceptTCP(listener, PortForward{Protocol: "tcp", GuestIP: g.ip, GuestPort: 22})
+
+	client, err := net.Dial("tcp4", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer func() { _ = client.Close() }()
+
+	syn := g.receiveTCP()
+	if syn.Flags != tcpSYN || syn.DstPort != 22 || syn.SrcPort < natFirstPort {
+		t.Fatalf("Expected SYN to the guest, got %+v", syn)
+	}
+
+	g.sendTCP(gateway, &tcpSegment{SrcPort: 22, DstPort: syn.SrcPort, Seq: 500, Ack: syn.Seq + 1, Flags: tcpSYN | tcpACK, Window: 65535})
+
+	ack := g.receiveTCP()
+	if ack.Flags != tcpACK || ack.Ack != 501 {
+		t.Fatalf("Expected ACK of the guest's SYN, got %+v", ack)
+	}
+
+	_, _ = client.Write([]byte("ssh"))
+
+	data := g.receiveTCP()
+	if string(data.Payload) != "ssh" || data.Seq != syn.Seq+1 {
+		t.Fatalf("Expected client data, got %+v", data)
+	}
+
+	g.sendTCP(gateway, &tcpSegment{SrcPort: 22, DstPort: syn.SrcPort, Seq: 501, Ack: data.Seq + 3, Flags: tcpACK | tcpPSH, Window: 65535, Payload: []byte("banner")})
+
+	buf := make([]byte, 16)
+	_ = client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	nr, err := client.Read(buf)
+	if err != nil || string(buf[:nr]) != "banner" {
+		t.Errorf("Expected guest data on the client, got %q (%v)", buf[:nr], err)
+	}
+}
@@ -0,0 +1,237 @@
+package vswitch
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// icmpv6Redirect is the ICMPv6 redirect message type, which only routers send
+const icmpv6Redirect = 137
+
+// IPv6 extension header numbers walked to find the upper-layer protocol
+const (
+	ipv6HopByHop    = 0
+	ipv6Routing     = 43
+	ipv6Fragment    = 44
+	ipv6DestOptions = 60
+	ipv6NoNext      = 59
+)
+
+// ipv6UpperLayer walks the extension header chain and returns the
+// upper-layer protocol and payload. It returns ok=false if the chain is
+// malformed or the upper-layer header is not in this packet, such as in a
+// non-first fragment.
+func ipv6UpperLayer(packet *ipv6Packet) (proto uint8, payload []byte, ok bool) {
+	proto = packet.NextHeader
+	payload = packet.Payload
+
+	for {
+		switch proto {
+		case ipv6HopByHop, ipv6Routing, ipv6DestOptions:
+			if len(payload) < 8 {
+				return 0, nil, false
+			}
+			length := (int(payload[1]) + 1) * 8
+			if len(payload) < length {
+				return 0, nil, false
+			}
+			proto = payload[0]
+			payload = payload[length:]
+		case ipv6Fragment:
+			if len(payload) < 8 {
+				return 0, nil, false
+			}
+			// Only the first fragment carries the upper-layer header
+			if offset := (uint16(payload[2])<<8 | uint16(payload[3])) >> 3; offset != 0 {
+				return 0, nil, false
+			}
+			proto = payload[0]
+			payload = payload[8:]
+		case ipv6NoNext:
+			return proto, nil, false
+		default:
+			return proto, payload, true
+		}
+	}
+}
+
+// ndBinding records which connection first claimed an IPv6 address
+type ndBinding struct {
+	mac      net.HardwareAddr
+	connID   string
+	lastSeen time.Time
+}
+
+// ndGuard drops router advertisements and redirects from untrusted
+// connections and neighbor discovery messages that spoof addresses already
+// claimed by other connections
+type ndGuard struct {
+	raGuard      bool
+	ndInspection bool
+	timeout      time.Duration
+
+	bindings map[string]*ndBinding // IPv6 address -> first claimant
+	mutex    sync.Mutex
+}
+
+// newNDGuard creates an IPv6 first-hop security filter
+func newNDGuard(raGuard, ndInspection bool, timeout time.Duration) *ndGuard {
+	return &ndGuard{
+		raGuard:      raGuard,
+		ndInspection: ndInspection,
+		timeout:      timeout,
+		bindings:     make(map[string]*ndBinding),
+	}
+}
+
+// inspect examines a frame and returns false if it must be dropped
+func (g *ndGuard) inspect(frame *EthernetFrame, src *Connection) bool {
+	if frame.EtherType != EtherTypeIPv6 || src.Trusted() {
+		return true
+	}
+
+	packet, err := parseIPv6(frame.Payload)
+	if err != nil {
+		return true
+	}
+
+	proto, msg, ok := ipv6UpperLayer(packet)
+	if !ok {
+		// Drop fragments hiding their upper-layer header, a known RA guard evasion
+		return !g.raGuard || proto == ipv6NoNext
+	}
+
+	if proto != ipProtoICMPv6 || len(msg) < 4 {
+		return true
+	}
+
+	switch msg[0] {
+	case icmpv6RouterAdvertisement, icmpv6Redirect:
+		return !g.raGuard
+	case icmpv6NeighborSolicitation:
+		if !g.ndInspection {
+			return true
+		}
+		return g.inspectSolicitation(frame, packet, msg, src)
+	case icmpv6NeighborAdvertisement:
+		if !g.ndInspection {
+			return true
+		}
+		return g.inspectAdvertisement(frame, msg, src)
+	}
+
+	return true
+}
+
+// inspectSolicitation checks that a neighbor solicitation's source link-layer
+// address is the sender's and that its source address is not claimed by another connection
+func (g *ndGuard) inspectSolicitation(frame *EthernetFrame, packet *ipv6Packet, msg []byte, src *Connection) bool {
+	if len(msg) < 24 {
+		return false
+	}
+
+	if lla := ndLinkLayerOption(msg[24:], 1); lla != nil && lla.String() != frame.SrcMAC.String() {
+		return false
+	}
+
+	// Solicitations from the unspecified address are duplicate address detection probes
+	if packet.Src.IsUnspecified() {
+		return true
+	}
+
+	return g.claim(packet.Src, frame.SrcMAC, src)
+}
+
+// inspectAdvertisement checks that a neighbor advertisement's target link-layer
+// address is the sender's and that its target is not claimed by another connection
+func (g *ndGuard) inspectAdvertisement(frame *EthernetFrame, msg []byte, src *Connection) bool {
+	if len(msg) < 24 {
+		return false
+	}
+
+	if lla := ndLinkLayerOption(msg[24:], 2); lla != nil && lla.String() != frame.SrcMAC.String() {
+		return false
+	}
+
+	return g.claim(net.IP(msg[8:24]), frame.SrcMAC, src)
+}
+
+// claim binds an address to the connection on first use and reports whether
+// the connection may use it
+func (g *ndGuard) claim(ip net.IP, mac net.HardwareAddr, conn *Connection) bool {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	key := ip.String()
+	now := time.Now()
+
+	if binding, found := g.bindings[key]; found && now.Sub(binding.lastSeen) <= g.timeout {
+		if binding.connID != conn.ID {
+			return false
+		}
+		binding.mac = append(net.HardwareAddr{}, mac...)
+		binding.lastSeen = now
+		return true
+	}
+
+	g.bindings[key] = &ndBinding{
+		mac:      append(net.HardwareAddr{}, mac...),
+		connID:   conn.ID,
+		lastSeen: now,
+	}
+	return true
+}
+
+// removeConnection releases every address claimed by a connection
+func (g *ndGuard) removeConnection(connID string) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	for key, binding := range g.bindings {
+		if binding.connID == connID {
+			delete(g.bindings, key)
+		}
+	}
+}
+
+// rebindMAC hands the addresses claimed by a MAC over to the connection
+// it moved to
+func (g *ndGuard) rebindMAC(mac net.HardwareAddr, connID string) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	for _, binding := range g.bindings {
+		if binding.mac.String() == mac.String() {
+			binding.connID = connID
+		}
+	}
+}
+
+// expire releases addresses not used within the timeout
+func (g *ndGuard) expire(now time.Time) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	for key, binding := range g.bindings {
+		if now.Sub(binding.lastSeen) > g.timeout {
+			delete(g.bindings, key)
+		}
+	}
+}
+
+// ndLinkLayerOption returns the link-layer address carried in the given
+// neighbor discovery option type, or nil if it is absent
+func ndLinkLayerOption(options []byte, optType uint8) net.HardwareAddr {
+	for len(options) >= 8 {
+		length := int(options[1]) * 8
+		if length == 0 || length > len(options) {
+			return nil
+		}
+		if options[0] == optType {
+			return net.HardwareAddr(options[2:8])
+		}
+		options = options[length:]
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package vswitch
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// ndFrame builds an Ethernet frame carrying an ICMPv6 message
+func ndFrame(srcMAC net.HardwareAddr, srcIP, dstIP net.IP, msg []byte) *EthernetFrame {
+	packet := buildIPv6(srcIP, dstIP, ipProtoICMPv6, 255, msg, 2)
+	frame, _ := ParseEthernetFrame(buildEthernet(ipv6MulticastMAC(allNodesIPv6), srcMAC, EtherTypeIPv6, packet))
+	frame.pooled = false
+	return frame
+}
+
+// neighborAdvertisement builds an NA for target with a target link-layer address option
+func neighborAdvertisement(target net.IP, lla net.HardwareAddr) []byte {
+	msg := make([]byte, 24, 32)
+	msg[0] = icmpv6NeighborAdvertisement
+	msg[4] = 0x20 // Override
+	copy(msg[8:24], target.To16())
+	msg = append(msg, 2, 1)
+	return append(msg, lla...)
+}
+
+func TestRAGuard(t *testing.T) {
+	guard := newNDGuard(true, false, time.Minute)
+
+	untrusted := NewConnection("guest", &mockConnSwitch{addr: &mockAddrSwitch{network: "tcp", address: "127.0.0.1:9001"}})
+	trusted := NewConnection("router", &mockConnSwitch{addr: &mockAddrSwitch{network: "tcp", address: "127.0.0.1:9002"}})
+	trusted.SetTrusted(true)
+
+	mac := net.HardwareAddr{0x52, 0x54, 0x00, 0x00, 0x00, 0x01}
+	ra := ndFrame(mac, linkLocalFromMAC(mac), allNodesIPv6, make([]byte, 16))
+	ra.Payload[40] = icmpv6RouterAdvertisement
+
+	if guard.inspect(ra, untrusted) {
+		t.Errorf("Expected RA from untrusted connection to be dropped")
+	}
+
+	if !guard.inspect(ra, trusted) {
+		t.Errorf("Expected RA from trusted connection to pass")
+	}
+
+	// Hiding the ICMPv6 header behind a non-first fragment is refused
+	fragment := []byte{ipProtoICMPv6, 0, 0, 8, 0, 0, 0, 1}
+	packet := buildIPv6(linkLocalFromMAC(mac), allNodesIPv6, ipv6Fragment, 255, append(fragment, make([]byte, 16)...), 10)
+	frame, _ := ParseEthernetFrame(buildEthernet(ipv6MulticastMAC(allNodesIPv6), mac, EtherTypeIPv6, packet))
+	if guard.inspect(frame, untrusted) {
+		t.Errorf("Expected non-first fragment to be dropped under RA guard")
+	}
+
+	// RA behind a hop-by-hop header is still recognized
+	hopByHop := append([]byte{ipProtoICMPv6, 0, 1, 4, 0, 0, 0, 0}, make([]byte, 16)...)
+	hopByHop[8] = icmpv6RouterAdvertisement
+	packet = buildIPv6(linkLocalFromMAC(mac), allNodesIPv6, ipv6HopByHop, 255, hopByHop, 10)
+	frame, _ = ParseEthernetFrame(buildEthernet(ipv6MulticastMAC(allNodesIPv6), mac, EtherTypeIPv6, packet))
+	if guard.inspect(frame, untrusted) {
+		t.Errorf("Expected RA behind extension header to be dropped")
+	}
+}
+
+func TestNDInspection(t *testing.T) {
+	sw := NewVirtualSwitchWithConfig([]int{8080}, Config{NDInspection: true})
+
+	victim := NewConnection("victim", &mockConnSwitch{addr: &mockAddrSwitch{network: "tcp", address: "127.0.0.1:9001"}})
+	attacker := NewConnection("attacker", &mockConnSwitch{addr: &mockAddrSwitch{network: "tcp", address: "127.0.0.1:9002"}})
+	sw.connections.Store("victim", victim)
+	sw.connections.Store("attacker", attacker)
+
+	victimMAC := net.HardwareAddr{0x52, 0x54, 0x00, 0x00, 0x00, 0x01}
+	attackerMAC := net.HardwareAddr{0x52, 0x54, 0x00, 0x00, 0x00, 0x02}
+	target := net.ParseIP("2001:db8::10")
+
+	// The victim claims its address first
+	if err := sw.processFrame(ndFrame(victimMAC, target, allNodesIPv6, neighborAdvertisement(target, victimMAC)), victim); err != nil {
+		t.Fatalf("Unexpected error for legitimate NA: %v", err)
+	}
+
+	// The attacker advertising the same address is refused
+	if err := sw.processFrame(ndFrame(attackerMAC, linkLocalFromMAC(attackerMAC), allNodesIPv6, neighborAdvertisement(target, attackerMAC)), attacker); err == nil {
+		t.Errorf("Expected spoofed NA to be dropped")
+	}
+
+	// A link-layer address that differs from the Ethernet source is refused
+	other := net.ParseIP("2001:db8::20")
+	if err := sw.processFrame(ndFrame(attackerMAC, other, allNodesIPv6, neighborAdvertisement(other, victimMAC)), attacker); err == nil {
+		t.Errorf("Expected NA with mismatched link-layer address to be dropped")
+	}
+
+	if stats := sw.GetStats(); stats["nd_drops"] != uint64(2) {
+		t.Errorf("Expected 2 ND drops, got %v", stats["nd_drops"])
+	}
+
+	// Once the victim disconnects the address can be claimed again
+	sw.cleanupConnection(victim)
+	if err := sw.processFrame(ndFrame(attackerMAC, target, allNodesIPv6, neighborAdvertisement(target, attackerMAC)), attacker); err != nil {
+		t.Errorf("Expected released address to be claimable, got %v", err)
+	}
+}
+
+func TestNDLinkLayerOption(t *testing.T) {
+	mac := net.HardwareAddr{0x52, 0x54, 0x00, 0x12, 0x34, 0x56}
+	options := append([]byte{14, 1, 0, 0, 0, 0, 0, 0, 1, 1}, mac...)
+
+	if lla := ndLinkLayerOption(options, 1); lla.String() != mac.String() {
+		t.Errorf("Expected %s, got %v", mac, lla)
+	}
+
+	if lla := ndLinkLayerOption(options, 2); lla != nil {
+		t.Errorf("Expected no target link-layer option, got %s", lla)
+	}
+
+	if lla := ndLinkLayerOption([]byte{1, 0, 0, 0, 0, 0, 0, 0}, 1); lla != nil {
+		t.Errorf("Expected zero-length option to stop parsing")
+	}
+}
@@ -0,0 +1,1014 @@
+package vswitch
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+// OpenFlow 1.3 message types
+const (
+	ofpVersion = 0x04
+
+	ofptHello           = 0
+	ofptError           = 1
+	ofptEchoRequest     = 2
+	ofptEchoReply       = 3
+	ofptFeaturesRequest = 5
+	ofptFeaturesReply   = 6
+	ofptGetConfigReq    = 7
+	ofptGetConfigReply  = 8
+	ofptSetConfig       = 9
+	ofptPacketIn        = 10
+	ofptFlowRemoved     = 11
+	ofptPortStatus      = 12
+	ofptPacketOut       = 13
+	ofptFlowMod         = 14
+	ofptMultipartReq    = 18
+	ofptMultipartReply  = 19
+	ofptBarrierRequest  = 20
+	ofptBarrierReply    = 21
+)
+
+// OpenFlow error types and codes
+const (
+	ofpetHelloFailed    = 0
+	ofpetBadRequest     = 1
+	ofpetBadAction      = 2
+	ofpetBadInstruction = 3
+	ofpetBadMatch       = 4
+	ofpetFlowModFailed  = 5
+
+	ofpHFCIncompatible = 0
+
+	ofpBRCBadVersion    = 0
+	ofpBRCBadType       = 1
+	ofpBRCBadMultipart  = 2
+	ofpBRCBadLen        = 6
+	ofpBRCBufferUnknown = 8
+
+	ofpBACBadType    = 0
+	ofpBACBadLen     = 1
+	ofpBACBadOutPort = 4
+
+	ofpBICUnsupInst  = 1
+	ofpBICBadTableID = 2
+	ofpBICBadLen     = 7
+
+	ofpBMCBadType  = 0
+	ofpBMCBadLen   = 1
+	ofpBMCBadField = 6
+	ofpBMCDupField = 10
+
+	ofpFMFCOverlap    = 3
+	ofpFMFCBadTableID = 2
+	ofpFMFCBadCommand = 6
+)
+
+// Reserved port numbers
+const (
+	ofppMax        = 0xffffff00
+	ofppInPort     = 0xfffffff8
+	ofppTable      = 0xfffffff9
+	ofppNormal     = 0xfffffffa
+	ofppFlood      = 0xfffffffb
+	ofppAll        = 0xfffffffc
+	ofppController = 0xfffffffd
+	ofppAny        = 0xffffffff
+)
+
+// Flow modification commands, flags and related constants
+const (
+	ofpfcAdd          = 0
+	ofpfcModify       = 1
+	ofpfcModifyStrict = 2
+	ofpfcDelete       = 3
+	ofpfcDeleteStrict = 4
+
+	ofpffSendFlowRem  = 1 << 0
+	ofpffCheckOverlap = 1 << 1
+	ofpffResetCounts  = 1 << 2
+
+	ofpitWriteActions = 3
+	ofpitApplyActions = 4
+	ofpitClearActions = 5
+
+	ofpatOutput = 0
+
+	ofpttAll    = 0xff
+	ofpNoBuffer = 0xffffffff
+
+	ofprNoMatch = 0
+	ofprAction  = 1
+
+	ofprrIdleTimeout = 0
+	ofprrHardTimeout = 1
+	ofprrDelete      = 2
+
+	ofpprAdd    = 0
+	ofpprDelete = 1
+)
+
+// Multipart message types
+const (
+	ofpmpDesc      = 0
+	ofpmpFlow      = 1
+	ofpmpAggregate = 2
+	ofpmpTable     = 3
+	ofpmpPortStats = 4
+	ofpmpPortDesc  = 13
+
+	ofpmpfReplyMore = 1
+)
+
+const (
+	// openflowRetryInterval is the delay between controller connection attempts
+	openflowRetryInterval = 5 * time.Second
+
+	// openflowEchoInterval is how often the controller connection is probed
+	openflowEchoInterval = 5 * time.Second
+
+	// openflowTimeout drops a controller connection that stays silent
+	openflowTimeout = 15 * time.Second
+
+	// openflowMaxMessage is the largest OpenFlow message
+	openflowMaxMessage = 0xffff
+)
+
+// ofpError is an OpenFlow error reported back to the controller
+type ofpError struct {
+	typ  uint16
+	code uint16
+}
+
+func (e *ofpError) Error() string {
+	return fmt.Sprintf("OpenFlow error type %d code %d", e.typ, e.code)
+}
+
+// openflowPort is a connection exposed to the controller as a switch port
+type openflowPort struct {
+	no    uint32
+	conn  *Connection
+	added time.Time
+}
+
+// openflowAgent forwards the frames of a VLAN according to flow entries
+// installed by an OpenFlow 1.3 controller. It has a single flow table and no
+// packet buffers. Frames matching no entry are sent to the controller; while
+// it is unreachable they are dropped and installed entries keep working.
+type openflowAgent struct {
+	vs         *VirtualSwitch
+	controller string
+	datapathID uint64
+	table      flowTable
+
+	portMutex sync.RWMutex
+	ports     map[uint32]*openflowPort
+	portNos   map[string]uint32
+	nextPort  uint32
+
+	connMutex   sync.Mutex
+	conn        net.Conn // nil while disconnected
+	established bool     // hello exchanged
+	closed      bool
+	missSendLen uint16
+	xid         uint32
+}
+
+// newOpenFlowAgent creates the OpenFlow agent of a switch
+func newOpenFlowAgent(vs *VirtualSwitch, config *OpenFlowConfig) *openflowAgent {
+	datapathID := config.DatapathID
+	if datapathID == 0 {
+		datapathID = uint64(vs.port())
+	}
+	return &openflowAgent{
+		vs:          vs,
+		controller:  config.Controller,
+		datapathID:  datapathID,
+		ports:       make(map[uint32]*openflowPort),
+		portNos:     make(map[string]uint32),
+		nextPort:    1,
+		missSendLen: 128,
+	}
+}
+
+// String describes the agent for logging
+func (a *openflowAgent) String() string {
+	return fmt.Sprintf("datapath %016x with controller %s", a.datapathID, a.controller)
+}
+
+// addPort exposes a connection as a port and returns its number
+func (a *openflowAgent) addPort(conn *Connection) uint32 {
+	a.portMutex.RLock()
+	no, exists := a.portNos[conn.ID]
+	a.portMutex.RUnlock()
+	if exists {
+		return no
+	}
+
+	a.portMutex.Lock()
+	if no, exists = a.portNos[conn.ID]; exists {
+		a.portMutex.Unlock()
+		return no
+	}
+	port := &openflowPort{no: a.nextPort, conn: conn, added: time.Now()}
+	a.nextPort++
+	a.ports[port.no] = port
+	a.portNos[conn.ID] = port.no
+	a.portMutex.Unlock()
+
+	a.vs.logger.Info("OpenFlow port added", "of_port", port.no, "connection", conn.ID)
+	a.sendPortStatus(ofpprAdd, port)
+	return port.no
+}
+
+// removePort withdraws the port of a closed connection
+func (a *openflowAgent) removePort(conn *Connection) {
+	a.portMutex.Lock()
+	no, exists := a.portNos[conn.ID]
+	port := a.ports[no]
+	delete(a.portNos, conn.ID)
+	delete(a.ports, no)
+	a.portMutex.Unlock()
+
+	if exists {
+		a.sendPortStatus(ofpprDelete, port)
+	}
+}
+
+// port returns the port with the given number
+func (a *openflowAgent) port(no uint32) *openflowPort {
+	a.portMutex.RLock()
+	defer a.portMutex.RUnlock()
+	return a.ports[no]
+}
+
+// sortedPorts returns the ports ordered by number
+func (a *openflowAgent) sortedPorts() []*openflowPort {
+	a.portMutex.RLock()
+	ports := make([]*openflowPort, 0, len(a.ports))
+	for _, port := range a.ports {
+		ports = append(ports, port)
+	}
+	a.portMutex.RUnlock()
+
+	sort.Slice(ports, func(i, j int) bool { return ports[i].no < ports[j].no })
+	return ports
+}
+
+// handleFrame forwards every frame through the flow table; MAC learning
+// forwarding is only used for entries that output to the NORMAL port
+func (a *openflowAgent) handleFrame(frame *EthernetFrame, src *Connection) bool {
+	a.process(frame.Raw, a.addPort(src))
+	return true
+}
+
+// process looks a frame up in the flow table and applies the actions
+func (a *openflowAgent) process(raw []byte, inPort uint32) {
+	entry, actions := a.table.lookup(extractFields(raw, inPort), len(raw))
+	if entry == nil {
+		a.sendPacketIn(raw, inPort, ofprNoMatch, 0xffffffffffffffff)
+		return
+	}
+
+	reason := uint8(ofprAction)
+	if entry.tableMiss() {
+		reason = ofprNoMatch
+	}
+	a.apply(raw, inPort, actions, reason, entry.cookie)
+}
+
+// apply performs output actions on a frame
+func (a *openflowAgent) apply(raw []byte, inPort uint32, actions []ofpOutput, reason uint8, cookie uint64) {
+	if len(actions) == 0 {
+		return
+	}
+
+	frame, err := ParseEthernetFrame(raw)
+	if err != nil {
+		return
+	}
+	frame.pooled = false
+
+	for _, action := range actions {
+		switch action.port {
+		case ofppController:
+			a.sendPacketIn(raw, inPort, reason, cookie)
+		case ofppNormal:
+			a.forwardNormal(frame, inPort)
+		case ofppFlood, ofppAll:
+			for _, port := range a.sortedPorts() {
+				if port.no != inPort {
+					a.output(frame, port)
+				}
+			}
+		case ofppInPort:
+			a.output(frame, a.port(inPort))
+		default:
+			a.output(frame, a.port(action.port))
+		}
+	}
+}
+
+// output sends a frame out of a port
+func (a *openflowAgent) output(frame *EthernetFrame, port *openflowPort) {
+	if port == nil || port.conn.IsClosed() {
+		return
+	}
+	if err := port.conn.SendFrame(frame); err != nil {
+		a.vs.logger.Debug("Failed to send frame", "connection", port.conn.ID, "error", err)
+	}
+}
+
+// forwardNormal hands a frame to the switch's MAC learning forwarding
+func (a *openflowAgent) forwardNormal(frame *EthernetFrame, inPort uint32) {
+	src := a.port(inPort)
+	if src == nil {
+		a.vs.injectFrame(frame.Raw)
+		return
+	}
+
+	var err error
+	if frame.IsBroadcast() || frame.IsMulticast() {
+		err = a.vs.floodFrame(frame, src.conn)
+	} else {
+		err = a.vs.forwardFrame(frame, src.conn)
+	}
+	if err != nil {
+		a.vs.logger.Debug("Failed to forward frame", "connection", src.conn.ID, "error", err)
+	}
+}
+
+// run keeps the controller connection up and expires flow entries until
+// shutdown
+func (a *openflowAgent) run(shutdown <-chan bool) {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		a.connectLoop(shutdown)
+	}()
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-shutdown:
+			a.connMutex.Lock()
+			a.closed = true
+			if a.conn != nil {
+				_ = a.conn.Close()
+			}
+			a.connMutex.Unlock()
+			<-done
+			return
+		case now := <-ticker.C:
+			a.expireFlows(now)
+		}
+	}
+}
+
+// connectLoop connects to the controller, reconnecting after failures
+func (a *openflowAgent) connectLoop(shutdown <-chan bool) {
+	reported := false
+	for {
+		conn, err := net.DialTimeout("tcp", a.controller, openflowTimeout)
+		if err != nil {
+			if !reported {
+				a.vs.logger.Warn("Failed to connect to OpenFlow controller", "controller", a.controller, "error", err)
+				reported = true
+			}
+		} else {
+			reported = false
+			a.serve(conn)
+		}
+
+		select {
+		case <-shutdown:
+			return
+		case <-time.After(openflowRetryInterval):
+		}
+	}
+}
+
+// serve exchanges messages with the controller until the connection fails
+func (a *openflowAgent) serve(conn net.Conn) {
+	a.connMutex.Lock()
+	if a.closed {
+		a.connMutex.Unlock()
+		_ = conn.Close()
+		return
+	}
+	a.conn = conn
+	a.connMutex.Unlock()
+
+	defer func() {
+		a.connMutex.Lock()
+		a.conn = nil
+		a.established = false
+		a.connMutex.Unlock()
+		_ = conn.Close()
+	}()
+
+	stopEcho := make(chan struct{})
+	defer close(stopEcho)
+	go func() {
+		ticker := time.NewTicker(openflowEchoInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stopEcho:
+				return
+			case <-ticker.C:
+				a.send(ofptEchoRequest, a.nextXID(), nil)
+			}
+		}
+	}()
+
+	a.send(ofptHello, a.nextXID(), []byte{0, 1, 0, 8, 0, 0, 0, 1 << ofpVersion})
+
+	reader := bufio.NewReader(conn)
+	for {
+		_ = conn.SetReadDeadline(time.Now().Add(openflowTimeout))
+		msg, err := readOpenFlowMessage(reader)
+		if err != nil {
+			a.vs.logger.Warn("OpenFlow controller disconnected", "controller", a.controller, "error", err)
+			return
+		}
+		if !a.handleMessage(msg) {
+			return
+		}
+	}
+}
+
+// readOpenFlowMessage reads one message including its header
+func readOpenFlowMessage(r io.Reader) ([]byte, error) {
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	length := int(binary.BigEndian.Uint16(header[2:4]))
+	if length < 8 {
+		return nil, fmt.Errorf("invalid message length %d", length)
+	}
+
+	msg := make([]byte, length)
+	copy(msg, header)
+	if _, err := io.ReadFull(r, msg[8:]); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// nextXID returns a transaction ID for a message the agent initiates
+func (a *openflowAgent) nextXID() uint32 {
+	a.connMutex.Lock()
+	defer a.connMutex.Unlock()
+	a.xid++
+	return a.xid
+}
+
+// send writes a message to the controller if one is connected
+func (a *openflowAgent) send(msgType uint8, xid uint32, body []byte) {
+	a.connMutex.Lock()
+	defer a.connMutex.Unlock()
+	a.writeLocked(msgType, xid, body)
+}
+
+// sendAsync writes an asynchronous message once the hello exchange is done
+func (a *openflowAgent) sendAsync(msgType uint8, body []byte) {
+	a.connMutex.Lock()
+	defer a.connMutex.Unlock()
+	if a.established {
+		a.xid++
+		a.writeLocked(msgType, a.xid, body)
+	}
+}
+
+// writeLocked writes a message; the caller holds connMutex
+func (a *openflowAgent) writeLocked(msgType uint8, xid uint32, body []byte) {
+	if a.conn == nil {
+		return
+	}
+	if 8+len(body) > openflowMaxMessage {
+		a.vs.logger.Warn("Dropping oversized OpenFlow message", "type", msgType)
+		return
+	}
+
+	msg := make([]byte, 8, 8+len(body))
+	msg[0], msg[1] = ofpVersion, msgType
+	binary.BigEndian.PutUint16(msg[2:4], uint16(8+len(body)))
+	binary.BigEndian.PutUint32(msg[4:8], xid)
+	msg = append(msg, body...)
+
+	_ = a.conn.SetWriteDeadline(time.Now().Add(openflowTimeout))
+	if _, err := a.conn.Write(msg); err != nil {
+		a.vs.logger.Warn("Failed to write to OpenFlow controller", "controller", a.controller, "error", err)
+		_ = a.conn.Close()
+	}
+}
+
+// sendError reports an error caused by a controller message
+func (a *openflowAgent) sendError(msg []byte, err *ofpError) {
+	body := binary.BigEndian.AppendUint16(nil, err.typ)
+	body = binary.BigEndian.AppendUint16(body, err.code)
+	body = append(body, msg[:min(len(msg), 64)]...)
+	a.send(ofptError, binary.BigEndian.Uint32(msg[4:8]), body)
+}
+
+// handleMessage processes a controller message and returns false if the
+// connection must be closed
+func (a *openflowAgent) handleMessage(msg []byte) bool {
+	msgType, xid, body := msg[1], binary.BigEndian.Uint32(msg[4:8]), msg[8:]
+
+	if msgType == ofptHello {
+		if !helloSupportsVersion(msg) {
+			a.sendError(msg, &ofpError{ofpetHelloFailed, ofpHFCIncompatible})
+			a.vs.logger.Error("OpenFlow controller does not support OpenFlow 1.3", "controller", a.controller)
+			return false
+		}
+		a.connMutex.Lock()
+		a.established = true
+		a.connMutex.Unlock()
+		a.vs.logger.Info("Connected to OpenFlow controller", "controller", a.controller, "datapath", a.String())
+		return true
+	}
+	if msg[0] != ofpVersion {
+		a.sendError(msg, &ofpError{ofpetBadRequest, ofpBRCBadVersion})
+		return true
+	}
+
+	var err *ofpError
+	switch msgType {
+	case ofptError:
+		if len(body) >= 4 {
+			a.vs.logger.Warn("OpenFlow controller reported error", "controller", a.controller,
+				"type", binary.BigEndian.Uint16(body[0:2]), "code", binary.BigEndian.Uint16(body[2:4]))
+		}
+	case ofptEchoRequest:
+		a.send(ofptEchoReply, xid, body)
+	case ofptEchoReply:
+	case ofptFeaturesRequest:
+		a.send(ofptFeaturesReply, xid, a.features())
+	case ofptGetConfigReq:
+		a.connMutex.Lock()
+		missSendLen := a.missSendLen
+		a.connMutex.Unlock()
+		a.send(ofptGetConfigReply, xid, binary.BigEndian.AppendUint16([]byte{0, 0}, missSendLen))
+	case ofptSetConfig:
+		if len(body) >= 4 {
+			a.connMutex.Lock()
+			a.missSendLen = binary.BigEndian.Uint16(body[2:4])
+			a.connMutex.Unlock()
+		}
+	case ofptBarrierRequest:
+		// Messages are handled in order, so earlier ones are complete
+		a.send(ofptBarrierReply, xid, nil)
+	case ofptFlowMod:
+		err = a.handleFlowMod(body)
+	case ofptPacketOut:
+		err = a.handlePacketOut(body)
+	case ofptMultipartReq:
+		err = a.handleMultipart(xid, body)
+	default:
+		err = &ofpError{ofpetBadRequest, ofpBRCBadType}
+	}
+
+	if err != nil {
+		a.sendError(msg, err)
+	}
+	return true
+}
+
+// helloSupportsVersion reports whether a hello message allows OpenFlow 1.3,
+// either through its header version or its version bitmap
+func helloSupportsVersion(msg []byte) bool {
+	if msg[0] == ofpVersion {
+		return true
+	}
+	for elements := msg[8:]; len(elements) >= 4; {
+		typ := binary.BigEndian.Uint16(elements[0:2])
+		length := int(binary.BigEndian.Uint16(elements[2:4]))
+		if length < 4 || length > len(elements) {
+			break
+		}
+		if typ == 1 && length >= 8 {
+			return binary.BigEndian.Uint32(elements[4:8])&(1<<ofpVersion) != 0
+		}
+		// Elements are padded to 8 bytes, which a truncated hello lacks
+		next := (length + 7) / 8 * 8
+		if next > len(elements) {
+			break
+		}
+		elements = elements[next:]
+	}
+	return msg[0] > ofpVersion
+}
+
+// features builds the body of a features reply
+func (a *openflowAgent) features() []byte {
+	body := binary.BigEndian.AppendUint64(nil, a.datapathID)
+	body = binary.BigEndian.AppendUint32(body, 0) // n_buffers
+	body = append(body, 1, 0, 0, 0)               // n_tables, auxiliary_id, pad
+	body = binary.BigEndian.AppendUint32(body, 1|2|4)
+	return binary.BigEndian.AppendUint32(body, 0)
+}
+
+// handleFlowMod applies a flow table modification
+func (a *openflowAgent) handleFlowMod(body []byte) *ofpError {
+	if len(body) < 40 {
+		return &ofpError{ofpetBadRequest, ofpBRCBadLen}
+	}
+	cookie := binary.BigEndian.Uint64(body[0:8])
+	cookieMask := binary.BigEndian.Uint64(body[8:16])
+	tableID, command := body[16], body[17]
+	idleTimeout := binary.BigEndian.Uint16(body[18:20])
+	hardTimeout := binary.BigEndian.Uint16(body[20:22])
+	priority := binary.BigEndian.Uint16(body[22:24])
+	bufferID := binary.BigEndian.Uint32(body[24:28])
+	outPort := binary.BigEndian.Uint32(body[28:32])
+	flags := binary.BigEndian.Uint16(body[36:38])
+
+	match, matchLen, err := parseFlowMatch(body[40:])
+	if err != nil {
+		return err.(*ofpError)
+	}
+	instructions := body[40+matchLen:]
+	actions, ofpErr := parseInstructions(instructions)
+	if ofpErr != nil {
+		return ofpErr
+	}
+
+	deleting := command == ofpfcDelete || command == ofpfcDeleteStrict
+	if tableID != 0 && !(deleting && tableID == ofpttAll) {
+		return &ofpError{ofpetFlowModFailed, ofpFMFCBadTableID}
+	}
+	if !deleting && bufferID != ofpNoBuffer {
+		return &ofpError{ofpetBadRequest, ofpBRCBufferUnknown}
+	}
+
+	selector := flowSelector{
+		match:      match,
+		priority:   priority,
+		strict:     command == ofpfcModifyStrict || command == ofpfcDeleteStrict,
+		cookie:     cookie,
+		cookieMask: cookieMask,
+		outPort:    ofppAny,
+	}
+
+	switch command {
+	case ofpfcAdd:
+		entry := &flowEntry{
+			priority:     priority,
+			match:        match,
+			cookie:       cookie,
+			idleTimeout:  idleTimeout,
+			hardTimeout:  hardTimeout,
+			flags:        flags,
+			instructions: append([]byte{}, instructions...),
+			actions:      actions,
+			created:      time.Now(),
+		}
+		entry.lastUsed.Store(entry.created.UnixNano())
+		if !a.table.add(entry, flags&ofpffCheckOverlap != 0) {
+			return &ofpError{ofpetFlowModFailed, ofpFMFCOverlap}
+		}
+	case ofpfcModify, ofpfcModifyStrict:
+		update := &flowEntry{instructions: append([]byte{}, instructions...), actions: actions}
+		a.table.modify(selector, update, flags&ofpffResetCounts != 0)
+	case ofpfcDelete, ofpfcDeleteStrict:
+		selector.outPort = outPort
+		for _, entry := range a.table.remove(func(e *flowEntry) bool { return !selector.selects(e) }) {
+			a.sendFlowRemoved(entry, ofprrDelete)
+		}
+	default:
+		return &ofpError{ofpetFlowModFailed, ofpFMFCBadCommand}
+	}
+	return nil
+}
+
+// parseInstructions returns the output actions of a flow entry's
+// instructions; apply-actions run before the written action set
+func parseInstructions(data []byte) ([]ofpOutput, *ofpError) {
+	var applied, written []ofpOutput
+	for len(data) > 0 {
+		if len(data) < 4 {
+			return nil, &ofpError{ofpetBadInstruction, ofpBICBadLen}
+		}
+		typ := binary.BigEndian.Uint16(data[0:2])
+		length := int(binary.BigEndian.Uint16(data[2:4]))
+		if length < 8 || length%8 != 0 || length > len(data) {
+			return nil, &ofpError{ofpetBadInstruction, ofpBICBadLen}
+		}
+
+		switch typ {
+		case ofpitApplyActions, ofpitWriteActions:
+			actions, err := parseActions(data[8:length], false)
+			if err != nil {
+				return nil, err
+			}
+			if typ == ofpitApplyActions {
+				applied = append(applied, actions...)
+			} else {
+				written = append(written, actions...)
+			}
+		case ofpitClearActions:
+			written = nil
+		case 1: // goto-table; there is only one table
+			return nil, &ofpError{ofpetBadInstruction, ofpBICBadTableID}
+		default:
+			return nil, &ofpError{ofpetBadInstruction, ofpBICUnsupInst}
+		}
+		data = data[length:]
+	}
+	return append(applied, written...), nil
+}
+
+// parseActions parses a list of actions; only output is supported. The
+// TABLE port is only valid in packet-outs.
+func parseActions(data []byte, packetOut bool) ([]ofpOutput, *ofpError) {
+	var actions []ofpOutput
+	for len(data) > 0 {
+		if len(data) < 4 {
+			return nil, &ofpError{ofpetBadAction, ofpBACBadLen}
+		}
+		typ := binary.BigEndian.Uint16(data[0:2])
+		length := int(binary.BigEndian.Uint16(data[2:4]))
+		if length < 8 || length%8 != 0 || length > len(data) {
+			return nil, &ofpError{ofpetBadAction, ofpBACBadLen}
+		}
+		if typ != ofpatOutput {
+			return nil, &ofpError{ofpetBadAction, ofpBACBadType}
+		}
+		if length != 16 {
+			return nil, &ofpError{ofpetBadAction, ofpBACBadLen}
+		}
+
+		action := ofpOutput{port: binary.BigEndian.Uint32(data[4:8]), maxLen: binary.BigEndian.Uint16(data[8:10])}
+		switch {
+		case action.port == 0:
+			return nil, &ofpError{ofpetBadAction, ofpBACBadOutPort}
+		case action.port == ofppTable && !packetOut:
+			return nil, &ofpError{ofpetBadAction, ofpBACBadOutPort}
+		case action.port > ofppMax && action.port != ofppInPort && action.port != ofppTable &&
+			action.port != ofppNormal && action.port != ofppFlood && action.port != ofppAll &&
+			action.port != ofppController:
+			return nil, &ofpError{ofpetBadAction, ofpBACBadOutPort}
+		}
+		actions = append(actions, action)
+		data = data[length:]
+	}
+	return actions, nil
+}
+
+// handlePacketOut sends a frame supplied by the controller
+func (a *openflowAgent) handlePacketOut(body []byte) *ofpError {
+	if len(body) < 16 {
+		return &ofpError{ofpetBadRequest, ofpBRCBadLen}
+	}
+	bufferID := binary.BigEndian.Uint32(body[0:4])
+	inPort := binary.BigEndian.Uint32(body[4:8])
+	actionsLen := int(binary.BigEndian.Uint16(body[8:10]))
+	if 16+actionsLen > len(body) {
+		return &ofpError{ofpetBadRequest, ofpBRCBadLen}
+	}
+	if bufferID != ofpNoBuffer {
+		return &ofpError{ofpetBadRequest, ofpBRCBufferUnknown}
+	}
+
+	actions, err := parseActions(body[16:16+actionsLen], true)
+	if err != nil {
+		return err
+	}
+	data := body[16+actionsLen:]
+	if len(data) < 14 {
+		return &ofpError{ofpetBadRequest, ofpBRCBadLen}
+	}
+
+	for _, action := range actions {
+		if action.port == ofppTable {
+			a.process(data, inPort)
+		} else {
+			a.apply(data, inPort, []ofpOutput{action}, ofprAction, 0xffffffffffffffff)
+		}
+	}
+	return nil
+}
+
+// sendPacketIn sends a frame to the controller. Without packet buffers the
+// whole frame is always included.
+func (a *openflowAgent) sendPacketIn(raw []byte, inPort uint32, reason uint8, cookie uint64) {
+	body := binary.BigEndian.AppendUint32(nil, ofpNoBuffer)
+	body = binary.BigEndian.AppendUint16(body, uint16(len(raw)))
+	body = append(body, reason, 0)
+	body = binary.BigEndian.AppendUint64(body, cookie)
+	body = appendFlowMatch(body, flowMatch{{field: oxmInPort, value: binary.BigEndian.AppendUint32(nil, inPort)}})
+	body = append(body, 0, 0)
+	body = append(body, raw...)
+	a.sendAsync(ofptPacketIn, body)
+}
+
+// sendPortStatus announces a port change
+func (a *openflowAgent) sendPortStatus(reason uint8, port *openflowPort) {
+	body := append([]byte{reason}, make([]byte, 7)...)
+	a.sendAsync(ofptPortStatus, appendPortDesc(body, port))
+}
+
+// sendFlowRemoved reports a removed entry if the controller asked for it
+func (a *openflowAgent) sendFlowRemoved(entry *flowEntry, reason uint8) {
+	if entry.flags&ofpffSendFlowRem == 0 {
+		return
+	}
+
+	duration := time.Since(entry.created)
+	body := binary.BigEndian.AppendUint64(nil, entry.cookie)
+	body = binary.BigEndian.AppendUint16(body, entry.priority)
+	body = append(body, reason, 0)
+	body = binary.BigEndian.AppendUint32(body, uint32(duration/time.Second))
+	body = binary.BigEndian.AppendUint32(body, uint32(duration%time.Second))
+	body = binary.BigEndian.AppendUint16(body, entry.idleTimeout)
+	body = binary.BigEndian.AppendUint16(body, entry.hardTimeout)
+	body = binary.BigEndian.AppendUint64(body, entry.packets.Load())
+	body = binary.BigEndian.AppendUint64(body, entry.bytes.Load())
+	a.sendAsync(ofptFlowRemoved, appendFlowMatch(body, entry.match))
+}
+
+// expiryReason returns the reason an entry has expired, or -1
+func expiryReason(entry *flowEntry, now time.Time) int {
+	if entry.hardTimeout > 0 && now.Sub(entry.created) >= time.Duration(entry.hardTimeout)*time.Second {
+		return ofprrHardTimeout
+	}
+	idle := now.Sub(time.Unix(0, entry.lastUsed.Load()))
+	if entry.idleTimeout > 0 && idle >= time.Duration(entry.idleTimeout)*time.Second {
+		return ofprrIdleTimeout
+	}
+	return -1
+}
+
+// expireFlows removes entries whose idle or hard timeout has passed
+func (a *openflowAgent) expireFlows(now time.Time) {
+	for _, entry := range a.table.remove(func(e *flowEntry) bool { return expiryReason(e, now) < 0 }) {
+		a.sendFlowRemoved(entry, uint8(expiryReason(entry, now)))
+	}
+}
+
+// appendPortDesc appends the ofp_port description of a port
+func appendPortDesc(b []byte, port *openflowPort) []byte {
+	b = binary.BigEndian.AppendUint32(b, port.no)
+	b = append(b, 0, 0, 0, 0)
+	b = append(b, 0x02, 0x76, 0x6f, byte(port.no>>16), byte(port.no>>8), byte(port.no), 0, 0)
+	name := make([]byte, 16)
+	copy(name[:15], fmt.Sprintf("vm%d", port.no))
+	b = append(b, name...)
+	b = binary.BigEndian.AppendUint32(b, 0)        // config
+	b = binary.BigEndian.AppendUint32(b, 4)        // state: live
+	b = binary.BigEndian.AppendUint32(b, 1<<6)     // curr: 10 Gb full duplex
+	b = binary.BigEndian.AppendUint32(b, 0)        // advertised
+	b = binary.BigEndian.AppendUint32(b, 1<<6)     // supported
+	b = binary.BigEndian.AppendUint32(b, 0)        // peer
+	b = binary.BigEndian.AppendUint32(b, 10000000) // curr_speed in kbps
+	return binary.BigEndian.AppendUint32(b, 10000000)
+}
+
+// handleMultipart answers a statistics or description request
+func (a *openflowAgent) handleMultipart(xid uint32, body []byte) *ofpError {
+	if len(body) < 8 {
+		return &ofpError{ofpetBadRequest, ofpBRCBadLen}
+	}
+	mpType, request := binary.BigEndian.Uint16(body[0:2]), body[8:]
+
+	var items [][]byte
+	switch mpType {
+	case ofpmpDesc:
+		desc := make([]byte, 1056)
+		copy(desc[0:255], "vswitch")
+		copy(desc[256:511], "Virtual Switch for QEMU VMs")
+		copy(desc[512:767], "vswitch")
+		copy(desc[1056-256:1055], fmt.Sprintf("VLAN on port %d", a.vs.port()))
+		items = append(items, desc)
+	case ofpmpFlow, ofpmpAggregate:
+		if len(request) < 32 {
+			return &ofpError{ofpetBadRequest, ofpBRCBadLen}
+		}
+		match, _, err := parseFlowMatch(request[32:])
+		if err != nil {
+			return err.(*ofpError)
+		}
+		selector := flowSelector{
+			match:      match,
+			cookie:     binary.BigEndian.Uint64(request[16:24]),
+			cookieMask: binary.BigEndian.Uint64(request[24:32]),
+			outPort:    binary.BigEndian.Uint32(request[4:8]),
+		}
+		tableID := request[0]
+		if mpType == ofpmpFlow {
+			if tableID == 0 || tableID == ofpttAll {
+				a.table.forEach(selector, func(entry *flowEntry) { items = append(items, flowStats(entry)) })
+			}
+		} else {
+			var packets, bytes uint64
+			var count uint32
+			if tableID == 0 || tableID == ofpttAll {
+				a.table.forEach(selector, func(entry *flowEntry) {
+					packets += entry.packets.Load()
+					bytes += entry.bytes.Load()
+					count++
+				})
+			}
+			item := binary.BigEndian.AppendUint64(nil, packets)
+			item = binary.BigEndian.AppendUint64(item, bytes)
+			item = binary.BigEndian.AppendUint32(item, count)
+			items = append(items, append(item, 0, 0, 0, 0))
+		}
+	case ofpmpTable:
+		item := []byte{0, 0, 0, 0}
+		item = binary.BigEndian.AppendUint32(item, uint32(a.table.len()))
+		item = binary.BigEndian.AppendUint64(item, a.table.lookups.Load())
+		items = append(items, binary.BigEndian.AppendUint64(item, a.table.matched.Load()))
+	case ofpmpPortStats:
+		if len(request) < 4 {
+			return &ofpError{ofpetBadRequest, ofpBRCBadLen}
+		}
+		portNo := binary.BigEndian.Uint32(request[0:4])
+		for _, port := range a.sortedPorts() {
+			if portNo == ofppAny || portNo == port.no {
+				items = append(items, portStats(port))
+			}
+		}
+	case ofpmpPortDesc:
+		for _, port := range a.sortedPorts() {
+			items = append(items, appendPortDesc(nil, port))
+		}
+	default:
+		return &ofpError{ofpetBadRequest, ofpBRCBadMultipart}
+	}
+
+	a.sendMultipartReply(xid, mpType, items)
+	return nil
+}
+
+// sendMultipartReply sends reply items, split over several messages when
+// they do not fit in one
+func (a *openflowAgent) sendMultipartReply(xid uint32, mpType uint16, items [][]byte) {
+	const limit = openflowMaxMessage - 16
+
+	for {
+		var body []byte
+		for len(items) > 0 && len(body)+len(items[0]) <= limit {
+			body = append(body, items[0]...)
+			items = items[1:]
+		}
+
+		flags := uint16(0)
+		if len(items) > 0 {
+			flags = ofpmpfReplyMore
+		}
+		header := binary.BigEndian.AppendUint16(nil, mpType)
+		header = binary.BigEndian.AppendUint16(header, flags)
+		a.send(ofptMultipartReply, xid, append(append(header, 0, 0, 0, 0), body...))
+
+		if len(items) == 0 {
+			return
+		}
+	}
+}
+
+// flowStats builds the ofp_flow_stats of an entry
+func flowStats(entry *flowEntry) []byte {
+	duration := time.Since(entry.created)
+	b := []byte{0, 0, 0, 0} // length, table_id, pad
+	b = binary.BigEndian.AppendUint32(b, uint32(duration/time.Second))
+	b = binary.BigEndian.AppendUint32(b, uint32(duration%time.Second))
+	b = binary.BigEndian.AppendUint16(b, entry.priority)
+	b = binary.BigEndian.AppendUint16(b, entry.idleTimeout)
+	b = binary.BigEndian.AppendUint16(b, entry.hardTimeout)
+	b = binary.BigEndian.AppendUint16(b, entry.flags)
+	b = append(b, 0, 0, 0, 0)
+	b = binary.BigEndian.AppendUint64(b, entry.cookie)
+	b = binary.BigEndian.AppendUint64(b, entry.packets.Load())
+	b = binary.BigEndian.AppendUint64(b, entry.bytes.Load())
+	b = appendFlowMatch(b, entry.match)
+	b = append(b, entry.instructions...)
+	binary.BigEndian.PutUint16(b[0:2], uint16(len(b)))
+	return b
+}
+
+// portStats builds the ofp_port_stats of a port
+func portStats(port *openflowPort) []byte {
+	info := port.conn.Info()
+	duration := time.Since(port.added)
+
+	b := binary.BigEndian.AppendUint32(nil, port.no)
+	b = append(b, 0, 0, 0, 0)
+	for _, counter := range []uint64{info.FramesReceived, info.FramesSent, info.BytesReceived, info.BytesSent} {
+		b = binary.BigEndian.AppendUint64(b, counter)
+	}
+	b = append(b, make([]byte, 8*8)...) // drops, errors and collisions
+	b = binary.BigEndian.AppendUint32(b, uint32(duration/time.Second))
+	return binary.BigEndian.AppendUint32(b, uint32(duration%time.Second))
+}
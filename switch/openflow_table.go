@@ -0,0 +1,553 @@
+package vswitch
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// OXM match fields of the OpenFlow basic class
+const (
+	oxmClassBasic = 0x8000
+
+	oxmInPort     = 0
+	oxmEthDst     = 3
+	oxmEthSrc     = 4
+	oxmEthType    = 5
+	oxmVLANVID    = 6
+	oxmIPProto    = 10
+	oxmIPv4Src    = 11
+	oxmIPv4Dst    = 12
+	oxmTCPSrc     = 13
+	oxmTCPDst     = 14
+	oxmUDPSrc     = 15
+	oxmUDPDst     = 16
+	oxmICMPv4Type = 19
+	oxmICMPv4Code = 20
+	oxmARPOp      = 21
+	oxmARPSPA     = 22
+	oxmARPTPA     = 23
+	oxmARPSHA     = 24
+	oxmARPTHA     = 25
+	oxmIPv6Src    = 26
+	oxmIPv6Dst    = 27
+
+	// ofpVIDPresent is set in VLAN_VID values of tagged frames
+	ofpVIDPresent = 0x1000
+)
+
+// oxmFieldLengths holds the value length of each supported match field
+var oxmFieldLengths = map[uint8]int{
+	oxmInPort: 4, oxmEthDst: 6, oxmEthSrc: 6, oxmEthType: 2, oxmVLANVID: 2,
+	oxmIPProto: 1, oxmIPv4Src: 4, oxmIPv4Dst: 4, oxmTCPSrc: 2, oxmTCPDst: 2,
+	oxmUDPSrc: 2, oxmUDPDst: 2, oxmICMPv4Type: 1, oxmICMPv4Code: 1, oxmARPOp: 2,
+	oxmARPSPA: 4, oxmARPTPA: 4, oxmARPSHA: 6, oxmARPTHA: 6, oxmIPv6Src: 16, oxmIPv6Dst: 16,
+}
+
+// oxmField is one field of a flow match; the value is stored already masked
+type oxmField struct {
+	field uint8
+	value []byte
+	mask  []byte // nil for an exact match
+}
+
+// flowMatch is a set of match fields sorted by field number
+type flowMatch []oxmField
+
+// parseFlowMatch parses an OXM ofp_match structure and returns the match
+// and the padded length it occupies
+func parseFlowMatch(data []byte) (flowMatch, int, error) {
+	if len(data) < 4 || binary.BigEndian.Uint16(data[0:2]) != 1 {
+		return nil, 0, &ofpError{ofpetBadMatch, ofpBMCBadType}
+	}
+	length := int(binary.BigEndian.Uint16(data[2:4]))
+	padded := (length + 7) / 8 * 8
+	if length < 4 || padded > len(data) {
+		return nil, 0, &ofpError{ofpetBadMatch, ofpBMCBadLen}
+	}
+
+	var match flowMatch
+	seen := make(map[uint8]bool)
+	for fields := data[4:length]; len(fields) > 0; {
+		if len(fields) < 4 {
+			return nil, 0, &ofpError{ofpetBadMatch, ofpBMCBadLen}
+		}
+		class := binary.BigEndian.Uint16(fields[0:2])
+		field, hasMask := fields[2]>>1, fields[2]&1 == 1
+		size := int(fields[3])
+		if len(fields) < 4+size {
+			return nil, 0, &ofpError{ofpetBadMatch, ofpBMCBadLen}
+		}
+
+		valueLen, supported := oxmFieldLengths[field]
+		if class != oxmClassBasic || !supported {
+			return nil, 0, &ofpError{ofpetBadMatch, ofpBMCBadField}
+		}
+		if seen[field] {
+			return nil, 0, &ofpError{ofpetBadMatch, ofpBMCDupField}
+		}
+		seen[field] = true
+
+		payload := fields[4 : 4+size]
+		f := oxmField{field: field}
+		switch {
+		case !hasMask && size == valueLen:
+			f.value = append([]byte{}, payload...)
+		case hasMask && size == 2*valueLen && field != oxmInPort:
+			f.mask = append([]byte{}, payload[valueLen:]...)
+			f.value = make([]byte, valueLen)
+			for i := range f.value {
+				f.value[i] = payload[i] & f.mask[i]
+			}
+		default:
+			return nil, 0, &ofpError{ofpetBadMatch, ofpBMCBadLen}
+		}
+		match = append(match, f)
+		fields = fields[4+size:]
+	}
+
+	sort.Slice(match, func(i, j int) bool { return match[i].field < match[j].field })
+	return match, padded, nil
+}
+
+// appendFlowMatch appends the OXM encoding of a match, padded to 8 bytes
+func appendFlowMatch(b []byte, match flowMatch) []byte {
+	start := len(b)
+	b = binary.BigEndian.AppendUint16(b, 1)
+	b = binary.BigEndian.AppendUint16(b, 0)
+	for _, f := range match {
+		b = binary.BigEndian.AppendUint16(b, oxmClassBasic)
+		if f.mask == nil {
+			b = append(b, f.field<<1, byte(len(f.value)))
+			b = append(b, f.value...)
+		} else {
+			b = append(b, f.field<<1|1, byte(2*len(f.value)))
+			b = append(b, f.value...)
+			b = append(b, f.mask...)
+		}
+	}
+	binary.BigEndian.PutUint16(b[start+2:], uint16(len(b)-start))
+	for (len(b)-start)%8 != 0 {
+		b = append(b, 0)
+	}
+	return b
+}
+
+// matches reports whether packet fields satisfy the match
+func (m flowMatch) matches(fields *packetFields) bool {
+	for _, f := range m {
+		value := fields.get(f.field)
+		if value == nil || !maskedEqual(value, f.value, f.mask) {
+			return false
+		}
+	}
+	return true
+}
+
+// equal reports whether two matches have identical fields and masks
+func (m flowMatch) equal(other flowMatch) bool {
+	if len(m) != len(other) {
+		return false
+	}
+	for i := range m {
+		if m[i].field != other[i].field || !bytes.Equal(m[i].value, other[i].value) || !bytes.Equal(m[i].mask, other[i].mask) {
+			return false
+		}
+	}
+	return true
+}
+
+// covers reports whether every packet matched by other is matched by m,
+// the non-strict comparison of flow modifications and deletions
+func (m flowMatch) covers(other flowMatch) bool {
+	for _, f := range m {
+		var found *oxmField
+		for i := range other {
+			if other[i].field == f.field {
+				found = &other[i]
+			}
+		}
+		if found == nil {
+			return false
+		}
+		for i := range f.value {
+			mask := byte(0xff)
+			if f.mask != nil {
+				mask = f.mask[i]
+			}
+			otherMask := byte(0xff)
+			if found.mask != nil {
+				otherMask = found.mask[i]
+			}
+			if otherMask&mask != mask || found.value[i]&mask != f.value[i] {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// overlaps reports whether a packet could match both m and other
+func (m flowMatch) overlaps(other flowMatch) bool {
+	for _, f := range m {
+		for _, o := range other {
+			if o.field != f.field {
+				continue
+			}
+			for i := range f.value {
+				mask := byte(0xff)
+				if f.mask != nil {
+					mask &= f.mask[i]
+				}
+				if o.mask != nil {
+					mask &= o.mask[i]
+				}
+				if f.value[i]&mask != o.value[i]&mask {
+					return false
+				}
+			}
+		}
+	}
+	return true
+}
+
+// maskedEqual compares a packet value against a masked match value
+func maskedEqual(value, want, mask []byte) bool {
+	if len(value) != len(want) {
+		return false
+	}
+	for i := range value {
+		v := value[i]
+		if mask != nil {
+			v &= mask[i]
+		}
+		if v != want[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// packetFields locates the match fields of a frame. Fields whose
+// prerequisites are not met are absent and never match.
+type packetFields struct {
+	inPort  [4]byte
+	vlanVID [2]byte
+	raw     []byte
+	ethType []byte
+	l3      []byte
+	l4      []byte
+	proto   byte
+}
+
+// extractFields parses the headers of a frame received on a port
+func extractFields(raw []byte, inPort uint32) *packetFields {
+	p := &packetFields{raw: raw}
+	binary.BigEndian.PutUint32(p.inPort[:], inPort)
+
+	if len(raw) < 14 {
+		return p
+	}
+	p.ethType, p.l3 = raw[12:14], raw[14:]
+	if binary.BigEndian.Uint16(p.ethType) == 0x8100 && len(raw) >= 18 {
+		binary.BigEndian.PutUint16(p.vlanVID[:], binary.BigEndian.Uint16(raw[14:16])&0x0fff|ofpVIDPresent)
+		p.ethType, p.l3 = raw[16:18], raw[18:]
+	}
+
+	switch binary.BigEndian.Uint16(p.ethType) {
+	case EtherTypeIPv4:
+		if len(p.l3) < 20 {
+			p.l3 = nil
+			return p
+		}
+		p.proto = p.l3[9]
+		headerLen := int(p.l3[0]&0x0f) * 4
+		fragmentOffset := binary.BigEndian.Uint16(p.l3[6:8]) & 0x1fff
+		if headerLen >= 20 && len(p.l3) >= headerLen && fragmentOffset == 0 {
+			p.l4 = p.l3[headerLen:]
+		}
+	case EtherTypeARP:
+		if len(p.l3) < 28 {
+			p.l3 = nil
+		}
+	case EtherTypeIPv6:
+		if len(p.l3) < 40 {
+			p.l3 = nil
+			return p
+		}
+		p.proto = p.l3[6]
+		p.l4 = p.l3[40:]
+	default:
+		p.l3 = nil
+	}
+	return p
+}
+
+// get returns the value of a match field, or nil if the frame lacks it
+func (p *packetFields) get(field uint8) []byte {
+	switch field {
+	case oxmInPort:
+		return p.inPort[:]
+	case oxmEthDst:
+		if len(p.raw) >= 14 {
+			return p.raw[0:6]
+		}
+	case oxmEthSrc:
+		if len(p.raw) >= 14 {
+			return p.raw[6:12]
+		}
+	case oxmEthType:
+		return p.ethType
+	case oxmVLANVID:
+		if len(p.raw) >= 14 {
+			return p.vlanVID[:]
+		}
+	}
+
+	if p.l3 == nil {
+		return nil
+	}
+	etherType := binary.BigEndian.Uint16(p.ethType)
+	ip := etherType == EtherTypeIPv4 || etherType == EtherTypeIPv6
+
+	switch field {
+	case oxmIPProto:
+		if ip {
+			return []byte{p.proto}
+		}
+	case oxmIPv4Src:
+		if etherType == EtherTypeIPv4 {
+			return p.l3[12:16]
+		}
+	case oxmIPv4Dst:
+		if etherType == EtherTypeIPv4 {
+			return p.l3[16:20]
+		}
+	case oxmIPv6Src:
+		if etherType == EtherTypeIPv6 {
+			return p.l3[8:24]
+		}
+	case oxmIPv6Dst:
+		if etherType == EtherTypeIPv6 {
+			return p.l3[24:40]
+		}
+	case oxmARPOp:
+		if etherType == EtherTypeARP {
+			return p.l3[6:8]
+		}
+	case oxmARPSHA:
+		if etherType == EtherTypeARP {
+			return p.l3[8:14]
+		}
+	case oxmARPSPA:
+		if etherType == EtherTypeARP {
+			return p.l3[14:18]
+		}
+	case oxmARPTHA:
+		if etherType == EtherTypeARP {
+			return p.l3[18:24]
+		}
+	case oxmARPTPA:
+		if etherType == EtherTypeARP {
+			return p.l3[24:28]
+		}
+	case oxmTCPSrc, oxmTCPDst:
+		if ip && p.proto == ipProtoTCP && len(p.l4) >= 4 {
+			return p.l4[(field-oxmTCPSrc)*2 : (field-oxmTCPSrc)*2+2]
+		}
+	case oxmUDPSrc, oxmUDPDst:
+		if ip && p.proto == ipProtoUDP && len(p.l4) >= 4 {
+			return p.l4[(field-oxmUDPSrc)*2 : (field-oxmUDPSrc)*2+2]
+		}
+	case oxmICMPv4Type, oxmICMPv4Code:
+		if etherType == EtherTypeIPv4 && p.proto == ipProtoICMP && len(p.l4) >= 2 {
+			return p.l4[field-oxmICMPv4Type : field-oxmICMPv4Type+1]
+		}
+	}
+	return nil
+}
+
+// flowEntry is an entry of the flow table
+type flowEntry struct {
+	priority     uint16
+	match        flowMatch
+	cookie       uint64
+	idleTimeout  uint16
+	hardTimeout  uint16
+	flags        uint16
+	instructions []byte // as received, returned in flow statistics
+	actions      []ofpOutput
+	created      time.Time
+
+	packets  atomic.Uint64
+	bytes    atomic.Uint64
+	lastUsed atomic.Int64 // unix nanoseconds
+}
+
+// ofpOutput is an output action
+type ofpOutput struct {
+	port   uint32
+	maxLen uint16
+}
+
+// tableMiss reports whether the entry is the table-miss entry
+func (e *flowEntry) tableMiss() bool {
+	return e.priority == 0 && len(e.match) == 0
+}
+
+// outputsTo reports whether the entry has an output action to port
+func (e *flowEntry) outputsTo(port uint32) bool {
+	for _, action := range e.actions {
+		if action.port == port {
+			return true
+		}
+	}
+	return false
+}
+
+// flowTable is the single flow table of an OpenFlow agent, kept sorted by
+// descending priority
+type flowTable struct {
+	mutex   sync.RWMutex
+	entries []*flowEntry
+
+	lookups atomic.Uint64
+	matched atomic.Uint64
+}
+
+// lookup returns the highest priority entry matching the packet with its
+// actions, and updates its counters
+func (t *flowTable) lookup(fields *packetFields, size int) (*flowEntry, []ofpOutput) {
+	t.lookups.Add(1)
+
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+
+	for _, entry := range t.entries {
+		if entry.match.matches(fields) {
+			t.matched.Add(1)
+			entry.packets.Add(1)
+			entry.bytes.Add(uint64(size))
+			entry.lastUsed.Store(time.Now().UnixNano())
+			return entry, entry.actions
+		}
+	}
+	return nil, nil
+}
+
+// add inserts an entry, replacing one with an identical match and priority.
+// With checkOverlap it refuses entries that overlap another of the same
+// priority.
+func (t *flowTable) add(entry *flowEntry, checkOverlap bool) bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	for i, existing := range t.entries {
+		if existing.priority != entry.priority {
+			continue
+		}
+		if existing.match.equal(entry.match) {
+			t.entries[i] = entry
+			return true
+		}
+		if checkOverlap && existing.match.overlaps(entry.match) {
+			return false
+		}
+	}
+
+	i := sort.Search(len(t.entries), func(i int) bool { return t.entries[i].priority < entry.priority })
+	t.entries = append(t.entries, nil)
+	copy(t.entries[i+1:], t.entries[i:])
+	t.entries[i] = entry
+	return true
+}
+
+// modify replaces the instructions of the entries selected by a flow
+// modification and returns how many were changed
+func (t *flowTable) modify(selector flowSelector, update *flowEntry, resetCounts bool) int {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	modified := 0
+	for _, entry := range t.entries {
+		if !selector.selects(entry) {
+			continue
+		}
+		entry.instructions = update.instructions
+		entry.actions = update.actions
+		if resetCounts {
+			entry.packets.Store(0)
+			entry.bytes.Store(0)
+		}
+		modified++
+	}
+	return modified
+}
+
+// remove deletes the entries selected by a flow modification or a timeout
+// and returns them
+func (t *flowTable) remove(keep func(*flowEntry) bool) []*flowEntry {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	var removed []*flowEntry
+	kept := t.entries[:0]
+	for _, entry := range t.entries {
+		if keep(entry) {
+			kept = append(kept, entry)
+		} else {
+			removed = append(removed, entry)
+		}
+	}
+	clear(t.entries[len(kept):])
+	t.entries = kept
+	return removed
+}
+
+// forEach calls fn with the table locked for each entry a statistics
+// request selects
+func (t *flowTable) forEach(selector flowSelector, fn func(*flowEntry)) {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+
+	for _, entry := range t.entries {
+		if selector.selects(entry) {
+			fn(entry)
+		}
+	}
+}
+
+// len returns the number of entries
+func (t *flowTable) len() int {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	return len(t.entries)
+}
+
+// flowSelector selects flow entries for modification, deletion and
+// statistics the way OpenFlow flow modifications do
+type flowSelector struct {
+	match      flowMatch
+	priority   uint16
+	strict     bool
+	cookie     uint64
+	cookieMask uint64
+	outPort    uint32
+}
+
+// selects reports whether the selector applies to an entry
+func (s flowSelector) selects(entry *flowEntry) bool {
+	if entry.cookie&s.cookieMask != s.cookie&s.cookieMask {
+		return false
+	}
+	if s.outPort != ofppAny && !entry.outputsTo(s.outPort) {
+		return false
+	}
+	if s.strict {
+		return entry.priority == s.priority && entry.match.equal(s.match)
+	}
+	return s.match.covers(entry.match)
+}
@@ -0,0 +1,310 @@
+package vswitch
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+)
+
+// ofTestController plays an OpenFlow controller for a switch's agent
+type ofTestController struct {
+	t      *testing.T
+	conn   net.Conn
+	reader *bufio.Reader
+	xid    uint32
+}
+
+// newOpenFlowTest starts a switch with an OpenFlow agent and accepts its
+// connection after exchanging hellos
+func newOpenFlowTest(t *testing.T) (*VirtualSwitch, *ofTestController) {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer func() { _ = listener.Close() }()
+
+	sw := NewVirtualSwitchWithConfig([]int{8080}, Config{
+		OpenFlow: &OpenFlowConfig{Controller: listener.Addr().String()},
+	})
+	sw.startServices()
+	t.Cleanup(func() {
+		close(sw.shutdown)
+		sw.wg.Wait()
+	})
+
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatalf("Failed to accept agent: %v", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+
+	c := &ofTestController{t: t, conn: conn, reader: bufio.NewReader(conn)}
+	if hello := c.expect(ofptHello); !helloSupportsVersion(hello) {
+		t.Fatalf("Expected hello offering OpenFlow 1.3, got %x", hello)
+	}
+	c.send(ofptHello, nil)
+
+	// The agent is established once it answers a request sent after hello
+	c.send(ofptBarrierRequest, nil)
+	c.expect(ofptBarrierReply)
+
+	return sw, c
+}
+
+// send sends a message to the agent and returns its transaction ID
+func (c *ofTestController) send(msgType uint8, body []byte) uint32 {
+	c.t.Helper()
+
+	c.xid++
+	msg := []byte{ofpVersion, msgType, 0, 0}
+	binary.BigEndian.PutUint16(msg[2:4], uint16(8+len(body)))
+	msg = binary.BigEndian.AppendUint32(msg, c.xid)
+	if _, err := c.conn.Write(append(msg, body...)); err != nil {
+		c.t.Fatalf("Failed to send message: %v", err)
+	}
+	return c.xid
+}
+
+// expect reads messages until one of the given type arrives, skipping echo
+// requests, and returns it with its header
+func (c *ofTestController) expect(msgType uint8) []byte {
+	c.t.Helper()
+
+	_ = c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		msg, err := readOpenFlowMessage(c.reader)
+		if err != nil {
+			c.t.Fatalf("Failed to read message type %d: %v", msgType, err)
+		}
+		if msg[1] == msgType {
+			return msg
+		}
+		if msg[1] != ofptEchoRequest {
+			c.t.Fatalf("Expected message type %d, got %d", msgType, msg[1])
+		}
+	}
+}
+
+// sync waits until the agent has processed all earlier messages
+func (c *ofTestController) sync() {
+	c.t.Helper()
+	c.send(ofptBarrierRequest, nil)
+	c.expect(ofptBarrierReply)
+}
+
+// ofFlowMod builds a flow mod body outputting to ports through apply-actions
+func ofFlowMod(command uint8, priority, flags uint16, match flowMatch, ports ...uint32) []byte {
+	body := make([]byte, 40)
+	body[17] = command
+	binary.BigEndian.PutUint16(body[22:24], priority)
+	binary.BigEndian.PutUint32(body[24:28], ofpNoBuffer)
+	binary.BigEndian.PutUint32(body[28:32], ofppAny)
+	binary.BigEndian.PutUint32(body[32:36], ofppAny)
+	binary.BigEndian.PutUint16(body[36:38], flags)
+	body = appendFlowMatch(body, match)
+
+	if len(ports) > 0 {
+		body = append(body, ofpApplyActions(ports...)...)
+	}
+	return body
+}
+
+// ofpApplyActions builds an apply-actions instruction with output actions
+func ofpApplyActions(ports ...uint32) []byte {
+	actions := ofpOutputActions(ports...)
+	instruction := binary.BigEndian.AppendUint16(nil, ofpitApplyActions)
+	instruction = binary.BigEndian.AppendUint16(instruction, uint16(8+len(actions)))
+	return append(append(instruction, 0, 0, 0, 0), actions...)
+}
+
+// ofpOutputActions builds output actions
+func ofpOutputActions(ports ...uint32) []byte {
+	var actions []byte
+	for _, port := range ports {
+		actions = binary.BigEndian.AppendUint16(actions, ofpatOutput)
+		actions = binary.BigEndian.AppendUint16(actions, 16)
+		actions = binary.BigEndian.AppendUint32(actions, port)
+		actions = binary.BigEndian.AppendUint16(actions, 0xffff)
+		actions = append(actions, 0, 0, 0, 0, 0, 0)
+	}
+	return actions
+}
+
+func TestFlowMatch(t *testing.T) {
+	frame := buildEthernet(net.HardwareAddr{0x52, 0x54, 0, 0, 0, 2}, net.HardwareAddr{0x52, 0x54, 0, 0, 0, 1}, EtherTypeIPv4,
+		buildUDPv4(net.ParseIP("10.0.0.1").To4(), net.ParseIP("10.0.0.2").To4(), 1000, 53, []byte("query")))
+	fields := extractFields(frame, 3)
+
+	encoded := appendFlowMatch(nil, flowMatch{
+		{field: oxmUDPDst, value: []byte{0, 53}},
+		{field: oxmIPv4Dst, value: []byte{10, 0, 0, 0}, mask: []byte{255, 255, 255, 0}},
+		{field: oxmInPort, value: []byte{0, 0, 0, 3}},
+	})
+	match, length, err := parseFlowMatch(encoded)
+	if err != nil || length != len(encoded) || len(match) != 3 || match[0].field != oxmInPort {
+		t.Fatalf("Unexpected match %+v (%d, %v)", match, length, err)
+	}
+	if !match.matches(fields) {
+		t.Errorf("Expected frame to match")
+	}
+	if match.matches(extractFields(frame, 4)) {
+		t.Errorf("Expected other in_port not to match")
+	}
+
+	tcpMatch := flowMatch{{field: oxmTCPDst, value: []byte{0, 53}}}
+	if tcpMatch.matches(fields) {
+		t.Errorf("Expected TCP port not to match a UDP frame")
+	}
+
+	subnet := flowMatch{{field: oxmIPv4Dst, value: []byte{10, 0, 0, 0}, mask: []byte{255, 0, 0, 0}}}
+	if !subnet.covers(match) || match.covers(subnet) {
+		t.Errorf("Expected /8 to cover /24 match and not the reverse")
+	}
+	if !subnet.overlaps(tcpMatch) {
+		t.Errorf("Expected matches on different fields to overlap")
+	}
+
+	if _, _, err := parseFlowMatch(appendFlowMatch(nil, flowMatch{{field: 40, value: []byte{1}}})); err == nil {
+		t.Errorf("Expected unsupported field to be rejected")
+	}
+}
+
+func TestHelloSupportsVersion(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		msg      []byte
+		expected bool
+	}{
+		{"1.3", []byte{0x04, 0x00, 0x00, 0x08, 0x00, 0x00, 0x00, 0x01}, true},
+		{"1.0", []byte{0x01, 0x00, 0x00, 0x08, 0x00, 0x00, 0x00, 0x01}, false},
+		{"bitmap", []byte{0x05, 0x00, 0x00, 0x10, 0x00, 0x00, 0x00, 0x01, 0x00, 0x01, 0x00, 0x08, 0x00, 0x00, 0x00, 0x12}, true},
+		{"bitmap without 1.3", []byte{0x05, 0x00, 0x00, 0x10, 0x00, 0x00, 0x00, 0x01, 0x00, 0x01, 0x00, 0x08, 0x00, 0x00, 0x00, 0x22}, false},
+		{"unpadded element", []byte{0x03, 0x00, 0x00, 0x0c, 0x00, 0x00, 0x00, 0x01, 0x00, 0x02, 0x00, 0x04}, false},
+		{"truncated bitmap", []byte{0x03, 0x00, 0x00, 0x0e, 0x00, 0x00, 0x00, 0x01, 0x00, 0x01, 0x00, 0x08, 0x00, 0x00}, false},
+	} {
+		if got := helloSupportsVersion(tc.msg); got != tc.expected {
+			t.Errorf("%s: expected %v, got %v", tc.name, tc.expected, got)
+		}
+	}
+}
+
+func TestOpenFlowAgent(t *testing.T) {
+	sw, c := newOpenFlowTest(t)
+
+	c.send(ofptFeaturesRequest, nil)
+	if features := c.expect(ofptFeaturesReply); binary.BigEndian.Uint64(features[8:16]) != 8080 {
+		t.Errorf("Expected datapath ID 8080, got %x", features[8:16])
+	}
+
+	guestMACs := []net.HardwareAddr{{0x52, 0x54, 0, 0, 0, 1}, {0x52, 0x54, 0, 0, 0, 2}}
+	var conns []*Connection
+	var sinks []*frameSink
+	for i := range guestMACs {
+		sink := &frameSink{}
+		conn := NewConnection(string(rune('a'+i)), sink)
+		sw.connections.Store(conn.ID, conn)
+		sw.openflow.addPort(conn)
+		if status := c.expect(ofptPortStatus); status[8] != ofpprAdd || binary.BigEndian.Uint32(status[16:20]) != uint32(i+1) {
+			t.Fatalf("Expected port %d to be added, got %x", i+1, status[8:20])
+		}
+		conns = append(conns, conn)
+		sinks = append(sinks, sink)
+	}
+
+	// Without flow entries frames go to the controller
+	raw := buildEthernet(guestMACs[1], guestMACs[0], EtherTypeIPv4,
+		buildUDPv4(net.ParseIP("10.0.0.1").To4(), net.ParseIP("10.0.0.2").To4(), 1000, 2000, []byte("hello")))
+	_ = sw.processFrame(rawFrame(raw), conns[0])
+	packetIn := c.expect(ofptPacketIn)
+	match, matchLen, err := parseFlowMatch(packetIn[24:])
+	if err != nil || len(match) != 1 || binary.BigEndian.Uint32(match[0].value) != 1 || packetIn[14] != ofprNoMatch {
+		t.Fatalf("Unexpected packet-in match %+v (%v)", match, err)
+	}
+	if data := packetIn[24+matchLen+2:]; !bytes.Equal(data, raw) {
+		t.Errorf("Expected packet-in to carry the frame")
+	}
+
+	// A flow entry forwards to the guest owning the destination MAC
+	c.send(ofptFlowMod, ofFlowMod(ofpfcAdd, 10, ofpffSendFlowRem, flowMatch{{field: oxmEthDst, value: guestMACs[1]}}, 2))
+	c.sync()
+	_ = sw.processFrame(rawFrame(raw), conns[0])
+	if frame := sinks[1].nextFrame(t); !bytes.Equal(frame.Raw, raw) {
+		t.Errorf("Expected frame to be forwarded to port 2")
+	}
+
+	// Packet-outs are sent on the requested port
+	packetOut := binary.BigEndian.AppendUint32(nil, ofpNoBuffer)
+	packetOut = binary.BigEndian.AppendUint32(packetOut, ofppController)
+	packetOut = binary.BigEndian.AppendUint16(packetOut, 16)
+	packetOut = append(packetOut, 0, 0, 0, 0, 0, 0)
+	packetOut = append(append(packetOut, ofpOutputActions(1)...), raw...)
+	c.send(ofptPacketOut, packetOut)
+	if frame := sinks[0].nextFrame(t); !bytes.Equal(frame.Raw, raw) {
+		t.Errorf("Expected packet-out on port 1")
+	}
+
+	// Flow statistics count the forwarded frame
+	request := binary.BigEndian.AppendUint16(nil, ofpmpFlow)
+	request = append(request, make([]byte, 6)...)
+	request = append(request, ofpttAll, 0, 0, 0)
+	request = binary.BigEndian.AppendUint32(request, ofppAny)
+	request = binary.BigEndian.AppendUint32(request, ofppAny)
+	request = append(request, make([]byte, 20)...)
+	request = appendFlowMatch(request, nil)
+	c.send(ofptMultipartReq, request)
+	reply := c.expect(ofptMultipartReply)
+	if stats := reply[16:]; len(stats) < 48 || binary.BigEndian.Uint64(stats[32:40]) != 1 {
+		t.Errorf("Expected one flow with one packet, got %x", stats)
+	}
+
+	// Unsupported instructions are refused
+	gotoTable := ofFlowMod(ofpfcAdd, 20, 0, nil)
+	gotoTable = append(gotoTable, 0, 1, 0, 8, 1, 0, 0, 0)
+	c.send(ofptFlowMod, gotoTable)
+	if errMsg := c.expect(ofptError); binary.BigEndian.Uint16(errMsg[8:10]) != ofpetBadInstruction {
+		t.Errorf("Expected bad instruction error, got %x", errMsg[8:12])
+	}
+
+	// Deleting the entry reports it removed
+	c.send(ofptFlowMod, ofFlowMod(ofpfcDeleteStrict, 10, 0, flowMatch{{field: oxmEthDst, value: guestMACs[1]}}))
+	if removed := c.expect(ofptFlowRemoved); removed[18] != ofprrDelete || binary.BigEndian.Uint64(removed[32:40]) != 1 {
+		t.Errorf("Unexpected flow removed %x", removed[8:48])
+	}
+	if sw.openflow.table.len() != 0 {
+		t.Errorf("Expected empty flow table")
+	}
+
+	sw.cleanupConnection(conns[1])
+	if status := c.expect(ofptPortStatus); status[8] != ofpprDelete {
+		t.Errorf("Expected port to be deleted")
+	}
+}
+
+func TestOpenFlowExpiry(t *testing.T) {
+	agent := newOpenFlowAgent(NewVirtualSwitch([]int{8080}), &OpenFlowConfig{Controller: "127.0.0.1:1"})
+
+	now := time.Now()
+	for i, timeouts := range [][2]uint16{{1, 0}, {0, 5}, {0, 0}} {
+		entry := &flowEntry{priority: uint16(i), idleTimeout: timeouts[0], hardTimeout: timeouts[1], created: now}
+		entry.lastUsed.Store(now.UnixNano())
+		agent.table.add(entry, false)
+	}
+
+	agent.expireFlows(now.Add(500 * time.Millisecond))
+	if agent.table.len() != 3 {
+		t.Errorf("Expected no entries to expire yet")
+	}
+	agent.expireFlows(now.Add(2 * time.Second))
+	if agent.table.len() != 2 {
+		t.Errorf("Expected idle entry to expire")
+	}
+	agent.expireFlows(now.Add(6 * time.Second))
+	if agent.table.len() != 1 {
+		t.Errorf("Expected hard timeout entry to expire")
+	}
+}
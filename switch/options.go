@@ -0,0 +1,337 @@
+package vswitch
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// Options configures a switch created with New
+type Options struct {
+	// Ports are the VLANs to create and start, one per TCP port
+	Ports []int
+
+	// Config is the configuration of the VLANs without an entry in VLANs
+	// and of VLANs added later through the manager. Nil uses DefaultConfig.
+	Config *Config
+
+	// VLANs holds the configuration of individual VLANs by port
+	VLANs map[int]Config
+
+	// ExtraPorts holds, by port of a VLAN of Ports, more ports feeding the
+	// same VLAN, so that clients with different transport constraints
+	// share its broadcast domain
+	ExtraPorts map[int][]int
+
+	// Listeners holds listeners the caller opened, by port; each port of
+	// Ports or ExtraPorts with one accepts its connections there instead
+	// of opening a TCP socket, e.g. to mix unix and TCP clients in a VLAN
+	Listeners map[int]net.Listener
+
+	// Instance names the switch in its statistics
+	Instance string
+
+	// AllowDegraded keeps the VLANs that started running when others fail
+	// to, instead of failing New. The failed VLANs stay in the manager and
+	// report why in their health.
+	AllowDegraded bool
+}
+
+// Option changes the configuration of every VLAN of a switch created with
+// New, on top of Options
+type Option func(*Config)
+
+// WithLogger sends the switch's log records to logger instead of
+// discarding them
+func WithLogger(logger *slog.Logger) Option {
+	return func(c *Config) { c.Logger = logger }
+}
+
+// WithMTU sets the largest frame payload accepted from connections
+func WithMTU(mtu int) Option {
+	return func(c *Config) { c.MTU = mtu }
+}
+
+// WithMACTimeout sets how long learned MAC addresses are kept
+func WithMACTimeout(timeout time.Duration) Option {
+	return func(c *Config) { c.MACTimeout = timeout }
+}
+
+// WithMACTableSize caps the number of MAC addresses each VLAN learns,
+// evicting the least recently used ones beyond it
+func WithMACTableSize(size int) Option {
+	return func(c *Config) { c.MACTableSize = size }
+}
+
+// WithErrorHandler passes the failures of running VLANs to handler
+func WithErrorHandler(handler func(vlan int, err error)) Option {
+	return func(c *Config) { c.ErrorHandler = handler }
+}
+
+// WithBindRetry retries binding ports in use for up to timeout
+func WithBindRetry(timeout time.Duration) Option {
+	return func(c *Config) { c.BindRetry = timeout }
+}
+
+// WithListener opens the listener of each VLAN's port with listen instead
+// of a TCP socket
+func WithListener(listen ListenFunc) Option {
+	return func(c *Config) { c.Listen = listen }
+}
+
+// WithInMemory keeps every VLAN off the network; connections join through
+// Dial and Attach only
+func WithInMemory() Option {
+	return func(c *Config) { c.InMemory = true }
+}
+
+// WithEgressQueue sets the depth of each connection's egress queue and
+// what happens to frames when it is full
+func WithEgressQueue(size int, policy SlowConsumerPolicy, timeout time.Duration) Option {
+	return func(c *Config) {
+		c.EgressQueueSize = size
+		c.SlowConsumerPolicy = policy
+		c.SlowConsumerTimeout = timeout
+	}
+}
+
+// WithDrainFIN half-closes connections after draining them on Shutdown
+func WithDrainFIN() Option {
+	return func(c *Config) { c.DrainFIN = true }
+}
+
+// WithWorkers sets the number of forwarding workers of each VLAN
+func WithWorkers(workers int) Option {
+	return func(c *Config) { c.Workers = workers }
+}
+
+// WithListenFamily selects the IP versions the VLANs listen on
+func WithListenFamily(family AddressFamily) Option {
+	return func(c *Config) { c.ListenFamily = family }
+}
+
+// WithSocketOptions tunes the TCP sockets of the VLANs
+func WithSocketOptions(options SocketOptions) Option {
+	return func(c *Config) { c.Socket = options }
+}
+
+// WithPadding pads frames sent to connections to the Ethernet minimum
+func WithPadding() Option {
+	return func(c *Config) { c.PadFrames = true }
+}
+
+// WithValidation sets which frame checks drop frames
+func WithValidation(mode ValidationMode) Option {
+	return func(c *Config) { c.Validation = mode }
+}
+
+// WithHorizonGroup places connections from the peers in a split horizon
+// group
+func WithHorizonGroup(name string, peers ...*net.IPNet) Option {
+	return func(c *Config) {
+		c.HorizonGroups = append(c.HorizonGroups, HorizonGroup{Name: name, Peers: peers})
+	}
+}
+
+// WithTrunkBUMLimits caps the flooded frames sent to each trunk link
+func WithTrunkBUMLimits(limits BUMLimits) Option {
+	return func(c *Config) { c.TrunkBUMLimits = limits }
+}
+
+// WithBandwidth shapes the traffic each VLAN forwards
+func WithBandwidth(limit BandwidthLimit) Option {
+	return func(c *Config) { c.Bandwidth = limit }
+}
+
+// WithDropUnknownUnicast drops unicast frames to unlearned destinations
+// instead of flooding them
+func WithDropUnknownUnicast() Option {
+	return func(c *Config) { c.DropUnknownUnicast = true }
+}
+
+// WithARPSuppression answers ARP requests for learned addresses instead
+// of flooding them
+func WithARPSuppression() Option {
+	return func(c *Config) { c.ARPSuppression = true }
+}
+
+// WithMACMoveAnnounce announces MACs moving to another connection to the
+// rest of the VLAN
+func WithMACMoveAnnounce() Option {
+	return func(c *Config) { c.MACMoveAnnounce = true }
+}
+
+// WithLLDP advertises the switch port of each connection with LLDP
+func WithLLDP() Option {
+	return func(c *Config) { c.LLDP = true }
+}
+
+// WithProtocolStats breaks the traffic of each VLAN down by protocol,
+// decoding one in rate received frames
+func WithProtocolStats(rate int) Option {
+	return func(c *Config) { c.ProtocolStats = rate }
+}
+
+// WithMACEventHandler calls handler when a VLAN's MAC table changes
+func WithMACEventHandler(handler func(MACEvent)) Option {
+	return func(c *Config) { c.MACEventHandler = handler }
+}
+
+// WithIngressHook appends a hook run on every frame received by a VLAN
+func WithIngressHook(hook FrameHook) Option {
+	return func(c *Config) { c.IngressHooks = append(c.IngressHooks, hook) }
+}
+
+// WithEgressHook appends a hook run on every frame sent by a VLAN
+func WithEgressHook(hook FrameHook) Option {
+	return func(c *Config) { c.EgressHooks = append(c.EgressHooks, hook) }
+}
+
+// New creates a switch manager for a program embedding the switch, starts
+// the VLANs of options.Ports and stops them all when ctx is done. It
+// returns an error instead of a partially started switch, unless
+// options.AllowDegraded is set.
+func New(ctx context.Context, options Options, opts ...Option) (*SwitchManager, error) {
+	config := DefaultConfig()
+	if options.Config != nil {
+		config = *options.Config
+	}
+	for _, opt := range opts {
+		opt(&config)
+	}
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+
+	for port := range options.ExtraPorts {
+		if !slices.Contains(options.Ports, port) {
+			return nil, fmt.Errorf("extra ports for port %d, which is not in Ports", port)
+		}
+	}
+	for port := range options.Listeners {
+		if !slices.Contains(options.Ports, port) && !slices.ContainsFunc(options.Ports, func(vlan int) bool {
+			return slices.Contains(options.ExtraPorts[vlan], port)
+		}) {
+			return nil, fmt.Errorf("listener for port %d, which is not in Ports", port)
+		}
+	}
+
+	sm := NewSwitchManager()
+	sm.SetDefaultConfig(config)
+	sm.SetInstance(options.Instance)
+
+	for _, port := range options.Ports {
+		vlanConfig, found := options.VLANs[port]
+		if !found {
+			vlanConfig = config
+		} else {
+			for _, opt := range opts {
+				opt(&vlanConfig)
+			}
+			if err := vlanConfig.validate(); err != nil {
+				return nil, fmt.Errorf("VLAN %d: %v", port, err)
+			}
+		}
+		ports := append([]int{port}, options.ExtraPorts[port]...)
+		if slices.ContainsFunc(ports, func(p int) bool { _, found := options.Listeners[p]; return found }) {
+			vlanConfig.Listen = listenersOf(options.Listeners, vlanConfig.ListenFamily)
+		}
+		if err := sm.AddVLANWithPorts(ports, vlanConfig); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := sm.StartAll(ctx); err != nil {
+		if !options.AllowDegraded {
+			sm.StopAll()
+			return nil, err
+		}
+		sm.logger().Warn("Running degraded", "error", err)
+	}
+
+	go func() {
+		<-ctx.Done()
+		sm.StopAll()
+	}()
+	return sm, nil
+}
+
+// listenersOf returns a ListenFunc serving the ports with a listener in
+// listeners there, and the others on a TCP socket of the address family
+func listenersOf(listeners map[int]net.Listener, family AddressFamily) ListenFunc {
+	return func(port int) (net.Listener, error) {
+		if listener, found := listeners[port]; found {
+			return listener, nil
+		}
+		return Listen(family.network(), ":"+strconv.Itoa(port))
+	}
+}
+
+// validate checks the limits of a configuration
+func (c Config) validate() error {
+	switch {
+	case c.MTU < 0 || c.MTU > maxMTU:
+		return fmt.Errorf("MTU must be between 0 and %d", maxMTU)
+	case c.MACTimeout < 0:
+		return fmt.Errorf("MAC timeout must not be negative")
+	case c.BindRetry < 0:
+		return fmt.Errorf("bind retry timeout must not be negative")
+	case c.Pipe != "" && !pipesSupported:
+		return fmt.Errorf("named pipes are only supported on Windows")
+	case c.Socket.Listeners < 0:
+		return fmt.Errorf("listeners per port must not be negative")
+	case c.Socket.Listeners > 1 && (c.Listen != nil || !reusePortSupported):
+		return fmt.Errorf("several listeners per port need SO_REUSEPORT sockets opened by the switch on Linux")
+	case c.MACTableSize < 0:
+		return fmt.Errorf("MAC table size must not be negative")
+	case c.EgressQueueSize < 0:
+		return fmt.Errorf("egress queue size must not be negative")
+	case c.SlowConsumerTimeout < 0:
+		return fmt.Errorf("slow consumer timeout must not be negative")
+	case c.Workers < 0:
+		return fmt.Errorf("workers must not be negative")
+	case c.ProtocolStats < 0:
+		return fmt.Errorf("protocol statistics sample rate must not be negative")
+	case c.Socket.SendBuffer < 0 || c.Socket.ReceiveBuffer < 0:
+		return fmt.Errorf("socket buffer sizes must not be negative")
+	case c.Socket.Backlog < 0:
+		return fmt.Errorf("listen backlog must not be negative")
+	case c.ListenFamily < FamilyDual || c.ListenFamily > FamilyIPv6:
+		return fmt.Errorf("unknown address family %d", c.ListenFamily)
+	case c.Validation < ValidationStandard || c.Validation > ValidationPermissive:
+		return fmt.Errorf("unknown validation mode %d", c.Validation)
+	case c.TrunkBUMLimits.Broadcast < 0 || c.TrunkBUMLimits.UnknownUnicast < 0 || c.TrunkBUMLimits.Multicast < 0:
+		return fmt.Errorf("trunk BUM limits must not be negative")
+	case slices.ContainsFunc(c.HorizonGroups, func(group HorizonGroup) bool { return group.Name == "" }):
+		return fmt.Errorf("horizon groups need a name")
+	case c.NativeVID < 0 || c.NativeVID > maxVID:
+		return fmt.Errorf("native VLAN ID must be between 0 and %d", maxVID)
+	case slices.ContainsFunc(c.TagDemuxVIDs, func(vid int) bool { return vid < 1 || vid > maxVID }):
+		return fmt.Errorf("VLAN IDs must be between 1 and %d", maxVID)
+	case !c.TagDemux && (c.NativeVID != 0 || len(c.TagDemuxVIDs) > 0):
+		return fmt.Errorf("VLAN IDs need tag demultiplexing")
+	case c.TagDemux && len(c.ProtocolVLANs) > 0:
+		return fmt.Errorf("protocol VLANs classify untagged frames, not demultiplexed tags")
+	case c.PSK != "" && len(c.PSK) < minPSKLength:
+		return fmt.Errorf("PSK must be at least %d bytes", minPSKLength)
+	case c.PSK != "" && c.Pipe != "":
+		return fmt.Errorf("PSK cannot be used with named pipes, which have no deadlines to time out the challenge")
+	case c.FastPath && !fastPathSupported:
+		return fmt.Errorf("the XDP fast path is only supported on Linux")
+	case c.FastPath && c.fastPathConflict() != "":
+		return fmt.Errorf("the XDP fast path cannot be combined with %s, which must see every frame", c.fastPathConflict())
+	}
+	for _, assignment := range c.ProtocolVLANs {
+		if _, err := assignment.compile(); err != nil {
+			return err
+		}
+	}
+	if err := c.Bandwidth.validate(); err != nil {
+		return err
+	}
+	return c.Impairment.validate()
+}
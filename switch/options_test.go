@@ -0,0 +1,317 @@
+package vswitch
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestNew(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to find a free port: %v", err)
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+	_ = listener.Close()
+
+	var logs bytes.Buffer
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sm, err := New(ctx, Options{Ports: []int{port}, Instance: "embedded"},
+		WithLogger(slog.New(slog.NewTextHandler(&logs, nil))), WithMTU(9000), WithMACTimeout(time.Minute))
+	if err != nil {
+		t.Fatalf("Failed to create switch: %v", err)
+	}
+
+	vs := sm.switches[port]
+	if vs.macTimeout != time.Minute || vs.config.maxFrameSize() != 9018 {
+		t.Errorf("Expected the options to apply, got MAC timeout %v and frame size %d", vs.macTimeout, vs.config.maxFrameSize())
+	}
+	if sm.GetStats()["instance"] != "embedded" {
+		t.Errorf("Expected the instance to be set")
+	}
+
+	// Jumbo frames pass within the MTU
+	conn, err := net.Dial("tcp", "127.0.0.1:"+strconv.Itoa(port))
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer func() { _ = conn.Close() }()
+	frame := make([]byte, 4+9000)
+	binary.BigEndian.PutUint32(frame, 9000)
+	copy(frame[4:], []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x52, 0x54, 0x00, 0x00, 0x00, 0x01, 0x08, 0x00})
+	if _, err := conn.Write(frame); err != nil {
+		t.Fatalf("Failed to send frame: %v", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for vs.lookupMAC(net.HardwareAddr{0x52, 0x54, 0x00, 0x00, 0x00, 0x01}) == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the jumbo frame to be accepted")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Cancelling the context stops the switch, closing its connections
+	cancel()
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err == nil || errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("Expected the switch to stop with its context, got %v", err)
+	}
+	// Stopping again waits for the first stop to finish
+	sm.StopAll()
+
+	if !strings.Contains(logs.String(), "vlan="+strconv.Itoa(port)) {
+		t.Errorf("Expected the logs to go to the given logger, got %q", logs.String())
+	}
+}
+
+func TestNewInvalidOptions(t *testing.T) {
+	if _, err := New(context.Background(), Options{}, WithMTU(20000)); err == nil {
+		t.Error("Expected an MTU beyond jumbo frames to be rejected")
+	}
+	if _, err := New(context.Background(), Options{Ports: []int{9999}, VLANs: map[int]Config{9999: {Workers: -1}}}); err == nil {
+		t.Error("Expected a negative worker count to be rejected")
+	}
+	if _, err := New(context.Background(), Options{}, WithHorizonGroup("")); err == nil {
+		t.Error("Expected a horizon group without a name to be rejected")
+	}
+}
+
+// plainListener hides the deadline support of a listener, like listeners
+// wrapping it in TLS
+type plainListener struct {
+	net.Listener
+}
+
+func TestListenFunc(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+
+	var opened []int
+	sw := NewVirtualSwitchWithConfig([]int{9999}, Config{Listen: func(port int) (net.Listener, error) {
+		opened = append(opened, port)
+		return plainListener{listener}, nil
+	}})
+	if err := sw.Start(context.Background()); err != nil {
+		t.Fatalf("Failed to start switch: %v", err)
+	}
+	if len(opened) != 1 || opened[0] != 9999 {
+		t.Errorf("Expected the listener of port 9999 to be opened, got %v", opened)
+	}
+	if health := sw.Health(); !health.Ready {
+		t.Errorf("Expected the VLAN to be ready, got %+v", health)
+	}
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer func() { _ = conn.Close() }()
+	waitUntil(t, "the client is connected", func() bool { return sw.connectionCount() == 1 })
+
+	// Stopping unblocks the accept loop of a listener without deadlines
+	stopped := make(chan struct{})
+	go func() {
+		sw.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out stopping the switch")
+	}
+
+	if _, err := New(context.Background(), Options{Listeners: map[int]net.Listener{9999: listener}}); err == nil {
+		t.Error("Expected a listener for a port without a VLAN to be rejected")
+	}
+}
+
+func TestStartBindFailure(t *testing.T) {
+	free, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatalf("Failed to find a free port: %v", err)
+	}
+	freePort := free.Addr().(*net.TCPAddr).Port
+	_ = free.Close()
+	busy, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatalf("Failed to occupy a port: %v", err)
+	}
+	defer func() { _ = busy.Close() }()
+	busyPort := busy.Addr().(*net.TCPAddr).Port
+
+	// The port in use fails the start, which closes the other listener
+	sw := NewVirtualSwitch([]int{freePort, busyPort})
+	err = sw.Start(context.Background())
+	if err == nil || !strings.Contains(err.Error(), strconv.Itoa(busyPort)) {
+		t.Fatalf("Expected the bind failure of port %d, got %v", busyPort, err)
+	}
+	if conn, err := net.Dial("tcp", "127.0.0.1:"+strconv.Itoa(freePort)); err == nil {
+		_ = conn.Close()
+		t.Errorf("Expected the listener of port %d to be closed", freePort)
+	}
+	if health := sw.Health(); health.Ready || health.Listening != 0 {
+		t.Errorf("Expected a VLAN that failed to start not to be ready, got %+v", health)
+	}
+}
+
+func TestListenersValidation(t *testing.T) {
+	listen := func(int) (net.Listener, error) { return nil, syscall.EADDRINUSE }
+	if err := (Config{Listen: listen, Socket: SocketOptions{Listeners: 2}}).validate(); err == nil {
+		t.Errorf("Expected an error for several listeners from a Listen function")
+	}
+	if err := (Config{Socket: SocketOptions{Listeners: -1}}).validate(); err == nil {
+		t.Errorf("Expected an error for a negative number of listeners")
+	}
+}
+
+func TestPipeValidation(t *testing.T) {
+	if err := (Config{Pipe: "vswitch-9999"}).validate(); (err == nil) != pipesSupported {
+		t.Errorf("Expected named pipes to be valid only where supported, got %v", err)
+	}
+}
+
+func TestBindRetry(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer func() { _ = listener.Close() }()
+
+	// The port is released by its previous owner after a few attempts
+	var attempts atomic.Int32
+	sw := NewVirtualSwitchWithConfig([]int{9999}, Config{
+		BindRetry: 10 * time.Second,
+		Listen: func(int) (net.Listener, error) {
+			if attempts.Add(1) <= 3 {
+				return nil, syscall.EADDRINUSE
+			}
+			return listener, nil
+		},
+	})
+	if err := sw.Start(context.Background()); err != nil {
+		t.Fatalf("Expected the start to retry the bind, got %v", err)
+	}
+	defer sw.Stop()
+
+	stats := sw.GetStats()
+	if stats["bind_pending"] != int32(1) || stats["bind_failures"].(uint64) < 1 || stats["bind_error"] == nil {
+		t.Errorf("Expected the retry in the statistics, got %v", stats)
+	}
+	if sw.Health().Ready {
+		t.Errorf("Expected the VLAN not to be ready before it binds its port")
+	}
+
+	waitUntil(t, "the port is bound", func() bool { return sw.Health().Ready })
+	stats = sw.GetStats()
+	if stats["bind_pending"] != int32(0) || stats["bind_failures"] != uint64(3) {
+		t.Errorf("Expected the retry to be over after 3 failures, got %v", stats)
+	}
+	if _, found := stats["bind_error"]; found {
+		t.Errorf("Expected no bind error once bound, got %v", stats["bind_error"])
+	}
+
+	// A port that stays in use fails the VLAN once the retries run out
+	failures := make(chan error, 1)
+	busy := NewVirtualSwitchWithConfig([]int{9998}, Config{
+		BindRetry:    300 * time.Millisecond,
+		Listen:       func(int) (net.Listener, error) { return nil, syscall.EADDRINUSE },
+		ErrorHandler: func(_ int, err error) { failures <- err },
+	})
+	if err := busy.Start(context.Background()); err != nil {
+		t.Fatalf("Expected the start to retry the bind, got %v", err)
+	}
+	defer busy.Stop()
+	select {
+	case err := <-failures:
+		if !errors.Is(err, syscall.EADDRINUSE) {
+			t.Errorf("Expected the bind failure, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the retries to run out")
+	}
+	if health := busy.Health(); health.Ready || len(health.Problems) == 0 {
+		t.Errorf("Expected the VLAN to report the bind failure, got %+v", health)
+	}
+}
+
+func TestStartContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	sw := NewVirtualSwitchWithConfig([]int{9999}, Config{InMemory: true})
+	if err := sw.Start(ctx); err != nil {
+		t.Fatalf("Failed to start switch: %v", err)
+	}
+	guest := sw.Dial("guest")
+	waitUntil(t, "the guest is connected", func() bool { return sw.connectionCount() == 1 })
+
+	// Cancelling the context stops the VLAN, closing its connections
+	cancel()
+	_ = guest.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := guest.Read(make([]byte, 1)); err == nil || errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("Expected the VLAN to stop with its context, got %v", err)
+	}
+
+	if err := NewVirtualSwitch([]int{9999}).Start(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected a done context to fail the start, got %v", err)
+	}
+}
+
+func TestErrorHandler(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+
+	failures := make(chan error, 1)
+	sw := NewVirtualSwitchWithConfig([]int{9999}, Config{
+		Listen:       func(int) (net.Listener, error) { return plainListener{listener}, nil },
+		ErrorHandler: func(vlan int, err error) { failures <- fmt.Errorf("VLAN %d: %w", vlan, err) },
+	})
+	if err := sw.Start(context.Background()); err != nil {
+		t.Fatalf("Failed to start switch: %v", err)
+	}
+	defer sw.Stop()
+
+	// The listener dies under the running switch
+	_ = listener.Close()
+	select {
+	case err := <-failures:
+		if !strings.HasPrefix(err.Error(), "VLAN 9999: listener on port 9999") {
+			t.Errorf("Expected the listener failure, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the failure")
+	}
+	waitUntil(t, "the VLAN is no longer ready", func() bool { return !sw.Health().Ready })
+}
+
+func TestConnectionMTU(t *testing.T) {
+	frame := make([]byte, 4+1600)
+	binary.BigEndian.PutUint32(frame, 1600)
+	copy(frame[4:], []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x52, 0x54, 0x00, 0x00, 0x00, 0x01, 0x08, 0x00})
+
+	conn := NewConnection("guest", &mockConnSwitch{readData: frame})
+	if _, err := conn.ReadFrame(); err == nil {
+		t.Error("Expected a frame beyond the default MTU to be rejected")
+	}
+
+	vs := NewVirtualSwitchWithConfig([]int{9999}, Config{MTU: 1600})
+	conn = NewConnection("guest", &mockConnSwitch{readData: frame, addr: &mockAddrSwitch{network: "tcp", address: "127.0.0.1:9001"}})
+	vs.applyPortDefaults(conn)
+	if _, err := conn.ReadFrame(); err != nil {
+		t.Errorf("Expected a frame within the VLAN's MTU to be accepted: %v", err)
+	}
+}
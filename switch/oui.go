@@ -0,0 +1,145 @@
+package vswitch
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+)
+
+// builtinOUIs names the vendors of the MACs most seen on virtual networks:
+// hypervisors, container runtimes and the NICs they emulate
+var builtinOUIs = map[[3]byte]string{
+	{0x52, 0x54, 0x00}: "QEMU/KVM",
+	{0x00, 0x16, 0x3e}: "Xen",
+	{0x00, 0x50, 0x56}: "VMware",
+	{0x00, 0x0c, 0x29}: "VMware",
+	{0x00, 0x05, 0x69}: "VMware",
+	{0x00, 0x1c, 0x14}: "VMware",
+	{0x08, 0x00, 0x27}: "VirtualBox",
+	{0x0a, 0x00, 0x27}: "VirtualBox",
+	{0x00, 0x15, 0x5d}: "Hyper-V",
+	{0x00, 0x1c, 0x42}: "Parallels",
+	{0x02, 0x42, 0xac}: "Docker",
+	{0x00, 0x18, 0x51}: "SWsoft",
+	{0x00, 0x21, 0xf6}: "Oracle VM",
+	{0x00, 0x0f, 0x4b}: "Oracle VM",
+	{0x00, 0x03, 0xff}: "Microsoft Virtual PC",
+	{0x00, 0x1b, 0x21}: "Intel",
+	{0x00, 0x1e, 0x67}: "Intel",
+	{0x3c, 0xfd, 0xfe}: "Intel",
+	{0xa0, 0x36, 0x9f}: "Intel",
+	{0x00, 0x0d, 0x3a}: "Microsoft Azure",
+	{0x42, 0x01, 0x0a}: "Google Cloud",
+	{0x00, 0xe0, 0x4c}: "Realtek",
+	{0x00, 0x00, 0x0c}: "Cisco",
+	{0x00, 0x1c, 0x73}: "Arista",
+	{0x0c, 0xc4, 0x7a}: "Supermicro",
+	{0xb8, 0x27, 0xeb}: "Raspberry Pi",
+	{0xdc, 0xa6, 0x32}: "Raspberry Pi",
+	{0xe4, 0x5f, 0x01}: "Raspberry Pi",
+	{0xf0, 0x18, 0x98}: "Apple",
+	{0xac, 0xde, 0x48}: "Apple",
+	{0x00, 0x1b, 0x63}: "Apple",
+}
+
+// loadedOUIs holds the vendors of the OUI database loaded with
+// LoadOUIFile, which take precedence over the built-in ones
+var loadedOUIs struct {
+	mutex   sync.RWMutex
+	vendors map[[3]byte]string
+}
+
+// LookupVendor returns the vendor of the OUI a MAC address starts with, or
+// "" if it is unknown
+func LookupVendor(mac net.HardwareAddr) string {
+	if len(mac) < 3 {
+		return ""
+	}
+	oui := [3]byte{mac[0], mac[1], mac[2]}
+
+	loadedOUIs.mutex.RLock()
+	vendor, found := loadedOUIs.vendors[oui]
+	loadedOUIs.mutex.RUnlock()
+	if found {
+		return vendor
+	}
+	return builtinOUIs[oui]
+}
+
+// LoadOUIFile loads an OUI database, either Wireshark's manuf file or the
+// IEEE's oui.txt, to name the vendors of MAC addresses beyond the built-in
+// ones. It returns the number of vendors loaded.
+func LoadOUIFile(path string) (int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	vendors, err := parseOUIs(file)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %v", path, err)
+	}
+	loadedOUIs.mutex.Lock()
+	loadedOUIs.vendors = vendors
+	loadedOUIs.mutex.Unlock()
+	return len(vendors), nil
+}
+
+// parseOUIs reads the 24-bit prefixes of an OUI database. Lines of the
+// manuf format are "52:54:00<tab>QEMU<tab>QEMU virtual NIC" and those of
+// oui.txt "52-54-00   (hex)<tabs>QEMU"; other lines, comments and longer
+// prefixes are skipped.
+func parseOUIs(r io.Reader) (map[[3]byte]string, error) {
+	vendors := make(map[[3]byte]string)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		var prefix, vendor string
+		if before, after, found := strings.Cut(line, "(hex)"); found {
+			prefix, vendor = strings.TrimSpace(before), strings.TrimSpace(after)
+		} else {
+			fields := strings.Split(line, "\t")
+			if len(fields) < 2 {
+				continue
+			}
+			prefix, vendor = fields[0], strings.TrimSpace(fields[len(fields)-1])
+		}
+
+		oui, ok := parseOUI(prefix)
+		if !ok || vendor == "" {
+			continue
+		}
+		vendors[oui] = vendor
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(vendors) == 0 {
+		return nil, fmt.Errorf("no OUI found")
+	}
+	return vendors, nil
+}
+
+// parseOUI parses a 24-bit prefix written as 52:54:00 or 52-54-00
+func parseOUI(prefix string) ([3]byte, bool) {
+	var oui [3]byte
+	prefix = strings.ReplaceAll(prefix, "-", ":")
+	if len(prefix) != 8 {
+		return oui, false
+	}
+	mac, err := net.ParseMAC(prefix + ":00:00:00")
+	if err != nil {
+		return oui, false
+	}
+	copy(oui[:], mac)
+	return oui, true
+}
@@ -0,0 +1,85 @@
+package vswitch
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLookupVendor(t *testing.T) {
+	for mac, expected := range map[string]string{
+		"52:54:00:12:34:56": "QEMU/KVM",
+		"00:50:56:aa:bb:cc": "VMware",
+		"02:00:00:00:00:01": "",
+	} {
+		parsed, _ := net.ParseMAC(mac)
+		if vendor := LookupVendor(parsed); vendor != expected {
+			t.Errorf("Expected %s to be %q, got %q", mac, expected, vendor)
+		}
+	}
+	if vendor := LookupVendor(nil); vendor != "" {
+		t.Errorf("Expected no vendor for an empty MAC, got %q", vendor)
+	}
+}
+
+func TestParseOUIs(t *testing.T) {
+	database := strings.Join([]string{
+		"# Wireshark manuf",
+		"00:00:0C\tCisco\tCisco Systems, Inc",
+		"00:1B:C5:00:00:00/36\tConverging\tConverging Systems Inc.",
+		"",
+		"OUI/MA-L                                                    Organization",
+		"02-00-00   (hex)\t\tExample Labs",
+		"020000     (base 16)\t\tExample Labs",
+	}, "\n")
+	vendors, err := parseOUIs(strings.NewReader(database))
+	if err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+	if len(vendors) != 2 || vendors[[3]byte{0, 0, 0x0c}] != "Cisco Systems, Inc" || vendors[[3]byte{2, 0, 0}] != "Example Labs" {
+		t.Errorf("Unexpected vendors %v", vendors)
+	}
+
+	if _, err := parseOUIs(strings.NewReader("not an OUI database\n")); err == nil {
+		t.Errorf("Expected a file without OUIs to be rejected")
+	}
+}
+
+func TestLoadOUIFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "manuf")
+	if err := os.WriteFile(path, []byte("52:54:00\tQEMU\tQEMU virtual NIC\n02:00:00\tLab\tExample Labs\n"), 0o644); err != nil {
+		t.Fatalf("Failed to write the database: %v", err)
+	}
+	t.Cleanup(func() { loadedOUIs.vendors = nil })
+
+	if loaded, err := LoadOUIFile(path); err != nil || loaded != 2 {
+		t.Fatalf("Expected 2 vendors loaded, got %d, %v", loaded, err)
+	}
+
+	// Loaded vendors take precedence over the built-in ones
+	sw := NewVirtualSwitch([]int{8080})
+	conn := NewConnection("conn1", &frameSink{})
+	sw.learnMAC(net.HardwareAddr{0x52, 0x54, 0x00, 0, 0, 1}, conn)
+	sw.learnMAC(net.HardwareAddr{0x02, 0x00, 0x00, 0, 0, 1}, conn)
+	sw.learnMAC(net.HardwareAddr{0x00, 0x50, 0x56, 0, 0, 1}, conn)
+	vendors := make(map[string]string)
+	for _, entry := range sw.GetMACTable() {
+		vendors[entry.MAC] = entry.Vendor
+	}
+	expected := map[string]string{
+		"52:54:00:00:00:01": "QEMU virtual NIC",
+		"02:00:00:00:00:01": "Example Labs",
+		"00:50:56:00:00:01": "VMware",
+	}
+	for mac, vendor := range expected {
+		if vendors[mac] != vendor {
+			t.Errorf("Expected %s to be %q, got %q", mac, vendor, vendors[mac])
+		}
+	}
+
+	if _, err := LoadOUIFile(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Errorf("Expected a missing file to be rejected")
+	}
+}
@@ -0,0 +1,339 @@
+package vswitch
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+)
+
+// EtherType values understood by the switch
+const (
+	EtherTypeIPv4 uint16 = 0x0800
+	EtherTypeARP  uint16 = 0x0806
+	EtherTypeRARP uint16 = 0x8035
+	EtherTypeIPv6 uint16 = 0x86dd
+	EtherTypeVLAN uint16 = 0x8100
+	EtherTypeQinQ uint16 = 0x88a8
+	EtherTypeLLDP uint16 = 0x88cc
+)
+
+// IP protocol numbers
+const (
+	ipProtoTCP    = 6
+	ipProtoUDP    = 17
+	ipProtoICMPv6 = 58
+)
+
+// TCP header flags
+const (
+	tcpFIN = 0x01
+	tcpSYN = 0x02
+	tcpRST = 0x04
+	tcpPSH = 0x08
+	tcpACK = 0x10
+)
+
+// ipv4Packet is a parsed IPv4 packet
+type ipv4Packet struct {
+	Src            net.IP
+	Dst            net.IP
+	Protocol       uint8
+	TTL            uint8
+	FragmentOffset uint16
+	MoreFragments  bool
+	Payload        []byte
+}
+
+// parseIPv4 parses an IPv4 packet, skipping any header options
+func parseIPv4(data []byte) (*ipv4Packet, error) {
+	if len(data) < 20 {
+		return nil, fmt.Errorf("IPv4 packet too short: %d bytes", len(data))
+	}
+
+	if data[0]>>4 != 4 {
+		return nil, fmt.Errorf("not an IPv4 packet: version %d", data[0]>>4)
+	}
+
+	headerLen := int(data[0]&0x0f) * 4
+	totalLen := int(binary.BigEndian.Uint16(data[2:4]))
+	if headerLen < 20 || totalLen < headerLen || totalLen > len(data) {
+		return nil, fmt.Errorf("invalid IPv4 lengths: header %d, total %d, available %d", headerLen, totalLen, len(data))
+	}
+
+	flagsFragment := binary.BigEndian.Uint16(data[6:8])
+
+	return &ipv4Packet{
+		Src:            net.IP(data[12:16]),
+		Dst:            net.IP(data[16:20]),
+		Protocol:       data[9],
+		TTL:            data[8],
+		FragmentOffset: flagsFragment & 0x1fff,
+		MoreFragments:  flagsFragment&0x2000 != 0,
+		Payload:        data[headerLen:totalLen],
+	}, nil
+}
+
+// buildIPv4 serializes an IPv4 packet with a 20-byte header
+func buildIPv4(src, dst net.IP, protocol, ttl uint8, payload []byte) []byte {
+	packet := make([]byte, 20+len(payload))
+	packet[0] = 0x45
+	binary.BigEndian.PutUint16(packet[2:4], uint16(len(packet)))
+	packet[8] = ttl
+	packet[9] = protocol
+	copy(packet[12:16], src.To4())
+	copy(packet[16:20], dst.To4())
+	binary.BigEndian.PutUint16(packet[10:12], checksumFinish(checksumAdd(0, packet[:20])))
+	copy(packet[20:], payload)
+	return packet
+}
+
+// udpDatagram is a parsed UDP datagram
+type udpDatagram struct {
+	SrcPort uint16
+	DstPort uint16
+	Payload []byte
+}
+
+// parseUDP parses a UDP datagram
+func parseUDP(data []byte) (*udpDatagram, error) {
+	if len(data) < 8 {
+		return nil, fmt.Errorf("UDP datagram too short: %d bytes", len(data))
+	}
+
+	length := int(binary.BigEndian.Uint16(data[4:6]))
+	if length < 8 || length > len(data) {
+		return nil, fmt.Errorf("invalid UDP length %d with %d bytes available", length, len(data))
+	}
+
+	return &udpDatagram{
+		SrcPort: binary.BigEndian.Uint16(data[0:2]),
+		DstPort: binary.BigEndian.Uint16(data[2:4]),
+		Payload: data[8:length],
+	}, nil
+}
+
+// buildUDPv4 serializes a UDP datagram inside an IPv4 packet
+func buildUDPv4(src, dst net.IP, srcPort, dstPort uint16, payload []byte) []byte {
+	datagram := make([]byte, 8+len(payload))
+	binary.BigEndian.PutUint16(datagram[0:2], srcPort)
+	binary.BigEndian.PutUint16(datagram[2:4], dstPort)
+	binary.BigEndian.PutUint16(datagram[4:6], uint16(len(datagram)))
+	copy(datagram[8:], payload)
+
+	checksum := ipv4Checksum(src, dst, ipProtoUDP, datagram)
+	if checksum == 0 {
+		checksum = 0xffff
+	}
+	binary.BigEndian.PutUint16(datagram[6:8], checksum)
+
+	return buildIPv4(src, dst, ipProtoUDP, 64, datagram)
+}
+
+// ipv4Checksum computes an upper-layer checksum including the IPv4 pseudo header
+func ipv4Checksum(src, dst net.IP, protocol uint8, upper []byte) uint16 {
+	pseudo := make([]byte, 12)
+	copy(pseudo[0:4], src.To4())
+	copy(pseudo[4:8], dst.To4())
+	pseudo[9] = protocol
+	binary.BigEndian.PutUint16(pseudo[10:12], uint16(len(upper)))
+
+	return checksumFinish(checksumAdd(checksumAdd(0, pseudo), upper))
+}
+
+// ipv6Packet is a parsed IPv6 packet without extension header processing
+type ipv6Packet struct {
+	Src        net.IP
+	Dst        net.IP
+	NextHeader uint8
+	HopLimit   uint8
+	Payload    []byte
+}
+
+// parseIPv6 parses the fixed IPv6 header of a packet
+func parseIPv6(data []byte) (*ipv6Packet, error) {
+	if len(data) < 40 {
+		return nil, fmt.Errorf("IPv6 packet too short: %d bytes", len(data))
+	}
+
+	if data[0]>>4 != 6 {
+		return nil, fmt.Errorf("not an IPv6 packet: version %d", data[0]>>4)
+	}
+
+	payloadLen := int(binary.BigEndian.Uint16(data[4:6]))
+	if 40+payloadLen > len(data) {
+		return nil, fmt.Errorf("IPv6 payload truncated: %d of %d bytes", len(data)-40, payloadLen)
+	}
+
+	return &ipv6Packet{
+		Src:        net.IP(data[8:24]),
+		Dst:        net.IP(data[24:40]),
+		NextHeader: data[6],
+		HopLimit:   data[7],
+		Payload:    data[40 : 40+payloadLen],
+	}, nil
+}
+
+// buildIPv6 serializes an IPv6 packet, filling in the upper-layer checksum
+// at checksumOffset within the payload
+func buildIPv6(src, dst net.IP, nextHeader, hopLimit uint8, payload []byte, checksumOffset int) []byte {
+	packet := make([]byte, 40+len(payload))
+	packet[0] = 0x60
+	binary.BigEndian.PutUint16(packet[4:6], uint16(len(payload)))
+	packet[6] = nextHeader
+	packet[7] = hopLimit
+	copy(packet[8:24], src.To16())
+	copy(packet[24:40], dst.To16())
+	copy(packet[40:], payload)
+
+	upper := packet[40:]
+	upper[checksumOffset] = 0
+	upper[checksumOffset+1] = 0
+	binary.BigEndian.PutUint16(upper[checksumOffset:], ipv6Checksum(src, dst, nextHeader, upper))
+
+	return packet
+}
+
+// ipv6Checksum computes an upper-layer checksum including the IPv6 pseudo header
+func ipv6Checksum(src, dst net.IP, nextHeader uint8, upper []byte) uint16 {
+	pseudo := make([]byte, 40)
+	copy(pseudo[0:16], src.To16())
+	copy(pseudo[16:32], dst.To16())
+	binary.BigEndian.PutUint32(pseudo[32:36], uint32(len(upper)))
+	pseudo[39] = nextHeader
+
+	return checksumFinish(checksumAdd(checksumAdd(0, pseudo), upper))
+}
+
+// checksumAdd accumulates data into a ones' complement sum
+func checksumAdd(sum uint32, data []byte) uint32 {
+	for i := 0; i+1 < len(data); i += 2 {
+		sum += uint32(data[i])<<8 | uint32(data[i+1])
+	}
+	if len(data)%2 == 1 {
+		sum += uint32(data[len(data)-1]) << 8
+	}
+	return sum
+}
+
+// checksumFinish folds a ones' complement sum into a 16-bit checksum
+func checksumFinish(sum uint32) uint16 {
+	for sum>>16 != 0 {
+		sum = (sum & 0xffff) + (sum >> 16)
+	}
+	return ^uint16(sum)
+}
+
+// buildEthernet serializes an Ethernet II frame
+func buildEthernet(dst, src net.HardwareAddr, etherType uint16, payload []byte) []byte {
+	frame := make([]byte, 14+len(payload))
+	copy(frame[0:6], dst)
+	copy(frame[6:12], src)
+	binary.BigEndian.PutUint16(frame[12:14], etherType)
+	copy(frame[14:], payload)
+	return frame
+}
+
+// ipv6MulticastMAC returns the Ethernet address an IPv6 multicast group maps to
+func ipv6MulticastMAC(group net.IP) net.HardwareAddr {
+	ip := group.To16()
+	return net.HardwareAddr{0x33, 0x33, ip[12], ip[13], ip[14], ip[15]}
+}
+
+// linkLocalFromMAC derives the EUI-64 based IPv6 link-local address for a MAC
+func linkLocalFromMAC(mac net.HardwareAddr) net.IP {
+	ip := make(net.IP, 16)
+	ip[0] = 0xfe
+	ip[1] = 0x80
+	ip[8] = mac[0] ^ 0x02
+	ip[9] = mac[1]
+	ip[10] = mac[2]
+	ip[11] = 0xff
+	ip[12] = 0xfe
+	ip[13] = mac[3]
+	ip[14] = mac[4]
+	ip[15] = mac[5]
+	return ip
+}
+
+// tcpSegment is a parsed TCP segment
+type tcpSegment struct {
+	SrcPort uint16
+	DstPort uint16
+	Seq     uint32
+	Ack     uint32
+	Flags   uint8
+	Window  uint16
+	MSS     uint16 // From the MSS option, or 0 if absent
+	Payload []byte
+}
+
+// parseTCP parses a TCP segment, extracting the MSS option if present
+func parseTCP(data []byte) (*tcpSegment, error) {
+	if len(data) < 20 {
+		return nil, fmt.Errorf("TCP segment too short: %d bytes", len(data))
+	}
+
+	headerLen := int(data[12]>>4) * 4
+	if headerLen < 20 || headerLen > len(data) {
+		return nil, fmt.Errorf("invalid TCP header length %d", headerLen)
+	}
+
+	seg := &tcpSegment{
+		SrcPort: binary.BigEndian.Uint16(data[0:2]),
+		DstPort: binary.BigEndian.Uint16(data[2:4]),
+		Seq:     binary.BigEndian.Uint32(data[4:8]),
+		Ack:     binary.BigEndian.Uint32(data[8:12]),
+		Flags:   data[13],
+		Window:  binary.BigEndian.Uint16(data[14:16]),
+		Payload: data[headerLen:],
+	}
+
+	options := data[20:headerLen]
+	for len(options) > 0 {
+		kind := options[0]
+		if kind == 0 {
+			break
+		}
+		if kind == 1 {
+			options = options[1:]
+			continue
+		}
+		if len(options) < 2 || int(options[1]) < 2 || int(options[1]) > len(options) {
+			break
+		}
+		if kind == 2 && options[1] == 4 {
+			seg.MSS = binary.BigEndian.Uint16(options[2:4])
+		}
+		options = options[options[1]:]
+	}
+
+	return seg, nil
+}
+
+// buildTCPv4 serializes a TCP segment inside an IPv4 packet. A non-zero mss
+// adds the MSS option, which is only meaningful on SYN segments.
+func buildTCPv4(src, dst net.IP, seg *tcpSegment) []byte {
+	headerLen := 20
+	if seg.MSS != 0 {
+		headerLen += 4
+	}
+
+	data := make([]byte, headerLen+len(seg.Payload))
+	binary.BigEndian.PutUint16(data[0:2], seg.SrcPort)
+	binary.BigEndian.PutUint16(data[2:4], seg.DstPort)
+	binary.BigEndian.PutUint32(data[4:8], seg.Seq)
+	binary.BigEndian.PutUint32(data[8:12], seg.Ack)
+	data[12] = byte(headerLen/4) << 4
+	data[13] = seg.Flags
+	binary.BigEndian.PutUint16(data[14:16], seg.Window)
+	if seg.MSS != 0 {
+		data[20] = 2
+		data[21] = 4
+		binary.BigEndian.PutUint16(data[22:24], seg.MSS)
+	}
+	copy(data[headerLen:], seg.Payload)
+
+	binary.BigEndian.PutUint16(data[16:18], ipv4Checksum(src, dst, ipProtoTCP, data))
+
+	return buildIPv4(src, dst, ipProtoTCP, 64, data)
+}
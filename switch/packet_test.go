@@ -0,0 +1,141 @@
+package vswitch
+
+import (
+	"net"
+	"testing"
+)
+
+func TestBuildParseIPv6(t *testing.T) {
+	src := net.ParseIP("fe80::1")
+	dst := net.ParseIP("ff02::1")
+	payload := []byte{134, 0, 0, 0, 1, 2, 3, 4}
+
+	packet := buildIPv6(src, dst, ipProtoICMPv6, 255, payload, 2)
+
+	parsed, err := parseIPv6(packet)
+	if err != nil {
+		t.Fatalf("Unexpected error parsing packet: %v", err)
+	}
+
+	if !parsed.Src.Equal(src) || !parsed.Dst.Equal(dst) {
+		t.Errorf("Expected %s -> %s, got %s -> %s", src, dst, parsed.Src, parsed.Dst)
+	}
+
+	if parsed.NextHeader != ipProtoICMPv6 || parsed.HopLimit != 255 {
+		t.Errorf("Unexpected next header %d or hop limit %d", parsed.NextHeader, parsed.HopLimit)
+	}
+
+	// A packet with a correct checksum sums to zero
+	if sum := ipv6Checksum(src, dst, ipProtoICMPv6, parsed.Payload); sum != 0 {
+		t.Errorf("Expected checksum to verify, got residual 0x%04x", sum)
+	}
+
+	if _, err := parseIPv6(packet[:30]); err == nil {
+		t.Errorf("Expected error parsing truncated header")
+	}
+
+	if _, err := parseIPv6(packet[:len(packet)-1]); err == nil {
+		t.Errorf("Expected error parsing truncated payload")
+	}
+}
+
+func TestLinkLocalFromMAC(t *testing.T) {
+	mac := net.HardwareAddr{0x52, 0x54, 0x00, 0x12, 0x34, 0x56}
+
+	if ip := linkLocalFromMAC(mac); ip.String() != "fe80::5054:ff:fe12:3456" {
+		t.Errorf("Unexpected link-local address %s", ip)
+	}
+
+	if group := ipv6MulticastMAC(net.ParseIP("ff02::1:ff12:3456")); group.String() != "33:33:ff:12:34:56" {
+		t.Errorf("Unexpected multicast MAC %s", group)
+	}
+}
+
+func TestBuildParseUDPv4(t *testing.T) {
+	src := net.ParseIP("10.0.0.1")
+	dst := net.ParseIP("10.0.0.2")
+
+	packet := buildUDPv4(src, dst, 67, 68, []byte("hello"))
+
+	ip, err := parseIPv4(packet)
+	if err != nil {
+		t.Fatalf("Unexpected error parsing IPv4: %v", err)
+	}
+
+	if !ip.Src.Equal(src) || !ip.Dst.Equal(dst) || ip.Protocol != ipProtoUDP {
+		t.Errorf("Unexpected IPv4 header: %s -> %s proto %d", ip.Src, ip.Dst, ip.Protocol)
+	}
+
+	if checksumFinish(checksumAdd(0, packet[:20])) != 0 {
+		t.Errorf("IPv4 header checksum does not verify")
+	}
+
+	if ipv4Checksum(ip.Src, ip.Dst, ipProtoUDP, ip.Payload) != 0 {
+		t.Errorf("UDP checksum does not verify")
+	}
+
+	udp, err := parseUDP(ip.Payload)
+	if err != nil {
+		t.Fatalf("Unexpected error parsing UDP: %v", err)
+	}
+
+	if udp.SrcPort != 67 || udp.DstPort != 68 || string(udp.Payload) != "hello" {
+		t.Errorf("Unexpected UDP datagram: %d -> %d %q", udp.SrcPort, udp.DstPort, udp.Payload)
+	}
+
+	// Ethernet padding after the IPv4 packet is ignored
+	padded := append(append([]byte{}, packet...), 0, 0, 0, 0)
+	if ip, err := parseIPv4(padded); err != nil || len(ip.Payload) != len(packet)-20 {
+		t.Errorf("Expected padding to be ignored, got %v", err)
+	}
+
+	if _, err := parseIPv4(packet[:10]); err == nil {
+		t.Errorf("Expected error parsing truncated IPv4 packet")
+	}
+
+	if _, err := parseUDP([]byte{0, 67, 0, 68, 0, 50, 0, 0}); err == nil {
+		t.Errorf("Expected error parsing UDP datagram with bad length")
+	}
+}
+
+func TestBuildParseTCPv4(t *testing.T) {
+	src := net.ParseIP("10.0.0.10")
+	dst := net.ParseIP("192.0.2.1")
+
+	packet := buildTCPv4(src, dst, &tcpSegment{
+		SrcPort: 40000,
+		DstPort: 80,
+		Seq:     1000,
+		Ack:     2000,
+		Flags:   tcpSYN | tcpACK,
+		Window:  65535,
+		MSS:     1460,
+		Payload: []byte("data"),
+	})
+
+	ip, err := parseIPv4(packet)
+	if err != nil || ip.Protocol != ipProtoTCP {
+		t.Fatalf("Unexpected IPv4 packet: %v", err)
+	}
+
+	if ipv4Checksum(ip.Src, ip.Dst, ipProtoTCP, ip.Payload) != 0 {
+		t.Errorf("TCP checksum does not verify")
+	}
+
+	seg, err := parseTCP(ip.Payload)
+	if err != nil {
+		t.Fatalf("Unexpected error parsing TCP: %v", err)
+	}
+
+	if seg.SrcPort != 40000 || seg.DstPort != 80 || seg.Seq != 1000 || seg.Ack != 2000 {
+		t.Errorf("Unexpected ports or sequence numbers: %+v", seg)
+	}
+
+	if seg.Flags != tcpSYN|tcpACK || seg.Window != 65535 || seg.MSS != 1460 || string(seg.Payload) != "data" {
+		t.Errorf("Unexpected flags, window, MSS or payload: %+v", seg)
+	}
+
+	if _, err := parseTCP(ip.Payload[:10]); err == nil {
+		t.Errorf("Expected error for short TCP segment")
+	}
+}
@@ -0,0 +1,223 @@
+package vswitch
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Partition blackholes the traffic between groups of a VLAN's connections
+// to simulate a network split, e.g. to test how clustered guest software
+// handles split brain. Frames from a connection in one group never reach a
+// connection in another group of the partition, while the connections of
+// a group and those outside the partition are unaffected. A partition
+// lasts until it is healed, or until it expires if it was given a
+// duration.
+type Partition struct {
+	ID string `json:"id"`
+
+	// Groups list the members of each side of the partition. A member
+	// names connections by their ID, label, client ID, VM name or remote
+	// IP address, so that it still applies once they reconnect.
+	Groups [][]string `json:"groups"`
+
+	Created time.Time  `json:"created"`
+	Expires *time.Time `json:"expires,omitempty"`
+
+	// Dropped counts the frames the partition kept from crossing it
+	Dropped uint64 `json:"dropped"`
+}
+
+// partition is an active partition of a VLAN
+type partition struct {
+	Partition
+	dropped atomic.Uint64
+	timer   *time.Timer
+}
+
+// partitions holds the active partitions of a VLAN
+type partitions struct {
+	mutex  sync.RWMutex
+	byID   map[string]*partition
+	nextID int
+	active atomic.Int32
+}
+
+// validatePartitionGroups checks that there are at least two groups, each
+// with members, and that no member is on two sides
+func validatePartitionGroups(groups [][]string) error {
+	if len(groups) < 2 {
+		return fmt.Errorf("a partition needs at least two groups")
+	}
+	seen := make(map[string]bool)
+	for _, group := range groups {
+		if len(group) == 0 {
+			return fmt.Errorf("partition groups must not be empty")
+		}
+		for _, member := range group {
+			if member == "" {
+				return fmt.Errorf("partition members must not be empty")
+			}
+			if seen[member] {
+				return fmt.Errorf("%s is in more than one partition group", member)
+			}
+			seen[member] = true
+		}
+	}
+	return nil
+}
+
+// matchesMember reports whether a partition member names the connection
+func (c *Connection) matchesMember(member string) bool {
+	if member == c.ID || member == c.host() {
+		return true
+	}
+	hello := c.Hello()
+	return member == c.Label() || member == hello.ID || member == hello.VMName
+}
+
+// groupOf returns the index of the group of the partition the connection
+// is in, or -1 if it is not in the partition
+func (p *partition) groupOf(conn *Connection) int {
+	for i, group := range p.Groups {
+		if slices.ContainsFunc(group, conn.matchesMember) {
+			return i
+		}
+	}
+	return -1
+}
+
+// separates reports whether the partition keeps frames from src from
+// reaching dst
+func (p *partition) separates(src, dst *Connection) bool {
+	srcGroup := p.groupOf(src)
+	if srcGroup < 0 {
+		return false
+	}
+	dstGroup := p.groupOf(dst)
+	return dstGroup >= 0 && dstGroup != srcGroup
+}
+
+// snapshot returns the partition as reported by the API
+func (p *partition) snapshot() Partition {
+	snapshot := p.Partition
+	snapshot.Dropped = p.dropped.Load()
+	return snapshot
+}
+
+// partitioned reports whether an active partition keeps frames from src
+// from reaching dst, counting the frame against it if so
+func (vs *VirtualSwitch) partitioned(src, dst *Connection) bool {
+	if vs.partitions.active.Load() == 0 {
+		return false
+	}
+
+	vs.partitions.mutex.RLock()
+	defer vs.partitions.mutex.RUnlock()
+	for _, p := range vs.partitions.byID {
+		if p.separates(src, dst) {
+			p.dropped.Add(1)
+			vs.partitionDrops.Add(1)
+			return true
+		}
+	}
+	return false
+}
+
+// Partition blackholes the traffic between groups of the VLAN's
+// connections until the partition is healed, or for the given duration if
+// it is positive. It returns the new partition.
+func (vs *VirtualSwitch) Partition(groups [][]string, duration time.Duration) (Partition, error) {
+	if err := validatePartitionGroups(groups); err != nil {
+		return Partition{}, err
+	}
+	if duration < 0 {
+		return Partition{}, fmt.Errorf("partition duration must not be negative")
+	}
+
+	copied := make([][]string, len(groups))
+	for i, group := range groups {
+		copied[i] = slices.Clone(group)
+	}
+
+	vs.partitions.mutex.Lock()
+	defer vs.partitions.mutex.Unlock()
+
+	if vs.partitions.byID == nil {
+		vs.partitions.byID = make(map[string]*partition)
+	}
+	vs.partitions.nextID++
+	p := &partition{Partition: Partition{
+		ID:      "p" + strconv.Itoa(vs.partitions.nextID),
+		Groups:  copied,
+		Created: time.Now(),
+	}}
+	if duration > 0 {
+		expires := p.Created.Add(duration)
+		p.Expires = &expires
+		p.timer = time.AfterFunc(duration, func() { _, _ = vs.Heal(p.ID) })
+	}
+	vs.partitions.byID[p.ID] = p
+	vs.partitions.active.Add(1)
+
+	vs.logger.Info("Partitioned connections", "partition", p.ID, "groups", groups, "duration", duration)
+	vs.syncFastPath()
+	return p.snapshot(), nil
+}
+
+// Heal removes a partition, or every partition of the VLAN if id is "",
+// letting traffic cross it again. It returns the partitions removed.
+func (vs *VirtualSwitch) Heal(id string) ([]Partition, error) {
+	vs.partitions.mutex.Lock()
+	defer vs.partitions.mutex.Unlock()
+
+	if id != "" {
+		if _, found := vs.partitions.byID[id]; !found {
+			return nil, fmt.Errorf("partition %s not found", id)
+		}
+	}
+
+	healed := []Partition{}
+	for _, p := range vs.partitions.byID {
+		if id != "" && p.ID != id {
+			continue
+		}
+		if p.timer != nil {
+			p.timer.Stop()
+		}
+		delete(vs.partitions.byID, p.ID)
+		vs.partitions.active.Add(-1)
+		healed = append(healed, p.snapshot())
+		vs.logger.Info("Healed partition", "partition", p.ID, "dropped", p.dropped.Load())
+	}
+	sortPartitions(healed)
+	vs.syncFastPath()
+	return healed, nil
+}
+
+// Partitions returns the active partitions of the VLAN
+func (vs *VirtualSwitch) Partitions() []Partition {
+	vs.partitions.mutex.RLock()
+	defer vs.partitions.mutex.RUnlock()
+
+	list := make([]Partition, 0, len(vs.partitions.byID))
+	for _, p := range vs.partitions.byID {
+		list = append(list, p.snapshot())
+	}
+	sortPartitions(list)
+	return list
+}
+
+// sortPartitions sorts partitions by creation
+func sortPartitions(list []Partition) {
+	sort.Slice(list, func(i, j int) bool {
+		if len(list[i].ID) != len(list[j].ID) {
+			return len(list[i].ID) < len(list[j].ID)
+		}
+		return list[i].ID < list[j].ID
+	})
+}
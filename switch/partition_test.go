@@ -0,0 +1,143 @@
+package vswitch
+
+import (
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestPartition(t *testing.T) {
+	sw := NewVirtualSwitch([]int{8080})
+	conns := make(map[string]*Connection)
+	for _, id := range []string{"a", "b", "c", "outside"} {
+		conns[id] = NewConnection(id, &frameSink{})
+		sw.connections.Store(id, conns[id])
+	}
+	conns["c"].SetLabel("ns/c")
+
+	macA := net.HardwareAddr{0x52, 0x54, 0, 0, 0, 0xa}
+	macB := net.HardwareAddr{0x52, 0x54, 0, 0, 0, 0xb}
+	broadcast := func(from string, mac net.HardwareAddr) {
+		sw.handleFrame(rawFrame(buildEthernet(BroadcastMAC, mac, EtherTypeIPv4, make([]byte, 46))), conns[from])
+	}
+	sent := func() map[string]uint64 {
+		counts := make(map[string]uint64)
+		for id, conn := range conns {
+			counts[id] = conn.Info().FramesSent
+		}
+		return counts
+	}
+
+	// Members match connection IDs and labels alike
+	partition, err := sw.Partition([][]string{{"a"}, {"b", "ns/c"}}, 0)
+	if err != nil {
+		t.Fatalf("Failed to partition: %v", err)
+	}
+	if partition.ID != "p1" || partition.Expires != nil {
+		t.Errorf("Unexpected partition %+v", partition)
+	}
+
+	broadcast("a", macA)
+	if counts := sent(); counts["b"] != 0 || counts["c"] != 0 || counts["outside"] != 1 {
+		t.Errorf("Expected the flood to reach only the connection outside the partition, got %v", counts)
+	}
+	broadcast("b", macB)
+	if counts := sent(); counts["a"] != 0 || counts["c"] != 1 || counts["outside"] != 2 {
+		t.Errorf("Expected the flood to reach its own group, got %v", counts)
+	}
+
+	// Unicast to a learned MAC across the partition is dropped too
+	sw.handleFrame(rawFrame(buildEthernet(macA, macB, EtherTypeIPv4, make([]byte, 46))), conns["b"])
+	if counts := sent(); counts["a"] != 0 {
+		t.Errorf("Expected the unicast frame to be dropped, got %v", counts)
+	}
+	if partitions := sw.Partitions(); len(partitions) != 1 || partitions[0].Dropped != 4 {
+		t.Errorf("Expected 4 frames dropped by the partition, got %+v", partitions)
+	}
+	if drops := sw.GetStats()["partition_drops"]; drops != uint64(4) {
+		t.Errorf("Expected 4 partition drops in the statistics, got %v", drops)
+	}
+
+	healed, err := sw.Heal("p1")
+	if err != nil || len(healed) != 1 || healed[0].ID != "p1" {
+		t.Fatalf("Failed to heal: %+v, %v", healed, err)
+	}
+	broadcast("a", macA)
+	if counts := sent(); counts["b"] != 1 || counts["c"] != 2 {
+		t.Errorf("Expected the flood to cross the healed partition, got %v", counts)
+	}
+	if _, err := sw.Heal("p1"); err == nil {
+		t.Errorf("Expected healing a missing partition to fail")
+	}
+}
+
+func TestPartitionExpires(t *testing.T) {
+	sw := NewVirtualSwitch([]int{8080})
+	partition, err := sw.Partition([][]string{{"a"}, {"b"}}, 20*time.Millisecond)
+	if err != nil {
+		t.Fatalf("Failed to partition: %v", err)
+	}
+	if partition.Expires == nil {
+		t.Errorf("Expected the partition to expire")
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(sw.Partitions()) > 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the partition to heal itself")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestPartitionValidation(t *testing.T) {
+	sw := NewVirtualSwitch([]int{8080})
+	for _, groups := range [][][]string{
+		{{"a"}},
+		{{"a"}, {}},
+		{{"a"}, {""}},
+		{{"a", "b"}, {"b"}},
+	} {
+		if _, err := sw.Partition(groups, 0); err == nil {
+			t.Errorf("Expected %v to be rejected", groups)
+		}
+	}
+	if _, err := sw.Partition([][]string{{"a"}, {"b"}}, -time.Second); err == nil {
+		t.Errorf("Expected a negative duration to be rejected")
+	}
+}
+
+func TestAPIPartitions(t *testing.T) {
+	sm := NewSwitchManager()
+	if err := sm.AddVLAN(8080); err != nil {
+		t.Fatalf("Failed to add VLAN: %v", err)
+	}
+	handler := NewAPIHandler(sm, "secret")
+
+	if status := apiRequest(handler, http.MethodPost, "/vlans/8080/partitions", `{"groups":[["a"],["b"]],"duration":"1m"}`, "secret"); status != http.StatusCreated {
+		t.Fatalf("Expected the partition to be created, got %d", status)
+	}
+	if partitions, _ := sm.GetPartitions(8080); len(partitions) != 1 || partitions[0].Expires == nil {
+		t.Errorf("Unexpected partitions %+v", partitions)
+	}
+
+	for _, body := range []string{`{"groups":[["a"]]}`, `{"groups":[["a"],["b"]],"duration":"soon"}`, `{"groups":[["a"],["b"]],"duration":"-1s"}`} {
+		if status := apiRequest(handler, http.MethodPost, "/vlans/8080/partitions", body, "secret"); status != http.StatusBadRequest {
+			t.Errorf("Expected %s to be rejected, got %d", body, status)
+		}
+	}
+	if status := apiRequest(handler, http.MethodPost, "/vlans/8081/partitions", `{"groups":[["a"],["b"]]}`, "secret"); status != http.StatusNotFound {
+		t.Errorf("Expected an unknown VLAN to be rejected, got %d", status)
+	}
+	if status := apiRequest(handler, http.MethodDelete, "/vlans/8080/partitions/p9", "", "secret"); status != http.StatusNotFound {
+		t.Errorf("Expected an unknown partition to be rejected, got %d", status)
+	}
+
+	if status := apiRequest(handler, http.MethodDelete, "/vlans/8080/partitions", "", "secret"); status != http.StatusOK {
+		t.Fatalf("Expected the partitions to be healed, got %d", status)
+	}
+	if partitions, _ := sm.GetPartitions(8080); len(partitions) != 0 {
+		t.Errorf("Expected no partitions, got %+v", partitions)
+	}
+}
@@ -0,0 +1,39 @@
+package vswitch
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+)
+
+// peerCredentials describes the process at the other end of a unix socket
+// connection by its user and process IDs
+func peerCredentials(conn net.Conn) string {
+	cred, err := peerUcred(conn)
+	if err != nil {
+		return "unknown"
+	}
+	return fmt.Sprintf("uid=%d pid=%d", cred.Uid, cred.Pid)
+}
+
+// peerUcred returns the credentials of the process at the other end of a
+// unix socket connection
+func peerUcred(conn net.Conn) (*syscall.Ucred, error) {
+	unixConn, ok := conn.(*net.UnixConn)
+	if !ok {
+		return nil, fmt.Errorf("not a unix socket connection")
+	}
+	raw, err := unixConn.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+
+	var cred *syscall.Ucred
+	var credErr error
+	if err := raw.Control(func(fd uintptr) {
+		cred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	}); err != nil {
+		return nil, err
+	}
+	return cred, credErr
+}
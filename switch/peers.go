@@ -0,0 +1,82 @@
+package vswitch
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// ParseCIDRList parses a comma-separated list of IP addresses and CIDR
+// prefixes. Bare addresses are treated as single-host prefixes.
+func ParseCIDRList(list string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		if strings.Contains(item, "/") {
+			_, ipNet, err := net.ParseCIDR(item)
+			if err != nil {
+				return nil, fmt.Errorf("invalid CIDR '%s': %v", item, err)
+			}
+			nets = append(nets, ipNet)
+			continue
+		}
+
+		ip := net.ParseIP(item)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid IP address '%s'", item)
+		}
+		bits := 128
+		if ip4 := ip.To4(); ip4 != nil {
+			ip = ip4
+			bits = 32
+		}
+		nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+	}
+
+	return nets, nil
+}
+
+// addrIP extracts the IP address from a network address, or nil if it has none
+func addrIP(addr net.Addr) net.IP {
+	if addr == nil {
+		return nil
+	}
+
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return a.IP
+	case *net.UDPAddr:
+		return a.IP
+	}
+
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		host = addr.String()
+	}
+	return net.ParseIP(host)
+}
+
+// matchesPeer reports whether the address falls within any of the prefixes
+func matchesPeer(addr net.Addr, nets []*net.IPNet) bool {
+	if len(nets) == 0 {
+		return false
+	}
+
+	ip := addrIP(addr)
+	if ip == nil {
+		return false
+	}
+
+	for _, ipNet := range nets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,57 @@
+package vswitch
+
+import (
+	"net"
+	"testing"
+)
+
+func TestParseCIDRList(t *testing.T) {
+	nets, err := ParseCIDRList("10.0.0.0/8, 192.168.1.5,fd00::/64,,::1")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if len(nets) != 4 {
+		t.Fatalf("Expected 4 prefixes, got %d", len(nets))
+	}
+
+	if nets[1].String() != "192.168.1.5/32" {
+		t.Errorf("Expected bare IPv4 address to become /32, got %s", nets[1])
+	}
+
+	if nets[3].String() != "::1/128" {
+		t.Errorf("Expected bare IPv6 address to become /128, got %s", nets[3])
+	}
+
+	for _, bad := range []string{"10.0.0.0/33", "not-an-ip"} {
+		if _, err := ParseCIDRList(bad); err == nil {
+			t.Errorf("Expected error parsing '%s'", bad)
+		}
+	}
+}
+
+func TestMatchesPeer(t *testing.T) {
+	nets, _ := ParseCIDRList("127.0.0.0/8,fd00::/64")
+
+	tests := []struct {
+		addr     net.Addr
+		expected bool
+	}{
+		{&net.TCPAddr{IP: net.ParseIP("127.0.0.2"), Port: 1234}, true},
+		{&net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 1234}, false},
+		{&net.TCPAddr{IP: net.ParseIP("fd00::5"), Port: 1234}, true},
+		{&mockAddr{network: "tcp", address: "127.0.0.1:9001"}, true},
+		{&mockAddr{network: "unix", address: "/tmp/sock"}, false},
+		{nil, false},
+	}
+
+	for _, tt := range tests {
+		if got := matchesPeer(tt.addr, nets); got != tt.expected {
+			t.Errorf("matchesPeer(%v) = %v, expected %v", tt.addr, got, tt.expected)
+		}
+	}
+
+	if matchesPeer(&net.TCPAddr{IP: net.ParseIP("127.0.0.1")}, nil) {
+		t.Errorf("Expected empty prefix list to match nothing")
+	}
+}
@@ -0,0 +1,16 @@
+//go:build !windows
+
+package vswitch
+
+import (
+	"errors"
+	"net"
+)
+
+// pipesSupported tells whether VLANs can serve named pipes
+const pipesSupported = false
+
+// listenPipe fails, as named pipes are a Windows transport
+func listenPipe(_ string) (net.Listener, error) {
+	return nil, errors.New("named pipes are only supported on Windows")
+}
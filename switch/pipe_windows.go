@@ -0,0 +1,218 @@
+package vswitch
+
+import (
+	"errors"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// pipesSupported tells whether VLANs can serve named pipes
+const pipesSupported = true
+
+// Named pipe functions and flags missing from the syscall package
+var (
+	kernel32             = syscall.NewLazyDLL("kernel32.dll")
+	procCreateNamedPipeW = kernel32.NewProc("CreateNamedPipeW")
+	procConnectNamedPipe = kernel32.NewProc("ConnectNamedPipe")
+	procDisconnectPipe   = kernel32.NewProc("DisconnectNamedPipe")
+)
+
+const (
+	pipeAccessDuplex          = 0x3
+	fileFlagFirstPipeInstance = 0x80000
+	pipeRejectRemoteClients   = 0x8
+	pipeUnlimitedInstances    = 255
+	pipeBufferSize            = 64 * 1024
+
+	errPipeConnected syscall.Errno = 535
+
+	// pipePrefix is the namespace of local named pipes
+	pipePrefix = `\\.\pipe\`
+)
+
+// pipeListener accepts the clients of a named pipe. Every client gets an
+// instance of the pipe of its own, opened before it connects so that the
+// pipe always exists while the listener is open.
+type pipeListener struct {
+	path string
+
+	mutex  sync.Mutex
+	next   syscall.Handle // Instance waiting for the next client
+	closed bool
+}
+
+// listenPipe creates a named pipe, which must not exist yet, for local
+// clients only
+func listenPipe(name string) (net.Listener, error) {
+	path := pipePath(name)
+	handle, err := createPipe(path, true)
+	if err != nil {
+		return nil, &os.PathError{Op: "listen", Path: path, Err: err}
+	}
+	return &pipeListener{path: path, next: handle}, nil
+}
+
+// createPipe creates an instance of a named pipe in byte mode
+func createPipe(path string, first bool) (syscall.Handle, error) {
+	name, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return syscall.InvalidHandle, err
+	}
+	mode := uint32(pipeAccessDuplex)
+	if first {
+		mode |= fileFlagFirstPipeInstance
+	}
+	handle, _, err := procCreateNamedPipeW.Call(uintptr(unsafe.Pointer(name)), uintptr(mode),
+		pipeRejectRemoteClients, pipeUnlimitedInstances, pipeBufferSize, pipeBufferSize, 0, 0)
+	if syscall.Handle(handle) == syscall.InvalidHandle {
+		return syscall.InvalidHandle, err
+	}
+	return syscall.Handle(handle), nil
+}
+
+// Accept waits for a client to open the pipe and returns its connection
+func (l *pipeListener) Accept() (net.Conn, error) {
+	l.mutex.Lock()
+	if l.closed {
+		l.mutex.Unlock()
+		return nil, net.ErrClosed
+	}
+	handle := l.next
+	l.mutex.Unlock()
+
+	// A client may have opened the instance before it was waited for
+	connected, _, err := procConnectNamedPipe.Call(uintptr(handle), 0)
+	if connected == 0 && !errors.Is(err, errPipeConnected) {
+		l.mutex.Lock()
+		defer l.mutex.Unlock()
+		if l.closed {
+			return nil, net.ErrClosed
+		}
+		_, _, _ = procDisconnectPipe.Call(uintptr(handle))
+		return nil, &net.OpError{Op: "accept", Net: "pipe", Addr: pipeAddr(l.path), Err: err}
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.closed {
+		// The client was the listener unblocking itself
+		return nil, net.ErrClosed
+	}
+	next, err := createPipe(l.path, false)
+	if err != nil {
+		_, _, _ = procDisconnectPipe.Call(uintptr(handle))
+		return nil, &net.OpError{Op: "accept", Net: "pipe", Addr: pipeAddr(l.path), Err: err}
+	}
+	l.next = next
+	return &pipeConn{File: os.NewFile(uintptr(handle), l.path), handle: handle, addr: pipeAddr(l.path)}, nil
+}
+
+// Close removes the pipe once its connections are closed, unblocking a
+// pending Accept by connecting to the pipe
+func (l *pipeListener) Close() error {
+	l.mutex.Lock()
+	if l.closed {
+		l.mutex.Unlock()
+		return nil
+	}
+	l.closed = true
+	handle := l.next
+	l.mutex.Unlock()
+
+	if name, err := syscall.UTF16PtrFromString(l.path); err == nil {
+		client, err := syscall.CreateFile(name, syscall.GENERIC_READ|syscall.GENERIC_WRITE, 0, nil, syscall.OPEN_EXISTING, 0, 0)
+		if err == nil {
+			_ = syscall.CloseHandle(client)
+		}
+	}
+	return syscall.CloseHandle(handle)
+}
+
+// Addr returns the path of the pipe
+func (l *pipeListener) Addr() net.Addr {
+	return pipeAddr(l.path)
+}
+
+// pipeConn is the server end of a connected pipe instance. Its handle is
+// synchronous, so the file only closes it once pending reads and writes
+// return, and it has no deadlines.
+type pipeConn struct {
+	*os.File
+	handle syscall.Handle
+	addr   pipeAddr
+
+	closing atomic.Bool
+	pending atomic.Int32 // reads and writes in progress
+}
+
+// Read reads from the pipe unless it is closing
+func (c *pipeConn) Read(b []byte) (int, error) {
+	c.pending.Add(1)
+	defer c.pending.Add(-1)
+	if c.closing.Load() {
+		return 0, os.ErrClosed
+	}
+	return c.File.Read(b)
+}
+
+// Write writes to the pipe unless it is closing
+func (c *pipeConn) Write(b []byte) (int, error) {
+	c.pending.Add(1)
+	defer c.pending.Add(-1)
+	if c.closing.Load() {
+		return 0, os.ErrClosed
+	}
+	return c.File.Write(b)
+}
+
+// Close cancels the reads and writes in progress, which an idle or stuck
+// client would otherwise never complete, and closes the pipe
+func (c *pipeConn) Close() error {
+	if c.closing.Swap(true) {
+		return os.ErrClosed
+	}
+	// An operation may start between its check and the cancellation, so
+	// cancelling goes on until none is left
+	for c.pending.Load() > 0 {
+		_ = syscall.CancelIoEx(c.handle, nil)
+		time.Sleep(time.Millisecond)
+	}
+	return c.File.Close()
+}
+
+// LocalAddr returns the path of the pipe
+func (c *pipeConn) LocalAddr() net.Addr {
+	return c.addr
+}
+
+// RemoteAddr returns the path of the pipe, as pipe clients have no address
+func (c *pipeConn) RemoteAddr() net.Addr {
+	return c.addr
+}
+
+// pipePath returns the full path of a named pipe given its path or name
+func pipePath(name string) string {
+	if strings.HasPrefix(name, `\\`) {
+		return name
+	}
+	return pipePrefix + name
+}
+
+// pipeAddr is the address of a named pipe, its path
+type pipeAddr string
+
+// Network returns "pipe"
+func (a pipeAddr) Network() string {
+	return "pipe"
+}
+
+// String returns the path of the pipe
+func (a pipeAddr) String() string {
+	return string(a)
+}
@@ -0,0 +1,73 @@
+package vswitch
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestNamedPipe(t *testing.T) {
+	name := fmt.Sprintf("vswitch-test-%d", os.Getpid())
+	sw := NewVirtualSwitchWithConfig([]int{9999}, Config{
+		Listen: func(int) (net.Listener, error) { return net.Listen("tcp", "127.0.0.1:0") },
+		Pipe:   name,
+	})
+	if err := sw.Start(context.Background()); err != nil {
+		t.Fatalf("Failed to start switch: %v", err)
+	}
+	defer sw.Stop()
+
+	// A second switch cannot serve the same pipe
+	if _, err := listenPipe(name); err == nil {
+		t.Errorf("Expected the pipe to be in use")
+	}
+
+	// Every client of the pipe gets a connection of its own
+	for i := 0; i < 2; i++ {
+		client, err := os.OpenFile(pipePath(name), os.O_RDWR, 0)
+		if err != nil {
+			t.Fatalf("Failed to open pipe: %v", err)
+		}
+		defer func() { _ = client.Close() }()
+		// The next instance of the pipe exists once the client is accepted
+		waitUntil(t, "the client is connected", func() bool { return sw.connectionCount() == i+1 })
+	}
+	for _, info := range sw.GetConnections() {
+		if !strings.HasPrefix(info.ID, "pipe-9999-") {
+			t.Errorf("Expected a numbered pipe connection, got %s", info.ID)
+		}
+	}
+}
+
+func TestNamedPipeKick(t *testing.T) {
+	name := fmt.Sprintf("vswitch-test-kick-%d", os.Getpid())
+	sw := NewVirtualSwitchWithConfig([]int{9999}, Config{
+		Listen: func(int) (net.Listener, error) { return net.Listen("tcp", "127.0.0.1:0") },
+		Pipe:   name,
+	})
+	if err := sw.Start(context.Background()); err != nil {
+		t.Fatalf("Failed to start switch: %v", err)
+	}
+	defer sw.Stop()
+
+	client, err := os.OpenFile(pipePath(name), os.O_RDWR, 0)
+	if err != nil {
+		t.Fatalf("Failed to open pipe: %v", err)
+	}
+	defer func() { _ = client.Close() }()
+	waitUntil(t, "the client is connected", func() bool { return sw.connectionCount() == 1 })
+
+	// Kicking an idle client cancels the read waiting for its next frame
+	id := sw.GetConnections()[0].ID
+	if _, err := sw.Kick(id); err != nil {
+		t.Fatalf("Failed to kick %s: %v", id, err)
+	}
+	waitUntil(t, "the client is disconnected", func() bool { return sw.connectionCount() == 0 })
+
+	if err := (Config{Pipe: name, PSK: "correct horse battery staple"}).validate(); err == nil {
+		t.Errorf("Expected a PSK to be rejected on a named pipe")
+	}
+}
@@ -2,20 +2,42 @@ package vswitch
 
 import "sync"
 
-var frameBufferPool = sync.Pool{
-	New: func() interface{} {
-		buf := make([]byte, 1518)
-		return &buf
-	},
+// frameSizeClasses are the buffer sizes of the frame pools: small frames
+// such as ARP and TCP acknowledgements, standard Ethernet frames including
+// VLAN tags, and jumbo frames
+var frameSizeClasses = [...]int{128, 1600, 9216}
+
+// frameBufferPools holds one pool per size class
+var frameBufferPools [len(frameSizeClasses)]sync.Pool
+
+func init() {
+	for i, size := range frameSizeClasses {
+		frameBufferPools[i].New = func() interface{} {
+			buf := make([]byte, size)
+			return &buf
+		}
+	}
 }
 
-func getFrameBuffer() []byte {
-	return *frameBufferPool.Get().(*[]byte)
+// getFrameBuffer returns a buffer of the given length from the smallest
+// size class that fits it. Buffers beyond the largest class are allocated.
+func getFrameBuffer(size int) []byte {
+	for i, classSize := range frameSizeClasses {
+		if size <= classSize {
+			return (*frameBufferPools[i].Get().(*[]byte))[:size]
+		}
+	}
+	return make([]byte, size)
 }
 
+// putFrameBuffer returns a buffer to the pool of its size class. Buffers
+// that did not come from a pool are left to the garbage collector.
 func putFrameBuffer(buf []byte) {
-	if cap(buf) >= 1518 {
-		buf = buf[:1518]
-		frameBufferPool.Put(&buf)
+	for i, classSize := range frameSizeClasses {
+		if cap(buf) == classSize {
+			buf = buf[:classSize]
+			frameBufferPools[i].Put(&buf)
+			return
+		}
 	}
 }
@@ -0,0 +1,38 @@
+package vswitch
+
+import (
+	"testing"
+)
+
+func TestGetFrameBufferSizeClasses(t *testing.T) {
+	tests := []struct {
+		size, capacity int
+	}{
+		{60, 128},
+		{128, 128},
+		{129, 1600},
+		{1518, 1600},
+		{1601, 9216},
+		{9216, 9216},
+		{9217, 9217},
+	}
+
+	for _, test := range tests {
+		buf := getFrameBuffer(test.size)
+		if len(buf) != test.size || cap(buf) != test.capacity {
+			t.Errorf("getFrameBuffer(%d) has len %d cap %d, expected cap %d", test.size, len(buf), cap(buf), test.capacity)
+		}
+		putFrameBuffer(buf)
+	}
+}
+
+func TestPutFrameBufferIgnoresForeignBuffers(t *testing.T) {
+	// A buffer that is not of a size class must not end up in a pool,
+	// where a later get would hand out a buffer of the wrong size
+	putFrameBuffer(make([]byte, 1518))
+	for i := 0; i < 10; i++ {
+		if buf := getFrameBuffer(1518); cap(buf) != 1600 {
+			t.Fatalf("Expected a 1600 byte buffer, got cap %d", cap(buf))
+		}
+	}
+}
@@ -0,0 +1,76 @@
+//go:build !windows
+
+package vswitch
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"syscall"
+)
+
+// DropPrivileges permanently switches the process to the given user and
+// group, which may be names or numeric IDs. An empty group selects the
+// user's primary group. Supplementary groups are cleared, and the switch is
+// verified to be irreversible.
+func DropPrivileges(userName, groupName string) error {
+	uid, gid, err := resolveIDs(userName, groupName)
+	if err != nil {
+		return err
+	}
+
+	// A process started by an upgrade already runs as the user
+	if os.Getuid() == uid && os.Geteuid() == uid && os.Getgid() == gid {
+		return nil
+	}
+
+	// The group has to change first, while the process may still do so
+	if err := syscall.Setgroups([]int{gid}); err != nil {
+		return fmt.Errorf("failed to set supplementary groups: %v", err)
+	}
+	if err := syscall.Setgid(gid); err != nil {
+		return fmt.Errorf("failed to set group %d: %v", gid, err)
+	}
+	if err := syscall.Setuid(uid); err != nil {
+		return fmt.Errorf("failed to set user %d: %v", uid, err)
+	}
+
+	if uid != 0 {
+		if err := syscall.Setuid(0); err == nil {
+			return fmt.Errorf("privileges were not dropped: regained root")
+		}
+	}
+	return nil
+}
+
+// resolveIDs looks up the user and group IDs of a user and an optional group
+func resolveIDs(userName, groupName string) (int, int, error) {
+	u, err := user.Lookup(userName)
+	if err != nil {
+		if u, err = user.LookupId(userName); err != nil {
+			return 0, 0, fmt.Errorf("unknown user '%s'", userName)
+		}
+	}
+	uid, err := strconv.Atoi(u.Uid)
+	if err != nil {
+		return 0, 0, fmt.Errorf("user '%s' has no numeric ID", userName)
+	}
+
+	gidStr := u.Gid
+	if groupName != "" {
+		g, err := user.LookupGroup(groupName)
+		if err != nil {
+			if g, err = user.LookupGroupId(groupName); err != nil {
+				return 0, 0, fmt.Errorf("unknown group '%s'", groupName)
+			}
+		}
+		gidStr = g.Gid
+	}
+	gid, err := strconv.Atoi(gidStr)
+	if err != nil {
+		return 0, 0, fmt.Errorf("group '%s' has no numeric ID", gidStr)
+	}
+
+	return uid, gid, nil
+}
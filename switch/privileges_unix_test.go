@@ -0,0 +1,37 @@
+//go:build !windows
+
+package vswitch
+
+import (
+	"testing"
+)
+
+func TestResolveIDs(t *testing.T) {
+	tests := []struct {
+		user, group string
+	}{
+		{"root", ""},
+		{"0", ""},
+		{"root", "0"},
+	}
+
+	for _, test := range tests {
+		uid, gid, err := resolveIDs(test.user, test.group)
+		if err != nil {
+			t.Errorf("resolveIDs(%q, %q) failed: %v", test.user, test.group, err)
+			continue
+		}
+		if uid != 0 || gid != 0 {
+			t.Errorf("resolveIDs(%q, %q) = %d, %d, expected 0, 0", test.user, test.group, uid, gid)
+		}
+	}
+}
+
+func TestResolveIDsUnknown(t *testing.T) {
+	if _, _, err := resolveIDs("no-such-vswitch-user", ""); err == nil {
+		t.Error("Expected an error for an unknown user")
+	}
+	if _, _, err := resolveIDs("root", "no-such-vswitch-group"); err == nil {
+		t.Error("Expected an error for an unknown group")
+	}
+}
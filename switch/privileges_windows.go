@@ -0,0 +1,9 @@
+package vswitch
+
+import "errors"
+
+// DropPrivileges fails on Windows, which has no setuid; run the switch as
+// the account it should serve traffic under instead
+func DropPrivileges(_, _ string) error {
+	return errors.New("dropping privileges is not supported on Windows")
+}
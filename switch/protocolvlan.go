@@ -0,0 +1,237 @@
+package vswitch
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// ProtocolDHCP names the DHCPv4 messages in protocol VLAN assignments
+const ProtocolDHCP = "dhcp"
+
+// ProtocolVLAN assigns the untagged frames of a protocol that a VLAN
+// receives to another VLAN of the switch, as the protocol-based VLANs of
+// enterprise switches do, e.g. to put the DHCP and PXE traffic of every
+// segment on a provisioning VLAN
+type ProtocolVLAN struct {
+	// Protocol is ProtocolDHCP, or an EtherType by name (arp, ipv4, ipv6,
+	// lldp) or number
+	Protocol string `json:"protocol"`
+
+	// VLAN is the port of the VLAN the frames are switched in
+	VLAN int `json:"vlan"`
+}
+
+// ParseProtocolVLAN parses an assignment written protocol:port, e.g.
+// dhcp:9990 or 0x88b5:9991
+func ParseProtocolVLAN(spec string) (ProtocolVLAN, error) {
+	protocol, portStr, found := strings.Cut(strings.TrimSpace(spec), ":")
+	if !found {
+		return ProtocolVLAN{}, fmt.Errorf("invalid protocol VLAN '%s': expected protocol:port", spec)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return ProtocolVLAN{}, fmt.Errorf("invalid port in '%s'", spec)
+	}
+	assignment := ProtocolVLAN{Protocol: strings.ToLower(protocol), VLAN: port}
+	_, err = assignment.compile()
+	return assignment, err
+}
+
+// String returns the assignment as ParseProtocolVLAN parses it
+func (p ProtocolVLAN) String() string {
+	return p.Protocol + ":" + strconv.Itoa(p.VLAN)
+}
+
+// compile returns whether a frame belongs to the protocol of the assignment
+func (p ProtocolVLAN) compile() (func(*EthernetFrame) bool, error) {
+	if p.VLAN < 1 || p.VLAN > 65535 {
+		return nil, fmt.Errorf("protocol VLAN port %d out of range (1-65535)", p.VLAN)
+	}
+	if strings.ToLower(p.Protocol) == ProtocolDHCP {
+		return func(frame *EthernetFrame) bool {
+			msg, _ := dhcpFromFrame(frame)
+			return msg != nil
+		}, nil
+	}
+	etherType, err := parseEtherType(p.Protocol)
+	if err != nil {
+		return nil, err
+	}
+	if etherType == EtherTypeVLAN || etherType == EtherTypeQinQ {
+		return nil, fmt.Errorf("protocol VLANs only classify untagged frames")
+	}
+	return func(frame *EthernetFrame) bool { return frame.EtherType == etherType }, nil
+}
+
+// protocolRule is a compiled protocol VLAN assignment
+type protocolRule struct {
+	matches func(*EthernetFrame) bool
+	vlan    int
+}
+
+// protocolVLANs switches the frames of the protocols assigned to other
+// VLANs there. Each connection sending such frames is attached to the
+// other VLAN through a pipe, so that the replies it is sent there reach it
+// untagged, as if its port were a member of both VLANs.
+type protocolVLANs struct {
+	vs    *VirtualSwitch
+	rules []protocolRule
+
+	mutex sync.Mutex
+	ends  map[*Connection]map[int]protocolEnd
+
+	// diverted counts the frames switched in other VLANs, and dropped those
+	// whose VLAN does not exist
+	diverted atomic.Uint64
+	dropped  atomic.Uint64
+}
+
+// protocolEnd is a connection's end of its pipe to another VLAN
+type protocolEnd struct {
+	*Connection
+	vlan *VirtualSwitch
+}
+
+// newProtocolVLANs returns the classifier of the protocol VLAN assignments
+// of a VLAN, which the configuration validated
+func newProtocolVLANs(vs *VirtualSwitch) *protocolVLANs {
+	p := &protocolVLANs{
+		vs:   vs,
+		ends: make(map[*Connection]map[int]protocolEnd),
+	}
+	for _, assignment := range vs.config.ProtocolVLANs {
+		matches, err := assignment.compile()
+		if err != nil {
+			continue
+		}
+		p.rules = append(p.rules, protocolRule{matches: matches, vlan: assignment.VLAN})
+	}
+	return p
+}
+
+// ingress switches a frame in the VLAN its protocol is assigned to, if any,
+// and reports whether it did
+func (p *protocolVLANs) ingress(frame *EthernetFrame, source *Connection) (bool, error) {
+	// Connections standing in for another VLAN's never divert frames back
+	if source.protocolProxy {
+		return false, nil
+	}
+	vlan := 0
+	for _, rule := range p.rules {
+		if rule.matches(frame) {
+			vlan = rule.vlan
+			break
+		}
+	}
+	if vlan == 0 || vlan == p.vs.port() {
+		return false, nil
+	}
+
+	end := p.end(source, vlan)
+	if end == nil {
+		p.dropped.Add(1)
+		return true, fmt.Errorf("VLAN does not exist on port %d", vlan)
+	}
+	if err := end.SendFrame(frame); err != nil {
+		return true, dropped(sendDropReason(err), err)
+	}
+	p.diverted.Add(1)
+	return true, nil
+}
+
+// end returns the end of a connection's pipe to another VLAN, connecting
+// it if needed, or nil if the connection or the VLAN is gone
+func (p *protocolVLANs) end(source *Connection, vlan int) *Connection {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	ends, found := p.ends[source]
+	if !found {
+		return nil
+	}
+	// The VLAN may have been removed, or replaced, before the pipe closed
+	target := p.vs.sibling(vlan)
+	if end, found := ends[vlan]; found {
+		if end.vlan == target && !end.IsClosed() {
+			return end.Connection
+		}
+		_ = end.Close()
+		delete(ends, vlan)
+	}
+	if target == nil {
+		return nil
+	}
+
+	client, server := net.Pipe()
+	id := source.ID + "@" + strconv.Itoa(p.vs.port())
+	label := source.Label()
+	target.attach(id, server, func(c *Connection) {
+		c.protocolProxy = true
+		if label != "" {
+			c.SetLabel(label)
+		}
+	})
+
+	// Frames wait in a queue to enter the other VLAN, so that a busy VLAN
+	// never blocks the connection's own
+	end := NewConnection(id, client)
+	end.maxFrame = target.config.maxFrameSize()
+	end.StartWriter(tagDomainQueueSize, SlowConsumerDropNew, 0)
+	go p.egress(end, source)
+	ends[vlan] = protocolEnd{Connection: end, vlan: target}
+	return end
+}
+
+// egress sends the frames another VLAN sends to a connection's pipe on to
+// the connection, until either side closes
+func (p *protocolVLANs) egress(end, conn *Connection) {
+	defer func() { _ = end.Close() }()
+	for {
+		frame, err := end.ReadFrame()
+		if err != nil {
+			return
+		}
+		err = conn.SendFrame(frame)
+		frame.Release()
+		if err != nil && !errors.Is(err, errQueueFull) && !errors.Is(err, errPortDisabled) {
+			return
+		}
+	}
+}
+
+// attach prepares a new connection of the VLAN to send frames to others
+func (p *protocolVLANs) attach(conn *Connection) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.ends[conn] = make(map[int]protocolEnd)
+}
+
+// detach disconnects a closed connection of the VLAN from the others
+func (p *protocolVLANs) detach(conn *Connection) {
+	p.mutex.Lock()
+	ends := p.ends[conn]
+	delete(p.ends, conn)
+	p.mutex.Unlock()
+
+	for _, end := range ends {
+		_ = end.Close()
+	}
+}
+
+// sibling returns the VLAN of the same manager at a port, or nil. It does
+// not take the manager's mutex, which is held while VLANs stop.
+func (vs *VirtualSwitch) sibling(port int) *VirtualSwitch {
+	if vs.siblings == nil {
+		return nil
+	}
+	if sibling, found := vs.siblings.Load(port); found {
+		return sibling.(*VirtualSwitch)
+	}
+	return nil
+}
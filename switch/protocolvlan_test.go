@@ -0,0 +1,96 @@
+package vswitch
+
+import (
+	"context"
+	"net"
+	"testing"
+)
+
+func TestParseProtocolVLAN(t *testing.T) {
+	assignment, err := ParseProtocolVLAN("DHCP:9990")
+	if err != nil || assignment != (ProtocolVLAN{Protocol: ProtocolDHCP, VLAN: 9990}) {
+		t.Errorf("Unexpected assignment %+v, %v", assignment, err)
+	}
+	if assignment.String() != "dhcp:9990" {
+		t.Errorf("Expected dhcp:9990, got %s", assignment)
+	}
+	if assignment, err := ParseProtocolVLAN("0x88b5:9991"); err != nil || assignment.Protocol != "0x88b5" {
+		t.Errorf("Expected a numeric EtherType, got %+v, %v", assignment, err)
+	}
+
+	for _, spec := range []string{"", "dhcp", "dhcp:port", "dhcp:0", "tftp:9990", "0x8100:9990"} {
+		if _, err := ParseProtocolVLAN(spec); err == nil {
+			t.Errorf("Expected %q to be rejected", spec)
+		}
+	}
+	if err := (Config{TagDemux: true, ProtocolVLANs: []ProtocolVLAN{{Protocol: ProtocolDHCP, VLAN: 9990}}}).validate(); err == nil {
+		t.Errorf("Expected protocol VLANs of tagged frames to be rejected")
+	}
+}
+
+func TestProtocolVLAN(t *testing.T) {
+	sm := NewSwitchManager()
+	sm.SetDefaultConfig(Config{InMemory: true, EgressQueueSize: 64})
+	if err := sm.AddVLANWithConfig(8080, Config{InMemory: true, EgressQueueSize: 64, ProtocolVLANs: []ProtocolVLAN{{Protocol: ProtocolDHCP, VLAN: 8090}}}); err != nil {
+		t.Fatalf("Failed to add VLAN: %v", err)
+	}
+	_ = sm.AddVLAN(8090)
+	if err := sm.StartAll(context.Background()); err != nil {
+		t.Fatalf("Failed to start VLANs: %v", err)
+	}
+	defer sm.StopAll()
+
+	access, provisioning := sm.vlan(8080), sm.vlan(8090)
+	vmMAC := net.HardwareAddr{0x52, 0x54, 0x00, 0x00, 0x00, 0x01}
+	serverMAC := net.HardwareAddr{0x52, 0x54, 0x00, 0x00, 0x00, 0x02}
+	vm := access.Dial("vm")
+	neighbor := access.Dial("neighbor")
+	defer func() { _ = neighbor.Close() }()
+	server := provisioning.Dial("server")
+	defer func() { _ = server.Close() }()
+	send := func(guest net.Conn, raw []byte) {
+		t.Helper()
+		if _, err := guest.Write(qemuFrame(raw)); err != nil {
+			t.Fatalf("Failed to send frame: %v", err)
+		}
+	}
+
+	// The VM's DHCP discover is switched in the provisioning VLAN, and the
+	// server's offer comes back to the VM
+	send(vm, dhcpFrame(vmMAC, dhcpClientPort, dhcpServerPort, dhcpClientMessage(dhcpDiscover, vmMAC, nil)).Raw)
+	if frame := readGuestFrame(t, server); frame.SrcMAC.String() != vmMAC.String() {
+		t.Errorf("Expected the VM's discover, got a frame from %s", frame.SrcMAC)
+	}
+	offer := &dhcpMessage{Op: bootReply, XID: 0xabcd, CHAddr: vmMAC, Options: map[uint8][]byte{dhcpOptMessageType: {dhcpOffer}}}
+	send(server, dhcpFrame(serverMAC, dhcpServerPort, dhcpClientPort, offer).Raw)
+	if frame := readGuestFrame(t, vm); frame.SrcMAC.String() != serverMAC.String() {
+		t.Errorf("Expected the server's offer, got a frame from %s", frame.SrcMAC)
+	}
+
+	// Other protocols stay in the VM's own VLAN
+	send(vm, buildEthernet(BroadcastMAC, vmMAC, EtherTypeARP, make([]byte, 46)))
+	if frame := readGuestFrame(t, neighbor); frame.EtherType != EtherTypeARP {
+		t.Errorf("Expected the VM's ARP broadcast, got %04x", frame.EtherType)
+	}
+	if diverted := access.GetStats()["protocol_vlan_frames"]; diverted != uint64(1) {
+		t.Errorf("Expected 1 frame switched in another VLAN, got %v", diverted)
+	}
+	if _, found := connectionInfo(provisioning, "vm@8080"); !found {
+		t.Errorf("Expected the VM to stand in the provisioning VLAN")
+	}
+
+	// Frames of a VLAN that went away are dropped
+	if err := sm.RemoveVLAN(8090); err != nil {
+		t.Fatalf("Failed to remove VLAN: %v", err)
+	}
+	send(vm, dhcpFrame(vmMAC, dhcpClientPort, dhcpServerPort, dhcpClientMessage(dhcpDiscover, vmMAC, nil)).Raw)
+	waitUntil(t, "the frame is dropped", func() bool { return access.GetStats()["protocol_vlan_drops"] == uint64(1) })
+
+	// The VM leaves the other VLANs with its own
+	_ = vm.Close()
+	waitUntil(t, "the VM's pipes are closed", func() bool {
+		access.protocolVLANs.mutex.Lock()
+		defer access.protocolVLANs.mutex.Unlock()
+		return len(access.protocolVLANs.ends) == 1
+	})
+}
@@ -0,0 +1,226 @@
+package vswitch
+
+import (
+	"encoding/binary"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	// protocolPortLimit bounds the distinct ports counted per VLAN; once
+	// reached, frames to new ports are only counted by protocol
+	protocolPortLimit = 1024
+
+	// protocolTopPorts is the number of busiest ports reported
+	protocolTopPorts = 10
+)
+
+// ipProtocolClass groups sampled IP packets by transport protocol
+type ipProtocolClass int
+
+const (
+	ipProtocolTCP ipProtocolClass = iota
+	ipProtocolUDP
+	ipProtocolICMP
+	ipProtocolICMPv6
+	ipProtocolOther
+	ipProtocolClassCount
+)
+
+// ipProtocolClassNames names the classes in the statistics
+var ipProtocolClassNames = [ipProtocolClassCount]string{
+	ipProtocolTCP:    "tcp",
+	ipProtocolUDP:    "udp",
+	ipProtocolICMP:   "icmp",
+	ipProtocolICMPv6: "icmpv6",
+	ipProtocolOther:  "other",
+}
+
+// dnsTypeNames names the DNS query types counted separately; others are
+// counted as "other"
+var dnsTypeNames = map[uint16]string{
+	1:  "A",
+	2:  "NS",
+	5:  "CNAME",
+	6:  "SOA",
+	12: "PTR",
+	15: "MX",
+	16: "TXT",
+	28: "AAAA",
+	33: "SRV",
+	65: "HTTPS",
+}
+
+// ipProtocolClassOf returns the class of an IP protocol number
+func ipProtocolClassOf(protocol uint8) ipProtocolClass {
+	switch protocol {
+	case ipProtoTCP:
+		return ipProtocolTCP
+	case ipProtoUDP:
+		return ipProtocolUDP
+	case ipProtoICMP:
+		return ipProtocolICMP
+	case ipProtoICMPv6:
+		return ipProtocolICMPv6
+	default:
+		return ipProtocolOther
+	}
+}
+
+// PortCount is the number of sampled frames to or from a service port
+type PortCount struct {
+	Port   string `json:"port"`
+	Frames uint64 `json:"frames"`
+}
+
+// ProtocolStats is the breakdown of the sampled frames of a VLAN. The
+// counts are of sampled frames; multiply them by the sample rate to
+// estimate the totals.
+type ProtocolStats struct {
+	SampleRate    int               `json:"sample_rate"`
+	SampledFrames uint64            `json:"sampled_frames"`
+	IPProtocols   map[string]uint64 `json:"ip_protocols"`
+	TopPorts      []PortCount       `json:"top_ports"`
+	DNSQueries    map[string]uint64 `json:"dns_queries"`
+}
+
+// protocolStats decodes one in every rate frames received by a VLAN and
+// counts them by transport protocol, service port and DNS query type
+type protocolStats struct {
+	rate      uint64
+	seen      atomic.Uint64
+	sampled   atomic.Uint64
+	protocols [ipProtocolClassCount]atomic.Uint64
+
+	mutex      sync.Mutex
+	ports      map[string]uint64
+	dnsQueries map[string]uint64
+}
+
+// newProtocolStats creates the protocol statistics of a VLAN sampling one
+// in rate frames
+func newProtocolStats(rate int) *protocolStats {
+	return &protocolStats{
+		rate:       uint64(rate),
+		ports:      make(map[string]uint64),
+		dnsQueries: make(map[string]uint64),
+	}
+}
+
+// observe counts a received frame if it is due to be sampled
+func (p *protocolStats) observe(frame *EthernetFrame) {
+	if p.seen.Add(1)%p.rate != 0 {
+		return
+	}
+	p.sampled.Add(1)
+
+	var protocol uint8
+	var payload []byte
+	switch frame.EtherType {
+	case EtherTypeIPv4:
+		packet, err := parseIPv4(frame.Payload)
+		if err != nil {
+			return
+		}
+		protocol = packet.Protocol
+		// Only the first fragment carries the transport header
+		if packet.FragmentOffset == 0 {
+			payload = packet.Payload
+		}
+	case EtherTypeIPv6:
+		packet, err := parseIPv6(frame.Payload)
+		if err != nil {
+			return
+		}
+		protocol, payload = packet.NextHeader, packet.Payload
+	default:
+		return
+	}
+	p.protocols[ipProtocolClassOf(protocol)].Add(1)
+
+	switch protocol {
+	case ipProtoTCP:
+		if segment, err := parseTCP(payload); err == nil {
+			p.countPort("tcp", segment.SrcPort, segment.DstPort)
+		}
+	case ipProtoUDP:
+		datagram, err := parseUDP(payload)
+		if err != nil {
+			return
+		}
+		p.countPort("udp", datagram.SrcPort, datagram.DstPort)
+		if datagram.DstPort == dnsPort {
+			p.countDNSQuery(datagram.Payload)
+		}
+	}
+}
+
+// countPort counts a frame by its service port, taken to be the lower of
+// the two ports as clients use high ephemeral ones
+func (p *protocolStats) countPort(protocol string, src, dst uint16) {
+	key := protocol + "/" + strconv.Itoa(int(min(src, dst)))
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if _, found := p.ports[key]; found || len(p.ports) < protocolPortLimit {
+		p.ports[key]++
+	}
+}
+
+// countDNSQuery counts a DNS query by the type of its question
+func (p *protocolStats) countDNSQuery(message []byte) {
+	// Responses to queries sent from port 53 are not queries
+	if len(message) < dnsHeaderSize || message[2]&0x80 != 0 {
+		return
+	}
+	if binary.BigEndian.Uint16(message[4:6]) == 0 {
+		return
+	}
+	_, qtype, _, _, err := parseDNSQuestion(message)
+	if err != nil {
+		return
+	}
+	name, found := dnsTypeNames[qtype]
+	if !found {
+		name = "other"
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.dnsQueries[name]++
+}
+
+// snapshot returns the current protocol statistics
+func (p *protocolStats) snapshot() ProtocolStats {
+	stats := ProtocolStats{
+		SampleRate:    int(p.rate),
+		SampledFrames: p.sampled.Load(),
+		IPProtocols:   make(map[string]uint64, ipProtocolClassCount),
+	}
+	for class, name := range ipProtocolClassNames {
+		stats.IPProtocols[name] = p.protocols[class].Load()
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	stats.TopPorts = make([]PortCount, 0, len(p.ports))
+	for port, frames := range p.ports {
+		stats.TopPorts = append(stats.TopPorts, PortCount{Port: port, Frames: frames})
+	}
+	sort.Slice(stats.TopPorts, func(i, j int) bool {
+		if stats.TopPorts[i].Frames != stats.TopPorts[j].Frames {
+			return stats.TopPorts[i].Frames > stats.TopPorts[j].Frames
+		}
+		return stats.TopPorts[i].Port < stats.TopPorts[j].Port
+	})
+	if len(stats.TopPorts) > protocolTopPorts {
+		stats.TopPorts = stats.TopPorts[:protocolTopPorts]
+	}
+	stats.DNSQueries = make(map[string]uint64, len(p.dnsQueries))
+	for name, count := range p.dnsQueries {
+		stats.DNSQueries[name] = count
+	}
+	return stats
+}
@@ -0,0 +1,111 @@
+package vswitch
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestProtocolStats(t *testing.T) {
+	sw := NewVirtualSwitchWithConfig([]int{8080}, Config{ProtocolStats: 1})
+	conn := NewConnection("guest", &frameSink{})
+	sw.connections.Store("guest", conn)
+
+	src := net.HardwareAddr{0x52, 0x54, 0x00, 0x00, 0x00, 0x01}
+	client, server := net.IPv4(10, 0, 0, 2), net.IPv4(10, 0, 0, 1)
+	ipv4 := func(packet []byte) []byte {
+		return buildEthernet(BroadcastMAC, src, EtherTypeIPv4, packet)
+	}
+	frames := [][]byte{
+		ipv4(buildTCPv4(client, server, &tcpSegment{SrcPort: 50000, DstPort: 443, Flags: tcpSYN})),
+		ipv4(buildTCPv4(server, client, &tcpSegment{SrcPort: 443, DstPort: 50000, Flags: tcpSYN | tcpACK})),
+		ipv4(buildTCPv4(client, server, &tcpSegment{SrcPort: 50001, DstPort: 22, Flags: tcpSYN})),
+		ipv4(buildUDPv4(client, server, 40000, dnsPort, dnsQuery(1, "example.com", dnsTypeAAAA))),
+		ipv4(buildUDPv4(client, server, 40001, dnsPort, dnsQuery(2, "example.com", dnsTypeA))),
+		ipv4(buildUDPv4(client, server, 40002, dnsPort, dnsQuery(3, "example.com", 99))),
+		ipv4(buildIPv4(client, server, ipProtoICMP, 64, make([]byte, 8))),
+		buildEthernet(BroadcastMAC, src, EtherTypeARP, make([]byte, 28)),
+	}
+	for _, frame := range frames {
+		sw.handleFrame(rawFrame(frame), conn)
+	}
+
+	stats, ok := sw.GetStats()["protocol_stats"].(ProtocolStats)
+	if !ok {
+		t.Fatalf("Expected protocol statistics")
+	}
+	if stats.SampledFrames != uint64(len(frames)) {
+		t.Errorf("Expected %d sampled frames, got %d", len(frames), stats.SampledFrames)
+	}
+	for protocol, count := range map[string]uint64{"tcp": 3, "udp": 3, "icmp": 1, "icmpv6": 0, "other": 0} {
+		if stats.IPProtocols[protocol] != count {
+			t.Errorf("Expected %d %s frames, got %d", count, protocol, stats.IPProtocols[protocol])
+		}
+	}
+
+	// Both directions of a connection count for its service port
+	expected := []PortCount{{"udp/53", 3}, {"tcp/443", 2}, {"tcp/22", 1}}
+	if !slices.Equal(stats.TopPorts, expected) {
+		t.Errorf("Expected top ports %+v, got %+v", expected, stats.TopPorts)
+	}
+	for name, count := range map[string]uint64{"A": 1, "AAAA": 1, "other": 1} {
+		if stats.DNSQueries[name] != count {
+			t.Errorf("Expected %d %s queries, got %v", count, name, stats.DNSQueries)
+		}
+	}
+}
+
+func TestProtocolStatsSampling(t *testing.T) {
+	sw := NewVirtualSwitchWithConfig([]int{8080}, Config{ProtocolStats: 4})
+	conn := NewConnection("guest", &frameSink{})
+	sw.connections.Store("guest", conn)
+
+	src := net.HardwareAddr{0x52, 0x54, 0x00, 0x00, 0x00, 0x01}
+	frame := buildEthernet(BroadcastMAC, src, EtherTypeIPv4,
+		buildUDPv4(net.IPv4(10, 0, 0, 2), net.IPv4(10, 0, 0, 1), 40000, 5353, make([]byte, 8)))
+	for i := 0; i < 10; i++ {
+		sw.handleFrame(rawFrame(frame), conn)
+	}
+
+	stats := sw.GetStats()["protocol_stats"].(ProtocolStats)
+	if stats.SampleRate != 4 || stats.SampledFrames != 2 || stats.IPProtocols["udp"] != 2 {
+		t.Errorf("Expected 2 of 10 frames sampled, got %+v", stats)
+	}
+
+	// Without a sample rate there is no breakdown
+	if _, ok := NewVirtualSwitch([]int{8081}).GetStats()["protocol_stats"]; ok {
+		t.Errorf("Expected no protocol statistics by default")
+	}
+}
+
+func TestProtocolMetrics(t *testing.T) {
+	sm := NewSwitchManager()
+	defer sm.StopAll()
+	if err := sm.AddVLANWithConfig(8080, Config{ProtocolStats: 1}); err != nil {
+		t.Fatalf("Failed to add VLAN: %v", err)
+	}
+	vs := sm.vlan(8080)
+	conn := NewConnection("guest", &frameSink{})
+	vs.connections.Store("guest", conn)
+
+	src := net.HardwareAddr{0x52, 0x54, 0x00, 0x00, 0x00, 0x01}
+	vs.handleFrame(rawFrame(buildEthernet(BroadcastMAC, src, EtherTypeIPv4,
+		buildUDPv4(net.IPv4(10, 0, 0, 2), net.IPv4(10, 0, 0, 1), 40000, dnsPort, dnsQuery(1, "example.com", dnsTypeA)))), conn)
+
+	recorder := httptest.NewRecorder()
+	NewAPIHandler(sm, "").ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := recorder.Body.String()
+	for _, line := range []string{
+		`vswitch_protocol_sample_rate{vlan="8080"} 1`,
+		`vswitch_protocol_sampled_frames{vlan="8080",protocol="udp"} 1`,
+		`vswitch_protocol_sampled_port_frames{vlan="8080",port="udp/53"} 1`,
+		`vswitch_protocol_sampled_dns_queries{vlan="8080",type="A"} 1`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("Expected metric line %q in:\n%s", line, body)
+		}
+	}
+}
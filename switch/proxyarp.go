@@ -0,0 +1,68 @@
+package vswitch
+
+import (
+	"net"
+	"sync/atomic"
+)
+
+// ProxyARPEntry is an IPv4 address the switch answers ARP requests for
+type ProxyARPEntry struct {
+	IP net.IP
+	// MAC is returned in replies; nil answers with the switch's own service MAC
+	MAC net.HardwareAddr
+}
+
+// proxyARP answers ARP requests for configured addresses directly instead of
+// flooding them, e.g. to emulate a virtual gateway
+type proxyARP struct {
+	vs      *VirtualSwitch
+	entries map[string]net.HardwareAddr // IP -> MAC
+	replies atomic.Uint64
+}
+
+// newProxyARP creates an ARP responder for the configured entries
+func newProxyARP(vs *VirtualSwitch, entries []ProxyARPEntry) *proxyARP {
+	p := &proxyARP{
+		vs:      vs,
+		entries: make(map[string]net.HardwareAddr, len(entries)),
+	}
+
+	for _, entry := range entries {
+		mac := entry.MAC
+		if mac == nil {
+			mac = serviceMAC(vs.port())
+		}
+		p.entries[entry.IP.To4().String()] = mac
+	}
+
+	return p
+}
+
+// handleFrame answers and consumes ARP requests for proxied addresses
+func (p *proxyARP) handleFrame(frame *EthernetFrame, _ *Connection) bool {
+	if frame.EtherType != EtherTypeARP {
+		return false
+	}
+
+	request, err := parseARP(frame.Payload)
+	if err != nil || request.Op != arpRequest {
+		return false
+	}
+
+	mac, found := p.entries[request.TargetIP.String()]
+	if !found {
+		return false
+	}
+
+	// Let the address owner's own probes and announcements through untouched
+	if request.SenderMAC.String() == mac.String() {
+		return false
+	}
+
+	p.vs.injectFrame(buildARPReply(request, mac))
+	p.replies.Add(1)
+	return true
+}
+
+// run returns immediately; the responder is driven entirely by received frames
+func (p *proxyARP) run(_ <-chan bool) {}
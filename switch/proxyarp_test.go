@@ -0,0 +1,75 @@
+package vswitch
+
+import (
+	"net"
+	"testing"
+)
+
+func TestProxyARP(t *testing.T) {
+	gatewayMAC := net.HardwareAddr{0x52, 0x54, 0x00, 0x00, 0x00, 0xfe}
+	sw := NewVirtualSwitchWithConfig([]int{8080}, Config{
+		ProxyARP: []ProxyARPEntry{
+			{IP: net.ParseIP("10.0.0.1"), MAC: gatewayMAC},
+			{IP: net.ParseIP("10.0.0.2")},
+		},
+	})
+
+	mockGuest := &mockConnSwitch{addr: &mockAddrSwitch{network: "tcp", address: "127.0.0.1:9001"}}
+	mockOther := &mockConnSwitch{addr: &mockAddrSwitch{network: "tcp", address: "127.0.0.1:9002"}}
+	guest := NewConnection("guest", mockGuest)
+	other := NewConnection("other", mockOther)
+	sw.connections.Store("guest", guest)
+	sw.connections.Store("other", other)
+
+	guestMAC := net.HardwareAddr{0x52, 0x54, 0x00, 0x00, 0x00, 0x01}
+	request := func(sender net.HardwareAddr, target string) *EthernetFrame {
+		arp := &arpPacket{
+			Op:        arpRequest,
+			SenderMAC: sender,
+			SenderIP:  net.ParseIP("10.0.0.10"),
+			TargetMAC: make(net.HardwareAddr, 6),
+			TargetIP:  net.ParseIP(target),
+		}
+		return rawFrame(buildEthernet(BroadcastMAC, sender, EtherTypeARP, arp.marshal()))
+	}
+
+	// Requests for proxied addresses are answered without flooding
+	_ = sw.processFrame(request(guestMAC, "10.0.0.1"), guest)
+	_ = sw.processFrame(request(guestMAC, "10.0.0.2"), guest)
+
+	if len(mockOther.writeData) != 0 {
+		t.Errorf("Expected proxied ARP requests not to be flooded")
+	}
+
+	frames := writtenFrames(t, mockGuest.writeData)
+	if len(frames) != 2 {
+		t.Fatalf("Expected 2 ARP replies, got %d", len(frames))
+	}
+
+	reply, _ := parseARP(rawFrame(frames[0]).Payload)
+	if reply.SenderMAC.String() != gatewayMAC.String() || !reply.SenderIP.Equal(net.ParseIP("10.0.0.1")) {
+		t.Errorf("Unexpected reply for configured MAC: %+v", reply)
+	}
+
+	reply, _ = parseARP(rawFrame(frames[1]).Payload)
+	if reply.SenderMAC.String() != serviceMAC(8080).String() {
+		t.Errorf("Expected default entry to answer with the service MAC, got %s", reply.SenderMAC)
+	}
+
+	// Other addresses are flooded as usual
+	_ = sw.processFrame(request(guestMAC, "10.0.0.3"), guest)
+	if len(mockOther.writeData) == 0 {
+		t.Errorf("Expected unproxied ARP request to be flooded")
+	}
+
+	// The address owner's own requests are not answered
+	mockGuest.writeData = nil
+	_ = sw.processFrame(request(gatewayMAC, "10.0.0.1"), other)
+	if len(mockGuest.writeData) == 0 || len(writtenFrames(t, mockOther.writeData)) != 1 {
+		t.Errorf("Expected the owner's request to be flooded rather than answered")
+	}
+
+	if stats := sw.GetStats(); stats["proxy_arp_replies"] != uint64(2) {
+		t.Errorf("Expected 2 proxy ARP replies, got %v", stats["proxy_arp_replies"])
+	}
+}
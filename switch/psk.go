@@ -0,0 +1,154 @@
+package vswitch
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+const (
+	// authTimeout bounds how long a client may take to answer the challenge
+	authTimeout = 10 * time.Second
+
+	// authNonceSize is the size of the random challenge
+	authNonceSize = 32
+
+	// minPSKLength is the length of the shortest pre-shared key accepted
+	minPSKLength = 16
+
+	// authChallengeMagic and authResponseMagic follow the Ethernet header of
+	// the challenge and response frames, which travel with the hello's
+	// EtherType
+	authChallengeMagic = "vswitch-challenge"
+	authResponseMagic  = "vswitch-response"
+)
+
+// errAuthFailed is the error of clients answering the challenge wrongly
+var errAuthFailed = errors.New("authentication failed")
+
+// The pre-shared key handshake gives VLANs with a PSK basic access control
+// without TLS. As soon as a connection is accepted, the switch sends a
+// challenge frame carrying a random nonce; the client must answer with a
+// response frame carrying the HMAC-SHA256 of the nonce keyed with the PSK
+// within authTimeout. Only then does the connection join the VLAN, so an
+// unauthenticated client neither sends nor receives any frame. Both frames
+// use the stream framing of any other frame, with EtherType 0x88b6 and a
+// payload starting with "vswitch-challenge" or "vswitch-response".
+
+// authFrame builds a challenge or response frame
+func authFrame(magic string, body []byte) []byte {
+	return buildEthernet(BroadcastMAC, make(net.HardwareAddr, 6), helloEtherType, append([]byte(magic), body...))
+}
+
+// authBody returns what follows the magic of a challenge or response frame
+func authBody(frame *EthernetFrame, magic string, size int) ([]byte, bool) {
+	if frame.EtherType != helloEtherType || !bytes.HasPrefix(frame.Payload, []byte(magic)) {
+		return nil, false
+	}
+	body := frame.Payload[len(magic):]
+	if len(body) < size {
+		return nil, false
+	}
+	return body[:size], true
+}
+
+// authMAC returns the answer to a challenge
+func authMAC(psk string, nonce []byte) []byte {
+	mac := hmac.New(sha256.New, []byte(psk))
+	mac.Write(nonce)
+	return mac.Sum(nil)
+}
+
+// challenge asks a new connection to prove it holds the VLAN's PSK
+func (vs *VirtualSwitch) challenge(conn net.Conn) error {
+	nonce := make([]byte, authNonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+
+	// Without a deadline, a client never answering would hold its
+	// connection open forever
+	if err := conn.SetDeadline(time.Now().Add(authTimeout)); err != nil {
+		return err
+	}
+	defer func() { _ = conn.SetDeadline(time.Time{}) }()
+
+	peer := NewConnection("", conn)
+	if err := peer.writeData(authFrame(authChallengeMagic, nonce)); err != nil {
+		return err
+	}
+	frame, err := peer.ReadFrame()
+	if err != nil {
+		return err
+	}
+	defer frame.Release()
+
+	answer, ok := authBody(frame, authResponseMagic, sha256.Size)
+	if !ok {
+		return fmt.Errorf("%w: expected a response to the challenge", errAuthFailed)
+	}
+	if !hmac.Equal(answer, authMAC(vs.config.PSK, nonce)) {
+		return errAuthFailed
+	}
+	return nil
+}
+
+// authenticate attaches an accepted connection to the VLAN once its client
+// proved it holds the PSK, and closes it otherwise. The caller added it to
+// the wait group.
+func (vs *VirtualSwitch) authenticate(id string, conn net.Conn, port int) {
+	defer vs.wg.Done()
+
+	// Stopping the switch interrupts the handshake
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-vs.shutdown:
+			_ = conn.Close()
+		case <-done:
+		}
+	}()
+
+	if err := vs.challenge(conn); err != nil {
+		vs.rejectedConns.Add(1)
+		vs.logger.Warn("Rejected unauthenticated connection", "port", port, "remote", conn.RemoteAddr().String(), "error", err)
+		vs.events.publish(Event{Type: EventConnectionRejected, VLAN: port, RemoteAddr: conn.RemoteAddr().String(), Reason: "authentication failed"})
+		_ = conn.Close()
+		return
+	}
+
+	select {
+	case <-vs.shutdown:
+		_ = conn.Close()
+	default:
+		vs.attach(id, conn, nil)
+	}
+}
+
+// Authenticate answers the switch's challenge on a new connection to a VLAN
+// with a PSK, proving the client holds the key. It must be called before
+// any frame is sent or read on the connection.
+func Authenticate(conn net.Conn, psk string) error {
+	_ = conn.SetDeadline(time.Now().Add(authTimeout))
+	defer func() { _ = conn.SetDeadline(time.Time{}) }()
+
+	peer := NewConnection("", conn)
+	peer.maxFrame = jumboFrameSize
+	frame, err := peer.ReadFrame()
+	if err != nil {
+		return fmt.Errorf("failed to read the challenge: %w", err)
+	}
+	defer frame.Release()
+
+	nonce, ok := authBody(frame, authChallengeMagic, authNonceSize)
+	if !ok {
+		return fmt.Errorf("expected a challenge, got %s", frame)
+	}
+	return peer.writeData(authFrame(authResponseMagic, authMAC(psk, nonce)))
+}
@@ -0,0 +1,96 @@
+package vswitch
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+const testPSK = "correct horse battery staple"
+
+func TestChallenge(t *testing.T) {
+	sw := NewVirtualSwitchWithConfig([]int{8080}, Config{PSK: testPSK})
+
+	for psk, expected := range map[string]bool{testPSK: true, "wrong horse battery staple": false} {
+		client, server := net.Pipe()
+		done := make(chan error, 1)
+		go func() { done <- Authenticate(client, psk) }()
+		err := sw.challenge(server)
+		if (err == nil) != expected {
+			t.Errorf("Challenge with key %q: got %v", psk, err)
+		}
+		if err := <-done; err != nil {
+			t.Errorf("Failed to answer the challenge: %v", err)
+		}
+		_ = client.Close()
+		_ = server.Close()
+	}
+
+	if err := (Config{PSK: "short"}).validate(); err == nil {
+		t.Errorf("Expected a short PSK to be rejected")
+	}
+}
+
+func TestPSKConnections(t *testing.T) {
+	probe, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to find a free port: %v", err)
+	}
+	port := probe.Addr().(*net.TCPAddr).Port
+	_ = probe.Close()
+
+	sw := NewVirtualSwitchWithConfig([]int{port}, Config{PSK: testPSK})
+	if err := sw.Start(context.Background()); err != nil {
+		t.Fatalf("Failed to start switch: %v", err)
+	}
+	defer sw.Stop()
+
+	dial := func() net.Conn {
+		conn, err := net.Dial("tcp", probe.Addr().String())
+		if err != nil {
+			t.Fatalf("Failed to connect: %v", err)
+		}
+		return conn
+	}
+
+	// A client holding the key joins the VLAN
+	guest := dial()
+	defer func() { _ = guest.Close() }()
+	if err := Authenticate(guest, testPSK); err != nil {
+		t.Fatalf("Failed to authenticate: %v", err)
+	}
+	waitUntil(t, "the client is connected", func() bool { return sw.connectionCount() == 1 })
+
+	// A client with another key is disconnected without joining
+	intruder := dial()
+	defer func() { _ = intruder.Close() }()
+	if err := Authenticate(intruder, "wrong horse battery staple"); err != nil {
+		t.Fatalf("Failed to answer the challenge: %v", err)
+	}
+	_ = intruder.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := intruder.Read(make([]byte, 1)); err == nil {
+		t.Errorf("Expected the connection to be closed")
+	}
+	if stats := sw.GetStats(); stats["rejected_connections"] != uint64(1) {
+		t.Errorf("Expected 1 rejected connection, got %v", stats["rejected_connections"])
+	}
+
+	// A client that has not answered yet receives nothing but the challenge
+	pending := dial()
+	defer func() { _ = pending.Close() }()
+	if frame := readGuestFrame(t, pending); frame.EtherType != helloEtherType {
+		t.Fatalf("Expected a challenge, got %s", frame)
+	}
+	mac := net.HardwareAddr{0x52, 0x54, 0x00, 0x00, 0x00, 0x01}
+	if _, err := guest.Write(qemuFrame(buildEthernet(BroadcastMAC, mac, EtherTypeIPv4, make([]byte, 46)))); err != nil {
+		t.Fatalf("Failed to send frame: %v", err)
+	}
+	_ = pending.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	if n, _ := pending.Read(make([]byte, 1)); n != 0 {
+		t.Errorf("Expected an unauthenticated client to receive no frames")
+	}
+	if count := sw.connectionCount(); count != 1 {
+		t.Errorf("Expected 1 connection, got %d", count)
+	}
+}
@@ -0,0 +1,136 @@
+package vswitch
+
+import (
+	"encoding/binary"
+	"net"
+	"time"
+)
+
+// ICMPv6 neighbor discovery message types
+const (
+	icmpv6RouterSolicitation    = 133
+	icmpv6RouterAdvertisement   = 134
+	icmpv6NeighborSolicitation  = 135
+	icmpv6NeighborAdvertisement = 136
+)
+
+const (
+	// raInterval is how often unsolicited router advertisements are sent
+	raInterval = 200 * time.Second
+
+	// Lifetimes announced for SLAAC prefixes and DNS servers
+	raValidLifetime     = 86400
+	raPreferredLifetime = 14400
+	raDNSLifetime       = 3 * uint32(raInterval/time.Second)
+)
+
+// allNodesIPv6 is the IPv6 link-local all-nodes multicast group
+var allNodesIPv6 = net.ParseIP("ff02::1")
+
+// raService announces IPv6 prefixes in router advertisements so guests can
+// configure addresses with SLAAC. The switch does not route, so it
+// advertises a router lifetime of zero and never becomes a default router.
+type raService struct {
+	vs        *VirtualSwitch
+	mac       net.HardwareAddr
+	linkLocal net.IP
+	prefixes  []*net.IPNet
+	dns       []net.IP
+}
+
+// newRAService creates a router advertisement service for the switch
+func newRAService(vs *VirtualSwitch) *raService {
+	mac := serviceMAC(vs.port())
+	return &raService{
+		vs:        vs,
+		mac:       mac,
+		linkLocal: linkLocalFromMAC(mac),
+		prefixes:  vs.config.SLAACPrefixes,
+		dns:       vs.config.SLAACDNS,
+	}
+}
+
+// handleFrame answers router solicitations. Solicitations are still
+// forwarded so that any router VM on the VLAN also sees them.
+func (r *raService) handleFrame(frame *EthernetFrame, _ *Connection) bool {
+	if frame.EtherType != EtherTypeIPv6 {
+		return false
+	}
+
+	packet, err := parseIPv6(frame.Payload)
+	if err != nil || packet.NextHeader != ipProtoICMPv6 || packet.HopLimit != 255 {
+		return false
+	}
+
+	if len(packet.Payload) < 8 || packet.Payload[0] != icmpv6RouterSolicitation {
+		return false
+	}
+
+	// Reply directly unless the solicitation came from the unspecified address
+	if packet.Src.IsUnspecified() {
+		r.vs.injectFrame(r.buildAdvertisement(ipv6MulticastMAC(allNodesIPv6), allNodesIPv6))
+	} else {
+		r.vs.injectFrame(r.buildAdvertisement(frame.SrcMAC, packet.Src))
+	}
+
+	return false
+}
+
+// run sends unsolicited router advertisements periodically
+func (r *raService) run(shutdown <-chan bool) {
+	r.vs.logger.Info("Announcing SLAAC prefixes", "prefixes", r.prefixes)
+
+	ticker := time.NewTicker(raInterval)
+	defer ticker.Stop()
+
+	for {
+		r.vs.injectFrame(r.buildAdvertisement(ipv6MulticastMAC(allNodesIPv6), allNodesIPv6))
+
+		select {
+		case <-shutdown:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// buildAdvertisement builds a complete router advertisement frame
+func (r *raService) buildAdvertisement(dstMAC net.HardwareAddr, dstIP net.IP) []byte {
+	// Header: type, code, checksum, hop limit, flags, router lifetime,
+	// reachable time and retransmit timer
+	msg := make([]byte, 16, 16+8+32*len(r.prefixes)+8+16*len(r.dns))
+	msg[0] = icmpv6RouterAdvertisement
+	msg[4] = 64
+
+	// Source link-layer address option
+	msg = append(msg, 1, 1)
+	msg = append(msg, r.mac...)
+
+	// Prefix information options with the on-link and autonomous flags
+	for _, prefix := range r.prefixes {
+		opt := make([]byte, 32)
+		opt[0] = 3
+		opt[1] = 4
+		opt[2] = 64
+		opt[3] = 0xc0
+		binary.BigEndian.PutUint32(opt[4:8], raValidLifetime)
+		binary.BigEndian.PutUint32(opt[8:12], raPreferredLifetime)
+		copy(opt[16:32], prefix.IP.To16())
+		msg = append(msg, opt...)
+	}
+
+	// Recursive DNS server option
+	if len(r.dns) > 0 {
+		opt := make([]byte, 8+16*len(r.dns))
+		opt[0] = 25
+		opt[1] = byte(1 + 2*len(r.dns))
+		binary.BigEndian.PutUint32(opt[4:8], raDNSLifetime)
+		for i, server := range r.dns {
+			copy(opt[8+16*i:], server.To16())
+		}
+		msg = append(msg, opt...)
+	}
+
+	packet := buildIPv6(r.linkLocal, dstIP, ipProtoICMPv6, 255, msg, 2)
+	return buildEthernet(dstMAC, r.mac, EtherTypeIPv6, packet)
+}
@@ -0,0 +1,113 @@
+package vswitch
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+)
+
+func TestRAServiceAnswersSolicitation(t *testing.T) {
+	_, prefix, _ := net.ParseCIDR("2001:db8:1::/64")
+	dns := net.ParseIP("2001:db8:1::53")
+	sw := NewVirtualSwitchWithConfig([]int{8080}, Config{
+		SLAACPrefixes: []*net.IPNet{prefix},
+		SLAACDNS:      []net.IP{dns},
+	})
+
+	if len(sw.services) != 1 {
+		t.Fatalf("Expected RA service to be configured, got %d services", len(sw.services))
+	}
+
+	mockConn := &mockConnSwitch{addr: &mockAddrSwitch{network: "tcp", address: "127.0.0.1:9001"}}
+	conn := NewConnection("conn1", mockConn)
+	sw.connections.Store("conn1", conn)
+
+	// Router solicitation from a guest link-local address
+	guestMAC := net.HardwareAddr{0x52, 0x54, 0x00, 0x12, 0x34, 0x56}
+	guestIP := linkLocalFromMAC(guestMAC)
+	allRouters := net.ParseIP("ff02::2")
+	rs := buildIPv6(guestIP, allRouters, ipProtoICMPv6, 255, []byte{icmpv6RouterSolicitation, 0, 0, 0, 0, 0, 0, 0}, 2)
+	raw := buildEthernet(ipv6MulticastMAC(allRouters), guestMAC, EtherTypeIPv6, rs)
+	frame, _ := ParseEthernetFrame(raw)
+	frame.pooled = false
+
+	if err := sw.processFrame(frame, conn); err != nil {
+		t.Fatalf("Unexpected error processing solicitation: %v", err)
+	}
+
+	frames := writtenFrames(t, mockConn.writeData)
+	if len(frames) != 1 {
+		t.Fatalf("Expected 1 advertisement, got %d frames", len(frames))
+	}
+
+	reply, _ := ParseEthernetFrame(frames[0])
+	if reply.DestMAC.String() != guestMAC.String() {
+		t.Errorf("Expected advertisement addressed to %s, got %s", guestMAC, reply.DestMAC)
+	}
+
+	packet, err := parseIPv6(reply.Payload)
+	if err != nil {
+		t.Fatalf("Failed to parse advertisement: %v", err)
+	}
+
+	if !packet.Dst.Equal(guestIP) || packet.HopLimit != 255 {
+		t.Errorf("Unexpected advertisement destination %s or hop limit %d", packet.Dst, packet.HopLimit)
+	}
+
+	if ipv6Checksum(packet.Src, packet.Dst, ipProtoICMPv6, packet.Payload) != 0 {
+		t.Errorf("Advertisement checksum does not verify")
+	}
+
+	msg := packet.Payload
+	if msg[0] != icmpv6RouterAdvertisement {
+		t.Fatalf("Expected router advertisement, got ICMPv6 type %d", msg[0])
+	}
+
+	if lifetime := binary.BigEndian.Uint16(msg[6:8]); lifetime != 0 {
+		t.Errorf("Expected zero router lifetime, got %d", lifetime)
+	}
+
+	// Walk the options looking for the prefix and DNS server
+	var foundPrefix, foundDNS bool
+	for opts := msg[16:]; len(opts) >= 8; opts = opts[int(opts[1])*8:] {
+		switch opts[0] {
+		case 3:
+			foundPrefix = net.IP(opts[16:32]).Equal(prefix.IP) && opts[2] == 64 && opts[3]&0x40 != 0
+		case 25:
+			foundDNS = net.IP(opts[8:24]).Equal(dns)
+		}
+	}
+
+	if !foundPrefix {
+		t.Errorf("Expected autonomous prefix option for %s", prefix)
+	}
+
+	if !foundDNS {
+		t.Errorf("Expected RDNSS option for %s", dns)
+	}
+}
+
+func TestRAServiceIgnoresOtherTraffic(t *testing.T) {
+	_, prefix, _ := net.ParseCIDR("2001:db8:1::/64")
+	sw := NewVirtualSwitchWithConfig([]int{8080}, Config{SLAACPrefixes: []*net.IPNet{prefix}})
+
+	mockConn := &mockConnSwitch{addr: &mockAddrSwitch{network: "tcp", address: "127.0.0.1:9001"}}
+	conn := NewConnection("conn1", mockConn)
+	sw.connections.Store("conn1", conn)
+
+	frame := &EthernetFrame{
+		DestMAC:   BroadcastMAC,
+		SrcMAC:    net.HardwareAddr{0x02, 0x00, 0x00, 0x00, 0x00, 0x01},
+		EtherType: EtherTypeIPv4,
+		Payload:   make([]byte, 46),
+		Raw:       make([]byte, 60),
+	}
+
+	if sw.services[0].handleFrame(frame, conn) {
+		t.Errorf("Expected non-solicitation traffic not to be consumed")
+	}
+
+	if len(mockConn.writeData) != 0 {
+		t.Errorf("Expected no advertisement for non-solicitation traffic")
+	}
+}
@@ -0,0 +1,131 @@
+package vswitch
+
+import (
+	"sync"
+	"time"
+)
+
+// BUMLimits caps the broadcast, unknown unicast and multicast frames
+// flooded out each trunk link, in frames per second, so that a storm on
+// one host cannot saturate the links feeding the other members of a
+// stretched VLAN. Trunk links are cluster trunks and connections in a
+// split horizon group. Each limit allows bursts of one second of frames;
+// zero leaves the class unlimited.
+type BUMLimits struct {
+	Broadcast      int
+	UnknownUnicast int
+	Multicast      int
+}
+
+// bumClass is a class of flooded traffic
+type bumClass int
+
+const (
+	bumBroadcast bumClass = iota
+	bumUnknownUnicast
+	bumMulticast
+	bumClassCount
+)
+
+// bumClassNames names the classes in the statistics
+var bumClassNames = [bumClassCount]string{
+	bumBroadcast:      "broadcast",
+	bumUnknownUnicast: "unknown_unicast",
+	bumMulticast:      "multicast",
+}
+
+// bumClassOf returns the class of a flooded frame
+func bumClassOf(frame *EthernetFrame) bumClass {
+	switch {
+	case frame.IsBroadcast():
+		return bumBroadcast
+	case frame.IsMulticast():
+		return bumMulticast
+	default:
+		return bumUnknownUnicast
+	}
+}
+
+// newBUMLimiters creates the limiters of a connection, nil for the
+// unlimited classes
+func newBUMLimiters(limits BUMLimits) [bumClassCount]*rateLimiter {
+	var limiters [bumClassCount]*rateLimiter
+	for class, rate := range [bumClassCount]int{limits.Broadcast, limits.UnknownUnicast, limits.Multicast} {
+		if rate > 0 {
+			limiters[class] = newRateLimiter(rate)
+		}
+	}
+	return limiters
+}
+
+// rateLimiter is a token bucket filled at rate tokens per second and
+// holding up to burst of them
+type rateLimiter struct {
+	mutex  sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// newRateLimiter creates a full limiter allowing rate frames per second,
+// in bursts of up to one second of frames
+func newRateLimiter(rate int) *rateLimiter {
+	return newTokenBucket(float64(rate), float64(rate))
+}
+
+// newTokenBucket creates a full token bucket
+func newTokenBucket(rate, burst float64) *rateLimiter {
+	return &rateLimiter{rate: rate, burst: burst, tokens: burst, last: time.Now()}
+}
+
+// allow takes a token if one is left at the given time
+func (l *rateLimiter) allow(now time.Time) bool {
+	return l.take(1, now)
+}
+
+// take takes n tokens if that many are left at the given time
+func (l *rateLimiter) take(n float64, now time.Time) bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if elapsed := now.Sub(l.last); elapsed > 0 {
+		l.tokens = min(l.burst, l.tokens+elapsed.Seconds()*l.rate)
+		l.last = now
+	}
+	if l.tokens < n {
+		return false
+	}
+	l.tokens -= n
+	return true
+}
+
+// allowFlood reports whether a flooded frame of the class may go out the
+// connection, counting it if the connection is a trunk link over its limit
+func (c *Connection) allowFlood(class bumClass, now time.Time) bool {
+	limiter := c.bumLimiters[class]
+	if limiter == nil || !c.trunkLink() || limiter.allow(now) {
+		return true
+	}
+	c.bumDrops.Add(1)
+	return false
+}
+
+// trunkLink reports whether the connection links to other switches: a
+// cluster trunk or a connection in a split horizon group
+func (c *Connection) trunkLink() bool {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	return c.trunk || c.horizon != ""
+}
+
+// bumDropCounts returns the number of flooded frames of each class the
+// limits kept off the VLAN's trunk links
+func (vs *VirtualSwitch) bumDropCounts() map[string]uint64 {
+	counts := make(map[string]uint64, bumClassCount)
+	for class, name := range bumClassNames {
+		counts[name] = vs.bumDrops[class].Load()
+	}
+	return counts
+}
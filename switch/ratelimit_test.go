@@ -0,0 +1,71 @@
+package vswitch
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	limiter := newRateLimiter(10)
+	now := limiter.last
+
+	// A full bucket allows a burst of one second of frames
+	for i := 0; i < 10; i++ {
+		if !limiter.allow(now) {
+			t.Fatalf("Expected frame %d of the burst to pass", i)
+		}
+	}
+	if limiter.allow(now) {
+		t.Error("Expected the limiter to refuse frames beyond the burst")
+	}
+
+	// Tokens come back at the rate
+	if !limiter.allow(now.Add(100 * time.Millisecond)) {
+		t.Error("Expected a token after a tenth of a second")
+	}
+	if limiter.allow(now.Add(100 * time.Millisecond)) {
+		t.Error("Expected a single token after a tenth of a second")
+	}
+}
+
+func TestTrunkBUMLimits(t *testing.T) {
+	trunkPeers, _ := ParseCIDRList("10.0.0.1")
+	sw := NewVirtualSwitchWithConfig([]int{8080}, Config{
+		HorizonGroups:  []HorizonGroup{{Name: "mesh", Peers: trunkPeers}},
+		TrunkBUMLimits: BUMLimits{Broadcast: 2},
+	})
+
+	trunk := &mockConnSwitch{addr: &mockAddrSwitch{network: "tcp", address: "10.0.0.1:7000"}}
+	guest := &mockConnSwitch{addr: &mockAddrSwitch{network: "tcp", address: "10.0.0.2:7000"}}
+	for id, conn := range map[string]*mockConnSwitch{"trunk": trunk, "guest": guest} {
+		connection := NewConnection(id, conn)
+		sw.applyPortDefaults(connection)
+		sw.connections.Store(id, connection)
+	}
+	source := NewConnection("source", &mockConnSwitch{addr: &mockAddrSwitch{network: "tcp", address: "10.0.0.3:7000"}})
+	sw.applyPortDefaults(source)
+
+	// A storm reaches the local guest in full but the trunk only up to its limit
+	src := net.HardwareAddr{0x52, 0x54, 0x00, 0x00, 0x00, 0x01}
+	for i := 0; i < 5; i++ {
+		_ = sw.processFrame(rawFrame(buildEthernet(BroadcastMAC, src, EtherTypeARP, make([]byte, 46))), source)
+	}
+	frameSize := 4 + 14 + 46
+	if sent := len(guest.writeData) / frameSize; sent != 5 {
+		t.Errorf("Expected 5 broadcasts to reach the guest, got %d", sent)
+	}
+	if sent := len(trunk.writeData) / frameSize; sent != 2 {
+		t.Errorf("Expected 2 broadcasts to reach the trunk, got %d", sent)
+	}
+	if drops := sw.GetStats()["bum_drops"].(map[string]uint64); drops["broadcast"] != 3 || drops["multicast"] != 0 {
+		t.Errorf("Expected 3 broadcast drops, got %v", drops)
+	}
+
+	// Multicast is not limited
+	multicast := net.HardwareAddr{0x01, 0x00, 0x5e, 0x00, 0x00, 0x01}
+	_ = sw.processFrame(rawFrame(buildEthernet(multicast, src, EtherTypeIPv4, make([]byte, 46))), source)
+	if sent := len(trunk.writeData) / frameSize; sent != 3 {
+		t.Errorf("Expected the multicast frame to reach the trunk, got %d frames", sent)
+	}
+}
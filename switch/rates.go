@@ -0,0 +1,94 @@
+package vswitch
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	// rateInterval is how often the throughput rates are sampled
+	rateInterval = time.Second
+
+	// rateWindow is the time constant of the rolling averages: a change
+	// in load shows for about two thirds after this long
+	rateWindow = 5 * time.Second
+)
+
+// Rates is the rolling average throughput of a connection or VLAN
+type Rates struct {
+	BitsPerSecond   float64 `json:"bps"`
+	FramesPerSecond float64 `json:"pps"`
+}
+
+// rateMeter turns monotonic frame and byte counters into exponentially
+// weighted moving averages of bits and frames per second
+type rateMeter struct {
+	mutex  sync.Mutex
+	last   time.Time
+	frames uint64
+	bytes  uint64
+	rates  Rates
+}
+
+// sample folds the counters at now into the averages; the first sample
+// only sets the baseline
+func (m *rateMeter) sample(now time.Time, frames, bytes uint64) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	elapsed := now.Sub(m.last).Seconds()
+	// Counters that went back, as when stats are reset, start over
+	if !m.last.IsZero() && elapsed > 0 && frames >= m.frames && bytes >= m.bytes {
+		weight := 1 - math.Exp(-elapsed/rateWindow.Seconds())
+		pps := float64(frames-m.frames) / elapsed
+		bps := float64(bytes-m.bytes) * 8 / elapsed
+		m.rates.FramesPerSecond += weight * (pps - m.rates.FramesPerSecond)
+		m.rates.BitsPerSecond += weight * (bps - m.rates.BitsPerSecond)
+	}
+	m.last, m.frames, m.bytes = now, frames, bytes
+}
+
+// get returns the current averages, rounded as nobody needs fractions of a
+// bit per second
+func (m *rateMeter) get() Rates {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return Rates{
+		BitsPerSecond:   math.Round(m.rates.BitsPerSecond),
+		FramesPerSecond: math.Round(m.rates.FramesPerSecond*10) / 10,
+	}
+}
+
+// sampleRates samples the throughput of the VLAN and its connections
+// periodically until the switch stops
+func (vs *VirtualSwitch) sampleRates() {
+	defer vs.wg.Done()
+
+	ticker := time.NewTicker(rateInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-vs.shutdown:
+			return
+		case now := <-ticker.C:
+			vs.sampleRatesAt(now)
+		}
+	}
+}
+
+// sampleRatesAt samples the counters of the VLAN and its connections
+func (vs *VirtualSwitch) sampleRatesAt(now time.Time) {
+	var bytes uint64
+	for class := range vs.etherTypeBytes {
+		bytes += vs.etherTypeBytes[class].Load()
+	}
+	vs.rxRates.sample(now, vs.totalFrames.Load(), bytes)
+
+	vs.connections.Range(func(_, value interface{}) bool {
+		value.(*Connection).sampleRates(now)
+		return true
+	})
+}
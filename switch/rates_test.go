@@ -0,0 +1,63 @@
+package vswitch
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestRateMeter(t *testing.T) {
+	var meter rateMeter
+	now := time.Now()
+
+	// A steady 100 frames of 1000 bytes a second
+	var frames, bytes uint64
+	for i := 0; i <= 30; i++ {
+		meter.sample(now.Add(time.Duration(i)*time.Second), frames, bytes)
+		frames += 100
+		bytes += 100 * 1000
+	}
+	rates := meter.get()
+	if math.Abs(rates.FramesPerSecond-100) > 1 || math.Abs(rates.BitsPerSecond-800000) > 8000 {
+		t.Errorf("Expected about 100 pps and 800 kbit/s, got %+v", rates)
+	}
+
+	// The average decays once the traffic stops
+	for i := 31; i <= 60; i++ {
+		meter.sample(now.Add(time.Duration(i)*time.Second), frames, bytes)
+	}
+	if rates := meter.get(); rates.FramesPerSecond > 1 {
+		t.Errorf("Expected the rate to decay, got %+v", rates)
+	}
+
+	// Counters starting over do not make the rates negative
+	meter.sample(now.Add(61*time.Second), 0, 0)
+	meter.sample(now.Add(62*time.Second), 0, 0)
+	if rates := meter.get(); rates.FramesPerSecond < 0 || rates.BitsPerSecond < 0 {
+		t.Errorf("Expected no negative rates, got %+v", rates)
+	}
+}
+
+func TestConnectionRates(t *testing.T) {
+	sw := NewVirtualSwitch([]int{8080})
+	conn := NewConnection("guest", &frameSink{})
+	sw.connections.Store("guest", conn)
+
+	now := time.Now()
+	sw.sampleRatesAt(now)
+	conn.mutex.Lock()
+	conn.FramesReceived, conn.BytesReceived = 50, 50*64
+	conn.mutex.Unlock()
+	sw.totalFrames.Store(50)
+	sw.etherTypeBytes[0].Store(50 * 64)
+	sw.sampleRatesAt(now.Add(time.Second))
+
+	info := conn.Info()
+	if info.RxRates.FramesPerSecond <= 0 || info.RxRates.BitsPerSecond <= 0 || info.TxRates.FramesPerSecond != 0 {
+		t.Errorf("Expected only a receive rate, got %+v and %+v", info.RxRates, info.TxRates)
+	}
+	stats := sw.GetStats()
+	if stats["rx_frames_per_second"] != info.RxRates.FramesPerSecond || stats["rx_bits_per_second"] != info.RxRates.BitsPerSecond {
+		t.Errorf("Expected the VLAN rates to match its only connection's, got %v and %v", stats["rx_frames_per_second"], stats["rx_bits_per_second"])
+	}
+}
@@ -0,0 +1,254 @@
+package vswitch
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Reconcile actions
+const (
+	ActionCreateVLAN      = "create-vlan"
+	ActionDeleteVLAN      = "delete-vlan"
+	ActionUpdateSettings  = "update-settings"
+	ActionDisableVLAN     = "disable-vlan"
+	ActionEnableVLAN      = "enable-vlan"
+	ActionSetPeerLabel    = "set-peer-label"
+	ActionRemovePeerLabel = "remove-peer-label"
+	ActionAddStaticMAC    = "add-static-mac"
+	ActionRemoveStaticMAC = "remove-static-mac"
+)
+
+// ReconcileAction is a change Reconcile made, or would make, to bring the
+// switch to the desired state
+type ReconcileAction struct {
+	Action string `json:"action"`
+	VLAN   int    `json:"vlan"`
+	Detail string `json:"detail,omitempty"`
+
+	run func() error
+}
+
+// Reconcile brings the switch to a desired state: it starts the VLANs of
+// desired that do not exist, removes those that are not in it, restarts
+// those whose settings differ and changes the runtime state of the others
+// to match. It returns the actions taken in order, or with dryRun those it
+// would take without changing anything. The desired state is validated
+// before anything changes; on failure the actions taken until then are
+// returned with the error.
+func (sm *SwitchManager) Reconcile(desired Snapshot, dryRun bool) ([]ReconcileAction, error) {
+	sm.reconcileMutex.Lock()
+	defer sm.reconcileMutex.Unlock()
+
+	configs, err := sm.snapshotConfigs(desired)
+	if err != nil {
+		return nil, err
+	}
+
+	existing := sm.Snapshot().VLANs
+	current := make(map[int]VLANSnapshot, len(existing))
+	for _, vlan := range existing {
+		current[vlan.Port] = vlan
+	}
+
+	// Removing VLANs first frees their resources for the others
+	var actions []ReconcileAction
+	for _, vlan := range existing {
+		if _, wanted := configs[vlan.Port]; !wanted {
+			port := vlan.Port
+			actions = append(actions, ReconcileAction{Action: ActionDeleteVLAN, VLAN: port, run: func() error {
+				return sm.RemoveVLAN(port)
+			}})
+		}
+	}
+
+	sorted := slices.Clone(desired.VLANs)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Port < sorted[j].Port })
+	for _, vlan := range sorted {
+		actions = append(actions, sm.planVLAN(current, vlan, configs[vlan.Port])...)
+	}
+
+	if dryRun {
+		return actions, nil
+	}
+	for i, action := range actions {
+		if err := action.run(); err != nil {
+			return actions[:i], fmt.Errorf("%s on VLAN %d: %w", action.Action, action.VLAN, err)
+		}
+	}
+	if len(actions) > 0 {
+		sm.logger().Info("Reconciled desired state", "actions", len(actions))
+	}
+	return actions, nil
+}
+
+// planVLAN returns the actions bringing a VLAN to its desired state, with
+// the configuration it should run with
+func (sm *SwitchManager) planVLAN(current map[int]VLANSnapshot, desired VLANSnapshot, config Config) []ReconcileAction {
+	port := desired.Port
+	existing, exists := current[port]
+	if !exists {
+		return []ReconcileAction{{Action: ActionCreateVLAN, VLAN: port, run: func() error {
+			if err := sm.StartVLANWithConfig(port, config); err != nil {
+				return err
+			}
+			return sm.withVLAN(port, func(vs *VirtualSwitch) { vs.importState(desired) })
+		}}}
+	}
+
+	var actions []ReconcileAction
+	if changed := changedSettings(existing.Settings, settingsOf(config)); len(changed) > 0 {
+		actions = append(actions, ReconcileAction{Action: ActionUpdateSettings, VLAN: port, Detail: strings.Join(changed, ","), run: func() error {
+			return sm.restartVLAN(port, config)
+		}})
+	}
+
+	if existing.Disabled != desired.Disabled {
+		action := ActionEnableVLAN
+		if desired.Disabled {
+			action = ActionDisableVLAN
+		}
+		actions = append(actions, ReconcileAction{Action: action, VLAN: port, run: func() error {
+			return sm.SetVLANDisabled(port, desired.Disabled)
+		}})
+	}
+
+	for _, address := range sortedKeys(existing.PeerLabels) {
+		if _, kept := desired.PeerLabels[address]; !kept {
+			ip := net.ParseIP(address)
+			actions = append(actions, ReconcileAction{Action: ActionRemovePeerLabel, VLAN: port, Detail: address, run: func() error {
+				return sm.RemovePeerLabel(port, ip)
+			}})
+		}
+	}
+	for _, address := range sortedKeys(desired.PeerLabels) {
+		if label := desired.PeerLabels[address]; existing.PeerLabels[address] != label {
+			ip := net.ParseIP(address)
+			actions = append(actions, ReconcileAction{Action: ActionSetPeerLabel, VLAN: port, Detail: address + "=" + label, run: func() error {
+				return sm.SetPeerLabel(port, ip, label)
+			}})
+		}
+	}
+
+	for _, static := range existing.StaticMACs {
+		if !slices.Contains(desired.StaticMACs, static) {
+			mac := static.MAC
+			actions = append(actions, ReconcileAction{Action: ActionRemoveStaticMAC, VLAN: port, Detail: mac + "@" + static.Peer, run: func() error {
+				return sm.withVLAN(port, func(vs *VirtualSwitch) { vs.removeStaticMAC(mac) })
+			}})
+		}
+	}
+	for _, static := range desired.StaticMACs {
+		if !slices.Contains(existing.StaticMACs, static) {
+			actions = append(actions, ReconcileAction{Action: ActionAddStaticMAC, VLAN: port, Detail: static.MAC + "@" + static.Peer, run: func() error {
+				return sm.withVLAN(port, func(vs *VirtualSwitch) { vs.addStaticMACs([]StaticMAC{static}) })
+			}})
+		}
+	}
+
+	return actions
+}
+
+// changedSettings returns the names of the settings that differ
+func changedSettings(current, desired VLANSettings) []string {
+	if reflect.DeepEqual(current, desired) {
+		return nil
+	}
+
+	var before, after map[string]json.RawMessage
+	currentJSON, _ := json.Marshal(current)
+	desiredJSON, _ := json.Marshal(desired)
+	_ = json.Unmarshal(currentJSON, &before)
+	_ = json.Unmarshal(desiredJSON, &after)
+
+	var changed []string
+	for name, value := range after {
+		if string(before[name]) != string(value) {
+			changed = append(changed, name)
+		}
+	}
+	for name := range before {
+		if _, found := after[name]; !found {
+			changed = append(changed, name)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// sortedKeys returns the keys of a map in order
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// withVLAN runs f on the VLAN at the given port
+func (sm *SwitchManager) withVLAN(port int, f func(vs *VirtualSwitch)) error {
+	vs := sm.vlan(port)
+	if vs == nil {
+		return fmt.Errorf("VLAN does not exist on port %d", port)
+	}
+	f(vs)
+	return nil
+}
+
+// restartVLAN replaces the VLAN at the given port with one running with
+// config, which disconnects its clients. The VLAN keeps its runtime state,
+// and its MAC table and counters if they are saved. If the new VLAN fails
+// to start the old configuration is restored.
+func (sm *SwitchManager) restartVLAN(port int, config Config) error {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	old, exists := sm.switches[port]
+	if !exists {
+		return fmt.Errorf("VLAN does not exist on port %d", port)
+	}
+	state := old.Snapshot()
+	old.Stop()
+
+	ctx := sm.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	vs := NewVirtualSwitchWithConfig([]int{port}, config)
+	vs.events = &sm.events
+	vs.siblings = &sm.siblings
+	err := vs.Start(ctx)
+	if err != nil {
+		vs = NewVirtualSwitchWithConfig([]int{port}, old.config)
+		vs.events = &sm.events
+		vs.siblings = &sm.siblings
+		if restoreErr := vs.Start(ctx); restoreErr != nil {
+			delete(sm.switches, port)
+			sm.siblings.Delete(port)
+			sm.events.publish(Event{Type: EventVLANRemoved, VLAN: port})
+			return fmt.Errorf("failed to restart VLAN on port %d: %v, and to restore it: %v", port, err, restoreErr)
+		}
+	}
+	vs.importState(state)
+	sm.switches[port] = vs
+	sm.siblings.Store(port, vs)
+
+	if err != nil {
+		return fmt.Errorf("failed to restart VLAN on port %d: %v", port, err)
+	}
+	sm.defaultConfig.logger().Info("Restarted VLAN with new settings", "vlan", port)
+	return nil
+}
+
+// removeStaticMAC removes the sticky binding of a MAC, whether it is bound
+// or waiting for its peer
+func (vs *VirtualSwitch) removeStaticMAC(mac string) {
+	vs.macBindings.Delete(mac)
+	vs.macState.removeStatic(mac)
+}
@@ -0,0 +1,120 @@
+package vswitch
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestReconcile(t *testing.T) {
+	sm := NewSwitchManager()
+	sm.SetDefaultConfig(Config{InMemory: true})
+	for _, port := range []int{9997, 9999} {
+		if err := sm.AddVLAN(port); err != nil {
+			t.Fatalf("Failed to add VLAN: %v", err)
+		}
+	}
+	if err := sm.StartAll(context.Background()); err != nil {
+		t.Fatalf("Failed to start: %v", err)
+	}
+	defer sm.StopAll()
+	sm.vlan(9999).SetPeerLabel(net.ParseIP("10.0.0.5"), "old")
+	sm.vlan(9999).SetPeerLabel(net.ParseIP("10.0.0.6"), "gone")
+
+	settings := settingsOf(sm.vlan(9999).config)
+	settings.StickyMAC = true
+	desired := Snapshot{VLANs: []VLANSnapshot{
+		{Port: 9998, Settings: settingsOf(sm.defaultConfig), PeerLabels: map[string]string{"10.0.0.7": "new"}},
+		{
+			Port:       9999,
+			Disabled:   true,
+			Settings:   settings,
+			PeerLabels: map[string]string{"10.0.0.5": "lab/vm1"},
+			StaticMACs: []StaticMAC{{MAC: "52:54:00:00:00:01", Peer: "client:vm-1"}},
+		},
+	}}
+
+	expected := []ReconcileAction{
+		{Action: ActionDeleteVLAN, VLAN: 9997},
+		{Action: ActionCreateVLAN, VLAN: 9998},
+		{Action: ActionUpdateSettings, VLAN: 9999, Detail: "sticky_mac"},
+		{Action: ActionDisableVLAN, VLAN: 9999},
+		{Action: ActionRemovePeerLabel, VLAN: 9999, Detail: "10.0.0.6"},
+		{Action: ActionSetPeerLabel, VLAN: 9999, Detail: "10.0.0.5=lab/vm1"},
+		{Action: ActionAddStaticMAC, VLAN: 9999, Detail: "52:54:00:00:00:01@client:vm-1"},
+	}
+	strip := func(actions []ReconcileAction) []ReconcileAction {
+		for i := range actions {
+			actions[i].run = nil
+		}
+		return actions
+	}
+
+	// A dry run plans the actions without taking them
+	before := sm.Snapshot()
+	actions, err := sm.Reconcile(desired, true)
+	if err != nil {
+		t.Fatalf("Failed to plan: %v", err)
+	}
+	if !reflect.DeepEqual(strip(actions), expected) {
+		t.Errorf("Expected actions\n%+v\ngot\n%+v", expected, actions)
+	}
+	if after := sm.Snapshot(); !reflect.DeepEqual(after, before) {
+		t.Errorf("Expected a dry run to change nothing, got %+v", after)
+	}
+
+	old := sm.vlan(9999)
+	actions, err = sm.Reconcile(desired, false)
+	if err != nil {
+		t.Fatalf("Failed to reconcile: %v", err)
+	}
+	if !reflect.DeepEqual(strip(actions), expected) {
+		t.Errorf("Expected actions\n%+v\ngot\n%+v", expected, actions)
+	}
+	if vs := sm.vlan(9999); vs == old || !vs.config.StickyMAC {
+		t.Errorf("Expected the VLAN to be restarted with its new settings")
+	}
+	if current := sm.Snapshot(); !reflect.DeepEqual(current, desired) {
+		t.Errorf("Expected the switch to reach the desired state\n%+v\ngot\n%+v", desired, current)
+	}
+
+	// Once reconciled nothing is left to do
+	if actions, err = sm.Reconcile(desired, false); err != nil || len(actions) != 0 {
+		t.Errorf("Expected no actions, got %+v, %v", actions, err)
+	}
+}
+
+func TestAPIReconcile(t *testing.T) {
+	sm := NewSwitchManager()
+	sm.SetDefaultConfig(Config{InMemory: true})
+	defer sm.StopAll()
+	handler := NewAPIHandler(sm, "secret")
+
+	desired := `{"vlans":[{"port":9999,"settings":{"sticky_mac":true}}]}`
+	if status := apiRequest(handler, http.MethodPut, "/config?dry_run=true", desired, "secret"); status != http.StatusOK {
+		t.Fatalf("Expected a dry run to succeed, got %d", status)
+	}
+	if sm.vlan(9999) != nil {
+		t.Errorf("Expected a dry run not to create the VLAN")
+	}
+	if status := apiRequest(handler, http.MethodPut, "/config", desired, "secret"); status != http.StatusOK {
+		t.Fatalf("Expected reconciling to succeed, got %d", status)
+	}
+	if vs := sm.vlan(9999); vs == nil || !vs.config.StickyMAC {
+		t.Errorf("Expected the VLAN to be created with its settings")
+	}
+
+	for _, body := range []string{`{"vlans":[{"port":0}]}`, `{"vlans":[{"port":9999,"settings":{"validation":"bogus"}}]}`, `not json`} {
+		if status := apiRequest(handler, http.MethodPut, "/config", body, "secret"); status != http.StatusBadRequest {
+			t.Errorf("Expected %s to be rejected, got %d", body, status)
+		}
+	}
+	if sm.vlan(9999) == nil {
+		t.Errorf("Expected an invalid state to change nothing")
+	}
+	if status := apiRequest(handler, http.MethodPut, "/config", `{"vlans":[]}`, ""); status != http.StatusUnauthorized {
+		t.Errorf("Expected reconciling without the token to be rejected, got %d", status)
+	}
+}
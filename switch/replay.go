@@ -0,0 +1,202 @@
+package vswitch
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// pcapMagicMicros starts pcap files with microsecond timestamps
+	pcapMagicMicros = 0xa1b2c3d4
+
+	// pcapMagicNanos starts pcap files with nanosecond timestamps
+	pcapMagicNanos = 0xa1b23c4d
+
+	// pcapMaxRecord bounds the size of a record read from a pcap file
+	pcapMaxRecord = 256 * 1024
+)
+
+// replayIDs numbers the synthetic connections replays are attributed to
+var replayIDs atomic.Uint64
+
+// ReplayResult summarizes a replay of a pcap file into a VLAN
+type ReplayResult struct {
+	Connection string  `json:"connection"`
+	Frames     int     `json:"frames"`
+	Bytes      uint64  `json:"bytes"`
+	Skipped    int     `json:"skipped"`
+	Seconds    float64 `json:"seconds"`
+}
+
+// pcapReader reads the records of a pcap file of Ethernet frames
+type pcapReader struct {
+	r     io.Reader
+	order binary.ByteOrder
+	nanos bool
+}
+
+// newPcapReader reads the header of a pcap file
+func newPcapReader(r io.Reader) (*pcapReader, error) {
+	var header [24]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, fmt.Errorf("failed to read pcap header: %v", err)
+	}
+
+	p := &pcapReader{r: r}
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		switch order.Uint32(header[0:4]) {
+		case pcapMagicMicros:
+			p.order = order
+		case pcapMagicNanos:
+			p.order, p.nanos = order, true
+		}
+	}
+	if p.order == nil {
+		return nil, fmt.Errorf("not a pcap file")
+	}
+	if linkType := p.order.Uint32(header[20:24]); linkType != pcapLinkTypeEthernet {
+		return nil, fmt.Errorf("unsupported pcap link type %d", linkType)
+	}
+	return p, nil
+}
+
+// next returns the timestamp and data of the next record, and whether the
+// frame was truncated by the capture's snapshot length. It returns io.EOF
+// after the last record.
+func (p *pcapReader) next() (time.Time, []byte, bool, error) {
+	var header [16]byte
+	if _, err := io.ReadFull(p.r, header[:]); err != nil {
+		if errors.Is(err, io.EOF) {
+			return time.Time{}, nil, false, io.EOF
+		}
+		return time.Time{}, nil, false, fmt.Errorf("failed to read pcap record: %v", err)
+	}
+
+	seconds := int64(p.order.Uint32(header[0:4]))
+	fraction := int64(p.order.Uint32(header[4:8]))
+	if !p.nanos {
+		fraction *= 1000
+	}
+	included := p.order.Uint32(header[8:12])
+	original := p.order.Uint32(header[12:16])
+	if included > pcapMaxRecord {
+		return time.Time{}, nil, false, fmt.Errorf("invalid pcap record length %d", included)
+	}
+
+	data := make([]byte, included)
+	if _, err := io.ReadFull(p.r, data); err != nil {
+		return time.Time{}, nil, false, fmt.Errorf("failed to read pcap record: %v", err)
+	}
+	return time.Unix(seconds, fraction), data, included < original, nil
+}
+
+// Replay injects the frames of a pcap file into the VLAN as if received
+// from a synthetic connection, so they pass the same checks, hooks and
+// limits as a VM's. With a speed of 1 frames keep their original spacing,
+// higher speeds replay them proportionally faster and 0 as fast as
+// possible. Truncated frames and frames beyond the VLAN's MTU are skipped.
+// The connection goes away when the replay ends.
+func (vs *VirtualSwitch) Replay(ctx context.Context, r io.Reader, speed float64) (result ReplayResult, err error) {
+	if speed < 0 {
+		return result, fmt.Errorf("replay speed must not be negative")
+	}
+	reader, err := newPcapReader(r)
+	if err != nil {
+		return result, err
+	}
+
+	// Frames the VLAN sends to the connection are discarded
+	switchSide, peer := net.Pipe()
+	go func() {
+		_, _ = io.Copy(io.Discard, peer)
+		_ = peer.Close()
+	}()
+	conn := NewConnection(fmt.Sprintf("replay-%d", replayIDs.Add(1)), switchSide)
+	vs.applyPortDefaults(conn)
+	vs.attachConnection(conn)
+	defer vs.cleanupConnection(conn)
+
+	result.Connection = conn.ID
+	start := time.Now()
+	defer func() { result.Seconds = time.Since(start).Seconds() }()
+
+	var first time.Time
+	maxFrame := vs.config.maxFrameSize()
+	for {
+		timestamp, data, truncated, err := reader.next()
+		if errors.Is(err, io.EOF) {
+			return result, nil
+		}
+		if err != nil {
+			return result, err
+		}
+		if truncated || len(data) < 14 || len(data) > maxFrame {
+			result.Skipped++
+			continue
+		}
+
+		var delay time.Duration
+		if speed > 0 {
+			if first.IsZero() {
+				first = timestamp
+			}
+			delay = time.Until(start.Add(time.Duration(float64(timestamp.Sub(first)) / speed)))
+		}
+		if err := vs.replayWait(ctx, delay); err != nil {
+			return result, err
+		}
+
+		buffer := getFrameBuffer(len(data))
+		copy(buffer, data)
+		frame, _ := newPooledFrame(buffer)
+		frame.received = time.Now()
+
+		conn.mutex.Lock()
+		conn.FramesReceived++
+		conn.BytesReceived += uint64(len(data))
+		conn.LastSeen = frame.received
+		conn.mutex.Unlock()
+
+		vs.handleFrame(frame, conn)
+		frame.Release()
+		result.Frames++
+		result.Bytes += uint64(len(data))
+	}
+}
+
+// replayWait waits until the next frame of a replay is due, returning an
+// error if the replay is cancelled or the switch stops first
+func (vs *VirtualSwitch) replayWait(ctx context.Context, delay time.Duration) error {
+	var due <-chan time.Time
+	if delay > 0 {
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		due = timer.C
+	}
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-vs.shutdown:
+		return fmt.Errorf("VLAN stopped")
+	default:
+	}
+	if due == nil {
+		return nil
+	}
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-vs.shutdown:
+		return fmt.Errorf("VLAN stopped")
+	case <-due:
+		return nil
+	}
+}
@@ -0,0 +1,135 @@
+package vswitch
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// pcapRecord is a frame to write to a pcap file, offset from the capture's
+// start, and its length on the wire if it was truncated
+type pcapRecord struct {
+	offset   time.Duration
+	data     []byte
+	original int
+}
+
+// buildPcap writes a pcap file with microsecond timestamps
+func buildPcap(records ...pcapRecord) []byte {
+	data := binary.LittleEndian.AppendUint32(nil, pcapMagicMicros)
+	data = binary.LittleEndian.AppendUint16(data, 2)
+	data = binary.LittleEndian.AppendUint16(data, 4)
+	data = binary.LittleEndian.AppendUint32(data, 0)
+	data = binary.LittleEndian.AppendUint32(data, 0)
+	data = binary.LittleEndian.AppendUint32(data, pcapSnapLen)
+	data = binary.LittleEndian.AppendUint32(data, pcapLinkTypeEthernet)
+
+	start := time.Unix(1700000000, 0)
+	for _, record := range records {
+		timestamp := start.Add(record.offset)
+		original := max(record.original, len(record.data))
+		data = binary.LittleEndian.AppendUint32(data, uint32(timestamp.Unix()))
+		data = binary.LittleEndian.AppendUint32(data, uint32(timestamp.Nanosecond()/1000))
+		data = binary.LittleEndian.AppendUint32(data, uint32(len(record.data)))
+		data = binary.LittleEndian.AppendUint32(data, uint32(original))
+		data = append(data, record.data...)
+	}
+	return data
+}
+
+func TestReplay(t *testing.T) {
+	sw := NewVirtualSwitch([]int{8080})
+	sink := &frameSink{}
+	sw.connections.Store("guest", NewConnection("guest", sink))
+
+	src := net.HardwareAddr{0x52, 0x54, 0x00, 0x00, 0x00, 0x01}
+	first := buildEthernet(BroadcastMAC, src, EtherTypeARP, make([]byte, 46))
+	second := buildEthernet(BroadcastMAC, src, EtherTypeIPv4, make([]byte, 46))
+	pcap := buildPcap(
+		pcapRecord{data: first},
+		pcapRecord{offset: 200 * time.Millisecond, data: buildEthernet(BroadcastMAC, src, EtherTypeIPv4, make([]byte, 46))[:30], original: 60},
+		pcapRecord{offset: 200 * time.Millisecond, data: buildEthernet(BroadcastMAC, src, EtherTypeIPv4, make([]byte, 2000))},
+		pcapRecord{offset: 200 * time.Millisecond, data: second},
+	)
+
+	// At twice the original speed the frames are 100ms apart
+	result, err := sw.Replay(context.Background(), bytes.NewReader(pcap), 2)
+	if err != nil {
+		t.Fatalf("Replay failed: %v", err)
+	}
+	if result.Frames != 2 || result.Bytes != 120 || result.Skipped != 2 || !strings.HasPrefix(result.Connection, "replay-") {
+		t.Errorf("Unexpected result %+v", result)
+	}
+	if result.Seconds < 0.1 || result.Seconds > 1 {
+		t.Errorf("Expected the replay to take about 100ms, took %gs", result.Seconds)
+	}
+
+	frames := writtenFrames(t, sink.data)
+	if len(frames) != 2 || !bytes.Equal(frames[0], first) || !bytes.Equal(frames[1], second) {
+		t.Errorf("Expected the replayed frames to be flooded, got %x", frames)
+	}
+
+	// The synthetic connection and what it learned go away afterwards
+	if _, found := sw.connections.Load(result.Connection); found {
+		t.Errorf("Expected the replay connection to be removed")
+	}
+	if entries := sw.GetMACTable(); len(entries) != 0 {
+		t.Errorf("Expected the replayed MAC addresses to be flushed, got %+v", entries)
+	}
+	if total := sw.GetStats()["total_frames"].(uint64); total != 2 {
+		t.Errorf("Expected 2 frames counted, got %d", total)
+	}
+}
+
+func TestReplayInvalid(t *testing.T) {
+	sw := NewVirtualSwitch([]int{8080})
+
+	if _, err := sw.Replay(context.Background(), strings.NewReader("not a capture file at all"), 0); err == nil {
+		t.Errorf("Expected an error replaying a file that is not pcap")
+	}
+
+	pcap := buildPcap()
+	binary.LittleEndian.PutUint32(pcap[20:24], 101)
+	if _, err := sw.Replay(context.Background(), bytes.NewReader(pcap), 0); err == nil {
+		t.Errorf("Expected an error replaying raw IP packets")
+	}
+
+	// A cancelled replay stops waiting for the next frame
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	src := net.HardwareAddr{0x52, 0x54, 0x00, 0x00, 0x00, 0x01}
+	frame := buildEthernet(BroadcastMAC, src, EtherTypeARP, make([]byte, 46))
+	result, err := sw.Replay(ctx, bytes.NewReader(buildPcap(pcapRecord{data: frame}, pcapRecord{offset: time.Hour, data: frame})), 1)
+	if err == nil || result.Frames != 1 {
+		t.Errorf("Expected the replay to be cancelled after 1 frame, got %+v, %v", result, err)
+	}
+}
+
+func TestReplayAPI(t *testing.T) {
+	sm := NewSwitchManager()
+	defer sm.StopAll()
+	if err := sm.AddVLANWithConfig(8080, DefaultConfig()); err != nil {
+		t.Fatalf("Failed to add VLAN: %v", err)
+	}
+
+	handler := NewAPIHandler(sm, "secret")
+	src := net.HardwareAddr{0x52, 0x54, 0x00, 0x00, 0x00, 0x01}
+	pcap := string(buildPcap(pcapRecord{data: buildEthernet(BroadcastMAC, src, EtherTypeARP, make([]byte, 46))}))
+	if code := apiRequest(handler, http.MethodPost, "/vlans/8080/replay?speed=0", pcap, "secret"); code != http.StatusOK {
+		t.Errorf("Expected pcap to be replayed, got %d", code)
+	}
+	if code := apiRequest(handler, http.MethodPost, "/vlans/8080/replay", "garbage", "secret"); code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an invalid file, got %d", code)
+	}
+	if code := apiRequest(handler, http.MethodPost, "/vlans/8081/replay", pcap, "secret"); code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown VLAN, got %d", code)
+	}
+	if code := apiRequest(handler, http.MethodPost, "/vlans/8080/replay?speed=fast", pcap, "secret"); code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an invalid speed, got %d", code)
+	}
+}
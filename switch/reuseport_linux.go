@@ -0,0 +1,36 @@
+//go:build linux && !mips && !mipsle && !mips64 && !mips64le
+
+package vswitch
+
+import (
+	"context"
+	"net"
+	"syscall"
+)
+
+const (
+	// reusePortSupported tells whether a port can have several listeners
+	reusePortSupported = true
+
+	// soReusePort is SO_REUSEPORT, which the syscall package lacks; MIPS
+	// is the only architecture Go supports where it differs
+	soReusePort = 0xf
+)
+
+// listenReusePort opens a listener with SO_REUSEPORT set, so that other
+// listeners with it set can bind the same address and the kernel spreads
+// incoming connections across all of them
+func listenReusePort(network, addr string) (net.Listener, error) {
+	config := net.ListenConfig{
+		Control: func(_, _ string, raw syscall.RawConn) error {
+			var err error
+			if controlErr := raw.Control(func(fd uintptr) {
+				err = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, soReusePort, 1)
+			}); controlErr != nil {
+				return controlErr
+			}
+			return err
+		},
+	}
+	return config.Listen(context.Background(), network, addr)
+}
@@ -0,0 +1,47 @@
+//go:build linux && !mips && !mipsle && !mips64 && !mips64le
+
+package vswitch
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"testing"
+)
+
+func TestReusePortListeners(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to find a free port: %v", err)
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+	_ = listener.Close()
+
+	sw := NewVirtualSwitchWithConfig([]int{port}, Config{Socket: SocketOptions{Listeners: 4}})
+	if err := sw.Start(context.Background()); err != nil {
+		t.Fatalf("Failed to start switch: %v", err)
+	}
+	defer sw.Stop()
+
+	if health := sw.Health(); !health.Ready || health.Listening != 1 || len(sw.listenerBeats) != 4 {
+		t.Errorf("Expected one port listening with 4 accept loops, got %+v", health)
+	}
+
+	// The kernel spreads the clients across the listeners, which all
+	// attach them to the same VLAN
+	for i := 0; i < 16; i++ {
+		client, err := net.Dial("tcp", "127.0.0.1:"+strconv.Itoa(port))
+		if err != nil {
+			t.Fatalf("Failed to connect: %v", err)
+		}
+		defer func() { _ = client.Close() }()
+	}
+	waitUntil(t, "every client is connected", func() bool { return sw.connectionCount() == 16 })
+
+	// Another process cannot join the listeners, as the port is not shared
+	// with sockets lacking SO_REUSEPORT
+	if other, err := net.Listen("tcp", ":"+strconv.Itoa(port)); err == nil {
+		_ = other.Close()
+		t.Errorf("Expected the port to stay in use")
+	}
+}
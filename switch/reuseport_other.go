@@ -0,0 +1,17 @@
+//go:build !linux || mips || mipsle || mips64 || mips64le
+
+package vswitch
+
+import (
+	"errors"
+	"net"
+)
+
+// reusePortSupported tells whether a port can have several listeners
+const reusePortSupported = false
+
+// listenReusePort fails outside Linux, where SO_REUSEPORT does not spread
+// connections across the listeners sharing a port
+func listenReusePort(_, _ string) (net.Listener, error) {
+	return nil, errors.New("several listeners per port are only supported on Linux")
+}
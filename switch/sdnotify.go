@@ -0,0 +1,81 @@
+package vswitch
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Environment variables of the systemd notification protocol
+const (
+	notifySocketEnv = "NOTIFY_SOCKET"
+	watchdogUSecEnv = "WATCHDOG_USEC"
+	watchdogPIDEnv  = "WATCHDOG_PID"
+)
+
+// Notify sends a state change such as "READY=1" or "WATCHDOG=1" to the
+// service manager named by NOTIFY_SOCKET, which is systemd or the
+// supervisor. It does nothing when the variable is not set.
+func Notify(state string) error {
+	return notify(os.Getenv(notifySocketEnv), state)
+}
+
+// notify sends a state to the notification socket at path
+func notify(path, state string) error {
+	if path == "" {
+		return nil
+	}
+	// Names starting with @ are in the abstract namespace
+	if path[0] == '@' {
+		path = "\x00" + path[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer func() { _ = conn.Close() }()
+
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// WatchdogTimeout returns how long the service manager waits for a
+// "WATCHDOG=1" notification before it restarts this process, or zero when
+// it does not watch the process
+func WatchdogTimeout() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv(watchdogUSecEnv), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv(watchdogPIDEnv); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
+
+// RunWatchdog notifies the service manager twice per watchdog timeout for as
+// long as the accept loops and forwarding workers of all VLANs keep beating
+// their heartbeats. A stuck loop stops the notifications, so the service
+// manager restarts the switch.
+func (sm *SwitchManager) RunWatchdog(timeout time.Duration) {
+	ticker := time.NewTicker(timeout / 2)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		live := true
+		for _, health := range sm.Health() {
+			if !health.Live {
+				sm.logger().Error("Withholding watchdog notification", "vlan", health.Port, "problems", health.Problems)
+				live = false
+			}
+		}
+		if !live {
+			continue
+		}
+		if err := Notify("WATCHDOG=1"); err != nil {
+			sm.logger().Warn("Failed to notify watchdog", "error", err)
+		}
+	}
+}
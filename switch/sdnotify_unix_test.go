@@ -0,0 +1,58 @@
+//go:build !windows
+
+package vswitch
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestNotify(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	t.Setenv(notifySocketEnv, path)
+	if err := Notify("READY=1"); err != nil {
+		t.Fatalf("Failed to notify: %v", err)
+	}
+
+	buf := make([]byte, 64)
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, err := conn.Read(buf)
+	if err != nil || string(buf[:n]) != "READY=1" {
+		t.Errorf("Expected READY=1, got %q (%v)", buf[:n], err)
+	}
+}
+
+func TestNotifyWithoutSocket(t *testing.T) {
+	t.Setenv(notifySocketEnv, "")
+	if err := Notify("READY=1"); err != nil {
+		t.Errorf("Expected no error without a service manager, got %v", err)
+	}
+}
+
+func TestWatchdogTimeout(t *testing.T) {
+	t.Setenv(watchdogPIDEnv, "")
+	t.Setenv(watchdogUSecEnv, "")
+	if timeout := WatchdogTimeout(); timeout != 0 {
+		t.Errorf("Expected no watchdog, got %v", timeout)
+	}
+
+	t.Setenv(watchdogUSecEnv, "2000000")
+	if timeout := WatchdogTimeout(); timeout != 2*time.Second {
+		t.Errorf("Expected a 2s watchdog, got %v", timeout)
+	}
+
+	t.Setenv(watchdogPIDEnv, strconv.Itoa(os.Getpid()+1))
+	if timeout := WatchdogTimeout(); timeout != 0 {
+		t.Errorf("Expected no watchdog for another process, got %v", timeout)
+	}
+}
@@ -0,0 +1,180 @@
+package vswitch
+
+import (
+	"net"
+)
+
+// service is a network function hosted by the switch itself on a VLAN,
+// such as an address autoconfiguration responder
+type service interface {
+	// handleFrame inspects a frame received from a connection. It returns
+	// true if the frame was consumed and must not be forwarded.
+	handleFrame(frame *EthernetFrame, src *Connection) bool
+
+	// run performs periodic work until the shutdown channel is closed
+	run(shutdown <-chan bool)
+}
+
+// listenerService is a service with host sockets. They are opened before
+// the service runs so that they exist once the switch has started.
+type listenerService interface {
+	service
+
+	// listen opens the service's host sockets
+	listen()
+}
+
+// serviceMAC returns the locally administered MAC address the switch uses
+// for frames it originates on the VLAN at the given port
+func serviceMAC(port int) net.HardwareAddr {
+	return net.HardwareAddr{0x02, 0x76, 0x73, 0x00, byte(port >> 8), byte(port)}
+}
+
+// buildServices creates the services enabled by the switch configuration
+func (vs *VirtualSwitch) buildServices() []service {
+	var services []service
+
+	// sFlow samples frames before other services consume them
+	if vs.config.SFlow != nil {
+		agent, err := newSFlowAgent(vs, vs.config.SFlow)
+		if err != nil {
+			vs.logger.Error("sFlow disabled", "error", err)
+		} else {
+			vs.sflow = agent
+			services = append(services, agent)
+			vs.logger.Info("sFlow enabled", "sflow", agent.String())
+		}
+	}
+
+	if len(vs.config.SLAACPrefixes) > 0 {
+		services = append(services, newRAService(vs))
+	}
+
+	if vs.config.LLDP {
+		vs.lldp = newLLDPService(vs)
+		services = append(services, vs.lldp)
+	}
+
+	if len(vs.config.ProxyARP) > 0 {
+		vs.proxyARP = newProxyARP(vs, vs.config.ProxyARP)
+		services = append(services, vs.proxyARP)
+	}
+
+	if vs.config.ARPSuppression {
+		vs.arpSuppressor = newARPSuppressor(vs)
+		services = append(services, vs.arpSuppressor)
+	}
+
+	// IPv4 services share a single host stack
+	var stack *hostStack
+	if vs.config.DNSAddr != nil || vs.config.NAT != nil || vs.config.DHCPServer != nil || vs.config.TFTPRoot != "" {
+		stack = newHostStack(vs)
+	}
+	if vs.config.DNSAddr != nil {
+		stack.addAddress(vs.config.DNSAddr)
+		dns := newDNSForwarder(stack, vs.config.DNSUpstreams, vs.config.DNSRecords)
+		stack.handleUDP(dnsPort, dns.handleQuery)
+		vs.logger.Info("DNS forwarder enabled", "ip", vs.config.DNSAddr.String(), "upstreams", dns.upstreams)
+	}
+	if vs.config.NAT != nil {
+		vs.nat = newNATEngine(vs, stack, vs.config.NAT)
+		services = append(services, vs.nat)
+		vs.logger.Info("NAT enabled", "nat", vs.nat.String())
+	}
+	if vs.config.DHCPServer != nil {
+		cfg := *vs.config.DHCPServer
+		if cfg.Router == nil && vs.config.NAT != nil {
+			cfg.Router = vs.config.NAT.Gateway
+		}
+		if cfg.DNS == nil && vs.config.DNSAddr != nil {
+			cfg.DNS = []net.IP{vs.config.DNSAddr}
+		}
+		services = append(services, newDHCPServer(vs, stack, cfg))
+		vs.logger.Info("DHCP server enabled", "ip", cfg.ServerIP.String())
+	}
+	if vs.config.TFTPRoot != "" {
+		tftp, err := newTFTPServer(stack, vs.config.TFTPRoot)
+		if err != nil {
+			vs.logger.Error("TFTP server disabled", "error", err)
+		} else {
+			stack.handleUDP(tftpPort, tftp.handleRequest)
+			services = append(services, tftp)
+			vs.logger.Info("TFTP server enabled", "root", vs.config.TFTPRoot)
+		}
+	}
+	if stack != nil {
+		services = append(services, stack)
+	}
+
+	if vs.config.VMNetSocket != "" {
+		services = append(services, newVMNetUplink(vs, vs.config.VMNetSocket))
+		vs.logger.Info("Uplink to vmnet enabled", "socket", vs.config.VMNetSocket)
+	}
+
+	if vs.config.CaptureAddr != "" {
+		services = append(services, newCaptureServer(vs, vs.config.CaptureAddr))
+		vs.logger.Info("Capture server enabled", "addr", vs.config.CaptureAddr)
+	}
+
+	// The OpenFlow agent consumes every frame, so it comes last
+	if vs.config.OpenFlow != nil {
+		vs.openflow = newOpenFlowAgent(vs, vs.config.OpenFlow)
+		services = append(services, vs.openflow)
+		vs.logger.Info("OpenFlow enabled", "openflow", vs.openflow.String())
+	}
+
+	return services
+}
+
+// startServices opens the host sockets of all services and launches their
+// periodic work
+func (vs *VirtualSwitch) startServices() {
+	for _, svc := range vs.services {
+		if listener, ok := svc.(listenerService); ok {
+			listener.listen()
+		}
+	}
+
+	for _, svc := range vs.services {
+		vs.wg.Add(1)
+		go func(svc service) {
+			defer vs.wg.Done()
+			svc.run(vs.shutdown)
+		}(svc)
+	}
+}
+
+// injectFrame delivers a frame originated by the switch to the VLAN. Unicast
+// frames go to the learned destination; anything else goes to every connection.
+func (vs *VirtualSwitch) injectFrame(raw []byte) {
+	frame, err := ParseEthernetFrame(raw)
+	if err != nil {
+		vs.logger.Warn("Failed to inject frame", "error", err)
+		return
+	}
+	frame.pooled = false
+	if vs.disabled.Load() {
+		return
+	}
+	vs.capture.tap(raw, frame.timestamp())
+
+	if !frame.IsBroadcast() && !frame.IsMulticast() {
+		if conn := vs.macTable.lookup(frame.DestMAC); conn != nil {
+			if err := conn.SendFrame(frame); err != nil {
+				vs.logger.Debug("Failed to send frame", "connection", conn.ID, "error", err)
+			}
+			return
+		}
+	}
+
+	vs.connections.Range(func(_, value interface{}) bool {
+		conn := value.(*Connection)
+		if conn.IsClosed() {
+			return true
+		}
+		if err := conn.SendFrame(frame); err != nil {
+			vs.logger.Debug("Failed to send frame", "connection", conn.ID, "error", err)
+		}
+		return true
+	})
+}
@@ -0,0 +1,63 @@
+package vswitch
+
+import (
+	"net"
+	"testing"
+)
+
+// writtenFrames splits data written to a mock connection into frames
+func writtenFrames(t *testing.T, data []byte) [][]byte {
+	t.Helper()
+
+	var frames [][]byte
+	for len(data) > 0 {
+		if len(data) < 4 {
+			t.Fatalf("Truncated length prefix in written data")
+		}
+		frameLen := int(data[0])<<24 | int(data[1])<<16 | int(data[2])<<8 | int(data[3])
+		if len(data) < 4+frameLen {
+			t.Fatalf("Truncated frame in written data")
+		}
+		frames = append(frames, data[4:4+frameLen])
+		data = data[4+frameLen:]
+	}
+	return frames
+}
+
+func TestServiceMAC(t *testing.T) {
+	mac := serviceMAC(9999)
+
+	if mac.String() != "02:76:73:00:27:0f" {
+		t.Errorf("Unexpected service MAC %s", mac)
+	}
+
+	if mac[0]&0x02 == 0 || mac[0]&0x01 != 0 {
+		t.Errorf("Expected a locally administered unicast MAC, got %s", mac)
+	}
+}
+
+func TestInjectFrame(t *testing.T) {
+	sw := NewVirtualSwitch([]int{8080})
+
+	mockConn1 := &mockConnSwitch{addr: &mockAddrSwitch{network: "tcp", address: "127.0.0.1:9001"}}
+	mockConn2 := &mockConnSwitch{addr: &mockAddrSwitch{network: "tcp", address: "127.0.0.1:9002"}}
+	conn1 := NewConnection("conn1", mockConn1)
+	conn2 := NewConnection("conn2", mockConn2)
+	sw.connections.Store("conn1", conn1)
+	sw.connections.Store("conn2", conn2)
+
+	guest := net.HardwareAddr{0x02, 0x00, 0x00, 0x00, 0x00, 0x01}
+	sw.learnMAC(guest, conn1)
+
+	// Unicast to a learned MAC reaches only that connection
+	sw.injectFrame(buildEthernet(guest, serviceMAC(8080), EtherTypeIPv4, make([]byte, 46)))
+	if len(mockConn1.writeData) == 0 || len(mockConn2.writeData) != 0 {
+		t.Errorf("Expected unicast injection to reach only the learned connection")
+	}
+
+	// Broadcasts reach every connection
+	sw.injectFrame(buildEthernet(BroadcastMAC, serviceMAC(8080), EtherTypeARP, make([]byte, 46)))
+	if len(writtenFrames(t, mockConn1.writeData)) != 2 || len(writtenFrames(t, mockConn2.writeData)) != 1 {
+		t.Errorf("Expected broadcast injection to reach every connection")
+	}
+}
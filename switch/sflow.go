@@ -0,0 +1,273 @@
+package vswitch
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// sFlow v5 structure formats
+const (
+	sflowVersion         = 5
+	sflowFlowSample      = 1
+	sflowCounterSample   = 2
+	sflowRawHeader       = 1
+	sflowGenericCounters = 1
+	sflowHeaderEthernet  = 1
+	sflowUnknown         = 0xffffffff
+
+	// sflowMaxHeader is how much of a sampled frame is exported
+	sflowMaxHeader = 128
+
+	// sflowMaxDatagram keeps datagrams below a typical path MTU
+	sflowMaxDatagram = 1400
+
+	// sflowFlushInterval bounds how long samples wait for a datagram
+	sflowFlushInterval = time.Second
+)
+
+// sflowInterface tracks the sFlow data source of a connection
+type sflowInterface struct {
+	index      uint32
+	flowSeq    uint32
+	counterSeq uint32
+}
+
+// sflowAgent samples one in N frames received on a VLAN and periodically
+// exports connection counters to an sFlow collector. Each connection is a
+// data source with its own ifIndex; the VLAN port is the sub-agent ID.
+type sflowAgent struct {
+	vs              *VirtualSwitch
+	rate            uint32
+	pollingInterval time.Duration
+	started         time.Time
+
+	skip atomic.Int64
+	pool atomic.Uint32
+
+	mutex      sync.Mutex
+	conn       net.Conn
+	agentIP    net.IP
+	sequence   uint32
+	samples    [][]byte
+	size       int
+	interfaces map[string]*sflowInterface
+	nextIndex  uint32
+}
+
+// newSFlowAgent creates an sFlow agent sending to the configured collector
+func newSFlowAgent(vs *VirtualSwitch, config *SFlowConfig) (*sflowAgent, error) {
+	conn, err := net.Dial("udp", config.Collector)
+	if err != nil {
+		return nil, err
+	}
+
+	a := &sflowAgent{
+		vs:              vs,
+		rate:            max(config.SamplingRate, 1),
+		pollingInterval: config.PollingInterval,
+		started:         time.Now(),
+		conn:            conn,
+		agentIP:         conn.LocalAddr().(*net.UDPAddr).IP,
+		interfaces:      make(map[string]*sflowInterface),
+		nextIndex:       1,
+	}
+	a.skip.Store(a.nextSkip())
+	return a, nil
+}
+
+// String describes the agent for logging
+func (a *sflowAgent) String() string {
+	return fmt.Sprintf("1-in-%d sampling to %s", a.rate, a.conn.RemoteAddr())
+}
+
+// nextSkip picks the number of frames until the next sample, uniformly
+// distributed around the sampling rate
+func (a *sflowAgent) nextSkip() int64 {
+	if a.rate <= 1 {
+		return 1
+	}
+	return 1 + rand.Int64N(2*int64(a.rate)-1)
+}
+
+// handleFrame samples frames without consuming them
+func (a *sflowAgent) handleFrame(frame *EthernetFrame, src *Connection) bool {
+	a.pool.Add(1)
+	if a.skip.Add(-1) > 0 {
+		return false
+	}
+	a.skip.Store(a.nextSkip())
+
+	header := frame.Raw[:min(len(frame.Raw), sflowMaxHeader)]
+	record := binary.BigEndian.AppendUint32(nil, sflowHeaderEthernet)
+	record = binary.BigEndian.AppendUint32(record, uint32(len(frame.Raw)+4)) // including FCS
+	record = binary.BigEndian.AppendUint32(record, 4)                        // FCS stripped
+	record = binary.BigEndian.AppendUint32(record, uint32(len(header)))
+	record = appendPadded(record, header)
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	iface := a.interfaceLocked(src.ID)
+	iface.flowSeq++
+	sample := binary.BigEndian.AppendUint32(nil, iface.flowSeq)
+	sample = binary.BigEndian.AppendUint32(sample, iface.index)
+	sample = binary.BigEndian.AppendUint32(sample, a.rate)
+	sample = binary.BigEndian.AppendUint32(sample, a.pool.Load())
+	sample = binary.BigEndian.AppendUint32(sample, 0)           // drops
+	sample = binary.BigEndian.AppendUint32(sample, iface.index) // input
+	sample = binary.BigEndian.AppendUint32(sample, 0)           // output not known at ingress
+	sample = binary.BigEndian.AppendUint32(sample, 1)
+	sample = appendSFlowRecord(sample, sflowRawHeader, record)
+
+	a.addSampleLocked(sflowFlowSample, sample)
+	return false
+}
+
+// interfaceLocked returns the data source of a connection, assigning an
+// ifIndex on first use; the caller holds the mutex
+func (a *sflowAgent) interfaceLocked(connID string) *sflowInterface {
+	iface, exists := a.interfaces[connID]
+	if !exists {
+		iface = &sflowInterface{index: a.nextIndex}
+		a.nextIndex++
+		a.interfaces[connID] = iface
+	}
+	return iface
+}
+
+// removeConnection forgets the data source of a closed connection
+func (a *sflowAgent) removeConnection(connID string) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	delete(a.interfaces, connID)
+}
+
+// pollCounters exports the generic interface counters of every connection
+func (a *sflowAgent) pollCounters() {
+	var conns []*Connection
+	a.vs.connections.Range(func(_, value interface{}) bool {
+		conns = append(conns, value.(*Connection))
+		return true
+	})
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	for _, conn := range conns {
+		info := conn.Info()
+		iface := a.interfaceLocked(conn.ID)
+		iface.counterSeq++
+
+		counters := binary.BigEndian.AppendUint32(nil, iface.index)
+		counters = binary.BigEndian.AppendUint32(counters, 6) // ethernetCsmacd
+		counters = binary.BigEndian.AppendUint64(counters, 10_000_000_000)
+		counters = binary.BigEndian.AppendUint32(counters, 1) // full duplex
+		counters = binary.BigEndian.AppendUint32(counters, 3) // admin and oper up
+		counters = binary.BigEndian.AppendUint64(counters, info.BytesReceived)
+		counters = appendUint32s(counters, uint32(info.FramesReceived), sflowUnknown, sflowUnknown, sflowUnknown, 0, sflowUnknown)
+		counters = binary.BigEndian.AppendUint64(counters, info.BytesSent)
+		counters = appendUint32s(counters, uint32(info.FramesSent), sflowUnknown, sflowUnknown, sflowUnknown, 0, 0)
+
+		sample := binary.BigEndian.AppendUint32(nil, iface.counterSeq)
+		sample = binary.BigEndian.AppendUint32(sample, iface.index)
+		sample = binary.BigEndian.AppendUint32(sample, 1)
+		sample = appendSFlowRecord(sample, sflowGenericCounters, counters)
+		a.addSampleLocked(sflowCounterSample, sample)
+	}
+}
+
+// addSampleLocked queues a sample, sending the pending datagram first if
+// the sample would not fit; the caller holds the mutex
+func (a *sflowAgent) addSampleLocked(format uint32, sample []byte) {
+	sample = appendSFlowRecord(nil, format, sample)
+	if a.size+len(sample) > sflowMaxDatagram {
+		a.flushLocked()
+	}
+	a.samples = append(a.samples, sample)
+	a.size += len(sample)
+}
+
+// flushLocked sends the queued samples; the caller holds the mutex
+func (a *sflowAgent) flushLocked() {
+	if len(a.samples) == 0 {
+		return
+	}
+
+	a.sequence++
+	datagram := binary.BigEndian.AppendUint32(nil, sflowVersion)
+	if ip4 := a.agentIP.To4(); ip4 != nil {
+		datagram = binary.BigEndian.AppendUint32(datagram, 1)
+		datagram = append(datagram, ip4...)
+	} else {
+		datagram = binary.BigEndian.AppendUint32(datagram, 2)
+		datagram = append(datagram, a.agentIP.To16()...)
+	}
+	datagram = binary.BigEndian.AppendUint32(datagram, uint32(a.vs.port()))
+	datagram = binary.BigEndian.AppendUint32(datagram, a.sequence)
+	datagram = binary.BigEndian.AppendUint32(datagram, uint32(time.Since(a.started).Milliseconds()))
+	datagram = binary.BigEndian.AppendUint32(datagram, uint32(len(a.samples)))
+	for _, sample := range a.samples {
+		datagram = append(datagram, sample...)
+	}
+	a.samples, a.size = nil, 0
+
+	if _, err := a.conn.Write(datagram); err != nil {
+		a.vs.logger.Debug("Failed to send sFlow datagram", "error", err)
+	}
+}
+
+// run flushes samples and polls counters until shutdown
+func (a *sflowAgent) run(shutdown <-chan bool) {
+	flush := time.NewTicker(sflowFlushInterval)
+	defer flush.Stop()
+
+	var poll <-chan time.Time
+	if a.pollingInterval > 0 {
+		ticker := time.NewTicker(a.pollingInterval)
+		defer ticker.Stop()
+		poll = ticker.C
+	}
+
+	for {
+		select {
+		case <-shutdown:
+			a.mutex.Lock()
+			a.flushLocked()
+			a.mutex.Unlock()
+			_ = a.conn.Close()
+			return
+		case <-flush.C:
+			a.mutex.Lock()
+			a.flushLocked()
+			a.mutex.Unlock()
+		case <-poll:
+			a.pollCounters()
+		}
+	}
+}
+
+// appendSFlowRecord appends a structure tagged with its format and length
+func appendSFlowRecord(b []byte, format uint32, data []byte) []byte {
+	b = binary.BigEndian.AppendUint32(b, format)
+	b = binary.BigEndian.AppendUint32(b, uint32(len(data)))
+	return append(b, data...)
+}
+
+// appendPadded appends opaque data padded to a multiple of four bytes
+func appendPadded(b, data []byte) []byte {
+	b = append(b, data...)
+	return append(b, make([]byte, (4-len(data)%4)%4)...)
+}
+
+// appendUint32s appends big-endian 32-bit values
+func appendUint32s(b []byte, values ...uint32) []byte {
+	for _, v := range values {
+		b = binary.BigEndian.AppendUint32(b, v)
+	}
+	return b
+}
@@ -0,0 +1,124 @@
+package vswitch
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+)
+
+// newSFlowTest creates a switch exporting sFlow to a local collector socket
+func newSFlowTest(t *testing.T, rate uint32) (*VirtualSwitch, *net.UDPConn) {
+	t.Helper()
+
+	collector, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { _ = collector.Close() })
+
+	sw := NewVirtualSwitchWithConfig([]int{8080}, Config{
+		SFlow: &SFlowConfig{Collector: collector.LocalAddr().String(), SamplingRate: rate},
+	})
+	if sw.sflow == nil {
+		t.Fatalf("Expected sFlow agent")
+	}
+	return sw, collector
+}
+
+// receiveSFlow reads a datagram, checks its header and returns its samples
+func receiveSFlow(t *testing.T, collector *net.UDPConn) []byte {
+	t.Helper()
+
+	buf := make([]byte, 2048)
+	_ = collector.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, err := collector.Read(buf)
+	if err != nil {
+		t.Fatalf("Failed to receive datagram: %v", err)
+	}
+	datagram := buf[:n]
+
+	if binary.BigEndian.Uint32(datagram[0:4]) != sflowVersion || binary.BigEndian.Uint32(datagram[4:8]) != 1 ||
+		!net.IP(datagram[8:12]).Equal(net.IPv4(127, 0, 0, 1)) || binary.BigEndian.Uint32(datagram[12:16]) != 8080 {
+		t.Fatalf("Unexpected datagram header %x", datagram[:28])
+	}
+	if binary.BigEndian.Uint32(datagram[24:28]) != 1 {
+		t.Fatalf("Expected one sample, got %d", binary.BigEndian.Uint32(datagram[24:28]))
+	}
+	return datagram[28:]
+}
+
+// flushSFlow sends the pending samples
+func flushSFlow(a *sflowAgent) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.flushLocked()
+}
+
+func TestSFlowFlowSample(t *testing.T) {
+	sw, collector := newSFlowTest(t, 1)
+
+	conn := NewConnection("guest", &frameSink{})
+	sw.connections.Store("guest", conn)
+	raw := buildEthernet(BroadcastMAC, net.HardwareAddr{0x52, 0x54, 0, 0, 0, 1}, EtherTypeIPv4, bytes.Repeat([]byte{0xaa}, 200))
+	_ = sw.processFrame(rawFrame(raw), conn)
+	flushSFlow(sw.sflow)
+
+	sample := receiveSFlow(t, collector)
+	if binary.BigEndian.Uint32(sample[0:4]) != sflowFlowSample {
+		t.Fatalf("Expected flow sample, got format %d", binary.BigEndian.Uint32(sample[0:4]))
+	}
+	body := sample[8:]
+	if binary.BigEndian.Uint32(body[4:8]) != 1 || binary.BigEndian.Uint32(body[8:12]) != 1 ||
+		binary.BigEndian.Uint32(body[12:16]) != 1 || binary.BigEndian.Uint32(body[20:24]) != 1 {
+		t.Errorf("Unexpected flow sample fields %x", body[:32])
+	}
+
+	record := body[32+8:]
+	headerLen := binary.BigEndian.Uint32(record[12:16])
+	if binary.BigEndian.Uint32(record[4:8]) != uint32(len(raw)+4) || headerLen != sflowMaxHeader ||
+		!bytes.Equal(record[16:16+headerLen], raw[:sflowMaxHeader]) {
+		t.Errorf("Unexpected raw header record %x", record[:16])
+	}
+}
+
+func TestSFlowCounterSample(t *testing.T) {
+	sw, collector := newSFlowTest(t, 1)
+
+	conn := NewConnection("guest", &frameSink{})
+	conn.FramesReceived, conn.BytesReceived = 3, 300
+	sw.connections.Store("guest", conn)
+	sw.sflow.pollCounters()
+	flushSFlow(sw.sflow)
+
+	sample := receiveSFlow(t, collector)
+	if binary.BigEndian.Uint32(sample[0:4]) != sflowCounterSample {
+		t.Fatalf("Expected counter sample, got format %d", binary.BigEndian.Uint32(sample[0:4]))
+	}
+	counters := sample[8+12+8:]
+	if binary.BigEndian.Uint32(counters[0:4]) != 1 || binary.BigEndian.Uint64(counters[24:32]) != 300 ||
+		binary.BigEndian.Uint32(counters[32:36]) != 3 {
+		t.Errorf("Unexpected interface counters %x", counters[:36])
+	}
+}
+
+func TestSFlowSamplingRate(t *testing.T) {
+	sw, _ := newSFlowTest(t, 10)
+
+	conn := NewConnection("guest", &frameSink{})
+	frame := rawFrame(buildEthernet(BroadcastMAC, net.HardwareAddr{0x52, 0x54, 0, 0, 0, 1}, EtherTypeIPv4, make([]byte, 46)))
+	for i := 0; i < 10000; i++ {
+		sw.sflow.handleFrame(frame, conn)
+	}
+
+	sw.sflow.mutex.Lock()
+	samples := sw.sflow.interfaces["guest"].flowSeq
+	sw.sflow.mutex.Unlock()
+	if samples < 800 || samples > 1200 {
+		t.Errorf("Expected about 1000 samples at 1-in-10, got %d", samples)
+	}
+	if pool := sw.sflow.pool.Load(); pool != 10000 {
+		t.Errorf("Expected sample pool of 10000, got %d", pool)
+	}
+}
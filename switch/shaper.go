@@ -0,0 +1,121 @@
+package vswitch
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// shaperBurstTime is the burst a bandwidth limit allows when it sets none,
+// in time at its rate
+const shaperBurstTime = 100 * time.Millisecond
+
+// errBandwidthExceeded is the error of frames dropped by a VLAN's shaper
+var errBandwidthExceeded = errors.New("VLAN bandwidth exceeded")
+
+// BandwidthLimit caps the traffic a VLAN forwards, flooded and unicast
+// alike, at an average Rate in bits per second with bursts of up to Burst
+// bytes, so that one lab segment cannot take all the forwarding capacity of
+// a shared host. Frames beyond it are dropped, which TCP in the guests
+// backs off from. Without a burst, 100ms of traffic at the rate is allowed.
+// The zero value leaves the VLAN unlimited.
+type BandwidthLimit struct {
+	Rate  int64
+	Burst int64
+}
+
+// ParseBandwidth parses a rate in bits per second, optionally followed by
+// a slash and a burst in bytes, both with an optional k, M or G suffix for
+// powers of 1000, e.g. 100M or 1G/512k
+func ParseBandwidth(spec string) (BandwidthLimit, error) {
+	rate, burst, hasBurst := strings.Cut(strings.TrimSpace(spec), "/")
+
+	var limit BandwidthLimit
+	var err error
+	if limit.Rate, err = parseQuantity(rate); err != nil {
+		return BandwidthLimit{}, fmt.Errorf("invalid bandwidth rate '%s'", rate)
+	}
+	if hasBurst {
+		if limit.Burst, err = parseQuantity(burst); err != nil {
+			return BandwidthLimit{}, fmt.Errorf("invalid bandwidth burst '%s'", burst)
+		}
+	}
+	return limit, limit.validate()
+}
+
+// String formats the limit as ParseBandwidth parses it, or "unlimited"
+func (l BandwidthLimit) String() string {
+	if l.Rate == 0 {
+		return "unlimited"
+	}
+	if l.Burst == 0 {
+		return formatQuantity(l.Rate)
+	}
+	return formatQuantity(l.Rate) + "/" + formatQuantity(l.Burst)
+}
+
+// validate checks that the limit is not negative and has a rate to burst
+func (l BandwidthLimit) validate() error {
+	switch {
+	case l.Rate < 0 || l.Burst < 0:
+		return fmt.Errorf("bandwidth rate and burst must not be negative")
+	case l.Rate == 0 && l.Burst > 0:
+		return fmt.Errorf("bandwidth burst needs a rate")
+	}
+	return nil
+}
+
+// quantitySuffixes are the suffixes of parseQuantity, largest first
+var quantitySuffixes = []struct {
+	suffix     string
+	multiplier int64
+}{{"G", 1000000000}, {"M", 1000000}, {"k", 1000}}
+
+// parseQuantity parses a whole number with an optional k, M or G suffix
+func parseQuantity(s string) (int64, error) {
+	multiplier := int64(1)
+	for _, unit := range quantitySuffixes {
+		if trimmed, found := strings.CutSuffix(s, unit.suffix); found {
+			s, multiplier = trimmed, unit.multiplier
+			break
+		}
+	}
+	value, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || value < 0 || value > (1<<62)/multiplier {
+		return 0, fmt.Errorf("invalid quantity '%s'", s)
+	}
+	return value * multiplier, nil
+}
+
+// formatQuantity formats a number with the largest suffix dividing it
+func formatQuantity(value int64) string {
+	for _, unit := range quantitySuffixes {
+		if value != 0 && value%unit.multiplier == 0 {
+			return strconv.FormatInt(value/unit.multiplier, 10) + unit.suffix
+		}
+	}
+	return strconv.FormatInt(value, 10)
+}
+
+// newShaper creates the token bucket of a bandwidth limit, counting bits,
+// or nil if it is unlimited. The bucket holds at least one frame of
+// maxFrame bytes, which could never pass otherwise.
+func newShaper(limit BandwidthLimit, maxFrame int) *rateLimiter {
+	if limit.Rate == 0 {
+		return nil
+	}
+
+	burst := float64(limit.Burst) * 8
+	if limit.Burst == 0 {
+		burst = float64(limit.Rate) * shaperBurstTime.Seconds()
+	}
+	return newTokenBucket(float64(limit.Rate), max(burst, float64(maxFrame)*8))
+}
+
+// shape takes the bits of a frame about to be forwarded from the VLAN's
+// shaper, returning false if the VLAN is over its bandwidth
+func (vs *VirtualSwitch) shape(frame *EthernetFrame) bool {
+	return vs.shaper == nil || vs.shaper.take(float64(len(frame.Raw))*8, time.Now())
+}
@@ -0,0 +1,66 @@
+package vswitch
+
+import (
+	"net"
+	"testing"
+)
+
+func TestParseBandwidth(t *testing.T) {
+	for spec, expected := range map[string]BandwidthLimit{
+		"100M":    {Rate: 100000000},
+		"1G/512k": {Rate: 1000000000, Burst: 512000},
+		"1500":    {Rate: 1500},
+	} {
+		limit, err := ParseBandwidth(spec)
+		if err != nil || limit != expected {
+			t.Errorf("Expected %s to parse as %+v, got %+v, %v", spec, expected, limit, err)
+		}
+		if formatted := limit.String(); formatted != spec {
+			t.Errorf("Expected %s to format back, got %s", spec, formatted)
+		}
+	}
+	if limit, _ := ParseBandwidth("64000/1500"); limit.String() != "64k/1500" {
+		t.Errorf("Expected 64k/1500, got %s", limit)
+	}
+
+	for _, spec := range []string{"fast", "-1M", "10M/", "10M/big", "0/5k", "10T"} {
+		if _, err := ParseBandwidth(spec); err == nil {
+			t.Errorf("Expected %s to be rejected", spec)
+		}
+	}
+}
+
+func TestVLANBandwidth(t *testing.T) {
+	// Three 1000-byte frames fit in the burst, the fourth is over it
+	sw := NewVirtualSwitchWithConfig([]int{8080}, Config{Bandwidth: BandwidthLimit{Rate: 8000, Burst: 3000}})
+	source := NewConnection("source", &frameSink{})
+	sw.connections.Store("source", source)
+	receiver := NewConnection("receiver", &frameSink{})
+	sw.connections.Store("receiver", receiver)
+
+	raw := buildEthernet(BroadcastMAC, net.HardwareAddr{0x52, 0x54, 0, 0, 0, 1}, EtherTypeIPv4, make([]byte, 986))
+	for i := 0; i < 4; i++ {
+		sw.handleFrame(rawFrame(raw), source)
+	}
+
+	if sent := receiver.Info().FramesSent; sent != 3 {
+		t.Errorf("Expected 3 frames forwarded, got %d", sent)
+	}
+	if drops := sw.drops[dropBandwidth].Load(); drops != 1 {
+		t.Errorf("Expected 1 frame dropped for bandwidth, got %d", drops)
+	}
+	if limit := sw.GetStats()["bandwidth_limit"]; limit != "8k/3k" {
+		t.Errorf("Expected the limit in the statistics, got %v", limit)
+	}
+}
+
+func TestShaperHoldsAFrame(t *testing.T) {
+	// A burst below the largest frame would never let it through
+	shaper := newShaper(BandwidthLimit{Rate: 1000, Burst: 10}, 1518)
+	if shaper.burst != 1518*8 {
+		t.Errorf("Expected the burst to hold a full frame, got %v bits", shaper.burst)
+	}
+	if newShaper(BandwidthLimit{}, 1518) != nil {
+		t.Errorf("Expected no shaper without a rate")
+	}
+}
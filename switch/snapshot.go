@@ -0,0 +1,440 @@
+package vswitch
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+)
+
+// maxSnapshotSize bounds the size of a snapshot to import
+const maxSnapshotSize = 16 << 20
+
+// errInvalidSnapshot is returned by Import for snapshots it cannot apply
+var errInvalidSnapshot = errors.New("invalid snapshot")
+
+// Snapshot is the declarative state of a switch: its VLANs with their
+// settings and the state set on them at runtime. Exported from one switch,
+// it recreates the VLANs on another, for backups or cloning an environment.
+type Snapshot struct {
+	VLANs []VLANSnapshot `json:"vlans"`
+}
+
+// VLANSnapshot is the declarative state of a VLAN
+type VLANSnapshot struct {
+	Port     int          `json:"port"`
+	Disabled bool         `json:"disabled,omitempty"`
+	Settings VLANSettings `json:"settings"`
+
+	// PeerLabels holds the label of each labeled peer address
+	PeerLabels map[string]string `json:"peer_labels,omitempty"`
+
+	// StaticMACs are the sticky MAC bindings, by the peer they are bound to
+	StaticMACs []StaticMAC `json:"static_macs,omitempty"`
+}
+
+// StaticMAC is a MAC address bound to a peer: a client identity as
+// "client:<id>", or a remote address
+type StaticMAC struct {
+	MAC  string `json:"mac"`
+	Peer string `json:"peer"`
+}
+
+// VLANSettings are the settings of a VLAN that describe its forwarding,
+// access control and limits. Services bound to the host, such as NAT, the
+// DHCP and DNS servers or the listeners, are left to the switch's own
+// configuration.
+type VLANSettings struct {
+	MTU                 int                 `json:"mtu,omitempty"`
+	MACTimeout          string              `json:"mac_timeout,omitempty"`
+	MACTableSize        int                 `json:"mac_table_size,omitempty"`
+	EgressQueueSize     int                 `json:"egress_queue_size,omitempty"`
+	SlowConsumerPolicy  string              `json:"slow_consumer_policy,omitempty"`
+	SlowConsumerTimeout string              `json:"slow_consumer_timeout,omitempty"`
+	TrunkBUMLimits      *BUMLimitSettings   `json:"trunk_bum_limits,omitempty"`
+	TagDemux            bool                `json:"tag_demux,omitempty"`
+	TagDemuxVIDs        []int               `json:"tag_demux_vids,omitempty"`
+	NativeVID           int                 `json:"native_vid,omitempty"`
+	ProtocolVLANs       []ProtocolVLAN      `json:"protocol_vlans,omitempty"`
+	Validation          string              `json:"validation,omitempty"`
+	PadFrames           bool                `json:"pad_frames,omitempty"`
+	DropUnknownUnicast  bool                `json:"drop_unknown_unicast,omitempty"`
+	StickyMAC           bool                `json:"sticky_mac,omitempty"`
+	MACMoveAnnounce     bool                `json:"mac_move_announce,omitempty"`
+	Hairpin             bool                `json:"hairpin,omitempty"`
+	PrivateVLAN         bool                `json:"private_vlan,omitempty"`
+	PromiscuousPeers    []string            `json:"promiscuous_peers,omitempty"`
+	AllowedClients      []string            `json:"allowed_clients,omitempty"`
+	DeniedClients       []string            `json:"denied_clients,omitempty"`
+	HorizonGroups       map[string][]string `json:"horizon_groups,omitempty"`
+	DHCPSnooping        bool                `json:"dhcp_snooping,omitempty"`
+	IPInventory         bool                `json:"ip_inventory,omitempty"`
+	TrustedPeers        []string            `json:"trusted_peers,omitempty"`
+	RAGuard             bool                `json:"ra_guard,omitempty"`
+	NDInspection        bool                `json:"nd_inspection,omitempty"`
+	ARPSuppression      bool                `json:"arp_suppression,omitempty"`
+	LLDP                bool                `json:"lldp,omitempty"`
+	ProtocolStats       int                 `json:"protocol_stats,omitempty"`
+	Impairment          *Impairment         `json:"impairment,omitempty"`
+	Bandwidth           *BandwidthSettings  `json:"bandwidth,omitempty"`
+}
+
+// BUMLimitSettings are the limits of the flooded frames per second sent to
+// each trunk link
+type BUMLimitSettings struct {
+	Broadcast      int `json:"broadcast,omitempty"`
+	UnknownUnicast int `json:"unknown_unicast,omitempty"`
+	Multicast      int `json:"multicast,omitempty"`
+}
+
+// BandwidthSettings are the rate in bits per second and burst in bytes the
+// traffic of a VLAN is shaped to
+type BandwidthSettings struct {
+	Rate  int64 `json:"rate"`
+	Burst int64 `json:"burst,omitempty"`
+}
+
+// ImportResult reports what importing a snapshot did
+type ImportResult struct {
+	// Created are the VLANs that did not exist and were started
+	Created []int `json:"created"`
+
+	// Updated are the VLANs that existed; they keep their settings and
+	// take the rest of the state of the snapshot
+	Updated []int `json:"updated"`
+}
+
+// settingsOf returns the settings of a VLAN configuration
+func settingsOf(config Config) VLANSettings {
+	settings := VLANSettings{
+		MTU:                config.MTU,
+		MACTableSize:       config.MACTableSize,
+		EgressQueueSize:    config.EgressQueueSize,
+		PadFrames:          config.PadFrames,
+		DropUnknownUnicast: config.DropUnknownUnicast,
+		StickyMAC:          config.StickyMAC,
+		MACMoveAnnounce:    config.MACMoveAnnounce,
+		Hairpin:            config.Hairpin,
+		PrivateVLAN:        config.PrivateVLAN,
+		PromiscuousPeers:   formatCIDRs(config.PromiscuousPeers),
+		AllowedClients:     formatCIDRs(config.AllowedClients),
+		DeniedClients:      formatCIDRs(config.DeniedClients),
+		DHCPSnooping:       config.DHCPSnooping,
+		IPInventory:        config.IPInventory,
+		TrustedPeers:       formatCIDRs(config.TrustedPeers),
+		RAGuard:            config.RAGuard,
+		NDInspection:       config.NDInspection,
+		ARPSuppression:     config.ARPSuppression,
+		LLDP:               config.LLDP,
+		ProtocolStats:      config.ProtocolStats,
+		TagDemux:           config.TagDemux,
+		TagDemuxVIDs:       config.TagDemuxVIDs,
+		NativeVID:          config.NativeVID,
+		ProtocolVLANs:      config.ProtocolVLANs,
+		SlowConsumerPolicy: config.SlowConsumerPolicy.String(),
+		Validation:         config.Validation.String(),
+	}
+	if config.MACTimeout > 0 {
+		settings.MACTimeout = config.MACTimeout.String()
+	}
+	if config.SlowConsumerTimeout > 0 {
+		settings.SlowConsumerTimeout = config.SlowConsumerTimeout.String()
+	}
+	if limits := config.TrunkBUMLimits; limits != (BUMLimits{}) {
+		settings.TrunkBUMLimits = &BUMLimitSettings{
+			Broadcast:      limits.Broadcast,
+			UnknownUnicast: limits.UnknownUnicast,
+			Multicast:      limits.Multicast,
+		}
+	}
+	if impairment := config.Impairment; !impairment.IsZero() {
+		settings.Impairment = &impairment
+	}
+	if limit := config.Bandwidth; limit.Rate > 0 {
+		settings.Bandwidth = &BandwidthSettings{Rate: limit.Rate, Burst: limit.Burst}
+	}
+	if len(config.HorizonGroups) > 0 {
+		settings.HorizonGroups = make(map[string][]string, len(config.HorizonGroups))
+		for _, group := range config.HorizonGroups {
+			settings.HorizonGroups[group.Name] = formatCIDRs(group.Peers)
+		}
+	}
+	return settings
+}
+
+// apply sets the settings on a VLAN configuration and validates the
+// result. Its other fields, and those of the settings left empty, such as
+// the MAC timeout, keep their values.
+func (s VLANSettings) apply(config *Config) error {
+	config.MTU = s.MTU
+	config.MACTableSize = s.MACTableSize
+	config.EgressQueueSize = s.EgressQueueSize
+	config.PadFrames = s.PadFrames
+	config.DropUnknownUnicast = s.DropUnknownUnicast
+	config.StickyMAC = s.StickyMAC
+	config.MACMoveAnnounce = s.MACMoveAnnounce
+	config.Hairpin = s.Hairpin
+	config.PrivateVLAN = s.PrivateVLAN
+	config.DHCPSnooping = s.DHCPSnooping
+	config.IPInventory = s.IPInventory
+	config.RAGuard = s.RAGuard
+	config.NDInspection = s.NDInspection
+	config.ARPSuppression = s.ARPSuppression
+	config.LLDP = s.LLDP
+	config.ProtocolStats = s.ProtocolStats
+	config.TagDemux = s.TagDemux
+	config.TagDemuxVIDs = s.TagDemuxVIDs
+	config.NativeVID = s.NativeVID
+	config.ProtocolVLANs = s.ProtocolVLANs
+
+	var err error
+	if err = parseSettingDuration("mac_timeout", s.MACTimeout, &config.MACTimeout); err != nil {
+		return err
+	}
+	if err = parseSettingDuration("slow_consumer_timeout", s.SlowConsumerTimeout, &config.SlowConsumerTimeout); err != nil {
+		return err
+	}
+	if s.SlowConsumerPolicy != "" {
+		if config.SlowConsumerPolicy, err = ParseSlowConsumerPolicy(s.SlowConsumerPolicy); err != nil {
+			return err
+		}
+	}
+	if s.Validation != "" {
+		if config.Validation, err = ParseValidationMode(s.Validation); err != nil {
+			return err
+		}
+	}
+	config.Impairment = Impairment{}
+	if s.Impairment != nil {
+		config.Impairment = *s.Impairment
+	}
+	config.Bandwidth = BandwidthLimit{}
+	if limit := s.Bandwidth; limit != nil {
+		config.Bandwidth = BandwidthLimit{Rate: limit.Rate, Burst: limit.Burst}
+	}
+	config.TrunkBUMLimits = BUMLimits{}
+	if limits := s.TrunkBUMLimits; limits != nil {
+		config.TrunkBUMLimits = BUMLimits{
+			Broadcast:      limits.Broadcast,
+			UnknownUnicast: limits.UnknownUnicast,
+			Multicast:      limits.Multicast,
+		}
+	}
+
+	for _, peers := range []struct {
+		name string
+		list []string
+		nets *[]*net.IPNet
+	}{
+		{"promiscuous_peers", s.PromiscuousPeers, &config.PromiscuousPeers},
+		{"allowed_clients", s.AllowedClients, &config.AllowedClients},
+		{"denied_clients", s.DeniedClients, &config.DeniedClients},
+		{"trusted_peers", s.TrustedPeers, &config.TrustedPeers},
+	} {
+		if *peers.nets, err = ParseCIDRList(strings.Join(peers.list, ",")); err != nil {
+			return fmt.Errorf("%s: %v", peers.name, err)
+		}
+	}
+
+	config.HorizonGroups = nil
+	names := make([]string, 0, len(s.HorizonGroups))
+	for name := range s.HorizonGroups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		peers, err := ParseCIDRList(strings.Join(s.HorizonGroups[name], ","))
+		if err != nil {
+			return fmt.Errorf("horizon group %s: %v", name, err)
+		}
+		config.HorizonGroups = append(config.HorizonGroups, HorizonGroup{Name: name, Peers: peers})
+	}
+
+	return config.validate()
+}
+
+// parseSettingDuration parses an optional duration setting into d
+func parseSettingDuration(name, value string, d *time.Duration) error {
+	if value == "" {
+		return nil
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return fmt.Errorf("invalid %s %q: %v", name, value, err)
+	}
+	*d = parsed
+	return nil
+}
+
+// formatCIDRs formats prefixes as strings, or nil if there are none
+func formatCIDRs(nets []*net.IPNet) []string {
+	if len(nets) == 0 {
+		return nil
+	}
+	list := make([]string, len(nets))
+	for i, ipNet := range nets {
+		list[i] = ipNet.String()
+	}
+	return list
+}
+
+// Snapshot returns the declarative state of the VLAN
+func (vs *VirtualSwitch) Snapshot() VLANSnapshot {
+	snapshot := VLANSnapshot{
+		Port:     vs.port(),
+		Disabled: vs.disabled.Load(),
+		Settings: settingsOf(vs.config),
+	}
+	// The impairment may have changed since the VLAN started
+	snapshot.Settings.Impairment = nil
+	if impairment := vs.Impairment(); !impairment.IsZero() {
+		snapshot.Settings.Impairment = &impairment
+	}
+
+	for _, peer := range vs.PeerLabels() {
+		if snapshot.PeerLabels == nil {
+			snapshot.PeerLabels = make(map[string]string)
+		}
+		snapshot.PeerLabels[peer.Address] = peer.Label
+	}
+
+	vs.macBindings.Range(func(key, value interface{}) bool {
+		snapshot.StaticMACs = append(snapshot.StaticMACs, StaticMAC{MAC: key.(string), Peer: value.(*Connection).stateKey()})
+		return true
+	})
+	// Bindings waiting for their peer to come back are as much part of it
+	for _, entry := range vs.macState.static() {
+		snapshot.StaticMACs = append(snapshot.StaticMACs, StaticMAC{MAC: entry.MAC, Peer: entry.Peer})
+	}
+	sort.Slice(snapshot.StaticMACs, func(i, j int) bool {
+		return snapshot.StaticMACs[i].MAC < snapshot.StaticMACs[j].MAC
+	})
+
+	return snapshot
+}
+
+// importState applies the runtime state of a snapshot to the VLAN. Static
+// MACs are bound when their peer connects, or at once if it is connected.
+func (vs *VirtualSwitch) importState(snapshot VLANSnapshot) {
+	vs.SetDisabled(snapshot.Disabled)
+	for address, label := range snapshot.PeerLabels {
+		vs.SetPeerLabel(net.ParseIP(address), label)
+	}
+
+	vs.addStaticMACs(snapshot.StaticMACs)
+}
+
+// addStaticMACs queues static MACs for their peers and binds those of the
+// peers connected
+func (vs *VirtualSwitch) addStaticMACs(statics []StaticMAC) {
+	if len(statics) == 0 {
+		return
+	}
+	now := time.Now()
+	entries := make([]macStateEntry, 0, len(statics))
+	for _, static := range statics {
+		entries = append(entries, macStateEntry{MAC: static.MAC, Peer: static.Peer, Static: true, LearnedAt: now})
+	}
+	vs.macState.add(entries)
+	vs.connections.Range(func(_, value interface{}) bool {
+		vs.restoreMACs(value.(*Connection))
+		return true
+	})
+}
+
+// validate checks the parts of a VLAN snapshot that are not settings
+func (s VLANSnapshot) validate() error {
+	if s.Port <= 0 || s.Port > 65535 {
+		return fmt.Errorf("invalid port %d", s.Port)
+	}
+	for address := range s.PeerLabels {
+		if net.ParseIP(address) == nil {
+			return fmt.Errorf("VLAN %d: invalid peer address %q", s.Port, address)
+		}
+	}
+	for _, static := range s.StaticMACs {
+		if _, err := net.ParseMAC(static.MAC); err != nil || static.Peer == "" {
+			return fmt.Errorf("VLAN %d: invalid static MAC %q for peer %q", s.Port, static.MAC, static.Peer)
+		}
+	}
+	return nil
+}
+
+// Snapshot returns the declarative state of all VLANs, sorted by port
+func (sm *SwitchManager) Snapshot() Snapshot {
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+
+	snapshot := Snapshot{VLANs: make([]VLANSnapshot, 0, len(sm.switches))}
+	for _, vs := range sm.switches {
+		snapshot.VLANs = append(snapshot.VLANs, vs.Snapshot())
+	}
+	sort.Slice(snapshot.VLANs, func(i, j int) bool {
+		return snapshot.VLANs[i].Port < snapshot.VLANs[j].Port
+	})
+	return snapshot
+}
+
+// snapshotConfigs validates a snapshot and returns the configuration of
+// each of its VLANs: that of the VLAN if it exists, or else the default
+// configuration, with the snapshot's settings
+func (sm *SwitchManager) snapshotConfigs(snapshot Snapshot) (map[int]Config, error) {
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+
+	configs := make(map[int]Config, len(snapshot.VLANs))
+	for _, vlan := range snapshot.VLANs {
+		if err := vlan.validate(); err != nil {
+			return nil, fmt.Errorf("%w: %w", errInvalidSnapshot, err)
+		}
+		if _, duplicate := configs[vlan.Port]; duplicate {
+			return nil, fmt.Errorf("%w: VLAN %d appears twice", errInvalidSnapshot, vlan.Port)
+		}
+		config := sm.defaultConfig
+		if vs, exists := sm.switches[vlan.Port]; exists {
+			config = vs.config
+		}
+		if err := vlan.Settings.apply(&config); err != nil {
+			return nil, fmt.Errorf("%w: VLAN %d: %w", errInvalidSnapshot, vlan.Port, err)
+		}
+		configs[vlan.Port] = config
+	}
+	return configs, nil
+}
+
+// Import starts the VLANs of a snapshot that do not exist, with the default
+// configuration and the snapshot's settings, and applies the rest of the
+// snapshot's state to all of them. The snapshot is validated before
+// anything changes; VLANs already started stay when a later one fails to.
+func (sm *SwitchManager) Import(snapshot Snapshot) (ImportResult, error) {
+	sm.reconcileMutex.Lock()
+	defer sm.reconcileMutex.Unlock()
+
+	result := ImportResult{Created: []int{}, Updated: []int{}}
+
+	configs, err := sm.snapshotConfigs(snapshot)
+	if err != nil {
+		return result, err
+	}
+
+	for _, vlan := range snapshot.VLANs {
+		vs := sm.vlan(vlan.Port)
+		if vs == nil {
+			if err := sm.StartVLANWithConfig(vlan.Port, configs[vlan.Port]); err != nil {
+				return result, err
+			}
+			vs = sm.vlan(vlan.Port)
+			result.Created = append(result.Created, vlan.Port)
+		} else {
+			result.Updated = append(result.Updated, vlan.Port)
+		}
+		if vs != nil {
+			vs.importState(vlan)
+		}
+	}
+
+	sm.logger().Info("Imported snapshot", "created", result.Created, "updated", result.Updated)
+	return result, nil
+}
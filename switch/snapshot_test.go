@@ -0,0 +1,110 @@
+package vswitch
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	source := NewSwitchManager()
+	source.SetDefaultConfig(Config{InMemory: true})
+	config := Config{
+		InMemory:       true,
+		MACTimeout:     2 * time.Minute,
+		StickyMAC:      true,
+		Validation:     ValidationStrict,
+		TrunkBUMLimits: BUMLimits{Broadcast: 100},
+		HorizonGroups:  []HorizonGroup{{Name: "uplinks", Peers: []*net.IPNet{{IP: net.IP{10, 0, 0, 0}, Mask: net.CIDRMask(8, 32)}}}},
+	}
+	config.AllowedClients, _ = ParseCIDRList("127.0.0.1,10.0.0.0/8")
+	if err := source.AddVLANWithConfig(9999, config); err != nil {
+		t.Fatalf("Failed to add VLAN: %v", err)
+	}
+	if err := source.StartAll(context.Background()); err != nil {
+		t.Fatalf("Failed to start: %v", err)
+	}
+	defer source.StopAll()
+
+	vs := source.vlan(9999)
+	vs.SetDisabled(true)
+	vs.SetPeerLabel(net.ParseIP("10.0.0.5"), "lab/vm1")
+	guest := NewConnection("guest", &mockConnSwitch{addr: &mockAddrSwitch{network: "tcp", address: "10.0.0.5:40001"}})
+	vs.connections.Store("guest", guest)
+	mac := net.HardwareAddr{0x52, 0x54, 0x00, 0x00, 0x00, 0x01}
+	vs.checkMACBinding(mac, guest)
+
+	snapshot := source.Snapshot()
+	if len(snapshot.VLANs) != 1 {
+		t.Fatalf("Expected one VLAN, got %+v", snapshot)
+	}
+	exported := snapshot.VLANs[0]
+	if !exported.Disabled || exported.PeerLabels["10.0.0.5"] != "lab/vm1" || exported.Settings.Validation != "strict" {
+		t.Errorf("Expected the VLAN state to be exported, got %+v", exported)
+	}
+	if len(exported.StaticMACs) != 1 || exported.StaticMACs[0] != (StaticMAC{MAC: mac.String(), Peer: "10.0.0.5:40001"}) {
+		t.Errorf("Expected the sticky binding to be exported, got %+v", exported.StaticMACs)
+	}
+
+	// A fresh switch recreates the same state
+	target := NewSwitchManager()
+	target.SetDefaultConfig(Config{InMemory: true})
+	defer target.StopAll()
+	result, err := target.Import(snapshot)
+	if err != nil {
+		t.Fatalf("Failed to import: %v", err)
+	}
+	if !reflect.DeepEqual(result.Created, []int{9999}) || len(result.Updated) != 0 {
+		t.Errorf("Expected the VLAN to be created, got %+v", result)
+	}
+	if reimported := target.Snapshot(); !reflect.DeepEqual(reimported, snapshot) {
+		t.Errorf("Expected the imported state to match\n%+v\ngot\n%+v", snapshot, reimported)
+	}
+
+	// The static MAC is bound to its peer as soon as it is connected
+	imported := target.vlan(9999)
+	peer := NewConnection("peer", &mockConnSwitch{addr: &mockAddrSwitch{network: "tcp", address: "10.0.0.5:40001"}})
+	imported.connections.Store("peer", peer)
+	if result, err = target.Import(snapshot); err != nil || !reflect.DeepEqual(result.Updated, []int{9999}) {
+		t.Fatalf("Expected the existing VLAN to be updated, got %+v, %v", result, err)
+	}
+	if imported.checkMACBinding(mac, NewConnection("other", &frameSink{})) {
+		t.Errorf("Expected the MAC to be bound to its peer")
+	}
+	if conn := imported.lookupMAC(mac); conn != peer {
+		t.Errorf("Expected the MAC to be learned on its peer")
+	}
+}
+
+func TestImportInvalid(t *testing.T) {
+	sm := NewSwitchManager()
+	sm.SetDefaultConfig(Config{InMemory: true})
+	defer sm.StopAll()
+	handler := NewAPIHandler(sm, "secret")
+
+	for _, body := range []string{
+		`{"vlans": [{"port": 0}]}`,
+		`{"vlans": [{"port": 9999, "settings": {"validation": "lenient"}}]}`,
+		`{"vlans": [{"port": 9999, "settings": {"allowed_clients": ["10.0.0.0/33"]}}]}`,
+		`{"vlans": [{"port": 9999, "static_macs": [{"mac": "nope", "peer": "10.0.0.5:1"}]}]}`,
+		`{"vlans": [{"port": 9999}, {"port": 9999}]}`,
+		`{"vlans": `,
+	} {
+		if code := apiRequest(handler, http.MethodPost, "/config", body, "secret"); code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for %s, got %d", body, code)
+		}
+	}
+	if vlans := sm.GetVLANs(); len(vlans) != 0 {
+		t.Errorf("Expected an invalid snapshot to change nothing, got VLANs %v", vlans)
+	}
+
+	if code := apiRequest(handler, http.MethodPost, "/config", `{"vlans": [{"port": 9999}]}`, "secret"); code != http.StatusOK {
+		t.Errorf("Expected the snapshot to be imported, got %d", code)
+	}
+	if code := apiRequest(NewAPIHandler(sm, ""), http.MethodPost, "/config", `{"vlans": []}`, ""); code != http.StatusForbidden {
+		t.Errorf("Expected importing to require management, got %d", code)
+	}
+}
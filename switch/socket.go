@@ -0,0 +1,124 @@
+package vswitch
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+)
+
+// SocketOptions tunes the TCP sockets of a VLAN's listener and of the
+// connections it accepts
+type SocketOptions struct {
+	// Nagle enables Nagle's algorithm, which Go disables (TCP_NODELAY),
+	// batching small frames into fewer segments at the cost of latency
+	Nagle bool
+
+	// SendBuffer and ReceiveBuffer size the kernel buffers of connections
+	// in bytes (SO_SNDBUF and SO_RCVBUF). Zero keeps the system default.
+	SendBuffer    int
+	ReceiveBuffer int
+
+	// KeepAlive is the idle time before TCP keepalive probes and the
+	// interval between them. Zero uses Go's default of 15 seconds and a
+	// negative value disables keepalives.
+	KeepAlive time.Duration
+
+	// Backlog is the length of the listener's queue of connections waiting
+	// to be accepted. Zero keeps the system maximum; it is only applied on
+	// Unix.
+	Backlog int
+
+	// Listeners is the number of listeners opened on each port with
+	// SO_REUSEPORT, each with its own accept loop, so that the kernel
+	// spreads the connections of VLANs with very many clients, and the
+	// work of accepting them, across cores. Zero or one opens a single
+	// listener; more are only supported on Linux.
+	Listeners int
+}
+
+// ListenFunc opens the listener of a port of a VLAN
+type ListenFunc func(port int) (net.Listener, error)
+
+// listenersPerPort returns how many listeners each port of the switch has
+func (vs *VirtualSwitch) listenersPerPort() int {
+	if vs.config.Listen != nil {
+		return 1
+	}
+	return max(1, vs.config.Socket.Listeners)
+}
+
+// listen opens the listeners of a port: the configured Listen function's,
+// or else TCP sockets of the VLAN's address family, sharing the port with
+// SO_REUSEPORT when there are several. If one cannot be opened, none is.
+func (vs *VirtualSwitch) listen(port int) ([]net.Listener, error) {
+	if vs.config.Listen != nil {
+		listener, err := vs.config.Listen(port)
+		if err != nil {
+			return nil, err
+		}
+		return []net.Listener{listener}, nil
+	}
+
+	network, addr := vs.config.ListenFamily.network(), ":"+strconv.Itoa(port)
+	count := vs.listenersPerPort()
+	listeners := make([]net.Listener, 0, count)
+	for i := 0; i < count; i++ {
+		var listener net.Listener
+		var err error
+		if count == 1 {
+			listener, err = Listen(network, addr)
+		} else {
+			listener, err = listenShared(network, addr, i)
+		}
+		if err != nil {
+			for _, opened := range listeners {
+				_ = opened.Close()
+			}
+			return nil, fmt.Errorf("%s: %w", vs.config.ListenFamily, err)
+		}
+		vs.tuneListener(listener, port)
+		listeners = append(listeners, listener)
+	}
+	return listeners, nil
+}
+
+// tuneListener applies the socket options of a listener
+func (vs *VirtualSwitch) tuneListener(listener net.Listener, port int) {
+	if vs.config.Socket.Backlog <= 0 {
+		return
+	}
+	if err := setBacklog(listener, vs.config.Socket.Backlog); err != nil {
+		vs.logger.Warn("Failed to set listen backlog", "port", port, "backlog", vs.config.Socket.Backlog, "error", err)
+	}
+}
+
+// tuneConn applies the socket options of an accepted connection
+func (vs *VirtualSwitch) tuneConn(conn net.Conn) {
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		return
+	}
+	options := vs.config.Socket
+
+	var err error
+	if options.Nagle {
+		err = tcpConn.SetNoDelay(false)
+	}
+	if options.SendBuffer > 0 && err == nil {
+		err = tcpConn.SetWriteBuffer(options.SendBuffer)
+	}
+	if options.ReceiveBuffer > 0 && err == nil {
+		err = tcpConn.SetReadBuffer(options.ReceiveBuffer)
+	}
+	if options.KeepAlive != 0 && err == nil {
+		err = tcpConn.SetKeepAliveConfig(net.KeepAliveConfig{
+			Enable:   options.KeepAlive > 0,
+			Idle:     options.KeepAlive,
+			Interval: options.KeepAlive,
+		})
+	}
+	if err != nil {
+		vs.logger.Warn("Failed to tune connection socket", "remote", conn.RemoteAddr().String(), "error", err)
+	}
+}
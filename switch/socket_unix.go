@@ -0,0 +1,30 @@
+//go:build !windows
+
+package vswitch
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+)
+
+// setBacklog changes the accept queue length of a listening socket by
+// listening on it again
+func setBacklog(listener net.Listener, backlog int) error {
+	sc, ok := listener.(syscall.Conn)
+	if !ok {
+		return fmt.Errorf("listener has no socket")
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var listenErr error
+	if err := raw.Control(func(fd uintptr) {
+		listenErr = syscall.Listen(int(fd), backlog)
+	}); err != nil {
+		return err
+	}
+	return listenErr
+}
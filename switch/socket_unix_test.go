@@ -0,0 +1,188 @@
+//go:build !windows
+
+package vswitch
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestSocketOptions(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to find a free port: %v", err)
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+	_ = listener.Close()
+
+	sw := NewVirtualSwitchWithConfig([]int{port}, Config{Socket: SocketOptions{
+		Nagle:         true,
+		ReceiveBuffer: 256 * 1024,
+		KeepAlive:     30 * time.Second,
+		Backlog:       16,
+	}})
+	if err := sw.Start(context.Background()); err != nil {
+		t.Fatalf("Failed to start switch: %v", err)
+	}
+	defer sw.Stop()
+
+	client, err := net.Dial("tcp", "127.0.0.1:"+strconv.Itoa(port))
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer func() { _ = client.Close() }()
+
+	var conn *Connection
+	deadline := time.Now().Add(5 * time.Second)
+	for conn == nil && time.Now().Before(deadline) {
+		sw.connections.Range(func(_, value interface{}) bool {
+			conn = value.(*Connection)
+			return false
+		})
+		time.Sleep(10 * time.Millisecond)
+	}
+	if conn == nil {
+		t.Fatal("Expected the connection to be accepted")
+	}
+
+	raw, err := conn.Conn.(*net.TCPConn).SyscallConn()
+	if err != nil {
+		t.Fatalf("Failed to get socket: %v", err)
+	}
+	var noDelay, rcvBuf, keepAlive int
+	_ = raw.Control(func(fd uintptr) {
+		noDelay, _ = syscall.GetsockoptInt(int(fd), syscall.IPPROTO_TCP, syscall.TCP_NODELAY)
+		rcvBuf, _ = syscall.GetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_RCVBUF)
+		keepAlive, _ = syscall.GetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_KEEPALIVE)
+	})
+	if noDelay != 0 {
+		t.Error("Expected Nagle's algorithm to be enabled")
+	}
+	if rcvBuf < 256*1024 {
+		t.Errorf("Expected a receive buffer of at least 256 KiB, got %d", rcvBuf)
+	}
+	if keepAlive == 0 {
+		t.Error("Expected keepalives to be enabled")
+	}
+}
+
+func TestSetBacklog(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer func() { _ = listener.Close() }()
+
+	if err := setBacklog(listener, 16); err != nil {
+		t.Errorf("Failed to set backlog: %v", err)
+	}
+}
+
+func TestUnixListener(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "vlan.sock")
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sm, err := New(ctx, Options{Ports: []int{9999}, Listeners: map[int]net.Listener{9999: listener}})
+	if err != nil {
+		t.Fatalf("Failed to create switch: %v", err)
+	}
+	vs := sm.vlan(9999)
+
+	// Clients on the unix socket get connections of their own
+	var guests []net.Conn
+	for i := 0; i < 2; i++ {
+		guest, err := net.Dial("unix", path)
+		if err != nil {
+			t.Fatalf("Failed to connect: %v", err)
+		}
+		defer func() { _ = guest.Close() }()
+		guests = append(guests, guest)
+	}
+	waitUntil(t, "both clients are connected", func() bool { return vs.connectionCount() == 2 })
+	for _, info := range vs.GetConnections() {
+		if !strings.HasPrefix(info.ID, "unix-9999-") {
+			t.Errorf("Expected a numbered unix connection, got %s", info.ID)
+		}
+	}
+
+	mac := net.HardwareAddr{0x52, 0x54, 0x00, 0x00, 0x00, 0x01}
+	if _, err := guests[0].Write(qemuFrame(buildEthernet(BroadcastMAC, mac, EtherTypeARP, make([]byte, 46)))); err != nil {
+		t.Fatalf("Failed to send frame: %v", err)
+	}
+	if frame := readGuestFrame(t, guests[1]); !bytes.Equal(frame.SrcMAC, mac) {
+		t.Errorf("Expected the broadcast, got a frame from %s", frame.SrcMAC)
+	}
+
+	// The switch closes the listener when it stops
+	cancel()
+	sm.StopAll()
+	if conn, err := net.Dial("unix", path); err == nil {
+		_ = conn.Close()
+		t.Errorf("Expected the listener to be closed")
+	}
+}
+
+func TestMixedListeners(t *testing.T) {
+	tcp, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "vlan.sock")
+	unix, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+
+	// The TCP and the unix socket feed the same VLAN
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sm, err := New(ctx, Options{
+		Ports:      []int{9999},
+		ExtraPorts: map[int][]int{9999: {9998}},
+		Listeners:  map[int]net.Listener{9999: tcp, 9998: unix},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create switch: %v", err)
+	}
+	defer sm.StopAll()
+	if vlans := sm.GetVLANs(); len(vlans) != 1 {
+		t.Fatalf("Expected a single VLAN, got %v", vlans)
+	}
+
+	tcpGuest, err := net.Dial("tcp", tcp.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer func() { _ = tcpGuest.Close() }()
+	unixGuest, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer func() { _ = unixGuest.Close() }()
+	vs := sm.vlan(9999)
+	waitUntil(t, "both clients are connected", func() bool { return vs.connectionCount() == 2 })
+
+	mac := net.HardwareAddr{0x52, 0x54, 0x00, 0x00, 0x00, 0x01}
+	if _, err := tcpGuest.Write(qemuFrame(buildEthernet(BroadcastMAC, mac, EtherTypeARP, make([]byte, 46)))); err != nil {
+		t.Fatalf("Failed to send frame: %v", err)
+	}
+	if frame := readGuestFrame(t, unixGuest); !bytes.Equal(frame.SrcMAC, mac) {
+		t.Errorf("Expected the broadcast, got a frame from %s", frame.SrcMAC)
+	}
+
+	if _, err := New(ctx, Options{Ports: []int{9999}, ExtraPorts: map[int][]int{9997: {9996}}}); err == nil {
+		t.Errorf("Expected extra ports of an unknown VLAN to be rejected")
+	}
+}
@@ -0,0 +1,12 @@
+package vswitch
+
+import (
+	"errors"
+	"net"
+)
+
+// setBacklog fails on Windows, where listening again on a socket leaves its
+// backlog unchanged
+func setBacklog(_ net.Listener, _ int) error {
+	return errors.New("changing the listen backlog is not supported on Windows")
+}
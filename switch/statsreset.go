@@ -0,0 +1,109 @@
+package vswitch
+
+import (
+	"fmt"
+	"time"
+)
+
+// ResetStats zeroes the counters of the VLAN and its connections and
+// records when, so that an experiment can be measured from a clean slate
+// without restarting the switch. Readers of the statistics see either all
+// counters before the reset or all after it; frames forwarded while it
+// runs may be counted on either side. It returns the time of the reset.
+func (vs *VirtualSwitch) ResetStats() time.Time {
+	vs.statsMutex.Lock()
+	defer vs.statsMutex.Unlock()
+
+	now := time.Now()
+	for _, counter := range vs.counters() {
+		counter.Store(0)
+	}
+	for _, categories := range vs.categoryCounters() {
+		for _, counter := range categories {
+			counter.Store(0)
+		}
+	}
+	vs.forwardLatency.reset()
+	vs.deliveryLatency.reset()
+	vs.frameSizes.reset()
+	vs.rxRates.reset()
+
+	if vs.protocolStats != nil {
+		vs.protocolStats.reset()
+	}
+
+	vs.connections.Range(func(_, value interface{}) bool {
+		value.(*Connection).resetStats(now)
+		return true
+	})
+	vs.statsResetAt = now
+	vs.logger.Info("Statistics reset")
+	return now
+}
+
+// ResetConnectionStats zeroes the counters of a connection of the VLAN and
+// returns the time of the reset
+func (vs *VirtualSwitch) ResetConnectionStats(connID string) (time.Time, error) {
+	value, found := vs.connections.Load(connID)
+	if !found {
+		return time.Time{}, fmt.Errorf("connection %s not found", connID)
+	}
+
+	now := time.Now()
+	value.(*Connection).resetStats(now)
+	vs.logger.Info("Connection statistics reset", "connection", connID)
+	return now, nil
+}
+
+// resetStats zeroes the counters of the connection and records when
+func (c *Connection) resetStats(now time.Time) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.FramesSent, c.FramesReceived = 0, 0
+	c.BytesSent, c.BytesReceived = 0, 0
+	c.queueDrops.Store(0)
+	c.bumDrops.Store(0)
+	c.impairmentDrops.Store(0)
+	c.rxRates.reset()
+	c.txRates.reset()
+	c.statsResetAt = now
+}
+
+// reset zeroes the histogram
+func (h *latencyHistogram) reset() {
+	for i := range h.counts {
+		h.counts[i].Store(0)
+	}
+	h.sum.Store(0)
+}
+
+// reset zeroes the histogram
+func (h *sizeHistogram) reset() {
+	for i := range h.counts {
+		h.counts[i].Store(0)
+	}
+	h.sum.Store(0)
+}
+
+// reset forgets the averages; the next sample sets a new baseline
+func (m *rateMeter) reset() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.last, m.frames, m.bytes = time.Time{}, 0, 0
+	m.rates = Rates{}
+}
+
+// reset zeroes the protocol breakdown, keeping the sampling phase
+func (p *protocolStats) reset() {
+	p.sampled.Store(0)
+	for i := range p.protocols {
+		p.protocols[i].Store(0)
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.ports = make(map[string]uint64)
+	p.dnsQueries = make(map[string]uint64)
+}
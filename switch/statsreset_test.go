@@ -0,0 +1,114 @@
+package vswitch
+
+import (
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestResetStats(t *testing.T) {
+	sw := NewVirtualSwitch([]int{8080})
+	guest, other := &frameSink{}, &frameSink{}
+	conn := NewConnection("guest", guest)
+	sw.connections.Store("guest", conn)
+	sw.connections.Store("other", NewConnection("other", other))
+	createdAt := sw.GetStats()["stats_reset_at"].(time.Time)
+
+	src := net.HardwareAddr{0x52, 0x54, 0x00, 0x00, 0x00, 0x01}
+	for i := 0; i < 3; i++ {
+		if err := sw.processFrame(rawFrame(buildEthernet(BroadcastMAC, src, EtherTypeARP, make([]byte, 46))), conn); err != nil {
+			t.Fatalf("Failed to process frame: %v", err)
+		}
+	}
+	sw.sampleRatesAt(time.Now())
+	if frames := sw.GetStats()["total_frames"].(uint64); frames != 3 {
+		t.Fatalf("Expected 3 frames before the reset, got %d", frames)
+	}
+
+	resetAt := sw.ResetStats()
+	stats := sw.GetStats()
+	if !resetAt.After(createdAt) || stats["stats_reset_at"] != resetAt {
+		t.Errorf("Expected the reset time to be recorded, got %v", stats["stats_reset_at"])
+	}
+	for _, key := range []string{"total_frames", "broadcast_frames"} {
+		if stats[key].(uint64) != 0 {
+			t.Errorf("Expected %s to be zeroed, got %d", key, stats[key])
+		}
+	}
+	if sizes := stats["frame_sizes"].(SizeHistogram); sizes.Count != 0 || sizes.SumBytes != 0 {
+		t.Errorf("Expected the frame sizes to be zeroed, got %+v", sizes)
+	}
+	if arp := stats["ethertype_frames"].(map[string]uint64)["arp"]; arp != 0 {
+		t.Errorf("Expected the frames by EtherType to be zeroed, got %d", arp)
+	}
+	info, _ := connectionInfo(sw, "other")
+	if info.FramesSent != 0 || info.BytesSent != 0 || info.StatsResetAt != resetAt {
+		t.Errorf("Expected the connections to be reset with the VLAN, got %+v", info)
+	}
+
+	// The MAC table is state, not a counter
+	if sw.lookupMAC(src) == nil {
+		t.Errorf("Expected the learned MAC to survive the reset")
+	}
+
+	// Resetting one connection leaves the others alone
+	if err := sw.processFrame(rawFrame(buildEthernet(BroadcastMAC, src, EtherTypeARP, make([]byte, 46))), conn); err != nil {
+		t.Fatalf("Failed to process frame: %v", err)
+	}
+	connResetAt, err := sw.ResetConnectionStats("guest")
+	if err != nil {
+		t.Fatalf("Failed to reset connection: %v", err)
+	}
+	if info, _ := connectionInfo(sw, "guest"); info.StatsResetAt != connResetAt {
+		t.Errorf("Expected the connection reset time to be recorded, got %+v", info)
+	}
+	if info, _ := connectionInfo(sw, "other"); info.FramesSent != 1 || info.StatsResetAt != resetAt {
+		t.Errorf("Expected the other connection to keep counting, got %+v", info)
+	}
+	if frames := sw.GetStats()["total_frames"].(uint64); frames != 1 {
+		t.Errorf("Expected the VLAN to keep counting, got %d frames", frames)
+	}
+	if _, err := sw.ResetConnectionStats("missing"); err == nil {
+		t.Errorf("Expected an error for an unknown connection")
+	}
+}
+
+func TestAPIResetStats(t *testing.T) {
+	sm := NewSwitchManager()
+	for _, port := range []int{8080, 8081} {
+		if err := sm.AddVLAN(port); err != nil {
+			t.Fatalf("Failed to add VLAN: %v", err)
+		}
+		sm.switches[port].totalFrames.Store(5)
+	}
+	handler := NewAPIHandler(sm, "secret")
+
+	if code := apiRequest(handler, http.MethodPost, "/vlans/8080/stats/reset", "", "secret"); code != http.StatusOK {
+		t.Fatalf("Expected the VLAN to be reset, got %d", code)
+	}
+	if frames := sm.GetStats()["total_frames"].(uint64); frames != 5 {
+		t.Errorf("Expected only one VLAN to be reset, got %d frames", frames)
+	}
+	if code := apiRequest(handler, http.MethodPost, "/vlans/8082/stats/reset", "", "secret"); code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown VLAN, got %d", code)
+	}
+	if code := apiRequest(handler, http.MethodPost, "/vlans/8080/connections/missing/stats/reset", "", "secret"); code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown connection, got %d", code)
+	}
+	if code := apiRequest(NewAPIHandler(sm, ""), http.MethodPost, "/stats/reset", "", ""); code != http.StatusForbidden {
+		t.Errorf("Expected resetting to require management, got %d", code)
+	}
+
+	before := sm.GetStats()["stats_reset_at"].(time.Time)
+	if code := apiRequest(handler, http.MethodPost, "/stats/reset", "", "secret"); code != http.StatusOK {
+		t.Fatalf("Expected the statistics to be reset, got %d", code)
+	}
+	stats := sm.GetStats()
+	if frames := stats["total_frames"].(uint64); frames != 0 {
+		t.Errorf("Expected all VLANs to be reset, got %d frames", frames)
+	}
+	if !stats["stats_reset_at"].(time.Time).After(before) {
+		t.Errorf("Expected the global reset time to be recorded")
+	}
+}
@@ -0,0 +1,60 @@
+package vswitch
+
+import (
+	"log/slog"
+	"os"
+	"time"
+)
+
+const (
+	// supervisedEnv is set in the environment of a supervised switch
+	supervisedEnv = "VSWITCH_SUPERVISED"
+
+	// supervisorStableAfter is how long a child has to run before a crash
+	// no longer counts towards the restart backoff
+	supervisorStableAfter = time.Minute
+
+	// supervisorKillTimeout is how long a child stuck past its watchdog
+	// timeout gets to dump its goroutines before it is killed
+	supervisorKillTimeout = 5 * time.Second
+)
+
+// Supervisor runs the switch as a child process and restarts it when it
+// crashes or, with a watchdog timeout, stops reporting that its forwarding
+// loops are alive. Restarts are delayed by an exponential backoff.
+type Supervisor struct {
+	// Args is the command line of the child
+	Args []string
+
+	// Watchdog is how long the child may go without sending "WATCHDOG=1"
+	// before it is killed and restarted (0 to disable)
+	Watchdog time.Duration
+
+	// MinBackoff and MaxBackoff bound the delay before a restart
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	// Logger receives the supervisor's log records; nil discards them
+	Logger *slog.Logger
+}
+
+// NewSupervisor creates a supervisor for a child command line, restarting
+// crashed children after one second at first and after one minute at most
+func NewSupervisor(args []string, watchdog time.Duration) *Supervisor {
+	return &Supervisor{
+		Args:       args,
+		Watchdog:   watchdog,
+		MinBackoff: time.Second,
+		MaxBackoff: time.Minute,
+	}
+}
+
+// logger returns the supervisor's logger
+func (s *Supervisor) logger() *slog.Logger {
+	return loggerOrDiscard(s.Logger)
+}
+
+// Supervised reports whether the process was started by a Supervisor
+func Supervised() bool {
+	return os.Getenv(supervisedEnv) != ""
+}
@@ -0,0 +1,215 @@
+//go:build !windows
+
+package vswitch
+
+import (
+	"errors"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// childExit describes why a supervised child ended
+type childExit int
+
+const (
+	childCrashed   childExit = iota // Exited unexpectedly, restart after the backoff
+	childRestarted                  // Restart requested, restart right away
+	childStopped                    // Exited cleanly or shut down, stop supervising
+)
+
+// Run supervises children until one exits cleanly or a SIGINT or SIGTERM
+// arrives on signals, which is passed to the child. SIGUSR2 restarts the
+// child, for example to pick up a new binary; other signals are passed on.
+// Run returns the exit code for the supervisor.
+func (s *Supervisor) Run(signals <-chan os.Signal) int {
+	dir, err := os.MkdirTemp("", "vswitch-supervisor")
+	if err != nil {
+		s.logger().Error("Failed to create notification socket", "error", err)
+		return 1
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	socketPath := filepath.Join(dir, "notify.sock")
+	notifications, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socketPath, Net: "unixgram"})
+	if err != nil {
+		s.logger().Error("Failed to create notification socket", "error", err)
+		return 1
+	}
+	defer func() { _ = notifications.Close() }()
+
+	messages := make(chan string, 16)
+	go func() {
+		buf := make([]byte, 4096)
+		for {
+			n, err := notifications.Read(buf)
+			if err != nil {
+				close(messages)
+				return
+			}
+			messages <- string(buf[:n])
+		}
+	}()
+
+	// The supervisor answers systemd's watchdog itself, so that restarts
+	// with a long backoff do not get it killed
+	var systemdPing <-chan time.Time
+	if timeout := WatchdogTimeout(); timeout > 0 {
+		ticker := time.NewTicker(timeout / 2)
+		defer ticker.Stop()
+		systemdPing = ticker.C
+	}
+
+	backoff := s.MinBackoff
+	for restarts := 0; ; restarts++ {
+		started := time.Now()
+		exit, code := s.runChild(socketPath, signals, messages, systemdPing)
+		switch exit {
+		case childStopped:
+			_ = Notify("STOPPING=1")
+			return code
+		case childRestarted:
+			backoff = s.MinBackoff
+			continue
+		}
+
+		if time.Since(started) >= supervisorStableAfter {
+			backoff = s.MinBackoff
+		}
+		s.logger().Warn("Restarting switch", "in", backoff, "restarts", restarts+1)
+
+		timer := time.NewTimer(backoff)
+		for waiting := true; waiting; {
+			select {
+			case <-timer.C:
+				waiting = false
+			case <-systemdPing:
+				_ = Notify("WATCHDOG=1")
+			case sig := <-signals:
+				if sig == syscall.SIGINT || sig == syscall.SIGTERM {
+					timer.Stop()
+					s.logger().Info("Received signal, stopping supervisor", "signal", sig.String())
+					return 0
+				}
+			}
+		}
+		backoff = min(backoff*2, s.MaxBackoff)
+	}
+}
+
+// runChild starts a child and waits for it to end
+func (s *Supervisor) runChild(socketPath string, signals <-chan os.Signal, messages <-chan string, systemdPing <-chan time.Time) (childExit, int) {
+	// #nosec G204 - the supervisor runs its own executable
+	cmd := exec.Command(s.Args[0], s.Args[1:]...)
+	cmd.Env = s.childEnv(socketPath)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		s.logger().Error("Failed to start switch", "error", err)
+		return childStopped, 1
+	}
+	s.logger().Info("Started switch", "pid", cmd.Process.Pid)
+
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+
+	var watchdog <-chan time.Time
+	if s.Watchdog > 0 {
+		ticker := time.NewTicker(s.Watchdog / 4)
+		defer ticker.Stop()
+		watchdog = ticker.C
+	}
+	lastPing := time.Now()
+
+	var killDeadline time.Time
+	stopping, restarting := false, false
+	for {
+		select {
+		case err := <-exited:
+			var exitErr *exec.ExitError
+			switch {
+			case restarting:
+				s.logger().Info("Switch stopped for restart")
+				return childRestarted, 0
+			case stopping:
+				return childStopped, 0
+			case err == nil:
+				s.logger().Info("Switch exited")
+				return childStopped, 0
+			case errors.As(err, &exitErr):
+				s.logger().Error("Switch crashed", "status", exitErr.ProcessState.String())
+			default:
+				s.logger().Error("Switch failed", "error", err)
+			}
+			return childCrashed, 1
+
+		case sig := <-signals:
+			switch sig {
+			case syscall.SIGINT, syscall.SIGTERM:
+				s.logger().Info("Received signal, stopping switch", "signal", sig.String())
+				stopping = true
+			case syscall.SIGUSR2:
+				s.logger().Info("Received signal, restarting switch", "signal", sig.String())
+				restarting = true
+				sig = syscall.SIGTERM
+			}
+			_ = cmd.Process.Signal(sig)
+
+		case message, ok := <-messages:
+			if !ok {
+				messages = nil
+				continue
+			}
+			for _, line := range strings.Split(message, "\n") {
+				switch {
+				case line == "WATCHDOG=1":
+					lastPing = time.Now()
+				case line == "READY=1" || strings.HasPrefix(line, "STATUS="):
+					_ = Notify(line)
+				}
+			}
+
+		case <-systemdPing:
+			_ = Notify("WATCHDOG=1")
+
+		case now := <-watchdog:
+			switch {
+			case !killDeadline.IsZero():
+				if now.After(killDeadline) {
+					_ = cmd.Process.Kill()
+				}
+			case now.Sub(lastPing) > s.Watchdog:
+				// SIGABRT makes the Go runtime dump all goroutines
+				s.logger().Error("Switch missed its watchdog, aborting it", "last_ping", lastPing)
+				_ = cmd.Process.Signal(syscall.SIGABRT)
+				killDeadline = now.Add(supervisorKillTimeout)
+			}
+		}
+	}
+}
+
+// childEnv returns the environment of a child, whose notifications go to
+// the supervisor instead of systemd
+func (s *Supervisor) childEnv(socketPath string) []string {
+	env := make([]string, 0, len(os.Environ())+3)
+	for _, variable := range os.Environ() {
+		name, _, _ := strings.Cut(variable, "=")
+		switch name {
+		case notifySocketEnv, watchdogUSecEnv, watchdogPIDEnv, supervisedEnv, daemonEnv:
+			continue
+		}
+		env = append(env, variable)
+	}
+
+	env = append(env, notifySocketEnv+"="+socketPath, supervisedEnv+"=1")
+	if s.Watchdog > 0 {
+		env = append(env, watchdogUSecEnv+"="+strconv.FormatInt(s.Watchdog.Microseconds(), 10))
+	}
+	return env
+}
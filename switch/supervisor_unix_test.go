@@ -0,0 +1,99 @@
+//go:build !windows
+
+package vswitch
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// countingChild returns a supervisor running a shell script that records
+// each start in a file, then runs the script body
+func countingChild(t *testing.T, body string) (*Supervisor, string) {
+	t.Helper()
+
+	runs := filepath.Join(t.TempDir(), "runs")
+	script := `echo run >> "$1"; runs=$(wc -l < "$1"); ` + body
+	supervisor := NewSupervisor([]string{"/bin/sh", "-c", script, "sh", runs}, 0)
+	supervisor.MinBackoff = 10 * time.Millisecond
+	supervisor.MaxBackoff = 20 * time.Millisecond
+	return supervisor, runs
+}
+
+// countRuns returns how often a counting child was started
+func countRuns(t *testing.T, runs string) int {
+	t.Helper()
+
+	data, err := os.ReadFile(runs) // #nosec G304 - test file
+	if err != nil {
+		t.Fatalf("Failed to read runs: %v", err)
+	}
+	return strings.Count(string(data), "run")
+}
+
+func TestSupervisorRestartsCrashedChild(t *testing.T) {
+	supervisor, runs := countingChild(t, `[ "$runs" -ge 3 ]`)
+
+	if code := supervisor.Run(make(chan os.Signal)); code != 0 {
+		t.Errorf("Expected a clean exit, got %d", code)
+	}
+	if n := countRuns(t, runs); n != 3 {
+		t.Errorf("Expected the child to be started 3 times, got %d", n)
+	}
+}
+
+func TestSupervisorWatchdog(t *testing.T) {
+	// The first child never reports to the watchdog
+	supervisor, runs := countingChild(t, `[ "$runs" -ge 2 ] || exec sleep 10`)
+	supervisor.Watchdog = 100 * time.Millisecond
+
+	if code := supervisor.Run(make(chan os.Signal)); code != 0 {
+		t.Errorf("Expected a clean exit, got %d", code)
+	}
+	if n := countRuns(t, runs); n != 2 {
+		t.Errorf("Expected the stuck child to be restarted once, got %d starts", n)
+	}
+}
+
+func TestSupervisorStopsOnSignal(t *testing.T) {
+	supervisor, runs := countingChild(t, `exec sleep 10`)
+
+	signals := make(chan os.Signal, 1)
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		signals <- syscall.SIGTERM
+	}()
+
+	done := make(chan int)
+	go func() { done <- supervisor.Run(signals) }()
+	select {
+	case code := <-done:
+		if code != 0 {
+			t.Errorf("Expected a clean exit, got %d", code)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the supervisor to stop")
+	}
+	if n := countRuns(t, runs); n != 1 {
+		t.Errorf("Expected the child not to be restarted, got %d starts", n)
+	}
+}
+
+func TestSupervisorChildEnv(t *testing.T) {
+	t.Setenv(notifySocketEnv, "/run/systemd/notify")
+	t.Setenv(watchdogPIDEnv, "1")
+
+	env := strings.Join(NewSupervisor(nil, 30*time.Second).childEnv("/tmp/notify.sock"), "\n")
+	for _, expected := range []string{notifySocketEnv + "=/tmp/notify.sock", watchdogUSecEnv + "=30000000", supervisedEnv + "=1"} {
+		if !strings.Contains(env, expected) {
+			t.Errorf("Expected %s in the child's environment", expected)
+		}
+	}
+	if strings.Contains(env, "/run/systemd/notify") || strings.Contains(env, watchdogPIDEnv+"=") {
+		t.Errorf("Expected systemd's variables to be replaced, got %s", env)
+	}
+}
@@ -0,0 +1,10 @@
+package vswitch
+
+import "os"
+
+// Run fails on Windows, where the child's notifications and signals have no
+// equivalent; use the restart options of the Windows service manager instead
+func (s *Supervisor) Run(_ <-chan os.Signal) int {
+	s.logger().Error("The supervisor is not supported on Windows")
+	return 1
+}
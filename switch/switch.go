@@ -1,11 +1,14 @@
 package vswitch
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net"
-	"strconv"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -15,58 +18,342 @@ type MACEntry struct {
 	LearnedAt  time.Time
 }
 
+// MACTableEntry is a snapshot of a learned MAC address. Static entries are
+// locked to their connection by sticky MAC; dynamic ones may move.
+type MACTableEntry struct {
+	VLAN         int       `json:"vlan"`
+	MAC          string    `json:"mac"`
+	Vendor       string    `json:"vendor,omitempty"`
+	VID          int       `json:"vid,omitempty"`
+	ConnectionID string    `json:"connection_id"`
+	Static       bool      `json:"static"`
+	LearnedAt    time.Time `json:"learned_at"`
+	AgeSeconds   int64     `json:"age_seconds"`
+}
+
 // VirtualSwitch implements a software Ethernet switch with MAC learning
 type VirtualSwitch struct {
 	// MAC learning table
-	macTable sync.Map // map[string]*MACEntry
+	macTable *macTable
+
+	// Saved or imported MAC entries awaiting their connection, kept on
+	// disk only when config.MACStateDir is set
+	macState *macState
+
+	// Sticky MAC bindings (only used when config.StickyMAC is set)
+	macBindings sync.Map // map[string]*Connection
 
 	// Active connections
 	connections sync.Map // map[string]*Connection
 
+	// Labels of the VMs connecting from registered peer addresses
+	peerLabels sync.Map // map[string]string
+
 	// Configuration
+	config     Config
 	macTimeout time.Duration
 	ports      []int
 
-	// Statistics
-	totalFrames     uint64
-	broadcastFrames uint64
-	unicastFrames   uint64
-	droppedFrames   uint64
+	// logger tags every record with the VLAN
+	logger *slog.Logger
 
-	// Control
+	// Statistics
+	totalFrames         atomic.Uint64
+	broadcastFrames     atomic.Uint64
+	unicastFrames       atomic.Uint64
+	droppedFrames       atomic.Uint64
+	spoofedFrames       atomic.Uint64
+	isolatedFrames      atomic.Uint64
+	horizonDrops        atomic.Uint64
+	partitionDrops      atomic.Uint64
+	unknownUnicastDrops atomic.Uint64
+	dhcpDrops           atomic.Uint64
+	ndDrops             atomic.Uint64
+	rejectedConns       atomic.Uint64
+	disabledDrops       atomic.Uint64
+	hookDrops           atomic.Uint64
+	validationDrops     atomic.Uint64
+	invalidFrames       [frameCheckCount]atomic.Uint64
+	bumDrops            [bumClassCount]atomic.Uint64
+	drops               [dropReasonCount]atomic.Uint64
+	etherTypeFrames     [etherTypeClassCount]atomic.Uint64
+	etherTypeBytes      [etherTypeClassCount]atomic.Uint64
+	slowConsumers       atomic.Uint64
+	macMoves            atomic.Uint64
+	forwardLatency      latencyHistogram
+	deliveryLatency     latencyHistogram
+
+	// demux switches tagged frames per VLAN ID (nil unless config.TagDemux
+	// is set)
+	demux *tagDemux
+
+	// protocolVLANs switches the frames of some protocols in other VLANs
+	// (nil unless config.ProtocolVLANs is set)
+	protocolVLANs *protocolVLANs
+
+	// fastPath forwards frames between veths in the kernel (nil unless
+	// config.FastPath is set)
+	fastPath *fastPath
+
+	// partitions blackhole traffic between groups of connections
+	partitions partitions
+
+	// shaper holds the bits the VLAN may still forward; nil for unlimited
+	shaper *rateLimiter
+
+	// impairment degrades the frames sent to the connections without one
+	// of their own; nil for none
+	impairment atomic.Pointer[Impairment]
+	frameSizes sizeHistogram
+	rxRates    rateMeter
+
+	// statsMutex makes a reset of the statistics atomic to their readers;
+	// statsResetAt is when they were last reset, or else created
+	statsMutex   sync.RWMutex
+	statsResetAt time.Time
+
+	// Protocol breakdown of sampled frames (nil unless config.ProtocolStats is set)
+	protocolStats *protocolStats
+
+	// Frame hooks run on reception and before sending
+	ingressHooks hookChain
+	egressHooks  hookChain
+
+	// Switch-hosted network services
+	services      []service
+	proxyARP      *proxyARP
+	arpSuppressor *arpSuppressor
+	lldp          *lldpService
+	nat           *natEngine
+	openflow      *openflowAgent
+	sflow         *sflowAgent
+
+	// Forwarding worker queues (empty when readers forward their frames)
+	workers []chan frameJob
+
+	// Liveness of the accept loops, listenersPerPort per port, and of the
+	// workers; accepting counts the running accept loops of each port and
+	// listening the ports with any
+	listenerBeats []heartbeat
+	workerBeats   []heartbeat
+	accepting     []atomic.Int32
+	listening     atomic.Int32
+
+	// startFailure holds why Start failed, if it did, as a string
+	startFailure atomic.Value
+
+	// Ports that failed to bind and are being retried
+	bindRetries bindRetries
+
+	// connSeq numbers the accepted connections whose remote address does
+	// not tell them apart
+	connSeq atomic.Uint64
+
+	// Live capture streams
+	capture captureHub
+
+	// Event streams of the manager the VLAN belongs to, if any
+	events *eventHub
+
+	// VLANs of the manager the VLAN belongs to by port, if any
+	siblings *sync.Map
+
+	// DHCP snooping state (nil unless config.DHCPSnooping is set)
+	dhcpSnooper *dhcpSnooper
+
+	// Addresses seen on the VLAN (nil unless config.IPInventory is set)
+	ipInventory *ipInventory
+
+	// IPv6 first-hop security (nil unless RA guard or ND inspection is set)
+	ndGuard *ndGuard
+
+	// Identified clients that disconnected, by client ID, kept for the
+	// MAC timeout in case they return
+	departed      map[string]departedClient
+	departedMutex sync.Mutex
+
+	// disabled is set while the VLAN is administratively shut down
+	disabled atomic.Bool
+
+	// Control; drain is closed when Shutdown starts draining
 	shutdown chan bool
+	drain    chan struct{}
+	draining atomic.Bool
+	stopOnce sync.Once
 	wg       sync.WaitGroup
 }
 
-// NewVirtualSwitch creates a new virtual switch instance
+// NewVirtualSwitch creates a new virtual switch instance with the default configuration
 func NewVirtualSwitch(ports []int) *VirtualSwitch {
-	return &VirtualSwitch{
+	return NewVirtualSwitchWithConfig(ports, DefaultConfig())
+}
+
+// NewVirtualSwitchWithConfig creates a new virtual switch instance with the given configuration
+func NewVirtualSwitchWithConfig(ports []int, config Config) *VirtualSwitch {
+	vs := &VirtualSwitch{
 		ports:      ports,
-		macTimeout: 300 * time.Second, // 5 minutes default MAC timeout
+		config:     config,
+		macTable:   newMACTable(config.MACTableSize),
+		macTimeout: DefaultMACTimeout,
 		shutdown:   make(chan bool),
+		drain:      make(chan struct{}),
+	}
+	vs.statsResetAt = time.Now()
+	if config.MACTimeout > 0 {
+		vs.macTimeout = config.MACTimeout
+	}
+	vs.listenerBeats = make([]heartbeat, len(ports)*vs.listenersPerPort())
+	vs.accepting = make([]atomic.Int32, len(ports))
+	vs.logger = config.logger().With("vlan", vs.port())
+	vs.macTable.evicted = vs.evictedMAC
+	vs.macState = newMACState(config.MACStateDir, vs.port())
+	if config.DHCPSnooping {
+		vs.dhcpSnooper = newDHCPSnooper(vs.logger)
+	}
+	if config.IPInventory {
+		vs.ipInventory = newIPInventory(vs)
+	}
+	if config.ProtocolStats > 0 {
+		vs.protocolStats = newProtocolStats(config.ProtocolStats)
+	}
+	if config.RAGuard || config.NDInspection {
+		vs.ndGuard = newNDGuard(config.RAGuard, config.NDInspection, vs.macTimeout)
+	}
+	vs.shaper = newShaper(config.Bandwidth, config.maxFrameSize())
+	if !config.Impairment.IsZero() {
+		impairment := config.Impairment
+		vs.impairment.Store(&impairment)
+	}
+	vs.ingressHooks.add(config.IngressHooks...)
+	vs.egressHooks.add(config.EgressHooks...)
+	vs.services = vs.buildServices()
+	if config.TagDemux {
+		vs.demux = newTagDemux(vs)
+	}
+	if len(config.ProtocolVLANs) > 0 {
+		vs.protocolVLANs = newProtocolVLANs(vs)
+	}
+	if config.FastPath {
+		vs.fastPath = newFastPath(vs.logger)
+	}
+	return vs
+}
+
+// port returns the primary port of the switch, used to identify its VLAN
+func (vs *VirtualSwitch) port() int {
+	if len(vs.ports) == 0 {
+		return 0
 	}
+	return vs.ports[0]
 }
 
-// Start starts the virtual switch on all configured ports
-func (vs *VirtualSwitch) Start() error {
-	log.Printf("Starting virtual switch on ports: %v", vs.ports)
+// Start starts the virtual switch on all configured ports and stops it
+// when ctx is done. Listening sockets are open when it returns; if one of
+// them cannot be opened, it returns the error and leaves nothing running.
+// Failures after it returned go to the configured ErrorHandler.
+func (vs *VirtualSwitch) Start(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	vs.logger.Info("Starting virtual switch", "ports", vs.ports)
+
+	// In-memory VLANs take their connections from Dial and Attach only
+	listenPorts := vs.ports
+	if vs.config.InMemory {
+		listenPorts = nil
+	}
+	// Ports that fail to bind are retried in the background if configured
+	start := time.Now()
+	listeners := make([][]net.Listener, len(listenPorts))
+	var retries []int
+	for i, port := range listenPorts {
+		opened, err := vs.listen(port)
+		if err != nil {
+			err = fmt.Errorf("failed to listen on port %d: %w", port, err)
+			if vs.config.BindRetry > 0 {
+				vs.logger.Warn("Failed to listen, retrying", "port", port, "error", err, "for", vs.config.BindRetry)
+				vs.bindRetries.failed(err, start.Add(bindRetryMin))
+				retries = append(retries, i)
+				continue
+			}
+			closeListeners(listeners...)
+			vs.logger.Error("Failed to start", "error", err)
+			vs.startFailure.Store(err.Error())
+			return err
+		}
+		vs.logger.Info("Listening", "port", port, "addr", opened[0].Addr().String(), "listeners", len(opened))
+		listeners[i] = opened
+	}
+
+	var pipe net.Listener
+	if vs.config.Pipe != "" && len(listenPorts) > 0 {
+		var err error
+		if pipe, err = listenPipe(vs.config.Pipe); err != nil {
+			closeListeners(listeners...)
+			err = fmt.Errorf("failed to listen on pipe %s: %w", vs.config.Pipe, err)
+			vs.logger.Error("Failed to start", "error", err)
+			vs.startFailure.Store(err.Error())
+			return err
+		}
+		vs.logger.Info("Listening", "pipe", pipe.Addr().String())
+	}
+
+	vs.loadMACState()
+	vs.loadCounters()
 
-	for _, port := range vs.ports {
+	// Workers must exist before the first connection is accepted
+	vs.startWorkers()
+
+	for i, opened := range listeners {
+		if opened != nil {
+			vs.serve(i, opened)
+		}
+	}
+	if pipe != nil {
+		vs.servePipe(pipe)
+	}
+	for _, i := range retries {
+		vs.bindRetries.pending.Add(1)
 		vs.wg.Add(1)
-		go vs.listenOnPort(port)
+		go vs.retryListen(i, start)
 	}
 
 	// Start MAC table cleanup routine
 	vs.wg.Add(1)
 	go vs.macTableCleanup()
 
+	vs.wg.Add(1)
+	go vs.sampleRates()
+
+	if vs.config.CountersDir != "" {
+		vs.wg.Add(1)
+		go vs.checkpointCounters()
+	}
+
+	vs.startServices()
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			vs.Stop()
+		case <-vs.shutdown:
+		}
+	}()
+
 	return nil
 }
 
 // Stop stops the virtual switch and closes all connections
 func (vs *VirtualSwitch) Stop() {
-	log.Printf("Stopping virtual switch")
+	vs.stopOnce.Do(vs.stop)
+}
 
+// stop shuts the switch down; Stop makes sure it only runs once
+func (vs *VirtualSwitch) stop() {
+	vs.logger.Info("Stopping virtual switch")
+
+	// Save the MAC table before closing connections removes its entries
+	vs.saveMACState()
 	close(vs.shutdown)
 
 	// Close all connections
@@ -78,21 +365,101 @@ func (vs *VirtualSwitch) Stop() {
 	})
 
 	vs.wg.Wait()
-	log.Printf("Virtual switch stopped")
+	if vs.demux != nil {
+		vs.demux.stop()
+	}
+	if vs.fastPath != nil {
+		vs.fastPath.close()
+	}
+
+	// Nothing counts frames any more
+	vs.saveCounters()
+	vs.logger.Info("Virtual switch stopped")
 }
 
-// listenOnPort starts a listener on the specified port
-func (vs *VirtualSwitch) listenOnPort(port int) {
-	defer vs.wg.Done()
+// serve accepts connections on the listeners of the i-th port of the
+// switch, each in its own accept loop
+func (vs *VirtualSwitch) serve(i int, listeners []net.Listener) {
+	if count := int32(len(listeners)); vs.accepting[i].Add(count) == count {
+		vs.listening.Add(1)
+	}
+	for j, listener := range listeners {
+		alive := &vs.listenerBeats[i*vs.listenersPerPort()+j]
+		alive.beat()
+		vs.wg.Add(1)
+		go vs.listenOnPort(listener, i, alive)
+	}
+}
 
-	listener, err := net.Listen("tcp", ":"+strconv.Itoa(port))
-	if err != nil {
-		log.Printf("Failed to listen on port %d: %v", port, err)
+// servePipe accepts connections on the named pipe of the switch, which
+// counts as a listener of its primary port. Accept on a pipe cannot time
+// out, so the loop has no heartbeat.
+func (vs *VirtualSwitch) servePipe(listener net.Listener) {
+	if vs.accepting[0].Add(1) == 1 {
+		vs.listening.Add(1)
+	}
+	vs.wg.Add(1)
+	go vs.listenOnPort(listener, 0, new(heartbeat))
+}
+
+// closeListeners closes the listeners opened for each port
+func closeListeners(listeners ...[]net.Listener) {
+	for _, opened := range listeners {
+		for _, listener := range opened {
+			_ = listener.Close()
+		}
+	}
+}
+
+// fail reports a failure of the running switch to the configured error
+// handler
+func (vs *VirtualSwitch) fail(err error) {
+	select {
+	case <-vs.shutdown:
 		return
+	default:
 	}
-	defer func() { _ = listener.Close() }()
 
-	log.Printf("Listening on port %d", port)
+	vs.logger.Error("VLAN failed", "error", err)
+	if vs.config.ErrorHandler != nil {
+		vs.config.ErrorHandler(vs.port(), err)
+	}
+}
+
+// deadlineListener is a listener whose Accept can time out, as TCP and
+// unix listeners
+type deadlineListener interface {
+	SetDeadline(t time.Time) error
+}
+
+// listenOnPort accepts connections on a listener of the i-th port, beating
+// its heartbeat at least once per accept timeout. The port stops listening
+// when the last of its accept loops returns.
+func (vs *VirtualSwitch) listenOnPort(listener net.Listener, i int, alive *heartbeat) {
+	defer vs.wg.Done()
+	defer func() { _ = listener.Close() }()
+	defer func() {
+		alive.stop()
+		if vs.accepting[i].Add(-1) == 0 {
+			vs.listening.Add(-1)
+		}
+	}()
+	port := vs.ports[i]
+
+	// Accept on other listeners blocks until they are closed at shutdown,
+	// so their loop has no heartbeat. Draining closes every listener to
+	// stop accepting at once.
+	timed, canTimeout := listener.(deadlineListener)
+	if !canTimeout {
+		alive.stop()
+	}
+	go func() {
+		select {
+		case <-vs.shutdown:
+		case <-vs.drain:
+		}
+		_ = listener.Close()
+	}()
 
 	for {
 		select {
@@ -102,8 +469,9 @@ func (vs *VirtualSwitch) listenOnPort(port int) {
 		}
 
 		// Set accept timeout to allow periodic shutdown checks
-		if tcpListener, ok := listener.(*net.TCPListener); ok {
-			_ = tcpListener.SetDeadline(time.Now().Add(1 * time.Second))
+		if canTimeout {
+			alive.beat()
+			_ = timed.SetDeadline(time.Now().Add(1 * time.Second))
 		}
 
 		conn, err := listener.Accept()
@@ -112,30 +480,238 @@ func (vs *VirtualSwitch) listenOnPort(port int) {
 			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
 				continue
 			}
-			log.Printf("Failed to accept connection on port %d: %v", port, err)
+			// The listener was handed over to an upgraded process, closed
+			// to drain the switch, or closed under it
+			if errors.Is(err, net.ErrClosed) {
+				if !sockets.handedOver() && !vs.draining.Load() {
+					vs.fail(fmt.Errorf("listener on port %d closed", port))
+				}
+				return
+			}
+			vs.logger.Warn("Failed to accept connection", "port", port, "error", err)
+			continue
+		}
+
+		if !vs.admit(conn, port) {
+			_ = conn.Close()
 			continue
 		}
+		vs.tuneConn(conn)
+
+		// Clients of a VLAN with a PSK prove they hold it first, without
+		// holding up the accept loop
+		if vs.config.PSK != "" {
+			vs.wg.Add(1)
+			go vs.authenticate(vs.connectionID(conn, port), conn, port)
+			continue
+		}
+		vs.attach(vs.connectionID(conn, port), conn, nil)
+	}
+}
 
-		// Generate connection ID
-		connID := fmt.Sprintf("%s-%d", conn.RemoteAddr().String(), port)
-		connection := NewConnection(connID, conn)
+// connectionID names a connection accepted on a port after its remote
+// address, or after a sequence number when the address does not tell its
+// connections apart, as with unix sockets
+func (vs *VirtualSwitch) connectionID(conn net.Conn, port int) string {
+	if addr := conn.RemoteAddr(); addrIP(addr) != nil {
+		return fmt.Sprintf("%s-%d", addr.String(), port)
+	}
+	return fmt.Sprintf("%s-%d-%d", conn.LocalAddr().Network(), port, vs.connSeq.Add(1))
+}
 
-		// Store the connection
-		vs.connections.Store(connID, connection)
-		log.Printf("New connection: %s", connection.String())
+// admit decides whether a connection accepted on a port may join the VLAN,
+// counting and logging the connections it refuses
+func (vs *VirtualSwitch) admit(conn net.Conn, port int) bool {
+	if vs.disabled.Load() {
+		vs.rejectedConns.Add(1)
+		vs.logger.Info("Rejected connection to disabled VLAN", "port", port, "remote", conn.RemoteAddr().String())
+		vs.events.publish(Event{Type: EventConnectionRejected, VLAN: port, RemoteAddr: conn.RemoteAddr().String(), Reason: "VLAN disabled"})
+		return false
+	}
 
-		// Handle the connection
-		vs.wg.Add(1)
-		go vs.handleConnection(connection)
+	if !vs.clientAllowed(conn.RemoteAddr()) {
+		vs.rejectedConns.Add(1)
+		vs.logger.Warn("Rejected connection", "port", port, "remote", conn.RemoteAddr().String())
+		vs.events.publish(Event{Type: EventConnectionRejected, VLAN: port, RemoteAddr: conn.RemoteAddr().String(), Reason: "client not allowed"})
+		return false
+	}
+
+	return true
+}
+
+// Attach connects a stream carrying frames in QEMU's stream framing, such
+// as a TAP device behind an adapter, to the VLAN as a new connection
+func (vs *VirtualSwitch) Attach(id string, conn net.Conn) {
+	vs.attach(id, conn, nil)
+}
+
+// Dial connects an in-process client to the VLAN and returns its end of
+// the stream, which carries frames in QEMU's stream framing. Together with
+// InMemory it runs a VLAN without any socket.
+func (vs *VirtualSwitch) Dial(id string) net.Conn {
+	client, server := net.Pipe()
+	vs.Attach(id, server)
+	return client
+}
+
+// attach adds a connection to the VLAN and starts handling its frames.
+// setup, if set, changes the port settings of the connection before its
+// first frame.
+func (vs *VirtualSwitch) attach(id string, conn net.Conn, setup func(*Connection)) {
+	connection := NewConnection(id, conn)
+	vs.applyPortDefaults(connection)
+	if setup != nil {
+		setup(connection)
+	}
+	vs.attachConnection(connection)
+
+	vs.wg.Add(1)
+	go vs.handleConnection(connection)
+}
+
+// attachConnection adds a new connection to the VLAN; its frames are then
+// read by handleConnection
+func (vs *VirtualSwitch) attachConnection(connection *Connection) {
+	connection.deliveryLatency = &vs.deliveryLatency
+	connection.vlanImpairment = &vs.impairment
+	if vs.config.EgressQueueSize > 0 {
+		connection.StartWriter(vs.config.EgressQueueSize, vs.config.SlowConsumerPolicy, vs.config.SlowConsumerTimeout)
+	}
+
+	// Store the connection
+	vs.connections.Store(connection.ID, connection)
+	if label := vs.peerLabel(connection); label != "" {
+		connection.SetLabel(label)
+		vs.logger.Info("New connection", "connection", connection.ID, "remote", connection.RemoteAddr(), "label", label)
+	} else {
+		vs.logger.Info("New connection", "connection", connection.ID, "remote", connection.RemoteAddr())
+	}
+	vs.connectionEvent(EventConnectionAccepted, connection, "")
+	vs.restoreMACs(connection)
+	if vs.openflow != nil {
+		vs.openflow.addPort(connection)
+	}
+	if vs.demux != nil {
+		vs.demux.attach(connection)
+	}
+	if vs.protocolVLANs != nil {
+		vs.protocolVLANs.attach(connection)
 	}
 }
 
+// clientAllowed reports whether a remote address may connect to the VLAN
+func (vs *VirtualSwitch) clientAllowed(addr net.Addr) bool {
+	if matchesPeer(addr, vs.config.DeniedClients) {
+		return false
+	}
+	return len(vs.config.AllowedClients) == 0 || matchesPeer(addr, vs.config.AllowedClients)
+}
+
+// applyPortDefaults sets the initial port settings of a new connection
+func (vs *VirtualSwitch) applyPortDefaults(conn *Connection) {
+	// Frames beyond the MTU are dropped or counted by checkFrame instead of
+	// ending the connection
+	conn.maxFrame = jumboFrameSize
+	conn.padFrames = vs.config.PadFrames
+	conn.bumLimiters = newBUMLimiters(vs.config.TrunkBUMLimits)
+	conn.logger = vs.logger
+	conn.SetHairpin(vs.config.Hairpin)
+	conn.SetTrusted(matchesPeer(conn.Conn.RemoteAddr(), vs.config.TrustedPeers))
+	conn.SetHorizon(vs.config.horizonGroup(conn.Conn.RemoteAddr()))
+
+	if !vs.config.PrivateVLAN || matchesPeer(conn.Conn.RemoteAddr(), vs.config.PromiscuousPeers) {
+		conn.SetPortMode(PortPromiscuous, "")
+		return
+	}
+	conn.SetPortMode(PortIsolated, "")
+}
+
+// SetPortMode changes the private VLAN mode of an active connection
+func (vs *VirtualSwitch) SetPortMode(connID string, mode PortMode, community string) error {
+	value, found := vs.connections.Load(connID)
+	if !found {
+		return fmt.Errorf("connection %s not found", connID)
+	}
+
+	value.(*Connection).SetPortMode(mode, community)
+	vs.logger.Info("Connection port mode changed", "connection", connID, "mode", mode.String(), "community", community)
+	vs.syncFastPath()
+	return nil
+}
+
+// SetHairpin enables or disables reflective relay on an active connection
+func (vs *VirtualSwitch) SetHairpin(connID string, enabled bool) error {
+	value, found := vs.connections.Load(connID)
+	if !found {
+		return fmt.Errorf("connection %s not found", connID)
+	}
+
+	value.(*Connection).SetHairpin(enabled)
+	vs.logger.Info("Connection hairpin changed", "connection", connID, "hairpin", enabled)
+	return nil
+}
+
+// SetTrusted marks an active connection as trusted or untrusted for DHCP snooping
+func (vs *VirtualSwitch) SetTrusted(connID string, trusted bool) error {
+	value, found := vs.connections.Load(connID)
+	if !found {
+		return fmt.Errorf("connection %s not found", connID)
+	}
+
+	value.(*Connection).SetTrusted(trusted)
+	vs.logger.Info("Connection trust changed", "connection", connID, "trusted", trusted)
+	return nil
+}
+
+// SetDisabled administratively shuts the VLAN down or brings it back up. A
+// disabled VLAN refuses new connections and drops every frame, but keeps
+// its connections, MAC table and other state.
+func (vs *VirtualSwitch) SetDisabled(disabled bool) {
+	if vs.disabled.Swap(disabled) != disabled {
+		vs.logger.Info("VLAN admin state changed", "disabled", disabled)
+		vs.syncFastPath()
+	}
+}
+
+// SetConnectionDisabled administratively disables or re-enables an active
+// connection without closing it
+func (vs *VirtualSwitch) SetConnectionDisabled(connID string, disabled bool) error {
+	value, found := vs.connections.Load(connID)
+	if !found {
+		return fmt.Errorf("connection %s not found", connID)
+	}
+
+	value.(*Connection).SetDisabled(disabled)
+	vs.logger.Info("Connection admin state changed", "connection", connID, "disabled", disabled)
+	vs.syncFastPath()
+	return nil
+}
+
+// Kick forcibly disconnects an active connection, e.g. of a hung VM, and
+// flushes its MAC entries right away so frames to them are flooded instead
+// of going to the closed socket. The rest of its state is cleaned up when
+// its reader notices the closed socket. It returns the number of MAC
+// entries flushed.
+func (vs *VirtualSwitch) Kick(connID string) (int, error) {
+	value, found := vs.connections.Load(connID)
+	if !found {
+		return 0, fmt.Errorf("connection %s not found", connID)
+	}
+	conn := value.(*Connection)
+
+	vs.logger.Info("Kicking connection", "connection", connID)
+	if err := conn.Close(); err != nil {
+		vs.logger.Debug("Failed to close kicked connection", "connection", connID, "error", err)
+	}
+	return vs.flushMACs(conn), nil
+}
+
 // handleConnection handles a single VM connection
 func (vs *VirtualSwitch) handleConnection(conn *Connection) {
 	defer vs.wg.Done()
 	defer vs.cleanupConnection(conn)
 
-	log.Printf("Handling connection: %s", conn.ID)
+	vs.logger.Debug("Handling connection", "connection", conn.ID)
 
 	frameChan := make(chan *EthernetFrame, 100)
 	errorChan := make(chan error, 10)
@@ -144,8 +720,13 @@ func (vs *VirtualSwitch) handleConnection(conn *Connection) {
 	go func() {
 		defer close(frameChan)
 		defer close(errorChan)
+		first := true
 		for {
 			frame, err := conn.ReadFrame()
+			if errors.Is(err, errMalformedFrame) {
+				vs.countDrop(dropParseError)
+				continue
+			}
 			if err != nil {
 				select {
 				case errorChan <- err:
@@ -153,6 +734,15 @@ func (vs *VirtualSwitch) handleConnection(conn *Connection) {
 				}
 				return
 			}
+			// The first frame may be a hello presenting the client's identity
+			if first {
+				first = false
+				if hello, ok := parseHello(frame); ok {
+					vs.greet(conn, hello)
+					frame.Release()
+					continue
+				}
+			}
 			select {
 			case frameChan <- frame:
 			case <-vs.shutdown:
@@ -161,6 +751,7 @@ func (vs *VirtualSwitch) handleConnection(conn *Connection) {
 		}
 	}()
 
+	worker := vs.workerFor(conn)
 	for {
 		select {
 		case <-vs.shutdown:
@@ -169,17 +760,23 @@ func (vs *VirtualSwitch) handleConnection(conn *Connection) {
 			if !ok {
 				return // channel closed
 			}
-			// Process the frame
-			if err := vs.processFrame(frame, conn); err != nil {
-				log.Printf("Error processing frame from %s: %v", conn.ID, err)
-				vs.droppedFrames++
+			if worker == nil {
+				vs.handleFrame(frame, conn)
+				frame.Release()
+				continue
+			}
+			// Hand the frame to the connection's worker
+			select {
+			case worker <- frameJob{frame: frame, conn: conn}:
+			case <-vs.shutdown:
+				frame.Release()
+				return
 			}
-			frame.Release()
 		case err, ok := <-errorChan:
 			if !ok {
 				return // channel closed
 			}
-			log.Printf("Connection %s read error: %v", conn.ID, err)
+			vs.logger.Info("Connection read failed", "connection", conn.ID, "error", err)
 			return
 		}
 	}
@@ -187,62 +784,209 @@ func (vs *VirtualSwitch) handleConnection(conn *Connection) {
 
 // processFrame processes an incoming Ethernet frame
 func (vs *VirtualSwitch) processFrame(frame *EthernetFrame, sourceConn *Connection) error {
-	vs.totalFrames++
+	// Administratively disabled ports neither receive nor forward
+	if vs.disabled.Load() || sourceConn.Disabled() {
+		vs.disabledDrops.Add(1)
+		return dropped(dropDisabled, errPortDisabled)
+	}
+	// Nor does a draining switch, so that the egress queues empty
+	if vs.draining.Load() {
+		return dropped(dropDraining, errDraining)
+	}
+
+	vs.totalFrames.Add(1)
+	vs.countEtherType(frame)
+	vs.frameSizes.observe(frame)
+	if vs.protocolStats != nil {
+		vs.protocolStats.observe(frame)
+	}
+	vs.capture.tap(frame.Raw, frame.timestamp())
+
+	// Drop malformed frames as the VLAN's validation mode requires
+	if err := vs.checkFrame(frame); err != nil {
+		return err
+	}
+
+	// Let hooks filter, rewrite or take over the frame
+	switch vs.ingressHooks.run(frame, sourceConn) {
+	case VerdictDrop:
+		vs.hookDrops.Add(1)
+		return dropped(dropHook, fmt.Errorf("frame dropped by ingress hook"))
+	case VerdictConsume:
+		return nil
+	}
+
+	// Tagged frames are switched in the broadcast domain of their VLAN ID
+	if vs.demux != nil {
+		return vs.demux.ingress(frame, sourceConn)
+	}
+
+	// Frames of protocols assigned to other VLANs are switched there
+	if vs.protocolVLANs != nil {
+		if diverted, err := vs.protocolVLANs.ingress(frame, sourceConn); diverted {
+			return err
+		}
+	}
+
+	// Refuse frames whose source MAC is bound to another connection
+	if !vs.checkMACBinding(frame.SrcMAC, sourceConn) {
+		vs.spoofedFrames.Add(1)
+		vs.violationEvent(EventLimitViolation, sourceConn, "sticky MAC")
+		return dropped(dropACLDeny, fmt.Errorf("source MAC %s is bound to another connection", frame.SrcMAC))
+	}
 
-	// Learn the source MAC address
+	// Refuse DHCP server traffic from untrusted connections
+	if vs.dhcpSnooper != nil && !vs.dhcpSnooper.inspect(frame, sourceConn, vs.lookupMAC) {
+		vs.dhcpDrops.Add(1)
+		vs.violationEvent(EventLimitViolation, sourceConn, "DHCP snooping")
+		return dropped(dropACLDeny, fmt.Errorf("DHCP server message from untrusted connection"))
+	}
+
+	// Refuse rogue router advertisements and spoofed neighbor discovery
+	if vs.ndGuard != nil && !vs.ndGuard.inspect(frame, sourceConn) {
+		vs.ndDrops.Add(1)
+		vs.violationEvent(EventLimitViolation, sourceConn, "ND inspection")
+		return dropped(dropACLDeny, fmt.Errorf("IPv6 neighbor discovery message refused"))
+	}
+
+	// Learn the source MAC address, and the IP address it sends from
 	vs.learnMAC(frame.SrcMAC, sourceConn)
+	if vs.ipInventory != nil {
+		vs.ipInventory.observe(frame, sourceConn)
+	}
+
+	// Let switch-hosted services inspect and possibly consume the frame
+	for _, svc := range vs.services {
+		if svc.handleFrame(frame, sourceConn) {
+			return nil
+		}
+	}
+
+	// Keep the VLAN within its bandwidth
+	if !vs.shape(frame) {
+		return dropped(dropBandwidth, errBandwidthExceeded)
+	}
 
 	// Forward the frame based on destination MAC
 	if frame.IsBroadcast() || frame.IsMulticast() {
-		vs.broadcastFrames++
+		vs.broadcastFrames.Add(1)
 		return vs.floodFrame(frame, sourceConn)
 	}
-	vs.unicastFrames++
+	vs.unicastFrames.Add(1)
 	return vs.forwardFrame(frame, sourceConn)
 }
 
 // learnMAC learns or updates a MAC address in the learning table
 func (vs *VirtualSwitch) learnMAC(mac net.HardwareAddr, conn *Connection) {
-	macStr := mac.String()
+	previous := vs.macTable.learn(mac, conn, time.Now())
+	if previous == nil {
+		vs.logger.Debug("Learned MAC", "mac", mac.String(), "connection", conn.ID)
+		vs.macEvent(MACLearned, mac, conn, nil)
+	} else if previous.ID != conn.ID {
+		vs.moveMAC(mac, conn, previous)
+	}
+}
 
-	if entryInterface, found := vs.macTable.Load(macStr); found {
-		existingEntry := entryInterface.(*MACEntry)
-		if existingEntry.Connection.ID == conn.ID {
-			existingEntry.LearnedAt = time.Now()
-			return
-		}
-		log.Printf("MAC %s moved from connection %s to %s", macStr, existingEntry.Connection.ID, conn.ID)
-	} else {
-		log.Printf("Learned MAC %s on connection %s", macStr, conn.ID)
+// evictedMAC reports a MAC evicted from the full MAC table
+func (vs *VirtualSwitch) evictedMAC(key macKey, entry MACEntry) {
+	vs.logger.Debug("Evicted MAC", "mac", key.String(), "connection", entry.Connection.ID)
+	vs.macEvent(MACEvicted, net.HardwareAddr(key[:]), entry.Connection, nil)
+}
+
+// lookupMAC returns the connection a MAC address was learned on, or nil
+func (vs *VirtualSwitch) lookupMAC(mac net.HardwareAddr) *Connection {
+	return vs.macTable.lookup(mac)
+}
+
+// checkMACBinding enforces sticky MAC bindings, binding the MAC to the
+// connection on first sight. It returns false if the MAC belongs to another connection.
+func (vs *VirtualSwitch) checkMACBinding(mac net.HardwareAddr, conn *Connection) bool {
+	if !vs.config.StickyMAC {
+		return true
+	}
+
+	owner, loaded := vs.macBindings.LoadOrStore(mac.String(), conn)
+	if !loaded {
+		vs.logger.Debug("Bound MAC", "mac", mac.String(), "connection", conn.ID)
+		return true
 	}
 
-	entry := &MACEntry{
-		Connection: conn,
-		LearnedAt:  time.Now(),
+	return owner.(*Connection).ID == conn.ID
+}
+
+// GetMACBindings returns the sticky MAC bindings as a map of MAC to connection ID
+func (vs *VirtualSwitch) GetMACBindings() map[string]string {
+	bindings := make(map[string]string)
+
+	vs.macBindings.Range(func(key, value interface{}) bool {
+		bindings[key.(string)] = value.(*Connection).ID
+		return true
+	})
+
+	return bindings
+}
+
+// ClearMACBinding removes the sticky binding for a MAC address, allowing
+// it to be claimed again. It returns false if the MAC was not bound.
+func (vs *VirtualSwitch) ClearMACBinding(mac net.HardwareAddr) bool {
+	if _, found := vs.macBindings.LoadAndDelete(mac.String()); !found {
+		return false
 	}
-	vs.macTable.Store(macStr, entry)
+
+	vs.logger.Info("Cleared MAC binding", "mac", mac.String())
+	return true
+}
+
+// ClearMACBindings removes all sticky MAC bindings and returns how many were removed
+func (vs *VirtualSwitch) ClearMACBindings() int {
+	removed := 0
+
+	vs.macBindings.Range(func(key, _ interface{}) bool {
+		vs.macBindings.Delete(key)
+		removed++
+		return true
+	})
+
+	if removed > 0 {
+		vs.logger.Info("Cleared MAC bindings", "count", removed)
+	}
+
+	return removed
 }
 
 // forwardFrame forwards a unicast frame to the destination
 func (vs *VirtualSwitch) forwardFrame(frame *EthernetFrame, sourceConn *Connection) error {
-	destMAC := frame.DestMAC.String()
-
 	// Look up destination in MAC table
-	if entryInterface, found := vs.macTable.Load(destMAC); found {
-		entry := entryInterface.(*MACEntry)
-
-		// Don't forward back to source
-		if entry.Connection.ID == sourceConn.ID {
+	if destConn := vs.macTable.lookup(frame.DestMAC); destConn != nil {
+		// Don't forward back to source unless it is in hairpin mode
+		if destConn.ID == sourceConn.ID {
+			if !sourceConn.Hairpin() {
+				return nil
+			}
+		} else if sourceConn.sameHorizon(destConn) {
+			// Enforce split horizon
+			vs.horizonDrops.Add(1)
+			return nil
+		} else if !sourceConn.canReach(destConn) {
+			// Enforce private VLAN isolation
+			vs.isolatedFrames.Add(1)
+			return nil
+		} else if vs.partitioned(sourceConn, destConn) {
+			// Simulate a network split
 			return nil
 		}
 
 		// Forward to specific destination
-		if !entry.Connection.IsClosed() {
-			if err := entry.Connection.WriteFrame(frame); err != nil {
-				log.Printf("Failed to forward frame to %s: %v", entry.Connection.ID, err)
-				return err
+		if !destConn.IsClosed() && vs.egress(frame, destConn) {
+			if err := destConn.SendFrame(frame); err != nil {
+				vs.logger.Debug("Failed to forward frame", "connection", destConn.ID, "error", err)
+				return dropped(sendDropReason(err), err)
 			}
 		}
+	} else if vs.config.DropUnknownUnicast {
+		// Unknown destination - keep it from leaking to every connection
+		vs.unknownUnicastDrops.Add(1)
+		return dropped(dropUnknownUnicast, fmt.Errorf("unknown destination %s", frame.DestMAC))
 	} else {
 		// Unknown destination - flood the frame
 		return vs.floodFrame(frame, sourceConn)
@@ -254,22 +998,48 @@ func (vs *VirtualSwitch) forwardFrame(frame *EthernetFrame, sourceConn *Connecti
 // floodFrame floods a frame to all connections except the source
 func (vs *VirtualSwitch) floodFrame(frame *EthernetFrame, sourceConn *Connection) error {
 	var errors []error
+	class := bumClassOf(frame)
+	now := time.Now()
 
 	vs.connections.Range(func(_, value interface{}) bool {
 		conn := value.(*Connection)
 
-		// Don't flood back to source
+		// Skip closed connections
+		if conn.IsClosed() {
+			return true
+		}
+
 		if conn.ID == sourceConn.ID {
+			// Don't flood back to source unless it is in hairpin mode
+			if !conn.Hairpin() {
+				return true
+			}
+		} else if sourceConn.sameHorizon(conn) {
+			// Skip connections in the source's horizon group
+			return true
+		} else if !sourceConn.canReach(conn) {
+			// Skip connections private VLAN rules keep apart
+			return true
+		} else if vs.partitioned(sourceConn, conn) {
+			// Skip connections on the other side of a partition
 			return true
 		}
 
-		// Skip closed connections
-		if conn.IsClosed() {
+		if !vs.egress(frame, conn) {
 			return true
 		}
 
-		if err := conn.WriteFrame(frame); err != nil {
-			log.Printf("Failed to flood frame to %s: %v", conn.ID, err)
+		// Keep storms off the links to other switches
+		if !conn.allowFlood(class, now) {
+			vs.bumDrops[class].Add(1)
+			vs.countDrop(dropStormControl)
+			vs.violationEvent(EventStormControl, conn, bumClassNames[class])
+			return true
+		}
+
+		if err := conn.SendFrame(frame); err != nil {
+			vs.logger.Debug("Failed to flood frame", "connection", conn.ID, "error", err)
+			vs.countDrop(sendDropReason(err))
 			errors = append(errors, err)
 		}
 
@@ -277,7 +1047,7 @@ func (vs *VirtualSwitch) floodFrame(frame *EthernetFrame, sourceConn *Connection
 	})
 
 	if len(errors) > 0 {
-		log.Printf("Flooding completed with %d errors", len(errors))
+		vs.logger.Debug("Flooding completed with errors", "errors", len(errors))
 	}
 
 	return nil
@@ -285,17 +1055,62 @@ func (vs *VirtualSwitch) floodFrame(frame *EthernetFrame, sourceConn *Connection
 
 // cleanupConnection cleans up resources when a connection is closed
 func (vs *VirtualSwitch) cleanupConnection(conn *Connection) {
-	log.Printf("Cleaning up connection: %s", conn.ID)
+	vs.logger.Debug("Cleaning up connection", "connection", conn.ID)
 
 	// Remove connection from active connections
 	vs.connections.Delete(conn.ID)
+	reason := ""
+	if conn.SlowConsumer() {
+		vs.slowConsumers.Add(1)
+		vs.logger.Warn("Disconnected slow consumer", "connection", conn.ID, "queue_drops", conn.Info().QueueDrops)
+		reason = "slow consumer"
+	}
+	vs.connectionEvent(EventConnectionClosed, conn, reason)
+
+	// Clean MAC entries for this connection, remembering them for an
+	// identified client's return
+	vs.departClient(conn)
+	vs.flushMACs(conn)
+
+	// Forget DHCP leases snooped on this connection
+	if vs.dhcpSnooper != nil {
+		vs.dhcpSnooper.removeConnection(conn.ID)
+	}
+
+	// Forget the addresses last seen on this connection
+	if vs.ipInventory != nil {
+		vs.ipInventory.removeConnection(conn)
+	}
+
+	// Release IPv6 addresses claimed by this connection
+	if vs.ndGuard != nil {
+		vs.ndGuard.removeConnection(conn.ID)
+	}
+
+	// Forget the connection's sFlow data source
+	if vs.sflow != nil {
+		vs.sflow.removeConnection(conn.ID)
+	}
+
+	// Withdraw the connection's OpenFlow port
+	if vs.openflow != nil {
+		vs.openflow.removePort(conn)
+	}
+
+	// Leave the broadcast domains of the VLAN IDs
+	if vs.demux != nil {
+		vs.demux.detach(conn)
+	}
+
+	// Leave the VLANs its protocols were switched in
+	if vs.protocolVLANs != nil {
+		vs.protocolVLANs.detach(conn)
+	}
 
-	// Clean MAC entries for this connection
-	vs.macTable.Range(func(key, value interface{}) bool {
-		entry := value.(*MACEntry)
-		if entry.Connection.ID == conn.ID {
-			vs.macTable.Delete(key)
-			log.Printf("Removed MAC entry %s for connection %s", key.(string), conn.ID)
+	// Release sticky bindings held by this connection
+	vs.macBindings.Range(func(key, value interface{}) bool {
+		if value.(*Connection).ID == conn.ID {
+			vs.macBindings.Delete(key)
 		}
 		return true
 	})
@@ -304,7 +1119,25 @@ func (vs *VirtualSwitch) cleanupConnection(conn *Connection) {
 	_ = conn.Close()
 }
 
-// macTableCleanup periodically cleans up stale MAC entries
+// flushMACs removes the MAC entries learned on a connection and returns
+// how many were removed
+func (vs *VirtualSwitch) flushMACs(conn *Connection) int {
+	var flushed []removedMAC
+	count := vs.macTable.deleteIf(func(key macKey, entry MACEntry) bool {
+		if entry.Connection.ID != conn.ID {
+			return false
+		}
+		vs.logger.Debug("Removed MAC entry", "mac", key.String(), "connection", conn.ID)
+		flushed = append(flushed, removedMAC{net.HardwareAddr(key[:]), entry.Connection, MACFlushed})
+		return true
+	})
+
+	vs.reportRemovedMACs(flushed)
+	return count
+}
+
+// macTableCleanup periodically cleans up stale MAC entries and saves the
+// MAC table
 func (vs *VirtualSwitch) macTableCleanup() {
 	defer vs.wg.Done()
 
@@ -317,6 +1150,7 @@ func (vs *VirtualSwitch) macTableCleanup() {
 			return
 		case <-ticker.C:
 			vs.cleanupStaleMACs()
+			vs.saveMACState()
 		}
 	}
 }
@@ -324,46 +1158,200 @@ func (vs *VirtualSwitch) macTableCleanup() {
 // cleanupStaleMACs removes stale MAC entries from the learning table
 func (vs *VirtualSwitch) cleanupStaleMACs() {
 	now := time.Now()
-	removed := 0
-
-	vs.macTable.Range(func(key, value interface{}) bool {
-		entry := value.(*MACEntry)
 
-		// Remove entries that are too old or have closed connections
-		if now.Sub(entry.LearnedAt) > vs.macTimeout || entry.Connection.IsClosed() {
-			vs.macTable.Delete(key)
-			removed++
+	// Remove entries that are too old or have closed connections
+	var stale []removedMAC
+	removed := vs.macTable.deleteIf(func(key macKey, entry MACEntry) bool {
+		kind := MACAged
+		if entry.Connection.IsClosed() {
+			kind = MACFlushed
+		} else if now.Sub(entry.LearnedAt) <= vs.macTimeout {
+			return false
 		}
-
+		stale = append(stale, removedMAC{net.HardwareAddr(key[:]), entry.Connection, kind})
 		return true
 	})
+	vs.reportRemovedMACs(stale)
 
 	if removed > 0 {
-		log.Printf("Cleaned up %d stale MAC entries", removed)
+		vs.logger.Debug("Cleaned up stale MAC entries", "count", removed)
+	}
+
+	if vs.ndGuard != nil {
+		vs.ndGuard.expire(now)
+	}
+
+	vs.expireDeparted(now)
+
+	if vs.ipInventory != nil {
+		if expired := vs.ipInventory.expire(now, vs.macTimeout); expired > 0 {
+			vs.logger.Debug("Expired IP inventory entries", "count", expired)
+		}
+	}
+
+	if vs.dhcpSnooper != nil {
+		if expired := vs.dhcpSnooper.expire(now); expired > 0 {
+			vs.logger.Info("Expired DHCP snooping bindings", "count", expired)
+		}
 	}
 }
 
-// GetStats returns current switch statistics
-func (vs *VirtualSwitch) GetStats() map[string]interface{} {
-	connectionCount := 0
-	macCount := 0
+// GetDHCPBindings returns the leases recorded by DHCP snooping
+func (vs *VirtualSwitch) GetDHCPBindings() []DHCPBinding {
+	if vs.dhcpSnooper == nil {
+		return nil
+	}
+	return vs.dhcpSnooper.list()
+}
 
-	vs.connections.Range(func(_, _ interface{}) bool {
-		connectionCount++
+// GetConnections returns a snapshot of the open connections sorted by ID
+func (vs *VirtualSwitch) GetConnections() []ConnectionInfo {
+	vs.statsMutex.RLock()
+	defer vs.statsMutex.RUnlock()
+
+	connections := make([]ConnectionInfo, 0)
+
+	vs.connections.Range(func(_, value interface{}) bool {
+		connections = append(connections, value.(*Connection).Info())
 		return true
 	})
 
-	vs.macTable.Range(func(_, _ interface{}) bool {
-		macCount++
+	sort.Slice(connections, func(i, j int) bool {
+		return connections[i].ID < connections[j].ID
+	})
+
+	return connections
+}
+
+// GetMACTable returns a snapshot of the learned MAC addresses sorted by MAC
+func (vs *VirtualSwitch) GetMACTable() []MACTableEntry {
+	entries := make([]MACTableEntry, 0)
+	now := time.Now()
+
+	vs.macTable.rangeEntries(func(key macKey, entry MACEntry) {
+		entries = append(entries, MACTableEntry{
+			VLAN:         vs.port(),
+			MAC:          key.String(),
+			Vendor:       LookupVendor(key[:]),
+			ConnectionID: entry.Connection.ID,
+			LearnedAt:    entry.LearnedAt,
+			AgeSeconds:   int64(now.Sub(entry.LearnedAt) / time.Second),
+		})
+	})
+	if vs.demux != nil {
+		entries = append(entries, vs.tagMACTable()...)
+	}
+
+	// Sticky bindings are looked up after visiting the table, whose shard
+	// locks are held during the visit
+	for i := range entries {
+		if owner, bound := vs.macBindings.Load(entries[i].MAC); bound {
+			entries[i].Static = owner.(*Connection).ID == entries[i].ConnectionID
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].MAC != entries[j].MAC {
+			return entries[i].MAC < entries[j].MAC
+		}
+		return entries[i].VID < entries[j].VID
+	})
+
+	return entries
+}
+
+// connectionCount returns the number of open connections
+func (vs *VirtualSwitch) connectionCount() int {
+	count := 0
+	vs.connections.Range(func(_, _ interface{}) bool {
+		count++
 		return true
 	})
+	return count
+}
+
+// GetStats returns current switch statistics
+func (vs *VirtualSwitch) GetStats() map[string]interface{} {
+	vs.statsMutex.RLock()
+	defer vs.statsMutex.RUnlock()
+
+	connectionCount := vs.connectionCount()
+	macCount := vs.macTable.len()
 
-	return map[string]interface{}{
-		"total_frames":     vs.totalFrames,
-		"broadcast_frames": vs.broadcastFrames,
-		"unicast_frames":   vs.unicastFrames,
-		"dropped_frames":   vs.droppedFrames,
-		"connections":      connectionCount,
-		"mac_entries":      macCount,
+	proxyARPReplies := uint64(0)
+	if vs.proxyARP != nil {
+		proxyARPReplies = vs.proxyARP.replies.Load()
+	}
+	arpSuppressed := uint64(0)
+	if vs.arpSuppressor != nil {
+		arpSuppressed = vs.arpSuppressor.suppressed.Load()
+	}
+
+	natFlows := 0
+	if vs.nat != nil {
+		natFlows = vs.nat.flows()
+	}
+
+	etherTypeFrames, etherTypeBytes := vs.etherTypeCounts()
+	rxRates := vs.rxRates.get()
+
+	stats := map[string]interface{}{
+		"ports":                 vs.ports,
+		"total_frames":          vs.totalFrames.Load(),
+		"rx_bits_per_second":    rxRates.BitsPerSecond,
+		"rx_frames_per_second":  rxRates.FramesPerSecond,
+		"broadcast_frames":      vs.broadcastFrames.Load(),
+		"unicast_frames":        vs.unicastFrames.Load(),
+		"dropped_frames":        vs.droppedFrames.Load(),
+		"drop_reasons":          vs.dropCounts(),
+		"ethertype_frames":      etherTypeFrames,
+		"ethertype_bytes":       etherTypeBytes,
+		"spoofed_frames":        vs.spoofedFrames.Load(),
+		"isolated_frames":       vs.isolatedFrames.Load(),
+		"horizon_drops":         vs.horizonDrops.Load(),
+		"partition_drops":       vs.partitionDrops.Load(),
+		"unknown_unicast_drops": vs.unknownUnicastDrops.Load(),
+		"dhcp_drops":            vs.dhcpDrops.Load(),
+		"nd_drops":              vs.ndDrops.Load(),
+		"rejected_connections":  vs.rejectedConns.Load(),
+		"disabled":              vs.disabled.Load(),
+		"draining":              vs.draining.Load(),
+		"disabled_drops":        vs.disabledDrops.Load(),
+		"hook_drops":            vs.hookDrops.Load(),
+		"validation_mode":       vs.config.Validation.String(),
+		"validation_drops":      vs.validationDrops.Load(),
+		"invalid_frames":        vs.invalidFrameCounts(),
+		"bum_drops":             vs.bumDropCounts(),
+		"slow_consumer_policy":  vs.config.SlowConsumerPolicy.String(),
+		"slow_consumers":        vs.slowConsumers.Load(),
+		"proxy_arp_replies":     proxyARPReplies,
+		"arp_suppressed":        arpSuppressed,
+		"nat_flows":             natFlows,
+		"connections":           connectionCount,
+		"mac_entries":           macCount,
+		"mac_moves":             vs.macMoves.Load(),
+		"mac_evictions":         vs.macTable.evictions.Load(),
+		"forwarding_latency":    vs.forwardLatency.snapshot(),
+		"delivery_latency":      vs.deliveryLatency.snapshot(),
+		"impairment":            vs.Impairment(),
+		"bandwidth_limit":       vs.config.Bandwidth.String(),
+		"frame_sizes":           vs.frameSizes.snapshot(),
+		"stats_reset_at":        vs.statsResetAt,
+	}
+	if vs.protocolStats != nil {
+		stats["protocol_stats"] = vs.protocolStats.snapshot()
+	}
+	if vs.demux != nil {
+		stats["tag_domains"] = len(vs.demux.domainList())
+		stats["vid_drops"] = vs.demux.dropped.Load()
+	}
+	if vs.protocolVLANs != nil {
+		stats["protocol_vlan_frames"] = vs.protocolVLANs.diverted.Load()
+		stats["protocol_vlan_drops"] = vs.protocolVLANs.dropped.Load()
+	}
+	if vs.fastPath != nil {
+		stats["fast_path_frames"], stats["fast_path_entries"] = vs.fastPath.stats()
 	}
+	vs.bindRetries.addStats(stats)
+	return stats
 }
@@ -1,7 +1,11 @@
 package vswitch
 
 import (
+	"bytes"
+	"context"
+	"log/slog"
 	"net"
+	"strings"
 	"testing"
 	"time"
 )
@@ -164,6 +168,33 @@ func TestProcessFrame(t *testing.T) {
 	}
 }
 
+func TestDataPathQuietAtInfo(t *testing.T) {
+	sw := NewVirtualSwitch([]int{8080})
+	var buf bytes.Buffer
+	sw.logger = slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelInfo}))
+
+	conn1 := NewConnection("conn1", &mockConnSwitch{addr: &mockAddrSwitch{network: "tcp", address: "127.0.0.1:9001"}})
+	conn2 := NewConnection("conn2", &mockConnSwitch{addr: &mockAddrSwitch{network: "tcp", address: "127.0.0.1:9002"}})
+	sw.connections.Store("conn1", conn1)
+	sw.connections.Store("conn2", conn2)
+
+	mac1 := net.HardwareAddr{0x52, 0x54, 0x00, 0x00, 0x00, 0x01}
+	mac2 := net.HardwareAddr{0x52, 0x54, 0x00, 0x00, 0x00, 0x02}
+	_ = sw.processFrame(rawFrame(buildEthernet(BroadcastMAC, mac1, EtherTypeARP, make([]byte, 46))), conn1)
+	_ = sw.processFrame(rawFrame(buildEthernet(mac1, mac2, EtherTypeARP, make([]byte, 46))), conn2)
+	_ = sw.processFrame(rawFrame(buildEthernet(mac2, mac1, EtherTypeIPv4, make([]byte, 46))), conn1)
+
+	if buf.Len() != 0 {
+		t.Errorf("Expected no log output when forwarding at info level, got %q", buf.String())
+	}
+
+	// A MAC moving between connections is worth reporting
+	_ = sw.processFrame(rawFrame(buildEthernet(BroadcastMAC, mac1, EtherTypeARP, make([]byte, 46))), conn2)
+	if !strings.Contains(buf.String(), "MAC moved") || !strings.Contains(buf.String(), "connection=conn2") {
+		t.Errorf("Expected MAC move to be logged, got %q", buf.String())
+	}
+}
+
 func TestLearnMAC(t *testing.T) {
 	ports := []int{8080}
 	sw := NewVirtualSwitch(ports)
@@ -179,10 +210,9 @@ func TestLearnMAC(t *testing.T) {
 	sw.learnMAC(srcMAC, conn)
 
 	// Check that MAC was learned
-	if entry, exists := sw.macTable.Load(srcMAC.String()); !exists {
+	if macEntry, exists := sw.macTable.get(srcMAC); !exists {
 		t.Errorf("Expected MAC %s to be learned", srcMAC.String())
 	} else {
-		macEntry := entry.(*MACEntry)
 		if macEntry.Connection != conn {
 			t.Errorf("Expected MAC entry to point to correct connection")
 		}
@@ -285,6 +315,43 @@ func TestFloodFrame(t *testing.T) {
 	}
 }
 
+func TestDropUnknownUnicast(t *testing.T) {
+	sw := NewVirtualSwitchWithConfig([]int{8080}, Config{DropUnknownUnicast: true})
+
+	mockConn1 := &mockConnSwitch{addr: &mockAddrSwitch{network: "tcp", address: "127.0.0.1:9001"}}
+	mockConn2 := &mockConnSwitch{addr: &mockAddrSwitch{network: "tcp", address: "127.0.0.1:9002"}}
+	conn1 := NewConnection("conn1", mockConn1)
+	conn2 := NewConnection("conn2", mockConn2)
+	sw.connections.Store("conn1", conn1)
+	sw.connections.Store("conn2", conn2)
+
+	src := net.HardwareAddr{0x52, 0x54, 0x00, 0x00, 0x00, 0x01}
+	dst := net.HardwareAddr{0x52, 0x54, 0x00, 0x00, 0x00, 0x02}
+
+	// Unicast to an unlearned destination is dropped and counted
+	if err := sw.processFrame(rawFrame(buildEthernet(dst, src, EtherTypeIPv4, make([]byte, 46))), conn1); err == nil {
+		t.Error("Expected unicast to an unknown destination to be dropped")
+	}
+	if len(mockConn2.writeData) != 0 {
+		t.Error("Expected unicast to an unknown destination not to be flooded")
+	}
+	if drops := sw.GetStats()["unknown_unicast_drops"].(uint64); drops != 1 {
+		t.Errorf("Expected 1 unknown unicast drop, got %d", drops)
+	}
+
+	// Broadcasts still flood, and teach the switch where their sender is
+	_ = sw.processFrame(rawFrame(buildEthernet(BroadcastMAC, dst, EtherTypeARP, make([]byte, 46))), conn2)
+	if len(mockConn1.writeData) == 0 {
+		t.Error("Expected broadcasts to be flooded")
+	}
+	if err := sw.processFrame(rawFrame(buildEthernet(dst, src, EtherTypeIPv4, make([]byte, 46))), conn1); err != nil {
+		t.Errorf("Expected unicast to a learned destination to be forwarded: %v", err)
+	}
+	if len(mockConn2.writeData) == 0 {
+		t.Error("Expected unicast to reach the learned destination")
+	}
+}
+
 func TestCleanupConnection(t *testing.T) {
 	ports := []int{8080}
 	sw := NewVirtualSwitch(ports)
@@ -310,7 +377,7 @@ func TestCleanupConnection(t *testing.T) {
 	}
 
 	// Check that MAC entry was removed
-	if _, exists := sw.macTable.Load(srcMAC.String()); exists {
+	if _, exists := sw.macTable.get(srcMAC); exists {
 		t.Errorf("Expected MAC entry to be removed from MAC table")
 	}
 }
@@ -329,16 +396,380 @@ func TestCleanupStaleMACs(t *testing.T) {
 	sw.learnMAC(srcMAC, conn)
 
 	// Manually set MAC entry to be old (more than MAC aging time)
-	if entry, exists := sw.macTable.Load(srcMAC.String()); exists {
-		macEntry := entry.(*MACEntry)
+	if macEntry, exists := sw.macTable.get(srcMAC); exists {
 		macEntry.LearnedAt = time.Now().Add(-10 * time.Minute) // Old entry
+		sw.macTable.store(srcMAC, macEntry)
 	}
 
 	// Cleanup stale MACs
 	sw.cleanupStaleMACs()
 
 	// Check that MAC entry was removed
-	if _, exists := sw.macTable.Load(srcMAC.String()); exists {
+	if _, exists := sw.macTable.get(srcMAC); exists {
 		t.Errorf("Expected stale MAC entry to be removed")
 	}
 }
+
+func TestStickyMAC(t *testing.T) {
+	sw := NewVirtualSwitchWithConfig([]int{8080}, Config{StickyMAC: true})
+
+	mockConn1 := &mockConnSwitch{
+		addr: &mockAddrSwitch{network: "tcp", address: "127.0.0.1:9001"},
+	}
+	mockConn2 := &mockConnSwitch{
+		addr: &mockAddrSwitch{network: "tcp", address: "127.0.0.1:9002"},
+	}
+
+	conn1 := NewConnection("conn1", mockConn1)
+	conn2 := NewConnection("conn2", mockConn2)
+	sw.connections.Store("conn1", conn1)
+	sw.connections.Store("conn2", conn2)
+
+	srcMAC := net.HardwareAddr{0x02, 0x02, 0x03, 0x04, 0x05, 0x06}
+	frame := &EthernetFrame{
+		DestMAC:   BroadcastMAC,
+		SrcMAC:    srcMAC,
+		EtherType: 0x0800,
+		Raw:       make([]byte, 64),
+	}
+
+	// First sender binds the MAC
+	if err := sw.processFrame(frame, conn1); err != nil {
+		t.Fatalf("Unexpected error from binding connection: %v", err)
+	}
+
+	// Another connection claiming the same MAC is refused
+	if err := sw.processFrame(frame, conn2); err == nil {
+		t.Errorf("Expected spoofed frame to be rejected")
+	}
+
+	if entry, ok := sw.macTable.get(srcMAC); !ok || entry.Connection != conn1 {
+		t.Errorf("Expected MAC to remain learned on conn1")
+	}
+
+	stats := sw.GetStats()
+	if stats["spoofed_frames"] != uint64(1) {
+		t.Errorf("Expected 1 spoofed frame, got %v", stats["spoofed_frames"])
+	}
+
+	bindings := sw.GetMACBindings()
+	if bindings[srcMAC.String()] != "conn1" {
+		t.Errorf("Expected MAC bound to conn1, got %v", bindings)
+	}
+
+	// Clearing the binding lets another connection claim the MAC
+	if !sw.ClearMACBinding(srcMAC) {
+		t.Errorf("Expected binding to be cleared")
+	}
+
+	if err := sw.processFrame(frame, conn2); err != nil {
+		t.Errorf("Unexpected error after clearing binding: %v", err)
+	}
+
+	if sw.ClearMACBindings() != 1 {
+		t.Errorf("Expected 1 binding to be cleared")
+	}
+}
+
+func TestStickyMACReleasedOnCleanup(t *testing.T) {
+	sw := NewVirtualSwitchWithConfig([]int{8080}, Config{StickyMAC: true})
+
+	mockConn := &mockConnSwitch{
+		addr: &mockAddrSwitch{network: "tcp", address: "127.0.0.1:9001"},
+	}
+	conn := NewConnection("conn1", mockConn)
+	sw.connections.Store("conn1", conn)
+
+	srcMAC := net.HardwareAddr{0x02, 0x02, 0x03, 0x04, 0x05, 0x06}
+	sw.checkMACBinding(srcMAC, conn)
+
+	sw.cleanupConnection(conn)
+
+	if len(sw.GetMACBindings()) != 0 {
+		t.Errorf("Expected bindings to be released when the connection closes")
+	}
+}
+
+func TestPrivateVLAN(t *testing.T) {
+	sw := NewVirtualSwitch([]int{8080})
+
+	mockGateway := &mockConnSwitch{addr: &mockAddrSwitch{network: "tcp", address: "127.0.0.1:9001"}}
+	mockGuest1 := &mockConnSwitch{addr: &mockAddrSwitch{network: "tcp", address: "127.0.0.1:9002"}}
+	mockGuest2 := &mockConnSwitch{addr: &mockAddrSwitch{network: "tcp", address: "127.0.0.1:9003"}}
+
+	gateway := NewConnection("gateway", mockGateway)
+	guest1 := NewConnection("guest1", mockGuest1)
+	guest2 := NewConnection("guest2", mockGuest2)
+	guest1.SetPortMode(PortIsolated, "")
+	guest2.SetPortMode(PortIsolated, "")
+
+	sw.connections.Store("gateway", gateway)
+	sw.connections.Store("guest1", guest1)
+	sw.connections.Store("guest2", guest2)
+
+	broadcast := &EthernetFrame{
+		DestMAC:   BroadcastMAC,
+		SrcMAC:    net.HardwareAddr{0x02, 0x00, 0x00, 0x00, 0x00, 0x01},
+		EtherType: 0x0806,
+		Raw:       make([]byte, 64),
+	}
+
+	// Isolated guest broadcasts reach only the promiscuous gateway
+	_ = sw.processFrame(broadcast, guest1)
+	if len(mockGateway.writeData) == 0 {
+		t.Errorf("Expected broadcast from isolated port to reach promiscuous port")
+	}
+	if len(mockGuest2.writeData) != 0 {
+		t.Errorf("Expected broadcast from isolated port not to reach another isolated port")
+	}
+
+	// Unicast between isolated guests is dropped
+	sw.learnMAC(net.HardwareAddr{0x02, 0x00, 0x00, 0x00, 0x00, 0x02}, guest2)
+	unicast := &EthernetFrame{
+		DestMAC:   net.HardwareAddr{0x02, 0x00, 0x00, 0x00, 0x00, 0x02},
+		SrcMAC:    net.HardwareAddr{0x02, 0x00, 0x00, 0x00, 0x00, 0x01},
+		EtherType: 0x0800,
+		Raw:       make([]byte, 64),
+	}
+	_ = sw.processFrame(unicast, guest1)
+	if len(mockGuest2.writeData) != 0 {
+		t.Errorf("Expected unicast between isolated ports to be dropped")
+	}
+
+	stats := sw.GetStats()
+	if stats["isolated_frames"] != uint64(1) {
+		t.Errorf("Expected 1 isolated frame, got %v", stats["isolated_frames"])
+	}
+
+	// Ports in the same community can talk to each other
+	if err := sw.SetPortMode("guest1", PortCommunity, "web"); err != nil {
+		t.Fatalf("Unexpected error setting port mode: %v", err)
+	}
+	if err := sw.SetPortMode("guest2", PortCommunity, "web"); err != nil {
+		t.Fatalf("Unexpected error setting port mode: %v", err)
+	}
+	_ = sw.processFrame(unicast, guest1)
+	if len(mockGuest2.writeData) == 0 {
+		t.Errorf("Expected unicast within a community to be forwarded")
+	}
+
+	if err := sw.SetPortMode("missing", PortIsolated, ""); err == nil {
+		t.Errorf("Expected error setting mode on a missing connection")
+	}
+}
+
+func TestApplyPortDefaults(t *testing.T) {
+	peers, _ := ParseCIDRList("127.0.0.2")
+	sw := NewVirtualSwitchWithConfig([]int{8080}, Config{PrivateVLAN: true, PromiscuousPeers: peers})
+
+	uplink := NewConnection("uplink", &mockConnSwitch{addr: &mockAddrSwitch{network: "tcp", address: "127.0.0.2:9001"}})
+	guest := NewConnection("guest", &mockConnSwitch{addr: &mockAddrSwitch{network: "tcp", address: "127.0.0.1:9002"}})
+
+	sw.applyPortDefaults(uplink)
+	sw.applyPortDefaults(guest)
+
+	if mode, _ := uplink.PortMode(); mode != PortPromiscuous {
+		t.Errorf("Expected matching peer to be promiscuous, got %s", mode)
+	}
+
+	if mode, _ := guest.PortMode(); mode != PortIsolated {
+		t.Errorf("Expected other peers to be isolated, got %s", mode)
+	}
+}
+
+func TestHairpin(t *testing.T) {
+	sw := NewVirtualSwitch([]int{8080})
+
+	mockConn1 := &mockConnSwitch{addr: &mockAddrSwitch{network: "tcp", address: "127.0.0.1:9001"}}
+	mockConn2 := &mockConnSwitch{addr: &mockAddrSwitch{network: "tcp", address: "127.0.0.1:9002"}}
+	conn1 := NewConnection("conn1", mockConn1)
+	conn2 := NewConnection("conn2", mockConn2)
+	sw.connections.Store("conn1", conn1)
+	sw.connections.Store("conn2", conn2)
+
+	// Two guests behind a nested bridge share conn1
+	guestA := net.HardwareAddr{0x02, 0x00, 0x00, 0x00, 0x00, 0x0a}
+	guestB := net.HardwareAddr{0x02, 0x00, 0x00, 0x00, 0x00, 0x0b}
+	sw.learnMAC(guestB, conn1)
+
+	unicast := &EthernetFrame{
+		DestMAC:   guestB,
+		SrcMAC:    guestA,
+		EtherType: 0x0800,
+		Raw:       make([]byte, 64),
+	}
+
+	_ = sw.processFrame(unicast, conn1)
+	if len(mockConn1.writeData) != 0 {
+		t.Errorf("Expected no reflection without hairpin mode")
+	}
+
+	if err := sw.SetHairpin("conn1", true); err != nil {
+		t.Fatalf("Unexpected error enabling hairpin: %v", err)
+	}
+
+	_ = sw.processFrame(unicast, conn1)
+	if len(mockConn1.writeData) == 0 {
+		t.Errorf("Expected unicast to be reflected in hairpin mode")
+	}
+	if len(mockConn2.writeData) != 0 {
+		t.Errorf("Expected reflected unicast not to reach other connections")
+	}
+
+	// Broadcasts are flooded back to the hairpin connection as well
+	mockConn1.writeData = nil
+	broadcast := &EthernetFrame{
+		DestMAC:   BroadcastMAC,
+		SrcMAC:    guestA,
+		EtherType: 0x0806,
+		Raw:       make([]byte, 64),
+	}
+	_ = sw.processFrame(broadcast, conn1)
+	if len(mockConn1.writeData) == 0 || len(mockConn2.writeData) == 0 {
+		t.Errorf("Expected broadcast to reach both connections in hairpin mode")
+	}
+
+	if err := sw.SetHairpin("missing", true); err == nil {
+		t.Errorf("Expected error setting hairpin on a missing connection")
+	}
+}
+
+func TestAdminDisable(t *testing.T) {
+	sw := NewVirtualSwitch([]int{8080})
+
+	mockConn1 := &mockConnSwitch{addr: &mockAddrSwitch{network: "tcp", address: "127.0.0.1:9001"}}
+	mockConn2 := &mockConnSwitch{addr: &mockAddrSwitch{network: "tcp", address: "127.0.0.1:9002"}}
+	conn1 := NewConnection("conn1", mockConn1)
+	conn2 := NewConnection("conn2", mockConn2)
+	sw.connections.Store("conn1", conn1)
+	sw.connections.Store("conn2", conn2)
+
+	broadcast := &EthernetFrame{
+		DestMAC:   BroadcastMAC,
+		SrcMAC:    net.HardwareAddr{0x02, 0x00, 0x00, 0x00, 0x00, 0x01},
+		EtherType: 0x0806,
+		Raw:       make([]byte, 64),
+	}
+
+	// A disabled connection neither sends nor receives
+	if err := sw.SetConnectionDisabled("conn1", true); err != nil {
+		t.Fatalf("Unexpected error disabling connection: %v", err)
+	}
+	if err := sw.processFrame(broadcast, conn1); err == nil {
+		t.Errorf("Expected frames from a disabled connection to be dropped")
+	}
+	_ = sw.processFrame(broadcast, conn2)
+	if len(mockConn1.writeData) != 0 || len(mockConn2.writeData) != 0 {
+		t.Errorf("Expected no frames to or from the disabled connection")
+	}
+	if !conn1.Info().Disabled || conn1.IsClosed() {
+		t.Errorf("Expected the disabled connection to stay open")
+	}
+
+	if err := sw.SetConnectionDisabled("conn1", false); err != nil {
+		t.Fatalf("Unexpected error enabling connection: %v", err)
+	}
+	_ = sw.processFrame(broadcast, conn2)
+	if len(mockConn1.writeData) == 0 {
+		t.Errorf("Expected the re-enabled connection to receive frames")
+	}
+
+	// A disabled VLAN drops everything but keeps its state
+	sw.SetDisabled(true)
+	mockConn1.writeData = nil
+	if err := sw.processFrame(broadcast, conn2); err == nil {
+		t.Errorf("Expected frames on a disabled VLAN to be dropped")
+	}
+	if len(mockConn1.writeData) != 0 {
+		t.Errorf("Expected a disabled VLAN not to forward")
+	}
+	stats := sw.GetStats()
+	if stats["disabled"] != true || stats["disabled_drops"] != uint64(2) || stats["connections"] != 2 {
+		t.Errorf("Unexpected stats of a disabled VLAN: %v", stats)
+	}
+
+	sw.SetDisabled(false)
+	_ = sw.processFrame(broadcast, conn2)
+	if len(mockConn1.writeData) == 0 {
+		t.Errorf("Expected the re-enabled VLAN to forward")
+	}
+
+	if err := sw.SetConnectionDisabled("missing", true); err == nil {
+		t.Errorf("Expected error disabling a missing connection")
+	}
+}
+
+func TestClientAllowed(t *testing.T) {
+	allowed, _ := ParseCIDRList("10.0.0.0/8,127.0.0.1")
+	denied, _ := ParseCIDRList("10.0.0.5")
+	sw := NewVirtualSwitchWithConfig([]int{8080}, Config{AllowedClients: allowed, DeniedClients: denied})
+
+	tests := map[string]bool{
+		"127.0.0.1:9001": true,
+		"10.1.2.3:9001":  true,
+		"10.0.0.5:9001":  false,
+		"192.0.2.1:9001": false,
+	}
+	for addr, expected := range tests {
+		if got := sw.clientAllowed(&mockAddrSwitch{network: "tcp", address: addr}); got != expected {
+			t.Errorf("clientAllowed(%s) = %v, expected %v", addr, got, expected)
+		}
+	}
+
+	open := NewVirtualSwitchWithConfig([]int{8080}, Config{DeniedClients: denied})
+	if !open.clientAllowed(&mockAddrSwitch{network: "tcp", address: "192.0.2.1:9001"}) {
+		t.Errorf("Expected clients to be allowed without an allowlist")
+	}
+}
+
+func TestRejectedConnection(t *testing.T) {
+	probe, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to find a free port: %v", err)
+	}
+	port := probe.Addr().(*net.TCPAddr).Port
+	_ = probe.Close()
+
+	denied, _ := ParseCIDRList("127.0.0.0/8")
+	sw := NewVirtualSwitchWithConfig([]int{port}, Config{DeniedClients: denied})
+	if err := sw.Start(context.Background()); err != nil {
+		t.Fatalf("Failed to start switch: %v", err)
+	}
+	defer sw.Stop()
+
+	conn, err := net.Dial("tcp", probe.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	// The switch closes rejected connections right away
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Errorf("Expected the connection to be closed")
+	}
+	if stats := sw.GetStats(); stats["rejected_connections"] != uint64(1) {
+		t.Errorf("Expected 1 rejected connection, got %v", stats["rejected_connections"])
+	}
+	if stats := sw.GetStats(); stats["connections"] != 0 {
+		t.Errorf("Expected no connections, got %v", stats["connections"])
+	}
+}
+
+func TestSlowConsumerCounted(t *testing.T) {
+	sw := NewVirtualSwitchWithConfig([]int{8080}, Config{SlowConsumerPolicy: SlowConsumerDisconnect})
+	conn := NewConnection("slow", &mockConnSwitch{addr: &mockAddrSwitch{network: "tcp", address: "127.0.0.1:9001"}})
+	sw.connections.Store(conn.ID, conn)
+
+	conn.slowConsumer.Store(true)
+	_ = conn.Close()
+	sw.cleanupConnection(conn)
+
+	stats := sw.GetStats()
+	if stats["slow_consumers"] != uint64(1) {
+		t.Errorf("Expected 1 slow consumer, got %v", stats["slow_consumers"])
+	}
+	if stats["slow_consumer_policy"] != "disconnect" {
+		t.Errorf("Expected disconnect policy, got %v", stats["slow_consumer_policy"])
+	}
+}
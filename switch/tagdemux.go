@@ -0,0 +1,343 @@
+package vswitch
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// maxVID is the largest 802.1Q VLAN ID; 4095 is reserved
+const maxVID = 4094
+
+// tagDomainQueueSize is the depth of the queue of frames waiting to enter a
+// broadcast domain from each connection; frames beyond it are dropped
+const tagDomainQueueSize = 256
+
+// errVIDNotAllowed is the error of frames whose VLAN ID the VLAN does not
+// carry
+var errVIDNotAllowed = errors.New("VLAN ID not allowed")
+
+// TagDomain is the broadcast domain of a VLAN ID on a VLAN demultiplexing
+// tagged frames
+type TagDomain struct {
+	VID         int    `json:"vid"`
+	Native      bool   `json:"native,omitempty"`
+	Connections int    `json:"connections"`
+	MACs        int    `json:"macs"`
+	Frames      uint64 `json:"frames"`
+}
+
+// tagDemux switches the tagged frames of a VLAN in TagDemux mode in a
+// broadcast domain per VLAN ID. Each domain is an in-memory switch of its
+// own, created with the first frame of its VLAN ID, to which every
+// connection of the VLAN is attached through a pipe: frames arriving with
+// the domain's VLAN ID enter it untagged, and the frames it sends leave
+// tagged, or untagged for the native VLAN ID.
+type tagDemux struct {
+	vs      *VirtualSwitch
+	allowed map[int]bool // nil allows every VLAN ID
+	native  int
+
+	mutex   sync.Mutex
+	domains map[int]*VirtualSwitch
+	ends    map[*Connection]map[int]*Connection
+	stopped bool
+
+	// dropped counts the frames of VLAN IDs the VLAN does not carry
+	dropped atomic.Uint64
+}
+
+// newTagDemux returns the demultiplexer of a VLAN in TagDemux mode
+func newTagDemux(vs *VirtualSwitch) *tagDemux {
+	d := &tagDemux{
+		vs:      vs,
+		native:  vs.config.NativeVID,
+		domains: make(map[int]*VirtualSwitch),
+		ends:    make(map[*Connection]map[int]*Connection),
+	}
+	if len(vs.config.TagDemuxVIDs) > 0 {
+		d.allowed = make(map[int]bool, len(vs.config.TagDemuxVIDs)+1)
+		for _, vid := range vs.config.TagDemuxVIDs {
+			d.allowed[vid] = true
+		}
+		if d.native != 0 {
+			d.allowed[d.native] = true
+		}
+	}
+	return d
+}
+
+// domainConfig returns the configuration of the broadcast domain of a VLAN
+// ID: the forwarding settings of the VLAN, without its listeners, services
+// or state kept on disk
+func (d *tagDemux) domainConfig(vid int) Config {
+	config := d.vs.config
+	return Config{
+		Logger:              config.logger().With("vid", vid),
+		MTU:                 config.MTU,
+		MACTimeout:          config.MACTimeout,
+		MACTableSize:        config.MACTableSize,
+		InMemory:            true,
+		PadFrames:           config.PadFrames,
+		Validation:          config.Validation,
+		DropUnknownUnicast:  config.DropUnknownUnicast,
+		StickyMAC:           config.StickyMAC,
+		Hairpin:             config.Hairpin,
+		EgressQueueSize:     config.EgressQueueSize,
+		SlowConsumerPolicy:  config.SlowConsumerPolicy,
+		SlowConsumerTimeout: config.SlowConsumerTimeout,
+		MACEventHandler:     config.MACEventHandler,
+	}
+}
+
+// frameVID returns the VLAN ID of a frame and whether it is tagged at all;
+// priority-tagged frames have VLAN ID 0
+func frameVID(frame *EthernetFrame) (int, bool) {
+	if frame.EtherType != EtherTypeVLAN || len(frame.Raw) < 18 {
+		return 0, false
+	}
+	return int(binary.BigEndian.Uint16(frame.Raw[14:16]) & 0x0fff), true
+}
+
+// untagFrame returns a copy of a tagged frame without its tag
+func untagFrame(frame *EthernetFrame) (*EthernetFrame, error) {
+	raw := getFrameBuffer(len(frame.Raw) - 4)
+	copy(raw, frame.Raw[:12])
+	copy(raw[12:], frame.Raw[16:])
+	untagged, err := newPooledFrame(raw)
+	if err != nil {
+		putFrameBuffer(raw)
+		return nil, err
+	}
+	untagged.received = frame.received
+	return untagged, nil
+}
+
+// tagFrame returns a copy of a frame tagged with a VLAN ID
+func tagFrame(frame *EthernetFrame, vid int) (*EthernetFrame, error) {
+	raw := getFrameBuffer(len(frame.Raw) + 4)
+	copy(raw, frame.Raw[:12])
+	binary.BigEndian.PutUint16(raw[12:14], EtherTypeVLAN)
+	binary.BigEndian.PutUint16(raw[14:16], uint16(vid))
+	copy(raw[16:], frame.Raw[12:])
+	tagged, err := newPooledFrame(raw)
+	if err != nil {
+		putFrameBuffer(raw)
+		return nil, err
+	}
+	tagged.received = frame.received
+	return tagged, nil
+}
+
+// ingress hands a frame received from a connection to the broadcast domain
+// of its VLAN ID
+func (d *tagDemux) ingress(frame *EthernetFrame, source *Connection) error {
+	vid, tagged := frameVID(frame)
+	if vid == 0 {
+		vid = d.native
+	}
+	if vid == 0 || vid > maxVID || (d.allowed != nil && !d.allowed[vid]) {
+		d.dropped.Add(1)
+		return dropped(dropACLDeny, fmt.Errorf("%w: %d", errVIDNotAllowed, vid))
+	}
+
+	end := d.end(source, vid)
+	if end == nil {
+		return fmt.Errorf("connection closed")
+	}
+	if tagged {
+		untagged, err := untagFrame(frame)
+		if err != nil {
+			return dropped(dropParseError, err)
+		}
+		defer untagged.Release()
+		frame = untagged
+	}
+	if err := end.SendFrame(frame); err != nil {
+		return dropped(sendDropReason(err), err)
+	}
+	return nil
+}
+
+// end returns the end of a connection's pipe to the broadcast domain of a
+// VLAN ID, creating the domain if it does not exist yet, or nil if the
+// connection or the VLAN is gone
+func (d *tagDemux) end(source *Connection, vid int) *Connection {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	ends, found := d.ends[source]
+	if !found || d.stopped {
+		return nil
+	}
+	if end, found := ends[vid]; found {
+		return end
+	}
+
+	domain := NewVirtualSwitchWithConfig(d.vs.ports, d.domainConfig(vid))
+	if err := domain.Start(context.Background()); err != nil {
+		d.vs.logger.Error("Failed to start VLAN ID", "vid", vid, "error", err)
+		return nil
+	}
+	d.domains[vid] = domain
+	d.vs.logger.Info("Created broadcast domain", "vid", vid)
+	for conn, ends := range d.ends {
+		ends[vid] = d.connect(conn, vid, domain)
+	}
+	return ends[vid]
+}
+
+// attach connects a new connection of the VLAN to every broadcast domain
+func (d *tagDemux) attach(conn *Connection) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if d.stopped {
+		return
+	}
+	ends := make(map[int]*Connection, len(d.domains))
+	for vid, domain := range d.domains {
+		ends[vid] = d.connect(conn, vid, domain)
+	}
+	d.ends[conn] = ends
+}
+
+// connect attaches a connection to a broadcast domain through a pipe and
+// returns its end of the pipe; the caller holds the mutex
+func (d *tagDemux) connect(conn *Connection, vid int, domain *VirtualSwitch) *Connection {
+	client, server := net.Pipe()
+	label := conn.Label()
+	domain.attach(conn.ID, server, func(c *Connection) {
+		if label != "" {
+			c.SetLabel(label)
+		}
+	})
+
+	// Frames wait in a queue to enter the domain, so that a busy domain
+	// never blocks the connection's other VLAN IDs
+	end := NewConnection(conn.ID, client)
+	end.maxFrame = domain.config.maxFrameSize()
+	end.StartWriter(tagDomainQueueSize, SlowConsumerDropNew, 0)
+	go d.egress(end, conn, vid)
+	return end
+}
+
+// egress tags the frames a broadcast domain sends to a connection with the
+// domain's VLAN ID and sends them, until either side closes
+func (d *tagDemux) egress(end, conn *Connection, vid int) {
+	defer func() { _ = end.Close() }()
+	for {
+		frame, err := end.ReadFrame()
+		if err != nil {
+			return
+		}
+		out := frame
+		if vid != d.native {
+			if out, err = tagFrame(frame, vid); err != nil {
+				frame.Release()
+				continue
+			}
+			frame.Release()
+		}
+		err = conn.SendFrame(out)
+		out.Release()
+		if err != nil && !errors.Is(err, errQueueFull) && !errors.Is(err, errPortDisabled) {
+			return
+		}
+	}
+}
+
+// detach disconnects a closed connection of the VLAN from every broadcast
+// domain
+func (d *tagDemux) detach(conn *Connection) {
+	d.mutex.Lock()
+	ends := d.ends[conn]
+	delete(d.ends, conn)
+	d.mutex.Unlock()
+
+	for _, end := range ends {
+		_ = end.Close()
+	}
+}
+
+// stop stops the broadcast domains once the VLAN's connections are closed
+func (d *tagDemux) stop() {
+	d.mutex.Lock()
+	d.stopped = true
+	domains := make([]*VirtualSwitch, 0, len(d.domains))
+	for _, domain := range d.domains {
+		domains = append(domains, domain)
+	}
+	d.mutex.Unlock()
+
+	for _, domain := range domains {
+		domain.Stop()
+	}
+}
+
+// domainList returns the broadcast domains by VLAN ID
+func (d *tagDemux) domainList() map[int]*VirtualSwitch {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	domains := make(map[int]*VirtualSwitch, len(d.domains))
+	for vid, domain := range d.domains {
+		domains[vid] = domain
+	}
+	return domains
+}
+
+// TagDomains returns the broadcast domains of the VLAN IDs the VLAN
+// switched frames of, sorted by VLAN ID, or nil unless it is in TagDemux
+// mode
+func (vs *VirtualSwitch) TagDomains() []TagDomain {
+	if vs.demux == nil {
+		return nil
+	}
+	domains := make([]TagDomain, 0)
+	for vid, domain := range vs.demux.domainList() {
+		domains = append(domains, TagDomain{
+			VID:         vid,
+			Native:      vid == vs.demux.native,
+			Connections: domain.connectionCount(),
+			MACs:        domain.macTable.len(),
+			Frames:      domain.totalFrames.Load(),
+		})
+	}
+	sort.Slice(domains, func(i, j int) bool { return domains[i].VID < domains[j].VID })
+	return domains
+}
+
+// tagMACTable returns the MAC table entries of the broadcast domains, each
+// with its VLAN ID
+func (vs *VirtualSwitch) tagMACTable() []MACTableEntry {
+	entries := make([]MACTableEntry, 0)
+	for vid, domain := range vs.demux.domainList() {
+		for _, entry := range domain.GetMACTable() {
+			entry.VID = vid
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// GetTagDomains returns the broadcast domains of the VLAN at a port in
+// TagDemux mode
+func (sm *SwitchManager) GetTagDomains(port int) ([]TagDomain, error) {
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+
+	vs, exists := sm.switches[port]
+	if !exists {
+		return nil, fmt.Errorf("VLAN does not exist on port %d", port)
+	}
+	if vs.demux == nil {
+		return nil, fmt.Errorf("VLAN on port %d does not demultiplex tags", port)
+	}
+	return vs.TagDomains(), nil
+}
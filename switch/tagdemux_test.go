@@ -0,0 +1,149 @@
+package vswitch
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"net/http"
+	"testing"
+)
+
+// taggedFrame builds a frame tagged with a VLAN ID
+func taggedFrame(dst, src net.HardwareAddr, vid int, etherType uint16) []byte {
+	payload := binary.BigEndian.AppendUint16(nil, uint16(vid))
+	payload = binary.BigEndian.AppendUint16(payload, etherType)
+	return buildEthernet(dst, src, EtherTypeVLAN, append(payload, make([]byte, 46)...))
+}
+
+func TestFrameTagging(t *testing.T) {
+	mac := net.HardwareAddr{0x52, 0x54, 0x00, 0x00, 0x00, 0x01}
+	tagged := rawFrame(taggedFrame(BroadcastMAC, mac, 0x2064, EtherTypeIPv4))
+	if vid, ok := frameVID(tagged); !ok || vid != 100 {
+		t.Errorf("Expected VLAN ID 100 under the priority bits, got %d, %v", vid, ok)
+	}
+
+	untagged, err := untagFrame(tagged)
+	if err != nil {
+		t.Fatalf("Failed to untag: %v", err)
+	}
+	defer untagged.Release()
+	if _, ok := frameVID(untagged); ok || untagged.EtherType != EtherTypeIPv4 || len(untagged.Raw) != len(tagged.Raw)-4 {
+		t.Errorf("Unexpected untagged frame %s", untagged)
+	}
+
+	retagged, err := tagFrame(untagged, 100)
+	if err != nil {
+		t.Fatalf("Failed to tag: %v", err)
+	}
+	defer retagged.Release()
+	if vid, ok := frameVID(retagged); !ok || vid != 100 || len(retagged.Raw) != len(tagged.Raw) {
+		t.Errorf("Unexpected tagged frame %s", retagged)
+	}
+}
+
+func TestTagDemux(t *testing.T) {
+	sm := NewSwitchManager()
+	sm.SetDefaultConfig(Config{InMemory: true, EgressQueueSize: 64})
+	if err := sm.AddVLANWithConfig(8080, Config{InMemory: true, EgressQueueSize: 64, TagDemux: true, TagDemuxVIDs: []int{10, 20}, NativeVID: 1}); err != nil {
+		t.Fatalf("Failed to add VLAN: %v", err)
+	}
+	if err := sm.StartAll(context.Background()); err != nil {
+		t.Fatalf("Failed to start VLANs: %v", err)
+	}
+	defer sm.StopAll()
+
+	vs := sm.vlan(8080)
+	macA := net.HardwareAddr{0x52, 0x54, 0x00, 0x00, 0x00, 0x0a}
+	macB := net.HardwareAddr{0x52, 0x54, 0x00, 0x00, 0x00, 0x0b}
+	a := vs.Dial("a")
+	b := vs.Dial("b")
+	send := func(guest net.Conn, raw []byte) {
+		t.Helper()
+		if _, err := guest.Write(qemuFrame(raw)); err != nil {
+			t.Fatalf("Failed to send frame: %v", err)
+		}
+	}
+	expectVID := func(frame *EthernetFrame, expected int) {
+		t.Helper()
+		if vid, tagged := frameVID(frame); vid != expected || tagged != (expected != 0) {
+			t.Errorf("Expected a frame of VLAN ID %d, got %d", expected, vid)
+		}
+	}
+
+	// A broadcast reaches the other connections tagged with its VLAN ID
+	send(a, taggedFrame(BroadcastMAC, macA, 10, EtherTypeARP))
+	frame := readGuestFrame(t, b)
+	expectVID(frame, 10)
+	if frame.SrcMAC.String() != macA.String() {
+		t.Errorf("Expected the frame of %s, got one from %s", macA, frame.SrcMAC)
+	}
+
+	// The MAC learned on VLAN ID 10 is unknown on VLAN ID 20, where the
+	// frame is flooded
+	send(b, taggedFrame(macA, macB, 20, EtherTypeIPv4))
+	expectVID(readGuestFrame(t, a), 20)
+	send(b, taggedFrame(macA, macB, 10, EtherTypeIPv4))
+	expectVID(readGuestFrame(t, a), 10)
+
+	// VLAN IDs not carried are dropped, and untagged frames belong to the
+	// native VLAN ID
+	send(a, taggedFrame(BroadcastMAC, macA, 30, EtherTypeIPv4))
+	send(a, buildEthernet(BroadcastMAC, macA, EtherTypeIPv4, make([]byte, 46)))
+	expectVID(readGuestFrame(t, b), 0)
+	if drops := vs.GetStats()["vid_drops"]; drops != uint64(1) {
+		t.Errorf("Expected 1 frame of a VLAN ID not carried, got %v", drops)
+	}
+
+	domains := vs.TagDomains()
+	if len(domains) != 3 || domains[0].VID != 1 || !domains[0].Native || domains[1].VID != 10 || domains[1].MACs != 2 || domains[2].Connections != 2 {
+		t.Errorf("Unexpected broadcast domains %+v", domains)
+	}
+	vids := make(map[int]int)
+	for _, entry := range vs.GetMACTable() {
+		if entry.MAC == macA.String() {
+			vids[entry.VID]++
+		}
+	}
+	if vids[1] != 1 || vids[10] != 1 || vids[20] != 0 {
+		t.Errorf("Expected %s learned on VLAN IDs 1 and 10, got %v", macA, vids)
+	}
+
+	// A connection leaving the VLAN leaves every broadcast domain
+	_ = b.Close()
+	waitUntil(t, "the connection left the domains", func() bool {
+		for _, domain := range vs.TagDomains() {
+			if domain.Connections != 1 {
+				return false
+			}
+		}
+		return true
+	})
+	_ = a.Close()
+}
+
+func TestTagDemuxValidation(t *testing.T) {
+	for _, config := range []Config{
+		{TagDemux: true, NativeVID: 4095},
+		{TagDemux: true, TagDemuxVIDs: []int{0}},
+		{NativeVID: 1},
+		{TagDemuxVIDs: []int{10}},
+	} {
+		if err := config.validate(); err == nil {
+			t.Errorf("Expected %+v to be rejected", config)
+		}
+	}
+}
+
+func TestAPITagDomains(t *testing.T) {
+	sm := NewSwitchManager()
+	_ = sm.AddVLAN(8080)
+	_ = sm.AddVLANWithConfig(8081, Config{InMemory: true, TagDemux: true})
+	handler := NewAPIHandler(sm, "secret")
+
+	if status := apiRequest(handler, http.MethodGet, "/vlans/8081/vids", "", "secret"); status != http.StatusOK {
+		t.Errorf("Expected the broadcast domains, got %d", status)
+	}
+	if status := apiRequest(handler, http.MethodGet, "/vlans/8080/vids", "", "secret"); status != http.StatusNotFound {
+		t.Errorf("Expected a VLAN not demultiplexing tags to be rejected, got %d", status)
+	}
+}
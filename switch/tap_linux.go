@@ -0,0 +1,149 @@
+package vswitch
+
+import (
+	"encoding/binary"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// tapMaxFrame is the largest frame read from a TAP device, leaving room for
+// jumbo frames
+const tapMaxFrame = 65535
+
+// openTAP creates a TAP device. It is removed when the returned connection
+// is closed, including after it was moved to another network namespace.
+func openTAP(name string) (net.Conn, error) {
+	fd, err := syscall.Open("/dev/net/tun", syscall.O_RDWR|syscall.O_NONBLOCK|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	var ifr struct {
+		name  [syscall.IFNAMSIZ]byte
+		flags uint16
+		_     [22]byte
+	}
+	copy(ifr.name[:syscall.IFNAMSIZ-1], name)
+	ifr.flags = syscall.IFF_TAP | syscall.IFF_NO_PI
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), syscall.TUNSETIFF, uintptr(unsafe.Pointer(&ifr))); errno != 0 {
+		_ = syscall.Close(fd)
+		return nil, errno
+	}
+
+	return newPacketConn(os.NewFile(uintptr(fd), name), name), nil
+}
+
+// packetConn adapts a file that reads and writes whole frames, such as a
+// TAP device, to QEMU's stream framing with its 4-byte length prefixes
+type packetConn struct {
+	file *os.File
+	name string
+
+	readMutex sync.Mutex
+	readBuf   []byte
+	pending   []byte // framed bytes not yet returned by Read
+
+	writeMutex sync.Mutex
+	writeBuf   []byte // partial framed bytes not yet written
+}
+
+// newPacketConn wraps a frame file
+func newPacketConn(file *os.File, name string) *packetConn {
+	return &packetConn{
+		file:    file,
+		name:    name,
+		readBuf: make([]byte, 4+tapMaxFrame),
+	}
+}
+
+// Read returns the next frame with its length prefix
+func (c *packetConn) Read(b []byte) (int, error) {
+	c.readMutex.Lock()
+	defer c.readMutex.Unlock()
+
+	if len(c.pending) == 0 {
+		n, err := c.file.Read(c.readBuf[4:])
+		if err != nil {
+			return 0, err
+		}
+		binary.BigEndian.PutUint32(c.readBuf, uint32(n))
+		c.pending = c.readBuf[:4+n]
+	}
+
+	n := copy(b, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+// Write writes every complete frame of the length-prefixed stream in b,
+// keeping a partial frame until the rest of it arrives
+func (c *packetConn) Write(b []byte) (int, error) {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+
+	c.writeBuf = append(c.writeBuf, b...)
+	for len(c.writeBuf) >= 4 {
+		frameLen := int(binary.BigEndian.Uint32(c.writeBuf))
+		if len(c.writeBuf) < 4+frameLen {
+			break
+		}
+		if _, err := c.file.Write(c.writeBuf[4 : 4+frameLen]); err != nil {
+			c.writeBuf = c.writeBuf[:0]
+			return 0, err
+		}
+		c.writeBuf = c.writeBuf[4+frameLen:]
+	}
+	// Release the space of written frames
+	if len(c.writeBuf) == 0 {
+		c.writeBuf = nil
+	}
+
+	return len(b), nil
+}
+
+// Close closes the file
+func (c *packetConn) Close() error {
+	return c.file.Close()
+}
+
+// LocalAddr returns the device name
+func (c *packetConn) LocalAddr() net.Addr {
+	return tapAddr(c.name)
+}
+
+// RemoteAddr returns the device name
+func (c *packetConn) RemoteAddr() net.Addr {
+	return tapAddr(c.name)
+}
+
+// SetDeadline sets the read and write deadlines of the file
+func (c *packetConn) SetDeadline(t time.Time) error {
+	return c.file.SetDeadline(t)
+}
+
+// SetReadDeadline sets the read deadline of the file
+func (c *packetConn) SetReadDeadline(t time.Time) error {
+	return c.file.SetReadDeadline(t)
+}
+
+// SetWriteDeadline sets the write deadline of the file
+func (c *packetConn) SetWriteDeadline(t time.Time) error {
+	return c.file.SetWriteDeadline(t)
+}
+
+// tapAddr is the address of a TAP device, its name
+type tapAddr string
+
+// Network returns "tap"
+func (a tapAddr) Network() string {
+	return "tap"
+}
+
+// String returns the device name
+func (a tapAddr) String() string {
+	return string(a)
+}
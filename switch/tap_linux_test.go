@@ -0,0 +1,117 @@
+package vswitch
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestPacketConn(t *testing.T) {
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_SEQPACKET, 0)
+	if err != nil {
+		t.Fatalf("Failed to create socket pair: %v", err)
+	}
+	for _, fd := range fds {
+		_ = syscall.SetNonblock(fd, true)
+	}
+	conn := newPacketConn(os.NewFile(uintptr(fds[0]), "left"), "left")
+	peer := os.NewFile(uintptr(fds[1]), "right")
+	defer func() { _ = conn.Close() }()
+	defer func() { _ = peer.Close() }()
+
+	// A frame written in pieces goes out once complete
+	first := bytes.Repeat([]byte{0xaa}, 60)
+	second := bytes.Repeat([]byte{0xbb}, 64)
+	var stream []byte
+	for _, frame := range [][]byte{first, second} {
+		stream = binary.BigEndian.AppendUint32(stream, uint32(len(frame)))
+		stream = append(stream, frame...)
+	}
+	for _, chunk := range [][]byte{stream[:2], stream[2:30], stream[30:]} {
+		if n, err := conn.Write(chunk); err != nil || n != len(chunk) {
+			t.Fatalf("Failed to write chunk: %d, %v", n, err)
+		}
+	}
+	buf := make([]byte, 128)
+	for _, want := range [][]byte{first, second} {
+		n, err := peer.Read(buf)
+		if err != nil || !bytes.Equal(buf[:n], want) {
+			t.Fatalf("Expected a %d-byte frame, got %d, %v", len(want), n, err)
+		}
+	}
+
+	// A received frame is read with its length prefix
+	if _, err := peer.Write(first); err != nil {
+		t.Fatalf("Failed to send frame: %v", err)
+	}
+	connection := NewConnection("tap", conn)
+	frame, err := connection.ReadFrame()
+	if err != nil || !bytes.Equal(frame.Raw, first) {
+		t.Fatalf("Expected the sent frame, got %v", err)
+	}
+
+	_ = conn.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+	if _, err := io.ReadFull(conn, buf[:4]); !os.IsTimeout(err) {
+		t.Errorf("Expected a read timeout, got %v", err)
+	}
+}
+
+func TestDockerDriverJoin(t *testing.T) {
+	probe, err := openTAP("vswprobe")
+	if err != nil {
+		t.Skipf("Cannot create TAP devices: %v", err)
+	}
+	_ = probe.Close()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to find a free port: %v", err)
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+	_ = listener.Close()
+
+	sm := NewSwitchManager()
+	if err := sm.StartVLAN(port); err != nil {
+		t.Fatalf("Failed to start VLAN: %v", err)
+	}
+	defer sm.StopAll()
+
+	driver := NewDockerDriver(sm)
+	network := `{"NetworkID": "n1", "Options": {"com.docker.network.generic": {"vlan": "` + strconv.Itoa(port) + `"}}}`
+	if code, response := pluginCall(t, driver, "/NetworkDriver.CreateNetwork", network); code != http.StatusOK {
+		t.Fatalf("Failed to create network %d: %v", code, response)
+	}
+	pluginCall(t, driver, "/NetworkDriver.CreateEndpoint", `{"NetworkID": "n1", "EndpointID": "0123456789abcdef"}`)
+
+	code, response := pluginCall(t, driver, "/NetworkDriver.Join", `{"NetworkID": "n1", "EndpointID": "0123456789abcdef", "SandboxKey": "/var/run/docker/netns/x"}`)
+	names, _ := response["InterfaceName"].(map[string]interface{})
+	if code != http.StatusOK || names["SrcName"] != "vsw0123456789ab" || names["DstPrefix"] != "eth" {
+		t.Fatalf("Unexpected join response %d: %v", code, response)
+	}
+	if _, err := net.InterfaceByName("vsw0123456789ab"); err != nil {
+		t.Errorf("Expected the TAP device to exist: %v", err)
+	}
+	connections, _ := sm.GetConnections(port)
+	if len(connections) != 1 || connections[0].ID != "docker-0123456789ab" {
+		t.Errorf("Expected the container's connection, got %v", connections)
+	}
+
+	pluginCall(t, driver, "/NetworkDriver.Leave", `{"NetworkID": "n1", "EndpointID": "0123456789abcdef"}`)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err := net.InterfaceByName("vsw0123456789ab"); err != nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the TAP device to be removed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
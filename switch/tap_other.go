@@ -0,0 +1,13 @@
+//go:build !linux
+
+package vswitch
+
+import (
+	"errors"
+	"net"
+)
+
+// openTAP fails; TAP devices are created through a Linux-specific ioctl
+func openTAP(_ string) (net.Conn, error) {
+	return nil, errors.New("TAP devices are only supported on Linux")
+}
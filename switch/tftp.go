@@ -0,0 +1,346 @@
+package vswitch
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	tftpPort = 69
+
+	// tftpDefaultBlockSize is the block size used without negotiation
+	tftpDefaultBlockSize = 512
+
+	// tftpMaxBlockSize is the largest block that fits an untagged Ethernet frame
+	tftpMaxBlockSize = 1500 - 20 - 8 - 4
+
+	// tftpTimeout is the default retransmission timeout
+	tftpTimeout = time.Second
+
+	// tftpRetries is how often a block is sent before the transfer is abandoned
+	tftpRetries = 5
+
+	// tftpMaxTransfers bounds concurrent transfers per VLAN
+	tftpMaxTransfers = 32
+)
+
+// TFTP opcodes
+const (
+	tftpRRQ   = 1
+	tftpWRQ   = 2
+	tftpDATA  = 3
+	tftpACK   = 4
+	tftpERROR = 5
+	tftpOACK  = 6
+)
+
+// TFTP error codes
+const (
+	tftpErrUndefined  = 0
+	tftpErrNotFound   = 1
+	tftpErrAccess     = 2
+	tftpErrIllegalOp  = 4
+	tftpErrUnknownTID = 5
+)
+
+// tftpServer serves files from a host directory over TFTP (RFC 1350) with
+// the blksize, tsize and timeout options (RFC 2348, 2349). It is read-only
+// and cannot serve files outside its root.
+type tftpServer struct {
+	stack  *hostStack
+	root   *os.Root
+	active chan struct{}
+}
+
+// newTFTPServer creates a TFTP server for a directory on the host stack
+func newTFTPServer(stack *hostStack, dir string) (*tftpServer, error) {
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tftpServer{
+		stack:  stack,
+		root:   root,
+		active: make(chan struct{}, tftpMaxTransfers),
+	}, nil
+}
+
+// tftpError builds an ERROR packet
+func tftpError(code uint16, message string) []byte {
+	packet := binary.BigEndian.AppendUint16(nil, tftpERROR)
+	packet = binary.BigEndian.AppendUint16(packet, code)
+	packet = append(packet, message...)
+	return append(packet, 0)
+}
+
+// parseTFTPRequest splits a read or write request into the file name, mode
+// and lower-cased options
+func parseTFTPRequest(data []byte) (string, string, map[string]string, bool) {
+	fields := bytes.Split(data, []byte{0})
+	if len(fields) < 3 || len(fields[len(fields)-1]) != 0 {
+		return "", "", nil, false // Fields must be NUL terminated
+	}
+	fields = fields[:len(fields)-1]
+
+	options := make(map[string]string)
+	for i := 2; i+1 < len(fields); i += 2 {
+		options[strings.ToLower(string(fields[i]))] = string(fields[i+1])
+	}
+
+	return string(fields[0]), strings.ToLower(string(fields[1])), options, true
+}
+
+// handleRequest starts a transfer for a read request sent to the TFTP port
+func (s *tftpServer) handleRequest(req *udpRequest) {
+	if len(req.Payload) < 2 {
+		return
+	}
+
+	client := &udpRequest{
+		SrcMAC:  append(net.HardwareAddr{}, req.SrcMAC...),
+		SrcIP:   append(net.IP{}, req.SrcIP...),
+		DstIP:   append(net.IP{}, req.DstIP...),
+		SrcPort: req.SrcPort,
+		DstPort: req.DstPort,
+	}
+
+	switch binary.BigEndian.Uint16(req.Payload[0:2]) {
+	case tftpRRQ:
+	case tftpWRQ:
+		s.stack.reply(client, tftpError(tftpErrAccess, "server is read-only"))
+		return
+	default:
+		return
+	}
+
+	name, mode, options, ok := parseTFTPRequest(req.Payload[2:])
+	if !ok || (mode != "octet" && mode != "netascii") {
+		s.stack.reply(client, tftpError(tftpErrIllegalOp, "malformed request"))
+		return
+	}
+
+	select {
+	case s.active <- struct{}{}:
+	default:
+		s.stack.reply(client, tftpError(tftpErrUndefined, "server busy"))
+		return
+	}
+
+	file, size, err := s.open(name)
+	if err != nil {
+		<-s.active
+		code := uint16(tftpErrAccess)
+		if errors.Is(err, os.ErrNotExist) {
+			code = tftpErrNotFound
+		}
+		s.stack.reply(client, tftpError(code, err.Error()))
+		return
+	}
+
+	t := &tftpTransfer{
+		server:    s,
+		client:    client,
+		file:      file,
+		blockSize: tftpDefaultBlockSize,
+		timeout:   tftpTimeout,
+		acks:      make(chan int, 8),
+	}
+
+	oack := t.negotiate(options, size)
+
+	t.port = s.stack.handleEphemeralUDP(t.handlePacket)
+	if t.port == 0 {
+		<-s.active
+		_ = file.Close()
+		s.stack.reply(client, tftpError(tftpErrUndefined, "server busy"))
+		return
+	}
+
+	s.stack.vs.logger.Info("TFTP transfer started", "file", name, "ip", client.SrcIP.String())
+
+	vs := s.stack.vs
+	vs.wg.Add(1)
+	go func() {
+		defer vs.wg.Done()
+		t.run(oack, vs.shutdown)
+	}()
+}
+
+// open opens a regular file below the root for reading
+func (s *tftpServer) open(name string) (*os.File, int64, error) {
+	file, err := s.root.Open(strings.TrimLeft(name, "/"))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	info, err := file.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		_ = file.Close()
+		return nil, 0, os.ErrPermission
+	}
+
+	return file, info.Size(), nil
+}
+
+// handleFrame lets the host stack receive TFTP requests
+func (s *tftpServer) handleFrame(_ *EthernetFrame, _ *Connection) bool {
+	return false
+}
+
+// run closes the root directory at shutdown
+func (s *tftpServer) run(shutdown <-chan bool) {
+	<-shutdown
+	_ = s.root.Close()
+}
+
+// tftpTransfer sends one file to a client from its own port
+type tftpTransfer struct {
+	server    *tftpServer
+	client    *udpRequest
+	port      uint16
+	file      *os.File
+	blockSize int
+	timeout   time.Duration
+	acks      chan int // Acknowledged block numbers; -1 if the client aborted
+}
+
+// negotiate applies the options the server supports and returns the OACK
+// packet acknowledging them, or nil if none were requested
+func (t *tftpTransfer) negotiate(options map[string]string, size int64) []byte {
+	oack := binary.BigEndian.AppendUint16(nil, tftpOACK)
+	accepted := false
+
+	appendOption := func(name, value string) {
+		oack = append(oack, name...)
+		oack = append(oack, 0)
+		oack = append(oack, value...)
+		oack = append(oack, 0)
+		accepted = true
+	}
+
+	if value, err := strconv.Atoi(options["blksize"]); err == nil && value >= 8 {
+		t.blockSize = min(value, tftpMaxBlockSize)
+		appendOption("blksize", strconv.Itoa(t.blockSize))
+	}
+	if _, found := options["tsize"]; found {
+		appendOption("tsize", strconv.FormatInt(size, 10))
+	}
+	if value, err := strconv.Atoi(options["timeout"]); err == nil && value >= 1 && value <= 255 {
+		t.timeout = time.Duration(value) * time.Second
+		appendOption("timeout", strconv.Itoa(value))
+	}
+
+	if !accepted {
+		return nil
+	}
+	return oack
+}
+
+// handlePacket receives acknowledgements on the transfer's port
+func (t *tftpTransfer) handlePacket(req *udpRequest) {
+	if !req.SrcIP.Equal(t.client.SrcIP) || req.SrcPort != t.client.SrcPort {
+		t.server.stack.reply(req, tftpError(tftpErrUnknownTID, "unknown transfer ID"))
+		return
+	}
+	if len(req.Payload) < 4 {
+		return
+	}
+
+	ack := -1
+	switch binary.BigEndian.Uint16(req.Payload[0:2]) {
+	case tftpACK:
+		ack = int(binary.BigEndian.Uint16(req.Payload[2:4]))
+	case tftpERROR:
+	default:
+		return
+	}
+
+	select {
+	case t.acks <- ack:
+	default:
+	}
+}
+
+// run sends the file block by block, waiting for each acknowledgement
+func (t *tftpTransfer) run(oack []byte, shutdown <-chan bool) {
+	defer func() {
+		t.server.stack.removeUDP(t.port)
+		_ = t.file.Close()
+		<-t.server.active
+	}()
+
+	buf := make([]byte, 4+t.blockSize)
+	block := uint16(0)
+	packet := oack
+	last := false
+
+	for {
+		if packet == nil {
+			block++ // Block numbers wrap around for large files
+			binary.BigEndian.PutUint16(buf[0:2], tftpDATA)
+			binary.BigEndian.PutUint16(buf[2:4], block)
+			n, err := io.ReadFull(t.file, buf[4:])
+			if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+				t.send(tftpError(tftpErrUndefined, "read error"))
+				return
+			}
+			packet = buf[:4+n]
+			last = n < t.blockSize
+		}
+
+		if !t.sendAndWait(packet, block, shutdown) {
+			return
+		}
+		if last {
+			return
+		}
+		packet = nil
+	}
+}
+
+// sendAndWait sends a packet until the client acknowledges the block,
+// returning false if the transfer failed
+func (t *tftpTransfer) sendAndWait(packet []byte, block uint16, shutdown <-chan bool) bool {
+	for attempt := 0; attempt < tftpRetries; attempt++ {
+		t.send(packet)
+
+		timer := time.NewTimer(t.timeout)
+	wait:
+		for {
+			select {
+			case ack := <-t.acks:
+				if ack < 0 {
+					timer.Stop()
+					return false
+				}
+				if uint16(ack) == block {
+					timer.Stop()
+					return true
+				}
+				// Duplicate acknowledgements are ignored rather than answered
+				// to avoid the Sorcerer's Apprentice syndrome
+			case <-timer.C:
+				break wait
+			case <-shutdown:
+				timer.Stop()
+				return false
+			}
+		}
+	}
+
+	t.server.stack.vs.logger.Warn("TFTP transfer timed out", "ip", t.client.SrcIP.String())
+	return false
+}
+
+// send transmits a packet to the client from the transfer's port
+func (t *tftpTransfer) send(packet []byte) {
+	t.server.stack.sendUDP(t.client.SrcMAC, t.client.DstIP, t.client.SrcIP, t.port, t.client.SrcPort, packet)
+}
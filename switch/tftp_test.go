@@ -0,0 +1,181 @@
+package vswitch
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// tftpTestClient exchanges TFTP packets with a switch's host stack
+type tftpTestClient struct {
+	t      *testing.T
+	sw     *VirtualSwitch
+	stack  *hostStack
+	conn   *Connection
+	sink   *frameSink
+	mac    net.HardwareAddr
+	ip     net.IP
+	server net.IP
+}
+
+// newTFTPTest creates a switch serving dir over TFTP and one client
+func newTFTPTest(t *testing.T, dir string) *tftpTestClient {
+	t.Helper()
+
+	_, subnet, _ := net.ParseCIDR("10.0.2.0/24")
+	sw := NewVirtualSwitchWithConfig([]int{8080}, Config{
+		DHCPServer: &DHCPServerConfig{ServerIP: net.ParseIP("10.0.2.3").To4(), Subnet: subnet},
+		TFTPRoot:   dir,
+	})
+	t.Cleanup(func() { close(sw.shutdown) })
+
+	var stack *hostStack
+	for _, svc := range sw.services {
+		if s, ok := svc.(*hostStack); ok {
+			stack = s
+		}
+	}
+
+	sink := &frameSink{}
+	conn := NewConnection("client", sink)
+	sw.connections.Store("client", conn)
+
+	return &tftpTestClient{
+		t:      t,
+		sw:     sw,
+		stack:  stack,
+		conn:   conn,
+		sink:   sink,
+		mac:    net.HardwareAddr{0x52, 0x54, 0x00, 0x00, 0x00, 0x01},
+		ip:     net.ParseIP("10.0.2.100").To4(),
+		server: net.ParseIP("10.0.2.3").To4(),
+	}
+}
+
+// send sends a TFTP packet from the client's port 2000 to a server port
+func (c *tftpTestClient) send(port uint16, payload []byte) {
+	packet := buildUDPv4(c.ip, c.server, 2000, port, payload)
+	_ = c.sw.processFrame(rawFrame(buildEthernet(c.stack.mac, c.mac, EtherTypeIPv4, packet)), c.conn)
+}
+
+// receive waits for the next TFTP packet and returns it with its source port
+func (c *tftpTestClient) receive() ([]byte, uint16) {
+	c.t.Helper()
+
+	for {
+		frame := c.sink.nextFrame(c.t)
+		if frame.EtherType != EtherTypeIPv4 {
+			continue
+		}
+		ip, err := parseIPv4(frame.Payload)
+		if err != nil || ip.Protocol != ipProtoUDP {
+			continue
+		}
+		udp, err := parseUDP(ip.Payload)
+		if err != nil || udp.DstPort != 2000 {
+			c.t.Fatalf("Unexpected datagram %+v (%v)", udp, err)
+		}
+		return append([]byte{}, udp.Payload...), udp.SrcPort
+	}
+}
+
+// tftpReadRequest builds a RRQ packet with options
+func tftpReadRequest(name string, options ...string) []byte {
+	packet := binary.BigEndian.AppendUint16(nil, tftpRRQ)
+	for _, field := range append([]string{name, "octet"}, options...) {
+		packet = append(packet, field...)
+		packet = append(packet, 0)
+	}
+	return packet
+}
+
+// tftpAck builds an ACK packet
+func tftpAck(block uint16) []byte {
+	return binary.BigEndian.AppendUint16(binary.BigEndian.AppendUint16(nil, tftpACK), block)
+}
+
+func TestParseTFTPRequest(t *testing.T) {
+	name, mode, options, ok := parseTFTPRequest(tftpReadRequest("pxelinux.0", "BLKSIZE", "1024", "tsize", "0")[2:])
+	if !ok || name != "pxelinux.0" || mode != "octet" {
+		t.Fatalf("Unexpected request %q %q %v", name, mode, ok)
+	}
+	if options["blksize"] != "1024" || options["tsize"] != "0" {
+		t.Errorf("Unexpected options %v", options)
+	}
+
+	if _, _, _, ok := parseTFTPRequest([]byte("file\x00octet")); ok {
+		t.Errorf("Expected unterminated request to be rejected")
+	}
+}
+
+func TestTFTPTransfer(t *testing.T) {
+	dir := t.TempDir()
+	content := bytes.Repeat([]byte("0123456789"), 100)
+	if err := os.WriteFile(filepath.Join(dir, "boot.img"), content, 0o644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	c := newTFTPTest(t, dir)
+	c.send(tftpPort, tftpReadRequest("/boot.img", "blksize", "512", "tsize", "0"))
+
+	oack, port := c.receive()
+	if binary.BigEndian.Uint16(oack) != tftpOACK || !bytes.Contains(oack, []byte("tsize\x001000\x00")) {
+		t.Fatalf("Expected OACK with tsize, got %q", oack)
+	}
+	if port == tftpPort {
+		t.Errorf("Expected transfer on its own port")
+	}
+
+	var received []byte
+	for block := uint16(0); ; block++ {
+		c.send(port, tftpAck(block))
+		data, _ := c.receive()
+		if binary.BigEndian.Uint16(data[0:2]) != tftpDATA || binary.BigEndian.Uint16(data[2:4]) != block+1 {
+			t.Fatalf("Expected DATA block %d, got %q", block+1, data[:4])
+		}
+		received = append(received, data[4:]...)
+		if len(data)-4 < 512 {
+			c.send(port, tftpAck(block+1))
+			break
+		}
+	}
+
+	if !bytes.Equal(received, content) {
+		t.Errorf("Received %d bytes, expected %d", len(received), len(content))
+	}
+}
+
+func TestTFTPErrors(t *testing.T) {
+	parent := t.TempDir()
+	dir := filepath.Join(parent, "root")
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		t.Fatalf("Failed to create root: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(parent, "secret"), []byte("secret"), 0o644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	c := newTFTPTest(t, dir)
+
+	tests := []struct {
+		name    string
+		request []byte
+		code    uint16
+	}{
+		{"missing file", tftpReadRequest("missing"), tftpErrNotFound},
+		{"escape root", tftpReadRequest("../secret"), tftpErrAccess},
+		{"directory", tftpReadRequest("."), tftpErrAccess},
+		{"write", append(binary.BigEndian.AppendUint16(nil, tftpWRQ), "file\x00octet\x00"...), tftpErrAccess},
+	}
+
+	for _, tt := range tests {
+		c.send(tftpPort, tt.request)
+		reply, _ := c.receive()
+		if binary.BigEndian.Uint16(reply[0:2]) != tftpERROR || binary.BigEndian.Uint16(reply[2:4]) != tt.code {
+			t.Errorf("%s: expected error %d, got %q", tt.name, tt.code, reply)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package vswitch
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// tlsHandshakeTimeout bounds how long a client may take to present its
+// certificate
+const tlsHandshakeTimeout = 10 * time.Second
+
+// TLSRoute places the clients presenting a certificate identity on a VLAN,
+// with the port settings of their tenant
+type TLSRoute struct {
+	// Identity is matched against the common name and the DNS, email and
+	// URI subject alternative names of the client certificate. "*" matches
+	// any client certificate.
+	Identity string
+
+	// Port is the VLAN the clients join
+	Port int
+
+	// Mode, if set, replaces the private VLAN mode the VLAN gives new
+	// connections; Community names the community of PortCommunity
+	Mode      *PortMode
+	Community string
+
+	// Trusted lets the clients answer as DHCP servers and routers
+	Trusted bool
+
+	// Hairpin reflects the clients' frames back to them
+	Hairpin bool
+
+	// Horizon, if set, places the clients in a split horizon group
+	Horizon string
+}
+
+// TLSEndpoint accepts TLS connections on a single address and attaches
+// each to the VLAN its client certificate is routed to, so tenants sharing
+// the endpoint land on their own segments. The connections are labeled
+// with the identity they were routed by.
+type TLSEndpoint struct {
+	sm       *SwitchManager
+	listener net.Listener
+	routes   []TLSRoute
+}
+
+// ListenTLS opens a TLS endpoint on addr. The configuration must require
+// verified client certificates, as they decide the VLAN; routes are tried
+// in order.
+func ListenTLS(sm *SwitchManager, addr string, config *tls.Config, routes []TLSRoute) (*TLSEndpoint, error) {
+	if config.ClientAuth != tls.RequireAndVerifyClientCert {
+		return nil, fmt.Errorf("TLS endpoint needs verified client certificates")
+	}
+
+	listener, err := tls.Listen("tcp", addr, config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TLSEndpoint{sm: sm, listener: listener, routes: routes}, nil
+}
+
+// Addr returns the address the endpoint listens on
+func (e *TLSEndpoint) Addr() net.Addr {
+	return e.listener.Addr()
+}
+
+// Serve accepts connections until the endpoint is closed
+func (e *TLSEndpoint) Serve() {
+	for {
+		conn, err := e.listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			e.sm.logger().Warn("Failed to accept TLS connection", "error", err)
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+		go e.handle(conn.(*tls.Conn))
+	}
+}
+
+// Close stops accepting connections; attached connections stay up
+func (e *TLSEndpoint) Close() error {
+	return e.listener.Close()
+}
+
+// handle completes the handshake of a connection and attaches it to the
+// VLAN its certificate is routed to
+func (e *TLSEndpoint) handle(conn *tls.Conn) {
+	remote := conn.RemoteAddr().String()
+
+	_ = conn.SetDeadline(time.Now().Add(tlsHandshakeTimeout))
+	if err := conn.Handshake(); err != nil {
+		e.sm.logger().Warn("TLS handshake failed", "remote", remote, "error", err)
+		_ = conn.Close()
+		return
+	}
+	_ = conn.SetDeadline(time.Time{})
+
+	cert := conn.ConnectionState().PeerCertificates[0]
+	route, identity, found := e.route(cert)
+	if !found {
+		e.sm.logger().Warn("Rejected TLS client without a VLAN", "remote", remote, "subject", cert.Subject.String())
+		_ = conn.Close()
+		return
+	}
+
+	e.sm.mutex.RLock()
+	vs, exists := e.sm.switches[route.Port]
+	e.sm.mutex.RUnlock()
+	if !exists {
+		e.sm.logger().Warn("Rejected TLS client routed to a missing VLAN", "remote", remote, "identity", identity, "vlan", route.Port)
+		_ = conn.Close()
+		return
+	}
+	if !vs.admit(conn, route.Port) {
+		_ = conn.Close()
+		return
+	}
+
+	vs.attach(fmt.Sprintf("%s-%d", remote, route.Port), conn, func(connection *Connection) {
+		if route.Mode != nil {
+			connection.SetPortMode(*route.Mode, route.Community)
+		}
+		if route.Trusted {
+			connection.SetTrusted(true)
+		}
+		if route.Hairpin {
+			connection.SetHairpin(true)
+		}
+		if route.Horizon != "" {
+			connection.SetHorizon(route.Horizon)
+		}
+		connection.SetLabel(identity)
+	})
+}
+
+// route returns the first route matching an identity of the certificate,
+// with the identity it matched
+func (e *TLSEndpoint) route(cert *x509.Certificate) (TLSRoute, string, bool) {
+	identities := certificateIdentities(cert)
+	for _, route := range e.routes {
+		if route.Identity == "*" {
+			if len(identities) == 0 {
+				return route, cert.Subject.String(), true
+			}
+			return route, identities[0], true
+		}
+		for _, identity := range identities {
+			if route.Identity == identity {
+				return route, identity, true
+			}
+		}
+	}
+	return TLSRoute{}, "", false
+}
+
+// certificateIdentities returns the common name and the subject alternative
+// names of a certificate
+func certificateIdentities(cert *x509.Certificate) []string {
+	var identities []string
+	if cert.Subject.CommonName != "" {
+		identities = append(identities, cert.Subject.CommonName)
+	}
+	identities = append(identities, cert.DNSNames...)
+	identities = append(identities, cert.EmailAddresses...)
+	for _, uri := range cert.URIs {
+		identities = append(identities, uri.String())
+	}
+	return identities
+}
@@ -0,0 +1,155 @@
+package vswitch
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+// testCA issues certificates for TLS endpoint tests
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pool *x509.CertPool
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate CA key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create CA certificate: %v", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return &testCA{cert: cert, key: key, pool: pool}
+}
+
+// issue returns a certificate for the common name and DNS names
+func (ca *testCA) issue(t *testing.T, usage x509.ExtKeyUsage, commonName string, dnsNames ...string) tls.Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		DNSNames:     dnsNames,
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestTLSEndpoint(t *testing.T) {
+	ca := newTestCA(t)
+	sm := NewSwitchManager()
+	for _, port := range []int{9101, 9102} {
+		if err := sm.AddVLANWithConfig(port, Config{PrivateVLAN: true}); err != nil {
+			t.Fatalf("Failed to add VLAN: %v", err)
+		}
+	}
+	defer sm.StopAll()
+
+	promiscuous := PortPromiscuous
+	serverConfig := &tls.Config{
+		Certificates: []tls.Certificate{ca.issue(t, x509.ExtKeyUsageServerAuth, "vswitch")},
+		ClientCAs:    ca.pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}
+	endpoint, err := ListenTLS(sm, "127.0.0.1:0", serverConfig, []TLSRoute{
+		{Identity: "tenant-a", Port: 9101},
+		{Identity: "gw.tenant-b.example", Port: 9102, Mode: &promiscuous, Trusted: true},
+	})
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer func() { _ = endpoint.Close() }()
+	go endpoint.Serve()
+
+	dial := func(cert tls.Certificate) *tls.Conn {
+		t.Helper()
+		conn, err := tls.Dial("tcp", endpoint.Addr().String(), &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			RootCAs:      ca.pool,
+			ServerName:   "127.0.0.1",
+		})
+		if err != nil {
+			t.Fatalf("Failed to connect: %v", err)
+		}
+		return conn
+	}
+	waitConnection := func(port int) ConnectionInfo {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for time.Now().Before(deadline) {
+			if conns, _ := sm.GetConnections(port); len(conns) == 1 {
+				return conns[0]
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatalf("Expected a connection on VLAN %d", port)
+		return ConnectionInfo{}
+	}
+
+	// The common name routes a client to its VLAN with the VLAN's defaults
+	tenantA := dial(ca.issue(t, x509.ExtKeyUsageClientAuth, "tenant-a"))
+	defer func() { _ = tenantA.Close() }()
+	if info := waitConnection(9101); info.Label != "tenant-a" || info.Mode != "isolated" {
+		t.Errorf("Expected an isolated connection labeled tenant-a, got %+v", info)
+	}
+
+	// A DNS name routes too, and the route's settings override the defaults
+	tenantB := dial(ca.issue(t, x509.ExtKeyUsageClientAuth, "router", "gw.tenant-b.example"))
+	defer func() { _ = tenantB.Close() }()
+	if info := waitConnection(9102); info.Label != "gw.tenant-b.example" || info.Mode != "promiscuous" || !info.Trusted {
+		t.Errorf("Expected a trusted promiscuous connection labeled gw.tenant-b.example, got %+v", info)
+	}
+
+	// Clients without a route are disconnected
+	stranger := dial(ca.issue(t, x509.ExtKeyUsageClientAuth, "stranger"))
+	defer func() { _ = stranger.Close() }()
+	_ = stranger.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := stranger.Read(make([]byte, 1)); err == nil || errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("Expected a client without a route to be disconnected, got %v", err)
+	}
+}
+
+func TestTLSEndpointRequiresClientCertificates(t *testing.T) {
+	if _, err := ListenTLS(NewSwitchManager(), "127.0.0.1:0", &tls.Config{}, nil); err == nil {
+		t.Error("Expected an endpoint without client certificate verification to be refused")
+	}
+}
@@ -0,0 +1,129 @@
+package vswitch
+
+import (
+	"fmt"
+)
+
+// ValidationMode selects which frame checks drop the frames failing them.
+// Frames failing a check are counted whatever the mode.
+type ValidationMode int
+
+const (
+	// ValidationStandard drops frames longer than the MTU allows and
+	// frames from the all-zero MAC address
+	ValidationStandard ValidationMode = iota
+	// ValidationStrict also drops frames with a multicast source MAC and
+	// 802.3 frames whose length field exceeds their payload
+	ValidationStrict
+	// ValidationPermissive drops no frames, accepting frames up to the
+	// largest jumbo frame whatever the MTU
+	ValidationPermissive
+)
+
+// String returns the name of the validation mode
+func (m ValidationMode) String() string {
+	switch m {
+	case ValidationStandard:
+		return "standard"
+	case ValidationStrict:
+		return "strict"
+	case ValidationPermissive:
+		return "permissive"
+	default:
+		return "unknown"
+	}
+}
+
+// ParseValidationMode parses the name of a validation mode
+func ParseValidationMode(name string) (ValidationMode, error) {
+	for _, mode := range []ValidationMode{ValidationStrict, ValidationStandard, ValidationPermissive} {
+		if mode.String() == name {
+			return mode, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown validation mode '%s'", name)
+}
+
+// frameCheck is a check frames are validated against
+type frameCheck int
+
+const (
+	checkOversize frameCheck = iota
+	checkZeroSource
+	checkMulticastSource
+	checkLength
+	frameCheckCount
+)
+
+// frameCheckNames names the checks in the statistics
+var frameCheckNames = [frameCheckCount]string{
+	checkOversize:        "oversize",
+	checkZeroSource:      "zero_source",
+	checkMulticastSource: "multicast_source",
+	checkLength:          "bad_length",
+}
+
+// drops returns whether frames failing a check are dropped in the mode
+func (m ValidationMode) drops(check frameCheck) bool {
+	switch m {
+	case ValidationStrict:
+		return true
+	case ValidationPermissive:
+		return false
+	default:
+		return check == checkOversize || check == checkZeroSource
+	}
+}
+
+// checkFrame counts the checks a frame fails and returns an error if the
+// VLAN's validation mode drops it
+func (vs *VirtualSwitch) checkFrame(frame *EthernetFrame) error {
+	var err error
+	var dropCheck frameCheck
+	fail := func(check frameCheck, reason error) {
+		vs.invalidFrames[check].Add(1)
+		if err == nil && vs.config.Validation.drops(check) {
+			err, dropCheck = reason, check
+		}
+	}
+
+	if len(frame.Raw) > vs.config.maxFrameSize() {
+		fail(checkOversize, fmt.Errorf("frame too long: %d bytes", len(frame.Raw)))
+	}
+	if isZeroMAC(frame.SrcMAC) {
+		fail(checkZeroSource, fmt.Errorf("invalid source MAC: all zeros"))
+	} else if frame.SrcMAC[0]&0x01 != 0 {
+		fail(checkMulticastSource, fmt.Errorf("invalid source MAC: multicast %s", frame.SrcMAC))
+	}
+	if frame.EtherType <= maxFrameLength && int(frame.EtherType) > len(frame.Raw)-14 {
+		fail(checkLength, fmt.Errorf("802.3 length %d exceeds the %d byte payload", frame.EtherType, len(frame.Raw)-14))
+	}
+
+	if err == nil {
+		return nil
+	}
+	vs.validationDrops.Add(1)
+	if dropCheck == checkOversize {
+		return dropped(dropMTUExceeded, err)
+	}
+	return dropped(dropValidation, err)
+}
+
+// invalidFrameCounts returns the number of frames that failed each check
+func (vs *VirtualSwitch) invalidFrameCounts() map[string]uint64 {
+	counts := make(map[string]uint64, frameCheckCount)
+	for check, name := range frameCheckNames {
+		counts[name] = vs.invalidFrames[check].Load()
+	}
+	return counts
+}
+
+// isZeroMAC returns whether a MAC address is all zeros
+func isZeroMAC(mac []byte) bool {
+	for _, b := range mac {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,100 @@
+package vswitch
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+)
+
+func TestValidationModes(t *testing.T) {
+	guestMAC := net.HardwareAddr{0x52, 0x54, 0x00, 0x00, 0x00, 0x01}
+	zeroMAC := net.HardwareAddr{0, 0, 0, 0, 0, 0}
+	multicastMAC := net.HardwareAddr{0x01, 0x00, 0x5e, 0x00, 0x00, 0x01}
+
+	frames := map[string][]byte{
+		"valid":            buildEthernet(BroadcastMAC, guestMAC, EtherTypeARP, make([]byte, 46)),
+		"oversize":         buildEthernet(BroadcastMAC, guestMAC, EtherTypeIPv4, make([]byte, 2000)),
+		"zero_source":      buildEthernet(BroadcastMAC, zeroMAC, EtherTypeARP, make([]byte, 46)),
+		"multicast_source": buildEthernet(BroadcastMAC, multicastMAC, EtherTypeARP, make([]byte, 46)),
+		"bad_length":       buildEthernet(BroadcastMAC, guestMAC, 100, make([]byte, 46)),
+	}
+
+	tests := []struct {
+		mode    ValidationMode
+		dropped []string
+	}{
+		{ValidationStandard, []string{"oversize", "zero_source"}},
+		{ValidationStrict, []string{"oversize", "zero_source", "multicast_source", "bad_length"}},
+		{ValidationPermissive, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.mode.String(), func(t *testing.T) {
+			sw := NewVirtualSwitchWithConfig([]int{8080}, Config{Validation: test.mode})
+			conn := NewConnection("guest", &frameSink{})
+
+			dropped := make(map[string]bool)
+			for _, name := range test.dropped {
+				dropped[name] = true
+			}
+			for name, raw := range frames {
+				err := sw.processFrame(rawFrame(raw), conn)
+				if dropped[name] && err == nil {
+					t.Errorf("Expected the %s frame to be dropped", name)
+				} else if !dropped[name] && err != nil {
+					t.Errorf("Expected the %s frame to pass: %v", name, err)
+				}
+			}
+
+			stats := sw.GetStats()
+			if drops := stats["validation_drops"].(uint64); drops != uint64(len(test.dropped)) {
+				t.Errorf("Expected %d validation drops, got %d", len(test.dropped), drops)
+			}
+			// Every mode counts the failed checks
+			for name, count := range stats["invalid_frames"].(map[string]uint64) {
+				if count != 1 {
+					t.Errorf("Expected 1 frame failing %s, got %d", name, count)
+				}
+			}
+		})
+	}
+}
+
+func TestValidationKeepsConnection(t *testing.T) {
+	// An oversized frame is dropped without ending the connection
+	frame := append(qemuFrame(buildEthernet(BroadcastMAC, net.HardwareAddr{0x52, 0x54, 0x00, 0x00, 0x00, 0x01}, EtherTypeIPv4, make([]byte, 2000))),
+		qemuFrame(buildEthernet(BroadcastMAC, net.HardwareAddr{0x52, 0x54, 0x00, 0x00, 0x00, 0x01}, EtherTypeARP, make([]byte, 46)))...)
+
+	sw := NewVirtualSwitch([]int{8080})
+	conn := NewConnection("guest", &mockConnSwitch{readData: frame, addr: &mockAddrSwitch{network: "tcp", address: "127.0.0.1:9001"}})
+	sw.applyPortDefaults(conn)
+
+	for i := 0; i < 2; i++ {
+		received, err := conn.ReadFrame()
+		if err != nil {
+			t.Fatalf("Expected frame %d to be read: %v", i, err)
+		}
+		err = sw.processFrame(received, conn)
+		if i == 0 && err == nil {
+			t.Error("Expected the oversized frame to be dropped")
+		} else if i == 1 && err != nil {
+			t.Errorf("Expected the next frame to pass: %v", err)
+		}
+	}
+}
+
+func TestParseValidationMode(t *testing.T) {
+	for _, mode := range []ValidationMode{ValidationStandard, ValidationStrict, ValidationPermissive} {
+		if parsed, err := ParseValidationMode(mode.String()); err != nil || parsed != mode {
+			t.Errorf("Expected %s to parse, got %v, %v", mode, parsed, err)
+		}
+	}
+	if _, err := ParseValidationMode("lenient"); err == nil {
+		t.Error("Expected an unknown mode to be rejected")
+	}
+}
+
+// qemuFrame prefixes a frame with its length as QEMU sends it
+func qemuFrame(raw []byte) []byte {
+	return append(binary.BigEndian.AppendUint32(nil, uint32(len(raw))), raw...)
+}
@@ -0,0 +1,76 @@
+package vswitch
+
+import (
+	"fmt"
+	"net"
+	"time"
+)
+
+const (
+	// vmnetDialTimeout bounds connecting to the socket_vmnet helper
+	vmnetDialTimeout = 5 * time.Second
+
+	// vmnetRetryInterval is how long the uplink waits before reconnecting
+	// to the helper
+	vmnetRetryInterval = 5 * time.Second
+)
+
+// vmnetUplink connects a VLAN to the host network on macOS. The vmnet
+// framework needs entitlements or root, so instead of linking it the
+// uplink talks to socket_vmnet, a small privileged helper that exposes a
+// vmnet interface on a unix socket using QEMU's stream framing. Whether
+// guests share the host's connection through NAT or are bridged to a host
+// interface is chosen by the helper's --vmnet-mode. The helper appears on
+// the VLAN as a trusted, promiscuous connection, since it hosts the DHCP
+// server and router of shared mode.
+type vmnetUplink struct {
+	vs   *VirtualSwitch
+	path string
+}
+
+// newVMNetUplink creates an uplink to the socket_vmnet helper at path
+func newVMNetUplink(vs *VirtualSwitch, path string) *vmnetUplink {
+	return &vmnetUplink{vs: vs, path: path}
+}
+
+// handleFrame lets all frames through; the helper is an ordinary connection
+func (u *vmnetUplink) handleFrame(_ *EthernetFrame, _ *Connection) bool {
+	return false
+}
+
+// run keeps the VLAN connected to the helper, reconnecting when it restarts
+func (u *vmnetUplink) run(shutdown <-chan bool) {
+	failing := false
+	for {
+		conn, err := net.DialTimeout("unix", u.path, vmnetDialTimeout)
+		if err != nil {
+			// Log once until the helper is back
+			if !failing {
+				u.vs.logger.Warn("Failed to connect to vmnet helper", "socket", u.path, "error", err)
+			}
+			failing = true
+		} else {
+			failing = false
+			u.serve(conn)
+		}
+
+		select {
+		case <-shutdown:
+			return
+		case <-time.After(vmnetRetryInterval):
+		}
+	}
+}
+
+// serve forwards frames between the VLAN and the helper until either side
+// closes
+func (u *vmnetUplink) serve(conn net.Conn) {
+	connection := NewConnection(fmt.Sprintf("vmnet-%d", u.vs.port()), conn)
+	u.vs.applyPortDefaults(connection)
+	connection.SetTrusted(true)
+	connection.SetPortMode(PortPromiscuous, "")
+	u.vs.attachConnection(connection)
+
+	u.vs.wg.Add(1)
+	u.vs.handleConnection(connection)
+}
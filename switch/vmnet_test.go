@@ -0,0 +1,70 @@
+//go:build !windows
+
+package vswitch
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestVMNetUplink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "socket_vmnet")
+	helper, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer func() { _ = helper.Close() }()
+
+	probe, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to find a free port: %v", err)
+	}
+	port := probe.Addr().(*net.TCPAddr).Port
+	_ = probe.Close()
+
+	config := DefaultConfig()
+	config.VMNetSocket = path
+	config.PrivateVLAN = true
+	sw := NewVirtualSwitchWithConfig([]int{port}, config)
+	if err := sw.Start(context.Background()); err != nil {
+		t.Fatalf("Failed to start switch: %v", err)
+	}
+	defer sw.Stop()
+
+	_ = helper.(*net.UnixListener).SetDeadline(time.Now().Add(5 * time.Second))
+	conn, err := helper.Accept()
+	if err != nil {
+		t.Fatalf("Expected the switch to connect to the helper: %v", err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	// A frame from the host network is learned on the uplink
+	frame := make([]byte, 4+60)
+	binary.BigEndian.PutUint32(frame, 60)
+	copy(frame[4:], []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x5a, 0x94, 0xef, 0x00, 0x00, 0x01, 0x08, 0x06})
+	if _, err := conn.Write(frame); err != nil {
+		t.Fatalf("Failed to send frame: %v", err)
+	}
+
+	mac := net.HardwareAddr{0x5a, 0x94, 0xef, 0x00, 0x00, 0x01}
+	deadline := time.Now().Add(5 * time.Second)
+	for sw.lookupMAC(mac) == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the host's MAC to be learned")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	uplink := sw.lookupMAC(mac)
+	if uplink.ID != "vmnet-"+strconv.Itoa(port) {
+		t.Errorf("Expected the uplink connection, got %s", uplink.ID)
+	}
+	if mode, _ := uplink.PortMode(); !uplink.Trusted() || mode != PortPromiscuous {
+		t.Errorf("Expected the uplink to be trusted and promiscuous")
+	}
+}
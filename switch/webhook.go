@@ -0,0 +1,146 @@
+package vswitch
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// webhookQueueSize is the number of events waiting to be posted before
+// new ones are dropped
+const webhookQueueSize = 1024
+
+// webhookTimeout bounds each POST to the webhook
+const webhookTimeout = 5 * time.Second
+
+// Webhook posts events as JSON to a URL from a background goroutine, so
+// that the switch never waits on the receiving system. Events are posted
+// in order; when the receiver falls behind, new events are dropped.
+type Webhook struct {
+	url    string
+	client *http.Client
+	logger *slog.Logger
+
+	queue chan any
+	stop  chan struct{}
+	wg    sync.WaitGroup
+	once  sync.Once
+
+	sent    atomic.Uint64
+	dropped atomic.Uint64
+	failed  atomic.Uint64
+}
+
+// NewWebhook starts posting the events sent to it to url
+func NewWebhook(url string, logger *slog.Logger) *Webhook {
+	w := &Webhook{
+		url:    url,
+		client: &http.Client{Timeout: webhookTimeout},
+		logger: loggerOrDiscard(logger),
+		queue:  make(chan any, webhookQueueSize),
+		stop:   make(chan struct{}),
+	}
+
+	w.wg.Add(1)
+	go w.run()
+	return w
+}
+
+// Send queues an event for posting. It never blocks and returns false if
+// the event was dropped because the queue is full or the webhook closed.
+func (w *Webhook) Send(event any) bool {
+	select {
+	case <-w.stop:
+		w.dropped.Add(1)
+		return false
+	default:
+	}
+
+	select {
+	case w.queue <- event:
+		return true
+	default:
+		w.dropped.Add(1)
+		return false
+	}
+}
+
+// Close posts the events already queued and stops the webhook
+func (w *Webhook) Close() {
+	w.once.Do(func() { close(w.stop) })
+	w.wg.Wait()
+}
+
+// Stats returns the number of events posted, dropped because the queue
+// was full and lost because the POST failed
+func (w *Webhook) Stats() (sent, dropped, failed uint64) {
+	return w.sent.Load(), w.dropped.Load(), w.failed.Load()
+}
+
+// run posts queued events until the webhook is closed
+func (w *Webhook) run() {
+	defer w.wg.Done()
+
+	failing := false
+	for {
+		select {
+		case event := <-w.queue:
+			failing = w.deliver(event, failing)
+		case <-w.stop:
+			// Drain what was queued before Close
+			for {
+				select {
+				case event := <-w.queue:
+					failing = w.deliver(event, failing)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// deliver posts one event, logging the first failure and the recovery
+// rather than every lost event. It returns whether the webhook is failing.
+func (w *Webhook) deliver(event any, failing bool) bool {
+	err := w.post(event)
+	if err != nil {
+		w.failed.Add(1)
+		if !failing {
+			w.logger.Warn("Webhook delivery failed", "url", w.url, "error", err)
+		}
+		return true
+	}
+
+	w.sent.Add(1)
+	if failing {
+		w.logger.Info("Webhook delivery recovered", "url", w.url)
+	}
+	return false
+}
+
+// post sends one event to the webhook URL
+func (w *Webhook) post(event any) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	response, err := w.client.Post(w.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer func() { _ = response.Body.Close() }()
+	_, _ = io.Copy(io.Discard, response.Body)
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("%s", response.Status)
+	}
+	return nil
+}
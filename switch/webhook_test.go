@@ -0,0 +1,108 @@
+package vswitch
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMACEvents(t *testing.T) {
+	var events []MACEvent
+	sw := NewVirtualSwitchWithConfig([]int{8080}, Config{
+		MACEventHandler: func(event MACEvent) { events = append(events, event) },
+	})
+
+	conn1 := NewConnection("conn1", &mockConnSwitch{addr: &mockAddrSwitch{network: "tcp", address: "127.0.0.1:9001"}})
+	conn2 := NewConnection("conn2", &mockConnSwitch{addr: &mockAddrSwitch{network: "tcp", address: "127.0.0.1:9002"}})
+	conn1.SetLabel("vm-a")
+
+	mac := net.HardwareAddr{0x52, 0x54, 0x00, 0x00, 0x00, 0x01}
+	sw.learnMAC(mac, conn1)
+	sw.learnMAC(mac, conn1) // Refreshing reports nothing
+	sw.learnMAC(mac, conn2)
+
+	// Age the entry out
+	entry, _ := sw.macTable.get(mac)
+	entry.LearnedAt = time.Now().Add(-10 * time.Minute)
+	sw.macTable.store(mac, entry)
+	sw.cleanupStaleMACs()
+
+	// Relearn it and flush it with its connection
+	sw.learnMAC(mac, conn1)
+	sw.flushMACs(conn1)
+
+	expected := []struct{ kind, conn, previous string }{
+		{MACLearned, "conn1", ""},
+		{MACMoved, "conn2", "conn1"},
+		{MACAged, "conn2", ""},
+		{MACLearned, "conn1", ""},
+		{MACFlushed, "conn1", ""},
+	}
+	if len(events) != len(expected) {
+		t.Fatalf("Expected %d events, got %d: %+v", len(expected), len(events), events)
+	}
+	for i, want := range expected {
+		got := events[i]
+		if got.Type != want.kind || got.Connection != want.conn || got.Previous != want.previous {
+			t.Errorf("Event %d: expected %s on %s from %q, got %+v", i, want.kind, want.conn, want.previous, got)
+		}
+		if got.VLAN != 8080 || got.MAC != mac.String() || got.Vendor != "QEMU/KVM" {
+			t.Errorf("Event %d: wrong VLAN, MAC or vendor: %+v", i, got)
+		}
+	}
+	if events[0].Label != "vm-a" || events[0].RemoteAddr != "127.0.0.1:9001" {
+		t.Errorf("Expected the connection's label and address, got %+v", events[0])
+	}
+}
+
+func TestWebhook(t *testing.T) {
+	var mutex sync.Mutex
+	var received []MACEvent
+	failures := 1
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("Unexpected request %s %s", r.Method, r.Header.Get("Content-Type"))
+		}
+		if failures > 0 {
+			failures--
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var event MACEvent
+		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+			t.Errorf("Failed to decode event: %v", err)
+		}
+		received = append(received, event)
+	}))
+	defer server.Close()
+
+	webhook := NewWebhook(server.URL, nil)
+	for _, kind := range []string{MACLearned, MACMoved, MACAged} {
+		if !webhook.Send(MACEvent{Type: kind, VLAN: 9999, MAC: "52:54:00:00:00:01"}) {
+			t.Errorf("Expected %s event to be queued", kind)
+		}
+	}
+	webhook.Close()
+
+	// The first POST fails and is not retried; the rest arrive in order
+	mutex.Lock()
+	defer mutex.Unlock()
+	if len(received) != 2 || received[0].Type != MACMoved || received[1].Type != MACAged {
+		t.Errorf("Expected the moved and aged events, got %+v", received)
+	}
+	if sent, dropped, failed := webhook.Stats(); sent != 2 || dropped != 0 || failed != 1 {
+		t.Errorf("Expected 2 sent, 0 dropped, 1 failed, got %d, %d, %d", sent, dropped, failed)
+	}
+
+	// Events sent after Close are dropped
+	if webhook.Send(MACEvent{Type: MACFlushed}) {
+		t.Error("Expected events sent after Close to be dropped")
+	}
+}
@@ -0,0 +1,79 @@
+package vswitch
+
+import (
+	"hash/fnv"
+	"time"
+)
+
+// workerQueueSize bounds the frames waiting for a forwarding worker; readers
+// block once it is full, pushing back on the sending VMs
+const workerQueueSize = 256
+
+// frameJob is a received frame waiting to be forwarded
+type frameJob struct {
+	frame *EthernetFrame
+	conn  *Connection
+}
+
+// startWorkers launches the forwarding workers of the VLAN
+func (vs *VirtualSwitch) startWorkers() {
+	vs.workers = make([]chan frameJob, vs.config.Workers)
+	vs.workerBeats = make([]heartbeat, vs.config.Workers)
+	for i := range vs.workers {
+		vs.workers[i] = make(chan frameJob, workerQueueSize)
+		vs.workerBeats[i].beat()
+		vs.wg.Add(1)
+		go vs.runWorker(vs.workers[i], &vs.workerBeats[i])
+	}
+}
+
+// workerFor returns the queue of the worker forwarding a connection's
+// frames, or nil if frames are forwarded by the connection's reader
+func (vs *VirtualSwitch) workerFor(conn *Connection) chan frameJob {
+	if len(vs.workers) == 0 {
+		return nil
+	}
+
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(conn.ID))
+	return vs.workers[h.Sum32()%uint32(len(vs.workers))]
+}
+
+// runWorker forwards queued frames until shutdown, beating its heartbeat
+// whenever it is free to do so
+func (vs *VirtualSwitch) runWorker(jobs <-chan frameJob, alive *heartbeat) {
+	defer vs.wg.Done()
+	defer alive.stop()
+
+	ticker := time.NewTicker(heartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-vs.shutdown:
+			return
+		case <-ticker.C:
+			alive.beat()
+		case job := <-jobs:
+			// Frames queued before their connection went away are stale
+			if !job.conn.IsClosed() {
+				vs.handleFrame(job.frame, job.conn)
+			}
+			job.frame.Release()
+		}
+	}
+}
+
+// handleFrame forwards a received frame, counting it as dropped on failure
+// and recording how long it took from reception until it was handed to the
+// egress queues otherwise
+func (vs *VirtualSwitch) handleFrame(frame *EthernetFrame, conn *Connection) {
+	if err := vs.processFrame(frame, conn); err != nil {
+		vs.logger.Debug("Dropped frame", "connection", conn.ID, "error", err)
+		vs.dropFrame(err)
+		return
+	}
+	if !frame.received.IsZero() {
+		vs.forwardLatency.observe(time.Since(frame.received))
+	}
+}
@@ -0,0 +1,73 @@
+package vswitch
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestWorkerFor(t *testing.T) {
+	sw := NewVirtualSwitchWithConfig([]int{8080}, Config{})
+	conn := NewConnection("conn1", &mockConnSwitch{addr: &mockAddrSwitch{network: "tcp", address: "127.0.0.1:9001"}})
+	if sw.workerFor(conn) != nil {
+		t.Errorf("Expected no worker without a pool")
+	}
+
+	sw = NewVirtualSwitchWithConfig([]int{8080}, Config{Workers: 4})
+	sw.startWorkers()
+	defer sw.Stop()
+
+	if sw.workerFor(conn) == nil || sw.workerFor(conn) != sw.workerFor(conn) {
+		t.Errorf("Expected a connection to stick to one worker")
+	}
+}
+
+func TestWorkerForwardsInOrder(t *testing.T) {
+	sw := NewVirtualSwitchWithConfig([]int{8080}, Config{Workers: 2})
+	sw.startWorkers()
+	defer sw.Stop()
+
+	local, remote := net.Pipe()
+	defer func() { _ = remote.Close() }()
+	dst := NewConnection("dst", local)
+	sw.connections.Store(dst.ID, dst)
+	src := NewConnection("src", &mockConnSwitch{addr: &mockAddrSwitch{network: "tcp", address: "127.0.0.1:9001"}})
+
+	dstMAC := net.HardwareAddr{0x02, 0x00, 0x00, 0x00, 0x00, 0x02}
+	srcMAC := net.HardwareAddr{0x02, 0x00, 0x00, 0x00, 0x00, 0x01}
+	sw.learnMAC(dstMAC, dst)
+
+	worker := sw.workerFor(src)
+	for i := 0; i < 10; i++ {
+		frame, _ := ParseEthernetFrame(buildEthernet(dstMAC, srcMAC, 0x0800, []byte{byte(i)}))
+		frame.pooled = false
+		worker <- frameJob{frame: frame, conn: src}
+	}
+
+	_ = remote.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 4+15)
+	for i := 0; i < 10; i++ {
+		if _, err := io.ReadFull(remote, buf); err != nil {
+			t.Fatalf("Failed to read frame %d: %v", i, err)
+		}
+		if buf[len(buf)-1] != byte(i) {
+			t.Fatalf("Expected frame %d, got %d", i, buf[len(buf)-1])
+		}
+	}
+}
+
+func TestHandleFrameRecordsLatency(t *testing.T) {
+	sw := NewVirtualSwitchWithConfig([]int{8080}, Config{})
+	src := NewConnection("src", &mockConnSwitch{addr: &mockAddrSwitch{network: "tcp", address: "127.0.0.1:9001"}})
+
+	frame := rawFrame(buildEthernet(BroadcastMAC, net.HardwareAddr{0x02, 0, 0, 0, 0, 1}, 0x0800, nil))
+	sw.handleFrame(frame, src)
+	frame.received = time.Now()
+	sw.handleFrame(frame, src)
+
+	// Frames the switch did not read itself are not measured
+	if latency := sw.forwardLatency.snapshot(); latency.Count != 1 {
+		t.Errorf("Expected 1 latency observation, got %d", latency.Count)
+	}
+}